@@ -0,0 +1,1312 @@
+// Package analyzer checks statements against the catalog before they run.
+// It resolves table and column names and infers expression types, so
+// mistakes are reported with their position instead of failing halfway
+// through a scan.
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+// Error is a problem found in a statement, located where it was found.
+// Suggestion is set when a misspelled name is close to an existing one.
+// Err is the backend error the problem amounts to, when there is one.
+type Error struct {
+	Loc        parser.Location
+	Msg        string
+	Suggestion string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("[%d,%d]: %s", e.Loc.Line, e.Loc.Column, e.Msg)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf("; did you mean %q?", e.Suggestion)
+	}
+
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func tableNotFound(catalog backend.Catalog, table *parser.Token) *Error {
+	err := errorf(table.Loc, "Table %q does not exist", table.Value)
+	err.Err = backend.ErrTableDoesNotExist
+	err.Suggestion = suggest(table.Value, catalog.Tables())
+	return err
+}
+
+func errorf(loc parser.Location, format string, args ...interface{}) *Error {
+	return &Error{Loc: loc, Msg: fmt.Sprintf(format, args...)}
+}
+
+// exprType is the inferred type of an expression. Known is false for NULL
+// and parameters, whose type is only decided when they are bound.
+type exprType struct {
+	Type  backend.ColumnType
+	Known bool
+}
+
+func known(t backend.ColumnType) exprType {
+	return exprType{Type: t, Known: true}
+}
+
+// scope is what expressions of a statement can refer to. Subqueries can
+// also refer to the columns of the queries enclosing them.
+type scope struct {
+	catalog backend.Catalog
+	table   string
+	columns []backend.Column
+	outer   *scope
+	// items is set while checking SELECT items, the only place aggregate
+	// functions may be called
+	items bool
+	// aggregating is set while checking the arguments of an aggregate call,
+	// which can't hold another one
+	aggregating bool
+}
+
+// lookup finds the column called name, searching enclosing scopes when this
+// one has no such column.
+func (sc *scope) lookup(name string) (backend.Column, bool) {
+	for s := sc; s != nil; s = s.outer {
+		for _, col := range s.columns {
+			if col.Name == name {
+				return col, true
+			}
+		}
+	}
+
+	return backend.Column{}, false
+}
+
+// Analyze checks stmt against catalog.
+func Analyze(catalog backend.Catalog, stmt *parser.Statement) error {
+	switch stmt.Type {
+	case parser.SelectType:
+		return analyzeSelect(catalog, stmt.SelectStatement)
+	case parser.InsertType:
+		return analyzeInsert(catalog, stmt.InsertStatement)
+	case parser.CreateTableType:
+		return analyzeCreateTable(catalog, stmt.CreateTableStatement)
+	case parser.DropTableType:
+		if _, ok := catalog.Columns(stmt.DropTableStatement.Name.Value); !ok {
+			return tableNotFound(catalog, &stmt.DropTableStatement.Name)
+		}
+	case parser.AlterTableType:
+		return analyzeAlterTable(catalog, stmt.AlterTableStatement)
+	case parser.CreateMaterializedViewType:
+		return analyzeSelect(catalog, stmt.CreateMaterializedViewStatement.Query)
+	case parser.RefreshType:
+		if _, ok := catalog.Columns(stmt.RefreshStatement.Name.Value); !ok {
+			return tableNotFound(catalog, &stmt.RefreshStatement.Name)
+		}
+	case parser.CreatePolicyType:
+		return analyzeCreatePolicy(catalog, stmt.CreatePolicyStatement)
+	case parser.CreateIndexType:
+		return analyzeCreateIndex(catalog, stmt.CreateIndexStatement)
+	case parser.DropPolicyType:
+		if _, ok := catalog.Columns(stmt.DropPolicyStatement.Table.Value); !ok {
+			return tableNotFound(catalog, &stmt.DropPolicyStatement.Table)
+		}
+	case parser.ExplainType:
+		return Analyze(catalog, stmt.ExplainStatement.Statement)
+	case parser.CheckTableType:
+		if _, ok := catalog.Columns(stmt.CheckTableStatement.Table.Value); !ok {
+			return tableNotFound(catalog, &stmt.CheckTableStatement.Table)
+		}
+	case parser.FlashbackType:
+		return analyzeFlashback(catalog, stmt.FlashbackStatement)
+	case parser.AnalyzeType:
+		if table := stmt.AnalyzeStatement.Table; table != nil {
+			if _, ok := catalog.Columns(table.Value); !ok {
+				return tableNotFound(catalog, table)
+			}
+		}
+	case parser.CreateStatisticsType:
+		return analyzeCreateStatistics(catalog, stmt.CreateStatisticsStatement)
+	case parser.CommentType:
+		return analyzeComment(catalog, stmt.CommentStatement)
+	case parser.ShowType:
+		if table := stmt.ShowStatement.Table; table != nil {
+			if _, ok := catalog.Columns(table.Value); !ok {
+				return tableNotFound(catalog, table)
+			}
+		}
+	}
+
+	return nil
+}
+
+func analyzeSelect(catalog backend.Catalog, slct *parser.SelectStatement) error {
+	return analyzeQuery(catalog, slct, nil)
+}
+
+// analyzeQuery checks slct, which is a subquery when outer is not nil.
+func analyzeQuery(catalog backend.Catalog, slct *parser.SelectStatement, outer *scope) error {
+	if err := analyzeHints(catalog, slct.Hints); err != nil {
+		return err
+	}
+
+	sc := scope{catalog: catalog, outer: outer}
+	if slct.From != nil {
+		columns, ok := catalog.Columns(slct.From.Value)
+		if !ok {
+			return tableNotFound(catalog, slct.From)
+		}
+
+		sc.table, sc.columns = slct.From.Value, columns
+
+		// The time is known before any row is read
+		if slct.AsOf != nil {
+			t, err := (&scope{catalog: catalog, outer: outer}).infer(slct.AsOf)
+			if err != nil {
+				return err
+			}
+
+			if t.Known && t.Type != backend.TimestampType && t.Type != backend.TextType {
+				return errorf(slct.AsOf.Loc, "AS OF TIMESTAMP must be timestamp, not %s", t.Type)
+			}
+		}
+	} else if slct.Function != nil {
+		col, err := analyzeFunction(catalog, slct.Function)
+		if err != nil {
+			return err
+		}
+
+		sc.columns = []backend.Column{col}
+	}
+
+	if slct.ConnectBy != nil {
+		if err := sc.analyzeConnectBy(slct.ConnectBy, outer); err != nil {
+			return err
+		}
+	}
+
+	sc.items = true
+	var grouped *parser.Expression
+	for _, item := range slct.Item {
+		if item.Asterisk {
+			continue
+		}
+
+		if grouped == nil && aggregated(item.Exp) {
+			grouped = item.Exp
+		}
+
+		// Set-returning functions are only allowed as a whole item
+		if item.Exp.Type == parser.CallType {
+			if _, err := sc.inferCall(item.Exp.Call, true); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := sc.infer(item.Exp); err != nil {
+			return err
+		}
+	}
+	sc.items = false
+
+	// Without GROUP BY an aggregate query returns one row, so columns can
+	// only be used inside the aggregates
+	if grouped != nil {
+		for _, item := range slct.Item {
+			if item.Asterisk {
+				return errorf(grouped.Loc, "* can't be selected together with aggregate functions")
+			}
+
+			if col := sc.ungrouped(item.Exp); col != nil {
+				return errorf(col.Loc, "Column %q must be used in an aggregate function", col.Column.Value)
+			}
+		}
+	}
+
+	if slct.Where != nil {
+		t, err := sc.infer(slct.Where)
+		if err != nil {
+			return err
+		}
+
+		if t.Known && t.Type != backend.BoolType {
+			return errorf(slct.Where.Loc, "WHERE must be bool, not %s", t.Type)
+		}
+	}
+
+	return sc.analyzeOrder(slct, outer)
+}
+
+// analyzeOrder checks the ORDER BY, LIMIT and OFFSET of slct, whose rows
+// sc is the scope of. LIMIT and OFFSET are known before any row is read.
+func (sc *scope) analyzeOrder(slct *parser.SelectStatement, outer *scope) error {
+	items := 0
+	names := map[string]bool{}
+	for _, item := range slct.Item {
+		if item.Asterisk {
+			items += len(sc.columns)
+			continue
+		}
+
+		items++
+		if item.As != nil {
+			names[item.As.Value] = true
+		}
+	}
+
+	for _, order := range slct.OrderBy {
+		exp := order.Exp
+		if exp.Type == parser.LiteralType && exp.Literal.Type == parser.Int64Value {
+			if n := exp.Literal.Int64; n < 1 || n > int64(items) {
+				return errorf(exp.Loc, "ORDER BY position %d is not in select list", n)
+			}
+			continue
+		}
+
+		if exp.Type == parser.ColumnRefType && names[exp.Column.Value] {
+			continue
+		}
+
+		if _, err := sc.infer(exp); err != nil {
+			return err
+		}
+	}
+
+	for _, clause := range []struct {
+		name string
+		exp  *parser.Expression
+	}{{"LIMIT", slct.Limit}, {"OFFSET", slct.Offset}} {
+		if clause.exp == nil {
+			continue
+		}
+
+		t, err := (&scope{catalog: sc.catalog, outer: outer}).infer(clause.exp)
+		if err != nil {
+			return err
+		}
+
+		if t.Known && t.Type != backend.IntType {
+			return errorf(clause.exp.Loc, "%s must be int, not %s", clause.name, t.Type)
+		}
+	}
+
+	return nil
+}
+
+// analyzeFlashback checks that the table of a flashback exists and that it
+// is taken back to a time.
+func analyzeFlashback(catalog backend.Catalog, flashback *parser.FlashbackStatement) error {
+	if _, ok := catalog.Columns(flashback.Table.Value); !ok {
+		return tableNotFound(catalog, &flashback.Table)
+	}
+
+	if flashback.Timestamp == nil {
+		return nil
+	}
+
+	t, err := (&scope{catalog: catalog}).infer(flashback.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	if t.Known && t.Type != backend.TimestampType && t.Type != backend.TextType {
+		return errorf(flashback.Timestamp.Loc, "TO TIMESTAMP must be timestamp, not %s", t.Type)
+	}
+
+	return nil
+}
+
+// analyzeCreatePolicy checks that the expression of a policy is a bool over
+// the columns of its table.
+func analyzeCreatePolicy(catalog backend.Catalog, crt *parser.CreatePolicyStatement) error {
+	columns, ok := catalog.Columns(crt.Table.Value)
+	if !ok {
+		return tableNotFound(catalog, &crt.Table)
+	}
+
+	sc := scope{catalog: catalog, table: crt.Table.Value, columns: columns}
+	t, err := sc.infer(&crt.Using)
+	if err != nil {
+		return err
+	}
+
+	if t.Known && t.Type != backend.BoolType {
+		return errorf(crt.Using.Loc, "USING must be bool, not %s", t.Type)
+	}
+
+	return nil
+}
+
+// analyzeCreateIndex checks that the indexed column exists, or that the
+// indexed expression is valid over the columns of the table, and that the
+// predicate of a partial index is a bool.
+func analyzeCreateIndex(catalog backend.Catalog, crt *parser.CreateIndexStatement) error {
+	columns, ok := catalog.Columns(crt.Table.Value)
+	if !ok {
+		return tableNotFound(catalog, &crt.Table)
+	}
+
+	sc := scope{catalog: catalog, table: crt.Table.Value, columns: columns}
+	if crt.Where != nil {
+		t, err := sc.infer(crt.Where)
+		if err != nil {
+			return err
+		}
+
+		if t.Known && t.Type != backend.BoolType {
+			return errorf(crt.Where.Loc, "WHERE must be bool, not %s", t.Type)
+		}
+	}
+
+	if crt.Expression != nil {
+		_, err := sc.infer(crt.Expression)
+		return err
+	}
+
+	_, err := sc.infer(&parser.Expression{Column: &crt.Column, Type: parser.ColumnRefType, Loc: crt.Column.Loc})
+	return err
+}
+
+// analyzeCreateStatistics checks that the columns exist and that there are
+// at least two of them, statistics of a single column are what ANALYZE
+// collects anyway.
+func analyzeCreateStatistics(catalog backend.Catalog, crt *parser.CreateStatisticsStatement) error {
+	columns, ok := catalog.Columns(crt.Table.Value)
+	if !ok {
+		return tableNotFound(catalog, &crt.Table)
+	}
+
+	sc := scope{catalog: catalog, table: crt.Table.Value, columns: columns}
+	seen := map[string]bool{}
+	for i := range crt.Columns {
+		col := &crt.Columns[i]
+		if _, err := sc.infer(&parser.Expression{Column: col, Type: parser.ColumnRefType, Loc: col.Loc}); err != nil {
+			return err
+		}
+
+		if seen[col.Value] {
+			return errorf(col.Loc, "Column %s is listed twice", col.Value)
+		}
+		seen[col.Value] = true
+	}
+
+	if len(crt.Columns) < 2 {
+		return errorf(crt.Name.Loc, "Statistics %s need at least two columns", crt.Name.Value)
+	}
+
+	return nil
+}
+
+// analyzeFunction checks the call of a set-returning function a query reads
+// in place of a table and returns the single column it has. The arguments
+// are evaluated once for the whole query, so they can't refer to columns.
+func analyzeFunction(catalog backend.Catalog, call *parser.Expression) (backend.Column, error) {
+	if f, ok := functions.Lookup(call.Call.Name.Value); ok && !f.Set {
+		return backend.Column{}, errorf(call.Loc,
+			"Function %s does not return a set, it can't be used in FROM", f.Name)
+	}
+
+	sc := scope{catalog: catalog}
+	t, err := sc.inferCall(call.Call, true)
+	if err != nil {
+		return backend.Column{}, err
+	}
+
+	// Like the backend, a column of unknown type is text
+	col := backend.Column{Name: call.Call.Name.Value, Type: backend.TextType}
+	if t.Known {
+		col.Type = t.Type
+	}
+
+	return col, nil
+}
+
+// analyzeConnectBy checks the CONNECT BY clause of a query and adds the
+// pseudo columns it gives the rows to sc.
+func (sc *scope) analyzeConnectBy(connect *parser.ConnectBy, outer *scope) error {
+	if outer != nil {
+		return errorf(connect.Prior.Loc, "CONNECT BY can't be used in subqueries")
+	}
+
+	prior, ok := sc.lookup(connect.Prior.Value)
+	if !ok {
+		return errorf(connect.Prior.Loc, "Column %q does not exist in table %q", connect.Prior.Value, sc.table)
+	}
+
+	child, ok := sc.lookup(connect.Child.Value)
+	if !ok {
+		return errorf(connect.Child.Loc, "Column %q does not exist in table %q", connect.Child.Value, sc.table)
+	}
+
+	if _, ok := types.Binary("=", prior.Type, child.Type); !ok {
+		return errorf(connect.Prior.Loc, "Can't compare %s with %s in CONNECT BY", prior.Type, child.Type)
+	}
+
+	if connect.Start != nil {
+		t, err := sc.infer(connect.Start)
+		if err != nil {
+			return err
+		}
+
+		if t.Known && t.Type != backend.BoolType {
+			return errorf(connect.Start.Loc, "START WITH must be bool, not %s", t.Type)
+		}
+	}
+
+	// The walk adds its pseudo columns to the rows, unless the table has
+	// columns of the same names
+	for _, col := range backend.ConnectByColumns {
+		if _, ok := sc.lookup(col.Name); !ok {
+			sc.columns = append(sc.columns, col)
+		}
+	}
+
+	return nil
+}
+
+// analyzeHints checks that hints are ones the planner knows, naming tables
+// that exist, and for index hints indexes of the table they name first.
+func analyzeHints(catalog backend.Catalog, hints []parser.Hint) error {
+	for _, hint := range hints {
+		known := false
+		for _, name := range backend.Hints {
+			known = known || hint.Name.Value == name
+		}
+
+		if !known {
+			err := errorf(hint.Name.Loc, "Unknown hint %q", hint.Name.Value)
+			err.Suggestion = suggest(hint.Name.Value, backend.Hints)
+			return err
+		}
+
+		if len(hint.Args) == 0 {
+			return errorf(hint.Name.Loc, "Hint %s needs the tables it applies to",
+				strings.ToUpper(hint.Name.Value))
+		}
+
+		indexHint := false
+		for _, name := range backend.IndexHints {
+			indexHint = indexHint || hint.Name.Value == name
+		}
+		if indexHint {
+			if err := analyzeIndexHint(catalog, hint); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for i := range hint.Args {
+			if _, ok := catalog.Columns(hint.Args[i].Value); !ok {
+				return tableNotFound(catalog, &hint.Args[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+// analyzeIndexHint checks that the arguments of an index hint after the
+// table it names first are indexes of the table.
+func analyzeIndexHint(catalog backend.Catalog, hint parser.Hint) error {
+	table := &hint.Args[0]
+	if _, ok := catalog.Columns(table.Value); !ok {
+		return tableNotFound(catalog, table)
+	}
+
+	indexes, ok := catalog.(backend.IndexCatalog)
+	if !ok {
+		return nil
+	}
+
+	names := indexes.Indexes(table.Value)
+	for _, arg := range hint.Args[1:] {
+		found := false
+		for _, name := range names {
+			found = found || arg.Value == name
+		}
+
+		if !found {
+			err := errorf(arg.Loc, "Index %q does not exist on table %q", arg.Value, table.Value)
+			err.Err = backend.ErrIndexDoesNotExist
+			err.Suggestion = suggest(arg.Value, names)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func analyzeInsert(catalog backend.Catalog, inst *parser.InsertStatement) error {
+	columns, ok := catalog.Columns(inst.Table.Value)
+	if !ok {
+		return tableNotFound(catalog, &inst.Table)
+	}
+
+	values := []*parser.Expression{}
+	if inst.Values != nil {
+		values = *inst.Values
+	}
+
+	if len(values) != len(columns) {
+		return errorf(inst.Table.Loc, "Table %q has %d columns but %d values were given",
+			inst.Table.Value, len(columns), len(values))
+	}
+
+	// Values can't refer to columns, they are evaluated before the row exists
+	sc := scope{catalog: catalog}
+	for i, value := range values {
+		if value.Type == parser.DefaultType {
+			continue
+		}
+
+		if columns[i].Identity == "always" && inst.Overriding == nil {
+			return errorf(value.Loc, "Column %q is generated always, use DEFAULT or OVERRIDING SYSTEM VALUE",
+				columns[i].Name)
+		}
+
+		t, err := sc.infer(value)
+		if err != nil {
+			return err
+		}
+
+		if t.Known && !types.Assignable(t.Type, columns[i].Type) {
+			return errorf(value.Loc, "Column %q is %s but the value is %s",
+				columns[i].Name, columns[i].Type, t.Type)
+		}
+	}
+
+	return nil
+}
+
+func analyzeCreateTable(catalog backend.Catalog, crt *parser.CreateTableStatement) error {
+	if _, ok := catalog.Columns(crt.Name.Value); ok {
+		return errorf(crt.Name.Loc, "Table %q already exists", crt.Name.Value)
+	}
+
+	if crt.Cols == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	columns := []backend.Column{}
+	for _, col := range *crt.Cols {
+		if seen[col.Name.Value] {
+			return errorf(col.Name.Loc, "Column %q specified more than once", col.Name.Value)
+		}
+		seen[col.Name.Value] = true
+
+		if err := analyzeColumnType(col); err != nil {
+			return err
+		}
+
+		t, _ := types.Parse(col.Datatype.Value)
+		columns = append(columns, backend.Column{Name: col.Name.Value, Type: t})
+	}
+
+	// A table can reference itself, whose columns aren't in the catalog yet
+	for i, col := range *crt.Cols {
+		if col.References == nil {
+			continue
+		}
+
+		var self []backend.Column
+		if col.References.Table.Value == crt.Name.Value {
+			self = columns
+		}
+		if err := analyzeReferences(catalog, self, columns[i], col.References); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// analyzeReferences checks that the column fk references exists and has the
+// type of col, the column made a foreign key. self are the columns of the
+// table being created when it references itself.
+func analyzeReferences(catalog backend.Catalog, self []backend.Column, col backend.Column, fk *parser.ForeignKey) error {
+	columns := self
+	if columns == nil {
+		var ok bool
+		if columns, ok = catalog.Columns(fk.Table.Value); !ok {
+			return tableNotFound(catalog, &fk.Table)
+		}
+	}
+
+	for _, referenced := range columns {
+		if referenced.Name != fk.Column.Value {
+			continue
+		}
+
+		if referenced.Type != col.Type {
+			return errorf(fk.Column.Loc, "Foreign key %q is %s but the column it references is %s",
+				col.Name, col.Type, referenced.Type)
+		}
+		return nil
+	}
+
+	return errorf(fk.Column.Loc, "Column %q does not exist in table %q", fk.Column.Value, fk.Table.Value)
+}
+
+// analyzeColumnType checks that the type and collation of col exist and go
+// together, and that identity columns are ints.
+func analyzeColumnType(col *parser.ColumnDefinition) error {
+	t, ok := types.Parse(col.Datatype.Value)
+	if !ok {
+		return errorf(col.Datatype.Loc, "Type %q does not exist", col.Datatype.Value)
+	}
+
+	if col.Collate != nil {
+		if t != backend.TextType {
+			return errorf(col.Collate.Loc, "Collations only apply to text, not %s", t)
+		}
+
+		if _, ok := types.LookupCollation(col.Collate.Value); !ok {
+			return errorf(col.Collate.Loc, "Collation %q does not exist", col.Collate.Value)
+		}
+	}
+
+	if col.Identity != nil && t != backend.IntType {
+		return errorf(col.Datatype.Loc, "Identity column %q must be int, not %s", col.Name.Value, t)
+	}
+
+	return nil
+}
+
+func analyzeComment(catalog backend.Catalog, cmt *parser.CommentStatement) error {
+	columns, ok := catalog.Columns(cmt.Table.Value)
+	if !ok {
+		return tableNotFound(catalog, &cmt.Table)
+	}
+
+	if cmt.Column == nil {
+		return nil
+	}
+
+	sc := scope{catalog: catalog, table: cmt.Table.Value, columns: columns}
+	_, err := sc.infer(&parser.Expression{Column: cmt.Column, Type: parser.ColumnRefType, Loc: cmt.Column.Loc})
+	return err
+}
+
+func analyzeAlterTable(catalog backend.Catalog, alter *parser.AlterTableStatement) error {
+	columns, ok := catalog.Columns(alter.Table.Value)
+	if !ok {
+		return tableNotFound(catalog, &alter.Table)
+	}
+
+	sc := scope{catalog: catalog, table: alter.Table.Value, columns: columns}
+	column := func(name *parser.Token) (backend.Column, error) {
+		if col, ok := sc.lookup(name.Value); ok {
+			return col, nil
+		}
+
+		_, err := sc.infer(&parser.Expression{Column: name, Type: parser.ColumnRefType, Loc: name.Loc})
+		return backend.Column{}, err
+	}
+
+	switch {
+	case alter.Add != nil:
+		if _, ok := sc.lookup(alter.Add.Name.Value); ok {
+			return errorf(alter.Add.Name.Loc, "Column %q already exists in table %q",
+				alter.Add.Name.Value, alter.Table.Value)
+		}
+
+		if err := analyzeColumnType(alter.Add); err != nil {
+			return err
+		}
+
+		if alter.Add.References != nil {
+			t, _ := types.Parse(alter.Add.Datatype.Value)
+			col := backend.Column{Name: alter.Add.Name.Value, Type: t}
+			var self []backend.Column
+			if alter.Add.References.Table.Value == alter.Table.Value {
+				self = append(columns[:len(columns):len(columns)], col)
+			}
+			return analyzeReferences(catalog, self, col, alter.Add.References)
+		}
+	case alter.Constraint != nil:
+		col, err := column(&alter.Constraint.Column)
+		if err != nil || alter.Constraint.References == nil {
+			return err
+		}
+
+		return analyzeReferences(catalog, nil, col, alter.Constraint.References)
+	case alter.Drop != nil:
+		_, err := column(alter.Drop)
+		return err
+	case alter.Mask != nil:
+		_, err := column(&alter.Mask.Column)
+		return err
+	case alter.Alter != nil:
+		col, err := column(&alter.Alter.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := analyzeColumnType(alter.Alter); err != nil {
+			return err
+		}
+
+		if to, _ := types.Parse(alter.Alter.Datatype.Value); col.Identity != "" && to != backend.IntType {
+			return errorf(alter.Alter.Datatype.Loc, "Identity column %q must be int, not %s", col.Name, to)
+		}
+
+		to, _ := types.Parse(alter.Alter.Datatype.Value)
+		if !types.Castable(col.Type, to) {
+			return errorf(alter.Alter.Datatype.Loc, "Column %q can't be changed from %s to %s",
+				col.Name, col.Type, to)
+		}
+	case alter.Rename != nil && alter.Rename.Column != nil:
+		if _, err := column(alter.Rename.Column); err != nil {
+			return err
+		}
+
+		if _, ok := sc.lookup(alter.Rename.To.Value); ok {
+			return errorf(alter.Rename.To.Loc, "Column %q already exists in table %q",
+				alter.Rename.To.Value, alter.Table.Value)
+		}
+	case alter.Rename != nil:
+		if _, ok := catalog.Columns(alter.Rename.To.Value); ok {
+			return errorf(alter.Rename.To.Loc, "Table %q already exists", alter.Rename.To.Value)
+		}
+	}
+
+	return nil
+}
+
+func (sc *scope) infer(exp *parser.Expression) (exprType, error) {
+	switch exp.Type {
+	case parser.LiteralType:
+		switch exp.Literal.Type {
+		case parser.Int64Value:
+			return known(backend.IntType), nil
+		case parser.Float64Value:
+			return known(backend.FloatType), nil
+		case parser.StringValue:
+			return known(backend.TextType), nil
+		case parser.BoolValue:
+			return known(backend.BoolType), nil
+		}
+
+		return exprType{}, nil
+	case parser.ParamType:
+		return exprType{}, nil
+	case parser.ColumnRefType:
+		if col, ok := sc.lookup(exp.Column.Value); ok {
+			return known(col.Type), nil
+		}
+		if col, ok, err := sc.qualified(exp); err != nil {
+			return exprType{}, err
+		} else if ok {
+			return known(col.Type), nil
+		}
+
+		var err *Error
+		if sc.table == "" {
+			err = errorf(exp.Loc, "Column %q does not exist", exp.Column.Value)
+		} else {
+			err = errorf(exp.Loc, "Column %q does not exist in table %q",
+				exp.Column.Value, sc.table)
+		}
+		err.Err = backend.ErrColumnDoesNotExist
+
+		names := make([]string, len(sc.columns))
+		for i, col := range sc.columns {
+			names[i] = col.Name
+		}
+		err.Suggestion = suggest(exp.Column.Value, names)
+
+		return exprType{}, err
+	case parser.BinaryType:
+		return sc.inferBinary(exp.Binary)
+	case parser.CastType:
+		return sc.inferCast(exp.Cast)
+	case parser.CallType:
+		return sc.inferCall(exp.Call, false)
+	case parser.ArrayType:
+		return sc.inferArray(exp)
+	case parser.IndexType:
+		return sc.inferIndex(exp.Index)
+	case parser.InType:
+		return sc.inferIn(exp.In)
+	case parser.RowType:
+		return exprType{}, errorf(exp.Loc, "Row values can only be compared or tested with IN")
+	case parser.ExistsType:
+		if err := analyzeQuery(sc.catalog, exp.Exists.Query, sc); err != nil {
+			return exprType{}, err
+		}
+		return known(backend.BoolType), nil
+	case parser.SubqueryType:
+		return sc.inferSubquery(exp)
+	case parser.NotType:
+		t, err := sc.infer(exp.Not)
+		if err != nil {
+			return exprType{}, err
+		}
+
+		if t.Known && t.Type != backend.BoolType {
+			return exprType{}, errorf(exp.Loc, "Operator NOT does not accept %s", t.Type)
+		}
+		return known(backend.BoolType), nil
+	}
+
+	return exprType{}, errorf(exp.Loc, "Unsupported expression")
+}
+
+// qualified resolves exp if it is written table.column, naming the column
+// of the nearest query reading table. Columns are found by their names
+// alone when statements run, so exp is rewritten to name the column
+// without the table, which only finds the same column when no query nested
+// closer than that one has a column called the same. It returns false if
+// no query reads table.
+func (sc *scope) qualified(exp *parser.Expression) (backend.Column, bool, error) {
+	name := exp.Column.Value
+	dot := strings.LastIndexByte(name, '.')
+	if dot <= 0 || dot == len(name)-1 {
+		return backend.Column{}, false, nil
+	}
+	table, column := name[:dot], name[dot+1:]
+
+	for s := sc; s != nil; s = s.outer {
+		if s.table != table {
+			continue
+		}
+
+		names := make([]string, len(s.columns))
+		for i, col := range s.columns {
+			names[i] = col.Name
+		}
+
+		var found *backend.Column
+		for i := range s.columns {
+			if s.columns[i].Name == column {
+				found = &s.columns[i]
+			}
+		}
+		if found == nil {
+			err := errorf(exp.Loc, "Column %q does not exist in table %q", column, table)
+			err.Err = backend.ErrColumnDoesNotExist
+			err.Suggestion = suggest(column, names)
+			return backend.Column{}, false, err
+		}
+
+		for in := sc; in != s; in = in.outer {
+			for _, col := range in.columns {
+				if col.Name == column {
+					return backend.Column{}, false, errorf(exp.Loc,
+						"Column %q of table %q can't be referred to inside a query of table %q, which has a column of the same name",
+						column, table, in.table)
+				}
+			}
+		}
+
+		token := *exp.Column
+		token.Value = column
+		exp.Column = &token
+		return *found, true, nil
+	}
+
+	return backend.Column{}, false, nil
+}
+
+func (sc *scope) inferBinary(b *parser.BinaryExpression) (exprType, error) {
+	if b.A.Type == parser.RowType || b.B.Type == parser.RowType {
+		return sc.inferRowComparison(b)
+	}
+
+	l, err := sc.infer(&b.A)
+	if err != nil {
+		return exprType{}, err
+	}
+
+	r, err := sc.infer(&b.B)
+	if err != nil {
+		return exprType{}, err
+	}
+
+	if err := sc.checkCollations(b); err != nil {
+		return exprType{}, err
+	}
+
+	mismatch := func() error {
+		return errorf(b.Op.Loc, "Operator %s does not accept %s and %s",
+			b.Op.Value, typeName(l), typeName(r))
+	}
+
+	// An operand of unknown type is taken to have the type of the other one
+	lt, rt := l.Type, r.Type
+	switch {
+	case l.Known && r.Known:
+	case l.Known:
+		rt = lt
+	case r.Known:
+		lt = rt
+	default:
+		switch b.Op.Value {
+		case "+", "-", "*", "/":
+			return exprType{}, nil
+		case "||":
+			return known(backend.TextType), nil
+		}
+
+		if _, ok := types.Binary(b.Op.Value, backend.BoolType, backend.BoolType); !ok {
+			if _, ok := types.Binary(b.Op.Value, backend.IntType, backend.IntType); !ok {
+				return exprType{}, errorf(b.Op.Loc, "Unknown operator %s", b.Op.Value)
+			}
+		}
+		return known(backend.BoolType), nil
+	}
+
+	t, ok := types.Binary(b.Op.Value, lt, rt)
+	if !ok {
+		return exprType{}, mismatch()
+	}
+
+	return known(t), nil
+}
+
+// inferRowComparison checks a comparison between row values, each pair of
+// elements must be comparable on its own.
+func (sc *scope) inferRowComparison(b *parser.BinaryExpression) (exprType, error) {
+	switch b.Op.Value {
+	case "=", "<>", "!=", "<", "<=", ">", ">=":
+	default:
+		return exprType{}, errorf(b.Op.Loc, "Operator %s does not accept row values", b.Op.Value)
+	}
+
+	if b.A.Type != parser.RowType || b.B.Type != parser.RowType {
+		return exprType{}, errorf(b.Op.Loc, "Row values can only be compared with row values")
+	}
+
+	if len(b.A.Row) != len(b.B.Row) {
+		return exprType{}, errorf(b.Op.Loc, "Cannot compare rows of %d and %d values",
+			len(b.A.Row), len(b.B.Row))
+	}
+
+	for i := range b.A.Row {
+		if _, err := sc.inferBinary(&parser.BinaryExpression{
+			A:  b.A.Row[i],
+			B:  b.B.Row[i],
+			Op: b.Op,
+		}); err != nil {
+			return exprType{}, err
+		}
+	}
+
+	return known(backend.BoolType), nil
+}
+
+// inferIn checks that the tested expression can be compared with each one
+// of the list.
+func (sc *scope) inferIn(in *parser.InExpression) (exprType, error) {
+	for i := range in.List {
+		if _, err := sc.inferBinary(&parser.BinaryExpression{
+			A:  in.Exp,
+			B:  in.List[i],
+			Op: parser.Token{Type: parser.SymbolType, Value: "=", Loc: in.List[i].Loc},
+		}); err != nil {
+			return exprType{}, err
+		}
+	}
+
+	return known(backend.BoolType), nil
+}
+
+// checkCollations rejects comparing columns with different collations, since
+// it is ambiguous which one applies.
+func (sc *scope) checkCollations(b *parser.BinaryExpression) error {
+	if b.A.Type != parser.ColumnRefType || b.B.Type != parser.ColumnRefType {
+		return nil
+	}
+
+	l, _ := sc.lookup(b.A.Column.Value)
+	r, _ := sc.lookup(b.B.Column.Value)
+
+	if l.Collation != r.Collation {
+		return errorf(b.Op.Loc, "Columns %q and %q have different collations",
+			b.A.Column.Value, b.B.Column.Value)
+	}
+
+	return nil
+}
+
+func (sc *scope) inferCast(cast *parser.CastExpression) (exprType, error) {
+	from, err := sc.infer(&cast.Exp)
+	if err != nil {
+		return exprType{}, err
+	}
+
+	to, ok := types.Parse(cast.Type.Value)
+	if !ok {
+		return exprType{}, errorf(cast.Type.Loc, "Type %q does not exist", cast.Type.Value)
+	}
+
+	if from.Known && !types.Castable(from.Type, to) {
+		return exprType{}, errorf(cast.Exp.Loc, "Cannot cast %s to %s", from.Type, to)
+	}
+
+	return known(to), nil
+}
+
+func (sc *scope) inferArray(exp *parser.Expression) (exprType, error) {
+	elem := exprType{}
+	for i := range exp.Array {
+		t, err := sc.infer(&exp.Array[i])
+		if err != nil {
+			return exprType{}, err
+		}
+
+		switch {
+		case !t.Known:
+		case !elem.Known:
+			elem = t
+		default:
+			c, ok := types.Common(elem.Type, t.Type)
+			if !ok {
+				return exprType{}, errorf(exp.Array[i].Loc,
+					"ARRAY elements must have the same type, got %s and %s", elem.Type, t.Type)
+			}
+			elem.Type = c
+		}
+	}
+
+	if !elem.Known {
+		return known(types.ArrayOf(backend.TextType)), nil
+	}
+
+	if elem.Type.IsArray() {
+		return exprType{}, errorf(exp.Loc, "Nested arrays are not supported")
+	}
+
+	return known(types.ArrayOf(elem.Type)), nil
+}
+
+func (sc *scope) inferIndex(ix *parser.IndexExpression) (exprType, error) {
+	t, err := sc.infer(&ix.Exp)
+	if err != nil {
+		return exprType{}, err
+	}
+
+	i, err := sc.infer(&ix.Index)
+	if err != nil {
+		return exprType{}, err
+	}
+
+	if i.Known && i.Type != backend.IntType {
+		return exprType{}, errorf(ix.Index.Loc, "Array index must be int, not %s", i.Type)
+	}
+
+	if !t.Known {
+		return exprType{}, nil
+	}
+
+	if !t.Type.IsArray() {
+		return exprType{}, errorf(ix.Exp.Loc, "Cannot index %s, it is not an array", t.Type)
+	}
+
+	return known(t.Type.Elem()), nil
+}
+
+// inferCall infers the type of a function call. top is set when the call is
+// a whole SELECT item, the only place set-returning functions may be used.
+func (sc *scope) inferCall(call *parser.CallExpression, top bool) (exprType, error) {
+	f, ok := functions.Lookup(call.Name.Value)
+	if !ok {
+		err := errorf(call.Name.Loc, "Function %q does not exist", call.Name.Value)
+		err.Suggestion = suggest(call.Name.Value, functions.Names())
+		return exprType{}, err
+	}
+
+	if f.Set && !top {
+		return exprType{}, errorf(call.Name.Loc,
+			"Set-returning function %s can only be used as a SELECT item", f.Name)
+	}
+
+	if f.Aggregate != nil {
+		if !sc.items {
+			return exprType{}, errorf(call.Name.Loc,
+				"Aggregate function %s can only be used in SELECT items", f.Name)
+		}
+		if sc.aggregating {
+			return exprType{}, errorf(call.Name.Loc,
+				"Aggregate function calls can't be nested")
+		}
+
+		sc.aggregating = true
+		defer func() { sc.aggregating = false }()
+	}
+
+	if call.Star {
+		if !f.Star {
+			return exprType{}, errorf(call.Name.Loc, "Function %s can't be called with *", f.Name)
+		}
+
+		t, _, err := f.Type(nil)
+		if err != nil {
+			return exprType{}, errorf(call.Name.Loc, "%s: %s", f.Name, err)
+		}
+		return known(t), nil
+	}
+
+	if len(call.Args) < f.MinArgs || (f.MaxArgs >= 0 && len(call.Args) > f.MaxArgs) {
+		return exprType{}, errorf(call.Name.Loc, "Function %s takes %s, got %d",
+			f.Name, arity(f), len(call.Args))
+	}
+
+	args := make([]functions.Arg, len(call.Args))
+	for i := range call.Args {
+		t, err := sc.infer(&call.Args[i])
+		if err != nil {
+			return exprType{}, err
+		}
+		args[i] = functions.Arg{Type: t.Type, Known: t.Known}
+	}
+
+	t, ok, err := f.Type(args)
+	if err != nil {
+		return exprType{}, errorf(call.Name.Loc, "%s: %s", f.Name, err)
+	}
+	if !ok {
+		return exprType{}, nil
+	}
+
+	return known(t), nil
+}
+
+// inferSubquery infers the type of a scalar subquery, which is the type of
+// its single item.
+func (sc *scope) inferSubquery(exp *parser.Expression) (exprType, error) {
+	q := exp.Subquery
+	if len(q.Item) != 1 || q.Item[0].Asterisk {
+		return exprType{}, errorf(exp.Loc, "Subquery must return exactly one column")
+	}
+
+	if err := analyzeQuery(sc.catalog, q, sc); err != nil {
+		return exprType{}, err
+	}
+
+	inner := scope{catalog: sc.catalog, outer: sc, items: true}
+	if q.From != nil {
+		inner.table = q.From.Value
+		inner.columns, _ = sc.catalog.Columns(q.From.Value)
+	} else if q.Function != nil {
+		col, _ := analyzeFunction(sc.catalog, q.Function)
+		inner.columns = []backend.Column{col}
+	}
+
+	item := q.Item[0].Exp
+	if item.Type == parser.CallType {
+		return inner.inferCall(item.Call, true)
+	}
+
+	return inner.infer(item)
+}
+
+// aggregated reports whether exp calls an aggregate function, not counting
+// the calls in its subqueries.
+func aggregated(exp *parser.Expression) bool {
+	found := false
+	walk(exp, func(e *parser.Expression) bool {
+		if e.Type == parser.CallType {
+			if f, ok := functions.Lookup(e.Call.Name.Value); ok && f.Aggregate != nil {
+				found = true
+			}
+		}
+		return !found
+	})
+
+	return found
+}
+
+// ungrouped returns a column of sc used in exp outside of an aggregate call,
+// or nil if there is none.
+func (sc *scope) ungrouped(exp *parser.Expression) *parser.Expression {
+	var col *parser.Expression
+	walk(exp, func(e *parser.Expression) bool {
+		switch e.Type {
+		case parser.CallType:
+			f, ok := functions.Lookup(e.Call.Name.Value)
+			return !ok || f.Aggregate == nil
+		case parser.ColumnRefType:
+			for _, c := range sc.columns {
+				if c.Name == e.Column.Value && col == nil {
+					col = e
+				}
+			}
+		}
+		return col == nil
+	})
+
+	return col
+}
+
+// walk calls visit for exp and its subexpressions, leaving out subqueries.
+// The subexpressions of an expression are skipped when visit returns false.
+func walk(exp *parser.Expression, visit func(*parser.Expression) bool) {
+	if !visit(exp) {
+		return
+	}
+
+	switch exp.Type {
+	case parser.BinaryType:
+		walk(&exp.Binary.A, visit)
+		walk(&exp.Binary.B, visit)
+	case parser.CastType:
+		walk(&exp.Cast.Exp, visit)
+	case parser.IndexType:
+		walk(&exp.Index.Exp, visit)
+		walk(&exp.Index.Index, visit)
+	case parser.CallType:
+		for i := range exp.Call.Args {
+			walk(&exp.Call.Args[i], visit)
+		}
+	case parser.ArrayType:
+		for i := range exp.Array {
+			walk(&exp.Array[i], visit)
+		}
+	case parser.RowType:
+		for i := range exp.Row {
+			walk(&exp.Row[i], visit)
+		}
+	case parser.InType:
+		walk(&exp.In.Exp, visit)
+		for i := range exp.In.List {
+			walk(&exp.In.List[i], visit)
+		}
+	case parser.NotType:
+		walk(exp.Not, visit)
+	}
+}
+
+func arity(f *functions.Function) string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+
+	switch {
+	case f.MaxArgs < 0:
+		return "at least " + plural(f.MinArgs)
+	case f.MinArgs == f.MaxArgs:
+		return plural(f.MinArgs)
+	}
+
+	return fmt.Sprintf("%d to %d arguments", f.MinArgs, f.MaxArgs)
+}
+
+func typeName(t exprType) string {
+	if !t.Known {
+		return "unknown"
+	}
+
+	return t.Type.String()
+}
@@ -0,0 +1,207 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+)
+
+func TestQualifiedColumns(t *testing.T) {
+	tests := []struct {
+		query string
+		rows  [][]interface{}
+		// err is part of the error, empty when the query runs
+		err string
+	}{
+		{"select t.id from t where t.name = 'b'", [][]interface{}{{int64(2)}}, ""},
+		{"select id from t where exists (select 1 from u where u.tid = t.id)", [][]interface{}{{int64(1)}}, ""},
+		{"select id, (select count(*) from u where u.tid = t.id) from t", [][]interface{}{{int64(1), int64(1)}, {int64(2), int64(0)}}, ""},
+		{"select id from t where id in (select u.tid from u where u.label = t.name)", [][]interface{}{{int64(1)}}, ""},
+		{"select t.nope from t", nil, `Column "nope" does not exist in table "t"`},
+		{"select id from t where exists (select 1 from u where v.tid = t.id)", nil, `Column "v.tid" does not exist`},
+		// name of t is hidden by name of w, and a query of w can't tell
+		// them apart
+		{"select id from t where exists (select 1 from w where w.name = t.name)", nil,
+			`Column "name" of table "t" can't be referred to inside a query of table "w"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb := backend.NewMemoryBackend()
+			session := functions.NewSession(mb)
+			exec := func(query string) (*backend.Results, error) {
+				ast, err := parser.Parse(query)
+				if err != nil {
+					return nil, err
+				}
+
+				results := &backend.Results{}
+				for _, stmt := range ast.Statements {
+					if err := Analyze(mb, stmt); err != nil {
+						return nil, err
+					}
+					if results, err = backend.Exec(context.Background(), mb, stmt, session, nil); err != nil {
+						return nil, err
+					}
+				}
+				return results, nil
+			}
+
+			for _, query := range []string{
+				"create table t (id int, name text)",
+				"create table u (tid int, label text)",
+				"create table w (name text)",
+				"insert into t values (1, 'a')",
+				"insert into t values (2, 'b')",
+				"insert into u values (1, 'a')",
+				"insert into u values (null, 'b')",
+			} {
+				if _, err := exec(query); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			results, err := exec(tt.query)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("got error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
+
+func TestIndexHints(t *testing.T) {
+	tests := []struct {
+		query string
+		// err is part of the error, empty when the query runs
+		err string
+	}{
+		{"select /*+ index(t t_name) */ id from t where name = 'a'", ""},
+		{"select /*+ no_index(t) */ id from t where name = 'a'", ""},
+		{"select /*+ no_index(t, t_name) */ id from t where name = 'a'", ""},
+		{"select /*+ index(t t_nmae) */ id from t", `Index "t_nmae" does not exist on table "t"`},
+		{"select /*+ index(u t_name) */ tid from u", `Index "t_name" does not exist on table "u"`},
+		{"select /*+ index(v t_name) */ id from t", `Table "v" does not exist`},
+		{"select /*+ index */ id from t", "Hint INDEX needs the tables it applies to"},
+	}
+
+	mb := backend.NewMemoryBackend()
+	session := functions.NewSession(mb)
+	exec := func(query string) error {
+		ast, err := parser.Parse(query)
+		if err != nil {
+			return err
+		}
+
+		for _, stmt := range ast.Statements {
+			if err := Analyze(mb, stmt); err != nil {
+				return err
+			}
+			if _, err = backend.Exec(context.Background(), mb, stmt, session, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, query := range []string{
+		"create table t (id int, name text)",
+		"create index t_name on t (name)",
+		"create table u (tid int)",
+	} {
+		if err := exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			err := exec(tt.query)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("got error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		query string
+		// msg is the message of the error, empty when the statement is fine
+		msg          string
+		line, column uint
+		err          error
+	}{
+		{"select id, name from t where id > 1", "", 0, 0, nil},
+		{"insert into t values (1, null)", "", 0, 0, nil},
+		{"select id from missing", `Table "missing" does not exist`, 0, 15, backend.ErrTableDoesNotExist},
+		{"select nope from t", `Column "nope" does not exist in table "t"`, 0, 7, nil},
+		{"select id from t\nwhere name", "WHERE must be bool, not text", 1, 6, nil},
+		{"select id + name from t", "Operator + does not accept int and text", 0, 10, nil},
+		{"insert into t values (1)", `Table "t" has 2 columns but 1 values were given`, 0, 12, nil},
+		{"insert into t values ('a', 'b')", `Column "id" is int but the value is text`, 0, 22, nil},
+		{"create table t (id int)", `Table "t" already exists`, 0, 13, nil},
+		{"create table u (id int, id text)", `Column "id" specified more than once`, 0, 24, nil},
+		{"create table u (id nope)", `Type "nope" does not exist`, 0, 19, nil},
+		{"select id, name as n from t order by n desc, 1, lower(name) limit $1 offset 1", "", 0, 0, nil},
+		{"select id from t order by 3", "ORDER BY position 3 is not in select list", 0, 26, nil},
+		{"select id from t order by nope", `Column "nope" does not exist in table "t"`, 0, 26, nil},
+		{"select id from t order by count(*)", "Aggregate function count can only be used in SELECT items", 0, 26, nil},
+		{"select id from t limit 'a'", "LIMIT must be int, not text", 0, 23, nil},
+	}
+
+	mb := backend.NewMemoryBackend()
+	session := functions.NewSession(mb)
+	ast, err := parser.Parse("create table t (id int, name text)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Exec(context.Background(), mb, ast.Statements[0], session, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			ast, err := parser.Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Analyze(mb, ast.Statements[0])
+			if tt.msg == "" {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+				return
+			}
+
+			aerr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got error %v, want an *Error", err)
+			}
+			if aerr.Msg != tt.msg || aerr.Loc.Line != tt.line || aerr.Loc.Column != tt.column {
+				t.Errorf("got %q at [%d,%d], want %q at [%d,%d]", aerr.Msg, aerr.Loc.Line, aerr.Loc.Column, tt.msg, tt.line, tt.column)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want it to be %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package analyzer
+
+import (
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+// ParamTypes returns the types of the $n placeholders of stmt by their
+// numbers, for protocols that report them before arguments are bound. A
+// placeholder has the type it is cast to, of what it is compared or
+// combined with, of the column its value is inserted into, or bool when it
+// is a condition.
+// Placeholders no type follows for are left out. stmt must have been
+// analyzed.
+func ParamTypes(catalog backend.Catalog, stmt *parser.Statement) map[uint]backend.ColumnType {
+	params := map[uint]backend.ColumnType{}
+
+	switch stmt.Type {
+	case parser.SelectType:
+		queryParamTypes(catalog, stmt.SelectStatement, nil, params)
+	case parser.DeclareCursorType:
+		queryParamTypes(catalog, stmt.DeclareCursorStatement.Query, nil, params)
+	case parser.CreateMaterializedViewType:
+		queryParamTypes(catalog, stmt.CreateMaterializedViewStatement.Query, nil, params)
+	case parser.ExplainType:
+		return ParamTypes(catalog, stmt.ExplainStatement.Statement)
+	case parser.InsertType:
+		inst := stmt.InsertStatement
+		columns, _ := catalog.Columns(inst.Table.Value)
+		if inst.Values == nil {
+			break
+		}
+
+		sc := &scope{catalog: catalog}
+		for i, value := range *inst.Values {
+			if value.Type == parser.ParamType && i < len(columns) {
+				bindParam(params, value, columns[i].Type)
+				continue
+			}
+			sc.paramTypes(value, params)
+		}
+	}
+
+	return params
+}
+
+// queryParamTypes adds the types of the placeholders of slct to params,
+// slct is a subquery when outer is not nil.
+func queryParamTypes(catalog backend.Catalog, slct *parser.SelectStatement, outer *scope, params map[uint]backend.ColumnType) {
+	sc := &scope{catalog: catalog, outer: outer, items: true}
+	if slct.From != nil {
+		sc.table = slct.From.Value
+		sc.columns, _ = catalog.Columns(slct.From.Value)
+	} else if slct.Function != nil {
+		col, _ := analyzeFunction(catalog, slct.Function)
+		sc.columns = []backend.Column{col}
+	}
+
+	for _, item := range slct.Item {
+		if !item.Asterisk {
+			sc.paramTypes(item.Exp, params)
+		}
+	}
+
+	if slct.AsOf != nil {
+		bindParam(params, slct.AsOf, backend.TimestampType)
+		sc.paramTypes(slct.AsOf, params)
+	}
+	if slct.Where != nil {
+		bindParam(params, slct.Where, backend.BoolType)
+		sc.paramTypes(slct.Where, params)
+	}
+	if slct.ConnectBy != nil && slct.ConnectBy.Start != nil {
+		bindParam(params, slct.ConnectBy.Start, backend.BoolType)
+		sc.paramTypes(slct.ConnectBy.Start, params)
+	}
+	for _, order := range slct.OrderBy {
+		sc.paramTypes(order.Exp, params)
+	}
+	for _, exp := range []*parser.Expression{slct.Limit, slct.Offset} {
+		if exp != nil {
+			bindParam(params, exp, backend.IntType)
+			sc.paramTypes(exp, params)
+		}
+	}
+}
+
+// paramTypes adds the types of the placeholders of exp to params.
+func (sc *scope) paramTypes(exp *parser.Expression, params map[uint]backend.ColumnType) {
+	walk(exp, func(e *parser.Expression) bool {
+		switch e.Type {
+		case parser.BinaryType:
+			sc.operandTypes(&e.Binary.A, &e.Binary.B, e.Binary.Op.Value, params)
+		case parser.InType:
+			for i := range e.In.List {
+				sc.operandTypes(&e.In.Exp, &e.In.List[i], "=", params)
+			}
+		case parser.NotType:
+			bindParam(params, e.Not, backend.BoolType)
+		case parser.CastType:
+			if to, ok := types.Parse(e.Cast.Type.Value); ok {
+				bindParam(params, &e.Cast.Exp, to)
+			}
+		case parser.SubqueryType:
+			queryParamTypes(sc.catalog, e.Subquery, sc, params)
+		case parser.ExistsType:
+			queryParamTypes(sc.catalog, e.Exists.Query, sc, params)
+		}
+		return true
+	})
+}
+
+// operandTypes gives a placeholder operand of op the type of the other
+// operand. Text converts to timestamps and intervals by itself, so
+// placeholders added to or subtracted from them are left as text.
+func (sc *scope) operandTypes(a, b *parser.Expression, op string, params map[uint]backend.ColumnType) {
+	switch {
+	case op == "and" || op == "or":
+		bindParam(params, a, backend.BoolType)
+		bindParam(params, b, backend.BoolType)
+		return
+	case op == "||" || types.IsMatch(op):
+		bindParam(params, a, backend.TextType)
+		bindParam(params, b, backend.TextType)
+		return
+	}
+
+	for _, pair := range [][2]*parser.Expression{{a, b}, {b, a}} {
+		param, other := pair[0], pair[1]
+		if param.Type != parser.ParamType {
+			continue
+		}
+
+		t, err := sc.infer(other)
+		if err != nil || !t.Known {
+			continue
+		}
+		if (op == "+" || op == "-") && (t.Type == backend.TimestampType || t.Type == backend.IntervalType) {
+			continue
+		}
+		bindParam(params, param, t.Type)
+	}
+}
+
+// bindParam gives exp type t if it is a placeholder without a type yet.
+func bindParam(params map[uint]backend.ColumnType, exp *parser.Expression, t backend.ColumnType) {
+	if exp.Type != parser.ParamType {
+		return
+	}
+
+	if _, ok := params[exp.Param]; !ok {
+		params[exp.Param] = t
+	}
+}
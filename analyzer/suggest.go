@@ -0,0 +1,57 @@
+package analyzer
+
+// editDistance is the optimal string alignment distance between a and b:
+// the number of insertions, deletions, substitutions and transpositions of
+// adjacent characters needed to turn one into the other.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+func minInt(first int, rest ...int) int {
+	m := first
+	for _, v := range rest {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
+
+// suggest returns the candidate closest to name, or "" if none is close
+// enough to plausibly be what was meant.
+func suggest(name string, candidates []string) string {
+	best := ""
+	bestDistance := (len(name) + 2) / 3
+	for _, c := range candidates {
+		if d := editDistance(name, c); d <= bestDistance && (best == "" || d < bestDistance) {
+			best = c
+			bestDistance = d
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,102 @@
+package analyzer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"users", "users", 0},
+		{"", "abc", 3},
+		{"user", "users", 1},
+		{"usres", "users", 1},
+		{"usrs", "users", 1},
+		{"uzers", "users", 1},
+		{"ca", "abc", 3},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := editDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"users", "orders", "order_items", "id"}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"usres", "users"},
+		{"ordrs", "orders"},
+		{"order_item", "order_items"},
+		{"customers", ""},
+		// Names of a few letters get only suggestions one edit away
+		{"ix", "id"},
+		{"di", "id"},
+		{"xy", ""},
+		{"ordes_itms", "order_items"},
+	}
+
+	for _, tt := range tests {
+		if got := suggest(tt.name, candidates); got != tt.want {
+			t.Errorf("suggest(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestErrorSuggestions(t *testing.T) {
+	tests := []struct {
+		query      string
+		suggestion string
+	}{
+		{"select id from usres", "users"},
+		{"select nmae from users", "name"},
+		{"select users.nmae from users", "name"},
+		{"select uper(name) from users", "upper"},
+		{"select /*+ nested_lop(users) */ id from users", "nested_loop"},
+		{"select id from customers", ""},
+	}
+
+	mb := backend.NewMemoryBackend()
+	session := functions.NewSession(mb)
+	ast, err := parser.Parse("create table users (id int, name text)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Exec(context.Background(), mb, ast.Statements[0], session, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			ast, err := parser.Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			aerr, ok := Analyze(mb, ast.Statements[0]).(*Error)
+			if !ok {
+				t.Fatalf("analyzed without an *Error")
+			}
+			if aerr.Suggestion != tt.suggestion {
+				t.Errorf("got suggestion %q, want %q", aerr.Suggestion, tt.suggestion)
+			}
+		})
+	}
+}
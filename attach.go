@@ -0,0 +1,420 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+)
+
+var (
+	ErrAttachInTx      = errors.New("ATTACH and DETACH can't run inside a transaction block")
+	ErrAttachedWrite   = errors.New("Only INSERT can change the tables of attached databases")
+	ErrNotAttached     = errors.New("No database is attached with that alias")
+	ErrAliasInUse      = errors.New("Another database is already attached with that alias")
+	ErrAlreadyAttached = errors.New("Database is already attached to the session")
+	ErrNoAttachDir     = errors.New("Databases can't be attached, no directory is set for their files")
+	ErrAttachEncrypted = errors.New("Database files can't be attached to an encrypted database, their logs aren't encrypted")
+)
+
+// lastDBID numbers the databases opened in the process, transactions lock
+// the databases they span in that order so two of them never wait on each
+// other.
+var lastDBID int64
+
+// attachedFiles are the database files attached to sessions of the process.
+// Sessions attaching the same file share a DB, since two of them appending
+// to one log would damage it. refs counts the sessions attaching each.
+var attachedFiles = struct {
+	sync.Mutex
+	dbs  map[string]*DB
+	refs map[string]int
+}{dbs: map[string]*DB{}, refs: map[string]int{}}
+
+// attachment is a database attached to a session. Its functions keep their
+// state in a session of its own.
+type attachment struct {
+	db      *DB
+	path    string
+	session *functions.Session
+}
+
+// SetAttachDir lets ATTACH open the database files in dir and the
+// directories below it, relative paths are relative to dir. Until it is
+// set only in-memory databases can be attached, so the users of a database
+// can't open or create files anywhere on the machine it runs on.
+func (db *DB) SetAttachDir(dir string) {
+	db.attachDir.Store(dir)
+}
+
+// attachPath returns the absolute path of the database file ATTACH names
+// with name, which must be in the directory set with SetAttachDir. Symbolic
+// links are followed before checking, so a link in the directory can't lead
+// out of it. A file that doesn't exist yet is checked by the directory it
+// would be created in.
+func (db *DB) attachPath(name string) (string, error) {
+	dir, _ := db.attachDir.Load().(string)
+	if dir == "" {
+		return "", ErrNoAttachDir
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", err
+	}
+
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Opening a link to nowhere would create the file it points to
+		if _, err := os.Lstat(path); err == nil {
+			return "", fmt.Errorf("Database file %s is a link to a file that doesn't exist", name)
+		}
+
+		parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return "", err
+		}
+		resolved = filepath.Join(parent, filepath.Base(path))
+	} else if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Database file %s is outside of %s", name, dir)
+	}
+
+	return resolved, nil
+}
+
+// attach runs ATTACH, which opens the database at the path of stmt, or a
+// new in-memory one for :memory:, and lets the session refer to its tables
+// as alias.table until DETACH or the session ends. Files must be in the
+// directory set with SetAttachDir, and read-only sessions can only attach
+// those that exist. Encrypted databases can only attach in-memory ones, as
+// attached files keep their statement logs unencrypted.
+func (c *Conn) attach(stmt *parser.AttachStatement) (*Results, error) {
+	if c.tx != nil {
+		return nil, ErrAttachInTx
+	}
+
+	alias := stmt.Alias.Value
+	if strings.Contains(alias, ".") {
+		return nil, fmt.Errorf("Database alias %s can't contain a dot", alias)
+	}
+	if _, ok := c.attached[alias]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrAliasInUse, alias)
+	}
+
+	if stmt.Path.Value == "" || stmt.Path.Value == MemoryPath {
+		db, err := open(MemoryPath, c.db.readOnly, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.addAttachment(alias, &attachment{db: db})
+		return &Results{}, nil
+	}
+
+	if c.db.encrypted() {
+		return nil, ErrAttachEncrypted
+	}
+
+	path, err := c.db.attachPath(stmt.Path.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.db.path != "" {
+		if own, err := filepath.Abs(c.db.path); err == nil && own == path {
+			return nil, fmt.Errorf("%w: %s is the database of the session", ErrAlreadyAttached, stmt.Path.Value)
+		}
+	}
+	for other, a := range c.attached {
+		if a.path == path {
+			return nil, fmt.Errorf("%w: %s is attached as %s", ErrAlreadyAttached, stmt.Path.Value, other)
+		}
+	}
+
+	attachedFiles.Lock()
+	defer attachedFiles.Unlock()
+
+	db, ok := attachedFiles.dbs[path]
+	if !ok {
+		if c.readOnly {
+			if _, err := os.Stat(path); err != nil {
+				return nil, err
+			}
+		}
+		if db, err = open(path, c.db.readOnly, nil, nil); err != nil {
+			return nil, err
+		}
+		attachedFiles.dbs[path] = db
+	}
+	attachedFiles.refs[path]++
+
+	c.addAttachment(alias, &attachment{db: db, path: path})
+	return &Results{}, nil
+}
+
+func (c *Conn) addAttachment(alias string, a *attachment) {
+	a.session = functions.NewSession(a.db.backend)
+	a.session.SetDatabase(a.db.name())
+	a.session.SetUser(c.session.User())
+
+	if c.attached == nil {
+		c.attached = map[string]*attachment{}
+	}
+	c.attached[alias] = a
+}
+
+// detach runs DETACH, closing the attached database once no session of the
+// process attaches it anymore.
+func (c *Conn) detach(stmt *parser.DetachStatement) (*Results, error) {
+	if c.tx != nil {
+		return nil, ErrAttachInTx
+	}
+
+	if _, ok := c.attached[stmt.Alias.Value]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotAttached, stmt.Alias.Value)
+	}
+
+	return &Results{}, c.removeAttachment(stmt.Alias.Value)
+}
+
+func (c *Conn) removeAttachment(alias string) error {
+	a := c.attached[alias]
+	delete(c.attached, alias)
+
+	if a.path == "" {
+		return a.db.Close()
+	}
+
+	attachedFiles.Lock()
+	defer attachedFiles.Unlock()
+
+	attachedFiles.refs[a.path]--
+	if attachedFiles.refs[a.path] > 0 {
+		return nil
+	}
+
+	delete(attachedFiles.refs, a.path)
+	delete(attachedFiles.dbs, a.path)
+	return a.db.Close()
+}
+
+// detachAll detaches every database attached to the session.
+func (c *Conn) detachAll() error {
+	var err error
+	for alias := range c.attached {
+		if derr := c.removeAttachment(alias); err == nil {
+			err = derr
+		}
+	}
+
+	return err
+}
+
+// begin starts a transaction on the database of the session and one on
+// each database attached to it, waiting for any other transaction on them
+// to finish first.
+func (c *Conn) begin() *Tx {
+	aliases := make([]string, 0, len(c.attached)+1)
+	aliases = append(aliases, "")
+	for alias := range c.attached {
+		aliases = append(aliases, alias)
+	}
+
+	dbOf := func(alias string) *DB {
+		if alias == "" {
+			return c.db
+		}
+		return c.attached[alias].db
+	}
+	sort.Slice(aliases, func(i, j int) bool {
+		return dbOf(aliases[i]).id < dbOf(aliases[j]).id
+	})
+
+	var tx *Tx
+	attached := map[string]*Tx{}
+	for _, alias := range aliases {
+		if alias == "" {
+			tx = c.db.begin(c.readOnly, c.session)
+			continue
+		}
+
+		a := c.attached[alias]
+		attached[alias] = a.db.begin(c.readOnly || a.db.readOnly, a.session)
+	}
+
+	if len(attached) > 0 {
+		tx.attached = attached
+	}
+	tx.conn = c
+	return tx
+}
+
+// attachedTx returns the transaction on the attached database table belongs
+// to and the name of the table in it, or false if table is a table of the
+// database of tx.
+func (tx *Tx) attachedTx(table string) (*Tx, string, bool) {
+	alias, name, ok := backend.SplitAttached(table)
+	if !ok || tx.attached[alias] == nil {
+		return nil, "", false
+	}
+
+	if _, ok := tx.db.backend.Columns(table); ok {
+		return nil, "", false
+	}
+
+	return tx.attached[alias], name, true
+}
+
+// attachedTarget returns the transaction on the attached database stmt
+// changes and the name of the table it changes there, if it changes one.
+// Only inserts may change the tables of attached databases, other
+// statements doing so fail with ErrAttachedWrite.
+func (tx *Tx) attachedTarget(stmt *parser.Statement) (*Tx, string, bool, error) {
+	var table string
+	switch stmt.Type {
+	case parser.InsertType:
+		table = stmt.InsertStatement.Table.Value
+	case parser.CreateTableType:
+		table = stmt.CreateTableStatement.Name.Value
+	case parser.DropTableType:
+		table = stmt.DropTableStatement.Name.Value
+	case parser.AlterTableType:
+		table = stmt.AlterTableStatement.Table.Value
+	case parser.CreateMaterializedViewType:
+		table = stmt.CreateMaterializedViewStatement.Name.Value
+	case parser.RefreshType:
+		table = stmt.RefreshStatement.Name.Value
+	case parser.CreateSequenceType:
+		table = stmt.CreateSequenceStatement.Name.Value
+	case parser.CheckTableType:
+		table = stmt.CheckTableStatement.Table.Value
+	case parser.CreatePolicyType:
+		table = stmt.CreatePolicyStatement.Table.Value
+	case parser.DropPolicyType:
+		table = stmt.DropPolicyStatement.Table.Value
+	case parser.CreateIndexType:
+		table = stmt.CreateIndexStatement.Table.Value
+	case parser.FlashbackType:
+		table = stmt.FlashbackStatement.Table.Value
+	case parser.CreateStatisticsType:
+		table = stmt.CreateStatisticsStatement.Table.Value
+	case parser.CommentType:
+		table = stmt.CommentStatement.Table.Value
+	case parser.AnalyzeType:
+		if stmt.AnalyzeStatement.Table == nil {
+			return nil, "", false, nil
+		}
+		table = stmt.AnalyzeStatement.Table.Value
+	default:
+		return nil, "", false, nil
+	}
+
+	attached, name, ok := tx.attachedTx(table)
+	if !ok {
+		return nil, "", false, nil
+	}
+	if stmt.Type != parser.InsertType {
+		return nil, "", true, ErrAttachedWrite
+	}
+
+	return attached, name, true, nil
+}
+
+// execAttached runs stmt in the transaction on the attached database it
+// changes, if it changes one. Inserts are rewritten to name the table as
+// the attached database knows it.
+func (tx *Tx) execAttached(ctx context.Context, stmt *parser.Statement, args []interface{}) (*Results, bool, error) {
+	attached, name, ok, err := tx.attachedTarget(stmt)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+
+	inst := *stmt.InsertStatement
+	inst.Table.Value = name
+	results, err := attached.execStatement(ctx, &parser.Statement{
+		InsertStatement: &inst,
+		Type:            parser.InsertType,
+		Text:            inst.String(),
+	}, args)
+	return results, true, err
+}
+
+// attachedBackends returns the backends of the databases attached to the
+// session of tx by their aliases.
+func (tx *Tx) attachedBackends() map[string]*backend.MemoryBackend {
+	backends := make(map[string]*backend.MemoryBackend, len(tx.attached))
+	for alias, attached := range tx.attached {
+		backends[alias] = attached.db.backend
+	}
+
+	return backends
+}
+
+// attachedAliases returns the aliases of the attached databases of tx in
+// order.
+func (tx *Tx) attachedAliases() []string {
+	aliases := make([]string, 0, len(tx.attached))
+	for alias := range tx.attached {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	return aliases
+}
+
+// attachedCatalog is the catalog of a database whose session has attached
+// others, it lists their tables as alias.table.
+type attachedCatalog struct {
+	backend.Catalog
+	attached map[string]*Tx
+}
+
+func (c attachedCatalog) Columns(table string) ([]backend.Column, bool) {
+	if columns, ok := c.Catalog.Columns(table); ok {
+		return columns, true
+	}
+
+	alias, name, ok := backend.SplitAttached(table)
+	if !ok || c.attached[alias] == nil {
+		return nil, false
+	}
+
+	return c.attached[alias].db.backend.Columns(name)
+}
+
+func (c attachedCatalog) Tables() []string {
+	tables := c.Catalog.Tables()
+	for alias, tx := range c.attached {
+		for _, table := range tx.db.backend.Tables() {
+			tables = append(tables, alias+"."+table)
+		}
+	}
+
+	return tables
+}
+
+// nextDBID returns the id of a database being opened.
+func nextDBID() int64 {
+	return atomic.AddInt64(&lastDBID, 1)
+}
@@ -0,0 +1,112 @@
+package sgsql
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAttachConfinedToAttachDir(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	other := filepath.Join(outside, "other.db")
+
+	// Links in the attach dir lead out of it, or stay inside
+	for link, target := range map[string]string{
+		"out":         outside,
+		"dangling.db": filepath.Join(outside, "dangling.db"),
+		"inside.db":   filepath.Join(dir, "aux.db"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		attachDir string
+		path      string
+		readOnly  bool
+		ok        bool
+	}{
+		{"no attach dir", "", "aux.db", false, false},
+		{"memory without attach dir", "", ":memory:", false, true},
+		{"relative", dir, "aux.db", false, true},
+		{"absolute inside", dir, filepath.Join(dir, "sub", "..", "aux.db"), false, true},
+		{"absolute outside", dir, other, false, false},
+		{"relative outside", dir, "../aux.db", false, false},
+		{"through a link out", dir, "out/aux.db", false, false},
+		{"link to a new file outside", dir, "dangling.db", false, false},
+		{"link inside", dir, "inside.db", false, true},
+		{"read-only creating a file", dir, "new.db", true, false},
+		{"read-only existing file", dir, "aux.db", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := openTest(t)
+			db.SetAttachDir(tt.attachDir)
+
+			c := db.Conn()
+			t.Cleanup(func() { c.Close() })
+			if err := c.SetReadOnly(tt.readOnly); err != nil {
+				t.Fatal(err)
+			}
+
+			err := c.Exec("attach database '" + tt.path + "' as aux")
+			if (err == nil) != tt.ok {
+				t.Fatalf("attaching %s: got %v, want ok = %v", tt.path, err, tt.ok)
+			}
+			if tt.attachDir == "" && !tt.ok && !errors.Is(err, ErrNoAttachDir) {
+				t.Errorf("got %v, want %v", err, ErrNoAttachDir)
+			}
+			if err == nil {
+				mustExec(t, c, "detach aux")
+			}
+		})
+	}
+
+	if files, err := os.ReadDir(outside); err != nil || len(files) != 0 {
+		t.Errorf("attaching outside of the attach dir created %v, %v", files, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.db")); !os.IsNotExist(err) {
+		t.Error("a read-only session created a database file by attaching it")
+	}
+}
+
+func TestDryRunSkipsAttach(t *testing.T) {
+	dir := t.TempDir()
+	db, _ := openTest(t)
+	db.SetAttachDir(dir)
+
+	c := db.Conn()
+	t.Cleanup(func() { c.Close() })
+	c.SetDryRun(true)
+
+	mustExec(t, c, "attach database 'aux.db' as aux", "detach aux")
+	if _, err := os.Stat(filepath.Join(dir, "aux.db")); !os.IsNotExist(err) {
+		t.Error("ATTACH created the database file in dry-run mode")
+	}
+}
+
+func TestEncryptedAttachesOnlyMemory(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenEncrypted(filepath.Join(t.TempDir(), "test.db"), []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetAttachDir(dir)
+
+	c := db.Conn()
+	t.Cleanup(func() { c.Close() })
+	if err := c.Exec("attach database 'aux.db' as aux"); !errors.Is(err, ErrAttachEncrypted) {
+		t.Errorf("attaching a file: got %v, want %v", err, ErrAttachEncrypted)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "aux.db")); !os.IsNotExist(err) {
+		t.Error("attaching to an encrypted database created an unencrypted file")
+	}
+
+	mustExec(t, c, "attach database ':memory:' as scratch", "detach scratch")
+}
@@ -0,0 +1,108 @@
+package sgsql
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/logging"
+	"github.com/nireo/sgsql/parser"
+)
+
+// auditRetained is how many of the latest audited statements __audit_log
+// keeps, older ones only survive in the sink set with SetAuditSink.
+const auditRetained = 10000
+
+// auditLog records who ran the DDL, GRANT and admin statements of a
+// database. Entries are only ever appended, to the sink as they are
+// recorded.
+type auditLog struct {
+	mu      sync.Mutex
+	entries []backend.AuditEntry
+	sink    io.Writer
+}
+
+// SetAuditSink writes every audited statement to w from now on, as a line
+// of JSON like
+//
+//	{"at":"2024-05-01T12:00:00Z","session_id":3,"user":"alice","statement":"DROP TABLE t"}
+//
+// The audited statements are those changing the schema, like CREATE TABLE
+// and CREATE POLICY, GRANT and REVOKE, and the admin statements VACUUM,
+// REKEY, KILL, ATTACH and DETACH, whether they succeed or not. A nil w
+// stops writing them. Failing writes are logged, they don't fail the
+// statements.
+func (db *DB) SetAuditSink(w io.Writer) {
+	db.auditLog.mu.Lock()
+	defer db.auditLog.mu.Unlock()
+
+	db.auditLog.sink = w
+}
+
+// audited reports whether stmt is recorded in the audit log.
+func audited(stmt *parser.Statement) bool {
+	switch stmt.Type {
+	case parser.CreateTableType, parser.DropTableType, parser.AlterTableType,
+		parser.CreateMaterializedViewType, parser.CreateSequenceType,
+		parser.CreatePolicyType, parser.DropPolicyType, parser.GrantType,
+		parser.CreateIndexType, parser.DropIndexType, parser.CreateRoleType, parser.DropRoleType,
+		parser.VacuumType, parser.RekeyType, parser.KillType,
+		parser.AttachType, parser.DetachType, parser.FlashbackType, parser.ReloadConfigType,
+		parser.CreateStatisticsType, parser.DropStatisticsType, parser.AnalyzeType, parser.CommentType:
+		return true
+	}
+
+	return false
+}
+
+// audit records that stmt ran in the session of c, or a session of its own
+// when c is nil, as the user of session, failing with err unless it is nil.
+func (db *DB) audit(c *Conn, session *functions.Session, stmt *parser.Statement, err error) {
+	if !audited(stmt) {
+		return
+	}
+
+	entry := backend.AuditEntry{
+		At:        time.Now().UTC(),
+		User:      session.User(),
+		Statement: parser.Redact(stmt.Text),
+	}
+	if c != nil {
+		entry.SessionID = c.ID()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	db.auditLog.mu.Lock()
+	defer db.auditLog.mu.Unlock()
+
+	db.auditLog.entries = append(db.auditLog.entries, entry)
+	if len(db.auditLog.entries) > auditRetained {
+		db.auditLog.entries = append([]backend.AuditEntry{}, db.auditLog.entries[len(db.auditLog.entries)-auditRetained:]...)
+	}
+
+	if db.auditLog.sink == nil {
+		return
+	}
+
+	line, merr := json.Marshal(entry)
+	if merr == nil {
+		_, merr = db.auditLog.sink.Write(append(line, '\n'))
+	}
+	if merr != nil {
+		db.logEvent(context.Background(), logging.LevelError, "Writing audit log failed", "error", merr)
+	}
+}
+
+// auditEntries lists the audited statements for __audit_log.
+func (db *DB) auditEntries() []backend.AuditEntry {
+	db.auditLog.mu.Lock()
+	defer db.auditLog.mu.Unlock()
+
+	return append([]backend.AuditEntry{}, db.auditLog.entries...)
+}
@@ -0,0 +1,104 @@
+package sgsql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+)
+
+func TestAuditLog(t *testing.T) {
+	tests := []struct {
+		user    string
+		query   string
+		audited bool
+		failed  bool
+	}{
+		{"alice", "create table u (id int)", true, false},
+		{"alice", "insert into u values (1)", false, false},
+		{"alice", "select id from u", false, false},
+		{"alice", "create index u_id on u (id)", true, false},
+		{"alice", "alter table u add column name text", true, false},
+		{"alice", "comment on table u is 'secret'", true, false},
+		{"bob", "create table u (id int)", true, true},
+		{"bob", "vacuum", true, true},
+		{"sgsql", "create role readers", true, false},
+		{"sgsql", "grant readers to alice", true, false},
+		{"sgsql", "vacuum", true, false},
+		{"alice", "drop table u", true, false},
+	}
+
+	db, _ := openTest(t)
+	var sink bytes.Buffer
+	db.SetAuditSink(&sink)
+
+	want, failed := []backend.AuditEntry{}, []bool{}
+	for _, tt := range tests {
+		c := connAs(t, db, tt.user)
+		if err := c.Exec(tt.query); (err != nil) != tt.failed {
+			t.Fatalf("%s as %s: got %v, want failed %v", tt.query, tt.user, err, tt.failed)
+		}
+
+		if tt.audited {
+			want = append(want, backend.AuditEntry{SessionID: c.ID(), User: tt.user, Statement: parser.Redact(tt.query)})
+			failed = append(failed, tt.failed)
+		}
+	}
+
+	results, err := db.Query("select session_id, user_name, statement, error from __audit_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Rows) != len(want) {
+		t.Fatalf("__audit_log holds %v, want %d rows", results.Rows, len(want))
+	}
+	for i, row := range results.Rows {
+		if row[0] != want[i].SessionID || row[1] != want[i].User || row[2] != want[i].Statement || (row[3] != nil) != failed[i] {
+			t.Errorf("row %d of __audit_log is %v, want %+v failed %v", i, row, want[i], failed[i])
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(sink.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("wrote %d lines to the sink, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		var entry backend.AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		if entry.SessionID != want[i].SessionID || entry.User != want[i].User || entry.Statement != want[i].Statement ||
+			(entry.Error != "") != failed[i] || entry.At.IsZero() {
+			t.Errorf("line %d of the sink is %+v, want %+v", i, entry, want[i])
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAuditSinkFailing(t *testing.T) {
+	db, _ := openTest(t)
+	l := &eventLogger{}
+	db.SetLogger(l)
+	db.SetAuditSink(failingWriter{})
+
+	mustExec(t, db, "create table t (id int)")
+	if _, ok := l.find("Writing audit log failed"); !ok {
+		t.Error("the failed write to the sink wasn't logged")
+	}
+
+	// The entry is still in __audit_log, and nil stops writing to the sink
+	db.SetAuditSink(nil)
+	mustExec(t, db, "drop table t")
+	if got := queryRows(t, db, "select statement from __audit_log"); len(got) != 2 {
+		t.Errorf("__audit_log holds %v, want both statements", got)
+	}
+}
@@ -0,0 +1,231 @@
+package backend
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+// workloadCandidates is how many columns and expressions the workload
+// keeps track of, those first seen after it is full are left out.
+const workloadCandidates = 1024
+
+// workload records what the queries that scanned whole tables compared
+// with values, which RECOMMEND INDEXES suggests indexes over. It has a
+// lock of its own since queries record into it holding mb.mu only for
+// reading.
+type workload struct {
+	mu         sync.Mutex
+	candidates map[candidateKey]*indexCandidate
+}
+
+type candidateKey struct {
+	table, target string
+}
+
+// indexCandidate is a column or an expression over the columns of a table
+// that queries compared with a value the same for every row. equalities
+// and ranges count the queries comparing it for equality and otherwise.
+type indexCandidate struct {
+	exp        *parser.Expression
+	equalities int64
+	ranges     int64
+}
+
+// recordScan records the comparisons of the WHERE of slct that an index
+// of t could have narrowed its rows down with, slct having scanned every
+// row of t instead. Collated and masked columns are left out, their
+// indexes are never used.
+func (ev *evaluation) recordScan(slct *parser.SelectStatement, t *memoryTable) {
+	if slct.From == nil || slct.Where == nil || slct.ConnectBy != nil || isSystemTable(slct.From.Value) ||
+		t.view != nil || t.external != nil {
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, exp := range conjuncts(slct.Where) {
+		if exp.Type != parser.BinaryType {
+			continue
+		}
+
+		op := exp.Binary.Op.Value
+		if _, ok := flipped[op]; !ok {
+			continue
+		}
+
+		for _, sides := range [][2]*parser.Expression{{&exp.Binary.A, &exp.Binary.B}, {&exp.Binary.B, &exp.Binary.A}} {
+			key, value := sides[0], sides[1]
+			if ev.refs(t, key) != innerRefs || !stable(key) || !rowOnly(key) || comparedByMore(t, key) ||
+				(&evaluation{table: t}).columnType(key).IsArray() || ev.refs(t, value) != 0 || !stable(value) {
+				continue
+			}
+
+			target := key.String()
+			if seen[target] {
+				break
+			}
+			seen[target] = true
+			ev.mb.workload.record(slct.From.Value, target, key, op == "=")
+			break
+		}
+	}
+}
+
+func (w *workload) record(table, target string, exp *parser.Expression, equality bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	k := candidateKey{table: table, target: target}
+	c, ok := w.candidates[k]
+	if !ok {
+		if len(w.candidates) >= workloadCandidates {
+			return
+		}
+		if w.candidates == nil {
+			w.candidates = map[candidateKey]*indexCandidate{}
+		}
+		c = &indexCandidate{exp: exp}
+		w.candidates[k] = c
+	}
+
+	if equality {
+		c.equalities++
+	} else {
+		c.ranges++
+	}
+}
+
+// recommendation is an index RECOMMEND INDEXES suggests.
+type recommendation struct {
+	table, target string
+	exp           *parser.Expression
+	queries       int64
+	saved         int64
+}
+
+// RecommendIndexes suggests indexes for the columns and expressions that
+// queries scanning whole tables compared with values since the database
+// was opened, most beneficial first. The benefit is estimated as the rows
+// the queries would have skipped if the index was there, counted over the
+// rows the tables hold now: an equality reads the rows with one of the
+// distinct values, a range a third of the rows. Candidates already
+// indexed, or that would save nothing, aren't suggested.
+func (mb *MemoryBackend) RecommendIndexes() (*Results, error) {
+	mb.workload.mu.Lock()
+	candidates := make(map[candidateKey]indexCandidate, len(mb.workload.candidates))
+	for k, c := range mb.workload.candidates {
+		candidates[k] = *c
+	}
+	mb.workload.mu.Unlock()
+
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	recommended := []recommendation{}
+	for k, c := range candidates {
+		t, ok := mb.tables[k.table]
+		if !ok || t.view != nil || t.external != nil || indexed(t, c.exp) {
+			continue
+		}
+
+		saved, ok := estimateSaved(t, &c)
+		if !ok || saved <= 0 {
+			continue
+		}
+
+		recommended = append(recommended, recommendation{
+			table:   k.table,
+			target:  k.target,
+			exp:     c.exp,
+			queries: c.equalities + c.ranges,
+			saved:   saved,
+		})
+	}
+
+	sort.Slice(recommended, func(i, j int) bool {
+		a, b := recommended[i], recommended[j]
+		if a.saved != b.saved {
+			return a.saved > b.saved
+		}
+		if a.table != b.table {
+			return a.table < b.table
+		}
+		return a.target < b.target
+	})
+
+	results := &Results{Columns: []ResultColumn{
+		{Type: TextType, Name: "table_name"},
+		{Type: TextType, Name: "target"},
+		{Type: IntType, Name: "queries"},
+		{Type: IntType, Name: "estimated_rows_saved"},
+		{Type: TextType, Name: "statement"},
+	}}
+	for _, r := range recommended {
+		target := r.target
+		if r.exp.Type != parser.ColumnRefType && r.exp.Type != parser.CallType {
+			target = "(" + target + ")"
+		}
+
+		results.Rows = append(results.Rows, []interface{}{
+			r.table, r.target, r.queries, r.saved,
+			"CREATE INDEX ON " + parser.FormatIdentifier(r.table) + " (" + target + ")",
+		})
+	}
+
+	return results, nil
+}
+
+// indexed reports whether t has an index over every row over exp.
+func indexed(t *memoryTable, exp *parser.Expression) bool {
+	for _, idx := range t.indexes {
+		if !idx.live || idx.where != nil {
+			continue
+		}
+
+		if exp.Type == parser.ColumnRefType {
+			if idx.expression == nil && idx.column == exp.Column.Value {
+				return true
+			}
+		} else if idx.expression != nil && idx.expression.String() == exp.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// estimateSaved estimates the rows of t the queries comparing c would have
+// skipped with an index over it, returning false if c can't be computed
+// from the rows of t anymore, like after its column was dropped.
+func estimateSaved(t *memoryTable, c *indexCandidate) (int64, bool) {
+	rows := float64(t.store.Len())
+	distinct := map[string]bool{}
+	nulls := 0
+
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		v, err := (&evaluation{table: t, row: row}).eval(c.exp)
+		if err != nil {
+			return 0, false
+		}
+
+		if v == nil {
+			nulls++
+			continue
+		}
+		distinct[statisticsKey(v)] = true
+	}
+	if scan.Err() != nil {
+		return 0, false
+	}
+
+	read := 0.0
+	if len(distinct) > 0 {
+		read = (rows - float64(nulls)) / float64(len(distinct))
+	}
+
+	saved := float64(c.equalities)*(rows-read) + float64(c.ranges)*rows*(1-conjunctSelectivity)
+	return int64(math.Round(saved)), true
+}
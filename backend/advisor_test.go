@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestRecommendIndexes checks the indexes suggested for what queries
+// scanning t compared, and that nothing is suggested once it is indexed or
+// can no longer be.
+func TestRecommendIndexes(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		rows    [][]interface{}
+	}{
+		{
+			"equalities and ranges",
+			[]string{
+				"select id from t where id = 1",
+				"select id from t where id = $1",
+				"select * from t where 1 < score",
+			},
+			[][]interface{}{
+				{"t", "id", int64(2), int64(4), "CREATE INDEX ON t (id)"},
+				{"t", "score", int64(1), int64(2), "CREATE INDEX ON t (score)"},
+			},
+		},
+		{
+			"expressions",
+			[]string{
+				"select id from t where lower(name) = 'a'",
+				"select id from t where id + 1 = 3",
+			},
+			[][]interface{}{
+				{"t", "id + 1", int64(1), int64(2), "CREATE INDEX ON t ((id + 1))"},
+				{"t", "lower(name)", int64(1), int64(2), "CREATE INDEX ON t (lower(name))"},
+			},
+		},
+		{
+			// Each column counts once a query, the NULL score is never read
+			"conjuncts",
+			[]string{
+				"select id from t where score = 1",
+				"select id from t where name = 'x' and id > 0 and id = 1",
+			},
+			[][]interface{}{
+				{"t", "id", int64(1), int64(2), "CREATE INDEX ON t (id)"},
+				{"t", "name", int64(1), int64(2), "CREATE INDEX ON t (name)"},
+				{"t", "score", int64(1), int64(2), "CREATE INDEX ON t (score)"},
+			},
+		},
+		{
+			"already indexed",
+			[]string{"create index t_id on t (id)", "select id from t where id = 1"},
+			nil,
+		},
+		{
+			// A partial index doesn't hold every row
+			"partially indexed",
+			[]string{"create index t_p on t (id) where score > 1", "select id from t where id = 1"},
+			[][]interface{}{{"t", "id", int64(1), int64(2), "CREATE INDEX ON t (id)"}},
+		},
+		{
+			"indexed afterwards",
+			[]string{"select id from t where id = 1", "create index t_id on t (id)"},
+			nil,
+		},
+		{
+			"column dropped",
+			[]string{"select id from t where id = 1", "alter table t drop column id"},
+			nil,
+		},
+		{
+			"nothing to look up",
+			[]string{
+				"select id from t where id = score",
+				"select id from t where id = random()",
+				"select id from t where id in (1, 2)",
+				"select id from t where id = 1 or id = 2",
+			},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+			for _, query := range tt.queries {
+				if _, err := run(mb, session, query, int64(2)); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			results, err := run(mb, session, "recommend indexes")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"fmt"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+)
+
+// aggregateCalls returns the calls to aggregate functions in exps, leaving
+// out the ones in subqueries, which aggregate on their own.
+func aggregateCalls(exps ...*parser.Expression) []*parser.CallExpression {
+	calls := []*parser.CallExpression{}
+
+	var walk func(exp *parser.Expression)
+	walk = func(exp *parser.Expression) {
+		switch exp.Type {
+		case parser.CallType:
+			if f, ok := functions.Lookup(exp.Call.Name.Value); ok && f.Aggregate != nil {
+				calls = append(calls, exp.Call)
+				return
+			}
+			for i := range exp.Call.Args {
+				walk(&exp.Call.Args[i])
+			}
+		case parser.BinaryType:
+			walk(&exp.Binary.A)
+			walk(&exp.Binary.B)
+		case parser.CastType:
+			walk(&exp.Cast.Exp)
+		case parser.IndexType:
+			walk(&exp.Index.Exp)
+			walk(&exp.Index.Index)
+		case parser.ArrayType:
+			for i := range exp.Array {
+				walk(&exp.Array[i])
+			}
+		case parser.RowType:
+			for i := range exp.Row {
+				walk(&exp.Row[i])
+			}
+		case parser.InType:
+			walk(&exp.In.Exp)
+			for i := range exp.In.List {
+				walk(&exp.In.List[i])
+			}
+		case parser.NotType:
+			walk(exp.Not)
+		}
+	}
+
+	for _, exp := range exps {
+		if exp != nil {
+			walk(exp)
+		}
+	}
+
+	return calls
+}
+
+// isAggregate reports whether slct aggregates its rows into a single one.
+func isAggregate(slct *parser.SelectStatement) bool {
+	for _, item := range slct.Item {
+		if !item.Asterisk && len(aggregateCalls(item.Exp)) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aggregation is the state of the aggregate functions of a query's items
+// over the rows added to it so far.
+type aggregation struct {
+	exps  []*parser.Expression
+	calls []*parser.CallExpression
+	aggs  []functions.Aggregator
+}
+
+func newAggregation(slct *parser.SelectStatement) (*aggregation, error) {
+	agg := aggregation{}
+	for _, item := range slct.Item {
+		if !item.Asterisk {
+			agg.exps = append(agg.exps, item.Exp)
+		}
+	}
+
+	agg.calls = aggregateCalls(agg.exps...)
+	agg.aggs = make([]functions.Aggregator, len(agg.calls))
+	for i, call := range agg.calls {
+		f, ok := functions.Lookup(call.Name.Value)
+		if !ok || f.Aggregate == nil {
+			return nil, fmt.Errorf("Function %s does not exist", call.Name.Value)
+		}
+		agg.aggs[i] = f.Aggregate()
+	}
+
+	return &agg, nil
+}
+
+// aggregate adds rows of t to agg and returns the items evaluated with the
+// results of their aggregate functions.
+func (ev *evaluation) aggregate(agg *aggregation, t *memoryTable, rows [][]interface{}) ([]interface{}, error) {
+	exps, calls, aggs := agg.exps, agg.calls, agg.aggs
+	for _, row := range rows {
+		sub := ev.sub(t, row)
+		for i, call := range calls {
+			args := make([]interface{}, len(call.Args))
+			for j := range call.Args {
+				v, err := sub.eval(&call.Args[j])
+				if err != nil {
+					return nil, err
+				}
+				args[j] = v
+			}
+
+			if err := aggs[i].Step(args); err != nil {
+				return nil, fmt.Errorf("%s: %w", call.Name.Value, err)
+			}
+		}
+	}
+
+	// The items are evaluated once, outside of any row
+	final := ev.sub(nil, nil)
+	final.aggregates = map[*parser.CallExpression]interface{}{}
+	for i, call := range calls {
+		v, err := aggs[i].Result()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", call.Name.Value, err)
+		}
+		final.aggregates[call] = v
+	}
+
+	values := make([]interface{}, len(exps))
+	for i, exp := range exps {
+		v, err := final.eval(exp)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
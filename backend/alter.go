@@ -0,0 +1,412 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+// DropTable drops a table or a materialized view with its indexes, policies
+// and statistics. Materialized views reading it are dropped with it with
+// CASCADE, and so are the foreign keys of other tables referencing it,
+// otherwise they keep it from being dropped. Only the superuser may
+// drop a table with policies or masks, see checkProtected.
+func (mb *MemoryBackend) DropTable(drop *parser.DropTableStatement, session *functions.Session) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if isSystemTable(drop.Name.Value) {
+		return ErrSystemTable
+	}
+
+	t, ok := mb.tables[drop.Name.Value]
+	if !ok {
+		return ErrTableDoesNotExist
+	}
+
+	switch {
+	case drop.View && t.view == nil:
+		return fmt.Errorf("%w: %s", ErrNotMaterializedView, drop.Name.Value)
+	case !drop.View && t.view != nil:
+		return fmt.Errorf("%w: %s, use DROP MATERIALIZED VIEW", ErrMaterializedView, drop.Name.Value)
+	}
+
+	if err := checkProtected(session, t, "drop"); err != nil {
+		return err
+	}
+
+	if err := mb.dropReferences(drop.Name.Value, drop.Cascade); err != nil {
+		return err
+	}
+
+	if err := mb.dropReaders(drop.Name.Value, drop.Cascade); err != nil {
+		return err
+	}
+
+	// External tables leave their files alone and have no rows stored
+	if t.external == nil {
+		if err := mb.engine.DropTable(drop.Name.Value); err != nil {
+			return err
+		}
+	}
+
+	delete(mb.tables, drop.Name.Value)
+	mb.changed(drop.Name.Value)
+	return nil
+}
+
+// AlterTable changes the columns of a table. Engines store rows as they are
+// given and never modify them, so the table is rebuilt: every row is copied
+// with the change applied into a new table replacing the old one. The rows
+// are copied as a job listed in __jobs without holding the backend locked,
+// and canceling ctx stops the copy, leaving the table as it was.
+func (mb *MemoryBackend) AlterTable(ctx context.Context, alter *parser.AlterTableStatement, session *functions.Session) error {
+	// Masks hide values from everyone without UNMASK, so only those who
+	// may grant it may change them
+	if alter.Mask != nil {
+		if err := checkSuperuser(session, "mask columns"); err != nil {
+			return err
+		}
+	}
+
+	name := alter.Table.Value
+	mb.mu.RLock()
+	t, ok := mb.tables[name]
+	mb.mu.RUnlock()
+	if ok {
+		if err := checkProtected(session, t, "alter"); err != nil {
+			return err
+		}
+	}
+
+	if alter.Rename != nil && alter.Rename.Column == nil {
+		return mb.renameTable(ctx, name, alter.Rename.To.Value)
+	}
+
+	t, altered, change, err := mb.prepareAlter(alter)
+	if err != nil || change == nil {
+		return err
+	}
+
+	// Every row is changed before the table is replaced, so a value that
+	// can't be cast leaves it as it was
+	rows, err := mb.copyRows(ctx, "ALTER TABLE", name, t, change)
+	if err != nil {
+		return err
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.tables[name] != t {
+		return fmt.Errorf("Table %s changed while it was being altered", name)
+	}
+
+	if err := mb.engine.DropTable(name); err != nil {
+		return err
+	}
+
+	store, err := mb.createStore(name, altered)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Insert(rows...); err != nil {
+		return err
+	}
+	altered.store = store
+	if altered.indexes, err = reindex(t, altered); err != nil {
+		return err
+	}
+
+	mb.tables[name] = altered
+	mb.changed(name)
+	return nil
+}
+
+// prepareAlter checks alter and returns the table it alters, the table it
+// becomes without its rows, and how each row changes. Alterations that
+// don't change the rows are made right away and return a nil change.
+func (mb *MemoryBackend) prepareAlter(alter *parser.AlterTableStatement) (*memoryTable, *memoryTable, func(row storage.Row) (storage.Row, error), error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name := alter.Table.Value
+	if isSystemTable(name) {
+		return nil, nil, nil, ErrSystemTable
+	}
+
+	t, ok := mb.tables[name]
+	if !ok {
+		return nil, nil, nil, ErrTableDoesNotExist
+	}
+
+	if t.view != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrMaterializedView, name)
+	}
+
+	if t.external != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrExternalTable, name)
+	}
+
+	// Views reading a column renamed are rewritten
+	if alter.Rename != nil {
+		return nil, nil, nil, mb.renameColumn(name, t, alter.Rename)
+	}
+
+	if alter.Constraint != nil {
+		if alter.Constraint.Unique != nil {
+			return nil, nil, nil, mb.addUnique(name, t, alter.Constraint)
+		}
+		return nil, nil, nil, mb.addForeignKey(name, t, alter.Constraint)
+	}
+
+	// Views can still read a table with a column added
+	if view, ok := mb.readBy(name); ok && alter.Add == nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s reads %s", ErrTableInUse, view, name)
+	}
+
+	if alter.Mask != nil {
+		return nil, nil, nil, mb.alterMask(name, t, alter.Mask)
+	}
+
+	altered := &memoryTable{
+		columns:     append([]string{}, t.columns...),
+		columnTypes: append([]ColumnType{}, t.columnTypes...),
+		collations:  append([]*types.Collation{}, t.collations...),
+		policies:    t.policies,
+		foreignKeys: t.foreignKeys,
+		uniques:     t.uniques,
+		masks:       t.masks,
+		comment:     t.comment,
+		comments:    t.comments,
+		compression: t.compression,
+		// The rows are rewritten, so the table has to be analyzed again
+		statistics: unanalyzed(t.statistics),
+	}
+	if t.identities != nil {
+		altered.identities = append([]*identity{}, t.identities...)
+	}
+
+	// change maps the values of a row to the values of the altered row
+	var change func(row storage.Row) (storage.Row, error)
+	switch {
+	case alter.Add != nil:
+		if _, ok := t.columnIndex(alter.Add.Name.Value); ok {
+			return nil, nil, nil, fmt.Errorf("Column %s already exists", alter.Add.Name.Value)
+		}
+
+		dt, collation, err := definitionType(alter.Add)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		id, err := newIdentity(alter.Add, dt)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		altered.columns = append(altered.columns, alter.Add.Name.Value)
+		altered.columnTypes = append(altered.columnTypes, dt)
+		altered.collations = append(altered.collations, collation)
+
+		// The column only holds NULL, which every foreign key allows, unless
+		// it is an identity column
+		if alter.Add.References != nil {
+			if id != nil {
+				return nil, nil, nil, fmt.Errorf("Identity column %s can't be added as a foreign key", alter.Add.Name.Value)
+			}
+
+			fk, err := mb.newForeignKey(name, altered, alter.Add.Name.Value, alter.Add.References)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			altered.foreignKeys = append(append([]*foreignKey{}, t.foreignKeys...), fk)
+		}
+
+		// So does every unique constraint, and identity values are unique
+		if alter.Add.Unique != nil {
+			u, err := newUnique(altered, alter.Add.Name.Value, alter.Add.Unique)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			altered.uniques = append(append([]*unique{}, t.uniques...), u)
+		}
+
+		if id == nil {
+			if altered.identities != nil {
+				altered.identities = append(altered.identities, nil)
+			}
+			change = func(row storage.Row) (storage.Row, error) {
+				return append(append(storage.Row{}, row...), nil), nil
+			}
+			break
+		}
+
+		// The rows the table holds are given values in the order they were
+		// inserted
+		if altered.identities == nil {
+			altered.identities = make([]*identity, len(t.columns))
+		}
+		altered.identities = append(altered.identities, id)
+		i := len(altered.identities) - 1
+		change = func(row storage.Row) (storage.Row, error) {
+			v, after, err := altered.identities[i].generate(alter.Add.Name.Value)
+			if err != nil {
+				return nil, err
+			}
+
+			altered.identities[i] = after
+			return append(append(storage.Row{}, row...), v), nil
+		}
+	case alter.Drop != nil:
+		i, ok := t.columnIndex(alter.Drop.Value)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, alter.Drop.Value)
+		}
+
+		for _, p := range t.policies {
+			if refersTo(p.using, alter.Drop.Value) {
+				return nil, nil, nil, fmt.Errorf("Column %s is used by policy %s", alter.Drop.Value, p.name)
+			}
+		}
+
+		for _, idx := range t.indexes {
+			if idx.column == alter.Drop.Value || (idx.expression != nil && refersTo(idx.expression, alter.Drop.Value)) ||
+				(idx.where != nil && refersTo(idx.where, alter.Drop.Value)) {
+				return nil, nil, nil, fmt.Errorf("Column %s is used by index %s", alter.Drop.Value, idx.name)
+			}
+		}
+
+		for _, s := range t.statistics {
+			for _, col := range s.columns {
+				if col == alter.Drop.Value {
+					return nil, nil, nil, fmt.Errorf("Column %s is used by statistics %s", alter.Drop.Value, s.name)
+				}
+			}
+		}
+
+		if refs := mb.referencing(name, alter.Drop.Value); len(refs) > 0 {
+			return nil, nil, nil, fmt.Errorf("Column %s is referenced by foreign key %s", alter.Drop.Value, refs[0])
+		}
+
+		// A foreign key is dropped with its column
+		if t.foreignKeyOf(alter.Drop.Value) != nil {
+			altered.foreignKeys = []*foreignKey{}
+			for _, fk := range t.foreignKeys {
+				if fk.column != alter.Drop.Value {
+					altered.foreignKeys = append(altered.foreignKeys, fk)
+				}
+			}
+		}
+
+		if t.uniqueOf(alter.Drop.Value) != nil {
+			altered.uniques = []*unique{}
+			for _, u := range t.uniques {
+				if u.column != alter.Drop.Value {
+					altered.uniques = append(altered.uniques, u)
+				}
+			}
+		}
+
+		if t.masks[alter.Drop.Value] != nil {
+			altered.masks = map[string]*columnMask{}
+			for col, m := range t.masks {
+				if col != alter.Drop.Value {
+					altered.masks[col] = m
+				}
+			}
+		}
+
+		if _, ok := t.comments[alter.Drop.Value]; ok {
+			altered.comments = map[string]string{}
+			for col, c := range t.comments {
+				if col != alter.Drop.Value {
+					altered.comments[col] = c
+				}
+			}
+		}
+
+		altered.columns = append(altered.columns[:i], altered.columns[i+1:]...)
+		altered.columnTypes = append(altered.columnTypes[:i], altered.columnTypes[i+1:]...)
+		altered.collations = append(altered.collations[:i], altered.collations[i+1:]...)
+		if altered.identities != nil {
+			altered.identities = append(altered.identities[:i], altered.identities[i+1:]...)
+		}
+		change = func(row storage.Row) (storage.Row, error) {
+			return append(append(storage.Row{}, row[:i]...), row[i+1:]...), nil
+		}
+	case alter.Alter != nil:
+		i, ok := t.columnIndex(alter.Alter.Name.Value)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, alter.Alter.Name.Value)
+		}
+
+		dt, collation, err := definitionType(alter.Alter)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if t.identityOf(i) != nil && dt != IntType {
+			return nil, nil, nil, fmt.Errorf("%w: identity column %s must be int, not %s",
+				ErrInvalidDatatype, alter.Alter.Name.Value, dt)
+		}
+
+		for _, idx := range t.indexes {
+			// The keys of an expression index could change type or fail to
+			// compute, and so could the predicate of a partial index
+			if idx.expression != nil && refersTo(idx.expression, alter.Alter.Name.Value) {
+				return nil, nil, nil, fmt.Errorf("Column %s is used by index %s on %s",
+					alter.Alter.Name.Value, idx.name, idx.expression)
+			}
+			if idx.where != nil && refersTo(idx.where, alter.Alter.Name.Value) {
+				return nil, nil, nil, fmt.Errorf("Column %s is used by index %s where %s",
+					alter.Alter.Name.Value, idx.name, idx.where)
+			}
+
+			if idx.column == alter.Alter.Name.Value && dt.IsArray() {
+				return nil, nil, nil, fmt.Errorf("%w: column %s is used by index %s, arrays can't be indexed",
+					ErrInvalidDatatype, idx.column, idx.name)
+			}
+		}
+
+		// A foreign key has the type of the column it references
+		if fk := t.foreignKeyOf(alter.Alter.Name.Value); fk != nil && dt != t.columnTypes[i] {
+			return nil, nil, nil, fmt.Errorf("Column %s is foreign key %s", alter.Alter.Name.Value, reference{table: name, fk: fk})
+		}
+		if refs := mb.referencing(name, alter.Alter.Name.Value); len(refs) > 0 && dt != t.columnTypes[i] {
+			return nil, nil, nil, fmt.Errorf("Column %s is referenced by foreign key %s", alter.Alter.Name.Value, refs[0])
+		}
+		// Casting could make values that differ equal
+		if u := t.uniqueOf(alter.Alter.Name.Value); u != nil && dt != t.columnTypes[i] {
+			return nil, nil, nil, fmt.Errorf("Column %s is unique", alter.Alter.Name.Value)
+		}
+
+		if m := t.masks[alter.Alter.Name.Value]; m != nil {
+			if _, err := newColumnMask(m.function, dt); err != nil {
+				return nil, nil, nil, fmt.Errorf("Column %s is masked: %w", alter.Alter.Name.Value, err)
+			}
+		}
+
+		altered.columnTypes[i] = dt
+		altered.collations[i] = collation
+		change = func(row storage.Row) (storage.Row, error) {
+			v, err := types.Cast(row[i], dt)
+			if err != nil {
+				return nil, fmt.Errorf("%w: column %s: %s", ErrInvalidDatatype, alter.Alter.Name.Value, err)
+			}
+
+			changed := append(storage.Row{}, row...)
+			changed[i] = v
+			return changed, nil
+		}
+	default:
+		return nil, nil, nil, nil
+	}
+
+	return t, altered, change, nil
+}
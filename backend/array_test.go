@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/types"
+)
+
+func TestArrays(t *testing.T) {
+	mb := NewMemoryBackend()
+	session := functions.NewSession(mb)
+	if _, err := run(mb, session, `create table a (id int, tags text[], ns int[]);
+		insert into a values (1, '{x,"y z",NULL}', array[1, 2, 3]);
+		insert into a values (2, '{}', null)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := types.Array{Elem: types.Text, Values: []interface{}{"x", "y z", nil}}
+	tests := []struct {
+		query string
+		rows  [][]interface{}
+	}{
+		{"select tags from a where id = 1", [][]interface{}{{tags}}},
+		// Elements are indexed from one, out of range indexes are NULL
+		{"select tags[1], tags[2], tags[3], ns[0], ns[4] from a where id = 1", [][]interface{}{{"x", "y z", nil, nil, nil}}},
+		{"select ns[1] from a where id = 2", [][]interface{}{{nil}}},
+		{"select array_length(tags, 1), cardinality(tags) from a", [][]interface{}{{int64(3), int64(3)}, {nil, int64(0)}}},
+		{"select id from a where ns = '{1,2,3}'", [][]interface{}{{int64(1)}}},
+		{"select id from a where tags = array['x', 'y z', null]", [][]interface{}{{int64(1)}}},
+		// UNNEST makes a row of every element, and none of empty arrays
+		{"select unnest(ns) from a", [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}},
+		{"select id, unnest(tags) from a", [][]interface{}{{int64(1), "x"}, {int64(1), "y z"}, {int64(1), nil}}},
+		{"select array[id, id * 2] from a where id = 2", [][]interface{}{{types.Array{Elem: types.Int, Values: []interface{}{int64(2), int64(4)}}}}},
+	}
+
+	for _, tt := range tests {
+		results, err := run(mb, session, tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if !reflect.DeepEqual(results.Rows, tt.rows) {
+			t.Errorf("%s: got %v, want %v", tt.query, results.Rows, tt.rows)
+		}
+	}
+
+	if _, err := run(mb, session, "insert into a values (3, '{1,', null)"); !errors.Is(err, ErrInvalidDatatype) {
+		t.Errorf("got %v inserting a malformed array, want %v", err, ErrInvalidDatatype)
+	}
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"context"
+	"strings"
+)
+
+type attachedKey struct{}
+
+// WithAttached returns a context whose statements can read the tables of
+// the backends in attached as alias.table, where alias is the key of the
+// backend. The caller keeps the attached backends from being written while
+// the statements run.
+func WithAttached(ctx context.Context, attached map[string]*MemoryBackend) context.Context {
+	return context.WithValue(ctx, attachedKey{}, attached)
+}
+
+// SplitAttached splits name into the alias of an attached database and the
+// name of the table in it, or returns false if name isn't written like one.
+func SplitAttached(name string) (alias string, table string, ok bool) {
+	i := strings.IndexByte(name, '.')
+	if i <= 0 || i == len(name)-1 {
+		return "", "", false
+	}
+
+	return name[:i], name[i+1:], true
+}
+
+// attachedTable returns the table called name of a database attached to
+// the session of ctx.
+func attachedTable(ctx context.Context, name string) (*memoryTable, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+
+	attached, _ := ctx.Value(attachedKey{}).(map[string]*MemoryBackend)
+	alias, table, ok := SplitAttached(name)
+	if !ok || attached[alias] == nil {
+		return nil, false
+	}
+
+	return attached[alias].table(table)
+}
+
+// lookupTable is table that also finds the tables of the databases attached
+// to the session of ctx. It must be called with mb.mu held.
+func (mb *MemoryBackend) lookupTable(ctx context.Context, name string) (*memoryTable, bool) {
+	if t, ok := mb.table(name); ok {
+		return t, true
+	}
+
+	return attachedTable(ctx, name)
+}
@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/trace"
+	"github.com/nireo/sgsql/types"
+)
+
+// ColumnType is the type of values stored in a column or produced by an
+// expression.
+type ColumnType = types.Type
+
+const (
+	TextType  = types.Text
+	IntType   = types.Int
+	BoolType  = types.Bool
+	FloatType = types.Float
+
+	TimestampType = types.Timestamp
+	IntervalType  = types.Interval
+	UUIDType      = types.UUID
+)
+
+// ResultColumn describes a single column of a result set.
+type ResultColumn struct {
+	Type ColumnType
+	Name string
+}
+
+// Results holds the columns and rows produced by a statement. Each cell is
+// nil for NULL, or an int64, float64, string, bool, time.Time,
+// types.IntervalValue or types.UUIDValue.
+type Results struct {
+	Columns []ResultColumn
+	Rows    [][]interface{}
+}
+
+// Column is a column of a table. Collation is empty for the default.
+// Identity is "always" or "by default" for identity columns, see
+// parser.ColumnIdentity, and empty for the others. References is the
+// column a foreign key references, and empty for the others.
+type Column struct {
+	Name       string
+	Type       ColumnType
+	Collation  string
+	Identity   string
+	References Reference
+	// Unique is set for unique columns, and UniqueDeferred when they are
+	// only checked once the transaction commits
+	Unique         bool
+	UniqueDeferred bool
+	Comment        string
+}
+
+// Reference is the column called Column of the table called Table.
+// Deferred is set when the foreign key is only checked once the
+// transaction commits.
+type Reference struct {
+	Table    string
+	Column   string
+	Deferred bool
+}
+
+// Catalog describes the tables a backend holds.
+type Catalog interface {
+	// Columns returns the columns of table in order, or false if there is
+	// no such table.
+	Columns(table string) ([]Column, bool)
+	// Tables returns the names of all tables.
+	Tables() []string
+}
+
+// TableKind tells tables CREATE TABLE describes from the others.
+type TableKind int
+
+const (
+	PlainTable TableKind = iota
+	MaterializedView
+	ExternalTable
+)
+
+// KindCatalog is a Catalog that knows what kind of table each of its tables
+// is.
+type KindCatalog interface {
+	Catalog
+	// Kind returns the kind of table, and the tables it reads when it is a
+	// materialized view.
+	Kind(table string) (TableKind, []string)
+}
+
+// Backend executes parsed statements. Params are bound to the $1..$n
+// placeholders in the order given, and functions keep their state in the
+// session, which may be nil. Queries are traced with the tracer of their
+// context, see package trace.
+type Backend interface {
+	CreateTable(*parser.CreateTableStatement, *functions.Session) error
+	DropTable(*parser.DropTableStatement, *functions.Session) error
+	AlterTable(context.Context, *parser.AlterTableStatement, *functions.Session) error
+	Insert(*parser.InsertStatement, *functions.Session, []interface{}) error
+	Select(context.Context, *parser.SelectStatement, *functions.Session, []interface{}) (*Results, error)
+	CreateSequence(*parser.CreateSequenceStatement) error
+	CreateMaterializedView(context.Context, *parser.CreateMaterializedViewStatement, *functions.Session) error
+	RefreshMaterializedView(context.Context, *parser.RefreshStatement, *functions.Session) error
+	CreatePolicy(*parser.CreatePolicyStatement, *functions.Session) error
+	DropPolicy(*parser.DropPolicyStatement, *functions.Session) error
+	Grant(*parser.GrantStatement, *functions.Session) error
+	CreateRole(*parser.CreateRoleStatement, *functions.Session) error
+	DropRole(*parser.DropRoleStatement, *functions.Session) error
+	CreateIndex(context.Context, *parser.CreateIndexStatement, *functions.Session) error
+	DropIndex(*parser.DropIndexStatement, *functions.Session) error
+	CreateStatistics(*parser.CreateStatisticsStatement) error
+	DropStatistics(*parser.DropStatisticsStatement) error
+	Analyze(context.Context, *parser.AnalyzeStatement) error
+	Flashback(context.Context, *parser.FlashbackStatement, *functions.Session, []interface{}) error
+	Explain(context.Context, *parser.ExplainStatement) (*Results, error)
+	Show(*parser.ShowStatement, *functions.Session) (*Results, error)
+	RecommendIndexes() (*Results, error)
+	Comment(*parser.CommentStatement) error
+}
+
+var (
+	ErrTableDoesNotExist  = errors.New("Table does not exist")
+	ErrTableAlreadyExists = errors.New("Table already exists")
+	ErrColumnDoesNotExist = errors.New("Column does not exist")
+	ErrInvalidDatatype    = errors.New("Invalid datatype")
+	ErrMissingValues      = errors.New("Missing values")
+	ErrInvalidOperands    = types.ErrInvalidOperands
+	ErrMissingParameter   = errors.New("Missing value for parameter")
+	ErrDivisionByZero     = types.ErrDivisionByZero
+)
+
+// Exec optimizes a single statement and runs it against b. Statements that
+// don't produce rows return empty results.
+func Exec(ctx context.Context, b Backend, stmt *parser.Statement, session *functions.Session, params []interface{}) (*Results, error) {
+	_, span := trace.Start(ctx, "sgsql.optimize")
+	Optimize(stmt)
+	span.End()
+
+	switch stmt.Type {
+	case parser.CreateTableType:
+		return &Results{}, b.CreateTable(stmt.CreateTableStatement, session)
+	case parser.DropTableType:
+		return &Results{}, b.DropTable(stmt.DropTableStatement, session)
+	case parser.AlterTableType:
+		return &Results{}, b.AlterTable(ctx, stmt.AlterTableStatement, session)
+	case parser.InsertType:
+		return &Results{}, b.Insert(stmt.InsertStatement, session, params)
+	case parser.SelectType:
+		return b.Select(ctx, stmt.SelectStatement, session, params)
+	case parser.CreateSequenceType:
+		return &Results{}, b.CreateSequence(stmt.CreateSequenceStatement)
+	case parser.CreateMaterializedViewType:
+		return &Results{}, b.CreateMaterializedView(ctx, stmt.CreateMaterializedViewStatement, session)
+	case parser.RefreshType:
+		return &Results{}, b.RefreshMaterializedView(ctx, stmt.RefreshStatement, session)
+	case parser.CreatePolicyType:
+		return &Results{}, b.CreatePolicy(stmt.CreatePolicyStatement, session)
+	case parser.DropPolicyType:
+		return &Results{}, b.DropPolicy(stmt.DropPolicyStatement, session)
+	case parser.GrantType:
+		return &Results{}, b.Grant(stmt.GrantStatement, session)
+	case parser.CreateRoleType:
+		return &Results{}, b.CreateRole(stmt.CreateRoleStatement, session)
+	case parser.DropRoleType:
+		return &Results{}, b.DropRole(stmt.DropRoleStatement, session)
+	case parser.CreateIndexType:
+		return &Results{}, b.CreateIndex(ctx, stmt.CreateIndexStatement, session)
+	case parser.DropIndexType:
+		return &Results{}, b.DropIndex(stmt.DropIndexStatement, session)
+	case parser.CreateStatisticsType:
+		return &Results{}, b.CreateStatistics(stmt.CreateStatisticsStatement)
+	case parser.DropStatisticsType:
+		return &Results{}, b.DropStatistics(stmt.DropStatisticsStatement)
+	case parser.AnalyzeType:
+		return &Results{}, b.Analyze(ctx, stmt.AnalyzeStatement)
+	case parser.FlashbackType:
+		return &Results{}, b.Flashback(ctx, stmt.FlashbackStatement, session, params)
+	case parser.ExplainType:
+		return b.Explain(ctx, stmt.ExplainStatement)
+	case parser.ShowType:
+		return b.Show(stmt.ShowStatement, session)
+	case parser.RecommendIndexesType:
+		return b.RecommendIndexes()
+	case parser.CommentType:
+		return &Results{}, b.Comment(stmt.CommentStatement)
+	}
+
+	return nil, errors.New("Unsupported statement")
+}
@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+// run runs every statement of query against mb and returns the results of
+// the last one.
+func run(mb *MemoryBackend, session *functions.Session, query string, params ...interface{}) (*Results, error) {
+	ast, err := parser.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{}
+	for _, stmt := range ast.Statements {
+		if results, err = Exec(context.Background(), mb, stmt, session, params); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// testBackend returns a backend holding the table t.
+func testBackend(t *testing.T) (*MemoryBackend, *functions.Session) {
+	t.Helper()
+
+	mb := NewMemoryBackend()
+	session := functions.NewSession(mb)
+	for _, query := range []string{
+		"create table t (id int, name text, score float)",
+		"insert into t values (1, 'a', 1.5)",
+		"insert into t values (2, 'b', null)",
+		"insert into t values (3, 'c', 4.5)",
+	} {
+		if _, err := run(mb, session, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	return mb, session
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		query  string
+		params []interface{}
+		rows   [][]interface{}
+	}{
+		{"select id, name from t where id = 2", nil, [][]interface{}{{int64(2), "b"}}},
+		{"select id from t where id > $1", []interface{}{int64(1)}, [][]interface{}{{int64(2)}, {int64(3)}}},
+		{"select id from t where coalesce(score, 0) = 0", nil, [][]interface{}{{int64(2)}}},
+		{"select id * 10 + 1 from t where name = 'c'", nil, [][]interface{}{{int64(31)}}},
+		{"select count(*), sum(score) from t", nil, [][]interface{}{{int64(3), 6.0}}},
+		{"select id from t where id = (select max(id) from t)", nil, [][]interface{}{{int64(3)}}},
+		{"select 1 where false", nil, nil},
+		{"select -9223372036854775808, -9223372036854775807 - 1", nil, [][]interface{}{{int64(math.MinInt64), int64(math.MinInt64)}}},
+		{"select extract(month from timestamp '2024-03-01' + interval '1 month 2 days'), date_trunc('year', '2024-03-15'::timestamp)", nil,
+			[][]interface{}{{4.0, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+		{"create index t_name on t (name); select id from t where name = 'a'", nil, [][]interface{}{{int64(1)}}},
+		{"alter table t add column flag bool; select flag from t where id = 1", nil, [][]interface{}{{nil}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb, session := testBackend(t)
+
+			results, err := run(mb, session, tt.query, tt.params...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   error
+	}{
+		{"select * from missing", ErrTableDoesNotExist},
+		{"create table t (id int)", ErrTableAlreadyExists},
+		{"drop table missing", ErrTableDoesNotExist},
+		{"select id / 0 from t", ErrDivisionByZero},
+		{"select id from t where id = $1", ErrMissingParameter},
+		{"insert into __tables values (1)", ErrSystemTable},
+		{"select abs(-9223372036854775808)", types.ErrOutOfRange},
+		{"select -9223372036854775808 - 1", types.ErrOutOfRange},
+		{"select id + 9223372036854775807 from t", types.ErrOutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb, session := testBackend(t)
+
+			if _, err := run(mb, session, tt.query); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	mb, session := testBackend(t)
+
+	snapshot := mb.Snapshot()
+	for _, query := range []string{
+		"insert into t values (4, 'd', 0.5)",
+		"create table u (id int)",
+		"drop table t",
+	} {
+		if _, err := run(mb, session, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	mb.Restore(snapshot)
+
+	results, err := run(mb, session, "select count(*) from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := results.Rows[0][0]; n != int64(3) {
+		t.Errorf("t holds %v rows after restoring, want 3", n)
+	}
+	if _, err := run(mb, session, "select * from u"); !errors.Is(err, ErrTableDoesNotExist) {
+		t.Errorf("u after restoring: got %v, want %v", err, ErrTableDoesNotExist)
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	tests := []struct {
+		query string
+		typ   ColumnType
+	}{
+		{"select 1 in (1, null)", BoolType},
+		{"select 2 in (1, null)", BoolType},
+		{"select 2 not in (1, null)", BoolType},
+		{"select null in (1)", BoolType},
+		{"select not (2 in (null))", BoolType},
+		{"select 1 + null", IntType},
+		{"select id in (1, null) from t", BoolType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb, session := testBackend(t)
+
+			results, err := run(mb, session, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ := results.Columns[0].Type; typ != tt.typ {
+				t.Errorf("got %v, want %v", typ, tt.typ)
+			}
+		})
+	}
+}
+
+func TestNullsAndFloats(t *testing.T) {
+	tests := []struct {
+		query string
+		rows  [][]interface{}
+	}{
+		{"select null and false, null and true, null or true, null or false", [][]interface{}{{false, nil, true, nil}}},
+		{"select 1.5 + 1, 3 / 2.0, 0.5 < 1", [][]interface{}{{2.5, 1.5, true}}},
+		{"select null = null, 1 + null", [][]interface{}{{nil, nil}}},
+		{"select id from t where score > 1 and score < 2", [][]interface{}{{int64(1)}}},
+		{"select id from t where score > 2 or name = 'b'", [][]interface{}{{int64(2)}, {int64(3)}}},
+		{"select id from t where not (score > 2)", [][]interface{}{{int64(1)}}},
+		{"insert into t values (null, null, null); select id, name, score from t where coalesce(id, 0) = 0", [][]interface{}{{nil, nil, nil}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb, session := testBackend(t)
+
+			results, err := run(mb, session, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
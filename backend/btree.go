@@ -0,0 +1,325 @@
+package backend
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+// btreeMin is the fewest entries a node of a B-tree other than its root
+// holds. Nodes hold at most btreeMax entries, and a node that isn't a leaf
+// has one child more than it has entries.
+const (
+	btreeMin = 31
+	btreeMax = 2*btreeMin + 1
+)
+
+// indexEntry is a value of an indexed column and the id of the row holding
+// it. Rows holding the same value are ordered by their ids, so every entry
+// is unique.
+type indexEntry struct {
+	key interface{}
+	id  storage.RowID
+}
+
+func (e indexEntry) less(other indexEntry) bool {
+	if c := compareKeys(e.key, other.key); c != 0 {
+		return c < 0
+	}
+
+	return e.id < other.id
+}
+
+// compareKeys orders the values of an indexed column. Keys of an index all
+// have the type of its column, see indexKey, and are never NULL. Numbers
+// compare like SQL compares them, with NaN before every other number, and
+// false comes before true.
+func compareKeys(a, b interface{}) int {
+	switch a := a.(type) {
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b || (math.IsNaN(a) && !math.IsNaN(b)):
+			return -1
+		case a > b || (math.IsNaN(b) && !math.IsNaN(a)):
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		switch b := b.(bool); {
+		case a == b:
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+		return 0
+	case types.UUIDValue:
+		b := b.(types.UUIDValue)
+		return bytes.Compare(a[:], b[:])
+	}
+
+	// Intervals compare with months taken as 30 days
+	if lt, _ := types.Apply("<", a, b); lt == true {
+		return -1
+	}
+	if gt, _ := types.Apply(">", a, b); gt == true {
+		return 1
+	}
+	return 0
+}
+
+// btree holds the entries of an index in order.
+type btree struct {
+	root *btreeNode
+	len  int
+}
+
+type btreeNode struct {
+	entries []indexEntry
+	// children is nil for leaves, children[i] holds the entries between
+	// entries[i-1] and entries[i]
+	children []*btreeNode
+}
+
+func (t *btree) insert(e indexEntry) {
+	if t.root == nil {
+		t.root = &btreeNode{}
+	}
+
+	// Full nodes are split on the way down, the root grows a new one above
+	if len(t.root.entries) == btreeMax {
+		t.root = &btreeNode{children: []*btreeNode{t.root}}
+		t.root.split(0)
+	}
+
+	t.root.insert(e)
+	t.len++
+}
+
+// remove removes e and reports whether the tree held it.
+func (t *btree) remove(e indexEntry) bool {
+	if t.root == nil || !t.root.remove(e) {
+		return false
+	}
+
+	if len(t.root.entries) == 0 && t.root.children != nil {
+		t.root = t.root.children[0]
+	}
+	t.len--
+	return true
+}
+
+// ascend calls fn with every entry from the first one not less than from,
+// in order, until it returns false.
+func (t *btree) ascend(from indexEntry, fn func(e indexEntry) bool) {
+	if t.root != nil {
+		t.root.ascend(&from, fn)
+	}
+}
+
+// ascendAll calls fn with every entry in order until it returns false.
+func (t *btree) ascendAll(fn func(e indexEntry) bool) {
+	if t.root != nil {
+		t.root.ascend(nil, fn)
+	}
+}
+
+// find returns the position of the first entry of n not less than e, and
+// whether it is e.
+func (n *btreeNode) find(e indexEntry) (int, bool) {
+	lo, hi := 0, len(n.entries)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if n.entries[mid].less(e) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, lo < len(n.entries) && !e.less(n.entries[lo])
+}
+
+// split splits the full child i of n in two, moving its middle entry up
+// into n between them.
+func (n *btreeNode) split(i int) {
+	child := n.children[i]
+	middle := child.entries[btreeMin]
+
+	right := &btreeNode{entries: append([]indexEntry{}, child.entries[btreeMin+1:]...)}
+	if child.children != nil {
+		right.children = append([]*btreeNode{}, child.children[btreeMin+1:]...)
+		child.children = child.children[:btreeMin+1]
+	}
+	child.entries = child.entries[:btreeMin]
+
+	n.entries = append(n.entries, indexEntry{})
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = middle
+
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+}
+
+// insert inserts e into the subtree of n, which isn't full.
+func (n *btreeNode) insert(e indexEntry) {
+	i, _ := n.find(e)
+	if n.children == nil {
+		n.entries = append(n.entries, indexEntry{})
+		copy(n.entries[i+1:], n.entries[i:])
+		n.entries[i] = e
+		return
+	}
+
+	if len(n.children[i].entries) == btreeMax {
+		n.split(i)
+		if n.entries[i].less(e) {
+			i++
+		}
+	}
+
+	n.children[i].insert(e)
+}
+
+// remove removes e from the subtree of n. Every node it descends into is
+// given more than btreeMin entries first, so removing one from it never
+// leaves it with too few.
+func (n *btreeNode) remove(e indexEntry) bool {
+	i, found := n.find(e)
+	if n.children == nil {
+		if !found {
+			return false
+		}
+		n.entries = append(n.entries[:i], n.entries[i+1:]...)
+		return true
+	}
+
+	if len(n.children[i].entries) <= btreeMin {
+		n.grow(i)
+		return n.remove(e)
+	}
+
+	// An entry of an inner node is replaced with the one before it, which
+	// is the last one of the child to its left
+	if found {
+		n.entries[i] = n.children[i].removeLast()
+		return true
+	}
+
+	return n.children[i].remove(e)
+}
+
+// removeLast removes the last entry of the subtree of n and returns it.
+func (n *btreeNode) removeLast() indexEntry {
+	if n.children == nil {
+		last := n.entries[len(n.entries)-1]
+		n.entries = n.entries[:len(n.entries)-1]
+		return last
+	}
+
+	i := len(n.children) - 1
+	if len(n.children[i].entries) <= btreeMin {
+		n.grow(i)
+		return n.removeLast()
+	}
+
+	return n.children[i].removeLast()
+}
+
+// grow gives the child i of n more than btreeMin entries, moving one over
+// from a sibling that can spare it, or else merging the child with one.
+func (n *btreeNode) grow(i int) {
+	child := n.children[i]
+	switch {
+	case i > 0 && len(n.children[i-1].entries) > btreeMin:
+		left := n.children[i-1]
+		last := len(left.entries) - 1
+
+		child.entries = append(child.entries, indexEntry{})
+		copy(child.entries[1:], child.entries)
+		child.entries[0] = n.entries[i-1]
+		n.entries[i-1] = left.entries[last]
+		left.entries = left.entries[:last]
+
+		if left.children != nil {
+			child.children = append(child.children, nil)
+			copy(child.children[1:], child.children)
+			child.children[0] = left.children[last+1]
+			left.children = left.children[:last+1]
+		}
+	case i < len(n.entries) && len(n.children[i+1].entries) > btreeMin:
+		right := n.children[i+1]
+
+		child.entries = append(child.entries, n.entries[i])
+		n.entries[i] = right.entries[0]
+		right.entries = append(right.entries[:0], right.entries[1:]...)
+
+		if right.children != nil {
+			child.children = append(child.children, right.children[0])
+			right.children = append(right.children[:0], right.children[1:]...)
+		}
+	default:
+		// Merge the child with the sibling to its right, or the last child
+		// with the one to its left
+		if i == len(n.entries) {
+			i--
+			child = n.children[i]
+		}
+		right := n.children[i+1]
+
+		child.entries = append(append(child.entries, n.entries[i]), right.entries...)
+		if child.children != nil {
+			child.children = append(child.children, right.children...)
+		}
+
+		n.entries = append(n.entries[:i], n.entries[i+1:]...)
+		n.children = append(n.children[:i+1], n.children[i+2:]...)
+	}
+}
+
+// ascend calls fn with the entries of n from the first one not less than
+// from, or from the first one when from is nil.
+func (n *btreeNode) ascend(from *indexEntry, fn func(e indexEntry) bool) bool {
+	i := 0
+	if from != nil {
+		i, _ = n.find(*from)
+	}
+	for ; i < len(n.entries); i++ {
+		if n.children != nil && !n.children[i].ascend(from, fn) {
+			return false
+		}
+		if !fn(n.entries[i]) {
+			return false
+		}
+	}
+
+	if n.children != nil {
+		return n.children[i].ascend(from, fn)
+	}
+	return true
+}
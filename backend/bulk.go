@@ -0,0 +1,128 @@
+package backend
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+// Default stands for the value DEFAULT gives a column in a row passed to
+// BulkInsert: the next value of an identity column and NULL for others.
+var Default = defaultValue{}
+
+type defaultValue struct{}
+
+// BulkInsert appends rows to table without parsing, analyzing or evaluating
+// a statement for each of them. Every row is checked against the columns of
+// the table before any is inserted, so either all of them are or none. Values
+// of identity columns are checked and generated like those of INSERT without
+// OVERRIDING, and each row must pass the policies, foreign keys and unique
+// constraints of the table for session.
+// For writing the rows to a log as a single entry, it returns the INSERT
+// adding a row of the table and its parameters for each of them.
+func (mb *MemoryBackend) BulkInsert(table string, rows [][]interface{}, session *functions.Session) (string, [][]interface{}, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if isSystemTable(table) {
+		return "", nil, ErrSystemTable
+	}
+
+	t, ok := mb.tables[table]
+	if !ok {
+		return "", nil, ErrTableDoesNotExist
+	}
+
+	if t.view != nil {
+		return "", nil, fmt.Errorf("%w: %s", ErrMaterializedView, table)
+	}
+
+	if t.external != nil {
+		return "", nil, fmt.Errorf("%w: %s", ErrExternalTable, table)
+	}
+
+	// The converted rows are buffered until all of them have been checked
+	mem := mb.budget.Reserve()
+	defer mem.Close()
+
+	ev := evaluation{
+		mb:       mb,
+		session:  session,
+		patterns: map[string]*regexp.Regexp{},
+		plans:    map[*parser.SelectStatement]*subqueryPlan{},
+	}
+	var identities []*identity
+	if t.identities != nil {
+		identities = append(identities, t.identities...)
+	}
+
+	assigned := make([][]interface{}, len(rows))
+	for r, row := range rows {
+		if len(row) != len(t.columns) {
+			return "", nil, fmt.Errorf("%w: row %d has %d values for %d columns",
+				ErrMissingValues, r+1, len(row), len(t.columns))
+		}
+
+		values := make([]interface{}, len(row))
+		for i, v := range row {
+			_, isDefault := v.(defaultValue)
+			ok := true
+			if isDefault {
+				v = nil
+			} else if v, ok = bind(v); ok {
+				v, ok = types.Assign(v, t.columnTypes[i])
+			}
+			if !ok {
+				return "", nil, fmt.Errorf("%w: expected %s for column %s in row %d",
+					ErrInvalidDatatype, t.columnTypes[i], t.columns[i], r+1)
+			}
+
+			// Each row goes on from the values generated for the rows before
+			if t.identityOf(i) != nil {
+				var err error
+				if v, identities[i], err = identityValue(identities[i], t.columns[i], "", isDefault, v); err != nil {
+					return "", nil, err
+				}
+			}
+
+			values[i] = v
+		}
+
+		if err := ev.checkPolicies(table, t, values); err != nil {
+			return "", nil, err
+		}
+
+		if err := mem.Grow(rowSize(values)); err != nil {
+			return "", nil, err
+		}
+		assigned[r] = values
+	}
+
+	if err := checkKeys(t, assigned...); err != nil {
+		return "", nil, err
+	}
+
+	if err := mb.checkForeignKeys(table, t, assigned...); err != nil {
+		return "", nil, err
+	}
+
+	if err := checkUnique(table, t, assigned...); err != nil {
+		return "", nil, err
+	}
+
+	if err := t.store.Insert(assigned...); err != nil {
+		return "", nil, err
+	}
+	t.identities = identities
+	mb.changed(table)
+
+	params := make([][]interface{}, len(assigned))
+	for i, row := range assigned {
+		params[i] = dumpParams(row)
+	}
+
+	return dumpInsert(table, t), params, nil
+}
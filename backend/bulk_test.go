@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBulkInsert(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		rows  [][]interface{}
+		err   error
+		// ids are the ids of t after the insert
+		ids [][]interface{}
+	}{
+		{"rows", "t", [][]interface{}{{int64(4), "d", 0.5}, {5, "e", nil}},
+			nil, [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}, {int64(4)}, {int64(5)}}},
+		{"no rows", "t", nil, nil, [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}},
+		{"too few values", "t", [][]interface{}{{int64(4), "d", 0.5}, {int64(5), "e"}},
+			ErrMissingValues, [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}},
+		{"wrong type", "t", [][]interface{}{{int64(4), "d", 0.5}, {"five", "e", 0.5}},
+			ErrInvalidDatatype, [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}},
+		{"missing table", "missing", [][]interface{}{{int64(4)}}, ErrTableDoesNotExist, nil},
+		{"system table", "__tables", [][]interface{}{{int64(4)}}, ErrSystemTable, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+
+			insert, params, err := mb.BulkInsert(tt.table, tt.rows, session)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if tt.ids == nil {
+				return
+			}
+
+			results, err := run(mb, session, "select id from t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.ids) {
+				t.Errorf("t holds %v, want %v", results.Rows, tt.ids)
+			}
+
+			// The statement returned inserts the same rows again
+			replayed, session := testBackend(t)
+			for _, p := range params {
+				if _, err := run(replayed, session, insert, p...); err != nil {
+					t.Fatalf("%s: %v", insert, err)
+				}
+			}
+			want, err := run(mb, session, "select * from t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := run(replayed, session, "select * from t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Rows, want.Rows) {
+				t.Errorf("the logged statements insert %v, want %v", got.Rows, want.Rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package backend
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+)
+
+// resultCache keeps the results of recent queries. An entry is only used
+// while the tables the query read haven't been written since, which is
+// told by their versions: every write gives the table a version no table
+// had before, so a version seen again means nothing changed.
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	// lru has the most recently used entry at the front
+	lru *list.List
+}
+
+type cacheEntry struct {
+	key      string
+	results  *Results
+	versions map[string]uint64
+}
+
+// SetResultCache keeps the results of up to size queries, evicting the
+// least recently used first, and answers the same query with the same
+// parameters from them until a table it reads is written. Queries calling
+// volatile functions like NOW() or RANDOM() and queries of system tables
+// are never cached. A size that isn't positive turns the cache off.
+func (mb *MemoryBackend) SetResultCache(size int) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.cache = nil
+	if size > 0 {
+		mb.cache = &resultCache{size: size, entries: map[string]*list.Element{}, lru: list.New()}
+	}
+}
+
+// changed gives table a new version, which makes cached results that read
+// it stale. It must be called with mb.mu held for writing.
+func (mb *MemoryBackend) changed(table string) {
+	mb.clock++
+	if mb.versions == nil {
+		mb.versions = map[string]uint64{}
+	}
+	mb.versions[table] = mb.clock
+}
+
+// cacheKey returns the key of slct run with params and the tables it reads,
+// or false if its results can't be cached.
+func cacheKey(slct *parser.SelectStatement, params []interface{}) (string, []string, bool) {
+	tables := []string{}
+	if !cacheable(slct, &tables) {
+		return "", nil, false
+	}
+
+	// Values of different types can print the same, so the types are part
+	// of the key
+	var b strings.Builder
+	b.WriteString(slct.String())
+	for _, p := range params {
+		fmt.Fprintf(&b, "\x00%T:%v", p, p)
+	}
+
+	return b.String(), tables, true
+}
+
+// cacheable reports whether the results of slct only depend on the tables
+// it reads, adding their names to tables.
+func cacheable(slct *parser.SelectStatement, tables *[]string) bool {
+	if slct.From != nil {
+		// What a table held at a time ages out of its history
+		if isSystemTable(slct.From.Value) || slct.AsOf != nil {
+			return false
+		}
+		*tables = append(*tables, slct.From.Value)
+	}
+
+	var walk func(exp *parser.Expression) bool
+	walk = func(exp *parser.Expression) bool {
+		if exp == nil {
+			return true
+		}
+
+		switch exp.Type {
+		case parser.BinaryType:
+			return walk(&exp.Binary.A) && walk(&exp.Binary.B)
+		case parser.CastType:
+			return walk(&exp.Cast.Exp)
+		case parser.IndexType:
+			return walk(&exp.Index.Exp) && walk(&exp.Index.Index)
+		case parser.ArrayType, parser.RowType:
+			for i := range exp.Array {
+				if !walk(&exp.Array[i]) {
+					return false
+				}
+			}
+			for i := range exp.Row {
+				if !walk(&exp.Row[i]) {
+					return false
+				}
+			}
+		case parser.ExistsType:
+			return cacheable(exp.Exists.Query, tables)
+		case parser.SubqueryType:
+			return cacheable(exp.Subquery, tables)
+		case parser.NotType:
+			return walk(exp.Not)
+		case parser.InType:
+			if !walk(&exp.In.Exp) {
+				return false
+			}
+			for i := range exp.In.List {
+				if !walk(&exp.In.List[i]) {
+					return false
+				}
+			}
+		case parser.CallType:
+			if f, ok := functions.Lookup(exp.Call.Name.Value); !ok || f.Volatile {
+				return false
+			}
+			for i := range exp.Call.Args {
+				if !walk(&exp.Call.Args[i]) {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	for _, item := range slct.Item {
+		if !walk(item.Exp) {
+			return false
+		}
+	}
+
+	if !walk(slct.Function) {
+		return false
+	}
+
+	if slct.ConnectBy != nil && !walk(slct.ConnectBy.Start) {
+		return false
+	}
+
+	for _, order := range slct.OrderBy {
+		if !walk(order.Exp) {
+			return false
+		}
+	}
+
+	return walk(slct.Where) && walk(slct.Limit) && walk(slct.Offset)
+}
+
+// cachedTables reports whether results read from tables can be cached,
+// which they can't when one of them is an external table or belongs to an
+// attached database, since those change without mb knowing, or when one has
+// policies or masks, whose rows depend on the session. It must be called
+// with mb.mu held.
+func (mb *MemoryBackend) cachedTables(tables []string) bool {
+	for _, name := range tables {
+		t, ok := mb.tables[name]
+		if (!ok && !isSystemTable(name)) || (ok && (t.external != nil || len(t.policies) > 0 || len(t.masks) > 0)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// get returns the cached results for key if none of the tables they were
+// computed from changed since. It must be called with mb.mu held.
+func (c *resultCache) get(mb *MemoryBackend, key string) (*Results, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	for table, version := range entry.versions {
+		if _, exists := mb.tables[table]; !exists || mb.versions[table] != version {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+			return nil, false
+		}
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry.results.copy(), true
+}
+
+// put caches results for key as computed from tables. It must be called
+// with mb.mu held.
+func (c *resultCache) put(mb *MemoryBackend, key string, tables []string, results *Results) {
+	versions := map[string]uint64{}
+	for _, table := range tables {
+		versions[table] = mb.versions[table]
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, results: results.copy(), versions: versions})
+
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// copy returns results with rows of their own, so callers changing them
+// don't change the cache. The rows themselves are shared.
+func (r *Results) copy() *Results {
+	copied := &Results{Columns: r.Columns}
+	if r.Rows != nil {
+		copied.Rows = append([][]interface{}{}, r.Rows...)
+	}
+
+	return copied
+}
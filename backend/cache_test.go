@@ -0,0 +1,253 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+func TestResultCache(t *testing.T) {
+	mb, session := testBackend(t)
+	mb.SetResultCache(2)
+	if _, err := run(mb, session, "create table u (id int); insert into u values (2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(q string, params ...interface{}) *Results {
+		t.Helper()
+
+		results, err := run(mb, session, q, params...)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		if len(results.Rows) == 0 {
+			t.Fatalf("%s: no rows", q)
+		}
+		return results
+	}
+	// Cached results share their rows with those they were cached from
+	cached := func(a, b *Results) bool {
+		return &a.Rows[0][0] == &b.Rows[0][0]
+	}
+
+	const q = "select id, name from t where id in (select id from u) or id = $1"
+	first := query(q, int64(1))
+	if second := query(q, int64(1)); !cached(first, second) || !reflect.DeepEqual(first, second) {
+		t.Fatalf("got %v and %v, want the same cached results", first.Rows, second.Rows)
+	}
+
+	// Callers can't change what is cached
+	first.Rows = first.Rows[:1]
+	if again := query(q, int64(1)); len(again.Rows) != 2 {
+		t.Fatalf("got %v after changing the results", again.Rows)
+	}
+
+	// Parameters of another value or type are another query
+	if other := query(q, 1.0); cached(first, other) {
+		t.Error("Float parameter answered from the cache of an int one")
+	}
+	if other := query(q, int64(3)); cached(first, other) || len(other.Rows) != 2 || other.Rows[1][0] != int64(3) {
+		t.Errorf("got %v for another parameter", other.Rows)
+	}
+
+	// Writing a table the query reads, even in a subquery, makes it stale
+	first = query(q, int64(1))
+	if _, err := run(mb, session, "insert into u values (3)"); err != nil {
+		t.Fatal(err)
+	}
+	if again := query(q, int64(1)); cached(first, again) || len(again.Rows) != 3 {
+		t.Errorf("got %v after writing u", again.Rows)
+	}
+
+	// Writing other tables doesn't
+	first = query(q, int64(1))
+	if _, err := run(mb, session, "create table v (id int); insert into v values (1)"); err != nil {
+		t.Fatal(err)
+	}
+	if again := query(q, int64(1)); !cached(first, again) {
+		t.Error("Writing another table made the results stale")
+	}
+
+	// Nor does a table dropped and created again answer from the old one
+	first = query("select id from u")
+	if _, err := run(mb, session, "drop table u; create table u (id int); insert into u values (7)"); err != nil {
+		t.Fatal(err)
+	}
+	if again := query("select id from u"); cached(first, again) || !reflect.DeepEqual(again.Rows, [][]interface{}{{int64(7)}}) {
+		t.Errorf("got %v after creating u again", again.Rows)
+	}
+}
+
+// TestResultCacheRestore checks that rolling back only makes the results
+// read from the tables written since the snapshot stale.
+func TestResultCacheRestore(t *testing.T) {
+	mb, session := testBackend(t)
+	mb.SetResultCache(10)
+	if _, err := run(mb, session, "create table u (id int); insert into u values (2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(q string) *Results {
+		t.Helper()
+
+		results, err := run(mb, session, q)
+		if err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+		return results
+	}
+	cached := func(a, b *Results) bool {
+		return &a.Rows[0][0] == &b.Rows[0][0]
+	}
+
+	tests := []struct {
+		name string
+		// write runs between taking the snapshot and restoring it
+		write string
+		// stale are the queries whose results the restore makes stale
+		stale []string
+	}{
+		{"nothing written", "", nil},
+		{"u written", "insert into u values (3)", []string{"select id from u"}},
+		{"u dropped", "drop table u", []string{"select id from u"}},
+		{"table created", "create table v (id int)", nil},
+		{"u created again", "drop table u; create table u (id int); insert into u values (4)", []string{"select id from u"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := []string{"select id, name from t", "select id from u"}
+			before := map[string]*Results{}
+			for _, q := range queries {
+				before[q] = query(q)
+			}
+
+			s := mb.Snapshot()
+			if tt.write != "" {
+				if _, err := run(mb, session, tt.write); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mb.Restore(s)
+
+			for _, q := range queries {
+				stale := false
+				for _, sq := range tt.stale {
+					stale = stale || sq == q
+				}
+
+				after := query(q)
+				if cached(before[q], after) == stale {
+					t.Errorf("%s: cached %v after restoring, want %v", q, stale, !stale)
+				}
+				if !reflect.DeepEqual(after.Rows, before[q].Rows) {
+					t.Errorf("%s: got %v after restoring, want %v", q, after.Rows, before[q].Rows)
+				}
+			}
+		})
+	}
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	mb, session := testBackend(t)
+	mb.SetResultCache(2)
+
+	results := map[string]*Results{}
+	for _, q := range []string{"select 1 from t", "select 2 from t", "select 1 from t", "select 3 from t"} {
+		r, err := run(mb, session, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := results[q]; !ok {
+			results[q] = r
+		}
+	}
+
+	// "select 2" was the least recently used when "select 3" came
+	if len(mb.cache.entries) != 2 || mb.cache.lru.Len() != 2 {
+		t.Fatalf("%d entries cached, want 2", len(mb.cache.entries))
+	}
+	for _, tt := range []struct {
+		query  string
+		cached bool
+	}{
+		{"select 3 from t", true},
+		{"select 1 from t", true},
+		{"select 2 from t", false},
+	} {
+		r, err := run(mb, session, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := &r.Rows[0][0] == &results[tt.query].Rows[0][0]; got != tt.cached {
+			t.Errorf("%s: cached %v, want %v", tt.query, got, tt.cached)
+		}
+	}
+
+	// A size that isn't positive turns the cache off
+	mb.SetResultCache(0)
+	if _, err := run(mb, session, "select 1 from t"); err != nil {
+		t.Fatal(err)
+	}
+	if mb.cache != nil {
+		t.Error("The cache is still on")
+	}
+}
+
+func TestResultCacheSkipped(t *testing.T) {
+	for _, q := range []string{
+		"select random() from t",
+		"select id from t where now() > '2000-01-01'",
+		"select id from t where id in (select 1 where random() < 2)",
+		"select name from __sessions",
+	} {
+		t.Run(q, func(t *testing.T) {
+			mb, session := testBackend(t)
+			mb.SetResultCache(10)
+
+			if _, err := run(mb, session, q); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(mb.cache.entries); n != 0 {
+				t.Errorf("%d results cached", n)
+			}
+		})
+	}
+
+	// Unlike those of stable functions
+	mb, session := testBackend(t)
+	mb.SetResultCache(10)
+	if _, err := run(mb, session, "select upper(name) from t where abs(id) = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(mb.cache.entries); n != 1 {
+		t.Errorf("%d results cached, want 1", n)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	key := func(q string, params ...interface{}) (string, []string, bool) {
+		t.Helper()
+
+		ast, err := parser.Parse(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cacheKey(ast.Statements[0].SelectStatement, params)
+	}
+
+	a, tables, ok := key("select id from t where id in (select id from u) and exists (select 1 from v)", int64(1))
+	if !ok || !reflect.DeepEqual(tables, []string{"t", "u", "v"}) {
+		t.Fatalf("got tables %v, %v", tables, ok)
+	}
+	// Values printing the same but of different types have different keys
+	if b, _, _ := key("select id from t where id in (select id from u) and exists (select 1 from v)", "1"); a == b {
+		t.Errorf("Same key %q for an int and a text parameter", a)
+	}
+
+	// What a table held at a time ages out of its history
+	if _, _, ok := key("select id from t as of timestamp '2024-01-01'"); ok {
+		t.Error("Query of a past version of a table is cacheable")
+	}
+}
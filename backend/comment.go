@@ -0,0 +1,129 @@
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+// Comment sets the comment of a table or one of its columns, or removes it
+// when the comment is NULL or empty. Comments are only descriptions kept in
+// the catalog, shown by SHOW COLUMNS and __comments.
+func (mb *MemoryBackend) Comment(cmt *parser.CommentStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name := cmt.Table.Value
+	if isSystemTable(name) {
+		return ErrSystemTable
+	}
+
+	t, ok := mb.tables[name]
+	if !ok {
+		return ErrTableDoesNotExist
+	}
+
+	text := ""
+	if cmt.Comment != nil {
+		text = cmt.Comment.Value
+	}
+
+	commented := *t
+	if cmt.Column == nil {
+		commented.comment = text
+	} else {
+		column := cmt.Column.Value
+		if _, ok := t.columnIndex(column); !ok {
+			return fmt.Errorf("%w: %s", ErrColumnDoesNotExist, column)
+		}
+
+		commented.comments = map[string]string{}
+		for col, c := range t.comments {
+			commented.comments[col] = c
+		}
+		if text == "" {
+			delete(commented.comments, column)
+		} else {
+			commented.comments[column] = text
+		}
+	}
+
+	mb.tables[name] = &commented
+	mb.changed(name)
+	return nil
+}
+
+// keepComments copies the comments of t, and those of its columns still in
+// changed, to changed. Materialized views keep their comments when they are
+// refreshed this way.
+func keepComments(t, changed *memoryTable) {
+	changed.comment = t.comment
+	for col, c := range t.comments {
+		if _, ok := changed.columnIndex(col); ok {
+			if changed.comments == nil {
+				changed.comments = map[string]string{}
+			}
+			changed.comments[col] = c
+		}
+	}
+}
+
+// commentRows returns the rows of __comments.
+func (mb *MemoryBackend) commentRows() [][]interface{} {
+	rows := [][]interface{}{}
+	for _, name := range mb.tableNames() {
+		t := mb.tables[name]
+		if t.comment != "" {
+			rows = append(rows, []interface{}{"table", name, nil, t.comment})
+		}
+
+		for _, col := range t.columns {
+			if c, ok := t.comments[col]; ok {
+				rows = append(rows, []interface{}{"column", name, col, c})
+			}
+		}
+	}
+
+	return rows
+}
+
+// dumpComments returns the statements commenting the tables and columns of
+// mb again. They come after the materialized views are created. It must be
+// called with mb.mu held.
+func (mb *MemoryBackend) dumpComments() []DumpStatement {
+	stmts := []DumpStatement{}
+	for _, name := range mb.tableNames() {
+		t := mb.tables[name]
+		if t.comment != "" {
+			stmts = append(stmts, DumpStatement{
+				Query: "COMMENT ON TABLE " + parser.FormatIdentifier(name) + " IS '" + strings.ReplaceAll(t.comment, "'", "''") + "'",
+			})
+		}
+
+		columns := make([]string, 0, len(t.comments))
+		for col := range t.comments {
+			columns = append(columns, col)
+		}
+		sort.Strings(columns)
+
+		for _, col := range columns {
+			stmts = append(stmts, DumpStatement{
+				Query: "COMMENT ON COLUMN " + commentTarget(name, col) + " IS '" + strings.ReplaceAll(t.comments[col], "'", "''") + "'",
+			})
+		}
+	}
+
+	return stmts
+}
+
+// commentTarget returns how COMMENT ON COLUMN names the column col of
+// table, as table.column quoted as a whole if either has to be quoted.
+func commentTarget(table, col string) string {
+	if parser.FormatIdentifier(table) == table && parser.FormatIdentifier(col) == col {
+		return table + "." + col
+	}
+
+	return parser.QuoteIdentifier(table + "." + col)
+}
@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/functions"
+)
+
+// TestComments checks the comments __comments shows after COMMENT ON and
+// changes to what they describe, and that dumps comment them again.
+func TestComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		rows    [][]interface{}
+	}{
+		{
+			"table and columns",
+			[]string{
+				"comment on column t.score is 'x'",
+				"comment on table t is 'people''s'",
+				"comment on column t.name is 'the name'",
+			},
+			[][]interface{}{
+				{"table", "t", nil, "people's"},
+				{"column", "t", "name", "the name"},
+				{"column", "t", "score", "x"},
+			},
+		},
+		{
+			"replaced",
+			[]string{"comment on table t is 'a'", "comment on table t is 'b'"},
+			[][]interface{}{{"table", "t", nil, "b"}},
+		},
+		{
+			"removed",
+			[]string{
+				"comment on table t is 'a'",
+				"comment on column t.name is 'b'",
+				"comment on table t is null",
+				"comment on column t.name is ''",
+			},
+			nil,
+		},
+		{
+			"column renamed",
+			[]string{"comment on column t.name is 'the name'", "alter table t rename column name to label"},
+			[][]interface{}{{"column", "t", "label", "the name"}},
+		},
+		{
+			"column dropped",
+			[]string{
+				"comment on column t.name is 'the name'",
+				"comment on column t.score is 'x'",
+				"alter table t drop column score",
+			},
+			[][]interface{}{{"column", "t", "name", "the name"}},
+		},
+		{
+			"table dropped",
+			[]string{"comment on table t is 'a'", "drop table t"},
+			nil,
+		},
+		{
+			"quoted",
+			[]string{
+				`create table "my t" ("a b" int)`,
+				`comment on column "my t.a b" is 'quoted'`,
+			},
+			[][]interface{}{{"column", "my t", "a b", "quoted"}},
+		},
+		{
+			"materialized view refreshed",
+			[]string{
+				"create materialized view v as select id, name from t",
+				"comment on table v is 'view'",
+				"comment on column v.name is 'the name'",
+				"insert into t values (4, 'd', null)",
+				"refresh materialized view v",
+			},
+			[][]interface{}{
+				{"table", "v", nil, "view"},
+				{"column", "v", "name", "the name"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+			for _, query := range tt.queries {
+				if _, err := run(mb, session, query); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			results, err := run(mb, session, "select * from __comments")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+
+			stmts, err := mb.Dump()
+			if err != nil {
+				t.Fatal(err)
+			}
+			replayed := NewMemoryBackend()
+			replayedSession := functions.NewSession(replayed)
+			for _, stmt := range stmts {
+				if _, err := run(replayed, replayedSession, stmt.Query, stmt.Params...); err != nil {
+					t.Fatalf("%s: %v", stmt.Query, err)
+				}
+			}
+			results, err = run(replayed, replayedSession, "select * from __comments")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("the dump comments %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
+
+func TestCommentErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   error
+	}{
+		{"comment on table missing is 'x'", ErrTableDoesNotExist},
+		{"comment on column missing.id is 'x'", ErrTableDoesNotExist},
+		{"comment on column t.missing is 'x'", ErrColumnDoesNotExist},
+		{"comment on table __tables is 'x'", ErrSystemTable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb, session := testBackend(t)
+
+			if _, err := run(mb, session, tt.query); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
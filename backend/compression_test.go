@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/storage"
+)
+
+// compressingEngine records the compressors of the tables it creates, by
+// their names.
+type compressingEngine struct {
+	storage.Engine
+	compressors map[string]string
+}
+
+func (e *compressingEngine) CreateTable(name string) (storage.Table, error) {
+	e.compressors[name] = ""
+	return e.Engine.CreateTable(name)
+}
+
+func (e *compressingEngine) CreateCompressedTable(name, compressor string) (storage.Table, error) {
+	e.compressors[name] = compressor
+	return e.Engine.CreateTable(name)
+}
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		table   string
+		want    string
+		err     error
+	}{
+		{"created", nil, "c", "lz", nil},
+		{"not compressed", []string{"create table p (a text)"}, "p", "", nil},
+		{"altered", []string{"insert into c values ('a')", "alter table c add column b int"}, "c", "lz", nil},
+		{"renamed", []string{"alter table c rename to d"}, "d", "lz", nil},
+		{"unknown", []string{"create table p (a text) with (compression = 'paper')"}, "", "", storage.ErrNoSuchCompressor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &compressingEngine{Engine: storage.NewMemory(), compressors: map[string]string{}}
+			mb := NewBackend(e)
+			session := functions.NewSession(mb)
+
+			var err error
+			for _, query := range append([]string{"create table c (a text) with (compression = 'lz')"}, tt.queries...) {
+				if _, err = run(mb, session, query); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := e.compressors[tt.table]; got != tt.want {
+				t.Errorf("%s is compressed with %q, want %q", tt.table, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDumpCompression(t *testing.T) {
+	mb, session := testBackend(t)
+	if _, err := run(mb, session, "create table c (a text) with (compression = 'lz')"); err != nil {
+		t.Fatal(err)
+	}
+
+	stmts, err := mb.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, stmt := range stmts {
+		found = found || stmt.Query == "CREATE TABLE c (a text) WITH (compression = 'lz')"
+	}
+	if !found {
+		t.Errorf("dump doesn't create c compressed: %v", stmts)
+	}
+}
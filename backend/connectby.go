@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"errors"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+var ErrConnectByLoop = errors.New("CONNECT BY loop in user data")
+
+// ConnectByColumns are the pseudo columns CONNECT BY adds to the rows it
+// walks. level is how deep the row is in the tree, 1 for the rows the walk
+// starts from, and connect_by_isleaf is 1 for rows without children and 0
+// for the others. A table with a column of the same name keeps its own.
+var ConnectByColumns = []Column{
+	{Name: "level", Type: IntType},
+	{Name: "connect_by_isleaf", Type: IntType},
+}
+
+// connectBy walks the rows of t as the tree connect describes, depth first,
+// and returns a table of the rows in the order they were reached with the
+// pseudo columns added. A row reached along several paths is there once for
+// each of them.
+func (ev *evaluation) connectBy(connect *parser.ConnectBy, t *memoryTable) (*memoryTable, error) {
+	prior, ok := t.columnIndex(connect.Prior.Value)
+	if !ok {
+		return nil, ErrColumnDoesNotExist
+	}
+
+	child, ok := t.columnIndex(connect.Child.Value)
+	if !ok {
+		return nil, ErrColumnDoesNotExist
+	}
+
+	walked := &memoryTable{
+		columns:     append([]string{}, t.columns...),
+		columnTypes: append([]ColumnType{}, t.columnTypes...),
+		collations:  append([]*types.Collation{}, t.collations...),
+	}
+	added := []string{}
+	for _, col := range ConnectByColumns {
+		if _, ok := t.columnIndex(col.Name); !ok {
+			walked.columns = append(walked.columns, col.Name)
+			walked.columnTypes = append(walked.columnTypes, col.Type)
+			walked.collations = append(walked.collations, nil)
+			added = append(added, col.Name)
+		}
+	}
+
+	// The rows are copied with their columns in order, which flat describes
+	flat := &memoryTable{columns: t.columns, columnTypes: t.columnTypes, collations: t.collations}
+	rows := []storage.Row{}
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		values := make(storage.Row, len(t.columns))
+		for i := range t.columns {
+			values[i] = row[t.position(i)]
+		}
+		rows = append(rows, values)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	children := childrenOf(rows, flat, prior, child)
+
+	walk := []storage.Row{}
+	onPath := make([]bool, len(rows))
+	var visit func(i int, level int64) error
+	visit = func(i int, level int64) error {
+		if err := ev.canceled(); err != nil {
+			return err
+		}
+
+		kids := children(rows[i])
+		row := append(storage.Row{}, rows[i]...)
+		for _, name := range added {
+			switch name {
+			case "level":
+				row = append(row, level)
+			case "connect_by_isleaf":
+				isLeaf := int64(0)
+				if len(kids) == 0 {
+					isLeaf = 1
+				}
+				row = append(row, isLeaf)
+			}
+		}
+
+		if err := ev.mem.Grow(rowSize(row)); err != nil {
+			return err
+		}
+		walk = append(walk, row)
+
+		onPath[i] = true
+		defer func() { onPath[i] = false }()
+		for _, kid := range kids {
+			if onPath[kid] {
+				if connect.NoCycle {
+					continue
+				}
+				return ErrConnectByLoop
+			}
+
+			if err := visit(kid, level+1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for i, row := range rows {
+		if connect.Start != nil {
+			ok, err := ev.sub(flat, row).satisfies([]*parser.Expression{connect.Start})
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if err := visit(i, 1); err != nil {
+			return nil, err
+		}
+	}
+
+	walked.store = storage.NewTable(walk...)
+	return walked, nil
+}
+
+// childrenOf returns a function finding the rows whose child column equals
+// the prior column of a row. The rows are grouped on their child column up
+// front, unless the columns compare with a collation, which values can't be
+// grouped by.
+func childrenOf(rows []storage.Row, t *memoryTable, prior int, child int) func(storage.Row) []int {
+	collation := t.collations[child]
+	if collation == nil {
+		collation = t.collations[prior]
+	}
+
+	if collation != nil {
+		return func(parent storage.Row) []int {
+			kids := []int{}
+			for i, row := range rows {
+				if eq, err := types.ApplyCollated("=", parent[prior], row[child], collation); err == nil && eq == true {
+					kids = append(kids, i)
+				}
+			}
+			return kids
+		}
+	}
+
+	// Both sides are converted to float when either is, so 1 and 1.0 match
+	keyTypes := []ColumnType{t.columnTypes[child]}
+	if t.columnTypes[prior] == FloatType {
+		keyTypes[0] = FloatType
+	}
+
+	groups := map[string][]int{}
+	for i, row := range rows {
+		if key, ok := hashKey([]interface{}{row[child]}, keyTypes); ok {
+			groups[key] = append(groups[key], i)
+		}
+	}
+
+	return func(parent storage.Row) []int {
+		key, ok := hashKey([]interface{}{parent[prior]}, keyTypes)
+		if !ok {
+			return nil
+		}
+		return groups[key]
+	}
+}
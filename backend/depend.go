@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dependent is an object of the catalog that depends on a table. Indexes,
+// policies and statistics belong to their table and are dropped with it,
+// auto is set for them. Materialized views only read the tables they
+// depend on, which can't be dropped without them unless with CASCADE.
+type dependent struct {
+	kind, name string
+	auto       bool
+}
+
+// dependents returns what depends on the table called name, those that
+// belong to it first. It must be called with mb.mu held.
+func (mb *MemoryBackend) dependents(name string) []dependent {
+	deps := []dependent{}
+	if t, ok := mb.tables[name]; ok {
+		for _, idx := range t.indexes {
+			deps = append(deps, dependent{kind: "index", name: idx.name, auto: true})
+		}
+		for _, p := range t.policies {
+			deps = append(deps, dependent{kind: "policy", name: p.name, auto: true})
+		}
+		for _, s := range t.statistics {
+			deps = append(deps, dependent{kind: "statistics", name: s.name, auto: true})
+		}
+	}
+
+	for _, view := range mb.tableNames() {
+		if v := mb.tables[view].view; v != nil && view != name {
+			for _, read := range v.reads {
+				if read == name {
+					deps = append(deps, dependent{kind: "materialized view", name: view})
+					break
+				}
+			}
+		}
+	}
+
+	return deps
+}
+
+// readers returns the materialized views that read the table called name,
+// directly or through other views, in an order they can be dropped in:
+// every view comes before the views it reads. reads describes why each of
+// them depends on it, like "v reads t". It must be called with mb.mu held.
+func (mb *MemoryBackend) readers(name string) (views []string, reads []string) {
+	visited := map[string]bool{name: true}
+	var visit func(table string)
+	visit = func(table string) {
+		for _, dep := range mb.dependents(table) {
+			if dep.auto {
+				continue
+			}
+
+			reads = append(reads, dep.name+" reads "+table)
+			if visited[dep.name] {
+				continue
+			}
+			visited[dep.name] = true
+			visit(dep.name)
+			views = append(views, dep.name)
+		}
+	}
+	visit(name)
+
+	return views, reads
+}
+
+// dropReaders drops the materialized views reading the table called name,
+// as DROP TABLE does with CASCADE, or fails listing them without it. It
+// must be called with mb.mu held for writing.
+func (mb *MemoryBackend) dropReaders(name string, cascade bool) error {
+	views, reads := mb.readers(name)
+	if len(views) == 0 {
+		return nil
+	}
+
+	if !cascade {
+		them := "them"
+		if len(views) == 1 {
+			them = "it"
+		}
+		return fmt.Errorf("%w: %s, use CASCADE to drop %s as well", ErrTableInUse, strings.Join(reads, ", "), them)
+	}
+
+	for _, view := range views {
+		if err := mb.engine.DropTable(view); err != nil {
+			return err
+		}
+		delete(mb.tables, view)
+		mb.changed(view)
+	}
+
+	return nil
+}
+
+// dependencyRows returns the rows of __dependencies.
+func (mb *MemoryBackend) dependencyRows() [][]interface{} {
+	rows := [][]interface{}{}
+	for _, table := range mb.tableNames() {
+		for _, dep := range mb.dependents(table) {
+			kind := "normal"
+			if dep.auto {
+				kind = "auto"
+			}
+			rows = append(rows, []interface{}{dep.kind, dep.name, table, kind})
+		}
+	}
+
+	return rows
+}
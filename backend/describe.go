@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+// Describe returns the columns slct returns without running it, which is
+// what protocols preparing a statement report before it is executed. The
+// types are those the columns have when every placeholder is bound to text.
+// slct must have been analyzed.
+func (mb *MemoryBackend) Describe(ctx context.Context, slct *parser.SelectStatement) ([]ResultColumn, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	// Only the columns of the table are needed, not its rows
+	t := &memoryTable{store: storage.NewTable()}
+	if slct.From != nil {
+		found, ok := mb.lookupTable(ctx, slct.From.Value)
+		if !ok {
+			return nil, ErrTableDoesNotExist
+		}
+
+		copied := *found
+		copied.store = storage.NewTable()
+		copied.positions = nil
+		t = &copied
+	} else if slct.Function != nil {
+		var err error
+		if t, err = mb.functionColumns(slct.Function); err != nil {
+			return nil, err
+		}
+	}
+
+	ev := &evaluation{ctx: ctx, mem: mb.budget.Reserve(), mb: mb, table: t}
+	defer ev.mem.Close()
+
+	if slct.ConnectBy != nil {
+		var err error
+		if t, err = ev.connectBy(slct.ConnectBy, t); err != nil {
+			return nil, err
+		}
+		ev.table = t
+	}
+
+	columns, _ := ev.resultColumns(slct)
+	return columns, nil
+}
@@ -0,0 +1,214 @@
+package backend
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+// DumpStatement is a query recreating part of a database, with the values
+// for its $1..$n placeholders.
+type DumpStatement struct {
+	Query  string
+	Params []interface{}
+}
+
+// Dump returns the statements recreating the tables, their indexes and
+// foreign keys, sequences, materialized views and comments of mb as they
+// are now. The parameters are JSON values, values of types JSON has no
+// values for are passed as text and cast back to the type of their column.
+// It fails if the rows of a table can't be read.
+func (mb *MemoryBackend) Dump() ([]DumpStatement, error) {
+	stmts, _, err := mb.dump(true)
+	return stmts, err
+}
+
+// DumpSchema returns the statements of Dump without those inserting the
+// rows the storage engine holds, and the names of the tables holding them.
+// They recreate the database from an engine that keeps its tables, see
+// storage.Checkpointer.
+func (mb *MemoryBackend) DumpSchema() ([]DumpStatement, []string) {
+	stmts, names, _ := mb.dump(false)
+	return stmts, names
+}
+
+// dump returns the statements of Dump, with those inserting the rows of the
+// tables the engine holds unless withRows is false, and the names of those
+// tables.
+func (mb *MemoryBackend) dump(withRows bool) ([]DumpStatement, []string, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	names := make([]string, 0, len(mb.tables))
+	for name, t := range mb.tables {
+		if t.view == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	stmts, stored, constraints := []DumpStatement{}, []string{}, []DumpStatement{}
+	for _, name := range names {
+		t := mb.tables[name]
+
+		defs := make([]string, len(t.columns))
+		for i, col := range t.columns {
+			defs[i] = parser.FormatIdentifier(col) + " " + t.columnTypes[i].String()
+			if t.collations[i] != nil {
+				defs[i] += " COLLATE " + parser.FormatIdentifier(t.collations[i].Name)
+			}
+			if id := t.identityOf(i); id != nil {
+				defs[i] += " " + id.String()
+			}
+		}
+
+		// The rows of external tables stay in their files
+		if t.external != nil {
+			stmts = append(stmts, DumpStatement{
+				Query: "CREATE EXTERNAL TABLE " + parser.FormatIdentifier(name) + " (" + strings.Join(defs, ", ") + ")" +
+					" LOCATION '" + strings.ReplaceAll(t.external.location, "'", "''") + "'" +
+					" FORMAT " + parser.FormatIdentifier(t.external.format),
+			})
+			stmts = append(stmts, dumpPolicies(name, t)...)
+			stmts = append(stmts, dumpMasks(name, t)...)
+			continue
+		}
+
+		create := "CREATE TABLE " + parser.FormatIdentifier(name) + " (" + strings.Join(defs, ", ") + ")"
+		if t.compression != "" {
+			create += " WITH (compression = '" + strings.ReplaceAll(t.compression, "'", "''") + "')"
+		}
+		stmts = append(stmts, DumpStatement{Query: create})
+		stored = append(stored, name)
+		if withRows {
+			rows := make([][]interface{}, 0, t.store.Len())
+			scan := t.store.Scan()
+			for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+				rows = append(rows, row)
+			}
+			if err := scan.Err(); err != nil {
+				return nil, nil, err
+			}
+			stmts = append(stmts, dumpRows(name, t, rows)...)
+		}
+		stmts = append(stmts, dumpIndexes(name, t)...)
+		stmts = append(stmts, dumpStatistics(name, t)...)
+		stmts = append(stmts, dumpPolicies(name, t)...)
+		stmts = append(stmts, dumpMasks(name, t)...)
+		constraints = append(constraints, dumpUniques(name, t)...)
+		constraints = append(constraints, dumpForeignKeys(name, t)...)
+	}
+	stmts = append(stmts, constraints...)
+
+	stmts = append(stmts, mb.dumpRoles()...)
+	stmts = append(stmts, mb.dumpGrants()...)
+
+	stmts = append(stmts, mb.dumpSequences()...)
+	stmts = append(stmts, mb.dumpViews()...)
+	return append(stmts, mb.dumpComments()...), stored, nil
+}
+
+// dumpRows returns the statements inserting rows into the table t called
+// name.
+func dumpRows(name string, t *memoryTable, rows [][]interface{}) []DumpStatement {
+	insert := dumpInsert(name, t)
+	stmts := make([]DumpStatement, len(rows))
+	for i, row := range rows {
+		stmts[i] = DumpStatement{Query: insert, Params: dumpParams(row)}
+	}
+
+	return stmts
+}
+
+// dumpInsert returns the INSERT adding a row into the table t called name,
+// with a parameter for the value of each column.
+func dumpInsert(name string, t *memoryTable) string {
+	values := make([]string, len(t.columns))
+	for i := range t.columns {
+		values[i] = "$" + strconv.Itoa(i+1)
+		switch t.columnTypes[i] {
+		case TextType, IntType, BoolType:
+		default:
+			values[i] += "::" + t.columnTypes[i].String()
+		}
+	}
+
+	// Identity columns keep the values they were given or generated
+	insert := "INSERT INTO " + parser.FormatIdentifier(name)
+	for _, id := range t.identities {
+		if id != nil && id.always {
+			insert += " OVERRIDING SYSTEM VALUE"
+			break
+		}
+	}
+	return insert + " VALUES (" + strings.Join(values, ", ") + ")"
+}
+
+// dumpParams returns the parameters of the INSERT of dumpInsert adding row.
+func dumpParams(row []interface{}) []interface{} {
+	params := make([]interface{}, len(row))
+	for i, v := range row {
+		params[i] = dumpValue(v)
+	}
+
+	return params
+}
+
+// dumpValue returns v as a value that survives being written out as JSON.
+func dumpValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, int64, bool, string:
+		return v
+	case float64:
+		// Infinities and NaN have no JSON number
+		if !math.IsInf(v, 0) && !math.IsNaN(v) {
+			return v
+		}
+	}
+
+	text, _ := types.Cast(v, types.Text)
+	return text
+}
+
+func (mb *MemoryBackend) dumpSequences() []DumpStatement {
+	mb.seqMu.Lock()
+	defer mb.seqMu.Unlock()
+
+	names := make([]string, 0, len(mb.sequences))
+	for name := range mb.sequences {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stmts := []DumpStatement{}
+	for _, name := range names {
+		seq := mb.sequences[name]
+
+		// A sequence that handed out values continues after the ones it
+		// reserved, like after replaying the log
+		start := seq.last
+		if seq.called {
+			start = seq.reserved
+		}
+
+		stmts = append(stmts, DumpStatement{
+			Query: "CREATE SEQUENCE " + parser.FormatIdentifier(name) +
+				" START WITH " + strconv.FormatInt(start, 10) +
+				" INCREMENT BY " + strconv.FormatInt(seq.increment, 10) +
+				" CACHE " + strconv.FormatInt(seq.cache, 10),
+		})
+
+		if seq.called {
+			stmts = append(stmts, DumpStatement{
+				Query:  "SELECT setval($1, $2)",
+				Params: []interface{}{name, start},
+			})
+		}
+	}
+
+	return stmts
+}
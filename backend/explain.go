@@ -0,0 +1,428 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+// Explain describes how stmt would run, one line of the plan per row. It
+// plans the statement's subqueries without running anything.
+func (mb *MemoryBackend) Explain(ctx context.Context, stmt *parser.ExplainStatement) (*Results, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	base := &evaluation{
+		ctx:      ctx,
+		mb:       mb,
+		patterns: map[string]*regexp.Regexp{},
+		plans:    map[*parser.SelectStatement]*subqueryPlan{},
+	}
+
+	var lines []string
+	var err error
+	switch inner := stmt.Statement; inner.Type {
+	case parser.SelectType:
+		base.hints = inner.SelectStatement.Hints
+		lines, err = base.explainSelect(inner.SelectStatement, 0)
+	case parser.InsertType:
+		lines = []string{"Insert: " + inner.InsertStatement.Table.Value}
+		if inner.InsertStatement.Values != nil {
+			var sub []string
+			sub, err = base.explainSubqueries(1, *inner.InsertStatement.Values...)
+			lines = append(lines, sub...)
+		}
+	case parser.CreateTableType:
+		lines = []string{"Create table: " + inner.CreateTableStatement.Name.Value}
+	case parser.DropTableType:
+		lines = []string{"Drop table: " + inner.DropTableStatement.Name.Value}
+		if inner.DropTableStatement.Cascade {
+			lines[0] += " cascade"
+		}
+	case parser.AlterTableType:
+		lines = []string{"Alter table: " + inner.AlterTableStatement.Table.Value}
+	case parser.CreateSequenceType:
+		lines = []string{"Create sequence: " + inner.CreateSequenceStatement.Name.Value}
+	case parser.CreateIndexType:
+		crt := inner.CreateIndexStatement
+		target := crt.Column.Value
+		if crt.Expression != nil {
+			target = crt.Expression.String()
+		}
+		lines = []string{"Create index: " + crt.Table.Value + " (" + target + ")"}
+		if crt.Where != nil {
+			lines[0] += " where " + crt.Where.String()
+		}
+	case parser.DropIndexType:
+		lines = []string{"Drop index: " + inner.DropIndexStatement.Name.Value}
+	case parser.CreateMaterializedViewType:
+		crt := inner.CreateMaterializedViewStatement
+		base.hints = crt.Query.Hints
+		lines, err = base.explainSelect(crt.Query, 1)
+		lines = append([]string{"Create materialized view: " + crt.Name.Value}, lines...)
+	case parser.RefreshType:
+		t, ok := mb.tables[inner.RefreshStatement.Name.Value]
+		if !ok || t.view == nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotMaterializedView, inner.RefreshStatement.Name.Value)
+		}
+
+		base.hints = t.view.query.Hints
+		lines, err = base.explainSelect(t.view.query, 1)
+		lines = append([]string{"Refresh materialized view: " + inner.RefreshStatement.Name.Value}, lines...)
+	default:
+		return nil, errors.New("Statement can't be explained")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := Results{Columns: []ResultColumn{{Type: TextType, Name: "QUERY PLAN"}}}
+	for _, line := range lines {
+		results.Rows = append(results.Rows, []interface{}{line})
+	}
+
+	return &results, nil
+}
+
+// Validate plans stmt like Explain does without running it, returning the
+// error it would fail with before it reads or changes any rows. Besides
+// planning, the names of new tables, indexes, roles and sequences are checked
+// to be free.
+// stmt must have been analyzed.
+func (mb *MemoryBackend) Validate(ctx context.Context, stmt *parser.Statement) error {
+	Optimize(stmt)
+
+	switch stmt.Type {
+	case parser.CreateTableType:
+		return mb.validateNewTable(stmt.CreateTableStatement.Name.Value)
+	case parser.CreateIndexType:
+		mb.mu.RLock()
+		defer mb.mu.RUnlock()
+
+		_, _, err := mb.newIndex(stmt.CreateIndexStatement)
+		return err
+	case parser.DropIndexType:
+		mb.mu.RLock()
+		defer mb.mu.RUnlock()
+
+		if _, _, ok := mb.findIndex(stmt.DropIndexStatement.Name.Value); !ok {
+			return fmt.Errorf("%w: %s", ErrIndexDoesNotExist, stmt.DropIndexStatement.Name.Value)
+		}
+	case parser.CreateStatisticsType:
+		mb.mu.RLock()
+		defer mb.mu.RUnlock()
+
+		_, _, err := mb.newStatistics(stmt.CreateStatisticsStatement)
+		return err
+	case parser.DropStatisticsType:
+		mb.mu.RLock()
+		defer mb.mu.RUnlock()
+
+		if _, _, ok := mb.findStatistics(stmt.DropStatisticsStatement.Name.Value); !ok {
+			return fmt.Errorf("%w: %s", ErrStatisticsDoNotExist, stmt.DropStatisticsStatement.Name.Value)
+		}
+	case parser.CreateRoleType:
+		mb.mu.RLock()
+		defer mb.mu.RUnlock()
+
+		if name := stmt.CreateRoleStatement.Name.Value; mb.roles[name] {
+			return fmt.Errorf("%w: %s", ErrRoleExists, name)
+		}
+	case parser.DropRoleType:
+		mb.mu.RLock()
+		defer mb.mu.RUnlock()
+
+		if name := stmt.DropRoleStatement.Name.Value; !mb.roles[name] {
+			return fmt.Errorf("%w: %s", ErrRoleDoesNotExist, name)
+		}
+	case parser.FlashbackType:
+		mb.mu.RLock()
+		defer mb.mu.RUnlock()
+
+		_, _, err := mb.flashbackRows(ctx, stmt.FlashbackStatement, nil, nil)
+		return err
+	case parser.CreateSequenceType:
+		mb.seqMu.Lock()
+		_, ok := mb.sequences[stmt.CreateSequenceStatement.Name.Value]
+		mb.seqMu.Unlock()
+		if ok {
+			return ErrSequenceAlreadyExists
+		}
+	case parser.CreateMaterializedViewType:
+		if err := mb.validateNewTable(stmt.CreateMaterializedViewStatement.Name.Value); err != nil {
+			return err
+		}
+		fallthrough
+	case parser.SelectType, parser.InsertType, parser.RefreshType:
+		_, err := mb.Explain(ctx, &parser.ExplainStatement{Statement: stmt})
+		return err
+	}
+
+	return nil
+}
+
+func (mb *MemoryBackend) validateNewTable(name string) error {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	if _, ok := mb.tables[name]; ok {
+		return ErrTableAlreadyExists
+	}
+	if isSystemTable(name) {
+		return ErrSystemTable
+	}
+
+	return nil
+}
+
+func indent(depth int, line string) string {
+	return strings.Repeat("  ", depth) + line
+}
+
+func (ev *evaluation) explainSelect(slct *parser.SelectStatement, depth int) ([]string, error) {
+	t, err := ev.subqueryTable(slct)
+	if err != nil {
+		return nil, err
+	}
+	scope := ev.sub(t, nil)
+
+	lines := []string{}
+	exps := []*parser.Expression{}
+	for _, item := range slct.Item {
+		if !item.Asterisk {
+			exps = append(exps, item.Exp)
+		}
+	}
+
+	for _, line := range explainOrder(slct) {
+		lines = append(lines, indent(depth, line))
+		depth++
+	}
+
+	if calls := aggregateCalls(exps...); len(calls) > 0 {
+		names := make([]string, len(calls))
+		for i, call := range calls {
+			names[i] = (&parser.Expression{Call: call, Type: parser.CallType}).String()
+		}
+
+		lines = append(lines, indent(depth, "Aggregate: "+strings.Join(names, ", ")))
+		depth++
+	}
+
+	if slct.Where != nil {
+		lines = append(lines, indent(depth, "Filter: "+slct.Where.String()))
+		depth++
+	}
+
+	if c := slct.ConnectBy; c != nil {
+		line := "Connect by: PRIOR " + c.Prior.Value + " = " + c.Child.Value
+		if c.Start != nil {
+			line += " (start with " + c.Start.String() + ")"
+		}
+		lines = append(lines, indent(depth, line))
+		depth++
+	}
+
+	if slct.From != nil {
+		scan := "Scan: " + scanned(slct, t)
+		source, ok := ev.mb.lookupTable(ev.ctx, slct.From.Value)
+		if ok && source.external != nil {
+			scan = "External scan: " + scanned(slct, t)
+		} else if slct.AsOf != nil {
+			scan = "Historic scan: " + scanned(slct, t) + " as of " + slct.AsOf.String()
+		} else if ok {
+			if index := ev.indexFor(slct, source); index != nil && index.eq != nil {
+				scan = "Index scan: " + scanned(slct, t) + " using " + index.idx.name + " (" + index.String() + ")"
+			} else if index != nil {
+				scan = "Index range scan: " + scanned(slct, t) + " using " + index.idx.name + " (" + index.String() + ")"
+			}
+			if _, forced := ev.hintedIndexes(IndexHint, slct); forced && strings.HasPrefix(scan, "Index") {
+				scan += " as hinted"
+			}
+		}
+		lines = append(lines, indent(depth, scan))
+
+		if rows, estimated := ev.estimateRows(slct, source); ok && estimated {
+			lines = append(lines, indent(depth+1, "Estimated rows: "+strconv.FormatInt(rows, 10)))
+		}
+
+		if ok && len(source.policies) > 0 {
+			names := make([]string, len(source.policies))
+			for i, p := range source.policies {
+				names[i] = p.name
+			}
+			lines = append(lines, indent(depth+1, "Policies: "+strings.Join(names, ", ")))
+		}
+	} else if slct.Function != nil {
+		lines = append(lines, indent(depth, "Function scan: "+slct.Function.String()))
+	} else {
+		lines = append(lines, indent(depth, "Result"))
+	}
+
+	exps = append(exps, slct.Where)
+	for _, order := range slct.OrderBy {
+		exps = append(exps, order.Exp)
+	}
+	sub, err := scope.explainSubqueries(depth, exps...)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(lines, sub...), nil
+}
+
+// scanned describes the table slct scans and the columns it reads of it.
+func scanned(slct *parser.SelectStatement, t *memoryTable) string {
+	if len(t.columns) == 0 {
+		return slct.From.Value
+	}
+
+	return slct.From.Value + " (" + strings.Join(t.columns, ", ") + ")"
+}
+
+// explainSubqueries describes how each subquery in exps runs for the rows of
+// ev's table.
+func (ev *evaluation) explainSubqueries(depth int, exps ...*parser.Expression) ([]string, error) {
+	lines := []string{}
+	for _, exp := range subqueries(exps...) {
+		slct, mode, not := exp.Subquery, scalarSubquery, false
+		switch exp.Type {
+		case parser.ExistsType:
+			slct, mode, not = exp.Exists.Query, existsSubquery, exp.Exists.Not
+		case parser.InType:
+			slct, mode = exp.In.List[0].Subquery, inSubquery
+		}
+
+		plan, err := ev.planSubquery(slct, mode)
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, indent(depth, "Subquery: "+exp.String()))
+		name := "result"
+		if slct.From != nil {
+			name = scanned(slct, plan.table)
+		} else if slct.Function != nil {
+			name = slct.Function.String()
+		}
+
+		var filter []*parser.Expression
+		switch plan.kind {
+		case subqueryConstant:
+			lines = append(lines, indent(depth+1, "Run once: "+name))
+			filter = plan.residual
+		case subqueryHashed:
+			var how string
+			switch {
+			case mode == existsSubquery && not:
+				how = "Hash anti-join: "
+			case mode == existsSubquery:
+				how = "Hash semi-join: "
+			case isAggregate(slct):
+				how = "Hash join on grouped aggregate: "
+			default:
+				how = "Hash lookup: "
+			}
+
+			keys := make([]string, len(plan.innerKeys))
+			for i := range plan.innerKeys {
+				keys[i] = plan.innerKeys[i].String() + " = " + plan.outerKeys[i].String()
+			}
+
+			lines = append(lines, indent(depth+1, how+name+" on "+strings.Join(keys, " and ")))
+			filter = plan.residual
+		default:
+			how := "Run for every row: "
+			if ev.hinted(NestedLoopHint, slct) {
+				how = "Run for every row as hinted: "
+			}
+
+			lines = append(lines, indent(depth+1, how+name))
+			if slct.Where != nil {
+				filter = []*parser.Expression{slct.Where}
+			}
+		}
+
+		for _, line := range explainOrder(slct) {
+			lines = append(lines, indent(depth+2, line))
+		}
+		for _, exp := range filter {
+			lines = append(lines, indent(depth+2, "Filter: "+exp.String()))
+		}
+
+		// Subqueries of the subquery run for its rows
+		inner := ev.sub(plan.table, nil)
+		nested := []*parser.Expression{slct.Where}
+		for _, item := range slct.Item {
+			if !item.Asterisk {
+				nested = append(nested, item.Exp)
+			}
+		}
+
+		sub, err := inner.explainSubqueries(depth+2, nested...)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, sub...)
+	}
+
+	return lines, nil
+}
+
+// subqueries returns the EXISTS, IN and scalar subqueries in exps, leaving
+// out the ones nested in other subqueries.
+func subqueries(exps ...*parser.Expression) []*parser.Expression {
+	found := []*parser.Expression{}
+
+	var walk func(exp *parser.Expression)
+	walk = func(exp *parser.Expression) {
+		switch exp.Type {
+		case parser.ExistsType, parser.SubqueryType:
+			found = append(found, exp)
+		case parser.BinaryType:
+			walk(&exp.Binary.A)
+			walk(&exp.Binary.B)
+		case parser.CastType:
+			walk(&exp.Cast.Exp)
+		case parser.IndexType:
+			walk(&exp.Index.Exp)
+			walk(&exp.Index.Index)
+		case parser.CallType:
+			for i := range exp.Call.Args {
+				walk(&exp.Call.Args[i])
+			}
+		case parser.ArrayType:
+			for i := range exp.Array {
+				walk(&exp.Array[i])
+			}
+		case parser.RowType:
+			for i := range exp.Row {
+				walk(&exp.Row[i])
+			}
+		case parser.InType:
+			walk(&exp.In.Exp)
+			if exp.In.Subquery {
+				found = append(found, exp)
+				break
+			}
+			for i := range exp.In.List {
+				walk(&exp.In.List[i])
+			}
+		case parser.NotType:
+			walk(exp.Not)
+		}
+	}
+
+	for _, exp := range exps {
+		if exp != nil {
+			walk(exp)
+		}
+	}
+
+	return found
+}
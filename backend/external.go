@@ -0,0 +1,200 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/nireo/sgsql/foreign"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrExternalTable = errors.New("External tables are read-only")
+	ErrNoExternalDir = errors.New("External tables can't be read, no directory is set for their files")
+)
+
+// externalTable is the file an external table reads its rows from.
+type externalTable struct {
+	location string
+	format   string
+}
+
+// SetExternalDir lets external tables read the files in dir and the
+// directories below it, relative locations are relative to dir. External
+// tables can be created without a directory, but reading files fails until
+// one is set, so a database doesn't let its users read the files of the
+// machine it runs on unless told to. Remote tables don't need one.
+func (mb *MemoryBackend) SetExternalDir(dir string) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.externalDir = dir
+}
+
+// createExternalTable adds t as the external table crt creates. The file
+// isn't read until the table is, so it doesn't have to exist yet. Only the
+// superuser may create tables reading a remote database, which is read with
+// the credentials of the embedding program. It must be called with mb.mu
+// held for writing.
+func (mb *MemoryBackend) createExternalTable(crt *parser.CreateTableStatement, t *memoryTable, session *functions.Session) error {
+	if t.identities != nil {
+		return fmt.Errorf("%w: %s can't have identity columns", ErrExternalTable, crt.Name.Value)
+	}
+
+	external := &externalTable{location: crt.Location.Value}
+	if crt.Format != nil {
+		external.format = crt.Format.Value
+	} else {
+		external.format = strings.ToLower(strings.TrimPrefix(filepath.Ext(external.location), "."))
+	}
+
+	format, ok := foreign.Lookup(external.format)
+	if !ok {
+		return fmt.Errorf("%w: %q, expected one of %s", foreign.ErrNoSuchFormat,
+			external.format, strings.Join(foreign.Formats(), ", "))
+	}
+
+	if _, ok := format.(foreign.RemoteFormat); ok {
+		if err := checkSuperuser(session, "create external tables of remote databases"); err != nil {
+			return err
+		}
+	}
+
+	t.external = external
+	t.store = storage.NewTable()
+	mb.tables[crt.Name.Value] = t
+	mb.changed(crt.Name.Value)
+	return nil
+}
+
+// externalPath returns the path of the file at location, which must be in
+// the directory external tables may read. Symbolic links are followed
+// before checking, so a link in the directory can't lead out of it.
+func (mb *MemoryBackend) externalPath(location string) (string, error) {
+	if mb.externalDir == "" {
+		return "", ErrNoExternalDir
+	}
+
+	dir, err := filepath.Abs(mb.externalDir)
+	if err != nil {
+		return "", err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", err
+	}
+
+	path := location
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("External table file %s is outside of %s", location, mb.externalDir)
+	}
+
+	return path, nil
+}
+
+// externalRows returns t with the rows its file or remote table holds now,
+// converted to the types of its columns. They are read again every time, so
+// queries see changes made to them since.
+func (ev *evaluation) externalRows(name string, t *memoryTable) (*memoryTable, error) {
+	format, ok := foreign.Lookup(t.external.format)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", foreign.ErrNoSuchFormat, t.external.format)
+	}
+
+	columns := make([]foreign.Column, len(t.columns))
+	for i, col := range t.columns {
+		columns[i] = foreign.Column{Name: col, Type: t.columnTypes[i]}
+	}
+
+	var r foreign.Reader
+	var err error
+	if remote, ok := format.(foreign.RemoteFormat); ok {
+		ctx := ev.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		r, err = remote.OpenRemote(ctx, t.external.location, columns)
+	} else {
+		var path string
+		if path, err = ev.mb.externalPath(t.external.location); err != nil {
+			return nil, err
+		}
+		r, err = format.Open(path, columns)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("External table %s: %w", name, err)
+	}
+	defer r.Close()
+
+	rows := []storage.Row{}
+	for {
+		if err := ev.canceled(); err != nil {
+			return nil, err
+		}
+
+		values, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("External table %s: %w", name, err)
+		}
+
+		if len(values) != len(t.columns) {
+			return nil, fmt.Errorf("External table %s, row %d: %d values for %d columns",
+				name, len(rows)+1, len(values), len(t.columns))
+		}
+
+		row := make(storage.Row, len(t.columns))
+		for i, v := range values {
+			if s, ok := v.(string); ok && t.columnTypes[i] != TextType {
+				v, err = types.Cast(s, t.columnTypes[i])
+				if err != nil {
+					return nil, fmt.Errorf("External table %s, row %d: %w", name, len(rows)+1, err)
+				}
+			}
+
+			var ok bool
+			if row[i], ok = types.Assign(v, t.columnTypes[i]); !ok {
+				return nil, fmt.Errorf("External table %s, row %d: %v can't be stored in %s column %s",
+					name, len(rows)+1, v, t.columnTypes[i], t.columns[i])
+			}
+		}
+
+		if err := ev.mem.Grow(rowSize(row)); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	read := *t
+	read.store = storage.NewTable(rows...)
+	return &read, nil
+}
+
+// readsExternal reports whether one of tables is an external table, whose
+// rows can change without the database knowing. It must be called with
+// mb.mu held.
+func (mb *MemoryBackend) readsExternal(tables []string) bool {
+	for _, name := range tables {
+		if t, ok := mb.tables[name]; ok && t.external != nil {
+			return true
+		}
+	}
+
+	return false
+}
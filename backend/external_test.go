@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nireo/sgsql/foreign"
+	"github.com/nireo/sgsql/functions"
+)
+
+// remoteFormat is a remote format whose tables are empty.
+type remoteFormat struct{}
+
+func (remoteFormat) Open(location string, columns []foreign.Column) (foreign.Reader, error) {
+	return emptyReader{}, nil
+}
+
+func (remoteFormat) OpenRemote(ctx context.Context, location string, columns []foreign.Column) (foreign.Reader, error) {
+	return emptyReader{}, nil
+}
+
+type emptyReader struct{}
+
+func (emptyReader) Next() ([]interface{}, error) { return nil, io.EOF }
+func (emptyReader) Close() error                 { return nil }
+
+func init() {
+	foreign.Register("testremote", remoteFormat{})
+}
+
+func TestOnlySuperuserCreatesRemoteTables(t *testing.T) {
+	mb, session := testBackend(t)
+
+	alice := functions.NewSession(mb)
+	alice.SetUser("alice")
+	query := "create external table rates (code text) location 'public.rates' format testremote"
+	if _, err := run(mb, alice, query); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("%s as alice: got %v, want %v", query, err, ErrPermissionDenied)
+	}
+
+	if _, err := run(mb, session, query); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(mb, alice, "select code from rates"); err != nil {
+		t.Errorf("reading the remote table as alice: %v", err)
+	}
+}
+
+func TestExternalPathFollowsLinks(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "external")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(dir, "inside.csv"):  "a\n1\n",
+		filepath.Join(root, "secret.csv"): "a\n2\n",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(root, "secret.csv"), filepath.Join(dir, "link.csv")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink("inside.csv", filepath.Join(dir, "alias.csv")); err != nil {
+		t.Fatal(err)
+	}
+
+	mb := NewMemoryBackend()
+	mb.SetExternalDir(dir)
+
+	tests := []struct {
+		location string
+		ok       bool
+	}{
+		{"inside.csv", true},
+		{"alias.csv", true},
+		{"link.csv", false},
+		{"../secret.csv", false},
+		{filepath.Join(root, "secret.csv"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			_, err := mb.externalPath(tt.location)
+			if (err == nil) != tt.ok {
+				t.Errorf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
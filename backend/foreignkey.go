@@ -0,0 +1,328 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+var ErrForeignKey = errors.New("Foreign key violation")
+
+// foreignKey makes column of a table a foreign key referencing the column
+// references of the table called table: every value of it other than NULL
+// must be one that column holds. Rows are never changed, and the tables
+// referenced can't lose theirs to DROP TABLE or FLASHBACK, so only the rows
+// inserted into the table of the key are checked. A deferred key is only
+// checked when the transaction inserting them commits, see CheckDeferred,
+// so a transaction can insert rows referencing those it inserts later.
+type foreignKey struct {
+	column     string
+	table      string
+	references string
+	deferred   bool
+}
+
+// String describes fk like ALTER TABLE adds it.
+func (fk *foreignKey) String() string {
+	return "FOREIGN KEY (" + parser.FormatIdentifier(fk.column) + ") REFERENCES " +
+		parser.FormatIdentifier(fk.table) + " (" + parser.FormatIdentifier(fk.references) + ")" + deferral(fk.deferred)
+}
+
+// violation is the error of a row of the table called name whose value v
+// for fk isn't held by the column it references.
+func (fk *foreignKey) violation(name string, v interface{}) error {
+	return fmt.Errorf("%w: %s.%s = %v is not in %s.%s", ErrForeignKey, name, fk.column, v, fk.table, fk.references)
+}
+
+// foreignKeyOf returns the foreign key of t over column, nil if it isn't
+// one.
+func (t *memoryTable) foreignKeyOf(column string) *foreignKey {
+	for _, fk := range t.foreignKeys {
+		if fk.column == column {
+			return fk
+		}
+	}
+
+	return nil
+}
+
+// newForeignKey checks that column of the table t called name can reference
+// what ref names and returns the foreign key. A table can reference itself,
+// t may not have been added to mb yet. It must be called with mb.mu held.
+func (mb *MemoryBackend) newForeignKey(name string, t *memoryTable, column string, ref *parser.ForeignKey) (*foreignKey, error) {
+	i, ok := t.columnIndex(column)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, column)
+	}
+
+	referenced := t
+	if table := ref.Table.Value; table != name {
+		if isSystemTable(table) {
+			return nil, ErrSystemTable
+		}
+
+		if referenced, ok = mb.tables[table]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrTableDoesNotExist, table)
+		}
+
+		switch {
+		case referenced.view != nil:
+			return nil, fmt.Errorf("%w: %s", ErrMaterializedView, table)
+		case referenced.external != nil:
+			return nil, fmt.Errorf("%w: %s", ErrExternalTable, table)
+		}
+	}
+
+	j, ok := referenced.columnIndex(ref.Column.Value)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, ref.Column.Value)
+	}
+
+	switch {
+	case t.columnTypes[i] != referenced.columnTypes[j]:
+		return nil, fmt.Errorf("%w: foreign key %s is %s but %s.%s is %s",
+			ErrInvalidDatatype, column, t.columnTypes[i], ref.Table.Value, ref.Column.Value, referenced.columnTypes[j])
+	case t.columnTypes[i].IsArray():
+		return nil, fmt.Errorf("%w: column %s is an array, arrays can't be foreign keys", ErrInvalidDatatype, column)
+	}
+
+	return &foreignKey{column: column, table: ref.Table.Value, references: ref.Column.Value, deferred: ref.Deferred}, nil
+}
+
+// checkForeignKeys checks that the foreign keys of the table t called name
+// hold for rows, which are about to be inserted into it in order. A table
+// referencing itself can also reference each row itself and the rows
+// inserted before it, as the key is checked once the row is in the table.
+// The deferred keys are left to CheckDeferred. It must be called with mb.mu
+// held.
+func (mb *MemoryBackend) checkForeignKeys(name string, t *memoryTable, rows ...storage.Row) error {
+	for _, fk := range t.foreignKeys {
+		if fk.deferred {
+			continue
+		}
+
+		i, _ := t.columnIndex(fk.column)
+		referenced := t
+		if fk.table != name {
+			referenced = mb.tables[fk.table]
+		}
+		j, _ := referenced.columnIndex(fk.references)
+
+		for r, row := range rows {
+			v := row[i]
+			if v == nil {
+				continue
+			}
+
+			found, err := holds(referenced, j, v)
+			if err != nil {
+				return err
+			}
+			if !found && referenced == t {
+				for _, before := range rows[:r+1] {
+					if before[j] != nil && compareKeys(before[j], v) == 0 {
+						found = true
+						break
+					}
+				}
+			}
+
+			if !found {
+				return fk.violation(name, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// holds reports whether the column at i of t holds v, which has the type of
+// the column. It looks v up in an index over the column when there is one
+// and scans the table otherwise.
+func holds(t *memoryTable, i int, v interface{}) (bool, error) {
+	for _, idx := range t.indexes {
+		if idx.live && idx.expression == nil && idx.where == nil && idx.position == i {
+			return len(idx.lookup(v)) > 0, nil
+		}
+	}
+
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if row[i] != nil && compareKeys(row[i], v) == 0 {
+			return true, nil
+		}
+	}
+
+	return false, scan.Err()
+}
+
+// reference is a foreign key of the table called table.
+type reference struct {
+	table string
+	fk    *foreignKey
+}
+
+// String describes r like "c.pid references p.id".
+func (r reference) String() string {
+	return r.table + "." + r.fk.column + " references " + r.fk.table + "." + r.fk.references
+}
+
+// referencing returns the foreign keys referencing the table called name,
+// or only its column when column isn't empty, those of name itself
+// included. It must be called with mb.mu held.
+func (mb *MemoryBackend) referencing(name, column string) []reference {
+	refs := []reference{}
+	for _, table := range mb.tableNames() {
+		for _, fk := range mb.tables[table].foreignKeys {
+			if fk.table == name && (column == "" || fk.references == column) {
+				refs = append(refs, reference{table: table, fk: fk})
+			}
+		}
+	}
+
+	return refs
+}
+
+// referencedBy returns a foreign key of another table referencing the table
+// called name, which would be left referencing rows that aren't there if
+// its rows were removed. It must be called with mb.mu held.
+func (mb *MemoryBackend) referencedBy(name string) (reference, bool) {
+	for _, ref := range mb.referencing(name, "") {
+		if ref.table != name {
+			return ref, true
+		}
+	}
+
+	return reference{}, false
+}
+
+// dropReferences drops the foreign keys of other tables referencing the
+// table called name, as DROP TABLE does with CASCADE, or fails listing them
+// without it. It must be called with mb.mu held for writing.
+func (mb *MemoryBackend) dropReferences(name string, cascade bool) error {
+	refs := []reference{}
+	for _, ref := range mb.referencing(name, "") {
+		if ref.table != name {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	if !cascade {
+		described := make([]string, len(refs))
+		for i, ref := range refs {
+			described[i] = ref.String()
+		}
+		return fmt.Errorf("%w: %s, use CASCADE to drop the foreign keys", ErrTableInUse, strings.Join(described, ", "))
+	}
+
+	for _, ref := range refs {
+		t := mb.tables[ref.table]
+		dropped := *t
+		dropped.foreignKeys = []*foreignKey{}
+		for _, fk := range t.foreignKeys {
+			if fk != ref.fk {
+				dropped.foreignKeys = append(dropped.foreignKeys, fk)
+			}
+		}
+		mb.tables[ref.table] = &dropped
+	}
+
+	return nil
+}
+
+// addForeignKey makes a column of t, the table called name, a foreign key
+// as ALTER TABLE ADD FOREIGN KEY does, once every row it holds meets it. It
+// must be called with mb.mu held for writing.
+func (mb *MemoryBackend) addForeignKey(name string, t *memoryTable, constraint *parser.TableConstraint) error {
+	fk, err := mb.newForeignKey(name, t, constraint.Column.Value, constraint.References)
+	if err != nil {
+		return err
+	}
+
+	if t.foreignKeyOf(fk.column) != nil {
+		return fmt.Errorf("Column %s is already a foreign key", fk.column)
+	}
+
+	if err := mb.checkReferences(name, t, fk); err != nil {
+		return err
+	}
+
+	constrained := *t
+	constrained.foreignKeys = append(append([]*foreignKey{}, t.foreignKeys...), fk)
+	mb.tables[name] = &constrained
+	return nil
+}
+
+// checkReferences checks that fk holds for every row of the table t called
+// name. The rows are all in the table, so one referencing the table itself
+// may reference any of them. It must be called with mb.mu held.
+func (mb *MemoryBackend) checkReferences(name string, t *memoryTable, fk *foreignKey) error {
+	referenced := t
+	if fk.table != name {
+		referenced = mb.tables[fk.table]
+	}
+	i, _ := t.columnIndex(fk.column)
+	j, _ := referenced.columnIndex(fk.references)
+
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if row[i] == nil {
+			continue
+		}
+
+		found, err := holds(referenced, j, row[i])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fk.violation(name, row[i])
+		}
+	}
+
+	return scan.Err()
+}
+
+// UnindexedForeignKeys returns the columns of table that are foreign keys
+// without an index over them. Finding the rows referencing a value of the
+// table they reference has to scan the whole table without one.
+func (mb *MemoryBackend) UnindexedForeignKeys(table string) []string {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	t, ok := mb.tables[table]
+	if !ok {
+		return nil
+	}
+
+	columns := []string{}
+	for _, fk := range t.foreignKeys {
+		i, _ := t.columnIndex(fk.column)
+		indexed := false
+		for _, idx := range t.indexes {
+			indexed = indexed || (idx.expression == nil && idx.where == nil && idx.position == i)
+		}
+		if !indexed {
+			columns = append(columns, fk.column)
+		}
+	}
+
+	return columns
+}
+
+// dumpForeignKeys returns the statements adding the foreign keys of the
+// table t called name. They come after the rows of every table, which they
+// check.
+func dumpForeignKeys(name string, t *memoryTable) []DumpStatement {
+	stmts := make([]DumpStatement, len(t.foreignKeys))
+	for i, fk := range t.foreignKeys {
+		stmts[i] = DumpStatement{Query: "ALTER TABLE " + parser.FormatIdentifier(name) + " ADD " + fk.String()}
+	}
+
+	return stmts
+}
@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/functions"
+)
+
+func TestForeignKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		fails   bool
+		err     error
+	}{
+		{"referenced", []string{"insert into c values (1, 3)"}, false, nil},
+		{"null", []string{"insert into c values (1, null)"}, false, nil},
+		{"not referenced", []string{"insert into c values (1, 4)"}, true, ErrForeignKey},
+		{"indexed", []string{"create index on t (id)", "insert into c values (1, 2)", "insert into c values (2, 7)"}, true, ErrForeignKey},
+		{"self", []string{
+			"create table tree (id int, parent int references tree (id))",
+			"insert into tree values (1, null)",
+			"insert into tree values (2, 1)",
+			"insert into tree values (3, 3)",
+		}, false, nil},
+		{"self not referenced", []string{"create table tree (id int, parent int references tree (id))", "insert into tree values (1, 2)"}, true, ErrForeignKey},
+		{"type", []string{"create table d (name int references t (name))"}, true, ErrInvalidDatatype},
+		{"missing table", []string{"create table d (id int references u (id))"}, true, ErrTableDoesNotExist},
+		{"drop referenced", []string{"drop table t"}, true, ErrTableInUse},
+		{"drop cascade", []string{"drop table t cascade", "insert into c values (1, 9)"}, false, nil},
+		{"drop referencing", []string{"drop table c", "drop table t"}, false, nil},
+		{"drop referenced column", []string{"alter table t drop column id"}, true, nil},
+		{"drop key column", []string{"alter table c drop column tid", "drop table t"}, false, nil},
+		{"change type", []string{"alter table c alter column tid type float"}, true, nil},
+		{"change referenced type", []string{"alter table t alter column id type float"}, true, nil},
+		{"flashback referenced", []string{"flashback table t to rows 1"}, true, ErrTableInUse},
+		{"add", []string{"create table d (tid int)", "insert into d values (1)", "alter table d add foreign key (tid) references t (id)",
+			"insert into d values (4)"}, true, ErrForeignKey},
+		{"add violated", []string{"create table d (tid int)", "insert into d values (4)", "alter table d add foreign key (tid) references t (id)"}, true, ErrForeignKey},
+		{"add column", []string{"alter table t add column parent int references t (id)", "insert into t values (4, 'd', null, 9)"}, true, ErrForeignKey},
+		{"renamed", []string{"alter table t rename to u", "alter table u rename column id to key", "insert into c values (1, 9)"}, true, ErrForeignKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+			if _, err := run(mb, session, "create table c (id int, tid int references t (id))"); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			for _, query := range tt.queries {
+				if _, err = run(mb, session, query); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.fails || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUnindexedForeignKeys(t *testing.T) {
+	mb, session := testBackend(t)
+	for _, query := range []string{
+		"create table c (id int references t (id), tid int references t (id), name text)",
+		"create index on c (tid)",
+	} {
+		if _, err := run(mb, session, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := mb.UnindexedForeignKeys("c"); !reflect.DeepEqual(got, []string{"id"}) {
+		t.Errorf("got %v, want [id]", got)
+	}
+}
+
+func TestDumpForeignKeys(t *testing.T) {
+	mb, session := testBackend(t)
+	for _, query := range []string{
+		"create table c (id int, tid int references t (id))",
+		"insert into c values (1, 3)",
+	} {
+		if _, err := run(mb, session, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stmts, err := mb.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := stmts[len(stmts)-1].Query
+	if want := "ALTER TABLE c ADD FOREIGN KEY (tid) REFERENCES t (id)"; last != want {
+		t.Fatalf("got %q last, want %q", last, want)
+	}
+
+	restored := NewMemoryBackend()
+	session = functions.NewSession(restored)
+	for _, stmt := range stmts {
+		if _, err := run(restored, session, stmt.Query, stmt.Params...); err != nil {
+			t.Fatalf("%s: %v", stmt.Query, err)
+		}
+	}
+	if _, err := run(restored, session, "insert into c values (2, 4)"); !errors.Is(err, ErrForeignKey) {
+		t.Errorf("got %v, want %v", err, ErrForeignKey)
+	}
+}
@@ -0,0 +1,136 @@
+package backend
+
+import (
+	"github.com/nireo/sgsql/parser"
+)
+
+// NestedLoopHint names tables whose subqueries run again for every row of
+// the enclosing query instead of being turned into joins, for when that
+// is cheaper, like when few enclosing rows pass the WHERE.
+const NestedLoopHint = "nested_loop"
+
+// IndexHint names a table followed by the indexes of it queries may scan
+// it with, like INDEX(users users_email), for when statistics point the
+// planner at the wrong one. Only those of them the WHERE can use are
+// picked from, without any the table is scanned whole.
+const IndexHint = "index"
+
+// NoIndexHint names a table followed by the indexes of it queries must not
+// scan it with, or every index when none follows.
+const NoIndexHint = "no_index"
+
+// Hints are the names of the planner hints the backend honors.
+var Hints = []string{NestedLoopHint, IndexHint, NoIndexHint}
+
+// IndexHints are the hints whose arguments after the table are the names
+// of its indexes.
+var IndexHints = []string{IndexHint, NoIndexHint}
+
+// IndexCatalog is a Catalog that knows the indexes of its tables.
+type IndexCatalog interface {
+	Catalog
+	// Indexes returns the names of the indexes of table.
+	Indexes(table string) []string
+}
+
+// hinted reports whether a hint called name applies to the table of slct.
+// Hints of a statement apply to all of its subqueries, hints of a subquery
+// only to itself.
+func (ev *evaluation) hinted(name string, slct *parser.SelectStatement) bool {
+	if slct.From == nil {
+		return false
+	}
+
+	for _, hints := range [][]parser.Hint{ev.hints, slct.Hints} {
+		for _, hint := range hints {
+			if hint.Name.Value != name {
+				continue
+			}
+
+			for _, arg := range hint.Args {
+				if arg.Value == slct.From.Value {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// hintedIndexes returns the indexes hints called name give for the table
+// of slct, and whether there are any such hints.
+func (ev *evaluation) hintedIndexes(name string, slct *parser.SelectStatement) ([]string, bool) {
+	if slct.From == nil {
+		return nil, false
+	}
+
+	indexes, found := []string{}, false
+	for _, hints := range [][]parser.Hint{ev.hints, slct.Hints} {
+		for _, hint := range hints {
+			if hint.Name.Value != name || len(hint.Args) == 0 || hint.Args[0].Value != slct.From.Value {
+				continue
+			}
+
+			found = true
+			for _, arg := range hint.Args[1:] {
+				indexes = append(indexes, arg.Value)
+			}
+		}
+	}
+
+	return indexes, found
+}
+
+// hintedTable returns t with only the indexes the INDEX and NO_INDEX hints
+// for slct let it be scanned with, or t itself without such hints.
+func (ev *evaluation) hintedTable(slct *parser.SelectStatement, t *memoryTable) *memoryTable {
+	only, forced := ev.hintedIndexes(IndexHint, slct)
+	not, avoided := ev.hintedIndexes(NoIndexHint, slct)
+	if !forced && !avoided {
+		return t
+	}
+
+	contains := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	indexes := []*index{}
+	for _, idx := range t.indexes {
+		if forced && !contains(only, idx.name) {
+			continue
+		}
+		if avoided && (len(not) == 0 || contains(not, idx.name)) {
+			continue
+		}
+		indexes = append(indexes, idx)
+	}
+
+	hinted := *t
+	hinted.indexes = indexes
+	return &hinted
+}
+
+// Indexes returns the names of the indexes of table. It implements
+// IndexCatalog.
+func (mb *MemoryBackend) Indexes(table string) []string {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	t, ok := mb.tables[table]
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, len(t.indexes))
+	for i, idx := range t.indexes {
+		names[i] = idx.name
+	}
+
+	return names
+}
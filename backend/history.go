@@ -0,0 +1,259 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+// DefaultHistoryRetention is how far back AS OF TIMESTAMP can read tables
+// until SetHistoryRetention changes it.
+const DefaultHistoryRetention = time.Hour
+
+var ErrNoHistory = errors.New("Table has no version at that time")
+
+// tableHistory holds the committed versions of a table in the order they
+// were committed. Rows are only ever appended to a store, so a version is
+// the rows the store held then and reading it only needs their number.
+type tableHistory struct {
+	versions []tableVersion
+}
+
+type tableVersion struct {
+	at    time.Time
+	store storage.Table
+	rows  int
+}
+
+// SetHistoryRetention sets how long the versions of tables are kept for AS
+// OF TIMESTAMP to read. Versions older than that are forgotten as
+// transactions commit, and reading a table as of a time before the window
+// fails. Zero only keeps the latest version.
+func (mb *MemoryBackend) SetHistoryRetention(d time.Duration) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.historyRetention = d
+}
+
+// RecordVersions records the tables changed since the last call as of at,
+// when a transaction changing them committed, and forgets the versions that
+// fell out of the retention window.
+func (mb *MemoryBackend) RecordVersions(at time.Time) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	cutoff := at.Add(-mb.historyRetention)
+	for _, t := range mb.tables {
+		if t.external != nil {
+			continue
+		}
+
+		if t.history == nil {
+			t.history = &tableHistory{}
+		}
+		h := t.history
+
+		rows := t.store.Len()
+		if n := len(h.versions); n == 0 || h.versions[n-1].store != t.store || h.versions[n-1].rows != rows {
+			h.versions = append(h.versions, tableVersion{at: at, store: t.store, rows: rows})
+		}
+
+		// The last version before the window is what the table held when
+		// the window starts
+		drop := 0
+		for drop+1 < len(h.versions) && !h.versions[drop+1].at.After(cutoff) {
+			drop++
+		}
+		if drop > 0 {
+			h.versions = append([]tableVersion{}, h.versions[drop:]...)
+		}
+	}
+}
+
+// historicRows returns t with the rows it held as of the time of slct,
+// which reads it AS OF TIMESTAMP. It must be called with mb.mu held.
+func (ev *evaluation) historicRows(slct *parser.SelectStatement, t *memoryTable) (*memoryTable, error) {
+	version, err := ev.versionAt(slct.From.Value, t, slct.AsOf)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]storage.Row, 0, version.rows)
+	scan := t.store.Scan()
+	for len(rows) < version.rows {
+		_, row, ok := scan.Next()
+		if !ok {
+			break
+		}
+
+		if err := ev.mem.Grow(rowRefSize); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	read := *t
+	read.store = storage.NewTable(rows...)
+	read.indexes = nil
+	return &read, nil
+}
+
+// versionAt returns the version of the table name, which is t, as of the
+// time exp evaluates to. It must be called with mb.mu held.
+func (ev *evaluation) versionAt(name string, t *memoryTable, exp *parser.Expression) (*tableVersion, error) {
+	v, err := ev.eval(exp)
+	if err != nil {
+		return nil, err
+	}
+
+	var at time.Time
+	switch v := v.(type) {
+	case time.Time:
+		at = v
+	case string:
+		if at, err = types.ParseTimestamp(v); err != nil {
+			return nil, fmt.Errorf("%w: %s is not a timestamp", ErrInvalidDatatype, v)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v is not a timestamp", ErrInvalidDatatype, v)
+	}
+
+	if at.Before(time.Now().UTC().Add(-ev.mb.historyRetention)) {
+		return nil, fmt.Errorf("%w: %s is before the history retention window", ErrNoHistory, types.FormatTimestamp(at))
+	}
+
+	var version *tableVersion
+	if t.history != nil {
+		for i := range t.history.versions {
+			if t.history.versions[i].at.After(at) {
+				break
+			}
+			version = &t.history.versions[i]
+		}
+	}
+	if version == nil || version.store != t.store {
+		return nil, fmt.Errorf("%w: %s as of %s", ErrNoHistory, name, types.FormatTimestamp(at))
+	}
+
+	return version, nil
+}
+
+// FlashbackRows returns how many rows the table of flashback keeps, the
+// number it had as of the time flashback takes it back to unless flashback
+// gives the number itself.
+func (mb *MemoryBackend) FlashbackRows(ctx context.Context, flashback *parser.FlashbackStatement, session *functions.Session, params []interface{}) (int64, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	_, rows, err := mb.flashbackRows(ctx, flashback, session, params)
+	return rows, err
+}
+
+// flashbackRows is FlashbackRows also returning the table. Only the
+// superuser may flash back tables: rows are removed whoever inserted them,
+// so anyone else could remove rows policies hide from them. It must be
+// called with mb.mu held.
+func (mb *MemoryBackend) flashbackRows(ctx context.Context, flashback *parser.FlashbackStatement, session *functions.Session, params []interface{}) (*memoryTable, int64, error) {
+	if err := checkSuperuser(session, "flash back tables"); err != nil {
+		return nil, 0, err
+	}
+
+	name := flashback.Table.Value
+	if isSystemTable(name) {
+		return nil, 0, ErrSystemTable
+	}
+
+	t, ok := mb.tables[name]
+	if !ok {
+		return nil, 0, ErrTableDoesNotExist
+	}
+
+	switch {
+	case t.view != nil:
+		return nil, 0, fmt.Errorf("%w: %s", ErrMaterializedView, name)
+	case t.external != nil:
+		return nil, 0, fmt.Errorf("%w: %s", ErrExternalTable, name)
+	}
+
+	if flashback.Rows != nil {
+		if *flashback.Rows > int64(t.store.Len()) {
+			return nil, 0, fmt.Errorf("Table %s has fewer than %d rows", name, *flashback.Rows)
+		}
+		return t, *flashback.Rows, nil
+	}
+
+	ev := &evaluation{ctx: ctx, mb: mb, session: session, params: params}
+	version, err := ev.versionAt(name, t, flashback.Timestamp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return t, int64(version.rows), nil
+}
+
+// Flashback takes a table back to the rows it had as of an earlier time,
+// undoing the inserts committed since. Rows are never removed from a
+// store, so like AlterTable it replaces the store with a new one holding
+// the rows kept. Versions of the table from before then can't be read
+// anymore.
+func (mb *MemoryBackend) Flashback(ctx context.Context, flashback *parser.FlashbackStatement, session *functions.Session, params []interface{}) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	t, keep, err := mb.flashbackRows(ctx, flashback, session, params)
+	if err != nil || keep == int64(t.store.Len()) {
+		return err
+	}
+
+	name := flashback.Table.Value
+	if view, ok := mb.readBy(name); ok {
+		return fmt.Errorf("%w: %s reads %s", ErrTableInUse, view, name)
+	}
+
+	if ref, ok := mb.referencedBy(name); ok {
+		return fmt.Errorf("%w: %s", ErrTableInUse, ref)
+	}
+
+	rows := make([]storage.Row, 0, keep)
+	scan := t.store.Scan()
+	for int64(len(rows)) < keep {
+		_, row, ok := scan.Next()
+		if !ok {
+			return scan.Err()
+		}
+		rows = append(rows, row)
+	}
+
+	if err := mb.engine.DropTable(name); err != nil {
+		return err
+	}
+
+	store, err := mb.engine.CreateTable(name)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Insert(rows...); err != nil {
+		return err
+	}
+
+	flashed := *t
+	flashed.store = store
+	if flashed.indexes, err = reindex(t, &flashed); err != nil {
+		return err
+	}
+
+	mb.tables[name] = &flashed
+	mb.changed(name)
+	return nil
+}
@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAsOfTimestamp(t *testing.T) {
+	mb, session := testBackend(t)
+	now := time.Now().UTC()
+	at := func(ago time.Duration) time.Time { return now.Add(-ago) }
+
+	// t holds 3 rows 30 minutes ago, 4 after 20 minutes ago and 5 after 10
+	// minutes ago, when u was created
+	steps := []struct {
+		ago     time.Duration
+		queries []string
+	}{
+		{30 * time.Minute, nil},
+		{20 * time.Minute, []string{"insert into t values (4, 'd', 0.5)"}},
+		{10 * time.Minute, []string{"insert into t values (5, 'e', 1.5)", "create table u (id int)"}},
+	}
+	for _, step := range steps {
+		for _, query := range step.queries {
+			if _, err := run(mb, session, query); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+		}
+		mb.RecordVersions(at(step.ago))
+	}
+	// Uncommitted rows aren't part of any version
+	if _, err := run(mb, session, "insert into t values (6, 'f', 2.5)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		at    interface{}
+		rows  [][]interface{}
+		err   error
+	}{
+		{"first version", "select count(*) from t as of timestamp $1", at(30 * time.Minute), [][]interface{}{{int64(3)}}, nil},
+		{"between versions", "select count(*) from t as of timestamp $1", at(25 * time.Minute), [][]interface{}{{int64(3)}}, nil},
+		{"second version", "select max(id) from t as of timestamp $1", at(15 * time.Minute), [][]interface{}{{int64(4)}}, nil},
+		{"latest version", "select count(*) from t as of timestamp $1", at(0), [][]interface{}{{int64(5)}}, nil},
+		{"filtered", "select name from t as of timestamp $1 where id > 3", at(15 * time.Minute), [][]interface{}{{"d"}}, nil},
+		{"text", "select count(*) from t as of timestamp $1", at(15 * time.Minute).Format("2006-01-02 15:04:05.999999"), [][]interface{}{{int64(4)}}, nil},
+		{"before the first version", "select count(*) from t as of timestamp $1", at(40 * time.Minute), nil, ErrNoHistory},
+		{"before the table existed", "select count(*) from u as of timestamp $1", at(15 * time.Minute), nil, ErrNoHistory},
+		{"before the retention window", "select count(*) from t as of timestamp $1", at(2 * time.Hour), nil, ErrNoHistory},
+		{"not a timestamp", "select count(*) from t as of timestamp $1", "yesterday", nil, ErrInvalidDatatype},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := run(mb, session, tt.query, tt.at)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	tests := []struct {
+		retention time.Duration
+		// readable is whether t can be read as of 20 minutes ago
+		readable bool
+	}{
+		{time.Hour, true},
+		{30 * time.Minute, true},
+		{15 * time.Minute, false},
+		{0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.retention.String(), func(t *testing.T) {
+			mb, session := testBackend(t)
+			mb.SetHistoryRetention(tt.retention)
+
+			now := time.Now().UTC()
+			mb.RecordVersions(now.Add(-20 * time.Minute))
+			if _, err := run(mb, session, "insert into t values (4, 'd', 0.5)"); err != nil {
+				t.Fatal(err)
+			}
+			mb.RecordVersions(now)
+
+			_, err := run(mb, session, "select count(*) from t as of timestamp $1", now.Add(-20*time.Minute))
+			if (err == nil) != tt.readable {
+				t.Errorf("got %v, want readable %v", err, tt.readable)
+			}
+			if err != nil && !errors.Is(err, ErrNoHistory) {
+				t.Errorf("got %v, want %v", err, ErrNoHistory)
+			}
+
+			// The latest version is always kept
+			if _, err := run(mb, session, "select count(*) from t as of timestamp $1", now.Add(time.Minute)); err != nil {
+				t.Errorf("reading the latest version: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+var ErrGeneratedAlways = errors.New("Cannot insert a value into a column generated always")
+
+// identity generates the values of an identity column. It is replaced
+// rather than changed as values are generated, so snapshots can share it
+// and rolling back a transaction takes the column back to the values it
+// generated before. Only committed values are ever logged, so replaying the
+// log generates the same values again.
+type identity struct {
+	always    bool
+	increment int64
+	// next is the value generated next, exhausted is set once the values
+	// ran past the range of an int
+	next      int64
+	exhausted bool
+}
+
+// newIdentity returns the identity col defines for a column of type dt, nil
+// when it isn't an identity column.
+func newIdentity(col *parser.ColumnDefinition, dt ColumnType) (*identity, error) {
+	def := col.Identity
+	if def == nil {
+		return nil, nil
+	}
+
+	if dt != IntType {
+		return nil, fmt.Errorf("%w: identity column %s must be int, not %s", ErrInvalidDatatype, col.Name.Value, dt)
+	}
+
+	id := &identity{always: def.Always, increment: def.Increment, next: 1}
+	if def.Increment < 0 {
+		id.next = -1
+	}
+	if def.Start != nil {
+		id.next = *def.Start
+	}
+
+	return id, nil
+}
+
+// kind is how the values of the column are generated, as Column.Identity
+// describes it.
+func (id *identity) kind() string {
+	if id.always {
+		return "always"
+	}
+
+	return "by default"
+}
+
+// String formats id the way CREATE TABLE takes it, starting from the value
+// it generates next.
+func (id *identity) String() string {
+	kind := "BY DEFAULT"
+	if id.always {
+		kind = "ALWAYS"
+	}
+
+	return "GENERATED " + kind + " AS IDENTITY (START WITH " + strconv.FormatInt(id.next, 10) +
+		" INCREMENT BY " + strconv.FormatInt(id.increment, 10) + ")"
+}
+
+// generate returns the next value of id and the identity generating the
+// one after it.
+func (id *identity) generate(column string) (int64, *identity, error) {
+	if id.exhausted {
+		return 0, nil, fmt.Errorf("%w: identity column %s has no more values", types.ErrOutOfRange, column)
+	}
+
+	v := id.next
+	after := *id
+	after.next = v + id.increment
+	after.exhausted = (after.next > v) != (id.increment > 0)
+	return v, &after, nil
+}
+
+// past returns the identity generating the values after v, which was given
+// for the column, or id itself if it is already past it. Values are never
+// generated twice even when rows are copied in with their values, like a
+// dump restores them.
+func (id *identity) past(v int64) *identity {
+	if id.exhausted || (id.increment > 0 && v < id.next) || (id.increment < 0 && v > id.next) {
+		return id
+	}
+
+	after := *id
+	after.next = v + id.increment
+	after.exhausted = (after.next > v) != (id.increment > 0)
+	return &after
+}
+
+// identityOf returns the identity of column i of t, nil if it isn't an
+// identity column.
+func (t *memoryTable) identityOf(i int) *identity {
+	if i >= len(t.identities) {
+		return nil
+	}
+
+	return t.identities[i]
+}
+
+// insertValue returns the value inserted into column, an identity column
+// generating values with id, for value given by inst, and the identity of
+// the column after it. v is what value evaluated to unless it is DEFAULT.
+func insertValue(id *identity, column string, inst *parser.InsertStatement, value *parser.Expression, v interface{}) (interface{}, *identity, error) {
+	overriding := ""
+	if inst.Overriding != nil {
+		overriding = inst.Overriding.Value
+	}
+
+	return identityValue(id, column, overriding, value.Type == parser.DefaultType, v)
+}
+
+// identityValue returns the value inserted into column, an identity column
+// generating values with id, and the identity of the column after it. v is
+// the value given for the column unless isDefault is set, overriding is
+// "system" or "user" for inserts with OVERRIDING SYSTEM or USER VALUE.
+func identityValue(id *identity, column, overriding string, isDefault bool, v interface{}) (interface{}, *identity, error) {
+	if isDefault || overriding == "user" {
+		n, after, err := id.generate(column)
+		return n, after, err
+	}
+
+	if id.always && overriding != "system" {
+		return nil, nil, fmt.Errorf("%w: %s, use DEFAULT or OVERRIDING SYSTEM VALUE", ErrGeneratedAlways, column)
+	}
+
+	n, ok := v.(int64)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: identity column %s can't be NULL", ErrInvalidDatatype, column)
+	}
+
+	return n, id.past(n), nil
+}
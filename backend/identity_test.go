@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func TestIdentityColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  string
+		inserts []string
+		// err is the error of the last insert, nil when all of them succeed
+		err  error
+		rows [][]interface{}
+	}{
+		{"by default", "create table i (id int generated by default as identity, v text)",
+			[]string{"insert into i values (default, 'a')", "insert into i values (default, 'b')"},
+			nil, [][]interface{}{{int64(1), "a"}, {int64(2), "b"}}},
+		{"given values are skipped", "create table i (id int generated by default as identity, v text)",
+			[]string{"insert into i values (5, 'a')", "insert into i values (default, 'b')", "insert into i values (3, 'c')", "insert into i values (default, 'd')"},
+			nil, [][]interface{}{{int64(5), "a"}, {int64(6), "b"}, {int64(3), "c"}, {int64(7), "d"}}},
+		{"start and increment", "create table i (id int generated always as identity (start with 10 increment by -3), v text)",
+			[]string{"insert into i values (default, 'a')", "insert into i values (default, 'b')"},
+			nil, [][]interface{}{{int64(10), "a"}, {int64(7), "b"}}},
+		{"negative increment", "create table i (id int generated always as identity (increment by -1), v text)",
+			[]string{"insert into i values (default, 'a')", "insert into i values (default, 'b')"},
+			nil, [][]interface{}{{int64(-1), "a"}, {int64(-2), "b"}}},
+		{"always", "create table i (id int generated always as identity, v text)",
+			[]string{"insert into i values (default, 'a')", "insert into i values (5, 'b')"},
+			ErrGeneratedAlways, [][]interface{}{{int64(1), "a"}}},
+		{"overriding system value", "create table i (id int generated always as identity, v text)",
+			[]string{"insert into i overriding system value values (5, 'a')", "insert into i values (default, 'b')"},
+			nil, [][]interface{}{{int64(5), "a"}, {int64(6), "b"}}},
+		{"overriding user value", "create table i (id int generated by default as identity, v text)",
+			[]string{"insert into i overriding user value values (5, 'a')"},
+			nil, [][]interface{}{{int64(1), "a"}}},
+		{"null", "create table i (id int generated by default as identity, v text)",
+			[]string{"insert into i values (null, 'a')"},
+			ErrInvalidDatatype, nil},
+		{"exhausted", "create table i (id int generated by default as identity (start with 9223372036854775807), v text)",
+			[]string{"insert into i values (default, 'a')", "insert into i values (default, 'b')"},
+			types.ErrOutOfRange, [][]interface{}{{int64(9223372036854775807), "a"}}},
+		{"added to a table", "create table i (v text); insert into i values ('a'); insert into i values ('b'); alter table i add column id int generated always as identity",
+			[]string{"insert into i values ('c', default)"},
+			nil, [][]interface{}{{"a", int64(1)}, {"b", int64(2)}, {"c", int64(3)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+			if _, err := run(mb, session, tt.create); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			for _, query := range tt.inserts {
+				if _, err = run(mb, session, query); err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+
+			results, err := run(mb, session, "select * from i")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
+
+func TestIdentityRollback(t *testing.T) {
+	mb, session := testBackend(t)
+	if _, err := run(mb, session, "create table i (id int generated always as identity, v text)"); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := mb.Snapshot()
+	if _, err := run(mb, session, "insert into i values (default, 'a'); insert into i values (default, 'b')"); err != nil {
+		t.Fatal(err)
+	}
+	mb.Restore(snapshot)
+
+	// The values rolled back are generated again
+	results, err := run(mb, session, "insert into i values (default, 'c'); select id from i")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{int64(1)}}; !reflect.DeepEqual(results.Rows, want) {
+		t.Errorf("got %v after rolling back, want %v", results.Rows, want)
+	}
+}
@@ -0,0 +1,1144 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrIndexExists       = errors.New("Index already exists")
+	ErrIndexDoesNotExist = errors.New("Index does not exist")
+)
+
+// indexEntrySize is what an entry of an index takes besides its key.
+const indexEntrySize = 24
+
+// index is an index over a column of a table: a B-tree of the values of
+// the column, each with the id of the row holding it. NULLs are left out,
+// comparing them never matches. It is kept up to date as a hook of the
+// store of the table.
+//
+// An expression index holds the values of an expression over the columns
+// of the table instead, computed as rows are inserted. The expression is
+// stable, so it computes the same keys again when rows are looked up or
+// removed.
+//
+// A partial index only holds the rows its predicate is true for. Queries
+// only use it when their WHERE implies the predicate, so every row they
+// read is among them.
+type index struct {
+	name       string
+	column     string
+	columnType ColumnType
+	// position is where the column is in the rows of store
+	position int
+	// expression is what an expression index holds, evaluated over rows of
+	// table, nil for indexes over a column
+	expression *parser.Expression
+	// where is the predicate of a partial index, nil for indexes over
+	// every row
+	where *parser.Expression
+	table *memoryTable
+	store storage.Table
+	tree  btree
+	// ids are the rows indexed so far in the order they were, so those
+	// inserted by a transaction that rolls back can be removed again
+	ids  []storage.RowID
+	size int64
+	// live is cleared once the index is dropped, which stops the store from
+	// keeping it up to date
+	live bool
+}
+
+func (idx *index) Inserted(id storage.RowID, row storage.Row) {
+	if idx.live {
+		idx.add(id, row)
+	}
+}
+
+// add indexes row. Keys of expression indexes that fail to compute are
+// left out, rows are only inserted once checkKeys passed them.
+func (idx *index) add(id storage.RowID, row storage.Row) {
+	idx.ids = append(idx.ids, id)
+	if key, err := idx.key(row); err == nil && key != nil {
+		idx.tree.insert(indexEntry{key: key, id: id})
+		idx.size += indexEntrySize + valueSize(key)
+	}
+}
+
+// key returns the key of row in idx, nil for NULL and for rows a partial
+// index leaves out.
+func (idx *index) key(row storage.Row) (interface{}, error) {
+	ev := &evaluation{table: idx.table, row: row}
+	if idx.where != nil {
+		v, err := ev.eval(idx.where)
+		if err != nil {
+			return nil, fmt.Errorf("Index %s: %w", idx.name, err)
+		}
+		if v != true {
+			return nil, nil
+		}
+	}
+
+	if idx.expression == nil {
+		return row[idx.position], nil
+	}
+
+	v, err := ev.eval(idx.expression)
+	if err != nil {
+		return nil, fmt.Errorf("Index %s: %w", idx.name, err)
+	}
+
+	key, ok := indexKey(v, idx.columnType)
+	if !ok {
+		return nil, fmt.Errorf("%w: index %s can't hold %v", ErrInvalidDatatype, idx.name, v)
+	}
+
+	return key, nil
+}
+
+// target is what idx indexes, its column or its expression.
+func (idx *index) target() string {
+	if idx.expression != nil {
+		return idx.expression.String()
+	}
+
+	return idx.column
+}
+
+// checkKeys checks that the expression and partial indexes of t can
+// compute the keys of rows, which are about to be inserted into it.
+func checkKeys(t *memoryTable, rows ...storage.Row) error {
+	for _, idx := range t.indexes {
+		if (idx.expression == nil && idx.where == nil) || !idx.live {
+			continue
+		}
+
+		for _, row := range rows {
+			if _, err := idx.key(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// truncate removes the rows indexed after the first n again. The entry of a
+// row that can't be read is left behind, which is harmless since its id is
+// past the rows of the store.
+func (idx *index) truncate(n int) {
+	for _, id := range idx.ids[n:] {
+		row, ok, err := idx.store.Lookup(id)
+		if err != nil || !ok {
+			continue
+		}
+
+		key, err := idx.key(row)
+		if err != nil || key == nil {
+			continue
+		}
+
+		if idx.tree.remove(indexEntry{key: key, id: id}) {
+			idx.size -= indexEntrySize + valueSize(key)
+		}
+	}
+	idx.ids = idx.ids[:n]
+}
+
+// rebuild indexes the rows of the store again from scratch.
+func (idx *index) rebuild() error {
+	idx.tree, idx.ids, idx.size = btree{}, nil, 0
+
+	scan := idx.store.Scan()
+	for id, row, ok := scan.Next(); ok; id, row, ok = scan.Next() {
+		idx.add(id, row)
+	}
+
+	return scan.Err()
+}
+
+// lookup returns the ids of the rows whose column equals key in order.
+func (idx *index) lookup(key interface{}) []storage.RowID {
+	ids := []storage.RowID{}
+	if key == nil {
+		return ids
+	}
+
+	idx.tree.ascend(indexEntry{key: key, id: -1}, func(e indexEntry) bool {
+		if compareKeys(e.key, key) != 0 {
+			return false
+		}
+
+		ids = append(ids, e.id)
+		return true
+	})
+
+	return ids
+}
+
+// indexKey converts v to a key of an index over a column of type dt. A nil
+// key matches no rows, which is the case for NULL and for numbers a column
+// of integers can't hold. It returns false for values the index can't look
+// up, which are left for the filter to compare.
+func indexKey(v interface{}, dt ColumnType) (interface{}, bool) {
+	if v == nil {
+		return nil, true
+	}
+
+	switch dt {
+	case IntType:
+		switch v := v.(type) {
+		case int64:
+			return v, true
+		case float64:
+			if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+				return nil, true
+			}
+			return int64(v), true
+		}
+	case FloatType:
+		switch v := v.(type) {
+		case int64:
+			return float64(v), true
+		case float64:
+			return v, true
+		}
+	case TextType:
+		if s, ok := v.(string); ok {
+			return s, true
+		}
+	case BoolType:
+		if b, ok := v.(bool); ok {
+			return b, true
+		}
+	case TimestampType, IntervalType, UUIDType:
+		// Text compared with a timestamp, an interval or a uuid is cast to
+		// it
+		if vt, ok := types.Of(v); ok && (vt == dt || vt == TextType) {
+			key, err := types.Cast(v, dt)
+			return key, err == nil
+		}
+	}
+
+	return nil, false
+}
+
+// IndexBuild is an index being built by CreateIndex. StartIndex takes the
+// values of the column the table holds, BuildIndex builds the index from
+// them and FinishIndex adds it to the table. Only the first and the last
+// step keep the backend locked, and not for long, so the table can be
+// written to while the index is built; FinishIndex indexes the rows
+// inserted meanwhile.
+type IndexBuild struct {
+	table string
+	t     *memoryTable
+	idx   *index
+	// entries are the values of the rows the table held when the build
+	// started, ids are the rows themselves
+	entries []indexEntry
+	ids     []storage.RowID
+}
+
+// CreateIndex creates an index over a column of a table, which queries
+// comparing the column with a value for equality look the rows up in
+// rather than scanning the whole table. The index is built in the steps of
+// IndexBuild, as a job listed in __jobs.
+func (mb *MemoryBackend) CreateIndex(ctx context.Context, crt *parser.CreateIndexStatement, session *functions.Session) error {
+	b, err := mb.StartIndex(crt, session)
+	if err != nil {
+		return err
+	}
+
+	if err := mb.BuildIndex(ctx, b); err != nil {
+		return err
+	}
+
+	return mb.FinishIndex(b)
+}
+
+// StartIndex checks crt and takes the values the index is built from. Only
+// the superuser may index a table with policies or masks, see
+// checkProtected.
+func (mb *MemoryBackend) StartIndex(crt *parser.CreateIndexStatement, session *functions.Session) (*IndexBuild, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	t, idx, err := mb.newIndex(crt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkProtected(session, t, "index"); err != nil {
+		return nil, err
+	}
+
+	b := &IndexBuild{
+		table:   crt.Table.Value,
+		t:       t,
+		idx:     idx,
+		entries: make([]indexEntry, 0, t.store.Len()),
+		ids:     make([]storage.RowID, 0, t.store.Len()),
+	}
+	scan := t.store.Scan()
+	for id, row, ok := scan.Next(); ok; id, row, ok = scan.Next() {
+		b.ids = append(b.ids, id)
+		key, err := idx.key(row)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			b.entries = append(b.entries, indexEntry{key: key, id: id})
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// newIndex checks crt and returns the table it indexes and the index
+// without any rows. It must be called with mb.mu held.
+func (mb *MemoryBackend) newIndex(crt *parser.CreateIndexStatement) (*memoryTable, *index, error) {
+	name := crt.Table.Value
+	if isSystemTable(name) {
+		return nil, nil, ErrSystemTable
+	}
+
+	t, ok := mb.tables[name]
+	if !ok {
+		return nil, nil, ErrTableDoesNotExist
+	}
+
+	if t.view != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrMaterializedView, name)
+	}
+
+	if t.external != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrExternalTable, name)
+	}
+
+	var idx *index
+	if crt.Expression != nil {
+		var err error
+		if idx, err = newExpressionIndex(t, crt.Expression); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		i, ok := t.columnIndex(crt.Column.Value)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, crt.Column.Value)
+		}
+
+		if t.columnTypes[i].IsArray() {
+			return nil, nil, fmt.Errorf("%w: column %s is an array, arrays can't be indexed", ErrInvalidDatatype, crt.Column.Value)
+		}
+
+		idx = &index{column: crt.Column.Value, columnType: t.columnTypes[i], position: i, table: t, store: t.store}
+	}
+
+	if crt.Where != nil {
+		if !stable(crt.Where) || !rowOnly(crt.Where) {
+			return nil, nil, fmt.Errorf("Index predicate %s must only depend on the row, without volatile functions, subqueries or parameters", crt.Where)
+		}
+		idx.where = crt.Where
+	}
+
+	if crt.Name != nil {
+		idx.name = crt.Name.Value
+		if _, _, ok := mb.findIndex(idx.name); ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrIndexExists, idx.name)
+		}
+	} else if crt.Expression != nil {
+		// Expression indexes are named after the function they call
+		idx.name = mb.indexName(name, "expr")
+		if crt.Expression.Type == parser.CallType {
+			idx.name = mb.indexName(name, crt.Expression.Call.Name.Value)
+		}
+	} else {
+		idx.name = mb.indexName(name, crt.Column.Value)
+	}
+
+	return t, idx, nil
+}
+
+// newExpressionIndex returns an index over exp computed from the rows of t
+// without any rows. The expression must compute the same value from the
+// same row every time, so it can't call volatile functions, read other
+// tables or take parameters.
+func newExpressionIndex(t *memoryTable, exp *parser.Expression) (*index, error) {
+	if !stable(exp) || !rowOnly(exp) {
+		return nil, fmt.Errorf("Index expression %s must only depend on the row, without volatile functions, subqueries or parameters", exp)
+	}
+
+	dt := (&evaluation{table: t}).columnType(exp)
+	if dt.IsArray() {
+		return nil, fmt.Errorf("%w: index expression %s is an array, arrays can't be indexed", ErrInvalidDatatype, exp)
+	}
+
+	return &index{columnType: dt, expression: exp, table: t, store: t.store}, nil
+}
+
+// rowOnly reports whether exp only reads the row it is evaluated for, with
+// neither subqueries nor parameters.
+func rowOnly(exp *parser.Expression) bool {
+	switch exp.Type {
+	case parser.ParamType, parser.SubqueryType, parser.ExistsType, parser.DefaultType:
+		return false
+	case parser.CallType:
+		return allRowOnly(exp.Call.Args)
+	case parser.BinaryType:
+		return rowOnly(&exp.Binary.A) && rowOnly(&exp.Binary.B)
+	case parser.CastType:
+		return rowOnly(&exp.Cast.Exp)
+	case parser.IndexType:
+		return rowOnly(&exp.Index.Exp) && rowOnly(&exp.Index.Index)
+	case parser.ArrayType:
+		return allRowOnly(exp.Array)
+	case parser.RowType:
+		return allRowOnly(exp.Row)
+	case parser.InType:
+		return rowOnly(&exp.In.Exp) && allRowOnly(exp.In.List)
+	case parser.NotType:
+		return rowOnly(exp.Not)
+	}
+
+	return true
+}
+
+func allRowOnly(exps []parser.Expression) bool {
+	for i := range exps {
+		if !rowOnly(&exps[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BuildIndex builds the index of b. It must be called without mb.mu held,
+// and canceling ctx stops it.
+func (mb *MemoryBackend) BuildIndex(ctx context.Context, b *IndexBuild) error {
+	j := mb.startJob(ctx, "CREATE INDEX", b.table, len(b.entries))
+	defer mb.endJob(j)
+
+	for i, e := range b.entries {
+		if i%jobChunk == 0 {
+			atomic.StoreInt64(&j.done, int64(i))
+			if ctx != nil {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+		}
+
+		b.idx.tree.insert(e)
+		b.idx.size += indexEntrySize + valueSize(e.key)
+	}
+	atomic.StoreInt64(&j.done, int64(len(b.entries)))
+
+	b.idx.ids, b.entries = b.ids, nil
+	return nil
+}
+
+// FinishIndex indexes the rows inserted since b started and adds the index
+// to its table. It fails if the table was replaced meanwhile, like by
+// ALTER TABLE, or an index of the same name was created.
+func (mb *MemoryBackend) FinishIndex(b *IndexBuild) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	t, ok := mb.tables[b.table]
+	if !ok || t.store != b.t.store {
+		return fmt.Errorf("Table %s changed while index %s was being built", b.table, b.idx.name)
+	}
+
+	if _, _, ok := mb.findIndex(b.idx.name); ok {
+		return fmt.Errorf("%w: %s", ErrIndexExists, b.idx.name)
+	}
+
+	idx := b.idx
+	scan := idx.store.Scan()
+	n := 0
+	for id, row, ok := scan.Next(); ok; id, row, ok = scan.Next() {
+		if n++; n > len(idx.ids) {
+			if _, err := idx.key(row); err != nil {
+				return err
+			}
+			idx.add(id, row)
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return err
+	}
+
+	idx.live = true
+	idx.store.AddIndexHook(idx)
+
+	indexed := *t
+	indexed.indexes = append(append([]*index{}, t.indexes...), idx)
+	mb.tables[b.table] = &indexed
+	return nil
+}
+
+// DropIndex removes an index. Only the superuser may drop the indexes of a
+// table with policies or masks, see checkProtected.
+func (mb *MemoryBackend) DropIndex(drop *parser.DropIndexStatement, session *functions.Session) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name, i, ok := mb.findIndex(drop.Name.Value)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrIndexDoesNotExist, drop.Name.Value)
+	}
+
+	t := mb.tables[name]
+	if err := checkProtected(session, t, "drop the indexes of"); err != nil {
+		return err
+	}
+	t.indexes[i].live = false
+
+	dropped := *t
+	dropped.indexes = append(append([]*index{}, t.indexes[:i]...), t.indexes[i+1:]...)
+	mb.tables[name] = &dropped
+	return nil
+}
+
+// findIndex returns the table of the index called name and where it is
+// among the indexes of the table. Indexes are named apart from tables, but
+// no two indexes can have the same name. It must be called with mb.mu held.
+func (mb *MemoryBackend) findIndex(name string) (string, int, bool) {
+	for table, t := range mb.tables {
+		for i, idx := range t.indexes {
+			if idx.name == name {
+				return table, i, true
+			}
+		}
+	}
+
+	return "", 0, false
+}
+
+// indexName names an index over column of table that wasn't given a name,
+// table_column_idx followed by a number if that is taken. It must be
+// called with mb.mu held.
+func (mb *MemoryBackend) indexName(table, column string) string {
+	name := table + "_" + column + "_idx"
+	for n := 1; ; n++ {
+		if _, _, ok := mb.findIndex(name); !ok {
+			return name
+		}
+		name = fmt.Sprintf("%s_%s_idx%d", table, column, n)
+	}
+}
+
+// reindex returns indexes like those of t over the rows of altered, which
+// t was altered into keeping every indexed column and the types of those
+// expression indexes read. It must be called with
+// mb.mu held.
+func reindex(t, altered *memoryTable) ([]*index, error) {
+	indexes := make([]*index, len(t.indexes))
+	for i, old := range t.indexes {
+		idx := &index{
+			name:       old.name,
+			column:     old.column,
+			columnType: old.columnType,
+			expression: old.expression,
+			where:      old.where,
+			table:      altered,
+			store:      altered.store,
+			live:       true,
+		}
+		if old.expression == nil {
+			idx.position, _ = altered.columnIndex(old.column)
+			idx.columnType = altered.columnTypes[idx.position]
+		}
+		if err := idx.rebuild(); err != nil {
+			return nil, err
+		}
+		idx.store.AddIndexHook(idx)
+		indexes[i] = idx
+	}
+
+	return indexes, nil
+}
+
+// indexScan is how an index narrows down the rows a query reads: to those
+// whose column equals eq, or otherwise to those whose column lies between
+// lower and upper, either of which may be missing.
+type indexScan struct {
+	idx *index
+	eq  *parser.Expression
+	// lower and upper are the bounds of a range scan, nil when the range
+	// is open on that side, and the inclusive flags are set for >= and <=
+	lower, upper                   *parser.Expression
+	lowerInclusive, upperInclusive bool
+}
+
+// String describes s for EXPLAIN.
+func (s *indexScan) String() string {
+	if s.eq != nil {
+		return s.idx.target() + " = " + s.eq.String()
+	}
+
+	bounds := []string{}
+	if s.lower != nil {
+		op := " > "
+		if s.lowerInclusive {
+			op = " >= "
+		}
+		bounds = append(bounds, s.idx.target()+op+s.lower.String())
+	}
+	if s.upper != nil {
+		op := " < "
+		if s.upperInclusive {
+			op = " <= "
+		}
+		bounds = append(bounds, s.idx.target()+op+s.upper.String())
+	}
+
+	return strings.Join(bounds, " and ")
+}
+
+// indexOn returns a live index of t over exp, a column or an expression
+// indexed as written, if there is one whose keys compare like exp does.
+// Partial indexes are only returned if where, the conjuncts of the WHERE of
+// the query, implies their predicate, and are preferred for holding fewer
+// rows.
+func (ev *evaluation) indexOn(t *memoryTable, exp *parser.Expression, where []*parser.Expression) *index {
+	if exp.Type == parser.ColumnRefType {
+		i, ok := t.columnIndex(exp.Column.Value)
+		if !ok || t.collations[i] != nil || t.masks[exp.Column.Value] != nil {
+			return nil
+		}
+	}
+
+	var found *index
+	var text string
+	for _, idx := range t.indexes {
+		if idx.store != t.store || !idx.live || (found != nil && found.where != nil) {
+			continue
+		}
+
+		if exp.Type == parser.ColumnRefType {
+			if idx.expression != nil || idx.column != exp.Column.Value {
+				continue
+			}
+		} else {
+			if idx.expression == nil {
+				continue
+			}
+			if text == "" {
+				text = exp.String()
+			}
+			if idx.expression.String() != text || comparedByMore(t, idx.expression) {
+				continue
+			}
+		}
+
+		if idx.where != nil && (comparedByMore(t, idx.where) || !implies(t, where, idx.where)) {
+			continue
+		}
+		if found == nil || idx.where != nil {
+			found = idx
+		}
+	}
+
+	return found
+}
+
+// comparedByMore reports whether exp reads collated or masked columns of
+// t, which are compared by more than the values exp computes from them.
+func comparedByMore(t *memoryTable, exp *parser.Expression) bool {
+	for i, col := range t.columns {
+		if (t.collations[i] != nil || t.masks[col] != nil) && refersTo(exp, col) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// implies reports whether where, the conjuncts of the WHERE of a query,
+// imply predicate, so every row the query reads satisfies it. It is proven
+// one conjunct of predicate at a time, each by a conjunct of where: either
+// the same one, or a comparison of the same operand with a constant that
+// narrows the rows down further, like a > 10 does a > 5. Anything else is
+// taken as not implied.
+func implies(t *memoryTable, where []*parser.Expression, predicate *parser.Expression) bool {
+	for _, p := range conjuncts(predicate) {
+		proven := false
+		for _, q := range where {
+			if proven = q.String() == p.String() || impliesComparison(t, q, p); proven {
+				break
+			}
+		}
+
+		if !proven {
+			return false
+		}
+	}
+
+	return true
+}
+
+// impliesComparison reports whether the comparison q of an operand over
+// the rows of t with a constant implies the comparison p of the same
+// operand with another one. Text constants are compared as the type of the
+// operand, which the comparisons cast them to.
+func impliesComparison(t *memoryTable, q, p *parser.Expression) bool {
+	qExp, qOp, a, ok := comparison(q)
+	if !ok {
+		return false
+	}
+	pExp, pOp, b, ok := comparison(p)
+	if !ok || qExp.String() != pExp.String() {
+		return false
+	}
+
+	if dt := (&evaluation{table: t}).columnType(qExp); dt != TextType {
+		for _, v := range []*interface{}{&a, &b} {
+			if _, ok := (*v).(string); !ok {
+				continue
+			}
+
+			c, err := types.Cast(*v, dt)
+			if err != nil {
+				return false
+			}
+			*v = c
+		}
+	}
+
+	holds := func(op string) bool {
+		v, err := types.Apply(op, a, b)
+		return err == nil && v == true
+	}
+
+	switch pOp {
+	case "=":
+		return qOp == "=" && holds("=")
+	case "<>":
+		switch qOp {
+		case "=":
+			return holds("<>")
+		case "<>":
+			return holds("=")
+		case ">":
+			return holds(">=")
+		case ">=":
+			return holds(">")
+		case "<":
+			return holds("<=")
+		case "<=":
+			return holds("<")
+		}
+	case ">":
+		return (qOp == ">" && holds(">=")) || ((qOp == ">=" || qOp == "=") && holds(">"))
+	case ">=":
+		return (qOp == ">" || qOp == ">=" || qOp == "=") && holds(">=")
+	case "<":
+		return (qOp == "<" && holds("<=")) || ((qOp == "<=" || qOp == "=") && holds("<"))
+	case "<=":
+		return (qOp == "<" || qOp == "<=" || qOp == "=") && holds("<=")
+	}
+
+	return false
+}
+
+// comparison splits exp, a comparison of an operand with a constant other
+// than NULL, into the operand, the comparison with the operand on the left
+// and the constant.
+func comparison(exp *parser.Expression) (*parser.Expression, string, interface{}, bool) {
+	if exp.Type != parser.BinaryType {
+		return nil, "", nil, false
+	}
+
+	op := exp.Binary.Op.Value
+	if op == "!=" {
+		op = "<>"
+	}
+	if _, ok := flipped[op]; !ok && op != "<>" {
+		return nil, "", nil, false
+	}
+
+	operand, c := &exp.Binary.A, &exp.Binary.B
+	v, ok := constant(c)
+	if !ok {
+		operand, c = c, operand
+		if v, ok = constant(c); !ok {
+			return nil, "", nil, false
+		}
+		if op != "<>" {
+			op = flipped[op]
+		}
+	}
+	if _, ok := constant(operand); ok || v == nil {
+		return nil, "", nil, false
+	}
+
+	return operand, op, v, true
+}
+
+// flipped is the comparison op turns into when its operands swap sides.
+var flipped = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// indexFor returns how an index of t narrows the rows slct reads down to
+// those the comparisons of its WHERE hold for, comparing the indexed column
+// with a value that is the same for every row. It returns nil if there is
+// no such index. Collated and masked columns compare by more than the
+// values the index holds, so their indexes are never used.
+//
+// Equalities are looked up rather than ranges. Once t is analyzed the
+// index over the column with the most distinct values is picked, which is
+// expected to narrow the rows down the most. Ranges are scanned over the
+// column bounded on both sides if there is one, otherwise over the first
+// column bounded at all. Only the indexes the hints of slct allow are
+// picked from.
+func (ev *evaluation) indexFor(slct *parser.SelectStatement, t *memoryTable) *indexScan {
+	t = ev.hintedTable(slct, t)
+	if len(t.indexes) == 0 || slct.Where == nil || slct.ConnectBy != nil {
+		return nil
+	}
+
+	var best *indexScan
+	ranges := []*indexScan{}
+	where := conjuncts(slct.Where)
+	for _, exp := range where {
+		if exp.Type != parser.BinaryType {
+			continue
+		}
+
+		op, ok := flipped[exp.Binary.Op.Value]
+		if !ok {
+			continue
+		}
+
+		// The indexed side is on the left unless the right one is indexed
+		// and the left one isn't
+		key, value := &exp.Binary.A, &exp.Binary.B
+		idx := ev.indexOn(t, key, where)
+		if idx == nil {
+			key, value = value, key
+			if idx = ev.indexOn(t, key, where); idx == nil {
+				continue
+			}
+		} else {
+			op = exp.Binary.Op.Value
+		}
+		if ev.refs(t, value) != 0 || !stable(value) {
+			continue
+		}
+
+		if op == "=" {
+			if t.stats == nil {
+				return &indexScan{idx: idx, eq: value}
+			}
+			if best == nil || t.stats.distinct[idx.column] > t.stats.distinct[best.idx.column] {
+				best = &indexScan{idx: idx, eq: value}
+			}
+			continue
+		}
+
+		var scan *indexScan
+		for _, r := range ranges {
+			if r.idx == idx {
+				scan = r
+			}
+		}
+		if scan == nil {
+			scan = &indexScan{idx: idx}
+			ranges = append(ranges, scan)
+		}
+
+		// Of several bounds on the same side only the first is scanned by,
+		// the filter still checks the others
+		switch {
+		case (op == ">" || op == ">=") && scan.lower == nil:
+			scan.lower, scan.lowerInclusive = value, op == ">="
+		case (op == "<" || op == "<=") && scan.upper == nil:
+			scan.upper, scan.upperInclusive = value, op == "<="
+		}
+	}
+
+	if best != nil {
+		return best
+	}
+
+	for _, r := range ranges {
+		if r.lower != nil && r.upper != nil {
+			return r
+		}
+	}
+	if len(ranges) > 0 {
+		return ranges[0]
+	}
+
+	return nil
+}
+
+// stable reports whether exp evaluates to the same value however many
+// times it is, which it does unless it calls volatile functions or ones
+// changing the database.
+func stable(exp *parser.Expression) bool {
+	switch exp.Type {
+	case parser.CallType:
+		if f, ok := functions.Lookup(exp.Call.Name.Value); ok && (f.Volatile || f.Modifies) {
+			return false
+		}
+		return allStable(exp.Call.Args)
+	case parser.BinaryType:
+		return stable(&exp.Binary.A) && stable(&exp.Binary.B)
+	case parser.CastType:
+		return stable(&exp.Cast.Exp)
+	case parser.IndexType:
+		return stable(&exp.Index.Exp) && stable(&exp.Index.Index)
+	case parser.ArrayType:
+		return allStable(exp.Array)
+	case parser.RowType:
+		return allStable(exp.Row)
+	case parser.InType:
+		return stable(&exp.In.Exp) && allStable(exp.In.List)
+	case parser.NotType:
+		return stable(exp.Not)
+	}
+
+	return true
+}
+
+func allStable(exps []parser.Expression) bool {
+	for i := range exps {
+		if !stable(&exps[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// indexedRows returns t with only the rows the index scan indexFor picks
+// for slct reads, or t itself when there is none. The WHERE still filters
+// the rows, the index only skips those it can't hold for. Values the index
+// can't look up, or that fail to evaluate, leave every row to the filter,
+// which fails the same way if any row gets to it. The rows stay in the
+// order the table holds them, however the index orders them. Without an
+// index the scan is recorded for RECOMMEND INDEXES.
+func (ev *evaluation) indexedRows(slct *parser.SelectStatement, t *memoryTable) (*memoryTable, error) {
+	scan := ev.indexFor(slct, t)
+	if scan == nil {
+		ev.recordScan(slct, t)
+		return t, nil
+	}
+
+	ids, ok := ev.scanIndex(scan)
+	if !ok {
+		return t, nil
+	}
+
+	rows := []storage.Row{}
+	for _, id := range ids {
+		row, ok, err := t.store.Lookup(id)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+
+		if err := ev.mem.Grow(rowRefSize); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	indexed := *t
+	indexed.store = storage.NewTable(rows...)
+	indexed.indexes = nil
+	return &indexed, nil
+}
+
+// scanIndex returns the ids of the rows scan reads in order, or false if
+// its values can't be looked up.
+func (ev *evaluation) scanIndex(scan *indexScan) ([]storage.RowID, bool) {
+	idx := scan.idx
+	if scan.eq != nil {
+		key, ok := ev.indexValue(scan.eq, idx.columnType)
+		if !ok {
+			return nil, false
+		}
+		return idx.lookup(key), true
+	}
+
+	var lower, upper *indexBound
+	for _, b := range []struct {
+		exp       *parser.Expression
+		inclusive bool
+		bound     **indexBound
+	}{{scan.lower, scan.lowerInclusive, &lower}, {scan.upper, scan.upperInclusive, &upper}} {
+		if b.exp == nil {
+			continue
+		}
+
+		key, ok := ev.boundValue(b.exp, idx.columnType)
+		if !ok {
+			return nil, false
+		}
+		// Nothing compares true with NULL or NaN
+		if f, isFloat := key.(float64); key == nil || (isFloat && math.IsNaN(f)) {
+			return []storage.RowID{}, true
+		}
+		*b.bound = &indexBound{key: key, inclusive: b.inclusive}
+	}
+
+	ids := idx.between(lower, upper)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, true
+}
+
+// indexValue evaluates exp to a key of an index over a column of type dt,
+// see indexKey. It returns false when exp fails to evaluate.
+func (ev *evaluation) indexValue(exp *parser.Expression, dt ColumnType) (interface{}, bool) {
+	v, err := ev.eval(exp)
+	if err != nil {
+		return nil, false
+	}
+
+	return indexKey(v, dt)
+}
+
+// boundValue is indexValue for a bound of a range, which is nil only for
+// NULL. Numbers a column of integers can't hold still bound a range, but
+// can't be compared with its keys, so they return false.
+func (ev *evaluation) boundValue(exp *parser.Expression, dt ColumnType) (interface{}, bool) {
+	v, err := ev.eval(exp)
+	if err != nil {
+		return nil, false
+	}
+
+	key, ok := indexKey(v, dt)
+	if !ok || (key == nil && v != nil) {
+		return nil, false
+	}
+
+	return key, true
+}
+
+// indexBound is a bound of a range of keys.
+type indexBound struct {
+	key       interface{}
+	inclusive bool
+}
+
+// between returns the ids of the rows whose column lies between lower and
+// upper, in the order of their values. A nil bound leaves the range open
+// on that side.
+func (idx *index) between(lower, upper *indexBound) []storage.RowID {
+	ids := []storage.RowID{}
+	visit := func(e indexEntry) bool {
+		if upper != nil {
+			if c := compareKeys(e.key, upper.key); c > 0 || (c == 0 && !upper.inclusive) {
+				return false
+			}
+		}
+
+		if lower != nil && !lower.inclusive && compareKeys(e.key, lower.key) == 0 {
+			return true
+		}
+		// NaN is ordered before every number but compares false with them
+		if f, ok := e.key.(float64); ok && math.IsNaN(f) {
+			return true
+		}
+
+		ids = append(ids, e.id)
+		return true
+	}
+
+	if lower == nil {
+		idx.tree.ascendAll(visit)
+	} else {
+		idx.tree.ascend(indexEntry{key: lower.key, id: -1}, visit)
+	}
+
+	return ids
+}
+
+// indexStats returns the rows of __index_stats.
+func (mb *MemoryBackend) indexStats() [][]interface{} {
+	rows := [][]interface{}{}
+	for _, table := range mb.tableNames() {
+		for _, idx := range mb.tables[table].indexes {
+			rows = append(rows, []interface{}{idx.name, table, int64(idx.tree.len), idx.size, lastAnalyze(mb.tables[table])})
+		}
+	}
+
+	return rows
+}
+
+// dumpIndexes returns the statements recreating the indexes of the table t
+// called name.
+func dumpIndexes(name string, t *memoryTable) []DumpStatement {
+	stmts := make([]DumpStatement, len(t.indexes))
+	for i, idx := range t.indexes {
+		target := parser.FormatIdentifier(idx.column)
+		if idx.expression != nil {
+			target = idx.expression.String()
+		}
+		stmts[i] = DumpStatement{
+			Query: "CREATE INDEX " + parser.FormatIdentifier(idx.name) + " ON " + parser.FormatIdentifier(name) +
+				" (" + target + ")",
+		}
+		if idx.where != nil {
+			stmts[i].Query += " WHERE " + idx.where.String()
+		}
+	}
+
+	return stmts
+}
+
+// indexesSince records how many rows each index of tables has indexed, for
+// Restore to remove the rows indexed since.
+func indexesSince(tables map[string]*memoryTable) map[*index]int {
+	indexed := map[*index]int{}
+	for _, t := range tables {
+		for _, idx := range t.indexes {
+			indexed[idx] = len(idx.ids)
+		}
+	}
+
+	return indexed
+}
+
+// restoreIndexes brings the indexes of mb back to the rows they had when
+// indexed was taken with indexesSince. Indexes created since stop being
+// kept up to date, and those dropped since are rebuilt. It must be called
+// with mb.mu held, before the engine drops the rows inserted since.
+func (mb *MemoryBackend) restoreIndexes(indexed map[*index]int) []*index {
+	for _, t := range mb.tables {
+		for _, idx := range t.indexes {
+			if _, ok := indexed[idx]; !ok {
+				idx.live = false
+			}
+		}
+	}
+
+	dropped := []*index{}
+	for idx, n := range indexed {
+		if !idx.live {
+			dropped = append(dropped, idx)
+			continue
+		}
+		idx.truncate(n)
+	}
+
+	return dropped
+}
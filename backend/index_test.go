@@ -0,0 +1,505 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+func TestBTree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var tree btree
+	held := map[indexEntry]bool{}
+
+	// Enough entries for the tree to grow several levels, then removing
+	// most of them again merges its nodes
+	for i := 0; i < 20000; i++ {
+		e := indexEntry{key: int64(r.Intn(500)), id: storage.RowID(r.Intn(100))}
+		if r.Intn(3) == 0 {
+			if removed := tree.remove(e); removed != held[e] {
+				t.Fatalf("removing %v reported %v", e, removed)
+			}
+			delete(held, e)
+			continue
+		}
+		if !held[e] {
+			tree.insert(e)
+			held[e] = true
+		}
+	}
+
+	want := []indexEntry{}
+	for e := range held {
+		want = append(want, e)
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].less(want[j]) })
+
+	got := []indexEntry{}
+	tree.ascendAll(func(e indexEntry) bool {
+		got = append(got, e)
+		return true
+	})
+	if tree.len != len(want) || !reflect.DeepEqual(got, want) {
+		t.Fatalf("tree holds %d entries in the wrong order, want %d", tree.len, len(want))
+	}
+
+	from := indexEntry{key: int64(250), id: -1}
+	first := sort.Search(len(want), func(i int) bool { return !want[i].less(from) })
+	got = got[:0]
+	tree.ascend(from, func(e indexEntry) bool {
+		got = append(got, e)
+		return len(got) < 10
+	})
+	if !reflect.DeepEqual(got, want[first:first+10]) {
+		t.Errorf("ascending from 250 got %v, want %v", got, want[first:first+10])
+	}
+}
+
+// indexTestTable creates the table n, with indexes over its columns when
+// indexed is set.
+func indexTestTable(t *testing.T, indexed bool) (*MemoryBackend, *functions.Session) {
+	mb := NewMemoryBackend()
+	session := functions.NewSession(mb)
+	queries := []string{"create table n (a int, f float, s text)"}
+	if indexed {
+		queries = append(queries, "create index n_a on n (a)", "create index n_f on n (f)", "create index n_s on n (s)")
+	}
+	for i := 0; i < 500; i++ {
+		a := fmt.Sprint(i * 37 % 101)
+		if i%13 == 0 {
+			a = "null"
+		}
+		queries = append(queries, fmt.Sprintf("insert into n values (%s, %d.5, '%c%d')", a, i%40, 'a'+i%26, i))
+	}
+
+	for _, query := range queries {
+		if _, err := run(mb, session, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	return mb, session
+}
+
+// TestIndexRangeScans checks that queries reading rows through an index
+// return the rows scanning the whole table returns.
+func TestIndexRangeScans(t *testing.T) {
+	indexed, indexedSession := indexTestTable(t, true)
+	scanned, scannedSession := indexTestTable(t, false)
+
+	for _, where := range []string{
+		"a = 5",
+		"a = 5.5",
+		"a > 90",
+		"a >= 90",
+		"90 < a",
+		"a < 3",
+		"a <= 3",
+		"a > 10 and a < 20",
+		"a >= 10 and a <= 20 and s > 'k'",
+		"a > 95 and a < 3",
+		"a > 2.5 and a < 6",
+		"a < -1",
+		"a > null",
+		"f >= 10.5 and f < 20",
+		"f > 38",
+		"s >= 'x'",
+		"s < 'b1'",
+		"s > 'c' and s <= 'c3'",
+	} {
+		t.Run(where, func(t *testing.T) {
+			query := "select a, f, s from n where " + where
+
+			plan, err := run(indexed, indexedSession, "explain "+query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !explains(plan.Rows, " using n_") {
+				t.Errorf("plan %v doesn't use an index", plan.Rows)
+			}
+
+			want, err := run(scanned, scannedSession, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := run(indexed, indexedSession, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Rows, want.Rows) {
+				t.Errorf("got %d rows %v, want %d rows %v", len(got.Rows), got.Rows, len(want.Rows), want.Rows)
+			}
+		})
+	}
+}
+
+// TestBetween checks how BETWEEN scans n and that it returns the rows
+// scanning the whole table returns.
+func TestBetween(t *testing.T) {
+	indexed, indexedSession := indexTestTable(t, true)
+	scanned, scannedSession := indexTestTable(t, false)
+
+	tests := []struct {
+		where  string
+		params []interface{}
+		// scan is the line of the EXPLAIN scanning n
+		scan string
+	}{
+		{"a between 10 and 20", nil, "Index range scan: n (a, f, s) using n_a (a >= 10 and a <= 20)"},
+		{"a between 20 and 10", nil, "Index range scan: n (a, f, s) using n_a (a >= 20 and a <= 10)"},
+		{"a between 10.5 and 12", nil, "Index range scan: n (a, f, s) using n_a (a >= 10.5 and a <= 12)"},
+		{"a between $1 and $2", []interface{}{int64(3), int64(5)}, "Index range scan: n (a, f, s) using n_a (a >= $1 and a <= $2)"},
+		{"s between 'a' and 'b'", nil, "Index range scan: n (a, f, s) using n_s (s >= 'a' and s <= 'b')"},
+		{"a between 10 and 20 and a = 15", nil, "Index scan: n (a, f, s) using n_a (a = 15)"},
+		{"a between null and 20", nil, "Index range scan: n (a, f, s) using n_a (a >= NULL and a <= 20)"},
+		// Neither a disjunction nor an expression over a can be looked up
+		{"a not between 10 and 95", nil, "Scan: n (a, f, s)"},
+		{"a + 1 between 10 and 20", nil, "Scan: n (a, f, s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			query := "select a, f, s from n where " + tt.where
+
+			plan, err := run(indexed, indexedSession, "explain "+query, tt.params...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !explainsLine(plan.Rows, tt.scan) {
+				t.Errorf("plan %v lacks %q", plan.Rows, tt.scan)
+			}
+
+			want, err := run(scanned, scannedSession, query, tt.params...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := run(indexed, indexedSession, query, tt.params...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Rows, want.Rows) {
+				t.Errorf("got %d rows %v, want %d rows %v", len(got.Rows), got.Rows, len(want.Rows), want.Rows)
+			}
+		})
+	}
+}
+
+// TestIndexHints checks that INDEX and NO_INDEX hints decide the index a
+// query scans its table with, and that the rows stay the same.
+func TestIndexHints(t *testing.T) {
+	mb, session := indexTestTable(t, true)
+	where := " from n where a = 5 and f = 10.5"
+
+	tests := []struct {
+		hint string
+		// scan is part of the scan line of the plan
+		scan string
+	}{
+		{"", "using n_a (a = 5)"},
+		{"/*+ index(n n_f) */", "using n_f (f = 10.5) as hinted"},
+		{"/*+ index(n n_s, n_f) */", "using n_f (f = 10.5) as hinted"},
+		{"/*+ index(n n_s) */", "Scan: n"},
+		{"/*+ no_index(n) */", "Scan: n"},
+		{"/*+ no_index(n n_a) */", "using n_f (f = 10.5)"},
+		{"/*+ index(m n_f) */", "using n_a (a = 5)"},
+	}
+
+	want, err := run(mb, session, "select a, f"+where)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hint, func(t *testing.T) {
+			query := "select " + tt.hint + " a, f" + where
+
+			plan, err := run(mb, session, "explain "+query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !explains(plan.Rows, tt.scan) {
+				t.Errorf("plan %v doesn't scan %s", plan.Rows, tt.scan)
+			}
+
+			got, err := run(mb, session, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Rows, want.Rows) {
+				t.Errorf("got %v, want %v", got.Rows, want.Rows)
+			}
+		})
+	}
+}
+
+// TestCreateIndexWithWrites builds an index in the steps CREATE INDEX
+// CONCURRENTLY takes, writing to the table between them, and checks the
+// index finds the rows written at every step.
+func TestCreateIndexWithWrites(t *testing.T) {
+	mb, session := indexTestTable(t, false)
+	insert := func(from, to int) {
+		for i := from; i < to; i++ {
+			if _, err := run(mb, session, fmt.Sprintf("insert into n values (%d, 0.5, 'w%d')", i%101, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ast, err := parser.Parse("create index concurrently n_a on n (a)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := mb.StartIndex(ast.Statements[0].CreateIndexStatement, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(0, 100)
+	if err := mb.BuildIndex(nil, b); err != nil {
+		t.Fatal(err)
+	}
+	insert(100, 200)
+	if err := mb.FinishIndex(b); err != nil {
+		t.Fatal(err)
+	}
+	insert(200, 300)
+
+	// Adding 0 keeps the index from being used
+	for _, where := range [][2]string{
+		{"a = 7", "a + 0 = 7"},
+		{"a >= 50 and a < 60", "a + 0 >= 50 and a + 0 < 60"},
+		{"a < 2", "a + 0 < 2"},
+	} {
+		got, err := run(mb, session, "select a, s from n where "+where[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := run(mb, session, "select a, s from n where "+where[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Rows) == 0 || !reflect.DeepEqual(got.Rows, want.Rows) {
+			t.Errorf("%s: got %v, want %v", where[0], got.Rows, want.Rows)
+		}
+	}
+}
+
+func TestCreateIndexTableChanged(t *testing.T) {
+	mb, session := indexTestTable(t, false)
+
+	ast, err := parser.Parse("create index concurrently n_a on n (a)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := mb.StartIndex(ast.Statements[0].CreateIndexStatement, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.BuildIndex(nil, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := run(mb, session, "alter table n add column b int"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.FinishIndex(b); err == nil {
+		t.Fatal("added an index built over the table before it was altered")
+	}
+	if _, _, ok := mb.findIndex("n_a"); ok {
+		t.Error("the index was added")
+	}
+}
+
+// TestExpressionIndexes checks that comparisons of an indexed expression
+// are looked up in its index and return the rows scanning t returns.
+func TestExpressionIndexes(t *testing.T) {
+	tests := []struct {
+		where string
+		// scan is the line of the EXPLAIN scanning t
+		scan string
+		rows [][]interface{}
+	}{
+		{"lower(name) = 'b'", "Index scan: t (id, name) using t_lower (lower(name) = 'b')", [][]interface{}{{int64(2)}, {int64(5)}}},
+		{"lower(name) > 'a'", "Index range scan: t (id, name) using t_lower (lower(name) > 'a')", [][]interface{}{{int64(2)}, {int64(3)}, {int64(5)}}},
+		{"'c' = lower(name)", "Index scan: t (id, name) using t_lower (lower(name) = 'c')", [][]interface{}{{int64(3)}}},
+		// Expressions only match when written the same way
+		{"upper(name) = 'B'", "Scan: t (id, name)", [][]interface{}{{int64(2)}, {int64(5)}}},
+		{"10/id = 5", "Index scan: t (id) using t_div (10 / id = 5)", [][]interface{}{{int64(2)}}},
+		{"10 / id between 2 and 3", "Index range scan: t (id) using t_div (10 / id >= 2 and 10 / id <= 3)", [][]interface{}{{int64(3)}, {int64(5)}}},
+		// The column alone isn't indexed
+		{"id = 2", "Scan: t (id)", [][]interface{}{{int64(2)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			mb, session := testBackend(t)
+			for _, query := range []string{
+				"create index t_lower on t (lower(name))",
+				"insert into t values (5, 'B', 0.5)",
+				"create index t_div on t (10 / id)",
+			} {
+				if _, err := run(mb, session, query); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			query := "select id from t where " + tt.where
+			plan, err := run(mb, session, "explain "+query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !explainsLine(plan.Rows, tt.scan) {
+				t.Errorf("plan %v lacks %q", plan.Rows, tt.scan)
+			}
+
+			results, err := run(mb, session, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
+
+// TestExpressionIndexErrors checks the expressions that can't be indexed and
+// the writes and changes that can't keep an expression index.
+func TestExpressionIndexErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		// err is matched when set, otherwise any error will do
+		err error
+	}{
+		{"create index t_r on t (random())", nil},
+		{"create index t_p on t (id + $1)", nil},
+		{"create index t_s on t ((select 1))", nil},
+		{"create index t_d on t (10 / (id - 2))", ErrDivisionByZero},
+		{"insert into t values (0, 'z', null)", ErrDivisionByZero},
+		{"alter table t alter column name type int", nil},
+		{"alter table t drop column name", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb, session := testBackend(t)
+			for _, query := range []string{
+				"create index t_lower on t (lower(name))",
+				"create index t_div on t (10 / id)",
+			} {
+				if _, err := run(mb, session, query); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			_, err := run(mb, session, tt.query, int64(1))
+			if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			// Nothing changed
+			results, err := run(mb, session, "select id, name from t where 10 / id > 0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}}
+			if !reflect.DeepEqual(results.Rows, want) {
+				t.Errorf("got %v, want %v", results.Rows, want)
+			}
+		})
+	}
+}
+
+// TestPartialIndexes checks that queries only read a partial index when
+// their WHERE implies its predicate, and that they return the rows scanning
+// the whole table returns.
+func TestPartialIndexes(t *testing.T) {
+	indexed, indexedSession := indexTestTable(t, false)
+	scanned, scannedSession := indexTestTable(t, false)
+	for _, query := range []string{
+		"create index n_a on n (a)",
+		"create index n_big on n (a) where f > 20",
+		"create index n_s on n (s) where a <> 5",
+	} {
+		if _, err := run(indexed, indexedSession, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	// Rows written after the indexes were built
+	for _, query := range []string{
+		"insert into n values (5, 30.5, 'q1')",
+		"insert into n values (6, 10.5, 'q2')",
+	} {
+		for _, mb := range []struct {
+			mb      *MemoryBackend
+			session *functions.Session
+		}{{indexed, indexedSession}, {scanned, scannedSession}} {
+			if _, err := run(mb.mb, mb.session, query); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+		}
+	}
+
+	tests := []struct {
+		where string
+		// scan is the line of the EXPLAIN scanning n
+		scan string
+	}{
+		{"a = 5 and f > 20", "Index scan: n (a, f, s) using n_big (a = 5)"},
+		{"f > 20 and a = 5", "Index scan: n (a, f, s) using n_big (a = 5)"},
+		{"a = 5 and f > 30", "Index scan: n (a, f, s) using n_big (a = 5)"},
+		{"a = 5 and f >= 20.5", "Index scan: n (a, f, s) using n_big (a = 5)"},
+		{"a = 5 and f = 25.5", "Index scan: n (a, f, s) using n_big (a = 5)"},
+		{"a > 90 and f > 20", "Index range scan: n (a, f, s) using n_big (a > 90)"},
+		{"s = 'q2' and a > 5", "Index scan: n (a, f, s) using n_s (s = 'q2')"},
+		{"s = 'q2' and a = 6", "Index scan: n (a, f, s) using n_s (s = 'q2')"},
+		// Predicates not implied fall back to the full index or a scan
+		{"a = 5 and f > 10", "Index scan: n (a, f, s) using n_a (a = 5)"},
+		{"a = 5", "Index scan: n (a, f, s) using n_a (a = 5)"},
+		{"s = 'q1'", "Scan: n (a, f, s)"},
+		{"s = 'q1' and f < 40", "Scan: n (a, f, s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			query := "select a, f, s from n where " + tt.where
+
+			plan, err := run(indexed, indexedSession, "explain "+query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !explainsLine(plan.Rows, tt.scan) {
+				t.Errorf("plan %v lacks %q", plan.Rows, tt.scan)
+			}
+
+			want, err := run(scanned, scannedSession, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := run(indexed, indexedSession, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Rows, want.Rows) {
+				t.Errorf("got %d rows %v, want %d rows %v", len(got.Rows), got.Rows, len(want.Rows), want.Rows)
+			}
+		})
+	}
+
+	for _, query := range []string{
+		"create index n_r on n (a) where random() > 0.5",
+		"create index n_p on n (a) where f > $1",
+		"alter table n drop column f",
+		"alter table n alter column f type int",
+	} {
+		if _, err := run(indexed, indexedSession, query, 1.5); err == nil {
+			t.Errorf("%s didn't fail", query)
+		}
+	}
+}
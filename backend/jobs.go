@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nireo/sgsql/storage"
+)
+
+// jobChunk is how many rows long-running statements copy between reporting
+// their progress and checking whether they were canceled.
+const jobChunk = 4096
+
+type sessionIDKey struct{}
+
+// WithSessionID returns a context whose long-running statements are listed
+// in __jobs as run by the session id, which is what KILL takes to cancel
+// them.
+func WithSessionID(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, id)
+}
+
+// job is a long-running statement listed in __jobs while it runs.
+type job struct {
+	id        int64
+	sessionID int64
+	kind      string
+	table     string
+	started   time.Time
+	total     int64
+	// done is the number of rows processed so far, it is updated
+	// atomically as the job progresses
+	done int64
+}
+
+// jobList holds the running jobs. It has a lock of its own so __jobs can
+// be read while the jobs run.
+type jobList struct {
+	mu      sync.Mutex
+	last    int64
+	running map[int64]*job
+}
+
+// startJob lists a job of kind on table processing total rows in __jobs
+// until endJob.
+func (mb *MemoryBackend) startJob(ctx context.Context, kind, table string, total int) *job {
+	j := &job{kind: kind, table: table, started: time.Now().UTC(), total: int64(total)}
+	if ctx != nil {
+		j.sessionID, _ = ctx.Value(sessionIDKey{}).(int64)
+	}
+
+	mb.jobs.mu.Lock()
+	defer mb.jobs.mu.Unlock()
+
+	mb.jobs.last++
+	j.id = mb.jobs.last
+	if mb.jobs.running == nil {
+		mb.jobs.running = map[int64]*job{}
+	}
+	mb.jobs.running[j.id] = j
+	return j
+}
+
+func (mb *MemoryBackend) endJob(j *job) {
+	mb.jobs.mu.Lock()
+	defer mb.jobs.mu.Unlock()
+
+	delete(mb.jobs.running, j.id)
+}
+
+// runningJobs returns the rows of __jobs.
+func (mb *MemoryBackend) runningJobs() [][]interface{} {
+	mb.jobs.mu.Lock()
+	defer mb.jobs.mu.Unlock()
+
+	jobs := make([]*job, 0, len(mb.jobs.running))
+	for _, j := range mb.jobs.running {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].id < jobs[b].id })
+
+	rows := make([][]interface{}, len(jobs))
+	for i, j := range jobs {
+		var session interface{}
+		if j.sessionID != 0 {
+			session = j.sessionID
+		}
+		rows[i] = []interface{}{j.id, session, j.kind, j.table, atomic.LoadInt64(&j.done), j.total, j.started}
+	}
+
+	return rows
+}
+
+// copyRows copies the rows of the table t called name through change as a
+// job of kind listed in __jobs. It copies them in chunks of jobChunk rows
+// and stops with the error of ctx once it is canceled, leaving t as it was.
+// It must be called without mb.mu held, so the job can be watched, and
+// the caller must keep t from being written meanwhile.
+func (mb *MemoryBackend) copyRows(ctx context.Context, kind, name string, t *memoryTable, change func(row storage.Row) (storage.Row, error)) ([]storage.Row, error) {
+	j := mb.startJob(ctx, kind, name, t.store.Len())
+	defer mb.endJob(j)
+
+	rows := make([]storage.Row, 0, t.store.Len())
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if len(rows)%jobChunk == 0 {
+			atomic.StoreInt64(&j.done, int64(len(rows)))
+			if ctx != nil {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		changed, err := change(row)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, changed)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	atomic.StoreInt64(&j.done, int64(len(rows)))
+
+	return rows, nil
+}
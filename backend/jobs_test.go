@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+func TestAlterTableCanceled(t *testing.T) {
+	tests := []string{
+		"alter table t add column flag bool",
+		"alter table t add column n int generated always as identity",
+		"alter table t drop column score",
+		"alter table t alter column id type text",
+	}
+
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			mb, session := testBackend(t)
+			want, err := run(mb, session, "select * from t")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ast, err := parser.Parse(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if _, err := Exec(ctx, mb, ast.Statements[0], session, nil); !errors.Is(err, context.Canceled) {
+				t.Fatalf("got %v, want %v", err, context.Canceled)
+			}
+
+			got, err := run(mb, session, "select * from t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Columns, want.Columns) || !reflect.DeepEqual(got.Rows, want.Rows) {
+				t.Errorf("t is %v %v after the canceled alter, want %v %v", got.Columns, got.Rows, want.Columns, want.Rows)
+			}
+
+			// Without canceling it the same alter goes through
+			if _, err := run(mb, session, query); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		session interface{}
+	}{
+		{"session", WithSessionID(context.Background(), 7), int64(7)},
+		{"no session", context.Background(), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+
+			// The copy reads __jobs while it runs
+			var listed [][]interface{}
+			_, err := mb.copyRows(tt.ctx, "ALTER TABLE", "t", mb.tables["t"], func(row storage.Row) (storage.Row, error) {
+				if listed == nil {
+					results, err := run(mb, session, "select session_id, kind, table_name, rows_done, rows_total from __jobs")
+					if err != nil {
+						return nil, err
+					}
+					listed = results.Rows
+				}
+				return row, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := [][]interface{}{{tt.session, "ALTER TABLE", "t", int64(0), int64(3)}}
+			if !reflect.DeepEqual(listed, want) {
+				t.Errorf("__jobs held %v while copying, want %v", listed, want)
+			}
+
+			results, err := run(mb, session, "select job_id from __jobs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results.Rows) != 0 {
+				t.Errorf("__jobs holds %v after the copy", results.Rows)
+			}
+		})
+	}
+}
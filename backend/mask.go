@@ -0,0 +1,264 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+// UnmaskPrivilege lets a user read the values of masked columns.
+const UnmaskPrivilege = "unmask"
+
+var ErrNoSuchPrivilege = errors.New("Privilege or role does not exist")
+
+// columnMask is how the values of a masked column are hidden, see maskValue.
+type columnMask struct {
+	// function is the masking function the column was masked with
+	function *parser.Expression
+	// keep is the number of trailing characters partial leaves visible
+	keep int
+}
+
+// newColumnMask checks the masking function call of a column of type t.
+// The functions are:
+//
+//	default()   replaces every value with one of the type that gives
+//	            nothing away, like xxxx or 0
+//	partial(n)  keeps the last n characters of text and hides the rest
+//	email()     keeps the first character of an email address
+func newColumnMask(call *parser.Expression, t ColumnType) (*columnMask, error) {
+	name := call.Call.Name.Value
+	args := call.Call.Args
+
+	m := &columnMask{function: call}
+	switch name {
+	case "default":
+		if len(args) != 0 {
+			return nil, errors.New("Masking function default takes no arguments")
+		}
+		return m, nil
+	case "partial":
+		if len(args) != 1 || args[0].Type != parser.LiteralType || args[0].Literal.Type != parser.Int64Value {
+			return nil, errors.New("Masking function partial takes the number of characters to keep")
+		}
+		if n := args[0].Literal.Int64; n < 0 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("Masking function partial can't keep %d characters", n)
+		}
+		m.keep = int(args[0].Literal.Int64)
+	case "email":
+		if len(args) != 0 {
+			return nil, errors.New("Masking function email takes no arguments")
+		}
+	default:
+		return nil, fmt.Errorf("Masking function %s does not exist, expected default, partial or email", name)
+	}
+
+	if t != TextType {
+		return nil, fmt.Errorf("Masking function %s can only mask text, not %s", name, t)
+	}
+
+	return m, nil
+}
+
+// maskValue returns what v looks like to a user who may not see it. NULL
+// stays NULL, so masking doesn't hide which values are missing.
+func (m *columnMask) maskValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	switch m.function.Call.Name.Value {
+	case "partial":
+		s := []rune(v.(string))
+		hidden := len(s) - m.keep
+		if hidden < 0 {
+			hidden = 0
+		}
+		return strings.Repeat("X", hidden) + string(s[hidden:])
+	case "email":
+		s := []rune(v.(string))
+		if len(s) == 0 {
+			return ""
+		}
+		return string(s[0]) + "XXX@XXXX.com"
+	}
+
+	switch v.(type) {
+	case string:
+		return "xxxx"
+	case int64:
+		return int64(0)
+	case float64:
+		return float64(0)
+	case bool:
+		return false
+	case time.Time:
+		return time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	case types.UUIDValue:
+		return types.UUIDValue{}
+	}
+
+	return nil
+}
+
+// alterMask masks a column of t as mask says, or stops masking it. It must
+// be called with mb.mu held for writing.
+func (mb *MemoryBackend) alterMask(name string, t *memoryTable, mask *parser.ColumnMask) error {
+	column := mask.Column.Value
+	i, ok := t.columnIndex(column)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrColumnDoesNotExist, column)
+	}
+
+	masks := map[string]*columnMask{}
+	for col, m := range t.masks {
+		masks[col] = m
+	}
+
+	if mask.Function == nil {
+		if masks[column] == nil {
+			return fmt.Errorf("Column %s is not masked", column)
+		}
+		delete(masks, column)
+	} else {
+		m, err := newColumnMask(mask.Function, t.columnTypes[i])
+		if err != nil {
+			return err
+		}
+		masks[column] = m
+	}
+
+	masked := *t
+	masked.masks = masks
+	mb.tables[name] = &masked
+	mb.changed(name)
+	return nil
+}
+
+// Grant gives a user or role a privilege, or takes it away again for
+// REVOKE. The only privilege is UNMASK, anything else granted is a role the
+// user becomes a member of, see CreateRole. Only the superuser may grant
+// and revoke them, see checkSuperuser.
+func (mb *MemoryBackend) Grant(grant *parser.GrantStatement, session *functions.Session) error {
+	what := "grant"
+	if grant.Revoke {
+		what = "revoke"
+	}
+	if grant.Privilege.Value == UnmaskPrivilege {
+		what += " UNMASK"
+	} else {
+		what += " roles"
+	}
+	if err := checkSuperuser(session, what); err != nil {
+		return err
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if grant.Privilege.Value != UnmaskPrivilege {
+		return mb.grantRole(grant)
+	}
+
+	unmasked := map[string]bool{}
+	for user := range mb.unmasked {
+		unmasked[user] = true
+	}
+
+	if grant.Revoke {
+		delete(unmasked, grant.User.Value)
+	} else {
+		unmasked[grant.User.Value] = true
+	}
+
+	mb.unmasked = unmasked
+	return nil
+}
+
+// maskedRows returns t with the values of its masked columns masked, unless
+// the user of the session of ev has the UNMASK privilege, directly or
+// through a role. The masks apply wherever the columns are used, in WHERE as
+// much as in the results, so values can't be found out by filtering on them.
+func (ev *evaluation) maskedRows(t *memoryTable) (*memoryTable, error) {
+	if len(t.masks) == 0 || ev.mb.canUnmask(ev.session.User()) {
+		return t, nil
+	}
+
+	masks := make([]*columnMask, len(t.columns))
+	for i, col := range t.columns {
+		masks[i] = t.masks[col]
+	}
+
+	rows := make([]storage.Row, 0, t.store.Len())
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if err := ev.canceled(); err != nil {
+			return nil, err
+		}
+
+		masked := append(storage.Row{}, row...)
+		for i, m := range masks {
+			if m != nil {
+				masked[t.position(i)] = m.maskValue(masked[t.position(i)])
+			}
+		}
+
+		if err := ev.mem.Grow(rowSize(masked)); err != nil {
+			return nil, err
+		}
+		rows = append(rows, masked)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	read := *t
+	read.store = storage.NewTable(rows...)
+	read.masks = nil
+	return &read, nil
+}
+
+// dumpMasks returns the statements masking the columns of the table t
+// called name again.
+func dumpMasks(name string, t *memoryTable) []DumpStatement {
+	columns := make([]string, 0, len(t.masks))
+	for col := range t.masks {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	stmts := make([]DumpStatement, len(columns))
+	for i, col := range columns {
+		stmts[i] = DumpStatement{
+			Query: "ALTER TABLE " + parser.FormatIdentifier(name) + " ALTER COLUMN " + parser.FormatIdentifier(col) +
+				" SET MASKED WITH " + t.masks[col].function.String(),
+		}
+	}
+
+	return stmts
+}
+
+// dumpGrants returns the statements granting the privileges of mb again.
+// It must be called with mb.mu held.
+func (mb *MemoryBackend) dumpGrants() []DumpStatement {
+	users := make([]string, 0, len(mb.unmasked))
+	for user := range mb.unmasked {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	stmts := make([]DumpStatement, len(users))
+	for i, user := range users {
+		stmts[i] = DumpStatement{Query: "GRANT UNMASK TO " + parser.FormatIdentifier(user)}
+	}
+
+	return stmts
+}
@@ -0,0 +1,1441 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nireo/sgsql/budget"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/trace"
+	"github.com/nireo/sgsql/types"
+)
+
+type memoryTable struct {
+	columns     []string
+	columnTypes []ColumnType
+	// collations has nil for columns compared with the default collation
+	collations []*types.Collation
+	// identities has the identity of each identity column and nil for the
+	// other columns, it is nil for tables without identity columns
+	identities []*identity
+	store      storage.Table
+	// positions holds where the columns of a projected view are in its rows,
+	// which it shares with the table it was projected from. It is nil for
+	// tables, whose rows hold their columns in order.
+	positions []int
+	// view is the query of a materialized view, nil for other tables
+	view *materializedView
+	// external is the file of an external table, whose store has no rows
+	external *externalTable
+	// policies are the row-level security policies of the table, see
+	// CreatePolicy
+	policies []*policy
+	// masks are the masks of the masked columns by their names
+	masks map[string]*columnMask
+	// indexes are the indexes over the columns of the table, see
+	// CreateIndex
+	indexes []*index
+	// foreignKeys are the columns of the table that are foreign keys, see
+	// foreignKey
+	foreignKeys []*foreignKey
+	// uniques are the constraints keeping columns of the table unique, see
+	// unique
+	uniques []*unique
+	// stats are what ANALYZE collected of the columns, nil until the table
+	// is analyzed, and statistics are those defined over several columns,
+	// see CreateStatistics
+	stats      *tableStats
+	statistics []*extendedStats
+	// history holds the committed versions of the table for AS OF
+	// TIMESTAMP, see RecordVersions
+	history *tableHistory
+	// comment describes the table and comments its columns by their names,
+	// see Comment
+	comment  string
+	comments map[string]string
+	// compression names the compressor the engine compresses the rows of
+	// the table with, empty for none, see storage.Compressing
+	compression string
+}
+
+// MemoryBackend runs statements over tables whose rows are stored in a
+// storage engine, and keeps everything else in memory. It is safe for
+// concurrent use.
+type MemoryBackend struct {
+	mu     sync.RWMutex
+	engine storage.Engine
+	tables map[string]*memoryTable
+	// lastVacuum is when the database was last vacuumed, zero if it
+	// hasn't been since it was opened
+	lastVacuum time.Time
+	// sessions lists the open sessions for __sessions, see SetSessions
+	sessions func() []SessionInfo
+	// audit lists the audited statements for __audit_log, see SetAudit
+	audit func() []AuditEntry
+	// prepared lists the prepared transactions for __prepared_xacts, see
+	// SetPrepared
+	prepared func() []PreparedInfo
+	// historyRetention is how long the versions of tables are kept, see
+	// SetHistoryRetention
+	historyRetention time.Duration
+	// jobs are the long-running statements listed in __jobs
+	jobs jobList
+	// workload records the scans RECOMMEND INDEXES suggests indexes for
+	workload workload
+	// budget accounts for the rows statements buffer, nil when they may
+	// buffer any amount
+	budget *budget.Accountant
+	// cache holds the results of recent queries, nil when it is off, and
+	// versions changes whenever a table is written, see changed
+	cache    *resultCache
+	clock    uint64
+	versions map[string]uint64
+	// externalDir holds the files external tables may read, see
+	// SetExternalDir
+	externalDir string
+	// unmasked are the users with the UNMASK privilege, the map is
+	// replaced rather than changed so snapshots can share it
+	unmasked map[string]bool
+	// roles are the roles created, and memberships the roles granted to
+	// each user or role, see CreateRole. Like unmasked they are replaced
+	// rather than changed
+	roles       map[string]bool
+	memberships map[string][]string
+
+	// Sequences advance while statements hold mu, so they are guarded by
+	// their own lock
+	seqMu     sync.Mutex
+	sequences map[string]*memorySequence
+	// seqLog is the value to log for each sequence whose reserved values
+	// have changed since it was last taken
+	seqLog map[string]int64
+}
+
+// NewMemoryBackend returns a backend storing its rows in memory.
+func NewMemoryBackend() *MemoryBackend {
+	return NewBackend(storage.NewMemory())
+}
+
+// NewBackend returns a backend storing its rows in engine, which must not
+// hold any tables yet.
+func NewBackend(engine storage.Engine) *MemoryBackend {
+	return &MemoryBackend{
+		engine:    engine,
+		tables:    map[string]*memoryTable{},
+		sequences: map[string]*memorySequence{},
+		seqLog:    map[string]int64{},
+
+		historyRetention: DefaultHistoryRetention,
+	}
+}
+
+func (mt *memoryTable) columnIndex(name string) (int, bool) {
+	for i, col := range mt.columns {
+		if col == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// position returns where the i-th column is in the rows of mt.
+func (mt *memoryTable) position(i int) int {
+	if mt.positions == nil {
+		return i
+	}
+
+	return mt.positions[i]
+}
+
+// evaluation holds what an expression can refer to while being evaluated.
+type evaluation struct {
+	// ctx is the context of the statement, nil when it isn't traced
+	ctx context.Context
+	// mem holds the memory the statement buffers, nil when it isn't
+	// accounted for
+	mem     *budget.Reservation
+	mb      *MemoryBackend
+	table   *memoryTable
+	row     []interface{}
+	session *functions.Session
+	params  []interface{}
+	// outer is the row of the enclosing query while evaluating a subquery
+	outer *evaluation
+	// hints are the planner hints of the statement
+	hints []parser.Hint
+	// patterns caches the compiled patterns of match operators for the
+	// whole statement, so a constant pattern is compiled once, not per row
+	patterns map[string]*regexp.Regexp
+	// plans caches how each subquery of the statement is answered
+	plans map[*parser.SelectStatement]*subqueryPlan
+	// aggregates are the results of aggregate function calls once all rows
+	// have been aggregated
+	aggregates map[*parser.CallExpression]interface{}
+}
+
+// start starts a span of the statement being evaluated.
+func (ev *evaluation) start(name string) trace.Span {
+	ctx := ev.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	_, span := trace.Start(ctx, name)
+	return span
+}
+
+// canceled returns the error of the statement's context once it is done,
+// which stops the statement between rows.
+func (ev *evaluation) canceled() error {
+	if ev.ctx == nil {
+		return nil
+	}
+
+	return ev.ctx.Err()
+}
+
+// startScan starts the span of scanning the table slct selects from.
+func (ev *evaluation) startScan(slct *parser.SelectStatement) trace.Span {
+	span := ev.start("sgsql.scan")
+	if slct.From != nil {
+		span.SetAttribute("table", slct.From.Value)
+	} else if slct.Function != nil {
+		span.SetAttribute("function", slct.Function.Call.Name.Value)
+	}
+
+	return span
+}
+
+// maxCachedPatterns bounds the cache when patterns come from the rows
+// themselves.
+const maxCachedPatterns = 256
+
+func (ev *evaluation) match(op string, l, r interface{}) (interface{}, error) {
+	s, lok := l.(string)
+	pattern, rok := r.(string)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%w: %v %s %v", ErrInvalidOperands, l, op, r)
+	}
+
+	key := op + " " + pattern
+	re, ok := ev.patterns[key]
+	if !ok {
+		var err error
+		if re, err = types.CompilePattern(op, pattern); err != nil {
+			return nil, err
+		}
+
+		if ev.patterns != nil && len(ev.patterns) < maxCachedPatterns {
+			ev.patterns[key] = re
+		}
+	}
+
+	return types.Match(op, s, re), nil
+}
+
+func (ev *evaluation) param(n uint) (interface{}, error) {
+	if n < 1 || n > uint(len(ev.params)) {
+		return nil, fmt.Errorf("%w: $%d", ErrMissingParameter, n)
+	}
+
+	v, ok := bind(ev.params[n-1])
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported value for $%d", ErrInvalidDatatype, n)
+	}
+
+	return v, nil
+}
+
+// bind converts a Go value passed in from outside to the value it is as a
+// SQL value, reporting whether it is one at all.
+func bind(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case nil, int64, float64, string, bool, types.IntervalValue, types.UUIDValue:
+		return v, true
+	case int:
+		return int64(v), true
+	case time.Time:
+		return v.UTC(), true
+	}
+
+	return nil, false
+}
+
+func (ev *evaluation) column(t *parser.Token) (interface{}, error) {
+	if ev.table != nil {
+		if i, ok := ev.table.columnIndex(t.Value); ok {
+			return ev.row[ev.table.position(i)], nil
+		}
+	}
+
+	if ev.outer != nil {
+		return ev.outer.column(t)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, t.Value)
+}
+
+func (ev *evaluation) binary(b *parser.BinaryExpression) (interface{}, error) {
+	if b.A.Type == parser.RowType || b.B.Type == parser.RowType {
+		return ev.compareRows(b.Op.Value, &b.A, &b.B)
+	}
+
+	l, err := ev.eval(&b.A)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := ev.eval(&b.B)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.Op.Value {
+	case "and", "or":
+		invalid := fmt.Errorf("%w: %v %s %v", ErrInvalidOperands, l, b.Op.Value, r)
+		return logical(b.Op.Value, l, r, invalid)
+	}
+
+	// Anything else involving NULL is NULL
+	if l == nil || r == nil {
+		return nil, nil
+	}
+
+	if types.IsMatch(b.Op.Value) {
+		return ev.match(b.Op.Value, l, r)
+	}
+
+	return types.ApplyCollated(b.Op.Value, l, r, ev.collation(&b.A, &b.B))
+}
+
+// compareRows compares two row values element by element. Rows are ordered
+// by their first elements that aren't equal, and comparing NULL makes the
+// result NULL unless another pair already decides it.
+func (ev *evaluation) compareRows(op string, a, b *parser.Expression) (interface{}, error) {
+	if a.Type != parser.RowType || b.Type != parser.RowType || len(a.Row) != len(b.Row) {
+		return nil, fmt.Errorf("%w: rows can only be compared with rows of the same length", ErrInvalidOperands)
+	}
+
+	l, err := ev.values(a.Row)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := ev.values(b.Row)
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := false
+	for i := range l {
+		if l[i] == nil || r[i] == nil {
+			if op != "=" && op != "<>" && op != "!=" {
+				return nil, nil
+			}
+			unknown = true
+			continue
+		}
+
+		coll := ev.collation(&a.Row[i], &b.Row[i])
+		eq, err := types.ApplyCollated("=", l[i], r[i], coll)
+		if err != nil {
+			return nil, err
+		}
+
+		if eq == true {
+			continue
+		}
+
+		switch op {
+		case "=":
+			return false, nil
+		case "<>", "!=":
+			return true, nil
+		}
+
+		return types.ApplyCollated(op, l[i], r[i], coll)
+	}
+
+	if unknown {
+		return nil, nil
+	}
+
+	switch op {
+	case "=", "<=", ">=":
+		return true, nil
+	case "<>", "!=", "<", ">":
+		return false, nil
+	}
+
+	return nil, fmt.Errorf("%w: operator %s on rows", ErrInvalidOperands, op)
+}
+
+func (ev *evaluation) values(exps []parser.Expression) ([]interface{}, error) {
+	values := make([]interface{}, len(exps))
+	for i := range exps {
+		v, err := ev.eval(&exps[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+// in evaluates IN as a chain of equality tests joined by OR, so it is NULL
+// rather than false when nothing matched but something was NULL.
+func (ev *evaluation) in(in *parser.InExpression) (interface{}, error) {
+	if in.Subquery {
+		return ev.inSubquery(in)
+	}
+
+	var result interface{} = false
+	for i := range in.List {
+		eq, err := ev.binary(&parser.BinaryExpression{
+			A:  in.Exp,
+			B:  in.List[i],
+			Op: parser.Token{Type: parser.SymbolType, Value: "="},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if eq == true {
+			result = true
+			break
+		}
+		if eq == nil {
+			result = nil
+		}
+	}
+
+	if in.Not && result != nil {
+		return !result.(bool), nil
+	}
+
+	return result, nil
+}
+
+// inSubquery evaluates Exp IN (query), comparing Exp with the values of the
+// rows of the query like an IN list compares it with its expressions.
+func (ev *evaluation) inSubquery(in *parser.InExpression) (interface{}, error) {
+	v, err := ev.eval(&in.Exp)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := ev.subquery(in.List[0].Subquery, inSubquery)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := set.(*valueSet).contains(v)
+	if err != nil || result == nil || !in.Not {
+		return result, err
+	}
+	return !result.(bool), nil
+}
+
+// collation returns the collation comparisons between exps are made with,
+// which is the one of the column among them.
+func (ev *evaluation) collation(exps ...*parser.Expression) *types.Collation {
+	if ev.table == nil {
+		return nil
+	}
+
+	for _, exp := range exps {
+		if exp.Type != parser.ColumnRefType {
+			continue
+		}
+
+		if i, ok := ev.table.columnIndex(exp.Column.Value); ok && ev.table.collations[i] != nil {
+			return ev.table.collations[i]
+		}
+	}
+
+	return nil
+}
+
+func (ev *evaluation) array(exp *parser.Expression) (interface{}, error) {
+	a := types.Array{
+		Elem:   ev.columnType(exp).Elem(),
+		Values: make([]interface{}, len(exp.Array)),
+	}
+
+	for i := range exp.Array {
+		v, err := ev.eval(&exp.Array[i])
+		if err != nil {
+			return nil, err
+		}
+
+		converted, ok := types.Assign(v, a.Elem)
+		if !ok {
+			return nil, fmt.Errorf("%w: %v in %s array", ErrInvalidDatatype, v, a.Elem)
+		}
+		a.Values[i] = converted
+	}
+
+	return a, nil
+}
+
+func (ev *evaluation) index(ix *parser.IndexExpression) (interface{}, error) {
+	v, err := ev.eval(&ix.Exp)
+	if err != nil {
+		return nil, err
+	}
+
+	i, err := ev.eval(&ix.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	if v == nil || i == nil {
+		return nil, nil
+	}
+
+	a, ok := v.(types.Array)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v is not an array", ErrInvalidOperands, v)
+	}
+
+	n, ok := i.(int64)
+	if !ok {
+		return nil, fmt.Errorf("%w: array index %v is not an int", ErrInvalidOperands, i)
+	}
+
+	return a.Index(n), nil
+}
+
+func (ev *evaluation) call(exp *parser.Expression) (interface{}, error) {
+	if v, ok := ev.aggregates[exp.Call]; ok {
+		return v, nil
+	}
+
+	f, ok := functions.Lookup(exp.Call.Name.Value)
+	if !ok {
+		return nil, fmt.Errorf("Function %s does not exist", exp.Call.Name.Value)
+	}
+
+	if f.Aggregate != nil {
+		return nil, fmt.Errorf("Aggregate function %s can only be used in SELECT items", f.Name)
+	}
+
+	args := make([]interface{}, len(exp.Call.Args))
+	for i := range exp.Call.Args {
+		v, err := ev.eval(&exp.Call.Args[i])
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	if f.Generate != nil {
+		return ev.generate(f, exp, args)
+	}
+
+	v, err := f.Eval(ev.session, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name, err)
+	}
+
+	// Results take the type the call was inferred to have, so coalesce(1, 1.5)
+	// is a float whichever argument it returns
+	if converted, ok := types.Assign(v, ev.columnType(exp)); ok {
+		return converted, nil
+	}
+
+	return v, nil
+}
+
+// generate builds the set the set-returning function f returns for args,
+// charging each element to the memory budget of the statement and stopping
+// once it is canceled.
+func (ev *evaluation) generate(f *functions.Function, exp *parser.Expression, args []interface{}) (interface{}, error) {
+	elem := ev.columnType(exp)
+	set := types.Array{Elem: elem}
+	err := f.Generate(ev.session, args, func(v interface{}) error {
+		if len(set.Values)%jobChunk == 0 {
+			if err := ev.canceled(); err != nil {
+				return err
+			}
+		}
+		if err := ev.mem.Grow(valueSize(v)); err != nil {
+			return err
+		}
+
+		if converted, ok := types.Assign(v, elem); ok {
+			v = converted
+		}
+		set.Values = append(set.Values, v)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Name, err)
+	}
+
+	return set, nil
+}
+
+// logical implements AND and OR with SQL's three-valued logic, where NULL
+// stands for an unknown truth value.
+func logical(op string, l, r interface{}, invalid error) (interface{}, error) {
+	lb, lok := l.(bool)
+	rb, rok := r.(bool)
+	if (!lok && l != nil) || (!rok && r != nil) {
+		return nil, invalid
+	}
+
+	if op == "and" {
+		if (lok && !lb) || (rok && !rb) {
+			return false, nil
+		}
+		if l == nil || r == nil {
+			return nil, nil
+		}
+		return true, nil
+	}
+
+	if (lok && lb) || (rok && rb) {
+		return true, nil
+	}
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	return false, nil
+}
+
+func (ev *evaluation) eval(exp *parser.Expression) (interface{}, error) {
+	switch exp.Type {
+	case parser.LiteralType:
+		return exp.Literal.Interface(), nil
+	case parser.ColumnRefType:
+		return ev.column(exp.Column)
+	case parser.ParamType:
+		return ev.param(exp.Param)
+	case parser.BinaryType:
+		return ev.binary(exp.Binary)
+	case parser.CastType:
+		v, err := ev.eval(&exp.Cast.Exp)
+		if err != nil {
+			return nil, err
+		}
+
+		t, ok := types.Parse(exp.Cast.Type.Value)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidDatatype, exp.Cast.Type.Value)
+		}
+
+		return types.Cast(v, t)
+	case parser.CallType:
+		return ev.call(exp)
+	case parser.ArrayType:
+		return ev.array(exp)
+	case parser.IndexType:
+		return ev.index(exp.Index)
+	case parser.InType:
+		return ev.in(exp.In)
+	case parser.RowType:
+		return nil, errors.New("Row values can only be compared")
+	case parser.ExistsType:
+		return ev.exists(exp.Exists)
+	case parser.SubqueryType:
+		return ev.subquery(exp.Subquery, scalarSubquery)
+	case parser.NotType:
+		v, err := ev.eval(exp.Not)
+		if err != nil || v == nil {
+			return nil, err
+		}
+
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%w: NOT %v", ErrInvalidOperands, v)
+		}
+		return !b, nil
+	}
+
+	return nil, errors.New("Unsupported expression")
+}
+
+// untyped reports whether exp is NULL or a parameter, which have no type
+// until they are evaluated.
+func untyped(exp *parser.Expression) bool {
+	return exp.Type == parser.ParamType ||
+		(exp.Type == parser.LiteralType && exp.Literal.Type == parser.NullValue)
+}
+
+// columnType infers the type an expression evaluates to without needing any
+// rows, so empty results still carry column metadata.
+func (ev *evaluation) columnType(exp *parser.Expression) ColumnType {
+	switch exp.Type {
+	case parser.BinaryType:
+		l := ev.columnType(&exp.Binary.A)
+		if t, ok := types.Binary(exp.Binary.Op.Value, l, ev.columnType(&exp.Binary.B)); ok {
+			return t
+		}
+
+		switch exp.Binary.Op.Value {
+		case "+", "-", "*", "/":
+			return l
+		case "||":
+			return TextType
+		}
+
+		return BoolType
+	case parser.InType, parser.ExistsType, parser.NotType:
+		return BoolType
+	case parser.CastType:
+		if t, ok := types.Parse(exp.Cast.Type.Value); ok {
+			return t
+		}
+	case parser.CallType:
+		f, ok := functions.Lookup(exp.Call.Name.Value)
+		if !ok {
+			break
+		}
+
+		args := make([]functions.Arg, len(exp.Call.Args))
+		for i := range exp.Call.Args {
+			args[i] = functions.Arg{Type: ev.columnType(&exp.Call.Args[i]), Known: !untyped(&exp.Call.Args[i])}
+		}
+
+		if t, ok, err := f.Type(args); ok && err == nil {
+			return t
+		}
+	case parser.ArrayType:
+		var elem ColumnType
+		found := false
+		for i := range exp.Array {
+			if untyped(&exp.Array[i]) {
+				continue
+			}
+
+			t := ev.columnType(&exp.Array[i])
+			if !found {
+				elem, found = t, true
+			} else if c, ok := types.Common(elem, t); ok {
+				elem = c
+			}
+		}
+
+		return types.ArrayOf(elem)
+	case parser.IndexType:
+		if t := ev.columnType(&exp.Index.Exp); t.IsArray() {
+			return t.Elem()
+		}
+	case parser.ColumnRefType:
+		for e := ev; e != nil; e = e.outer {
+			if e.table == nil {
+				continue
+			}
+
+			if i, ok := e.table.columnIndex(exp.Column.Value); ok {
+				return e.table.columnTypes[i]
+			}
+		}
+	case parser.SubqueryType:
+		if ev.mb == nil || len(exp.Subquery.Item) == 0 || exp.Subquery.Item[0].Asterisk {
+			break
+		}
+
+		if t, err := ev.subqueryTable(exp.Subquery); err == nil {
+			return ev.sub(t, nil).columnType(exp.Subquery.Item[0].Exp)
+		}
+	case parser.LiteralType:
+		switch exp.Literal.Type {
+		case parser.Int64Value:
+			return IntType
+		case parser.Float64Value:
+			return FloatType
+		case parser.BoolValue:
+			return BoolType
+		}
+	}
+
+	return TextType
+}
+
+func (mb *MemoryBackend) Columns(table string) ([]Column, bool) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	t, ok := mb.table(table)
+	if !ok {
+		return nil, false
+	}
+
+	columns := make([]Column, len(t.columns))
+	for i, name := range t.columns {
+		columns[i] = Column{Name: name, Type: t.columnTypes[i]}
+		if t.collations[i] != nil {
+			columns[i].Collation = t.collations[i].Name
+		}
+		if id := t.identityOf(i); id != nil {
+			columns[i].Identity = id.kind()
+		}
+		if fk := t.foreignKeyOf(name); fk != nil {
+			columns[i].References = Reference{Table: fk.table, Column: fk.references, Deferred: fk.deferred}
+		}
+		if u := t.uniqueOf(name); u != nil {
+			columns[i].Unique, columns[i].UniqueDeferred = true, u.deferred
+		}
+		columns[i].Comment = t.comments[name]
+	}
+
+	return columns, true
+}
+
+func (mb *MemoryBackend) Tables() []string {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	return mb.tableNames()
+}
+
+func (mb *MemoryBackend) CreateTable(crt *parser.CreateTableStatement, session *functions.Session) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if _, ok := mb.tables[crt.Name.Value]; ok {
+		return ErrTableAlreadyExists
+	}
+
+	if isSystemTable(crt.Name.Value) {
+		return ErrSystemTable
+	}
+
+	var cols []*parser.ColumnDefinition
+	if crt.Cols != nil {
+		cols = *crt.Cols
+	}
+
+	t := memoryTable{}
+	for _, col := range cols {
+		t.columns = append(t.columns, col.Name.Value)
+
+		dt, collation, err := definitionType(col)
+		if err != nil {
+			return err
+		}
+		t.collations = append(t.collations, collation)
+
+		t.columnTypes = append(t.columnTypes, dt)
+
+		id, err := newIdentity(col, dt)
+		if err != nil {
+			return err
+		}
+		if id != nil {
+			if t.identities == nil {
+				t.identities = make([]*identity, len(cols))
+			}
+			t.identities[len(t.columns)-1] = id
+		}
+	}
+
+	for _, col := range cols {
+		if col.References == nil && col.Unique == nil {
+			continue
+		}
+
+		if crt.Location != nil {
+			return fmt.Errorf("%w: %s, external tables can't have constraints", ErrExternalTable, crt.Name.Value)
+		}
+
+		if col.References != nil {
+			fk, err := mb.newForeignKey(crt.Name.Value, &t, col.Name.Value, col.References)
+			if err != nil {
+				return err
+			}
+			t.foreignKeys = append(t.foreignKeys, fk)
+		}
+
+		if col.Unique != nil {
+			u, err := newUnique(&t, col.Name.Value, col.Unique)
+			if err != nil {
+				return err
+			}
+			t.uniques = append(t.uniques, u)
+		}
+	}
+
+	if crt.Location != nil {
+		return mb.createExternalTable(crt, &t, session)
+	}
+
+	if crt.Compression != nil {
+		if _, err := storage.LookupCompressor(crt.Compression.Value); err != nil {
+			return err
+		}
+		t.compression = crt.Compression.Value
+	}
+
+	store, err := mb.createStore(crt.Name.Value, &t)
+	if err != nil {
+		return err
+	}
+	t.store = store
+
+	mb.tables[crt.Name.Value] = &t
+	mb.changed(crt.Name.Value)
+	return nil
+}
+
+// createStore creates the table of the engine holding the rows of t, called
+// name. Engines that can't compress tables ignore the compression of t.
+func (mb *MemoryBackend) createStore(name string, t *memoryTable) (storage.Table, error) {
+	if c, ok := mb.engine.(storage.Compressing); ok && t.compression != "" {
+		return c.CreateCompressedTable(name, t.compression)
+	}
+
+	return mb.engine.CreateTable(name)
+}
+
+// definitionType returns the type and collation col defines.
+func definitionType(col *parser.ColumnDefinition) (ColumnType, *types.Collation, error) {
+	dt, ok := types.Parse(col.Datatype.Value)
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: %s", ErrInvalidDatatype, col.Datatype.Value)
+	}
+
+	var collation *types.Collation
+	if col.Collate != nil {
+		if dt != TextType {
+			return 0, nil, fmt.Errorf("%w: collation on %s column %s", ErrInvalidDatatype, dt, col.Name.Value)
+		}
+
+		if collation, ok = types.LookupCollation(col.Collate.Value); !ok {
+			return 0, nil, fmt.Errorf("Collation %s does not exist", col.Collate.Value)
+		}
+	}
+
+	return dt, collation, nil
+}
+
+func (mb *MemoryBackend) Insert(inst *parser.InsertStatement, session *functions.Session, params []interface{}) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if isSystemTable(inst.Table.Value) {
+		return ErrSystemTable
+	}
+
+	t, ok := mb.tables[inst.Table.Value]
+	if !ok {
+		return ErrTableDoesNotExist
+	}
+
+	if t.view != nil {
+		return fmt.Errorf("%w: %s", ErrMaterializedView, inst.Table.Value)
+	}
+
+	if t.external != nil {
+		return fmt.Errorf("%w: %s", ErrExternalTable, inst.Table.Value)
+	}
+
+	if inst.Values == nil || len(*inst.Values) != len(t.columns) {
+		return ErrMissingValues
+	}
+
+	ev := evaluation{
+		mb:       mb,
+		session:  session,
+		params:   params,
+		patterns: map[string]*regexp.Regexp{},
+		plans:    map[*parser.SelectStatement]*subqueryPlan{},
+	}
+	row := []interface{}{}
+	var identities []*identity
+	if t.identities != nil {
+		identities = append(identities, t.identities...)
+	}
+	for i, value := range *inst.Values {
+		// DEFAULT is NULL for columns other than identity columns
+		var v interface{}
+		if value.Type != parser.DefaultType {
+			var err error
+			if v, err = ev.eval(value); err != nil {
+				return err
+			}
+		}
+
+		v, ok := types.Assign(v, t.columnTypes[i])
+		if !ok {
+			return fmt.Errorf("%w: expected %s for column %s",
+				ErrInvalidDatatype, t.columnTypes[i], t.columns[i])
+		}
+
+		if id := t.identityOf(i); id != nil {
+			var err error
+			if v, identities[i], err = insertValue(id, t.columns[i], inst, value, v); err != nil {
+				return err
+			}
+		}
+
+		row = append(row, v)
+	}
+
+	if err := ev.checkPolicies(inst.Table.Value, t, row); err != nil {
+		return err
+	}
+
+	if err := checkKeys(t, row); err != nil {
+		return err
+	}
+
+	if err := mb.checkForeignKeys(inst.Table.Value, t, row); err != nil {
+		return err
+	}
+
+	if err := checkUnique(inst.Table.Value, t, row); err != nil {
+		return err
+	}
+
+	if err := t.store.Insert(row); err != nil {
+		return err
+	}
+	t.identities = identities
+
+	mb.changed(inst.Table.Value)
+	return nil
+}
+
+func (mb *MemoryBackend) Select(ctx context.Context, slct *parser.SelectStatement, session *functions.Session, params []interface{}) (*Results, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	// Streamed rows aren't kept to cache them
+	if w, _ := takeRowWriter(ctx); mb.cache == nil || w != nil {
+		return mb.selectRows(ctx, slct, session, params)
+	}
+
+	key, tables, ok := cacheKey(slct, params)
+	if !ok || !mb.cachedTables(tables) {
+		return mb.selectRows(ctx, slct, session, params)
+	}
+
+	if results, ok := mb.cache.get(mb, key); ok {
+		return results, nil
+	}
+
+	results, err := mb.selectRows(ctx, slct, session, params)
+	if err != nil {
+		return nil, err
+	}
+
+	mb.cache.put(mb, key, tables, results)
+	return results, nil
+}
+
+// resultColumns returns the columns slct returns from the table of ev, and
+// the positions of the items calling set-returning functions.
+func (ev *evaluation) resultColumns(slct *parser.SelectStatement) ([]ResultColumn, []int) {
+	var columns []ResultColumn
+	var sets []int
+	for _, item := range slct.Item {
+		if item.Asterisk {
+			for i, col := range ev.table.columns {
+				columns = append(columns, ResultColumn{
+					Type: ev.table.columnTypes[i],
+					Name: col,
+				})
+			}
+			continue
+		}
+
+		if item.Exp.Type == parser.CallType {
+			if f, ok := functions.Lookup(item.Exp.Call.Name.Value); ok && f.Set {
+				sets = append(sets, len(columns))
+			}
+		}
+
+		name := "?column?"
+		if item.As != nil {
+			name = item.As.Value
+		} else if item.Exp.Type == parser.ColumnRefType {
+			name = item.Exp.Column.Value
+		}
+
+		columns = append(columns, ResultColumn{
+			Type: ev.columnType(item.Exp),
+			Name: name,
+		})
+	}
+
+	return columns, sets
+}
+
+// selectRows runs slct. It must be called with mb.mu held.
+func (mb *MemoryBackend) selectRows(ctx context.Context, slct *parser.SelectStatement, session *functions.Session, params []interface{}) (*Results, error) {
+	// Selecting without a table evaluates the items once over an empty row
+	t := &memoryTable{store: storage.NewTable(storage.Row{})}
+	if slct.From != nil {
+		var ok bool
+		t, ok = mb.lookupTable(ctx, slct.From.Value)
+		if !ok {
+			return nil, ErrTableDoesNotExist
+		}
+	}
+
+	return mb.selectFrom(ctx, slct, t, session, params, nil)
+}
+
+// selectFrom runs slct over the rows of t, which stands for the table slct
+// reads. A query reading a set-returning function calls it instead. An aggregating query adds the rows to agg when it is set, so the
+// results aggregate the rows it was given before too. It must be called
+// with mb.mu held.
+func (mb *MemoryBackend) selectFrom(ctx context.Context, slct *parser.SelectStatement, t *memoryTable, session *functions.Session, params []interface{}, agg *aggregation) (*Results, error) {
+	w, ctx := takeRowWriter(ctx)
+	base := &evaluation{
+		ctx:      ctx,
+		mem:      mb.budget.Reserve(),
+		mb:       mb,
+		session:  session,
+		params:   params,
+		hints:    slct.Hints,
+		patterns: map[string]*regexp.Regexp{},
+		plans:    map[*parser.SelectStatement]*subqueryPlan{},
+	}
+	defer base.mem.Close()
+
+	if slct.Function != nil {
+		var err error
+		if t, err = base.functionTable(slct.Function); err != nil {
+			return nil, err
+		}
+	} else if t.external != nil {
+		var err error
+		if t, err = base.externalRows(slct.From.Value, t); err != nil {
+			return nil, err
+		}
+	} else if slct.AsOf != nil {
+		var err error
+		if t, err = base.historicRows(slct, t); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if t, err = base.indexedRows(slct, t); err != nil {
+			return nil, err
+		}
+	}
+
+	// Policies hide rows and masks values before anything else sees them,
+	// so a walk can't reach hidden rows through visible ones either
+	var err error
+	if t, err = base.visibleRows(t); err != nil {
+		return nil, err
+	}
+	if t, err = base.maskedRows(t); err != nil {
+		return nil, err
+	}
+
+	// The rows are walked before WHERE filters them, so it can refer to the
+	// pseudo columns of the walk
+	if slct.ConnectBy != nil {
+		var err error
+		if t, err = base.connectBy(slct.ConnectBy, t); err != nil {
+			return nil, err
+		}
+	}
+
+	if slct.From != nil {
+		t = mb.project(t, slct)
+	}
+
+	scope := base.sub(t, nil)
+
+	// sets are the positions of items calling set-returning functions
+	columns, sets := scope.resultColumns(slct)
+	results := Results{Columns: columns}
+
+	var filter []*parser.Expression
+	if slct.Where != nil {
+		filter = []*parser.Expression{slct.Where}
+	}
+
+	offset, limit, err := base.window(slct)
+	if err != nil {
+		return nil, err
+	}
+
+	// An aggregating query returns a single row even when no rows match
+	if isAggregate(slct) {
+		span := base.startScan(slct)
+		rows, err := base.filter(t, filter, -1)
+		span.SetAttribute("rows.returned", len(rows))
+		span.End()
+		if err != nil {
+			return nil, err
+		}
+
+		if agg == nil {
+			if agg, err = newAggregation(slct); err != nil {
+				return nil, err
+			}
+		}
+
+		span = base.start("sgsql.aggregate")
+		row, err := base.aggregate(agg, t, rows)
+		span.End()
+		if err != nil {
+			return nil, err
+		}
+
+		results.Rows = cut([][]interface{}{row}, offset, limit)
+		return &results, nil
+	}
+
+	// Sorted rows are returned once every row is sorted, a top-N sort
+	// keeping only as many as LIMIT returns
+	var order *sorter
+	if len(slct.OrderBy) > 0 {
+		keys, err := scope.sortKeys(slct)
+		if err != nil {
+			return nil, err
+		}
+		order = &sorter{keys: keys, limit: bound(offset, limit), mem: base.mem}
+	}
+
+	scan := t.store.Scan()
+	if never(filter) || limit == 0 {
+		scan = storage.NewTable().Scan()
+	}
+
+	if w != nil {
+		if err := w.WriteColumns(columns); err != nil {
+			return nil, err
+		}
+	}
+
+	span := base.startScan(slct)
+	scanned, returned := 0, 0
+	defer func() {
+		span.SetAttribute("rows.scanned", scanned)
+		span.SetAttribute("rows.returned", returned)
+		span.End()
+	}()
+
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if err := base.canceled(); err != nil {
+			return nil, err
+		}
+
+		scanned++
+		ev := base.sub(t, row)
+		if ok, err := ev.satisfies(filter); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+
+		result := []interface{}{}
+		for _, item := range slct.Item {
+			if item.Asterisk {
+				result = append(result, row...)
+				continue
+			}
+
+			v, err := ev.eval(item.Exp)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, v)
+		}
+
+		rows := [][]interface{}{result}
+		if len(sets) > 0 {
+			rows = expandSets(result, sets)
+		}
+
+		if order != nil {
+			if err := order.add(ev, rows); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Without ORDER BY the rows are cut as they are found, and the
+		// scan stops once LIMIT of them are returned
+		if skip := int64(len(rows)); offset > 0 {
+			if skip > offset {
+				skip = offset
+			}
+			rows, offset = rows[skip:], offset-skip
+		}
+		if limit >= 0 && int64(returned+len(rows)) > limit {
+			rows = rows[:limit-int64(returned)]
+		}
+
+		if err := base.emit(w, &results, rows, true); err != nil {
+			return nil, err
+		}
+		returned += len(rows)
+		if limit >= 0 && int64(returned) == limit {
+			break
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	if order != nil {
+		rows := cut(order.sorted(), offset, limit)
+		if err := base.emit(w, &results, rows, false); err != nil {
+			return nil, err
+		}
+		returned += len(rows)
+	}
+
+	return &results, nil
+}
+
+// emit writes rows to w, or adds them to results without one, growing the
+// memory held by ev for them unless it already holds them.
+func (ev *evaluation) emit(w RowWriter, results *Results, rows [][]interface{}, grow bool) error {
+	if w != nil {
+		for _, row := range rows {
+			if err := w.WriteRow(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if grow {
+		for _, row := range rows {
+			if err := ev.mem.Grow(rowSize(row)); err != nil {
+				return err
+			}
+		}
+	}
+	results.Rows = append(results.Rows, rows...)
+	return nil
+}
+
+// expandSets turns a row into one row per element of the arrays returned by
+// set-returning functions at the positions in sets. Arrays shorter than the
+// longest are padded with NULL.
+func expandSets(row []interface{}, sets []int) [][]interface{} {
+	n := 0
+	for _, i := range sets {
+		if a, ok := row[i].(types.Array); ok && len(a.Values) > n {
+			n = len(a.Values)
+		}
+	}
+
+	rows := make([][]interface{}, n)
+	for k := range rows {
+		rows[k] = append([]interface{}{}, row...)
+		for _, i := range sets {
+			a, _ := row[i].(types.Array)
+			rows[k][i] = a.Index(int64(k + 1))
+		}
+	}
+
+	return rows
+}
+
+// MemorySnapshot is the state of a MemoryBackend at some point in time.
+// Sequences are shared with the backend rather than copied, so restoring
+// drops the ones created since but doesn't rewind the others, values that
+// have been handed out are never handed out again.
+type MemorySnapshot struct {
+	tables    map[string]*memoryTable
+	storage   storage.Snapshot
+	sequences map[string]*memorySequence
+	unmasked  map[string]bool
+	roles     map[string]bool
+	// memberships are the roles granted to each user or role
+	memberships map[string][]string
+	// indexed is how many rows each index had indexed
+	indexed map[*index]int
+	// versions are the versions of the tables, see changed
+	versions map[string]uint64
+}
+
+// Snapshot captures the current contents of every table, their rows are
+// captured by the storage engine.
+func (mb *MemoryBackend) Snapshot() *MemorySnapshot {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	s := MemorySnapshot{
+		tables:      map[string]*memoryTable{},
+		storage:     mb.engine.Snapshot(),
+		unmasked:    mb.unmasked,
+		roles:       mb.roles,
+		memberships: mb.memberships,
+		indexed:     indexesSince(mb.tables),
+		versions:    map[string]uint64{},
+	}
+	for name, t := range mb.tables {
+		copied := *t
+		s.tables[name] = &copied
+		s.versions[name] = mb.versions[name]
+	}
+
+	mb.seqMu.Lock()
+	defer mb.seqMu.Unlock()
+
+	s.sequences = map[string]*memorySequence{}
+	for name, seq := range mb.sequences {
+		s.sequences[name] = seq
+	}
+
+	return &s
+}
+
+// Restore discards every change made since s was taken. Only the tables
+// written since get a new version, so results cached from the others stay
+// fresh.
+func (mb *MemoryBackend) Restore(s *MemorySnapshot) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	written := []string{}
+	for name := range mb.tables {
+		if version, ok := s.versions[name]; !ok || version != mb.versions[name] {
+			written = append(written, name)
+		}
+	}
+	for name := range s.tables {
+		if _, ok := mb.tables[name]; !ok {
+			written = append(written, name)
+		}
+	}
+
+	dropped := mb.restoreIndexes(s.indexed)
+	mb.engine.Restore(s.storage)
+	// An index whose rows can't be read again stays dropped, the queries
+	// scanning its table fail with the error instead
+	for _, idx := range dropped {
+		idx.live = idx.rebuild() == nil
+	}
+	mb.unmasked = s.unmasked
+	mb.roles, mb.memberships = s.roles, s.memberships
+	mb.tables = map[string]*memoryTable{}
+	for name, t := range s.tables {
+		copied := *t
+		mb.tables[name] = &copied
+	}
+	for _, name := range written {
+		mb.changed(name)
+	}
+
+	mb.seqMu.Lock()
+	defer mb.seqMu.Unlock()
+
+	mb.sequences = map[string]*memorySequence{}
+	for name, seq := range s.sequences {
+		mb.sequences[name] = seq
+	}
+}
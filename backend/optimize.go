@@ -0,0 +1,369 @@
+package backend
+
+import (
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+)
+
+// Optimize rewrites stmt in place into an equivalent statement that is
+// cheaper to run. Constant expressions are folded into literals, predicates
+// that are always true are dropped and NOT is pushed down to the operands it
+// applies to. Parameters are never folded, so a prepared statement can be
+// optimized once for all of its bindings.
+func Optimize(stmt *parser.Statement) {
+	switch stmt.Type {
+	case parser.SelectType:
+		optimizeSelect(stmt.SelectStatement)
+	case parser.InsertType:
+		if stmt.InsertStatement.Values != nil {
+			for _, exp := range *stmt.InsertStatement.Values {
+				simplify(exp)
+			}
+		}
+	case parser.CreateMaterializedViewType:
+		optimizeSelect(stmt.CreateMaterializedViewStatement.Query)
+	case parser.CreateIndexType:
+		// Simplified the same way as the WHERE of queries, the predicate of
+		// a partial index is easier to match with theirs
+		if stmt.CreateIndexStatement.Where != nil {
+			simplify(stmt.CreateIndexStatement.Where)
+		}
+	case parser.ExplainType:
+		Optimize(stmt.ExplainStatement.Statement)
+	}
+}
+
+func optimizeSelect(slct *parser.SelectStatement) {
+	for _, item := range slct.Item {
+		if !item.Asterisk {
+			simplify(item.Exp)
+		}
+	}
+
+	if slct.Where != nil {
+		simplify(slct.Where)
+		if v, ok := constant(slct.Where); ok && v == true {
+			slct.Where = nil
+		}
+	}
+
+	if slct.Function != nil {
+		simplify(slct.Function)
+	}
+
+	if slct.ConnectBy != nil && slct.ConnectBy.Start != nil {
+		simplify(slct.ConnectBy.Start)
+	}
+
+	// ORDER BY isn't folded, which could turn an expression into the
+	// position of an item
+	if slct.Limit != nil {
+		simplify(slct.Limit)
+	}
+	if slct.Offset != nil {
+		simplify(slct.Offset)
+	}
+}
+
+// never reports whether one of filter was folded into a constant that isn't
+// true, so no row can satisfy it.
+func never(filter []*parser.Expression) bool {
+	for _, exp := range filter {
+		if v, ok := constant(exp); ok && v != true {
+			return true
+		}
+	}
+
+	return false
+}
+
+// constant returns the value of exp if it is a literal.
+func constant(exp *parser.Expression) (interface{}, bool) {
+	if exp.Type != parser.LiteralType {
+		return nil, false
+	}
+
+	return exp.Literal.Interface(), true
+}
+
+// negated maps comparison and match operators to the operator giving the
+// opposite result. Both give NULL for NULL operands.
+var negated = map[string]string{
+	"=":   "<>",
+	"<>":  "=",
+	"!=":  "=",
+	"<":   ">=",
+	"<=":  ">",
+	">":   "<=",
+	">=":  "<",
+	"~":   "!~",
+	"!~":  "~",
+	"~*":  "!~*",
+	"!~*": "~*",
+}
+
+// simplify rewrites exp and its subexpressions in place, folding the ones
+// that don't depend on rows, parameters or functions into literals.
+func simplify(exp *parser.Expression) {
+	switch exp.Type {
+	case parser.BinaryType:
+		simplify(&exp.Binary.A)
+		simplify(&exp.Binary.B)
+		switch exp.Binary.Op.Value {
+		case "and", "or":
+			simplifyLogical(exp)
+			return
+		}
+	case parser.CastType:
+		simplify(&exp.Cast.Exp)
+	case parser.CallType:
+		for i := range exp.Call.Args {
+			simplify(&exp.Call.Args[i])
+		}
+		return
+	case parser.ArrayType:
+		for i := range exp.Array {
+			simplify(&exp.Array[i])
+		}
+		return
+	case parser.IndexType:
+		simplify(&exp.Index.Exp)
+		simplify(&exp.Index.Index)
+		return
+	case parser.RowType:
+		for i := range exp.Row {
+			simplify(&exp.Row[i])
+		}
+		return
+	case parser.InType:
+		simplify(&exp.In.Exp)
+		for i := range exp.In.List {
+			simplify(&exp.In.List[i])
+		}
+	case parser.NotType:
+		simplify(exp.Not)
+		if !pushNot(exp) {
+			break
+		}
+		simplify(exp)
+		return
+	case parser.ExistsType:
+		optimizeSelect(exp.Exists.Query)
+		return
+	case parser.SubqueryType:
+		optimizeSelect(exp.Subquery)
+		return
+	default:
+		return
+	}
+
+	fold(exp)
+}
+
+// simplifyLogical drops the operands of AND and OR that don't change the
+// result and replaces the expression with the operand that decides it.
+func simplifyLogical(exp *parser.Expression) {
+	a, b := &exp.Binary.A, &exp.Binary.B
+	av, aok := constant(a)
+	bv, bok := constant(b)
+	if aok && bok {
+		fold(exp)
+		return
+	}
+
+	// The operand that decides the result is false for AND, true for OR,
+	// and the one that doesn't matter is the opposite
+	decides := exp.Binary.Op.Value == "or"
+	switch {
+	case aok && av == decides && !modifies(b), bok && bv == decides && !modifies(a):
+		*exp = parser.Expression{
+			Literal: &parser.Value{Type: parser.BoolValue, Bool: decides},
+			Type:    parser.LiteralType,
+			Loc:     exp.Loc,
+		}
+	case aok && av == !decides:
+		*exp = *b
+	case bok && bv == !decides:
+		*exp = *a
+	}
+}
+
+// pushNot rewrites the NOT expression exp into an equivalent expression
+// without NOT at its top, reporting whether it could.
+func pushNot(exp *parser.Expression) bool {
+	operand := exp.Not
+	switch operand.Type {
+	case parser.NotType:
+		*exp = *operand.Not
+	case parser.BinaryType:
+		b := operand.Binary
+		switch b.Op.Value {
+		case "and", "or":
+			// NOT (a AND b) is NOT a OR NOT b, and the other way around
+			op := b.Op
+			op.Value = "or"
+			if b.Op.Value == "or" {
+				op.Value = "and"
+			}
+
+			*exp = parser.Expression{
+				Binary: &parser.BinaryExpression{
+					A:  parser.Expression{Not: &b.A, Type: parser.NotType, Loc: b.A.Loc},
+					B:  parser.Expression{Not: &b.B, Type: parser.NotType, Loc: b.B.Loc},
+					Op: op,
+				},
+				Type: parser.BinaryType,
+				Loc:  exp.Loc,
+			}
+		default:
+			neg, ok := negated[b.Op.Value]
+			if !ok {
+				return false
+			}
+
+			op := b.Op
+			op.Value = neg
+			*exp = parser.Expression{
+				Binary: &parser.BinaryExpression{A: b.A, B: b.B, Op: op},
+				Type:   parser.BinaryType,
+				Loc:    operand.Loc,
+			}
+		}
+	case parser.InType:
+		in := *operand.In
+		in.Not = !in.Not
+		*exp = parser.Expression{In: &in, Type: parser.InType, Loc: operand.Loc}
+	case parser.ExistsType:
+		exists := *operand.Exists
+		exists.Not = !exists.Not
+		*exp = parser.Expression{Exists: &exists, Type: parser.ExistsType, Loc: exp.Loc}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// fold replaces exp with a literal of its value if all of its operands are
+// literals. Expressions failing to evaluate are left for the error to be
+// reported when they run, and so are values literals can't hold. A NULL
+// literal is always typed text, so NULL results are left as well for the
+// column to keep the type of the expression.
+func fold(exp *parser.Expression) {
+	var operands []*parser.Expression
+	switch exp.Type {
+	case parser.BinaryType:
+		if exp.Binary.A.Type == parser.RowType || exp.Binary.B.Type == parser.RowType {
+			return
+		}
+		operands = []*parser.Expression{&exp.Binary.A, &exp.Binary.B}
+	case parser.CastType:
+		operands = []*parser.Expression{&exp.Cast.Exp}
+	case parser.InType:
+		operands = []*parser.Expression{&exp.In.Exp}
+		for i := range exp.In.List {
+			if exp.In.List[i].Type == parser.RowType {
+				return
+			}
+			operands = append(operands, &exp.In.List[i])
+		}
+	case parser.NotType:
+		operands = []*parser.Expression{exp.Not}
+	default:
+		return
+	}
+
+	for _, operand := range operands {
+		if _, ok := constant(operand); !ok {
+			return
+		}
+	}
+
+	v, err := (&evaluation{}).eval(exp)
+	if err != nil {
+		return
+	}
+
+	var lit *parser.Value
+	switch v := v.(type) {
+	case int64:
+		lit = &parser.Value{Type: parser.Int64Value, Int64: v}
+	case float64:
+		lit = &parser.Value{Type: parser.Float64Value, Float64: v}
+	case string:
+		lit = &parser.Value{Type: parser.StringValue, String: v}
+	case bool:
+		lit = &parser.Value{Type: parser.BoolValue, Bool: v}
+	default:
+		return
+	}
+
+	*exp = parser.Expression{Literal: lit, Type: parser.LiteralType, Loc: exp.Loc}
+}
+
+// modifies reports whether evaluating exp changes the database, so it can't
+// be dropped even when its value doesn't matter.
+func modifies(exp *parser.Expression) bool {
+	switch exp.Type {
+	case parser.CallType:
+		if f, ok := functions.Lookup(exp.Call.Name.Value); ok && f.Modifies {
+			return true
+		}
+		return anyModifies(exp.Call.Args)
+	case parser.BinaryType:
+		return modifies(&exp.Binary.A) || modifies(&exp.Binary.B)
+	case parser.CastType:
+		return modifies(&exp.Cast.Exp)
+	case parser.IndexType:
+		return modifies(&exp.Index.Exp) || modifies(&exp.Index.Index)
+	case parser.ArrayType:
+		return anyModifies(exp.Array)
+	case parser.RowType:
+		return anyModifies(exp.Row)
+	case parser.InType:
+		return modifies(&exp.In.Exp) || anyModifies(exp.In.List)
+	case parser.NotType:
+		return modifies(exp.Not)
+	case parser.ExistsType:
+		return queryModifies(exp.Exists.Query)
+	case parser.SubqueryType:
+		return queryModifies(exp.Subquery)
+	}
+
+	return false
+}
+
+func anyModifies(exps []parser.Expression) bool {
+	for i := range exps {
+		if modifies(&exps[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func queryModifies(slct *parser.SelectStatement) bool {
+	for _, item := range slct.Item {
+		if !item.Asterisk && modifies(item.Exp) {
+			return true
+		}
+	}
+
+	if slct.Function != nil && modifies(slct.Function) {
+		return true
+	}
+
+	if slct.ConnectBy != nil && slct.ConnectBy.Start != nil && modifies(slct.ConnectBy.Start) {
+		return true
+	}
+
+	for _, order := range slct.OrderBy {
+		if modifies(order.Exp) {
+			return true
+		}
+	}
+
+	return (slct.Where != nil && modifies(slct.Where)) || (slct.Limit != nil && modifies(slct.Limit)) ||
+		(slct.Offset != nil && modifies(slct.Offset))
+}
@@ -0,0 +1,283 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+var (
+	ErrPolicyExists       = errors.New("Policy already exists")
+	ErrPolicyDoesNotExist = errors.New("Policy does not exist")
+	ErrPolicyViolation    = errors.New("New row violates the policies of the table")
+)
+
+// policy is a row-level security policy of a table.
+type policy struct {
+	name  string
+	using *parser.Expression
+}
+
+// CreatePolicy protects a table with a row-level security policy. Once a
+// table has policies, queries only see the rows at least one of them holds
+// for. The policies are evaluated for every row with the session running
+// the query, so a policy comparing a column with CURRENT_USER lets each
+// user see their own rows of a table shared by all of them. Rows inserted
+// by others than the superuser must pass a policy too, see checkPolicies,
+// and only the superuser may change a protected table, see checkProtected.
+// Only the superuser may create policies, see checkSuperuser.
+func (mb *MemoryBackend) CreatePolicy(crt *parser.CreatePolicyStatement, session *functions.Session) error {
+	if err := checkSuperuser(session, "create policies"); err != nil {
+		return err
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name := crt.Table.Value
+	if isSystemTable(name) {
+		return ErrSystemTable
+	}
+
+	t, ok := mb.tables[name]
+	if !ok {
+		return ErrTableDoesNotExist
+	}
+
+	if t.view != nil {
+		return fmt.Errorf("%w: %s", ErrMaterializedView, name)
+	}
+
+	// The view holds the rows it read before the policy, for everyone
+	if view, ok := mb.readBy(name); ok {
+		return fmt.Errorf("%w: %s reads %s", ErrTableInUse, view, name)
+	}
+
+	for _, p := range t.policies {
+		if p.name == crt.Name.Value {
+			return fmt.Errorf("%w: %s on %s", ErrPolicyExists, p.name, name)
+		}
+	}
+
+	if modifies(&crt.Using) {
+		return errors.New("Policies can't call functions that change the database")
+	}
+
+	protected := *t
+	protected.policies = append(append([]*policy{}, t.policies...), &policy{name: crt.Name.Value, using: &crt.Using})
+	mb.tables[name] = &protected
+	mb.changed(name)
+	return nil
+}
+
+// DropPolicy removes a policy from a table, which stops being protected
+// once it has none left. Only the superuser may drop policies, those they
+// restrict could read every row otherwise.
+func (mb *MemoryBackend) DropPolicy(drop *parser.DropPolicyStatement, session *functions.Session) error {
+	if err := checkSuperuser(session, "drop policies"); err != nil {
+		return err
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name := drop.Table.Value
+	t, ok := mb.tables[name]
+	if !ok {
+		return ErrTableDoesNotExist
+	}
+
+	for i, p := range t.policies {
+		if p.name != drop.Name.Value {
+			continue
+		}
+
+		protected := *t
+		protected.policies = append(append([]*policy{}, t.policies[:i]...), t.policies[i+1:]...)
+		mb.tables[name] = &protected
+		mb.changed(name)
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s on %s", ErrPolicyDoesNotExist, drop.Name.Value, name)
+}
+
+// visibleRows returns t with only the rows its policies let the session of
+// ev see, or t itself when it has none. The policies are evaluated on their
+// own, they can't refer to the columns of queries enclosing the scan.
+func (ev *evaluation) visibleRows(t *memoryTable) (*memoryTable, error) {
+	if len(t.policies) == 0 {
+		return t, nil
+	}
+
+	base := &evaluation{
+		ctx:      ev.ctx,
+		mem:      ev.mem,
+		mb:       ev.mb,
+		session:  ev.session,
+		patterns: map[string]*regexp.Regexp{},
+		plans:    map[*parser.SelectStatement]*subqueryPlan{},
+	}
+
+	rows := []storage.Row{}
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if err := base.canceled(); err != nil {
+			return nil, err
+		}
+
+		scope := base.sub(t, row)
+		for _, p := range t.policies {
+			v, err := scope.eval(p.using)
+			if err != nil {
+				return nil, fmt.Errorf("Policy %s: %w", p.name, err)
+			}
+
+			if keep, _ := v.(bool); keep {
+				if err := ev.mem.Grow(rowRefSize); err != nil {
+					return nil, err
+				}
+				rows = append(rows, row)
+				break
+			}
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	visible := *t
+	visible.store = storage.NewTable(rows...)
+	visible.policies = nil
+	return &visible, nil
+}
+
+// checkPolicies fails with ErrPolicyViolation unless one of the policies
+// of the table t called name holds for the row the session of ev inserts,
+// so no one can add rows they couldn't read back, like rows owned by
+// another user. The superuser isn't checked, as it adds rows for others.
+func (ev *evaluation) checkPolicies(name string, t *memoryTable, row storage.Row) error {
+	if len(t.policies) == 0 || isSuperuser(ev.session) {
+		return nil
+	}
+
+	scope := ev.sub(t, row)
+	for _, p := range t.policies {
+		v, err := scope.eval(p.using)
+		if err != nil {
+			return fmt.Errorf("Policy %s: %w", p.name, err)
+		}
+
+		if keep, _ := v.(bool); keep {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrPolicyViolation, name)
+}
+
+// checkProtected fails with ErrPermissionDenied unless session may change
+// the definition of t, which only the superuser may do once policies or
+// masks protect it. Otherwise anyone could drop rows they can't see with
+// the table, or rename it and its columns from under the policies. what is
+// what was denied, like "drop".
+func checkProtected(session *functions.Session, t *memoryTable, what string) error {
+	if len(t.policies) == 0 && len(t.masks) == 0 {
+		return nil
+	}
+
+	return checkSuperuser(session, what+" tables with policies or masks")
+}
+
+// refersTo reports whether exp refers to a column called column. Columns
+// of the tables its subqueries read count too, which is more than it needs
+// but never less.
+func refersTo(exp *parser.Expression, column string) bool {
+	var query func(slct *parser.SelectStatement) bool
+	var walk func(exp *parser.Expression) bool
+	query = func(slct *parser.SelectStatement) bool {
+		for _, item := range slct.Item {
+			if !item.Asterisk && walk(item.Exp) {
+				return true
+			}
+		}
+
+		for _, order := range slct.OrderBy {
+			if walk(order.Exp) {
+				return true
+			}
+		}
+
+		return (slct.Function != nil && walk(slct.Function)) ||
+			(slct.Where != nil && walk(slct.Where)) ||
+			(slct.ConnectBy != nil && (slct.ConnectBy.Prior.Value == column || slct.ConnectBy.Child.Value == column ||
+				(slct.ConnectBy.Start != nil && walk(slct.ConnectBy.Start))))
+	}
+	walk = func(exp *parser.Expression) bool {
+		switch exp.Type {
+		case parser.ColumnRefType:
+			return exp.Column.Value == column
+		case parser.BinaryType:
+			return walk(&exp.Binary.A) || walk(&exp.Binary.B)
+		case parser.CastType:
+			return walk(&exp.Cast.Exp)
+		case parser.IndexType:
+			return walk(&exp.Index.Exp) || walk(&exp.Index.Index)
+		case parser.CallType:
+			for i := range exp.Call.Args {
+				if walk(&exp.Call.Args[i]) {
+					return true
+				}
+			}
+		case parser.ArrayType:
+			for i := range exp.Array {
+				if walk(&exp.Array[i]) {
+					return true
+				}
+			}
+		case parser.RowType:
+			for i := range exp.Row {
+				if walk(&exp.Row[i]) {
+					return true
+				}
+			}
+		case parser.InType:
+			if walk(&exp.In.Exp) {
+				return true
+			}
+			for i := range exp.In.List {
+				if walk(&exp.In.List[i]) {
+					return true
+				}
+			}
+		case parser.NotType:
+			return walk(exp.Not)
+		case parser.ExistsType:
+			return query(exp.Exists.Query)
+		case parser.SubqueryType:
+			return query(exp.Subquery)
+		}
+
+		return false
+	}
+
+	return walk(exp)
+}
+
+// dumpPolicies returns the statements recreating the policies of the table
+// t called name.
+func dumpPolicies(name string, t *memoryTable) []DumpStatement {
+	stmts := make([]DumpStatement, len(t.policies))
+	for i, p := range t.policies {
+		stmts[i] = DumpStatement{
+			Query: "CREATE POLICY " + parser.FormatIdentifier(p.name) + " ON " + parser.FormatIdentifier(name) +
+				" USING (" + p.using.String() + ")",
+		}
+	}
+
+	return stmts
+}
@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"github.com/nireo/sgsql/parser"
+)
+
+// project returns a view of t with only the columns slct reads, from its
+// items, its WHERE, its ORDER BY and the subqueries in them. The view shares
+// its rows with t, so scanning it doesn't copy anything, but expressions
+// only resolve the columns they need instead of searching every column of a
+// wide table. t itself is returned when slct reads all of its columns.
+func (mb *MemoryBackend) project(t *memoryTable, slct *parser.SelectStatement) *memoryTable {
+	need := map[string]bool{}
+	for _, item := range slct.Item {
+		if item.Asterisk {
+			return t
+		}
+
+		mb.reads(item.Exp, nil, need)
+	}
+
+	if slct.Where != nil {
+		mb.reads(slct.Where, nil, need)
+	}
+
+	for _, order := range slct.OrderBy {
+		mb.reads(order.Exp, nil, need)
+	}
+
+	view := &memoryTable{store: t.store, positions: []int{}}
+	for i, col := range t.columns {
+		if !need[col] {
+			continue
+		}
+
+		view.columns = append(view.columns, col)
+		view.columnTypes = append(view.columnTypes, t.columnTypes[i])
+		view.collations = append(view.collations, t.collations[i])
+		view.positions = append(view.positions, t.position(i))
+	}
+
+	if len(view.columns) == len(t.columns) {
+		return t
+	}
+
+	return view
+}
+
+// reads adds the names of the columns exp refers to to need, leaving out
+// the ones resolving to the tables of the subqueries in inner.
+func (mb *MemoryBackend) reads(exp *parser.Expression, inner []*memoryTable, need map[string]bool) {
+	if exp == nil {
+		return
+	}
+
+	query := func(slct *parser.SelectStatement) {
+		scopes := inner[:len(inner):len(inner)]
+		if slct.From != nil {
+			if t, ok := mb.table(slct.From.Value); ok {
+				scopes = append(scopes, t)
+			}
+		}
+
+		for _, item := range slct.Item {
+			if !item.Asterisk {
+				mb.reads(item.Exp, scopes, need)
+			}
+		}
+		mb.reads(slct.Where, scopes, need)
+		for _, order := range slct.OrderBy {
+			mb.reads(order.Exp, scopes, need)
+		}
+		mb.reads(slct.Limit, scopes, need)
+		mb.reads(slct.Offset, scopes, need)
+	}
+
+	switch exp.Type {
+	case parser.ColumnRefType:
+		for _, t := range inner {
+			if _, ok := t.columnIndex(exp.Column.Value); ok {
+				return
+			}
+		}
+		need[exp.Column.Value] = true
+	case parser.BinaryType:
+		mb.reads(&exp.Binary.A, inner, need)
+		mb.reads(&exp.Binary.B, inner, need)
+	case parser.CastType:
+		mb.reads(&exp.Cast.Exp, inner, need)
+	case parser.IndexType:
+		mb.reads(&exp.Index.Exp, inner, need)
+		mb.reads(&exp.Index.Index, inner, need)
+	case parser.CallType:
+		for i := range exp.Call.Args {
+			mb.reads(&exp.Call.Args[i], inner, need)
+		}
+	case parser.ArrayType:
+		for i := range exp.Array {
+			mb.reads(&exp.Array[i], inner, need)
+		}
+	case parser.RowType:
+		for i := range exp.Row {
+			mb.reads(&exp.Row[i], inner, need)
+		}
+	case parser.InType:
+		mb.reads(&exp.In.Exp, inner, need)
+		for i := range exp.In.List {
+			mb.reads(&exp.In.List[i], inner, need)
+		}
+	case parser.NotType:
+		mb.reads(exp.Not, inner, need)
+	case parser.ExistsType:
+		query(exp.Exists.Query)
+	case parser.SubqueryType:
+		query(exp.Subquery)
+	}
+}
@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProjection(t *testing.T) {
+	tests := []struct {
+		query string
+		// scan is what EXPLAIN says the query scans
+		scan string
+		rows [][]interface{}
+	}{
+		{"select name from t where id = 2", "Scan: t (id, name)", [][]interface{}{{"b"}}},
+		{"select score from t where score > 2", "Scan: t (score)", [][]interface{}{{4.5}}},
+		{"select * from t where id = 3", "Scan: t (id, name, score)", [][]interface{}{{int64(3), "c", 4.5}}},
+		{"select count(*) from t", "Scan: t", [][]interface{}{{int64(3)}}},
+		{"select id, name, score from t where id = 1", "Scan: t (id, name, score)", [][]interface{}{{int64(1), "a", 1.5}}},
+		{"select name from t where id = (select max(id) from t)", "Scan: t (id, name)", [][]interface{}{{"c"}}},
+		{"select name from t where id = (select max(id) from t)", "Run once: t (id)", [][]interface{}{{"c"}}},
+		{"select id from t where exists (select 1 from u where tid = id and label = name)", "Scan: t (id, name)", [][]interface{}{{int64(1)}}},
+		{"select id from t where exists (select 1 from u where tid = id and label = name)", "Hash semi-join: u (tid, label) on tid = id and label = name", [][]interface{}{{int64(1)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query+" "+tt.scan, func(t *testing.T) {
+			mb, session := testBackend(t)
+			for _, query := range []string{
+				"create table u (tid int, label text, extra text)",
+				"insert into u values (1, 'a', 'x')",
+				"insert into u values (2, 'c', 'y')",
+			} {
+				if _, err := run(mb, session, query); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			results, err := run(mb, session, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+
+			plan, err := run(mb, session, "explain "+tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !explainsLine(plan.Rows, tt.scan) {
+				t.Errorf("explained as %v, want %q", plan.Rows, tt.scan)
+			}
+		})
+	}
+}
+
+// explainsLine tells whether a line of the plan is text apart from its
+// indentation.
+func explainsLine(lines [][]interface{}, text string) bool {
+	for _, line := range lines {
+		if s, ok := line[0].(string); ok && strings.TrimSpace(s) == text {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,516 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+// renameTable renames the table, materialized view or external table
+// called name to to. Engines can't rename their tables, so the rows are
+// copied into a new one as a job listed in __jobs, like ALTER TABLE copies
+// them. The views and policies reading the table are rewritten to read it
+// by its new name, and the foreign keys referencing it to reference it so.
+func (mb *MemoryBackend) renameTable(ctx context.Context, name, to string) error {
+	t, err := mb.prepareRename(name, to)
+	if err != nil {
+		return err
+	}
+
+	var rows []storage.Row
+	if t.external == nil {
+		if rows, err = mb.copyRows(ctx, "ALTER TABLE", name, t, func(row storage.Row) (storage.Row, error) {
+			return row, nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.tables[name] != t {
+		return fmt.Errorf("Table %s changed while it was being renamed", name)
+	}
+	if _, ok := mb.tables[to]; ok {
+		return fmt.Errorf("%w: %s", ErrTableAlreadyExists, to)
+	}
+
+	r := &renamer{mb: mb, table: name, to: to}
+	rewritten, err := r.dependents()
+	if err != nil {
+		return err
+	}
+
+	renamed := *t
+	if changed, ok := rewritten[name]; ok {
+		renamed.policies, renamed.foreignKeys = changed.policies, changed.foreignKeys
+		delete(rewritten, name)
+	}
+
+	if t.external == nil {
+		store, err := mb.createStore(to, t)
+		if err != nil {
+			return err
+		}
+		if err := store.Insert(rows...); err != nil {
+			return err
+		}
+		if err := mb.engine.DropTable(name); err != nil {
+			return err
+		}
+
+		renamed.store = store
+		if renamed.indexes, err = reindex(t, &renamed); err != nil {
+			return err
+		}
+	}
+
+	for table, changed := range rewritten {
+		mb.tables[table] = changed
+		mb.changed(table)
+	}
+	delete(mb.tables, name)
+	mb.tables[to] = &renamed
+	mb.changed(name)
+	mb.changed(to)
+	return nil
+}
+
+// prepareRename checks that the table called name can be renamed to to and
+// returns it.
+func (mb *MemoryBackend) prepareRename(name, to string) (*memoryTable, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	if isSystemTable(name) || isSystemTable(to) {
+		return nil, ErrSystemTable
+	}
+
+	t, ok := mb.tables[name]
+	if !ok {
+		return nil, ErrTableDoesNotExist
+	}
+
+	if _, ok := mb.tables[to]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableAlreadyExists, to)
+	}
+
+	return t, nil
+}
+
+// renameColumn renames a column of t, the table called name, along with
+// the indexes, statistics, masks, comments and foreign keys over it. The views and
+// policies reading it are rewritten to read it by its new name, views keep
+// calling it by its old one. The rows hold the values of the columns in
+// order, so they don't change. It must be called with mb.mu held for writing.
+func (mb *MemoryBackend) renameColumn(name string, t *memoryTable, rename *parser.Rename) error {
+	column, to := rename.Column.Value, rename.To.Value
+	i, ok := t.columnIndex(column)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrColumnDoesNotExist, column)
+	}
+	if _, ok := t.columnIndex(to); ok {
+		return fmt.Errorf("Column %s already exists", to)
+	}
+
+	r := &renamer{mb: mb, table: name, column: column, to: to}
+	rewritten, err := r.dependents()
+	if err != nil {
+		return err
+	}
+
+	renamed := *t
+	renamed.columns = append([]string{}, t.columns...)
+	renamed.columns[i] = to
+	if changed, ok := rewritten[name]; ok {
+		renamed.policies, renamed.foreignKeys = changed.policies, changed.foreignKeys
+		delete(rewritten, name)
+	}
+
+	if t.masks[column] != nil {
+		renamed.masks = map[string]*columnMask{}
+		for col, m := range t.masks {
+			renamed.masks[r.rename(col)] = m
+		}
+	}
+
+	if _, ok := t.comments[column]; ok {
+		renamed.comments = map[string]string{}
+		for col, c := range t.comments {
+			renamed.comments[r.rename(col)] = c
+		}
+	}
+
+	if t.stats != nil {
+		stats := *t.stats
+		stats.distinct, stats.nulls = map[string]int64{}, map[string]int64{}
+		for col, n := range t.stats.distinct {
+			stats.distinct[r.rename(col)] = n
+		}
+		for col, n := range t.stats.nulls {
+			stats.nulls[r.rename(col)] = n
+		}
+		renamed.stats = &stats
+	}
+
+	renamed.uniques = make([]*unique, len(t.uniques))
+	for j, u := range t.uniques {
+		renamed.uniques[j] = &unique{column: r.rename(u.column), deferred: u.deferred}
+	}
+
+	renamed.statistics = make([]*extendedStats, len(t.statistics))
+	for j, s := range t.statistics {
+		changed := *s
+		changed.columns = make([]string, len(s.columns))
+		for k, col := range s.columns {
+			changed.columns[k] = r.rename(col)
+		}
+		if s.data != nil {
+			data := *s.data
+			data.dependencies = make([]dependency, len(s.data.dependencies))
+			for k, d := range s.data.dependencies {
+				data.dependencies[k] = dependency{from: r.rename(d.from), to: r.rename(d.to), degree: d.degree}
+			}
+			changed.data = &data
+		}
+		renamed.statistics[j] = &changed
+	}
+
+	// The indexes are replaced like DROP INDEX and CREATE INDEX would, so
+	// rolling back brings the old ones back
+	renamed.indexes = make([]*index, len(t.indexes))
+	for j, old := range t.indexes {
+		idx := &index{
+			name:       old.name,
+			column:     old.column,
+			columnType: old.columnType,
+			position:   old.position,
+			table:      &renamed,
+			store:      old.store,
+			live:       true,
+		}
+		if old.expression == nil {
+			idx.column = r.rename(old.column)
+		} else if idx.expression, err = r.expression(old.expression, []string{name}); err != nil {
+			return err
+		}
+		if old.where != nil {
+			if idx.where, err = r.expression(old.where, []string{name}); err != nil {
+				return err
+			}
+		}
+
+		if err := idx.rebuild(); err != nil {
+			return err
+		}
+		idx.store.AddIndexHook(idx)
+		old.live = false
+		renamed.indexes[j] = idx
+	}
+
+	for table, changed := range rewritten {
+		mb.tables[table] = changed
+		mb.changed(table)
+	}
+	mb.tables[name] = &renamed
+	mb.changed(name)
+	return nil
+}
+
+// renamer rewrites queries and expressions for a table being renamed to
+// to, or for its column being renamed to to when column is set. References
+// to other tables and columns, even of the same name, are left alone.
+type renamer struct {
+	mb                *MemoryBackend
+	table, column, to string
+}
+
+// rename returns the name column has after the rename.
+func (r *renamer) rename(column string) string {
+	if column == r.column {
+		return r.to
+	}
+
+	return column
+}
+
+// dependents returns the tables whose materialized view, policies or foreign
+// keys read what is being renamed, with them rewritten to read it by its
+// new name.
+// Rewritten views are refreshed from scratch the next time. It must be
+// called with mb.mu held.
+func (r *renamer) dependents() (map[string]*memoryTable, error) {
+	rewritten := map[string]*memoryTable{}
+	for _, name := range r.mb.tableNames() {
+		t := r.mb.tables[name]
+		var changed *memoryTable
+
+		if t.view != nil && name != r.table && contains(t.view.reads, r.table) {
+			query, err := r.view(t.view.query)
+			if err != nil {
+				return nil, err
+			}
+
+			reads := t.view.reads
+			if r.column == "" {
+				reads = make([]string, len(t.view.reads))
+				for i, read := range t.view.reads {
+					if read == r.table {
+						read = r.to
+					}
+					reads[i] = read
+				}
+			}
+
+			copied := *t
+			copied.view = &materializedView{query: query, reads: reads}
+			changed = &copied
+		}
+
+		policies := make([]*policy, len(t.policies))
+		rewrote := false
+		for i, p := range t.policies {
+			using, err := r.expression(p.using, []string{name})
+			if err != nil {
+				return nil, err
+			}
+
+			policies[i] = p
+			if using.String() != p.using.String() {
+				policies[i], rewrote = &policy{name: p.name, using: using}, true
+			}
+		}
+		if rewrote {
+			if changed == nil {
+				copied := *t
+				changed = &copied
+			}
+			changed.policies = policies
+		}
+
+		if fks, ok := r.foreignKeys(name, t.foreignKeys); ok {
+			if changed == nil {
+				copied := *t
+				changed = &copied
+			}
+			changed.foreignKeys = fks
+		}
+
+		if changed != nil {
+			rewritten[name] = changed
+		}
+	}
+
+	return rewritten, nil
+}
+
+// foreignKeys returns fks, the foreign keys of the table called name, with
+// the column renamed or the table they reference renamed, and false when
+// the rename doesn't change any of them.
+func (r *renamer) foreignKeys(name string, fks []*foreignKey) ([]*foreignKey, bool) {
+	renamed := make([]*foreignKey, len(fks))
+	rewrote := false
+	for i, fk := range fks {
+		changed := *fk
+		if name == r.table {
+			changed.column = r.rename(fk.column)
+		}
+		if fk.table == r.table {
+			if r.column == "" {
+				changed.table = r.to
+			} else {
+				changed.references = r.rename(fk.references)
+			}
+		}
+
+		renamed[i] = fk
+		if changed != *fk {
+			renamed[i], rewrote = &changed, true
+		}
+	}
+
+	return renamed, rewrote
+}
+
+// view returns a copy of the query of a materialized view rewritten for
+// the rename. The columns of the view keep their names: a column renamed
+// is selected as its old name, and * over the table is spelled out.
+func (r *renamer) view(slct *parser.SelectStatement) (*parser.SelectStatement, error) {
+	query, err := copyQuery(slct)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.column != "" && query.From != nil && query.Function == nil {
+		items := []*parser.SelectItem{}
+		for _, item := range query.Item {
+			if item.Asterisk && query.From.Value == r.table {
+				for _, col := range r.mb.tables[r.table].columns {
+					items = append(items, &parser.SelectItem{
+						Exp: &parser.Expression{Type: parser.ColumnRefType, Column: &parser.Token{Type: parser.IdentifierType, Value: col}},
+					})
+				}
+				continue
+			}
+
+			items = append(items, item)
+		}
+		query.Item = items
+
+		scopes := []string{query.From.Value}
+		for _, item := range query.Item {
+			if !item.Asterisk && item.As == nil && item.Exp.Type == parser.ColumnRefType &&
+				item.Exp.Column.Value == r.column && r.resolve(r.column, scopes) == r.table {
+				as := *item.Exp.Column
+				item.As = &as
+			}
+		}
+	}
+
+	r.query(query, nil)
+	return query, nil
+}
+
+// expression returns a copy of exp, evaluated over the tables of scopes
+// with the innermost last, rewritten for the rename.
+func (r *renamer) expression(exp *parser.Expression, scopes []string) (*parser.Expression, error) {
+	copied, err := copyExpression(exp)
+	if err != nil {
+		return nil, err
+	}
+
+	r.walk(copied, scopes)
+	return copied, nil
+}
+
+func (r *renamer) query(slct *parser.SelectStatement, outer []string) {
+	scopes := append(append([]string{}, outer...), "")
+	if slct.From != nil {
+		scopes[len(scopes)-1] = slct.From.Value
+	}
+
+	if r.column == "" {
+		if slct.From != nil && slct.From.Value == r.table {
+			slct.From.Value = r.to
+		}
+		for i := range slct.Hints {
+			for j := range slct.Hints[i].Args {
+				if slct.Hints[i].Args[j].Value == r.table {
+					slct.Hints[i].Args[j].Value = r.to
+				}
+			}
+		}
+	} else if slct.ConnectBy != nil && slct.From != nil && slct.From.Value == r.table {
+		slct.ConnectBy.Prior.Value = r.rename(slct.ConnectBy.Prior.Value)
+		slct.ConnectBy.Child.Value = r.rename(slct.ConnectBy.Child.Value)
+	}
+
+	for _, item := range slct.Item {
+		if !item.Asterisk {
+			r.walk(item.Exp, scopes)
+		}
+	}
+	for _, exp := range []*parser.Expression{slct.Function, slct.AsOf, slct.Where, slct.Limit, slct.Offset} {
+		if exp != nil {
+			r.walk(exp, scopes)
+		}
+	}
+	if slct.ConnectBy != nil && slct.ConnectBy.Start != nil {
+		r.walk(slct.ConnectBy.Start, scopes)
+	}
+	for _, order := range slct.OrderBy {
+		r.walk(order.Exp, scopes)
+	}
+}
+
+func (r *renamer) walk(exp *parser.Expression, scopes []string) {
+	switch exp.Type {
+	case parser.ColumnRefType:
+		if r.column != "" && exp.Column.Value == r.column && r.resolve(exp.Column.Value, scopes) == r.table {
+			exp.Column.Value = r.to
+		}
+	case parser.BinaryType:
+		r.walk(&exp.Binary.A, scopes)
+		r.walk(&exp.Binary.B, scopes)
+	case parser.CastType:
+		r.walk(&exp.Cast.Exp, scopes)
+	case parser.IndexType:
+		r.walk(&exp.Index.Exp, scopes)
+		r.walk(&exp.Index.Index, scopes)
+	case parser.CallType:
+		for i := range exp.Call.Args {
+			r.walk(&exp.Call.Args[i], scopes)
+		}
+	case parser.ArrayType:
+		for i := range exp.Array {
+			r.walk(&exp.Array[i], scopes)
+		}
+	case parser.RowType:
+		for i := range exp.Row {
+			r.walk(&exp.Row[i], scopes)
+		}
+	case parser.InType:
+		r.walk(&exp.In.Exp, scopes)
+		for i := range exp.In.List {
+			r.walk(&exp.In.List[i], scopes)
+		}
+	case parser.NotType:
+		r.walk(exp.Not, scopes)
+	case parser.ExistsType:
+		r.query(exp.Exists.Query, scopes)
+	case parser.SubqueryType:
+		r.query(exp.Subquery, scopes)
+	}
+}
+
+// resolve returns the table of scopes a reference to column resolves to,
+// the innermost one with such a column.
+func (r *renamer) resolve(column string, scopes []string) string {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		if t, ok := r.mb.tables[scopes[i]]; ok {
+			if _, ok := t.columnIndex(column); ok {
+				return scopes[i]
+			}
+		}
+	}
+
+	return ""
+}
+
+// copyQuery returns a copy of slct that can be changed without changing
+// slct, parsed back from its text like a dump would be.
+func copyQuery(slct *parser.SelectStatement) (*parser.SelectStatement, error) {
+	ast, err := parser.Parse(slct.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ast.Statements) != 1 || ast.Statements[0].Type != parser.SelectType {
+		return nil, fmt.Errorf("Query %s can't be copied", slct)
+	}
+
+	return ast.Statements[0].SelectStatement, nil
+}
+
+// copyExpression is copyQuery for an expression.
+func copyExpression(exp *parser.Expression) (*parser.Expression, error) {
+	query, err := copyQuery(&parser.SelectStatement{Item: []*parser.SelectItem{{Exp: exp}}})
+	if err != nil {
+		return nil, err
+	}
+
+	return query.Item[0].Exp, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
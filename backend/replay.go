@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+var ErrUnreplayable = errors.New("Statements changing the database can't read what replaying the statement log wouldn't read again")
+
+// CheckReplayable returns an error when stmt, which is written to the
+// statement log, reads rows that replaying the log wouldn't read again.
+// The log keeps the text of the statement rather than what it read, so a
+// table read AS OF TIMESTAMP, whose history isn't replayed, would fail or
+// be read differently when the database is opened again, the file of an
+// external table can change or be unreadable by then and the rows of system
+// tables, like the sessions of __sessions, aren't the same.
+func (mb *MemoryBackend) CheckReplayable(ctx context.Context, stmt *parser.Statement) error {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	check := func(slct *parser.SelectStatement) error {
+		return eachQuery(slct, func(slct *parser.SelectStatement) error {
+			if slct.From == nil {
+				return nil
+			}
+
+			if slct.AsOf != nil {
+				return fmt.Errorf("%w: %s is read AS OF TIMESTAMP", ErrUnreplayable, slct.From.Value)
+			}
+			if isSystemTable(slct.From.Value) {
+				return fmt.Errorf("%w: %s is a system table", ErrUnreplayable, slct.From.Value)
+			}
+			if mb.readsExternal([]string{slct.From.Value}) {
+				return fmt.Errorf("%w: %s is an external table", ErrUnreplayable, slct.From.Value)
+			}
+			return nil
+		})
+	}
+
+	switch stmt.Type {
+	case parser.InsertType:
+		for _, exp := range *stmt.InsertStatement.Values {
+			if err := eachSubquery(exp, check); err != nil {
+				return err
+			}
+		}
+	case parser.CreateMaterializedViewType:
+		return check(stmt.CreateMaterializedViewStatement.Query)
+	}
+
+	return nil
+}
+
+// eachQuery calls fn with slct and every query nested in it, stopping at
+// the first error.
+func eachQuery(slct *parser.SelectStatement, fn func(slct *parser.SelectStatement) error) error {
+	if err := fn(slct); err != nil {
+		return err
+	}
+
+	nested := func(slct *parser.SelectStatement) error { return eachQuery(slct, fn) }
+	for _, item := range slct.Item {
+		if err := eachSubquery(item.Exp, nested); err != nil {
+			return err
+		}
+	}
+
+	exps := []*parser.Expression{slct.Function, slct.AsOf, slct.Where, slct.Limit, slct.Offset}
+	if slct.ConnectBy != nil {
+		exps = append(exps, slct.ConnectBy.Start)
+	}
+	for _, order := range slct.OrderBy {
+		exps = append(exps, order.Exp)
+	}
+	for _, exp := range exps {
+		if err := eachSubquery(exp, nested); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eachSubquery calls fn with the queries of exp that aren't nested in
+// another query, stopping at the first error.
+func eachSubquery(exp *parser.Expression, fn func(slct *parser.SelectStatement) error) error {
+	if exp == nil {
+		return nil
+	}
+
+	switch exp.Type {
+	case parser.BinaryType:
+		if err := eachSubquery(&exp.Binary.A, fn); err != nil {
+			return err
+		}
+		return eachSubquery(&exp.Binary.B, fn)
+	case parser.CastType:
+		return eachSubquery(&exp.Cast.Exp, fn)
+	case parser.IndexType:
+		if err := eachSubquery(&exp.Index.Exp, fn); err != nil {
+			return err
+		}
+		return eachSubquery(&exp.Index.Index, fn)
+	case parser.CallType:
+		for i := range exp.Call.Args {
+			if err := eachSubquery(&exp.Call.Args[i], fn); err != nil {
+				return err
+			}
+		}
+	case parser.ArrayType:
+		for i := range exp.Array {
+			if err := eachSubquery(&exp.Array[i], fn); err != nil {
+				return err
+			}
+		}
+	case parser.RowType:
+		for i := range exp.Row {
+			if err := eachSubquery(&exp.Row[i], fn); err != nil {
+				return err
+			}
+		}
+	case parser.InType:
+		if err := eachSubquery(&exp.In.Exp, fn); err != nil {
+			return err
+		}
+		for i := range exp.In.List {
+			if err := eachSubquery(&exp.In.List[i], fn); err != nil {
+				return err
+			}
+		}
+	case parser.NotType:
+		return eachSubquery(exp.Not, fn)
+	case parser.ExistsType:
+		return fn(exp.Exists.Query)
+	case parser.SubqueryType:
+		return fn(exp.Subquery)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrRoleExists       = errors.New("Role already exists")
+	ErrRoleDoesNotExist = errors.New("Role does not exist")
+	ErrPermissionDenied = errors.New("Permission denied")
+)
+
+// checkSuperuser fails with ErrPermissionDenied unless session runs as
+// functions.DefaultUser, the superuser owning the database with its roles,
+// privileges and policies, or is privileged. Tables have no owners of their
+// own. what is what was denied, like "grant roles".
+func checkSuperuser(session *functions.Session, what string) error {
+	if isSuperuser(session) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: only %s may %s", ErrPermissionDenied, functions.DefaultUser, what)
+}
+
+// isSuperuser reports whether session passes checkSuperuser.
+func isSuperuser(session *functions.Session) bool {
+	return session.User() == functions.DefaultUser || session.Privileged()
+}
+
+// CreateRole creates a role. Granting the role to users or other roles
+// makes them its members, and members have every privilege granted to the
+// role, including those it has as a member of other roles in turn. Only the
+// superuser may create roles, see checkSuperuser.
+func (mb *MemoryBackend) CreateRole(crt *parser.CreateRoleStatement, session *functions.Session) error {
+	if err := checkSuperuser(session, "create roles"); err != nil {
+		return err
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name := crt.Name.Value
+	if name == UnmaskPrivilege {
+		return fmt.Errorf("Role can't be called %s, it is a privilege", name)
+	}
+
+	if mb.roles[name] {
+		return fmt.Errorf("%w: %s", ErrRoleExists, name)
+	}
+
+	roles := map[string]bool{name: true}
+	for role := range mb.roles {
+		roles[role] = true
+	}
+
+	mb.roles = roles
+	return nil
+}
+
+// DropRole removes a role along with its memberships and the privileges
+// granted to it, so its members lose the privileges they had through it.
+// Only the superuser may drop roles.
+func (mb *MemoryBackend) DropRole(drop *parser.DropRoleStatement, session *functions.Session) error {
+	if err := checkSuperuser(session, "drop roles"); err != nil {
+		return err
+	}
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name := drop.Name.Value
+	if !mb.roles[name] {
+		return fmt.Errorf("%w: %s", ErrRoleDoesNotExist, name)
+	}
+
+	roles := map[string]bool{}
+	for role := range mb.roles {
+		if role != name {
+			roles[role] = true
+		}
+	}
+
+	memberships := map[string][]string{}
+	for member, granted := range mb.memberships {
+		if member == name {
+			continue
+		}
+
+		kept := without(granted, name)
+		if len(kept) > 0 {
+			memberships[member] = kept
+		}
+	}
+
+	unmasked := map[string]bool{}
+	for user := range mb.unmasked {
+		if user != name {
+			unmasked[user] = true
+		}
+	}
+
+	mb.roles, mb.memberships, mb.unmasked = roles, memberships, unmasked
+	return nil
+}
+
+// grantRole makes the user or role grant names a member of the role it
+// grants, or no longer one for REVOKE. A role can't become a member of
+// itself, not even through other roles. It must be called with mb.mu held
+// for writing.
+func (mb *MemoryBackend) grantRole(grant *parser.GrantStatement) error {
+	role, member := grant.Privilege.Value, grant.User.Value
+	if !mb.roles[role] {
+		return fmt.Errorf("%w: %s", ErrNoSuchPrivilege, role)
+	}
+
+	granted := mb.memberships[member]
+	if grant.Revoke {
+		granted = without(granted, role)
+	} else {
+		for _, r := range mb.principals(role) {
+			if r == member {
+				return fmt.Errorf("Role %s can't be granted to %s, %s would become a member of itself", role, member, member)
+			}
+		}
+
+		granted = append(without(granted, role), role)
+		sort.Strings(granted)
+	}
+
+	memberships := map[string][]string{}
+	for m, roles := range mb.memberships {
+		memberships[m] = roles
+	}
+	if len(granted) > 0 {
+		memberships[member] = granted
+	} else {
+		delete(memberships, member)
+	}
+
+	mb.memberships = memberships
+	return nil
+}
+
+// principals returns user followed by every role they are a member of,
+// directly or through other roles. It must be called with mb.mu held.
+func (mb *MemoryBackend) principals(user string) []string {
+	seen := map[string]bool{user: true}
+	principals := []string{user}
+	for i := 0; i < len(principals); i++ {
+		for _, role := range mb.memberships[principals[i]] {
+			if !seen[role] {
+				seen[role] = true
+				principals = append(principals, role)
+			}
+		}
+	}
+
+	return principals
+}
+
+// canUnmask reports whether user has the UNMASK privilege, granted to them
+// or to a role they are a member of. It must be called with mb.mu held.
+func (mb *MemoryBackend) canUnmask(user string) bool {
+	for _, p := range mb.principals(user) {
+		if mb.unmasked[p] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// roleRows returns the rows of __roles. It must be called with mb.mu held.
+func (mb *MemoryBackend) roleRows() [][]interface{} {
+	members := map[string][]interface{}{}
+	for member, roles := range mb.memberships {
+		for _, role := range roles {
+			members[role] = append(members[role], member)
+		}
+	}
+
+	names := make([]string, 0, len(mb.roles))
+	for role := range mb.roles {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+
+	rows := make([][]interface{}, len(names))
+	for i, role := range names {
+		values := append([]interface{}{}, members[role]...)
+		sort.Slice(values, func(a, b int) bool {
+			return values[a].(string) < values[b].(string)
+		})
+		rows[i] = []interface{}{role, types.Array{Elem: TextType, Values: values}}
+	}
+
+	return rows
+}
+
+// dumpRoles returns the statements creating the roles of mb and granting
+// them again. It must be called with mb.mu held.
+func (mb *MemoryBackend) dumpRoles() []DumpStatement {
+	names := make([]string, 0, len(mb.roles))
+	for role := range mb.roles {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+
+	stmts := []DumpStatement{}
+	for _, role := range names {
+		stmts = append(stmts, DumpStatement{Query: "CREATE ROLE " + parser.FormatIdentifier(role)})
+	}
+
+	members := make([]string, 0, len(mb.memberships))
+	for member := range mb.memberships {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	for _, member := range members {
+		for _, role := range mb.memberships[member] {
+			stmts = append(stmts, DumpStatement{
+				Query: "GRANT " + parser.FormatIdentifier(role) + " TO " + parser.FormatIdentifier(member),
+			})
+		}
+	}
+
+	return stmts
+}
+
+// without returns names without name, leaving names as it is.
+func without(names []string, name string) []string {
+	kept := []string{}
+	for _, n := range names {
+		if n != name {
+			kept = append(kept, n)
+		}
+	}
+
+	return kept
+}
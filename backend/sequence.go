@@ -0,0 +1,151 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrSequenceDoesNotExist  = errors.New("Sequence does not exist")
+	ErrSequenceAlreadyExists = errors.New("Sequence already exists")
+)
+
+// SequenceValue is the last value of a sequence.
+type SequenceValue struct {
+	Name  string
+	Value int64
+}
+
+// memorySequence is a counter handed out by NEXTVAL. Values are reserved
+// cache at a time, and only reservations need to be logged, so a restarted
+// database continues after the last reserved value instead of the last one
+// handed out.
+type memorySequence struct {
+	increment int64
+	cache     int64
+	// last is the last value handed out, or the first one to hand out
+	// while called is false
+	last     int64
+	called   bool
+	reserved int64
+}
+
+// next returns the value after the last one handed out.
+func (seq *memorySequence) next() (int64, bool) {
+	if !seq.called {
+		return seq.last, true
+	}
+
+	v := seq.last + seq.increment
+	if (v > seq.last) != (seq.increment > 0) {
+		return 0, false
+	}
+
+	return v, true
+}
+
+// covered reports whether v has already been reserved.
+func (seq *memorySequence) covered(v int64) bool {
+	if !seq.called {
+		return false
+	}
+
+	if seq.increment > 0 {
+		return v <= seq.reserved
+	}
+
+	return v >= seq.reserved
+}
+
+func (mb *MemoryBackend) CreateSequence(crt *parser.CreateSequenceStatement) error {
+	mb.seqMu.Lock()
+	defer mb.seqMu.Unlock()
+
+	if _, ok := mb.sequences[crt.Name.Value]; ok {
+		return ErrSequenceAlreadyExists
+	}
+
+	seq := memorySequence{increment: crt.Increment, cache: crt.Cache, last: 1}
+	if crt.Increment < 0 {
+		seq.last = -1
+	}
+	if crt.Start != nil {
+		seq.last = *crt.Start
+	}
+
+	mb.sequences[crt.Name.Value] = &seq
+	return nil
+}
+
+// NextValue advances the sequence name, reserving the next cache values
+// once the reserved ones have run out.
+func (mb *MemoryBackend) NextValue(name string) (int64, error) {
+	mb.seqMu.Lock()
+	defer mb.seqMu.Unlock()
+
+	seq, ok := mb.sequences[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrSequenceDoesNotExist, name)
+	}
+
+	v, ok := seq.next()
+	if !ok {
+		return 0, fmt.Errorf("%w: sequence %s has no more values", types.ErrOutOfRange, name)
+	}
+
+	if !seq.covered(v) {
+		seq.reserved = v
+		for i := int64(1); i < seq.cache; i++ {
+			r := seq.reserved + seq.increment
+			if (r > seq.reserved) != (seq.increment > 0) {
+				break
+			}
+			seq.reserved = r
+		}
+		mb.seqLog[name] = seq.reserved
+	}
+
+	seq.last, seq.called = v, true
+	return v, nil
+}
+
+// SetValue makes v the last value the sequence name handed out.
+func (mb *MemoryBackend) SetValue(name string, v int64) error {
+	mb.seqMu.Lock()
+	defer mb.seqMu.Unlock()
+
+	seq, ok := mb.sequences[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSequenceDoesNotExist, name)
+	}
+
+	seq.last, seq.called, seq.reserved = v, true, v
+	mb.seqLog[name] = v
+	return nil
+}
+
+// TakeSequenceLog returns the values sequences must be restored to for
+// none of the values they have reserved to be handed out again, and forgets
+// them. Sequences that no longer exist are left out.
+func (mb *MemoryBackend) TakeSequenceLog() []SequenceValue {
+	mb.seqMu.Lock()
+	defer mb.seqMu.Unlock()
+
+	values := []SequenceValue{}
+	for name, v := range mb.seqLog {
+		if _, ok := mb.sequences[name]; ok {
+			values = append(values, SequenceValue{Name: name, Value: v})
+		}
+	}
+	mb.seqLog = map[string]int64{}
+
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+
+	return values
+}
@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/types"
+)
+
+func TestSequences(t *testing.T) {
+	mb := NewMemoryBackend()
+	session := functions.NewSession(mb)
+	if _, err := run(mb, session, `create sequence up;
+		create sequence down increment by -2;
+		create sequence big start with 9223372036854775806 increment 1;
+		create sequence cached start 10 increment by 5 cache 3`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want []int64
+	}{
+		{"up", []int64{1, 2, 3}},
+		{"down", []int64{-1, -3, -5}},
+		{"big", []int64{9223372036854775806, 9223372036854775807}},
+		{"cached", []int64{10, 15, 20, 25}},
+	}
+	for _, tt := range tests {
+		got := []int64{}
+		for range tt.want {
+			v, err := mb.NextValue(tt.name)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			got = append(got, v)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := mb.NextValue("big"); !errors.Is(err, types.ErrOutOfRange) {
+		t.Errorf("got %v past the largest integer, want %v", err, types.ErrOutOfRange)
+	}
+	if _, err := mb.NextValue("missing"); !errors.Is(err, ErrSequenceDoesNotExist) {
+		t.Errorf("got %v, want %v", err, ErrSequenceDoesNotExist)
+	}
+	if _, err := run(mb, session, "create sequence up"); !errors.Is(err, ErrSequenceAlreadyExists) {
+		t.Errorf("got %v, want %v", err, ErrSequenceAlreadyExists)
+	}
+
+	// Only reservations are logged: cached reserved 10 to 20, then 25 to 35
+	want := []SequenceValue{{"big", 9223372036854775807}, {"cached", 35}, {"down", -5}, {"up", 3}}
+	if got := mb.TakeSequenceLog(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got log %v, want %v", got, want)
+	}
+	if got := mb.TakeSequenceLog(); len(got) != 0 {
+		t.Errorf("got log %v after taking it", got)
+	}
+	if v, _ := mb.NextValue("cached"); v != 30 {
+		t.Errorf("got %d, want 30", v)
+	}
+	if got := mb.TakeSequenceLog(); len(got) != 0 {
+		t.Errorf("got log %v for a reserved value", got)
+	}
+
+	// SETVAL makes a value the last one handed out
+	if err := mb.SetValue("up", 100); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mb.NextValue("up"); v != 101 {
+		t.Errorf("got %d after setval, want 101", v)
+	}
+	if err := mb.SetValue("missing", 1); !errors.Is(err, ErrSequenceDoesNotExist) {
+		t.Errorf("got %v, want %v", err, ErrSequenceDoesNotExist)
+	}
+
+	// The functions go through the session
+	results, err := run(mb, session, "select nextval('up'), currval('up'), setval('down', 7), nextval('down')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{int64(102), int64(102), int64(7), int64(5)}}; !reflect.DeepEqual(results.Rows, want) {
+		t.Errorf("got %v, want %v", results.Rows, want)
+	}
+	if _, err := run(mb, session, "select currval('cached')"); err == nil {
+		t.Error("currval of a sequence the session hasn't advanced succeeded")
+	}
+}
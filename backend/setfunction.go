@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"fmt"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+// functionColumns returns the table a set-returning function called in FROM
+// stands for, without any rows. It has a single column named after the
+// function holding the elements of the set.
+func (mb *MemoryBackend) functionColumns(call *parser.Expression) (*memoryTable, error) {
+	f, ok := functions.Lookup(call.Call.Name.Value)
+	if !ok {
+		return nil, fmt.Errorf("Function %s does not exist", call.Call.Name.Value)
+	}
+	if !f.Set {
+		return nil, fmt.Errorf("Function %s does not return a set, it can't be used in FROM", f.Name)
+	}
+
+	ev := &evaluation{mb: mb, table: &memoryTable{}}
+	return &memoryTable{
+		columns:     []string{f.Name},
+		columnTypes: []ColumnType{ev.columnType(call)},
+		collations:  []*types.Collation{nil},
+		store:       storage.NewTable(),
+	}, nil
+}
+
+// functionTable calls the set-returning function of call and returns a
+// table with a row for each element of the set it returns. The arguments
+// can't refer to columns, so they are evaluated once for the whole query.
+func (ev *evaluation) functionTable(call *parser.Expression) (*memoryTable, error) {
+	t, err := ev.mb.functionColumns(call)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := &evaluation{
+		ctx:      ev.ctx,
+		mem:      ev.mem,
+		mb:       ev.mb,
+		table:    &memoryTable{},
+		session:  ev.session,
+		params:   ev.params,
+		hints:    ev.hints,
+		patterns: ev.patterns,
+		plans:    ev.plans,
+	}
+	v, err := scope.eval(call)
+	if err != nil {
+		return nil, err
+	}
+
+	set, _ := v.(types.Array)
+	rows := make([]storage.Row, 0, len(set.Values))
+	for _, value := range set.Values {
+		if converted, ok := types.Assign(value, t.columnTypes[0]); ok {
+			value = converted
+		}
+
+		row := storage.Row{value}
+		if err := ev.mem.Grow(rowSize(row)); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	t.store = storage.NewTable(rows...)
+	return t, nil
+}
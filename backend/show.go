@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+)
+
+// Show lists the tables, or the columns of a table, with the result columns
+// MySQL gives them, so tools written for it can read them.
+func (mb *MemoryBackend) Show(show *parser.ShowStatement, session *functions.Session) (*Results, error) {
+	if show.Table == nil {
+		name := "Tables"
+		if db := session.Database(); db != "" {
+			name += "_in_" + db
+		}
+
+		results := &Results{Columns: []ResultColumn{{Type: TextType, Name: name}}}
+		for _, table := range mb.Tables() {
+			results.Rows = append(results.Rows, []interface{}{table})
+		}
+
+		return results, nil
+	}
+
+	columns, ok := mb.Columns(show.Table.Value)
+	if !ok {
+		return nil, ErrTableDoesNotExist
+	}
+
+	results := &Results{Columns: []ResultColumn{
+		{Type: TextType, Name: "Field"},
+		{Type: TextType, Name: "Type"},
+		{Type: TextType, Name: "Collation"},
+		{Type: TextType, Name: "Null"},
+		{Type: TextType, Name: "Key"},
+		{Type: TextType, Name: "Default"},
+		{Type: TextType, Name: "Extra"},
+		{Type: TextType, Name: "Comment"},
+	}}
+	for _, col := range columns {
+		// Columns other than identity columns take NULL, unique columns and
+		// foreign keys are the only keys and there are no defaults
+		var collation interface{}
+		if col.Collation != "" {
+			collation = col.Collation
+		}
+
+		key := ""
+		switch {
+		case col.Unique:
+			key = "UNI"
+		case col.References.Table != "":
+			key = "MUL"
+		}
+
+		null, extra := "YES", ""
+		if col.Identity != "" {
+			null, extra = "NO", "generated "+col.Identity+" as identity"
+		}
+
+		results.Rows = append(results.Rows, []interface{}{
+			col.Name, col.Type.String(), collation, null, key, nil, extra, col.Comment,
+		})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,381 @@
+package backend
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/nireo/sgsql/budget"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrOrderByPosition = errors.New("ORDER BY position is not in select list")
+	ErrNegativeLimit   = errors.New("LIMIT and OFFSET must not be negative")
+)
+
+// isOrdered reports whether slct sorts or cuts its rows with ORDER BY,
+// LIMIT or OFFSET, which makes the rows it returns depend on all of the
+// rows it reads rather than on each of them.
+func isOrdered(slct *parser.SelectStatement) bool {
+	return len(slct.OrderBy) > 0 || slct.Limit != nil || slct.Offset != nil
+}
+
+// sortKey is an ORDER BY item of a query over the table of an evaluation.
+// exp gives its values for the rows of the table. item is the position of
+// the result column it names, whose values are taken from the result rows
+// instead, and -1 for other expressions.
+type sortKey struct {
+	exp       *parser.Expression
+	item      int
+	desc      bool
+	collation *types.Collation
+}
+
+// sortKeys returns the keys slct sorts the rows of ev's table by.
+func (ev *evaluation) sortKeys(slct *parser.SelectStatement) ([]sortKey, error) {
+	// exps are the expressions of the result columns, those of * reading
+	// the columns of the table
+	exps := []*parser.Expression{}
+	names := []string{}
+	for _, item := range slct.Item {
+		if item.Asterisk {
+			for _, col := range ev.table.columns {
+				exps = append(exps, &parser.Expression{Type: parser.ColumnRefType, Column: &parser.Token{Value: col, Type: parser.IdentifierType}})
+				names = append(names, "")
+			}
+			continue
+		}
+
+		exps = append(exps, item.Exp)
+		if item.As != nil {
+			names = append(names, item.As.Value)
+		} else {
+			names = append(names, "")
+		}
+	}
+
+	keys := make([]sortKey, len(slct.OrderBy))
+	for i, order := range slct.OrderBy {
+		key := sortKey{exp: order.Exp, item: -1, desc: order.Desc}
+		switch exp := order.Exp; {
+		case exp.Type == parser.LiteralType && exp.Literal.Type == parser.Int64Value:
+			n := exp.Literal.Int64
+			if n < 1 || n > int64(len(exps)) {
+				return nil, fmt.Errorf("%w: %d", ErrOrderByPosition, n)
+			}
+			key.exp, key.item = exps[n-1], int(n-1)
+		case exp.Type == parser.ColumnRefType:
+			for j, name := range names {
+				if name == exp.Column.Value {
+					key.exp, key.item = exps[j], j
+					break
+				}
+			}
+		}
+
+		key.collation = ev.collation(key.exp)
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// window returns how many rows slct skips with OFFSET and how many of the
+// rest it returns with LIMIT, which is negative when it returns them all.
+// A NULL count is the same as leaving it out.
+func (ev *evaluation) window(slct *parser.SelectStatement) (int64, int64, error) {
+	count := func(exp *parser.Expression, clause string, none int64) (int64, error) {
+		if exp == nil {
+			return none, nil
+		}
+
+		v, err := ev.eval(exp)
+		if err != nil {
+			return 0, err
+		}
+
+		switch v := v.(type) {
+		case nil:
+			return none, nil
+		case int64:
+			if v < 0 {
+				return 0, fmt.Errorf("%w: %s %d", ErrNegativeLimit, clause, v)
+			}
+			return v, nil
+		}
+
+		t, _ := types.Of(v)
+		return 0, fmt.Errorf("%w: %s must be int, not %s", ErrInvalidDatatype, clause, t)
+	}
+
+	offset, err := count(slct.Offset, "OFFSET", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	limit, err := count(slct.Limit, "LIMIT", -1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return offset, limit, nil
+}
+
+// bound is how many rows a sort needs to keep to return limit rows after
+// skipping offset, negative when it needs them all.
+func bound(offset, limit int64) int {
+	if limit < 0 {
+		return -1
+	}
+
+	if offset > math.MaxInt32 || limit > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(offset + limit)
+}
+
+// cut returns limit of rows after skipping offset of them, all of the rest
+// when limit is negative.
+func cut(rows [][]interface{}, offset, limit int64) [][]interface{} {
+	if offset >= int64(len(rows)) {
+		return rows[len(rows):]
+	}
+
+	rows = rows[offset:]
+	if limit >= 0 && limit < int64(len(rows)) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// sortedRow is a row and the values of the keys it is sorted by. seq
+// orders rows with equal keys, which keep the order they were added in.
+type sortedRow struct {
+	row  []interface{}
+	keys []interface{}
+	seq  int
+	size int64
+}
+
+// sorter sorts rows by keys. An unbounded one keeps every row it is given
+// until they are sorted. A bounded one is a top-N sort: it only keeps the
+// first limit rows in a heap whose top is the last of them, which the rows
+// that sort before it replace, so it holds at most limit rows however many
+// it is given. The rows are kept within the memory budget of mem.
+type sorter struct {
+	keys  []sortKey
+	limit int
+	mem   *budget.Reservation
+	rows  []sortedRow
+	seq   int
+}
+
+func (s *sorter) less(a, b sortedRow) bool {
+	for i, key := range s.keys {
+		c := compareValues(a.keys[i], b.keys[i], key.collation)
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+
+	return a.seq < b.seq
+}
+
+// The heap of a bounded sorter has the row that sorts last on top.
+func (s *sorter) Len() int           { return len(s.rows) }
+func (s *sorter) Less(i, j int) bool { return s.less(s.rows[j], s.rows[i]) }
+func (s *sorter) Swap(i, j int)      { s.rows[i], s.rows[j] = s.rows[j], s.rows[i] }
+func (s *sorter) Push(x interface{}) { s.rows = append(s.rows, x.(sortedRow)) }
+
+func (s *sorter) Pop() interface{} {
+	last := s.rows[len(s.rows)-1]
+	s.rows = s.rows[:len(s.rows)-1]
+	return last
+}
+
+// add adds rows, result rows computed from the row ev evaluates. The keys
+// naming result columns take their values from each of them, the others
+// are evaluated once for all of them.
+func (s *sorter) add(ev *evaluation, rows [][]interface{}) error {
+	values := make([]interface{}, len(s.keys))
+	for i, key := range s.keys {
+		if key.item >= 0 {
+			continue
+		}
+
+		v, err := ev.eval(key.exp)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+
+	for _, row := range rows {
+		keys := append([]interface{}{}, values...)
+		for i, key := range s.keys {
+			if key.item >= 0 {
+				keys[i] = row[key.item]
+			}
+		}
+
+		if err := s.push(sortedRow{row: row, keys: keys}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sorter) push(r sortedRow) error {
+	r.seq, r.size = s.seq, rowSize(r.row)+rowSize(r.keys)
+	s.seq++
+
+	switch {
+	case s.limit < 0:
+		if err := s.mem.Grow(r.size); err != nil {
+			return err
+		}
+		s.rows = append(s.rows, r)
+	case len(s.rows) < s.limit:
+		if err := s.mem.Grow(r.size); err != nil {
+			return err
+		}
+		heap.Push(s, r)
+	case s.limit > 0 && s.less(r, s.rows[0]):
+		if err := s.mem.Grow(r.size); err != nil {
+			return err
+		}
+		s.mem.Release(s.rows[0].size)
+		s.rows[0] = r
+		heap.Fix(s, 0)
+	}
+
+	return nil
+}
+
+// sorted returns the rows kept in order.
+func (s *sorter) sorted() [][]interface{} {
+	sort.Slice(s.rows, func(i, j int) bool { return s.less(s.rows[i], s.rows[j]) })
+
+	rows := make([][]interface{}, len(s.rows))
+	for i, r := range s.rows {
+		rows[i] = r.row
+	}
+	return rows
+}
+
+// compareValues orders the values of an ORDER BY key. Values of the same
+// type compare like the keys of an index do, strings by collation c when
+// it is set, and values of different types like SQL compares them. NULL
+// comes after every other value.
+func compareValues(a, b interface{}, c *types.Collation) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok && c != nil {
+		return c.Compare(as, bs)
+	}
+
+	if at, ok := types.Of(a); ok {
+		if bt, ok := types.Of(b); ok && at == bt {
+			return compareKeys(a, b)
+		}
+	}
+
+	if lt, _ := types.ApplyCollated("<", a, b, c); lt == true {
+		return -1
+	}
+	if gt, _ := types.ApplyCollated(">", a, b, c); gt == true {
+		return 1
+	}
+	return 0
+}
+
+// orderRows sorts rows of t that passed the WHERE of slct, a query that
+// isn't aggregating, and cuts them to its LIMIT and OFFSET.
+func (ev *evaluation) orderRows(slct *parser.SelectStatement, t *memoryTable, rows [][]interface{}) ([][]interface{}, error) {
+	offset, limit, err := ev.window(slct)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(slct.OrderBy) > 0 {
+		scope := ev.sub(t, nil)
+		keys, err := scope.sortKeys(slct)
+		if err != nil {
+			return nil, err
+		}
+
+		held := ev.mem.Held()
+		defer func() { ev.mem.Release(ev.mem.Held() - held) }()
+
+		// The rows are those of t rather than result rows, so every key is
+		// evaluated over them
+		for i := range keys {
+			keys[i].item = -1
+		}
+
+		s := &sorter{keys: keys, limit: bound(offset, limit), mem: ev.mem}
+		for _, row := range rows {
+			if err := s.add(ev.sub(t, row), [][]interface{}{row}); err != nil {
+				return nil, err
+			}
+		}
+		rows = s.sorted()
+	}
+
+	return cut(rows, offset, limit), nil
+}
+
+// explainOrder describes how slct sorts and cuts its rows, with a top-N
+// sort when it is limited to some of them. An aggregating query has a
+// single row to cut and nothing to sort.
+func explainOrder(slct *parser.SelectStatement) []string {
+	lines := []string{}
+	if slct.Limit != nil || slct.Offset != nil {
+		line := "Limit: "
+		if slct.Limit != nil {
+			line += slct.Limit.String()
+		} else {
+			line += "ALL"
+		}
+		if slct.Offset != nil {
+			line += " offset " + slct.Offset.String()
+		}
+		lines = append(lines, line)
+	}
+
+	if len(slct.OrderBy) > 0 && !isAggregate(slct) {
+		keys := make([]string, len(slct.OrderBy))
+		for i, order := range slct.OrderBy {
+			keys[i] = order.Exp.String()
+			if order.Desc {
+				keys[i] += " DESC"
+			}
+		}
+
+		how := "Sort: "
+		if slct.Limit != nil {
+			how = "Top-N sort: "
+		}
+		lines = append(lines, how+strings.Join(keys, ", "))
+	}
+
+	return lines
+}
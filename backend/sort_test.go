@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		query string
+		rows  [][]interface{}
+		err   error
+	}{
+		{"select id from t order by score", [][]interface{}{{int64(1)}, {int64(3)}, {int64(2)}}, nil},
+		{"select id from t order by score desc", [][]interface{}{{int64(2)}, {int64(3)}, {int64(1)}}, nil},
+		{"select id, name from t order by 2 desc", [][]interface{}{{int64(3), "c"}, {int64(2), "b"}, {int64(1), "a"}}, nil},
+		{"select id, -id as neg from t order by neg", [][]interface{}{{int64(3), int64(-3)}, {int64(2), int64(-2)}, {int64(1), int64(-1)}}, nil},
+		{"select name from t order by name = 'b', id desc", [][]interface{}{{"c"}, {"a"}, {"b"}}, nil},
+		{"select * from t order by 1 desc limit 1", [][]interface{}{{int64(3), "c", 4.5}}, nil},
+		{"select id from t order by id desc limit 2", [][]interface{}{{int64(3)}, {int64(2)}}, nil},
+		{"select id from t order by id limit 1 offset 1", [][]interface{}{{int64(2)}}, nil},
+		{"select id from t order by id offset 2", [][]interface{}{{int64(3)}}, nil},
+		{"select id from t order by id limit 0", [][]interface{}{}, nil},
+		{"select id from t order by id limit null", [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}, nil},
+		{"select id from t limit 2", [][]interface{}{{int64(1)}, {int64(2)}}, nil},
+		{"select id from t offset 5", [][]interface{}{}, nil},
+		{"select count(*) from t limit 1", [][]interface{}{{int64(3)}}, nil},
+		{"select count(*) from t offset 1", [][]interface{}{}, nil},
+		{"select name from t where id = (select id from t order by score desc limit 1)", [][]interface{}{{"b"}}, nil},
+		{"select name from t where id in (select id from t order by id limit 2) order by name desc", [][]interface{}{{"b"}, {"a"}}, nil},
+		{"select id from t order by 4", nil, ErrOrderByPosition},
+		{"select id from t limit -1", nil, ErrNegativeLimit},
+		{"select id from t limit 'a'", nil, ErrInvalidDatatype},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb, session := testBackend(t)
+			results, err := run(mb, session, tt.query)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if len(results.Rows) != 0 || len(tt.rows) != 0 {
+				if !reflect.DeepEqual(results.Rows, tt.rows) {
+					t.Errorf("got %v, want %v", results.Rows, tt.rows)
+				}
+			}
+		})
+	}
+}
+
+func TestExplainOrderBy(t *testing.T) {
+	tests := []struct {
+		query string
+		lines []string
+	}{
+		{"select id from t order by score desc limit 2", []string{"Limit: 2", "Top-N sort: score DESC"}},
+		{"select id from t order by id, name desc", []string{"Sort: id, name DESC"}},
+		{"select id from t order by id offset 1", []string{"Limit: ALL offset 1", "Sort: id"}},
+		{"select id from t limit 2 offset 1", []string{"Limit: 2 offset 1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mb, session := testBackend(t)
+			plan, err := run(mb, session, "explain "+tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range tt.lines {
+				if !explainsLine(plan.Rows, line) {
+					t.Errorf("explained as %v, want %q", plan.Rows, line)
+				}
+			}
+		})
+	}
+}
+
+func TestTopNSort(t *testing.T) {
+	keys := []sortKey{{item: 0, desc: true}}
+	s := &sorter{keys: keys, limit: 3}
+	for i := int64(0); i < 100; i++ {
+		if err := s.push(sortedRow{row: []interface{}{i % 37}, keys: []interface{}{i % 37}}); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.rows) > 3 {
+			t.Fatalf("holds %d rows, want at most 3", len(s.rows))
+		}
+	}
+
+	want := [][]interface{}{{int64(36)}, {int64(36)}, {int64(35)}}
+	if got := s.sorted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,576 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrStatisticsExist      = errors.New("Statistics already exist")
+	ErrStatisticsDoNotExist = errors.New("Statistics do not exist")
+)
+
+// conjunctSelectivity is the fraction of rows a conjunct nothing is known
+// about is guessed to hold for.
+const conjunctSelectivity = 1.0 / 3
+
+// tableStats are the statistics ANALYZE collected of the columns of a
+// table. They are replaced rather than changed, like the table itself, and
+// go stale as rows are inserted until the table is analyzed again.
+type tableStats struct {
+	at   time.Time
+	rows int64
+	// distinct counts the distinct values other than NULL of each column
+	// and nulls the NULLs, by the name of the column
+	distinct map[string]int64
+	nulls    map[string]int64
+}
+
+// extendedStats are statistics over several columns of a table taken
+// together, see CreateStatistics. The planner otherwise takes the columns
+// to be independent, which underestimates the rows matching equalities of
+// columns that go together, like a city and its postal code.
+type extendedStats struct {
+	name    string
+	columns []string
+	// ndistinct and dependencies are the kinds collected
+	ndistinct    bool
+	dependencies bool
+	// data is what ANALYZE collected, nil until the table is analyzed
+	data *extendedData
+}
+
+type extendedData struct {
+	// distinct counts the distinct combinations of the values of the
+	// columns
+	distinct int64
+	// dependencies hold how far the value of each column determines the
+	// value of each other one
+	dependencies []dependency
+}
+
+// dependency is the functional dependency of column to on column from.
+// degree is the fraction of the rows whose value of from always comes with
+// the same value of to, 1 when from determines to.
+type dependency struct {
+	from, to string
+	degree   float64
+}
+
+// kinds names the kinds s collects the way CREATE STATISTICS takes them.
+func (s *extendedStats) kinds() []string {
+	kinds := []string{}
+	if s.ndistinct {
+		kinds = append(kinds, "ndistinct")
+	}
+	if s.dependencies {
+		kinds = append(kinds, "dependencies")
+	}
+
+	return kinds
+}
+
+// CreateStatistics defines statistics over several columns of a table,
+// which ANALYZE collects from then on.
+func (mb *MemoryBackend) CreateStatistics(crt *parser.CreateStatisticsStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	t, s, err := mb.newStatistics(crt)
+	if err != nil {
+		return err
+	}
+
+	created := *t
+	created.statistics = append(append([]*extendedStats{}, t.statistics...), s)
+	mb.tables[crt.Table.Value] = &created
+	return nil
+}
+
+// newStatistics checks crt and returns the table it is over and the
+// statistics without data. It must be called with mb.mu held.
+func (mb *MemoryBackend) newStatistics(crt *parser.CreateStatisticsStatement) (*memoryTable, *extendedStats, error) {
+	name := crt.Table.Value
+	if isSystemTable(name) {
+		return nil, nil, ErrSystemTable
+	}
+
+	t, ok := mb.tables[name]
+	if !ok {
+		return nil, nil, ErrTableDoesNotExist
+	}
+
+	if t.external != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrExternalTable, name)
+	}
+
+	if _, _, ok := mb.findStatistics(crt.Name.Value); ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrStatisticsExist, crt.Name.Value)
+	}
+
+	s := &extendedStats{name: crt.Name.Value}
+	for _, col := range crt.Columns {
+		if _, ok := t.columnIndex(col.Value); !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, col.Value)
+		}
+		s.columns = append(s.columns, col.Value)
+	}
+
+	for _, kind := range crt.Kinds {
+		switch kind.Value {
+		case "ndistinct":
+			s.ndistinct = true
+		case "dependencies":
+			s.dependencies = true
+		}
+	}
+	if len(crt.Kinds) == 0 {
+		s.ndistinct, s.dependencies = true, true
+	}
+
+	return t, s, nil
+}
+
+// DropStatistics drops statistics CreateStatistics defined.
+func (mb *MemoryBackend) DropStatistics(drop *parser.DropStatisticsStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name, i, ok := mb.findStatistics(drop.Name.Value)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrStatisticsDoNotExist, drop.Name.Value)
+	}
+
+	t := mb.tables[name]
+	dropped := *t
+	dropped.statistics = append(append([]*extendedStats{}, t.statistics[:i]...), t.statistics[i+1:]...)
+	mb.tables[name] = &dropped
+	return nil
+}
+
+// findStatistics returns the table of the statistics called name and where
+// they are among the statistics of the table. Like indexes, no two can have
+// the same name. It must be called with mb.mu held.
+func (mb *MemoryBackend) findStatistics(name string) (string, int, bool) {
+	for table, t := range mb.tables {
+		for i, s := range t.statistics {
+			if s.name == name {
+				return table, i, true
+			}
+		}
+	}
+
+	return "", 0, false
+}
+
+// Analyze collects the statistics of the table analyze names, or of every
+// table but external ones when it names none, which the planner estimates
+// the rows queries read with.
+func (mb *MemoryBackend) Analyze(ctx context.Context, analyze *parser.AnalyzeStatement) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	names := []string{}
+	if analyze.Table != nil {
+		name := analyze.Table.Value
+		if isSystemTable(name) {
+			return ErrSystemTable
+		}
+
+		t, ok := mb.tables[name]
+		if !ok {
+			return ErrTableDoesNotExist
+		}
+
+		if t.external != nil {
+			return fmt.Errorf("%w: %s", ErrExternalTable, name)
+		}
+		names = append(names, name)
+	} else {
+		for _, name := range mb.tableNames() {
+			if mb.tables[name].external == nil {
+				names = append(names, name)
+			}
+		}
+	}
+
+	at := time.Now().UTC()
+	for _, name := range names {
+		if ctx != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		analyzed := *mb.tables[name]
+		var err error
+		if analyzed.stats, analyzed.statistics, err = analyzeTable(&analyzed, at); err != nil {
+			return err
+		}
+		mb.tables[name] = &analyzed
+	}
+
+	return nil
+}
+
+// analyzeTable collects the statistics of the columns of t and those
+// defined over several of them.
+func analyzeTable(t *memoryTable, at time.Time) (*tableStats, []*extendedStats, error) {
+	stats := &tableStats{at: at, distinct: map[string]int64{}, nulls: map[string]int64{}}
+
+	rows := [][]string{}
+	distinct := make([]map[string]bool, len(t.columns))
+	for i := range distinct {
+		distinct[i] = map[string]bool{}
+	}
+
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		keys := make([]string, len(t.columns))
+		for i, col := range t.columns {
+			v := row[i]
+			if v == nil {
+				stats.nulls[col]++
+				continue
+			}
+
+			keys[i] = statisticsKey(v)
+			distinct[i][keys[i]] = true
+		}
+		rows = append(rows, keys)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	stats.rows = int64(len(rows))
+	for i, col := range t.columns {
+		stats.distinct[col] = int64(len(distinct[i]))
+	}
+
+	statistics := make([]*extendedStats, len(t.statistics))
+	for i, s := range t.statistics {
+		positions := make([]int, len(s.columns))
+		for j, col := range s.columns {
+			positions[j], _ = t.columnIndex(col)
+		}
+
+		analyzed := *s
+		analyzed.data = &extendedData{}
+		if s.ndistinct {
+			combinations := map[string]bool{}
+			for _, keys := range rows {
+				combination := make([]string, len(positions))
+				for j, p := range positions {
+					combination[j] = keys[p]
+				}
+				combinations[strings.Join(combination, "\x00")] = true
+			}
+			analyzed.data.distinct = int64(len(combinations))
+		}
+
+		if s.dependencies {
+			for j, from := range positions {
+				for k, to := range positions {
+					if j != k {
+						analyzed.data.dependencies = append(analyzed.data.dependencies, dependency{
+							from:   s.columns[j],
+							to:     s.columns[k],
+							degree: dependencyDegree(rows, from, to),
+						})
+					}
+				}
+			}
+		}
+		statistics[i] = &analyzed
+	}
+
+	return stats, statistics, nil
+}
+
+// dependencyDegree returns the fraction of rows whose value of column from
+// comes with the same value of column to in every row. rows hold the keys
+// of the values.
+func dependencyDegree(rows [][]string, from, to int) float64 {
+	if len(rows) == 0 {
+		return 0
+	}
+
+	type group struct {
+		to         string
+		rows       int
+		consistent bool
+	}
+
+	groups := map[string]*group{}
+	for _, keys := range rows {
+		g, ok := groups[keys[from]]
+		if !ok {
+			groups[keys[from]] = &group{to: keys[to], rows: 1, consistent: true}
+			continue
+		}
+
+		g.rows++
+		if g.to != keys[to] {
+			g.consistent = false
+		}
+	}
+
+	supporting := 0
+	for _, g := range groups {
+		if g.consistent {
+			supporting += g.rows
+		}
+	}
+
+	return float64(supporting) / float64(len(rows))
+}
+
+// statisticsKey returns a key that two values have in common when they
+// are equal, NULLs aside, which ANALYZE counts the distinct values with.
+func statisticsKey(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return "t" + v.UTC().Format(time.RFC3339Nano)
+	case types.UUIDValue:
+		return "u" + v.String()
+	case float64:
+		return "f" + strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// estimateRows estimates the rows of t that the WHERE of slct holds for
+// with the statistics of t, returning false when t hasn't been analyzed.
+// Equalities of a column with a value that is the same for every row hold
+// for the rows with one of its distinct values. The columns are taken to
+// be independent unless statistics over several of them say otherwise,
+// and other conjuncts are guessed to hold for a third of the rows each.
+func (ev *evaluation) estimateRows(slct *parser.SelectStatement, t *memoryTable) (int64, bool) {
+	stats := t.stats
+	if stats == nil {
+		return 0, false
+	}
+
+	rows := float64(t.store.Len())
+	selectivity := 1.0
+	// equal holds the selectivity of the equalities of each column
+	equal := map[string]float64{}
+	if slct.Where != nil {
+		for _, exp := range conjuncts(slct.Where) {
+			column, ok := ev.equalityColumn(exp, t)
+			if !ok {
+				selectivity *= conjunctSelectivity
+				continue
+			}
+
+			sel := 0.0
+			if distinct := stats.distinct[column]; distinct > 0 && stats.rows > 0 {
+				sel = (1 - float64(stats.nulls[column])/float64(stats.rows)) / float64(distinct)
+			}
+			if s, ok := equal[column]; !ok || sel < s {
+				equal[column] = sel
+			}
+		}
+	}
+
+	// Statistics over columns that all have equalities give the number of
+	// combinations of their values, which replaces their own
+	for {
+		var best *extendedStats
+		for _, s := range t.statistics {
+			if s.data == nil || !s.ndistinct || s.data.distinct == 0 || (best != nil && len(s.columns) <= len(best.columns)) {
+				continue
+			}
+
+			covered := true
+			for _, col := range s.columns {
+				if _, ok := equal[col]; !ok {
+					covered = false
+					break
+				}
+			}
+			if covered {
+				best = s
+			}
+		}
+		if best == nil {
+			break
+		}
+
+		selectivity /= float64(best.data.distinct)
+		for _, col := range best.columns {
+			delete(equal, col)
+		}
+	}
+
+	// Otherwise a column determined by another one matches most of the
+	// rows the other one does, P(a, b) = P(a) * (d + (1 - d) * P(b)) for
+	// the degree d of the dependency of b on a. The strongest dependencies
+	// are applied first, and a column determining another one isn't
+	// taken to be determined itself, which would count neither.
+	dependencies := []dependency{}
+	for _, s := range t.statistics {
+		if s.data == nil {
+			continue
+		}
+
+		for _, d := range s.data.dependencies {
+			_, from := equal[d.from]
+			_, to := equal[d.to]
+			if from && to {
+				dependencies = append(dependencies, d)
+			}
+		}
+	}
+	sort.SliceStable(dependencies, func(i, j int) bool { return dependencies[i].degree > dependencies[j].degree })
+
+	determined, determining := map[string]bool{}, map[string]bool{}
+	for _, d := range dependencies {
+		if determined[d.to] || determining[d.to] {
+			continue
+		}
+
+		equal[d.to] = d.degree + (1-d.degree)*equal[d.to]
+		determined[d.to], determining[d.from] = true, true
+	}
+
+	for _, sel := range equal {
+		selectivity *= sel
+	}
+
+	return int64(math.Round(rows * selectivity)), true
+}
+
+// equalityColumn returns the column of t exp compares with a value that is
+// the same for every row, if exp is such an equality.
+func (ev *evaluation) equalityColumn(exp *parser.Expression, t *memoryTable) (string, bool) {
+	if exp.Type != parser.BinaryType || exp.Binary.Op.Value != "=" {
+		return "", false
+	}
+
+	column, value := &exp.Binary.A, &exp.Binary.B
+	if column.Type != parser.ColumnRefType {
+		column, value = value, column
+	}
+	if column.Type != parser.ColumnRefType || ev.refs(t, value) != 0 || !stable(value) {
+		return "", false
+	}
+
+	if _, ok := t.columnIndex(column.Column.Value); !ok {
+		return "", false
+	}
+
+	return column.Column.Value, true
+}
+
+// unanalyzed returns statistics like statistics without their data, for
+// tables whose rows ALTER TABLE rewrote.
+func unanalyzed(statistics []*extendedStats) []*extendedStats {
+	if statistics == nil {
+		return nil
+	}
+
+	cleared := make([]*extendedStats, len(statistics))
+	for i, s := range statistics {
+		c := *s
+		c.data = nil
+		cleared[i] = &c
+	}
+
+	return cleared
+}
+
+// statisticsRows returns the rows of __statistics.
+func (mb *MemoryBackend) statisticsRows() [][]interface{} {
+	rows := [][]interface{}{}
+	for _, table := range mb.tableNames() {
+		t := mb.tables[table]
+		for _, s := range t.statistics {
+			columns := make([]interface{}, len(s.columns))
+			for i, col := range s.columns {
+				columns[i] = col
+			}
+
+			var distinct, dependencies, at interface{}
+			if s.data != nil {
+				if s.ndistinct {
+					distinct = s.data.distinct
+				}
+				if s.dependencies {
+					deps := make([]string, len(s.data.dependencies))
+					for i, d := range s.data.dependencies {
+						deps[i] = d.from + " => " + d.to + ": " + strconv.FormatFloat(d.degree, 'f', 3, 64)
+					}
+					dependencies = strings.Join(deps, ", ")
+				}
+				at = t.stats.at
+			}
+
+			rows = append(rows, []interface{}{
+				s.name, table, types.Array{Elem: TextType, Values: columns}, distinct, dependencies, at,
+			})
+		}
+	}
+
+	return rows
+}
+
+// columnStatsRows returns the rows of __column_stats.
+func (mb *MemoryBackend) columnStatsRows() [][]interface{} {
+	rows := [][]interface{}{}
+	for _, table := range mb.tableNames() {
+		t := mb.tables[table]
+		if t.stats == nil {
+			continue
+		}
+
+		for _, col := range t.columns {
+			rows = append(rows, []interface{}{table, col, t.stats.distinct[col], t.stats.nulls[col]})
+		}
+	}
+
+	return rows
+}
+
+// lastAnalyze returns when t was last analyzed, NULL if it hasn't been.
+func lastAnalyze(t *memoryTable) interface{} {
+	if t.stats == nil {
+		return nil
+	}
+
+	return t.stats.at
+}
+
+// dumpStatistics returns the statements recreating the statistics of the
+// table t called name, and analyzing it again if it was.
+func dumpStatistics(name string, t *memoryTable) []DumpStatement {
+	stmts := []DumpStatement{}
+	for _, s := range t.statistics {
+		columns := make([]string, len(s.columns))
+		for i, col := range s.columns {
+			columns[i] = parser.FormatIdentifier(col)
+		}
+
+		stmts = append(stmts, DumpStatement{
+			Query: "CREATE STATISTICS " + parser.FormatIdentifier(s.name) + " (" + strings.Join(s.kinds(), ", ") + ")" +
+				" ON " + strings.Join(columns, ", ") + " FROM " + parser.FormatIdentifier(name),
+		})
+	}
+
+	if t.stats != nil {
+		stmts = append(stmts, DumpStatement{Query: "ANALYZE " + parser.FormatIdentifier(name)})
+	}
+
+	return stmts
+}
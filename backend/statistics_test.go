@@ -0,0 +1,224 @@
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql/functions"
+)
+
+// statisticsTestTable returns a backend holding the table addr of 1000
+// rows, with 100 zip codes spread evenly over 10 cities, so the zip code
+// determines the city but not the other way around.
+func statisticsTestTable(t *testing.T, queries ...string) (*MemoryBackend, *functions.Session) {
+	t.Helper()
+
+	mb := NewMemoryBackend()
+	session := functions.NewSession(mb)
+	queries = append([]string{"create table addr (id int, city text, zip int)"}, queries...)
+	for i := 0; i < 1000; i++ {
+		queries = append(queries, fmt.Sprintf("insert into addr values (%d, 'c%d', %d)", i, i%100/10, i%100))
+	}
+
+	for _, query := range queries {
+		if _, err := run(mb, session, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	return mb, session
+}
+
+func TestAnalyzeChangesPlan(t *testing.T) {
+	mb, session := statisticsTestTable(t, "create index addr_city on addr (city)", "create index addr_id on addr (id)")
+	query := "select id, zip from addr where city = 'c5' and id = 451"
+	want := [][]interface{}{{int64(451), int64(51)}}
+
+	plan := func() [][]interface{} {
+		t.Helper()
+
+		results, err := run(mb, session, "explain "+query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results.Rows
+	}
+	check := func() {
+		t.Helper()
+
+		results, err := run(mb, session, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(results.Rows, want) {
+			t.Fatalf("got %v, want %v", results.Rows, want)
+		}
+	}
+
+	// Before ANALYZE the index of the first equality is used, and nothing
+	// is estimated
+	before := plan()
+	if !explains(before, "using addr_city") || explains(before, "Estimated rows") {
+		t.Fatalf("unanalyzed plan:\n%v", before)
+	}
+	check()
+
+	// Afterwards the index over the column with the most distinct values
+	if _, err := run(mb, session, "analyze addr"); err != nil {
+		t.Fatal(err)
+	}
+	after := plan()
+	if !explains(after, "using addr_id") || !explains(after, "Estimated rows") {
+		t.Fatalf("analyzed plan:\n%v", after)
+	}
+	check()
+
+	// ALTER TABLE rewrites the rows, which drops what ANALYZE collected
+	if _, err := run(mb, session, "alter table addr add column note text"); err != nil {
+		t.Fatal(err)
+	}
+	if altered := plan(); !explains(altered, "using addr_city") || explains(altered, "Estimated rows") {
+		t.Fatalf("altered plan:\n%v", altered)
+	}
+	check()
+}
+
+func TestColumnStats(t *testing.T) {
+	mb, session := testBackend(t)
+
+	results, err := run(mb, session, "select * from __column_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Rows) != 0 {
+		t.Fatalf("got %v before ANALYZE", results.Rows)
+	}
+
+	want := [][]interface{}{
+		{"t", "id", int64(3), int64(0)},
+		{"t", "name", int64(3), int64(0)},
+		{"t", "score", int64(2), int64(1)},
+	}
+	results, err = run(mb, session, "analyze; select * from __column_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results.Rows, want) {
+		t.Fatalf("got %v, want %v", results.Rows, want)
+	}
+
+	// The statistics go stale until the table is analyzed again
+	results, err = run(mb, session, "insert into t values (4, 'a', null); insert into t values (5, 'e', 1.5); select * from __column_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results.Rows, want) {
+		t.Fatalf("got %v after inserting, want %v", results.Rows, want)
+	}
+
+	want = [][]interface{}{
+		{"t", "id", int64(5), int64(0)},
+		{"t", "name", int64(4), int64(0)},
+		{"t", "score", int64(2), int64(2)},
+	}
+	results, err = run(mb, session, "analyze t; select * from __column_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(results.Rows, want) {
+		t.Fatalf("got %v, want %v", results.Rows, want)
+	}
+
+	results, err = run(mb, session, "select last_analyze from __table_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Rows[0][0] == nil {
+		t.Error("last_analyze of t is NULL")
+	}
+}
+
+func TestExtendedStatistics(t *testing.T) {
+	query := "explain select id from addr where city = 'c3' and zip = 35"
+
+	tests := []struct {
+		name       string
+		statistics string
+		// distinct and dependencies are the row of __statistics
+		distinct     interface{}
+		dependencies interface{}
+		// estimate is of the 10 rows query reads
+		estimate string
+	}{
+		{
+			// The columns are taken to be independent
+			name:     "none",
+			estimate: "Estimated rows: 1",
+		},
+		{
+			name:       "ndistinct",
+			statistics: "create statistics s (ndistinct) on city, zip from addr",
+			distinct:   int64(100),
+			estimate:   "Estimated rows: 10",
+		},
+		{
+			name:         "dependencies",
+			statistics:   "create statistics s (dependencies) on city, zip from addr",
+			dependencies: "city => zip: 0.000, zip => city: 1.000",
+			estimate:     "Estimated rows: 10",
+		},
+		{
+			name:         "both",
+			statistics:   "create statistics s on city, zip from addr",
+			distinct:     int64(100),
+			dependencies: "city => zip: 0.000, zip => city: 1.000",
+			estimate:     "Estimated rows: 10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := []string{}
+			if tt.statistics != "" {
+				queries = append(queries, tt.statistics)
+			}
+			mb, session := statisticsTestTable(t, queries...)
+
+			results, err := run(mb, session, "analyze addr; "+query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			estimated := false
+			for _, line := range results.Rows {
+				estimated = estimated || strings.TrimSpace(line[0].(string)) == tt.estimate
+			}
+			if !estimated {
+				t.Errorf("got plan %v, want %s", results.Rows, tt.estimate)
+			}
+
+			if tt.statistics == "" {
+				return
+			}
+			results, err = run(mb, session, "select distinct_values, dependencies from __statistics")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := [][]interface{}{{tt.distinct, tt.dependencies}}
+			if !reflect.DeepEqual(results.Rows, want) {
+				t.Errorf("got %v, want %v", results.Rows, want)
+			}
+
+			// Statistics defined after ANALYZE are empty until the next one
+			results, err = run(mb, session, "drop statistics s; "+tt.statistics+"; select distinct_values, dependencies from __statistics")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = [][]interface{}{{nil, nil}}
+			if !reflect.DeepEqual(results.Rows, want) {
+				t.Errorf("got %v before ANALYZE, want %v", results.Rows, want)
+			}
+		})
+	}
+}
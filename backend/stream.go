@@ -0,0 +1,42 @@
+package backend
+
+import "context"
+
+// RowWriter takes the rows of a query as they are computed, see
+// WithRowWriter.
+type RowWriter interface {
+	// WriteColumns is called once, before any row
+	WriteColumns(columns []ResultColumn) error
+	WriteRow(row []interface{}) error
+}
+
+type rowWriterKey struct{}
+
+// WithRowWriter returns a context under which SELECT hands the rows it scans
+// to w one at a time instead of returning them, so they are never all held
+// in memory. Results then only have the columns. Queries that compute all
+// of their rows before returning any, like those aggregating them, return
+// them as usual without calling w. A nil w turns streaming off again.
+//
+// The rows are written while the query holds the backend for reading, so a
+// writer waiting on a slow client keeps changes waiting too.
+func WithRowWriter(ctx context.Context, w RowWriter) context.Context {
+	return context.WithValue(ctx, rowWriterKey{}, w)
+}
+
+// RowWriterOf returns the writer of ctx, nil without one.
+func RowWriterOf(ctx context.Context) RowWriter {
+	w, _ := ctx.Value(rowWriterKey{}).(RowWriter)
+	return w
+}
+
+// takeRowWriter returns the writer of ctx, and ctx without it for the
+// queries nested in the one writing its rows.
+func takeRowWriter(ctx context.Context) (RowWriter, context.Context) {
+	w := RowWriterOf(ctx)
+	if w == nil {
+		return nil, ctx
+	}
+
+	return w, WithRowWriter(ctx, nil)
+}
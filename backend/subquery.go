@@ -0,0 +1,736 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql/budget"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+var ErrSubqueryRows = errors.New("More than one row returned by a subquery used as an expression")
+
+type subqueryKind uint
+
+const (
+	// subqueryNested runs the subquery again for every row of the
+	// enclosing query
+	subqueryNested subqueryKind = iota
+	// subqueryConstant runs a subquery that doesn't refer to the enclosing
+	// query once
+	subqueryConstant
+	// subqueryHashed runs the subquery once, grouping its rows on the
+	// columns it is correlated on
+	subqueryHashed
+)
+
+// subqueryKinds names the kinds in traces.
+var subqueryKinds = [...]string{"nested", "constant", "hashed"}
+
+// subqueryMode is how the result of a subquery is used, which decides what
+// it returns.
+type subqueryMode uint
+
+const (
+	// scalarSubquery returns the value of its only row, or NULL without any
+	scalarSubquery subqueryMode = iota
+	// existsSubquery returns whether it has any rows
+	existsSubquery
+	// inSubquery returns the values of its rows as a *valueSet
+	inSubquery
+)
+
+// subqueryPlan is how an EXISTS, IN or scalar subquery is answered. When the
+// subquery is only correlated through equalities between its own
+// expressions and the enclosing query's, it is decorrelated: its rows are
+// grouped by their side of the equalities, the result is computed once per
+// group, and each outer row looks up the group matching its side. EXISTS
+// then runs as a semi-join, NOT EXISTS as an anti-join, IN as a lookup in
+// the values of the group and a scalar aggregate as a join against the
+// grouped aggregates, instead of running the subquery once per outer row.
+//
+// Plans adapt to the rows they find as they run: a hashed plan whose groups
+// outgrow the memory budget while being built lets go of them and runs
+// nested from then on, which only holds the rows of one outer row at a
+// time, instead of failing the statement.
+type subqueryPlan struct {
+	kind subqueryKind
+	// adapted is set once a hashed plan switched to running nested
+	adapted bool
+	table   *memoryTable
+	// residual is the part of the subquery's WHERE not correlated with the
+	// enclosing query
+	residual []*parser.Expression
+	// innerKeys are evaluated against the subquery's rows and outerKeys
+	// against the enclosing row, both converted to keyTypes
+	innerKeys []*parser.Expression
+	outerKeys []*parser.Expression
+	keyTypes  []ColumnType
+
+	// value and err are the result of a constant subquery
+	value interface{}
+	err   error
+	// groups maps keys to the result for outer rows with those keys, missing
+	// is the result for keys without any rows
+	groups  map[string]interface{}
+	missing interface{}
+}
+
+// refs are the scopes the column references of an expression resolve to.
+type refs uint
+
+const (
+	innerRefs refs = 1 << iota
+	outerRefs
+	// otherRefs are references further out and nested subqueries, whose
+	// references aren't followed
+	otherRefs
+)
+
+func (ev *evaluation) exists(e *parser.ExistsExpression) (interface{}, error) {
+	found, err := ev.subquery(e.Query, existsSubquery)
+	if err != nil {
+		return nil, err
+	}
+
+	return found.(bool) != e.Not, nil
+}
+
+// subquery evaluates slct for the current row, used as mode says.
+func (ev *evaluation) subquery(slct *parser.SelectStatement, mode subqueryMode) (interface{}, error) {
+	plan, ok := ev.plans[slct]
+	if !ok {
+		span := ev.start("sgsql.subquery")
+		var err error
+		if plan, err = ev.planSubquery(slct, mode); err != nil {
+			span.RecordError(err)
+			span.End()
+			return nil, err
+		}
+
+		span.SetAttribute("kind", subqueryKinds[plan.kind])
+		err = ev.buildSubquery(slct, plan, mode)
+		if err != nil {
+			span.RecordError(err)
+		} else if plan.adapted {
+			span.SetAttribute("adapted", subqueryKinds[plan.kind])
+		}
+		span.End()
+		if err != nil {
+			return nil, err
+		}
+
+		if ev.plans != nil {
+			ev.plans[slct] = plan
+		}
+	}
+
+	switch plan.kind {
+	case subqueryConstant:
+		return plan.value, plan.err
+	case subqueryHashed:
+		values := make([]interface{}, len(plan.outerKeys))
+		for i, exp := range plan.outerKeys {
+			v, err := ev.eval(exp)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+
+		// NULL equals nothing, so an outer row with a NULL key has no group
+		key, ok := hashKey(values, plan.keyTypes)
+		if !ok {
+			return plan.missing, nil
+		}
+
+		result, ok := plan.groups[key]
+		if !ok {
+			return plan.missing, nil
+		}
+		if err, ok := result.(error); ok {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	var filter []*parser.Expression
+	if slct.Where != nil {
+		filter = []*parser.Expression{slct.Where}
+	}
+
+	// EXISTS only needs one row and a scalar subquery two to fail, unless
+	// the rows are sorted or cut first
+	limit := -1
+	switch {
+	case isOrdered(slct):
+	case mode == existsSubquery:
+		limit = 1
+	case mode == scalarSubquery && !isAggregate(slct):
+		limit = 2
+	}
+
+	rows, err := ev.filter(plan.table, filter, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return ev.result(slct, plan.table, rows, mode)
+}
+
+// result computes what a subquery returns over rows of t that passed its
+// WHERE.
+func (ev *evaluation) result(slct *parser.SelectStatement, t *memoryTable, rows [][]interface{}, mode subqueryMode) (interface{}, error) {
+	if isOrdered(slct) {
+		var err error
+		if isAggregate(slct) {
+			// LIMIT and OFFSET can leave out the one row of the aggregate,
+			// which then returns what a query without rows does
+			offset, limit, err := ev.window(slct)
+			if err != nil {
+				return nil, err
+			}
+			if len(cut([][]interface{}{nil}, offset, limit)) == 0 {
+				switch mode {
+				case existsSubquery:
+					return false, nil
+				case inSubquery:
+					return &valueSet{keys: map[string]bool{}, typ: ev.sub(t, nil).columnType(slct.Item[0].Exp)}, nil
+				}
+				return nil, nil
+			}
+		} else if rows, err = ev.orderRows(slct, t, rows); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case mode == existsSubquery:
+		return len(rows) > 0, nil
+	case mode == inSubquery:
+		return ev.valueSet(slct, t, rows)
+	case isAggregate(slct):
+		agg, err := newAggregation(slct)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := ev.aggregate(agg, t, rows)
+		if err != nil {
+			return nil, err
+		}
+		return values[0], nil
+	case len(rows) == 0:
+		return nil, nil
+	case len(rows) > 1:
+		return nil, ErrSubqueryRows
+	}
+
+	return ev.sub(t, rows[0]).eval(slct.Item[0].Exp)
+}
+
+// valueSet holds the values of the rows an IN subquery returned. The ones
+// that aren't NULL are also kept by their hash keys for the type of the
+// column, unless the column has a collation, which compares values that
+// aren't equal.
+type valueSet struct {
+	values    []interface{}
+	keys      map[string]bool
+	typ       ColumnType
+	collation *types.Collation
+	// null is set if one of the values is NULL
+	null bool
+}
+
+// valueSet returns the values of the only item of slct for rows of t.
+func (ev *evaluation) valueSet(slct *parser.SelectStatement, t *memoryTable, rows [][]interface{}) (*valueSet, error) {
+	item := slct.Item[0].Exp
+	values := make([]interface{}, 0, len(rows))
+	if isAggregate(slct) {
+		agg, err := newAggregation(slct)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err = ev.aggregate(agg, t, rows)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, row := range rows {
+			v, err := ev.sub(t, row).eval(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	}
+
+	set := &valueSet{values: values, keys: map[string]bool{}, typ: ev.sub(t, nil).columnType(item)}
+	if item.Type == parser.ColumnRefType {
+		if i, ok := t.columnIndex(item.Column.Value); ok {
+			set.collation = t.collations[i]
+		}
+	}
+
+	for _, v := range values {
+		if v == nil {
+			set.null = true
+			continue
+		}
+
+		if key, ok := hashKey([]interface{}{v}, []ColumnType{set.typ}); ok {
+			if err := ev.mem.Grow(int64(len(key))); err != nil {
+				return nil, err
+			}
+			set.keys[key] = true
+		}
+	}
+
+	return set, nil
+}
+
+// contains returns whether v equals one of the values of s: NULL when v is
+// NULL or none of them is equal but one is NULL, like comparing v with
+// each value would.
+func (s *valueSet) contains(v interface{}) (interface{}, error) {
+	switch {
+	case len(s.values) == 0:
+		return false, nil
+	case v == nil:
+		return nil, nil
+	}
+
+	// Values are looked up by their keys when they have the type of the
+	// column or can be converted to it without changing, and compared
+	// with every value otherwise
+	lookup := v
+	if f, ok := v.(float64); ok && s.typ == IntType && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		lookup = int64(f)
+	}
+	if t, ok := types.Of(lookup); ok && s.collation == nil && types.Assignable(t, s.typ) {
+		key, _ := hashKey([]interface{}{lookup}, []ColumnType{s.typ})
+		if s.keys[key] {
+			return true, nil
+		}
+	} else {
+		for _, value := range s.values {
+			if value == nil {
+				continue
+			}
+
+			eq, err := types.ApplyCollated("=", v, value, s.collation)
+			if err != nil {
+				return nil, err
+			}
+			if eq == true {
+				return true, nil
+			}
+		}
+	}
+
+	if s.null {
+		return nil, nil
+	}
+	return false, nil
+}
+
+// subqueryTable returns the table slct reads. The table of a set-returning
+// function or an external table has no rows here, see subqueryRows.
+func (ev *evaluation) subqueryTable(slct *parser.SelectStatement) (*memoryTable, error) {
+	if slct.Function != nil {
+		return ev.mb.functionColumns(slct.Function)
+	}
+
+	if slct.From == nil {
+		return &memoryTable{store: storage.NewTable(storage.Row{})}, nil
+	}
+
+	t, ok := ev.mb.lookupTable(ev.ctx, slct.From.Value)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableDoesNotExist, slct.From.Value)
+	}
+
+	return ev.mb.project(t, slct), nil
+}
+
+// subqueryRows is subqueryTable with the rows of every table, calling the
+// set-returning function slct reads, reading the file of its external
+// table or the version of the table it reads AS OF TIMESTAMP, and leaving out the rows hidden by the policies of the table and
+// the values hidden by its masks.
+func (ev *evaluation) subqueryRows(slct *parser.SelectStatement) (*memoryTable, error) {
+	if slct.Function != nil {
+		return ev.functionTable(slct.Function)
+	}
+
+	if slct.From != nil {
+		if t, ok := ev.mb.lookupTable(ev.ctx, slct.From.Value); ok && (t.external != nil || slct.AsOf != nil || len(t.policies) > 0 || len(t.masks) > 0) {
+			read := t
+			var err error
+			if t.external != nil {
+				if read, err = ev.externalRows(slct.From.Value, t); err != nil {
+					return nil, err
+				}
+			} else if slct.AsOf != nil {
+				if read, err = ev.historicRows(slct, t); err != nil {
+					return nil, err
+				}
+			}
+			if read, err = ev.visibleRows(read); err != nil {
+				return nil, err
+			}
+			if read, err = ev.maskedRows(read); err != nil {
+				return nil, err
+			}
+			return ev.mb.project(read, slct), nil
+		}
+	}
+
+	return ev.subqueryTable(slct)
+}
+
+// sub returns the evaluation of row of t in a subquery of ev.
+func (ev *evaluation) sub(t *memoryTable, row []interface{}) *evaluation {
+	return &evaluation{
+		ctx:      ev.ctx,
+		mem:      ev.mem,
+		mb:       ev.mb,
+		table:    t,
+		row:      row,
+		session:  ev.session,
+		params:   ev.params,
+		outer:    ev,
+		hints:    ev.hints,
+		patterns: ev.patterns,
+		plans:    ev.plans,
+	}
+}
+
+// filter returns up to limit rows of t satisfying every expression of
+// filter, or all of them when limit is negative.
+func (ev *evaluation) filter(t *memoryTable, filter []*parser.Expression, limit int) ([][]interface{}, error) {
+	rows := [][]interface{}{}
+	if never(filter) {
+		return rows, nil
+	}
+
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if len(rows) == limit {
+			break
+		}
+
+		if err := ev.canceled(); err != nil {
+			return nil, err
+		}
+
+		ok, err := ev.sub(t, row).satisfies(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			// The rows are shared with the table, only the references to
+			// them are buffered
+			if err := ev.mem.Grow(rowRefSize); err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (ev *evaluation) satisfies(filter []*parser.Expression) (bool, error) {
+	for _, exp := range filter {
+		v, err := ev.eval(exp)
+		if err != nil {
+			return false, err
+		}
+
+		if keep, _ := v.(bool); !keep {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// planSubquery decides how slct is run for rows of ev's table without
+// running it.
+func (ev *evaluation) planSubquery(slct *parser.SelectStatement, mode subqueryMode) (*subqueryPlan, error) {
+	t, err := ev.subqueryRows(slct)
+	if err != nil {
+		return nil, err
+	}
+
+	nested := &subqueryPlan{kind: subqueryNested, table: t}
+	if ev.hinted(NestedLoopHint, slct) {
+		return nested, nil
+	}
+
+	// The items of an EXISTS are never evaluated, those of other
+	// subqueries are evaluated once per group and can't depend on the
+	// enclosing row
+	for _, item := range slct.Item {
+		if mode != existsSubquery && !item.Asterisk && ev.refs(t, item.Exp)&^innerRefs != 0 {
+			return nested, nil
+		}
+	}
+
+	// Neither can the rows be sorted or cut by the enclosing row
+	for _, order := range slct.OrderBy {
+		if ev.refs(t, order.Exp)&^innerRefs != 0 {
+			return nested, nil
+		}
+	}
+	if ev.refs(t, slct.Limit)&^innerRefs != 0 || ev.refs(t, slct.Offset)&^innerRefs != 0 {
+		return nested, nil
+	}
+
+	plan := &subqueryPlan{kind: subqueryHashed, table: t}
+	for _, exp := range conjuncts(slct.Where) {
+		r := ev.refs(t, exp)
+		switch {
+		case r&otherRefs != 0:
+			return nested, nil
+		case r&outerRefs == 0:
+			plan.residual = append(plan.residual, exp)
+			continue
+		}
+
+		inner, outer, ok := ev.splitEquality(t, exp)
+		if !ok {
+			return nested, nil
+		}
+
+		keyType, ok := types.Common(ev.sub(t, nil).columnType(inner), ev.columnType(outer))
+		if !ok {
+			return nested, nil
+		}
+
+		plan.innerKeys = append(plan.innerKeys, inner)
+		plan.outerKeys = append(plan.outerKeys, outer)
+		plan.keyTypes = append(plan.keyTypes, keyType)
+	}
+
+	if len(plan.innerKeys) == 0 {
+		plan.kind = subqueryConstant
+	}
+
+	return plan, nil
+}
+
+// buildSubquery runs the subquery of a constant or hashed plan. A hashed
+// plan running out of memory budget switches to running nested.
+func (ev *evaluation) buildSubquery(slct *parser.SelectStatement, plan *subqueryPlan, mode subqueryMode) error {
+	if plan.kind == subqueryNested {
+		return nil
+	}
+
+	held := ev.mem.Held()
+	err := ev.buildGroups(slct, plan, mode)
+	if plan.kind == subqueryHashed && errors.Is(err, budget.ErrExceeded) {
+		ev.mem.Release(ev.mem.Held() - held)
+		plan.kind, plan.adapted, plan.groups = subqueryNested, true, nil
+		return nil
+	}
+
+	return err
+}
+
+// buildGroups runs the subquery of a constant or hashed plan.
+func (ev *evaluation) buildGroups(slct *parser.SelectStatement, plan *subqueryPlan, mode subqueryMode) error {
+	rows, err := ev.filter(plan.table, plan.residual, -1)
+	if err != nil {
+		return err
+	}
+
+	if plan.kind == subqueryConstant {
+		plan.value, plan.err = ev.result(slct, plan.table, rows, mode)
+		return nil
+	}
+
+	groups := map[string][][]interface{}{}
+	keys := []string{}
+	for _, row := range rows {
+		sub := ev.sub(plan.table, row)
+		values := make([]interface{}, len(plan.innerKeys))
+		for i, exp := range plan.innerKeys {
+			if values[i], err = sub.eval(exp); err != nil {
+				return err
+			}
+		}
+
+		key, ok := hashKey(values, plan.keyTypes)
+		if !ok {
+			continue
+		}
+
+		size := int64(rowRefSize)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			size += int64(len(key))
+		}
+		if err := ev.mem.Grow(size); err != nil {
+			return err
+		}
+		groups[key] = append(groups[key], row)
+	}
+
+	plan.groups = map[string]interface{}{}
+	for _, key := range keys {
+		result, err := ev.result(slct, plan.table, groups[key], mode)
+		if errors.Is(err, ErrSubqueryRows) {
+			// Only an error if some outer row looks the group up
+			result = err
+		} else if err != nil {
+			return err
+		}
+
+		plan.groups[key] = result
+	}
+
+	plan.missing, err = ev.result(slct, plan.table, nil, mode)
+	return err
+}
+
+// splitEquality returns the sides of exp if it is an equality between an
+// expression of the subquery over t and one of the enclosing query. Columns
+// with a collation compare by more than equal values, so equalities on them
+// are not split.
+func (ev *evaluation) splitEquality(t *memoryTable, exp *parser.Expression) (*parser.Expression, *parser.Expression, bool) {
+	if exp.Type != parser.BinaryType || exp.Binary.Op.Value != "=" {
+		return nil, nil, false
+	}
+
+	a, b := &exp.Binary.A, &exp.Binary.B
+	if a.Type == parser.RowType || b.Type == parser.RowType {
+		return nil, nil, false
+	}
+
+	ra, rb := ev.refs(t, a), ev.refs(t, b)
+	if ra == outerRefs && rb&outerRefs == 0 {
+		a, b = b, a
+	} else if rb != outerRefs || ra&outerRefs != 0 {
+		return nil, nil, false
+	}
+
+	if collated(t, a) || collated(ev.table, b) {
+		return nil, nil, false
+	}
+
+	return a, b, true
+}
+
+func collated(t *memoryTable, exp *parser.Expression) bool {
+	if t == nil || exp.Type != parser.ColumnRefType {
+		return false
+	}
+
+	i, ok := t.columnIndex(exp.Column.Value)
+	return ok && t.collations[i] != nil
+}
+
+// refs returns the scopes exp refers to when it is evaluated in a subquery
+// over t enclosed by ev.
+func (ev *evaluation) refs(t *memoryTable, exp *parser.Expression) refs {
+	if exp == nil {
+		return 0
+	}
+
+	var r refs
+	switch exp.Type {
+	case parser.ColumnRefType:
+		if _, ok := t.columnIndex(exp.Column.Value); ok {
+			return innerRefs
+		}
+		if ev.table != nil {
+			if _, ok := ev.table.columnIndex(exp.Column.Value); ok {
+				return outerRefs
+			}
+		}
+		return otherRefs
+	case parser.ExistsType, parser.SubqueryType:
+		return otherRefs
+	case parser.BinaryType:
+		r = ev.refs(t, &exp.Binary.A) | ev.refs(t, &exp.Binary.B)
+	case parser.CastType:
+		r = ev.refs(t, &exp.Cast.Exp)
+	case parser.IndexType:
+		r = ev.refs(t, &exp.Index.Exp) | ev.refs(t, &exp.Index.Index)
+	case parser.CallType:
+		for i := range exp.Call.Args {
+			r |= ev.refs(t, &exp.Call.Args[i])
+		}
+	case parser.ArrayType:
+		for i := range exp.Array {
+			r |= ev.refs(t, &exp.Array[i])
+		}
+	case parser.RowType:
+		for i := range exp.Row {
+			r |= ev.refs(t, &exp.Row[i])
+		}
+	case parser.InType:
+		r = ev.refs(t, &exp.In.Exp)
+		for i := range exp.In.List {
+			r |= ev.refs(t, &exp.In.List[i])
+		}
+	case parser.NotType:
+		r = ev.refs(t, exp.Not)
+	}
+
+	return r
+}
+
+// conjuncts splits exp into the expressions joined by AND at its top.
+func conjuncts(exp *parser.Expression) []*parser.Expression {
+	if exp == nil {
+		return nil
+	}
+
+	if exp.Type == parser.BinaryType && exp.Binary.Op.Value == "and" {
+		return append(conjuncts(&exp.Binary.A), conjuncts(&exp.Binary.B)...)
+	}
+
+	return []*parser.Expression{exp}
+}
+
+// hashKey encodes values converted to ts so that values comparing equal
+// have equal keys. It returns false if any value is NULL.
+func hashKey(values []interface{}, ts []ColumnType) (string, bool) {
+	var b strings.Builder
+	for i, v := range values {
+		if v == nil {
+			return "", false
+		}
+
+		if converted, ok := types.Assign(v, ts[i]); ok {
+			v = converted
+		}
+
+		switch v := v.(type) {
+		case string:
+			b.WriteString(strconv.Quote(v))
+		case time.Time:
+			b.WriteString(v.UTC().Format(time.RFC3339Nano))
+		case types.Array:
+			b.WriteString(strconv.Quote(v.String()))
+		default:
+			fmt.Fprintf(&b, "%T:%v", v, v)
+		}
+		b.WriteByte(0)
+	}
+
+	return b.String(), true
+}
@@ -0,0 +1,224 @@
+package backend
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql/budget"
+)
+
+// TestDecorrelatedSubqueries checks that subqueries turned into joins return
+// what running them again for every row returns, NULLs included.
+func TestDecorrelatedSubqueries(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		// plan is part of how EXPLAIN says the subquery runs unhinted
+		plan string
+		rows [][]interface{}
+		err  error
+	}{
+		{
+			"exists",
+			"select id from t where exists (select 1 from u where tid = id)",
+			"Hash semi-join", [][]interface{}{{int64(1)}, {int64(3)}}, nil,
+		},
+		{
+			"not exists",
+			"select id from t where not exists (select 1 from u where tid = id)",
+			"Hash anti-join", [][]interface{}{{int64(2)}}, nil,
+		},
+		{
+			"in",
+			"select id from t where id in (select tid from u)",
+			"Run once", [][]interface{}{{int64(1)}, {int64(3)}}, nil,
+		},
+		{
+			// u has a NULL tid, so no id is known to be missing from it
+			"not in with null",
+			"select id from t where id not in (select tid from u)",
+			"Run once", nil, nil,
+		},
+		{
+			// Name a has tids 1 and 1, b only NULL and c none
+			"correlated in",
+			"select id from t where id in (select tid from u where tname = name)",
+			"Hash lookup", [][]interface{}{{int64(1)}}, nil,
+		},
+		{
+			// NOT IN of an empty set is true and of a set holding NULL is
+			// NULL
+			"correlated not in with null",
+			"select id from t where id not in (select tid from u where tname = name)",
+			"Hash lookup", [][]interface{}{{int64(3)}}, nil,
+		},
+		{
+			// The NULL score of id 2 isn't in the empty set of its tids
+			"null not in empty",
+			"select id from t where score not in (select v from u where tid = id)",
+			"Hash lookup", [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}, nil,
+		},
+		{
+			"float in int",
+			"select id from t where score * 2 in (select v from u where tid = id)",
+			"Hash lookup", [][]interface{}{{int64(3)}}, nil,
+		},
+		{
+			"scalar aggregate",
+			"select id, (select sum(v) from u where tid = id) from t",
+			"Hash join on grouped aggregate",
+			[][]interface{}{{int64(1), int64(30)}, {int64(2), nil}, {int64(3), int64(9)}}, nil,
+		},
+		{
+			// Ids without any row count 0 rather than NULL
+			"scalar count",
+			"select id, (select count(*) from u where tid = id) from t",
+			"Hash join on grouped aggregate",
+			[][]interface{}{{int64(1), int64(2)}, {int64(2), int64(0)}, {int64(3), int64(1)}}, nil,
+		},
+		{
+			"scalar",
+			"select id, (select v from u where tid = id and v > 10) from t",
+			"Hash lookup",
+			[][]interface{}{{int64(1), int64(20)}, {int64(2), nil}, {int64(3), nil}}, nil,
+		},
+		{
+			"scalar with more rows",
+			"select id, (select v from u where tid = id) from t",
+			"Hash lookup", nil, ErrSubqueryRows,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hinted := strings.Replace(tt.query, "select", "select /*+ nested_loop(u) */", 1)
+			for _, query := range []string{tt.query, hinted} {
+				mb, session := testBackend(t)
+				for _, setup := range []string{
+					"create table u (tid int, tname text, v int)",
+					"insert into u values (1, 'a', 10)",
+					"insert into u values (1, 'a', 20)",
+					"insert into u values (3, 'x', 9)",
+					"insert into u values (null, 'b', 7)",
+					"insert into u values (4, null, 1)",
+				} {
+					if _, err := run(mb, session, setup); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				plan, err := run(mb, session, "explain "+query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := tt.plan
+				if query == hinted {
+					want = "Run for every row as hinted"
+				}
+				if !explains(plan.Rows, want) {
+					t.Errorf("%s: plan %v lacks %q", query, plan.Rows, want)
+				}
+
+				results, err := run(mb, session, query)
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Errorf("%s: got error %v, want %v", query, err, tt.err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+				if !reflect.DeepEqual(results.Rows, tt.rows) {
+					t.Errorf("%s: got %v, want %v", query, results.Rows, tt.rows)
+				}
+			}
+		})
+	}
+}
+
+// explains reports whether one of the lines of an EXPLAIN holds text.
+func explains(lines [][]interface{}, text string) bool {
+	for _, line := range lines {
+		if s, ok := line[0].(string); ok && strings.Contains(s, text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TestSubqueryOverBudget checks that hashed subqueries outgrowing the memory
+// budget run nested instead, returning what they return with no budget.
+func TestSubqueryOverBudget(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		// rows are returned with no budget, and with one unless err is set
+		rows [][]interface{}
+		err  error
+	}{
+		{
+			"scalar aggregate",
+			"select id, (select count(*) from u where tid = id) from t",
+			[][]interface{}{{int64(1), int64(10)}, {int64(2), int64(10)}, {int64(3), int64(10)}}, nil,
+		},
+		{
+			"exists",
+			"select id from t where exists (select 1 from u where tid = id and v > 90)",
+			[][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}, nil,
+		},
+		{
+			"correlated in",
+			"select id from t where id in (select tid from u where v = id * 11)",
+			[][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}, nil,
+		},
+		{
+			// Only hashed plans adapt, a subquery run once has to hold all
+			// of its rows
+			"run once",
+			"select id from t where id in (select tid from u)",
+			[][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}, budget.ErrExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+			if _, err := run(mb, session, "create table u (tid int, v int)"); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				if _, err := run(mb, session, "insert into u values ($1, $2)", int64(i%10), int64(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			unlimited, err := run(mb, session, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(unlimited.Rows, tt.rows) {
+				t.Fatalf("got %v with no budget, want %v", unlimited.Rows, tt.rows)
+			}
+
+			// Enough for the rows of one id, not for all of u
+			mb.SetMemoryBudget(1000)
+			results, err := run(mb, session, tt.query)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("got error %v, want %v", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("got %v, want %v", results.Rows, tt.rows)
+			}
+			if used := mb.budget.Used(); used != 0 {
+				t.Errorf("%d bytes still reserved", used)
+			}
+		})
+	}
+}
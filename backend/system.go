@@ -0,0 +1,584 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql/budget"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+var ErrSystemTable = errors.New("System tables can't be created or modified")
+
+// systemPrefix starts the names of system tables, which user tables can't
+// have.
+const systemPrefix = "__"
+
+// systemTable is a table whose rows are computed from the state of the
+// backend every time it is queried.
+type systemTable struct {
+	columns []Column
+	// rows is called with mb.mu held
+	rows func(mb *MemoryBackend) [][]interface{}
+	// policy is a condition a row must meet to be seen, like the policies
+	// of a table, empty when every row may be seen
+	policy string
+	// describes is the column naming the table each row tells about, like
+	// how many rows it has, empty for rows about no table. Only the
+	// superuser sees the rows about tables with policies, which would tell
+	// others about the rows the policies hide.
+	describes string
+}
+
+var systemTables = map[string]systemTable{
+	// __table_stats has a row for every table. Sizes are estimates of the
+	// memory the values take, and the times are NULL for what hasn't
+	// happened since the database was opened.
+	"__table_stats": {
+		describes: "table_name",
+		columns: []Column{
+			{Name: "table_name", Type: TextType},
+			{Name: "row_count", Type: IntType},
+			{Name: "size_bytes", Type: IntType},
+			{Name: "last_vacuum", Type: TimestampType},
+			{Name: "last_analyze", Type: TimestampType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			rows := [][]interface{}{}
+			for _, name := range mb.tableNames() {
+				t := mb.tables[name]
+				rows = append(rows, []interface{}{
+					name, int64(t.store.Len()), tableSize(t), nullTime(mb.lastVacuum), lastAnalyze(t),
+				})
+			}
+			return rows
+		},
+	},
+	// __sessions has a row for every open session, with the query it is
+	// running or waiting to run if it isn't idle. Users only see their own
+	// sessions, the superuser sees all of them.
+	"__sessions": {
+		policy: "user_name = current_user or current_user = '" + functions.DefaultUser + "'",
+		columns: []Column{
+			{Name: "session_id", Type: IntType},
+			{Name: "user_name", Type: TextType},
+			{Name: "state", Type: TextType},
+			{Name: "query", Type: TextType},
+			{Name: "connected_at", Type: TimestampType},
+			{Name: "query_start", Type: TimestampType},
+			{Name: "runtime", Type: IntervalType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			rows := [][]interface{}{}
+			if mb.sessions == nil {
+				return rows
+			}
+
+			now := time.Now().UTC()
+			for _, s := range mb.sessions() {
+				if s.Query == "" {
+					rows = append(rows, []interface{}{s.ID, s.User, "idle", nil, s.Connected, nil, nil})
+					continue
+				}
+
+				state := "active"
+				if s.Waiting {
+					state = "waiting"
+				}
+
+				runtime := types.IntervalValue{Duration: now.Sub(s.Started)}
+				rows = append(rows, []interface{}{s.ID, s.User, state, s.Query, s.Connected, s.Started, runtime})
+			}
+			return rows
+		},
+	},
+	// __audit_log has a row for every DDL, GRANT and admin statement run
+	// since the database was opened, oldest first. error is NULL for the
+	// ones that succeeded.
+	"__audit_log": {
+		columns: []Column{
+			{Name: "logged_at", Type: TimestampType},
+			{Name: "session_id", Type: IntType},
+			{Name: "user_name", Type: TextType},
+			{Name: "statement", Type: TextType},
+			{Name: "error", Type: TextType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			rows := [][]interface{}{}
+			if mb.audit == nil {
+				return rows
+			}
+
+			for _, e := range mb.audit() {
+				var err interface{}
+				if e.Error != "" {
+					err = e.Error
+				}
+				rows = append(rows, []interface{}{e.At, e.SessionID, e.User, e.Statement, err})
+			}
+			return rows
+		},
+	},
+	// __prepared_xacts has a row for the transaction prepared with PREPARE
+	// TRANSACTION, if there is one. prepared_at is NULL for a transaction
+	// prepared before the database was last opened.
+	"__prepared_xacts": {
+		columns: []Column{
+			{Name: "transaction_id", Type: TextType},
+			{Name: "prepared_at", Type: TimestampType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			rows := [][]interface{}{}
+			if mb.prepared == nil {
+				return rows
+			}
+
+			for _, p := range mb.prepared() {
+				rows = append(rows, []interface{}{p.ID, nullTime(p.At)})
+			}
+			return rows
+		},
+	},
+	// __jobs has a row for every long-running statement while it runs, like
+	// an ALTER TABLE copying the rows of a large table. session_id is NULL
+	// for statements run without a session.
+	"__jobs": {
+		columns: []Column{
+			{Name: "job_id", Type: IntType},
+			{Name: "session_id", Type: IntType},
+			{Name: "kind", Type: TextType},
+			{Name: "table_name", Type: TextType},
+			{Name: "rows_done", Type: IntType},
+			{Name: "rows_total", Type: IntType},
+			{Name: "started_at", Type: TimestampType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			return mb.runningJobs()
+		},
+	},
+	// __index_stats has a row for every index. last_analyze is when its
+	// table was last analyzed.
+	"__index_stats": {
+		describes: "table_name",
+		columns: []Column{
+			{Name: "index_name", Type: TextType},
+			{Name: "table_name", Type: TextType},
+			{Name: "entries", Type: IntType},
+			{Name: "size_bytes", Type: IntType},
+			{Name: "last_analyze", Type: TimestampType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			return mb.indexStats()
+		},
+	},
+	// __column_stats has a row for every column of the analyzed tables
+	// with what ANALYZE collected of it.
+	"__column_stats": {
+		describes: "table_name",
+		columns: []Column{
+			{Name: "table_name", Type: TextType},
+			{Name: "column_name", Type: TextType},
+			{Name: "distinct_values", Type: IntType},
+			{Name: "null_values", Type: IntType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			return mb.columnStatsRows()
+		},
+	},
+	// __statistics has a row for every statistics defined over several
+	// columns. What ANALYZE collected is NULL until the table is analyzed,
+	// and for kinds the statistics don't collect.
+	"__statistics": {
+		describes: "table_name",
+		columns: []Column{
+			{Name: "statistics_name", Type: TextType},
+			{Name: "table_name", Type: TextType},
+			{Name: "columns", Type: types.ArrayOf(TextType)},
+			{Name: "distinct_values", Type: IntType},
+			{Name: "dependencies", Type: TextType},
+			{Name: "last_analyze", Type: TimestampType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			return mb.statisticsRows()
+		},
+	},
+	// __dependencies has a row for every object depending on a table.
+	// dependency is auto for the indexes, policies and statistics dropped
+	// with the table, and normal for the materialized views reading it,
+	// which DROP TABLE only drops with CASCADE.
+	"__dependencies": {
+		columns: []Column{
+			{Name: "object_type", Type: TextType},
+			{Name: "object_name", Type: TextType},
+			{Name: "table_name", Type: TextType},
+			{Name: "dependency", Type: TextType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			return mb.dependencyRows()
+		},
+	},
+	// __comments has a row for every table and column commented with
+	// COMMENT ON, column_name is NULL for the tables.
+	"__comments": {
+		columns: []Column{
+			{Name: "object_type", Type: TextType},
+			{Name: "table_name", Type: TextType},
+			{Name: "column_name", Type: TextType},
+			{Name: "comment", Type: TextType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			return mb.commentRows()
+		},
+	},
+	// __roles has a row for every role with the users and roles granted
+	// it directly.
+	"__roles": {
+		columns: []Column{
+			{Name: "role_name", Type: TextType},
+			{Name: "members", Type: types.ArrayOf(TextType)},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			return mb.roleRows()
+		},
+	},
+	// __buffer_pool has a row for the buffer pool of the storage engine,
+	// none if the engine reads nothing from a file, see
+	// storage.BufferPool. hit_ratio is NULL until a page is read.
+	"__buffer_pool": {
+		columns: []Column{
+			{Name: "size_bytes", Type: IntType},
+			{Name: "used_bytes", Type: IntType},
+			{Name: "pages", Type: IntType},
+			{Name: "hits", Type: IntType},
+			{Name: "misses", Type: IntType},
+			{Name: "evictions", Type: IntType},
+			{Name: "hit_ratio", Type: FloatType},
+		},
+		rows: func(mb *MemoryBackend) [][]interface{} {
+			pool, ok := mb.engine.(storage.BufferPool)
+			if !ok {
+				return [][]interface{}{}
+			}
+
+			stats := pool.BufferPoolStats()
+			var ratio interface{}
+			if reads := stats.Hits + stats.Misses; reads > 0 {
+				ratio = float64(stats.Hits) / float64(reads)
+			}
+			return [][]interface{}{{
+				stats.Size, stats.Used, int64(stats.Pages), stats.Hits, stats.Misses, stats.Evictions, ratio,
+			}}
+		},
+	},
+}
+
+func isSystemTable(name string) bool {
+	return strings.HasPrefix(name, systemPrefix)
+}
+
+// SystemOnly reports whether slct reads system tables and no others, in
+// its subqueries neither. Such a query sees nothing a transaction changes.
+func SystemOnly(slct *parser.SelectStatement) bool {
+	if slct.From == nil || !isSystemTable(slct.From.Value) {
+		return false
+	}
+
+	var walk func(exp *parser.Expression) bool
+	walk = func(exp *parser.Expression) bool {
+		if exp == nil {
+			return true
+		}
+
+		switch exp.Type {
+		case parser.BinaryType:
+			return walk(&exp.Binary.A) && walk(&exp.Binary.B)
+		case parser.CastType:
+			return walk(&exp.Cast.Exp)
+		case parser.IndexType:
+			return walk(&exp.Index.Exp) && walk(&exp.Index.Index)
+		case parser.ArrayType, parser.RowType:
+			for i := range exp.Array {
+				if !walk(&exp.Array[i]) {
+					return false
+				}
+			}
+			for i := range exp.Row {
+				if !walk(&exp.Row[i]) {
+					return false
+				}
+			}
+		case parser.ExistsType:
+			return SystemOnly(exp.Exists.Query)
+		case parser.SubqueryType:
+			return SystemOnly(exp.Subquery)
+		case parser.NotType:
+			return walk(exp.Not)
+		case parser.InType:
+			if !walk(&exp.In.Exp) {
+				return false
+			}
+			for i := range exp.In.List {
+				if !walk(&exp.In.List[i]) {
+					return false
+				}
+			}
+		case parser.CallType:
+			for i := range exp.Call.Args {
+				if !walk(&exp.Call.Args[i]) {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	for _, item := range slct.Item {
+		if !walk(item.Exp) {
+			return false
+		}
+	}
+
+	if slct.ConnectBy != nil && !walk(slct.ConnectBy.Start) {
+		return false
+	}
+
+	for _, order := range slct.OrderBy {
+		if !walk(order.Exp) {
+			return false
+		}
+	}
+
+	return walk(slct.Where) && walk(slct.Limit) && walk(slct.Offset)
+}
+
+// systemPolicies are the policies of the system tables by their names,
+// parsed once from their conditions.
+var systemPolicies = func() map[string]*policy {
+	policies := map[string]*policy{}
+	for name, sys := range systemTables {
+		if sys.policy == "" {
+			continue
+		}
+
+		ast, err := parser.Parse("SELECT 1 FROM " + name + " WHERE " + sys.policy)
+		if err != nil {
+			panic(fmt.Sprintf("Policy of %s doesn't parse: %s", name, err))
+		}
+		policies[name] = &policy{name: name, using: ast.Statements[0].SelectStatement.Where}
+	}
+
+	return policies
+}()
+
+// systemPolicy returns the policy of the system table sys called name, if
+// it has one. A table whose rows describe other tables gets one hiding the
+// rows about those with policies from everyone but the superuser, which
+// names the tables with policies when the query runs. It must be called
+// with mb.mu held.
+func (mb *MemoryBackend) systemPolicy(name string, sys systemTable) (*policy, bool) {
+	protected := []string{}
+	if sys.describes != "" {
+		for _, table := range mb.tableNames() {
+			if len(mb.tables[table].policies) > 0 {
+				protected = append(protected, "'"+strings.ReplaceAll(table, "'", "''")+"'")
+			}
+		}
+	}
+	if len(protected) == 0 {
+		p, ok := systemPolicies[name]
+		return p, ok
+	}
+
+	condition := sys.describes + " not in (" + strings.Join(protected, ", ") + ") or current_user = '" + functions.DefaultUser + "'"
+	if sys.policy != "" {
+		condition = "(" + sys.policy + ") and (" + condition + ")"
+	}
+
+	ast, err := parser.Parse("SELECT 1 FROM " + name + " WHERE " + condition)
+	if err != nil {
+		panic(fmt.Sprintf("Policy of %s doesn't parse: %s", name, err))
+	}
+	return &policy{name: name, using: ast.Statements[0].SelectStatement.Where}, true
+}
+
+// table returns the table called name, computing the rows of a system
+// table. It must be called with mb.mu held.
+func (mb *MemoryBackend) table(name string) (*memoryTable, bool) {
+	if t, ok := mb.tables[name]; ok {
+		return t, true
+	}
+
+	sys, ok := systemTables[name]
+	if !ok {
+		return nil, false
+	}
+
+	t := &memoryTable{store: storage.NewTable(sys.rows(mb)...)}
+	if p, ok := mb.systemPolicy(name, sys); ok {
+		t.policies = []*policy{p}
+	}
+	for _, col := range sys.columns {
+		t.columns = append(t.columns, col.Name)
+		t.columnTypes = append(t.columnTypes, col.Type)
+		t.collations = append(t.collations, nil)
+	}
+
+	return t, true
+}
+
+// tableNames returns the names of the user tables in order. It must be
+// called with mb.mu held.
+func (mb *MemoryBackend) tableNames() []string {
+	names := make([]string, 0, len(mb.tables))
+	for name := range mb.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// SessionInfo describes an open session for __sessions. Query is what the
+// session has been running since Started, empty when it is idle, and
+// Waiting is set while the query waits for its turn to run.
+type SessionInfo struct {
+	ID        int64
+	User      string
+	Connected time.Time
+	Query     string
+	Started   time.Time
+	Waiting   bool
+}
+
+// SetSessions sets how __sessions finds the open sessions. Without it the
+// table is empty. sessions is called with the backend locked, so it must
+// not run statements.
+func (mb *MemoryBackend) SetSessions(sessions func() []SessionInfo) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.sessions = sessions
+}
+
+// AuditEntry records a DDL, GRANT or admin statement for __audit_log.
+// Error is empty when the statement succeeded.
+type AuditEntry struct {
+	At        time.Time `json:"at"`
+	SessionID int64     `json:"session_id"`
+	User      string    `json:"user"`
+	Statement string    `json:"statement"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// SetAudit sets how __audit_log finds the audited statements. Without it
+// the table is empty. audit is called with the backend locked, so it must
+// not run statements.
+func (mb *MemoryBackend) SetAudit(audit func() []AuditEntry) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.audit = audit
+}
+
+// PreparedInfo describes a prepared transaction for __prepared_xacts. At is
+// when it was prepared, zero if that isn't known.
+type PreparedInfo struct {
+	ID string
+	At time.Time
+}
+
+// SetPrepared sets how __prepared_xacts finds the prepared transactions.
+// Without it the table is empty. prepared is called with the backend
+// locked, so it must not run statements.
+func (mb *MemoryBackend) SetPrepared(prepared func() []PreparedInfo) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.prepared = prepared
+}
+
+// SetMemoryBudget limits the memory statements may buffer at once, like the
+// rows of their results, to limit bytes. Statements needing more fail with
+// an error matching budget.ErrExceeded. A limit that isn't positive removes
+// the limit.
+func (mb *MemoryBackend) SetMemoryBudget(limit int64) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.budget = budget.New(limit)
+}
+
+// Vacuumed records that the database was vacuumed at, for __table_stats.
+func (mb *MemoryBackend) Vacuumed(at time.Time) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.lastVacuum = at
+}
+
+// tableSize estimates the bytes the rows of t take. Rows that can't be read
+// aren't counted.
+func tableSize(t *memoryTable) int64 {
+	var size int64
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		for _, v := range row {
+			size += valueSize(v)
+		}
+	}
+
+	return size
+}
+
+// rowRefSize is what a reference to a row takes.
+const rowRefSize = 24
+
+// rowSize estimates the bytes row takes.
+func rowSize(row []interface{}) int64 {
+	size := int64(rowRefSize)
+	for _, v := range row {
+		size += valueSize(v)
+	}
+
+	return size
+}
+
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case time.Time:
+		return 24
+	case types.UUIDValue:
+		return 16
+	case types.Array:
+		size := int64(0)
+		for _, elem := range v.Values {
+			size += valueSize(elem)
+		}
+		return size
+	}
+
+	return 8
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
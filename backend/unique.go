@@ -0,0 +1,207 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+var ErrUniqueViolation = errors.New("Unique violation")
+
+// unique keeps column of a table from holding a value other than NULL
+// twice. Like a foreign key, a deferred one is only checked when the
+// transaction inserting the rows commits, see CheckDeferred.
+type unique struct {
+	column   string
+	deferred bool
+}
+
+// String describes u like ALTER TABLE adds it.
+func (u *unique) String() string {
+	return "UNIQUE (" + parser.FormatIdentifier(u.column) + ")" + deferral(u.deferred)
+}
+
+// violation is the error of the table called name holding v twice in the
+// column of u.
+func (u *unique) violation(name string, v interface{}) error {
+	return fmt.Errorf("%w: %s.%s = %v is already in the table", ErrUniqueViolation, name, u.column, v)
+}
+
+// deferral is what describes a constraint as deferred, if it is.
+func deferral(deferred bool) string {
+	if deferred {
+		return " DEFERRABLE INITIALLY DEFERRED"
+	}
+
+	return ""
+}
+
+// uniqueOf returns the unique constraint of t over column, nil if there is
+// none.
+func (t *memoryTable) uniqueOf(column string) *unique {
+	for _, u := range t.uniques {
+		if u.column == column {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// newUnique checks that column of t can be made unique as u says and
+// returns the constraint.
+func newUnique(t *memoryTable, column string, u *parser.Unique) (*unique, error) {
+	i, ok := t.columnIndex(column)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrColumnDoesNotExist, column)
+	}
+
+	if t.columnTypes[i].IsArray() {
+		return nil, fmt.Errorf("%w: column %s is an array, arrays can't be unique", ErrInvalidDatatype, column)
+	}
+
+	return &unique{column: column, deferred: u.Deferred}, nil
+}
+
+// checkUnique checks that the unique columns of the table t called name
+// still are once rows are inserted into it. The deferred ones are left to
+// CheckDeferred.
+func checkUnique(name string, t *memoryTable, rows ...storage.Row) error {
+	for _, u := range t.uniques {
+		if u.deferred {
+			continue
+		}
+
+		i, _ := t.columnIndex(u.column)
+		for r, row := range rows {
+			v := row[i]
+			if v == nil {
+				continue
+			}
+
+			found, err := holds(t, i, v)
+			if err != nil {
+				return err
+			}
+			for _, before := range rows[:r] {
+				found = found || (before[i] != nil && compareKeys(before[i], v) == 0)
+			}
+
+			if found {
+				return u.violation(name, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// check checks that u holds for every row of the table t called name.
+func (u *unique) check(name string, t *memoryTable) error {
+	i, _ := t.columnIndex(u.column)
+	v, found, err := duplicate(t, i)
+	if err != nil {
+		return err
+	}
+	if found {
+		return u.violation(name, v)
+	}
+
+	return nil
+}
+
+// duplicate returns a value other than NULL the column at i of t holds
+// more than once, and false if there is none.
+func duplicate(t *memoryTable, i int) (interface{}, bool, error) {
+	values := []interface{}{}
+	scan := t.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if row[i] != nil {
+			values = append(values, row[i])
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, false, err
+	}
+
+	sort.Slice(values, func(a, b int) bool { return compareKeys(values[a], values[b]) < 0 })
+	for j := 1; j < len(values); j++ {
+		if compareKeys(values[j-1], values[j]) == 0 {
+			return values[j], true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// addUnique makes a column of t, the table called name, unique as ALTER
+// TABLE ADD UNIQUE does, once no value is held twice. It must be called with
+// mb.mu held for writing.
+func (mb *MemoryBackend) addUnique(name string, t *memoryTable, constraint *parser.TableConstraint) error {
+	u, err := newUnique(t, constraint.Column.Value, constraint.Unique)
+	if err != nil {
+		return err
+	}
+
+	if t.uniqueOf(u.column) != nil {
+		return fmt.Errorf("Column %s is already unique", u.column)
+	}
+
+	if err := u.check(name, t); err != nil {
+		return err
+	}
+
+	constrained := *t
+	constrained.uniques = append(append([]*unique{}, t.uniques...), u)
+	mb.tables[name] = &constrained
+	return nil
+}
+
+// CheckDeferred checks the deferred constraints of the tables changed since
+// s was taken, as the transaction s was taken for must meet them once it
+// commits. Only the tables changed can have rows breaking them, the rows of
+// those referenced can't be removed while a foreign key references them.
+func (mb *MemoryBackend) CheckDeferred(s *MemorySnapshot) error {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	for _, name := range mb.tableNames() {
+		if version, ok := s.versions[name]; ok && version == mb.versions[name] {
+			continue
+		}
+
+		t := mb.tables[name]
+		for _, fk := range t.foreignKeys {
+			if fk.deferred {
+				if err := mb.checkReferences(name, t, fk); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, u := range t.uniques {
+			if u.deferred {
+				if err := u.check(name, t); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// dumpUniques returns the statements adding the unique constraints of the
+// table t called name. Like those of dumpForeignKeys they come after the
+// rows, which are then checked once rather than row by row.
+func dumpUniques(name string, t *memoryTable) []DumpStatement {
+	stmts := make([]DumpStatement, len(t.uniques))
+	for i, u := range t.uniques {
+		stmts[i] = DumpStatement{Query: "ALTER TABLE " + parser.FormatIdentifier(name) + " ADD " + u.String()}
+	}
+
+	return stmts
+}
@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnique(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		fails   bool
+		err     error
+	}{
+		{"distinct", []string{"insert into u values (4, 'd')"}, false, nil},
+		{"null", []string{"insert into u values (null, 'd')", "insert into u values (null, 'e')"}, false, nil},
+		{"duplicate", []string{"insert into u values (2, 'd')"}, true, ErrUniqueViolation},
+		{"indexed", []string{"create index on u (id)", "insert into u values (4, 'd')", "insert into u values (4, 'e')"}, true, ErrUniqueViolation},
+		{"deferred", []string{"create table d (id int unique deferrable initially deferred)", "insert into d values (1)", "insert into d values (1)"}, false, nil},
+		{"add", []string{"alter table u add unique (name)", "insert into u values (4, 'a')"}, true, ErrUniqueViolation},
+		{"add violated", []string{"insert into u values (4, 'a')", "alter table u add unique (name)"}, true, ErrUniqueViolation},
+		{"add twice", []string{"alter table u add unique (id)"}, true, nil},
+		{"add column", []string{"alter table u add column code text unique", "insert into u values (4, 'd', 'x')", "insert into u values (5, 'e', 'x')"}, true, ErrUniqueViolation},
+		{"array", []string{"create table a (tags text[] unique)"}, true, ErrInvalidDatatype},
+		{"drop column", []string{"alter table u drop column id", "alter table u add column id int", "insert into u values ('d', 1)", "insert into u values ('e', 1)"}, false, nil},
+		{"change type", []string{"alter table u alter column id type float"}, true, nil},
+		{"renamed", []string{"alter table u rename column id to key", "insert into u values (1, 'd')"}, true, ErrUniqueViolation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+			for _, query := range []string{
+				"create table u (id int unique, name text)",
+				"insert into u values (1, 'a')",
+				"insert into u values (2, 'b')",
+				"insert into u values (3, 'c')",
+			} {
+				if _, err := run(mb, session, query); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			var err error
+			for _, query := range tt.queries {
+				if _, err = run(mb, session, query); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.fails || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckDeferred(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		err     error
+	}{
+		{"referenced later", []string{"insert into c values (4)", "insert into t values (4, 'd', null)"}, nil},
+		{"never referenced", []string{"insert into c values (4)"}, ErrForeignKey},
+		{"unique", []string{"insert into c values (1)", "insert into c values (2)"}, nil},
+		{"not unique", []string{"insert into c values (1)", "insert into c values (1)"}, ErrUniqueViolation},
+		{"other tables", []string{"insert into t values (4, 'd', null)"}, nil},
+		{"created", []string{"create table e (id int unique initially deferred)", "insert into e values (1)", "insert into e values (1)"}, ErrUniqueViolation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+			if _, err := run(mb, session, "create table c (tid int references t (id) deferrable initially deferred unique initially deferred)"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := run(mb, session, "insert into c values (3)"); err != nil {
+				t.Fatal(err)
+			}
+
+			s := mb.Snapshot()
+			for _, query := range tt.queries {
+				if _, err := run(mb, session, query); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			if err := mb.CheckDeferred(s); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package backend
+
+import "fmt"
+
+// VerifyIndexes checks that every index holds exactly the rows of its
+// table, by indexing them again from scratch and comparing. It returns a
+// description of every index that doesn't, which can only be the fault of
+// keeping it up to date as rows are written.
+func (mb *MemoryBackend) VerifyIndexes() []string {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	problems := []string{}
+	for _, name := range mb.tableNames() {
+		for _, idx := range mb.tables[name].indexes {
+			if !idx.live {
+				continue
+			}
+
+			fresh := *idx
+			if err := fresh.rebuild(); err != nil {
+				problems = append(problems, fmt.Sprintf("Index %s on %s can't be built again: %v", idx.name, name, err))
+				continue
+			}
+
+			have, want := idx.entries(), fresh.entries()
+			if len(have) != len(want) {
+				problems = append(problems, fmt.Sprintf("Index %s on %s holds %d entries, its table %d", idx.name, name, len(have), len(want)))
+				continue
+			}
+
+			for i := range have {
+				if have[i].id != want[i].id || compareKeys(have[i].key, want[i].key) != 0 {
+					problems = append(problems, fmt.Sprintf("Index %s on %s doesn't match the rows of its table at row %d", idx.name, name, want[i].id))
+					break
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+// entries returns what idx holds in order.
+func (idx *index) entries() []indexEntry {
+	entries := []indexEntry{}
+	idx.tree.ascendAll(func(e indexEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+
+	return entries
+}
@@ -0,0 +1,372 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+var (
+	ErrNotMaterializedView = errors.New("Table is not a materialized view")
+	ErrMaterializedView    = errors.New("Materialized views can only be changed with REFRESH")
+	ErrTableInUse          = errors.New("Table is read by a materialized view")
+)
+
+// materializedView is the query a materialized view holds the results of.
+type materializedView struct {
+	query *parser.SelectStatement
+	// reads are the tables the query reads, which can't be dropped or have
+	// their columns changed while the view exists
+	reads []string
+	// seen is how many rows of the table an incrementally maintained view
+	// reads its rows were computed from, see viewDelta
+	seen  int
+	delta *viewDelta
+}
+
+// viewDelta is what a view that filters or aggregates the rows of a single
+// table needs to be refreshed from the rows appended to the table since,
+// rather than from all of them. Rows are only ever appended to a table,
+// tables changed in other ways are rebuilt into a new store.
+//
+// A refresh creates a new materializedView sharing the delta, so a view
+// restored by a rollback has seen fewer rows than its delta and is refreshed
+// in full instead.
+type viewDelta struct {
+	// source is the store of the table the view reads
+	source storage.Table
+	seen   int
+	// agg holds the aggregates of an aggregating view over the rows seen,
+	// it is nil for a view that filters
+	agg *aggregation
+}
+
+// CreateMaterializedView creates a table holding the results of a query,
+// which are computed again by RefreshMaterializedView. Queries read the
+// view like any other table, so they see the results as of its last
+// refresh.
+func (mb *MemoryBackend) CreateMaterializedView(ctx context.Context, crt *parser.CreateMaterializedViewStatement, session *functions.Session) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	name := crt.Name.Value
+	if isSystemTable(name) {
+		return ErrSystemTable
+	}
+
+	if _, ok := mb.tables[name]; ok {
+		return ErrTableAlreadyExists
+	}
+
+	// Refreshing the view must not change anything but the view
+	if queryModifies(crt.Query) {
+		return errors.New("Materialized views can't call functions that change the database")
+	}
+
+	// System and external tables don't hold the same rows when the log is
+	// replayed, external ones may not even be readable then. Tables with
+	// policies or masks show different rows to the session replaying the
+	// log.
+	view := &materializedView{query: crt.Query, reads: queryTables(crt.Query)}
+	for _, table := range view.reads {
+		if isSystemTable(table) {
+			return errors.New("Materialized views can't read system tables")
+		}
+		if mb.readsExternal([]string{table}) {
+			return errors.New("Materialized views can't read external tables")
+		}
+		if t, ok := mb.tables[table]; ok && len(t.policies) > 0 {
+			return errors.New("Materialized views can't read tables with policies")
+		}
+		if t, ok := mb.tables[table]; ok && len(t.masks) > 0 {
+			return errors.New("Materialized views can't read tables with masked columns")
+		}
+		if _, ok := mb.tables[table]; !ok {
+			if _, ok := attachedTable(ctx, table); ok {
+				return errors.New("Materialized views can't read the tables of attached databases")
+			}
+		}
+	}
+
+	return mb.materialize(ctx, name, view, session)
+}
+
+// RefreshMaterializedView replaces the rows of a materialized view with the
+// current results of its query.
+func (mb *MemoryBackend) RefreshMaterializedView(ctx context.Context, refresh *parser.RefreshStatement, session *functions.Session) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	t, ok := mb.tables[refresh.Name.Value]
+	if !ok {
+		return ErrTableDoesNotExist
+	}
+
+	if t.view == nil {
+		return fmt.Errorf("%w: %s", ErrNotMaterializedView, refresh.Name.Value)
+	}
+
+	return mb.materialize(ctx, refresh.Name.Value, t.view, session)
+}
+
+// materialize runs the query of view and stores its results as the table
+// called name, replacing the table if there is one. The columns are those
+// of the results, so a view selecting * picks up columns added to the
+// table it reads. It must be called with mb.mu held for writing.
+func (mb *MemoryBackend) materialize(ctx context.Context, name string, view *materializedView, session *functions.Session) error {
+	tables := []string{}
+	if view.query.From != nil && view.query.ConnectBy == nil && !isOrdered(view.query) && cacheable(view.query, &tables) && len(tables) == 1 {
+		return mb.materializeDelta(ctx, name, view, session)
+	}
+
+	results, err := mb.selectRows(ctx, view.query, session, nil)
+	if err != nil {
+		return err
+	}
+
+	return mb.storeView(name, view, results, false)
+}
+
+// materializeDelta is materialize for a view whose results only depend on
+// the rows of the one table it reads, which runs its query over the rows
+// appended to the table since it was last refreshed. Views that filter get
+// the new results appended, views that aggregate get the new aggregates.
+func (mb *MemoryBackend) materializeDelta(ctx context.Context, name string, view *materializedView, session *functions.Session) error {
+	source := mb.tables[view.query.From.Value]
+	_, exists := mb.tables[name]
+
+	delta := view.delta
+	if !exists || delta == nil || delta.seen != view.seen || delta.source != source.store {
+		delta = &viewDelta{source: source.store}
+		if isAggregate(view.query) {
+			var err error
+			if delta.agg, err = newAggregation(view.query); err != nil {
+				return err
+			}
+		}
+		view = &materializedView{query: view.query, reads: view.reads}
+		exists = false
+	}
+
+	rows := make([]storage.Row, 0, source.store.Len()-view.seen)
+	skip := view.seen
+	scan := source.store.Scan()
+	for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := scan.Err(); err != nil {
+		return err
+	}
+
+	appended := *source
+	appended.store = storage.NewTable(rows...)
+
+	// Until the rows are added the aggregates match no view, in case adding
+	// them fails halfway
+	delta.seen = -1
+	results, err := mb.selectFrom(ctx, view.query, &appended, session, nil, delta.agg)
+	if err != nil {
+		return err
+	}
+	delta.seen = view.seen + len(rows)
+
+	refreshed := &materializedView{query: view.query, reads: view.reads, seen: delta.seen, delta: delta}
+	return mb.storeView(name, refreshed, results, exists && delta.agg == nil)
+}
+
+// storeView stores results as the rows of the view called name, appending
+// them to its rows when add is set and replacing them otherwise. It must be
+// called with mb.mu held for writing.
+func (mb *MemoryBackend) storeView(name string, view *materializedView, results *Results, add bool) error {
+	t := memoryTable{view: view}
+	for _, col := range results.Columns {
+		if _, ok := t.columnIndex(col.Name); ok {
+			return fmt.Errorf("Column %s already exists, name the columns of the view with AS", col.Name)
+		}
+
+		t.columns = append(t.columns, col.Name)
+		t.columnTypes = append(t.columnTypes, col.Type)
+		t.collations = append(t.collations, nil)
+	}
+
+	if add {
+		t.store = mb.tables[name].store
+	} else {
+		if _, ok := mb.tables[name]; ok {
+			if err := mb.engine.DropTable(name); err != nil {
+				return err
+			}
+		}
+
+		store, err := mb.engine.CreateTable(name)
+		if err != nil {
+			return err
+		}
+		t.store = store
+	}
+
+	if err := t.store.Insert(results.Rows...); err != nil {
+		return err
+	}
+
+	if old, ok := mb.tables[name]; ok {
+		keepComments(old, &t)
+	}
+
+	mb.tables[name] = &t
+	mb.changed(name)
+	return nil
+}
+
+// readBy returns the materialized view reading table, if there is one. It
+// must be called with mb.mu held.
+func (mb *MemoryBackend) readBy(table string) (string, bool) {
+	for _, name := range mb.tableNames() {
+		if view := mb.tables[name].view; view != nil && name != table {
+			for _, read := range view.reads {
+				if read == table {
+					return name, true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Kind returns the kind of table, and the tables it reads when it is a
+// materialized view. It implements KindCatalog.
+func (mb *MemoryBackend) Kind(table string) (TableKind, []string) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	t, ok := mb.tables[table]
+	switch {
+	case !ok:
+		return PlainTable, nil
+	case t.view != nil:
+		return MaterializedView, append([]string{}, t.view.reads...)
+	case t.external != nil:
+		return ExternalTable, nil
+	}
+
+	return PlainTable, nil
+}
+
+// queryTables returns the names of the tables slct and its subqueries read.
+func queryTables(slct *parser.SelectStatement) []string {
+	tables := []string{}
+	seen := map[string]bool{}
+
+	var query func(slct *parser.SelectStatement)
+	var walk func(exp *parser.Expression)
+	query = func(slct *parser.SelectStatement) {
+		if slct.From != nil && !seen[slct.From.Value] {
+			seen[slct.From.Value] = true
+			tables = append(tables, slct.From.Value)
+		}
+
+		for _, item := range slct.Item {
+			if !item.Asterisk {
+				walk(item.Exp)
+			}
+		}
+		if slct.Function != nil {
+			walk(slct.Function)
+		}
+		if slct.Where != nil {
+			walk(slct.Where)
+		}
+		if slct.ConnectBy != nil && slct.ConnectBy.Start != nil {
+			walk(slct.ConnectBy.Start)
+		}
+		for _, order := range slct.OrderBy {
+			walk(order.Exp)
+		}
+	}
+	walk = func(exp *parser.Expression) {
+		switch exp.Type {
+		case parser.BinaryType:
+			walk(&exp.Binary.A)
+			walk(&exp.Binary.B)
+		case parser.CastType:
+			walk(&exp.Cast.Exp)
+		case parser.IndexType:
+			walk(&exp.Index.Exp)
+			walk(&exp.Index.Index)
+		case parser.CallType:
+			for i := range exp.Call.Args {
+				walk(&exp.Call.Args[i])
+			}
+		case parser.ArrayType:
+			for i := range exp.Array {
+				walk(&exp.Array[i])
+			}
+		case parser.RowType:
+			for i := range exp.Row {
+				walk(&exp.Row[i])
+			}
+		case parser.InType:
+			walk(&exp.In.Exp)
+			for i := range exp.In.List {
+				walk(&exp.In.List[i])
+			}
+		case parser.NotType:
+			walk(exp.Not)
+		case parser.ExistsType:
+			query(exp.Exists.Query)
+		case parser.SubqueryType:
+			query(exp.Subquery)
+		}
+	}
+
+	query(slct)
+	return tables
+}
+
+// dumpViews returns the statements recreating the materialized views of mb,
+// each after the views it reads. Like pg_dump, the views are created from
+// their queries, so they hold the current results of them once the
+// statements have run. It must be called with mb.mu held.
+func (mb *MemoryBackend) dumpViews() []DumpStatement {
+	stmts := []DumpStatement{}
+	created := map[string]bool{}
+	for name, t := range mb.tables {
+		if t.view == nil {
+			created[name] = true
+		}
+	}
+
+	for progress := true; progress; {
+		progress = false
+		for _, name := range mb.tableNames() {
+			t := mb.tables[name]
+			if created[name] {
+				continue
+			}
+
+			ready := true
+			for _, read := range t.view.reads {
+				ready = ready && created[read]
+			}
+			if !ready {
+				continue
+			}
+
+			stmts = append(stmts, DumpStatement{
+				Query: "CREATE MATERIALIZED VIEW " + parser.FormatIdentifier(name) + " AS " + t.view.query.String(),
+			})
+			created[name], progress = true, true
+		}
+	}
+
+	return stmts
+}
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestMaterializedViewRefresh checks that views refreshed from the rows
+// appended since their last refresh hold what running their query holds.
+func TestMaterializedViewRefresh(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		// incremental is whether refreshes read only the appended rows
+		incremental bool
+	}{
+		{"filter", "select id, name from t where score > 1", true},
+		{"expressions", "select id * 2 as double, coalesce(score, 0) as score from t", true},
+		{"aggregate", "select count(*) as n, sum(score) as total, max(id) as last, min(name) as first from t", true},
+		{"filtered aggregate", "select count(*) as n, sum(id) as total from t where score > 1", true},
+		{"star", "select * from t", true},
+		// Views reading more than one table are computed from every row
+		{"subquery", "select id from t where id not in (select tid from u)", false},
+	}
+
+	// Each step runs before a refresh, restoring a snapshot when rollback
+	// is set
+	steps := []struct {
+		queries  []string
+		rollback bool
+	}{
+		{nil, false},
+		{[]string{"insert into t values (4, 'd', 0.5)", "insert into t values (5, 'e', 5.5)"}, false},
+		{[]string{"insert into t values (6, 'f', 9.5)"}, true},
+		{[]string{"insert into t values (7, 'g', null)"}, false},
+		{[]string{"alter table t rename column name to label", "insert into t values (8, 'h', 2.5)",
+			"alter table t rename column label to name"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb, session := testBackend(t)
+			for _, query := range []string{
+				"create table u (tid int)",
+				"insert into u values (2)",
+				"create materialized view v as " + tt.query,
+			} {
+				if _, err := run(mb, session, query); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			for i, step := range steps {
+				snapshot := mb.Snapshot()
+				for _, query := range step.queries {
+					if _, err := run(mb, session, query); err != nil {
+						t.Fatalf("%s: %v", query, err)
+					}
+				}
+				if _, err := run(mb, session, "refresh materialized view v"); err != nil {
+					t.Fatal(err)
+				}
+				if incremental := mb.tables["v"].view.delta != nil; incremental != tt.incremental {
+					t.Errorf("step %d refreshed incrementally %v, want %v", i, incremental, tt.incremental)
+				}
+				if step.rollback {
+					mb.Restore(snapshot)
+				}
+
+				want, err := run(mb, session, tt.query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if step.rollback {
+					// The view is as it was before the step, refresh it again
+					if _, err := run(mb, session, "refresh materialized view v"); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				got, err := run(mb, session, "select * from v")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got.Rows, want.Rows) {
+					t.Errorf("after step %d, v holds %v, want %v", i, got.Rows, want.Rows)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Package budget accounts for the memory queries hold while they run, so a
+// query that would need more than the database is allowed to use fails
+// instead of taking the process down. Operators buffering rows reserve the
+// memory before holding on to it:
+//
+//	r := acc.Reserve()
+//	defer r.Close()
+//	for ... {
+//		if err := r.Grow(size); err != nil {
+//			return err
+//		}
+//		rows = append(rows, row)
+//	}
+//
+// Sizes are estimates, the budget is a bound on what queries buffer rather
+// than on the memory the process uses.
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var ErrExceeded = errors.New("Out of memory budget")
+
+// Accountant keeps track of the memory reserved against a limit. A nil
+// Accountant has no limit and keeps no track.
+type Accountant struct {
+	limit int64
+	used  int64
+}
+
+// New returns an accountant allowing limit bytes to be reserved at once, or
+// any amount when limit isn't positive.
+func New(limit int64) *Accountant {
+	return &Accountant{limit: limit}
+}
+
+// Limit returns the bytes that can be reserved at once, zero for no limit.
+func (a *Accountant) Limit() int64 {
+	if a == nil || a.limit <= 0 {
+		return 0
+	}
+
+	return a.limit
+}
+
+// Used returns the bytes reserved now.
+func (a *Accountant) Used() int64 {
+	if a == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&a.used)
+}
+
+// Reserve starts a reservation, which holds memory until it is closed.
+func (a *Accountant) Reserve() *Reservation {
+	return &Reservation{acc: a}
+}
+
+// Reservation is the memory held by a single operator or statement. It is
+// not safe for concurrent use.
+type Reservation struct {
+	acc  *Accountant
+	held int64
+}
+
+// Grow reserves n more bytes, failing with an error matching ErrExceeded if
+// that would take the accountant over its limit. Nothing is reserved then.
+func (r *Reservation) Grow(n int64) error {
+	if r == nil || r.acc == nil {
+		return nil
+	}
+
+	used := atomic.AddInt64(&r.acc.used, n)
+	if r.acc.limit > 0 && used > r.acc.limit {
+		atomic.AddInt64(&r.acc.used, -n)
+		return fmt.Errorf("%w: %d bytes are allowed, exceeded by %d",
+			ErrExceeded, r.acc.limit, used-r.acc.limit)
+	}
+
+	r.held += n
+	return nil
+}
+
+// Held returns the bytes the reservation holds.
+func (r *Reservation) Held() int64 {
+	if r == nil {
+		return 0
+	}
+
+	return r.held
+}
+
+// Release releases n of the bytes the reservation holds, for an operator
+// that let go of some of what it buffered.
+func (r *Reservation) Release(n int64) {
+	if r == nil || r.acc == nil {
+		return
+	}
+
+	if n > r.held {
+		n = r.held
+	}
+	atomic.AddInt64(&r.acc.used, -n)
+	r.held -= n
+}
+
+// Close releases everything the reservation holds. It can be grown again
+// afterwards.
+func (r *Reservation) Close() {
+	if r == nil || r.acc == nil {
+		return
+	}
+
+	atomic.AddInt64(&r.acc.used, -r.held)
+	r.held = 0
+}
@@ -0,0 +1,133 @@
+package budget
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestReservation(t *testing.T) {
+	acc := New(100)
+	if acc.Limit() != 100 {
+		t.Fatalf("got limit %d", acc.Limit())
+	}
+
+	first, second := acc.Reserve(), acc.Reserve()
+	if err := first.Grow(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Grow(30); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is reserved by a failed Grow
+	if err := second.Grow(20); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("got %v, want %v", err, ErrExceeded)
+	}
+	if acc.Used() != 90 || second.Held() != 30 {
+		t.Fatalf("%d used, %d held after a failed grow", acc.Used(), second.Held())
+	}
+	if err := second.Grow(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Releasing more than is held releases what is held
+	first.Release(10)
+	if acc.Used() != 90 || first.Held() != 50 {
+		t.Fatalf("%d used, %d held after releasing", acc.Used(), first.Held())
+	}
+	second.Release(1000)
+	if acc.Used() != 50 || second.Held() != 0 {
+		t.Fatalf("%d used, %d held after releasing too much", acc.Used(), second.Held())
+	}
+
+	first.Close()
+	if acc.Used() != 0 || first.Held() != 0 {
+		t.Fatalf("%d used, %d held after closing", acc.Used(), first.Held())
+	}
+	// Closed reservations can be grown again
+	if err := first.Grow(100); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+	first.Close()
+	if acc.Used() != 0 {
+		t.Fatalf("%d used after closing twice", acc.Used())
+	}
+}
+
+func TestNoLimit(t *testing.T) {
+	for _, acc := range []*Accountant{nil, New(0), New(-1)} {
+		if acc.Limit() != 0 {
+			t.Errorf("got limit %d, want none", acc.Limit())
+		}
+
+		r := acc.Reserve()
+		if err := r.Grow(1 << 40); err != nil {
+			t.Fatal(err)
+		}
+		r.Release(1)
+		r.Close()
+		if acc.Used() != 0 || r.Held() != 0 {
+			t.Errorf("%d used, %d held after closing", acc.Used(), r.Held())
+		}
+	}
+
+	// The nil accountant keeps no track at all
+	r := (*Accountant)(nil).Reserve()
+	if err := r.Grow(10); err != nil || r.Held() != 0 {
+		t.Errorf("got %v, %d held", err, r.Held())
+	}
+
+	var nilReservation *Reservation
+	if err := nilReservation.Grow(10); err != nil || nilReservation.Held() != 0 {
+		t.Errorf("got %v, %d held", err, nilReservation.Held())
+	}
+	nilReservation.Release(10)
+	nilReservation.Close()
+}
+
+func TestConcurrent(t *testing.T) {
+	const (
+		workers = 8
+		grows   = 1000
+	)
+	acc := New(workers * grows / 2)
+
+	var wg sync.WaitGroup
+	granted := make([]int64, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			r := acc.Reserve()
+			for j := 0; j < grows; j++ {
+				if err := r.Grow(1); err == nil {
+					granted[i]++
+				}
+			}
+			if r.Held() != granted[i] {
+				t.Errorf("%d held, %d granted", r.Held(), granted[i])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var total int64
+	for _, n := range granted {
+		total += n
+	}
+	if total > acc.Limit() || acc.Used() != total {
+		t.Fatalf("%d granted, %d used, limit %d", total, acc.Used(), acc.Limit())
+	}
+
+	// What is left can still be reserved, but no more
+	r := acc.Reserve()
+	if err := r.Grow(acc.Limit() - total); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Grow(1); !errors.Is(err, ErrExceeded) {
+		t.Errorf("got %v, want %v", err, ErrExceeded)
+	}
+}
@@ -0,0 +1,48 @@
+package sgsql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+func TestBulkInsertIdentity(t *testing.T) {
+	tests := []struct {
+		name   string
+		column string
+		rows   [][]interface{}
+		err    error
+		// ids are those of the rows after reopening the database and
+		// inserting one with DEFAULT
+		ids [][]interface{}
+	}{
+		{"generated", "generated always", [][]interface{}{{Default, "a"}, {Default, "b"}},
+			nil, [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}},
+		{"given", "generated by default", [][]interface{}{{int64(5), "a"}, {Default, "b"}},
+			nil, [][]interface{}{{int64(5)}, {int64(6)}, {int64(7)}}},
+		{"given to always", "generated always", [][]interface{}{{Default, "a"}, {int64(1), "b"}},
+			backend.ErrGeneratedAlways, [][]interface{}{{int64(1)}}},
+		{"null", "generated by default", [][]interface{}{{nil, "a"}},
+			backend.ErrInvalidDatatype, [][]interface{}{{int64(1)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			mustExec(t, db, "create table t (id int "+tt.column+" as identity, v text)")
+
+			if err := db.BulkInsert("t", tt.rows); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+
+			// The log replays and the identity goes on past the rows
+			db = reopen(t, db, path)
+			mustExec(t, db, "insert into t values (default, 'c')")
+			if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, tt.ids) {
+				t.Errorf("t holds %v, want %v", got, tt.ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package sgsql
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+// ErrCorrupt is matched by every CorruptionError.
+var ErrCorrupt = errors.New("Statement log is corrupt")
+
+// CorruptionError is a damaged entry of the statement log. Entry numbers
+// the entries from one and Offset is where the damaged one starts.
+type CorruptionError struct {
+	Entry  int
+	Offset int64
+	Msg    string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("Statement log is corrupt at entry %d, offset %d: %s", e.Entry, e.Offset, e.Msg)
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+const (
+	msgUndecryptable = "Entry can't be decrypted"
+	msgIncomplete    = "Entry is incomplete"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumPrefix starts every entry written with a checksum. The checksum
+// covers the encoding of the entry without it, which is what follows the
+// checksum with its opening brace put back.
+const checksumPrefix = `{"checksum":`
+
+// writeEntry writes entry to w as line n of the statement log, counting
+// from one, encrypted with c.
+func writeEntry(w io.Writer, entry logEntry, n int, c *logCipher) error {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	sum := crc32.Checksum(body, castagnoli)
+	line, err := c.seal([]byte(fmt.Sprintf("%s%d,%s", checksumPrefix, sum, body[1:])), n)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// logReader reads the entries of a statement log one line at a time, so a
+// damaged entry doesn't stop the ones after it from being read.
+type logReader struct {
+	r      *bufio.Reader
+	cipher *logCipher
+	entry  int
+	offset int64
+}
+
+func newLogReader(r io.Reader, c *logCipher) *logReader {
+	return &logReader{r: bufio.NewReader(r), cipher: c}
+}
+
+// next returns the next entry of the log and io.EOF after the last one. A
+// damaged entry is returned as a *CorruptionError, reading continues with
+// the entry following it.
+func (lr *logReader) next() (logEntry, error) {
+	for {
+		line, err := lr.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return logEntry{}, err
+		}
+		if len(line) == 0 {
+			return logEntry{}, io.EOF
+		}
+
+		offset := lr.offset
+		lr.offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		lr.entry++
+		corrupt := func(msg string) (logEntry, error) {
+			return logEntry{}, &CorruptionError{Entry: lr.entry, Offset: offset, Msg: msg}
+		}
+
+		// Entries always end in a newline, one without was cut off while
+		// being written
+		if err == io.EOF {
+			return corrupt(msgIncomplete)
+		}
+
+		// Plain entries are JSON objects, so anything else is encrypted
+		body, err := lr.cipher.open(bytes.TrimSuffix(line, []byte("\n")), lr.entry)
+		if err != nil || (lr.cipher == nil && body[0] != '{') {
+			return corrupt(msgUndecryptable)
+		}
+
+		if bytes.HasPrefix(body, []byte(checksumPrefix)) {
+			rest := body[len(checksumPrefix):]
+			end := bytes.IndexByte(rest, ',')
+			if end < 0 {
+				return corrupt("Checksum is malformed")
+			}
+
+			sum, err := strconv.ParseUint(string(rest[:end]), 10, 32)
+			if err != nil {
+				return corrupt("Checksum is malformed")
+			}
+
+			body = append([]byte{'{'}, rest[end+1:]...)
+			if crc32.Checksum(body, castagnoli) != uint32(sum) {
+				return corrupt("Checksum mismatch")
+			}
+		}
+		// Entries without a checksum were written before there were any
+
+		entry, err := decodeEntry(body)
+		if err != nil {
+			return corrupt(err.Error())
+		}
+
+		return entry, nil
+	}
+}
+
+// decodeEntry decodes the JSON encoding of an entry. Numeric parameters are
+// decoded as int64 when they are integers, and as float64 otherwise.
+func decodeEntry(body []byte) (logEntry, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var entry logEntry
+	if err := dec.Decode(&entry); err != nil {
+		return logEntry{}, err
+	}
+
+	if err := decodeParams(entry.Params); err != nil {
+		return logEntry{}, err
+	}
+	for _, row := range entry.Rows {
+		if err := decodeParams(row); err != nil {
+			return logEntry{}, err
+		}
+	}
+
+	return entry, nil
+}
+
+// decodeParams replaces the numbers of params with int64 or float64.
+func decodeParams(params []interface{}) error {
+	for i, p := range params {
+		n, ok := p.(json.Number)
+		if !ok {
+			continue
+		}
+
+		if v, err := n.Int64(); err == nil {
+			params[i] = v
+			continue
+		}
+
+		v, err := n.Float64()
+		if err != nil {
+			return err
+		}
+		params[i] = v
+	}
+
+	return nil
+}
+
+// checkLog reads the whole statement log and returns its damaged entries.
+// It must be called with db.mu held, so no entry is being written.
+func (db *DB) checkLog() ([]*CorruptionError, error) {
+	if db.log == nil {
+		return nil, nil
+	}
+
+	f, err := db.openLog()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	damaged := []*CorruptionError{}
+	lr := newLogReader(f, db.cipher)
+	for {
+		_, err := lr.next()
+		if err == io.EOF {
+			return damaged, nil
+		}
+
+		var corrupt *CorruptionError
+		if errors.As(err, &corrupt) {
+			damaged = append(damaged, corrupt)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkTable runs CHECK TABLE, returning a row for every damaged entry of
+// the statement log. Every table is stored in the one log, so the damaged
+// entries are reported whichever table they held rows of.
+func (tx *Tx) checkTable() (*Results, error) {
+	damaged, err := tx.db.checkLog()
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{Columns: []backend.ResultColumn{
+		{Type: backend.IntType, Name: "entry"},
+		{Type: backend.IntType, Name: "offset"},
+		{Type: backend.TextType, Name: "message"},
+	}}
+	for _, corrupt := range damaged {
+		results.Rows = append(results.Rows, []interface{}{int64(corrupt.Entry), corrupt.Offset, corrupt.Msg})
+	}
+
+	return results, nil
+}
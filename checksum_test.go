@@ -0,0 +1,154 @@
+package sgsql
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeLog writes a database of three entries to a file of its own, changes
+// its contents with damage and returns its path.
+func writeLog(t *testing.T, damage func(log string) string) string {
+	t.Helper()
+
+	db, path := openTest(t)
+	mustExec(t, db,
+		"create table t (id int)",
+		"insert into t values (1)",
+		"insert into t values (2)",
+	)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(damage(string(log))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// entryOffset returns where entry n, numbered from one, starts in log.
+func entryOffset(log string, n int) int64 {
+	lines := strings.SplitAfter(log, "\n")
+	offset := 0
+	for _, line := range lines[:n-1] {
+		offset += len(line)
+	}
+
+	return int64(offset)
+}
+
+func TestOpenDetectsCorruption(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(log string) string
+		// entry is the damaged entry, 0 when the log is intact
+		entry int
+		msg   string
+	}{
+		{"intact", func(log string) string { return log }, 0, ""},
+		{"changed value", func(log string) string {
+			return strings.Replace(log, "values (1)", "values (7)", 1)
+		}, 2, "Checksum mismatch"},
+		{"malformed checksum", func(log string) string {
+			return strings.Replace(log, `{"checksum":`, `{"checksum":x`, 1)
+		}, 1, "Checksum is malformed"},
+		{"cut off", func(log string) string {
+			return log[:len(log)-5]
+		}, 3, msgIncomplete},
+		{"garbage", func(log string) string {
+			return log + "not an entry\n"
+		}, 4, msgUndecryptable},
+		{"without checksums", func(log string) string {
+			// Logs written before entries had checksums replay as they are
+			lines := strings.SplitAfter(log, "\n")
+			for i, line := range lines {
+				if end := strings.Index(line, ","); end > 0 {
+					lines[i] = "{" + line[end+1:]
+				}
+			}
+			return strings.Join(lines, "")
+		}, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log string
+			path := writeLog(t, func(intact string) string {
+				log = tt.damage(intact)
+				return log
+			})
+
+			db, err := Open(path)
+			if tt.entry == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+
+				want := [][]interface{}{{int64(1)}, {int64(2)}}
+				if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, want) {
+					t.Errorf("t holds %v, want %v", got, want)
+				}
+				return
+			}
+
+			if err == nil {
+				db.Close()
+				t.Fatal("opened a damaged log")
+			}
+
+			var corrupt *CorruptionError
+			if !errors.Is(err, ErrCorrupt) || !errors.As(err, &corrupt) {
+				t.Fatalf("got %v, want a CorruptionError", err)
+			}
+			want := CorruptionError{Entry: tt.entry, Offset: entryOffset(log, tt.entry), Msg: tt.msg}
+			if *corrupt != want {
+				t.Errorf("got %+v, want %+v", *corrupt, want)
+			}
+		})
+	}
+}
+
+func TestCheckTable(t *testing.T) {
+	db, path := openTest(t)
+	mustExec(t, db, "create table t (id int)", "insert into t values (1)")
+
+	results, err := db.Query("check table t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Rows) != 0 {
+		t.Errorf("CHECK TABLE reports %v on an intact log", results.Rows)
+	}
+
+	// Damage done to the log while the database is open
+	log, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := strings.Replace(string(log), "values (1)", "values (9)", 1)
+	if err := os.WriteFile(path, []byte(damaged), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = db.Query("check table t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{int64(2), entryOffset(damaged, 2), "Checksum mismatch"}}
+	if !reflect.DeepEqual(results.Rows, want) {
+		t.Errorf("CHECK TABLE reports %v, want %v", results.Rows, want)
+	}
+
+	if _, err := db.Query("check table missing"); err == nil {
+		t.Error("CHECK TABLE of a missing table succeeds")
+	}
+}
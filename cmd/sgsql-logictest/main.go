@@ -0,0 +1,70 @@
+// Command sgsql-logictest runs sqllogictest files, each against a new
+// in-memory database, and reports how many of their records passed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/logictest"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "print every failing record")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-v] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		result, err := runFile(path)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			failed = true
+			continue
+		}
+
+		if *verbose {
+			for _, failure := range result.Failures {
+				fmt.Printf("%s:%s\n\n", path, failure)
+			}
+		}
+
+		fmt.Printf("%s: %d passed, %d failed, %d skipped\n",
+			path, result.Passed, len(result.Failures), result.Skipped)
+		failed = failed || len(result.Failures) > 0
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func runFile(path string) (*logictest.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	conn := db.Conn()
+	defer conn.Close()
+
+	return logictest.Run(conn, f)
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/config"
+	"github.com/nireo/sgsql/logging"
+	"github.com/nireo/sgsql/server"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		os.Exit(runFsck(os.Args[2:]))
+	}
+
+	configFile := flag.String("config", "", "TOML or YAML file to read the settings from, the SGSQL_ environment variables and flags override it, see package config")
+	script := flag.String("f", "", "file of statements to run before serving, - for stdin; without -http, -grpc, -mysql and -postgres the server exits after running it")
+	data := flag.String("d", "", "shorthand for -data")
+	flags := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	load := func() (*config.Config, error) {
+		cfg, err := loadConfig(*configFile, flags)
+		if err == nil && *data != "" {
+			cfg.Data = *data
+		}
+		return cfg, err
+	}
+	cfg, err := load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serving := cfg.HTTP != "" || cfg.GRPC != "" || cfg.MySQL != "" || cfg.Postgres != ""
+
+	key, err := readKey(cfg.KeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	open := sgsql.OpenEncrypted
+	if cfg.ReadOnly {
+		open = sgsql.OpenEncryptedReadOnly
+	}
+
+	db, err := open(cfg.Data, key, sgsql.WithEngine(cfg.Engine))
+	if err != nil {
+		log.Fatal(err)
+	}
+	apply(db, cfg)
+
+	if cfg.AuditLog != "" {
+		f, err := os.OpenFile(cfg.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		db.SetAuditSink(f)
+	}
+
+	if *script != "" {
+		if err := runScript(db, *script); err != nil {
+			db.Close()
+			log.Fatalf("running %s: %v", *script, err)
+		}
+
+		if !serving {
+			if err := db.Close(); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	// Without anything to serve the statements typed in are run instead
+	if !serving {
+		os.Exit(runShell(db))
+	}
+
+	reload := reloader(db, cfg, load)
+	db.SetReload(reload)
+	go reloadOnHangup(reload)
+
+	// The servers return once they are shut down, so errs has room for
+	// all of them to not be left blocked
+	errs := make(chan error, 4)
+	handler := server.NewHTTPServer(db)
+	httpServer := &http.Server{Addr: cfg.HTTP, Handler: handler}
+	grpcServer := server.NewGRPCServer(db)
+	mysqlServer := server.NewMySQLServer(db)
+	pgServer := server.NewPostgresServer(db)
+	if cfg.Credentials != "" {
+		credentials, err := server.ReadCredentialsFile(cfg.Credentials)
+		if err != nil {
+			db.Close()
+			log.Fatalf("reading credentials from %s: %v", cfg.Credentials, err)
+		}
+		handler.SetCredentials(credentials)
+		grpcServer.SetCredentials(credentials)
+		mysqlServer.SetCredentials(credentials)
+		pgServer.SetCredentials(credentials)
+	}
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			db.Close()
+			log.Fatalf("loading the TLS certificate: %v", err)
+		}
+		pgServer.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	} else if cfg.Postgres != "" && cfg.Credentials != "" {
+		log.Printf("warning: Postgres clients send their passwords in the clear, set tls-cert and tls-key to serve TLS")
+	}
+
+	if cfg.HTTP != "" {
+		go func() {
+			log.Printf("serving HTTP query API on %s", cfg.HTTP)
+			errs <- httpServer.ListenAndServe()
+		}()
+	}
+
+	if cfg.GRPC != "" {
+		go func() {
+			log.Printf("serving gRPC API on %s", cfg.GRPC)
+			errs <- grpcServer.ListenAndServe(cfg.GRPC)
+		}()
+	}
+
+	if cfg.MySQL != "" {
+		go func() {
+			log.Printf("serving MySQL protocol on %s", cfg.MySQL)
+			errs <- mysqlServer.ListenAndServe(cfg.MySQL)
+		}()
+	}
+
+	if cfg.Postgres != "" {
+		go func() {
+			log.Printf("serving Postgres protocol on %s", cfg.Postgres)
+			errs <- pgServer.ListenAndServe(cfg.Postgres)
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-errs:
+		db.Close()
+		log.Fatal(err)
+	case sig := <-stop:
+		log.Printf("%s received, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGrace)
+	defer cancel()
+	shutdown(ctx, httpServer, grpcServer, mysqlServer, pgServer)
+
+	// Closing syncs what was committed to the statement log
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("shut down")
+}
+
+// shutdown stops the servers, letting the requests and transactions they
+// are running finish until ctx is done, when the connections left are
+// closed.
+func shutdown(ctx context.Context, httpServer *http.Server, grpcServer *server.GRPCServer, mysqlServer *server.MySQLServer, pgServer *server.PostgresServer) {
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("closing HTTP connections still open: %v", err)
+			httpServer.Close()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := grpcServer.Shutdown(ctx); err != nil {
+			log.Printf("closed gRPC connections still open: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := mysqlServer.Shutdown(ctx); err != nil {
+			log.Printf("closed MySQL connections still open: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := pgServer.Shutdown(ctx); err != nil {
+			log.Printf("closed Postgres connections still open: %v", err)
+		}
+	}()
+	wg.Wait()
+}
+
+// loadConfig reads the settings from the file called name, if there is
+// one, then from the environment and the flags given.
+func loadConfig(name string, flags *config.Flags) (*config.Config, error) {
+	cfg := config.Default()
+	if name != "" {
+		if err := cfg.ReadFile(name); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ReadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := flags.Apply(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// apply sets the settings of cfg that can change while db is open.
+func apply(db *sgsql.DB, cfg *config.Config) {
+	logging.SetDefault(logging.NewText(os.Stderr, cfg.LogLevel))
+	db.SetSlowQueryThreshold(cfg.SlowQuery)
+	db.SetSynchronous(cfg.Synchronous)
+	db.SetMemoryBudget(cfg.MemoryBudget)
+	db.SetResultCache(cfg.ResultCache)
+	db.SetBufferPoolSize(cfg.BufferPoolSize)
+	db.SetExternalDir(cfg.ExternalDir)
+	db.SetAttachDir(cfg.AttachDir)
+	db.SetForeignKeyIndexes(cfg.ForeignKeyIndexes)
+	db.SetAutoVacuum(cfg.AutoVacuum)
+	db.SetHistoryRetention(cfg.HistoryRetention)
+	db.SetQuota("", sgsql.Quota{
+		MaxRows:       cfg.MaxRows,
+		MaxDuration:   cfg.MaxQueryDuration,
+		MaxConcurrent: cfg.MaxConcurrentQueries,
+		Queue:         cfg.QueueQueries,
+	})
+}
+
+// reloader returns a function loading the configuration again and applying
+// it to db, which was opened with running. Settings only applied at start
+// are reported instead, and an invalid configuration is returned as an error
+// leaving the one running in place.
+func reloader(db *sgsql.DB, running *config.Config, load func() (*config.Config, error)) func() error {
+	var mu sync.Mutex
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		cfg, err := load()
+		if err != nil {
+			return err
+		}
+
+		apply(db, cfg)
+		log.Printf("reloaded the configuration")
+		for _, key := range cfg.NeedRestart(running) {
+			log.Printf("%s changed, restart the server to apply it", key)
+		}
+
+		return nil
+	}
+}
+
+// reloadOnHangup calls reload every time the process gets SIGHUP.
+func reloadOnHangup(reload func() error) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		if err := reload(); err != nil {
+			log.Printf("not reloading the configuration: %v", err)
+		}
+	}
+}
+
+// readKey reads the hex-encoded key of the statement log from the file
+// called name, there is none when name is empty.
+func readKey(name string) ([]byte, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	text, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil {
+		return nil, fmt.Errorf("reading key from %s: %w", name, err)
+	}
+
+	return key, nil
+}
+
+// runFsck runs sgsql fsck with args, which checks a database that isn't
+// open, and returns the status to exit with: 0 when it is undamaged or
+// was repaired, 1 when damage is left and 2 when it couldn't be checked.
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	keyFile := fs.String("keyfile", "", "file holding the hex-encoded key the statement log is encrypted with")
+	repair := fs.Bool("repair", false, "remove an entry a crash left cut off at the end of the statement log")
+	engine := fs.String("engine", "memory", "storage engine the database keeps the rows of its tables in")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s fsck [flags] path\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	key, err := readKey(*keyFile)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	problems, err := sgsql.Fsck(fs.Arg(0), key, *repair, sgsql.WithEngine(*engine))
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	status := 0
+	for _, p := range problems {
+		fmt.Println(p)
+		if !p.Repaired {
+			status = 1
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Printf("%s: no damage found\n", fs.Arg(0))
+	}
+
+	return status
+}
+
+// runShell runs the statements read from stdin in a shell on db, which it
+// closes, and returns the status to exit with: 1 when a statement failed
+// while stdin isn't a terminal, or db couldn't be closed, and 0 otherwise.
+func runShell(db *sgsql.DB) int {
+	conn := db.Conn()
+	sh := newShell(conn, os.Stdout)
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		sh.terminal = true
+		sh.monitor, sh.progress = db.Conn(), progressEvery
+	}
+	interactive := false
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		interactive, sh.history = true, historyFile()
+		fmt.Print("sgsql shell, \\? for help\n")
+	}
+
+	status := 0
+	if err := sh.run(os.Stdin, interactive); err != nil {
+		log.Print(err)
+		status = 1
+	}
+	conn.Close()
+	if sh.monitor != nil {
+		sh.monitor.Close()
+	}
+	if err := db.Close(); err != nil {
+		log.Print(err)
+		status = 1
+	}
+
+	return status
+}
+
+// runScript runs the statements of the file called name, or of stdin when
+// name is -, stopping at the first that fails.
+func runScript(db *sgsql.DB, name string) error {
+	if name == "-" {
+		return db.ExecScript(os.Stdin)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return db.ExecScript(f)
+}
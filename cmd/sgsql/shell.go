@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+const shellHelp = `Statements end with a semicolon and may span lines. Commands:
+  \i file             run the statements of file, stopping at the first that fails
+  \s                  show the history of statements
+  \watch [n [times]]  run the last statement every n seconds, 2 by default,
+                      until interrupted or it ran times times
+  \x [on|off]         toggle the expanded output of a record per block of lines
+  \a                  toggle between aligned and unaligned output
+  \pset format name   print results aligned, unaligned, as csv or as json
+  \pset width n       cut aligned values longer than n characters, 0 to not
+  \q                  quit
+  \?                  show this help
+`
+
+// Formats results are printed in, set with \pset format.
+const (
+	formatAligned   = "aligned"
+	formatUnaligned = "unaligned"
+	formatCSV       = "csv"
+	formatJSON      = "json"
+)
+
+// defaultWatch is how often \watch runs the last statement when it isn't
+// told.
+const defaultWatch = 2 * time.Second
+
+// progressEvery is how often a shell on a terminal reads __jobs while a
+// statement runs.
+const progressEvery = 500 * time.Millisecond
+
+// defaultWidth is how many characters of a value aligned output shows
+// until \pset width changes it.
+const defaultWidth = 50
+
+// errQuit is returned by command for \q.
+var errQuit = errors.New("quit")
+
+// shell runs the statements typed into it in a single session, so
+// transactions span lines, and prints their results. The statements are
+// appended to the file at history, when it is set, which \s lists and
+// later shells keep adding to. Editing lines and recalling them with the
+// arrow keys is left to the terminal or a wrapper like rlwrap, since
+// reading keys one at a time needs a terminal library.
+type shell struct {
+	conn    *sgsql.Conn
+	out     io.Writer
+	history string
+	// format is how results are printed, expanded prints every row as a
+	// block of a line per column instead, and width is how many characters
+	// of a value aligned output shows, all of them when it is 0
+	format   string
+	expanded bool
+	width    int
+	// last is the last statement typed in, which \watch runs again, and
+	// terminal is set when out is a terminal, whose screen \watch clears
+	last     string
+	terminal bool
+	// monitor is a session reading __jobs every progress while a
+	// statement runs, to draw the progress of the jobs it started, like
+	// building an index. Nothing is drawn when it is nil.
+	monitor  *sgsql.Conn
+	progress time.Duration
+}
+
+// newShell returns a shell running statements in conn and printing to out
+// as aligned tables.
+func newShell(conn *sgsql.Conn, out io.Writer) *shell {
+	return &shell{conn: conn, out: out, format: formatAligned, width: defaultWidth}
+}
+
+// historyFile returns the file the history of the shell is kept in:
+// SGSQL_HISTORY, or .sgsql_history in the home directory. There is none
+// when SGSQL_HISTORY is set but empty or there is no home directory.
+func historyFile() string {
+	if name, ok := os.LookupEnv("SGSQL_HISTORY"); ok {
+		return name
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sgsql_history")
+}
+
+// run reads lines from in until it ends or \q. Prompts are written when
+// interactive is set, and errors are printed without stopping; otherwise
+// the first statement or command that fails stops the shell and its error
+// is returned.
+func (sh *shell) run(in io.Reader, interactive bool) error {
+	var stmt strings.Builder
+	scanner := bufio.NewScanner(in)
+	for {
+		if interactive {
+			if stmt.Len() == 0 {
+				fmt.Fprint(sh.out, "sgsql> ")
+			} else {
+				fmt.Fprint(sh.out, "   ...> ")
+			}
+		}
+		if !scanner.Scan() {
+			break
+		}
+
+		line := scanner.Text()
+		var err error
+		switch trimmed := strings.TrimSpace(line); {
+		case stmt.Len() == 0 && strings.HasPrefix(trimmed, `\`):
+			err = sh.command(trimmed)
+		case stmt.Len() == 0 && trimmed == "":
+			continue
+		default:
+			stmt.WriteString(line)
+			stmt.WriteByte('\n')
+			// A statement is run once a line ends it, like in psql
+			if !strings.HasSuffix(trimmed, ";") {
+				continue
+			}
+
+			text := stmt.String()
+			stmt.Reset()
+			if interactive {
+				sh.remember(text)
+			}
+			sh.last = text
+			err = sh.exec(text)
+		}
+
+		switch {
+		case err == errQuit:
+			return nil
+		case err != nil && !interactive:
+			return err
+		case err != nil:
+			fmt.Fprintf(sh.out, "ERROR: %v\n", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if interactive {
+		fmt.Fprintln(sh.out)
+	}
+	// Statements left without a semicolon at the end are run as well
+	if strings.TrimSpace(stmt.String()) != "" {
+		return sh.exec(stmt.String())
+	}
+	return nil
+}
+
+// command runs the backslash command text.
+func (sh *shell) command(text string) error {
+	name, arg := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		name, arg = text[:i], strings.TrimSpace(text[i:])
+	}
+
+	switch name {
+	case `\q`:
+		return errQuit
+	case `\?`:
+		fmt.Fprint(sh.out, shellHelp)
+		return nil
+	case `\i`:
+		if arg == "" {
+			return errors.New(`\i needs the file to run`)
+		}
+		f, err := os.Open(arg)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := sh.run(f, false); err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+		return nil
+	case `\s`:
+		if sh.history == "" {
+			return errors.New("No history is kept, SGSQL_HISTORY is empty")
+		}
+		f, err := os.Open(sh.history)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(sh.out, f)
+		return err
+	case `\x`:
+		switch arg {
+		case "":
+			sh.expanded = !sh.expanded
+		case "on":
+			sh.expanded = true
+		case "off":
+			sh.expanded = false
+		default:
+			return fmt.Errorf(`\x takes on or off, not %s`, arg)
+		}
+		fmt.Fprintf(sh.out, "Expanded display is %s.\n", onOff(sh.expanded))
+		return nil
+	case `\a`:
+		if sh.format == formatAligned {
+			sh.format = formatUnaligned
+		} else {
+			sh.format = formatAligned
+		}
+		fmt.Fprintf(sh.out, "Output format is %s.\n", sh.format)
+		return nil
+	case `\pset`:
+		return sh.pset(strings.Fields(arg))
+	case `\watch`:
+		return sh.watch(strings.Fields(arg))
+	}
+
+	return fmt.Errorf("Unknown command %s, \\? lists them", name)
+}
+
+// pset sets the option of the output named by args[0] to args[1].
+func (sh *shell) pset(args []string) error {
+	if len(args) != 2 {
+		return errors.New(`\pset takes an option and its value`)
+	}
+
+	switch args[0] {
+	case "format":
+		switch args[1] {
+		case formatAligned, formatUnaligned, formatCSV, formatJSON:
+			sh.format = args[1]
+		default:
+			return fmt.Errorf("Unknown format %s, expected aligned, unaligned, csv or json", args[1])
+		}
+		fmt.Fprintf(sh.out, "Output format is %s.\n", sh.format)
+	case "width":
+		width, err := strconv.Atoi(args[1])
+		if err != nil || width < 0 {
+			return fmt.Errorf("Width must be a number of characters, not %s", args[1])
+		}
+		sh.width = width
+		fmt.Fprintf(sh.out, "Width is %d.\n", sh.width)
+	default:
+		return fmt.Errorf("Unknown option %s, expected format or width", args[0])
+	}
+
+	return nil
+}
+
+// watch runs the last statement again and again, printing its results
+// under the time it ran, every args[0] seconds until it is interrupted or
+// ran args[1] times. A statement that fails stops it.
+func (sh *shell) watch(args []string) error {
+	if sh.last == "" {
+		return errors.New(`\watch needs a statement to run, there was none yet`)
+	}
+	if len(args) > 2 {
+		return errors.New(`\watch takes the seconds to wait and how many times to run`)
+	}
+
+	interval, times := defaultWatch, 0
+	if len(args) > 0 {
+		seconds, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || !(seconds > 0) || seconds > math.MaxInt64/float64(time.Second) {
+			return fmt.Errorf("Interval must be a positive number of seconds, not %s", args[0])
+		}
+		interval = time.Duration(seconds * float64(time.Second))
+	}
+	if len(args) > 1 {
+		var err error
+		if times, err = strconv.Atoi(args[1]); err != nil || times <= 0 {
+			return fmt.Errorf("Times must be a positive number, not %s", args[1])
+		}
+	}
+
+	// Interrupting ends the watch rather than the shell
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	every := strconv.FormatFloat(interval.Seconds(), 'g', -1, 64)
+	for n := 1; ; n++ {
+		if sh.terminal {
+			fmt.Fprint(sh.out, "\x1b[H\x1b[2J")
+		}
+		fmt.Fprintf(sh.out, "%s (every %ss)\n\n", time.Now().Format("Mon Jan 2 15:04:05 2006"), every)
+		if err := sh.exec(sh.last); err != nil {
+			return err
+		}
+		if n == times {
+			return nil
+		}
+
+		fmt.Fprintln(sh.out)
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-interrupt:
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// remember appends the statement text to the history file, on one line.
+// Failing to is reported but doesn't stop the statement from running.
+func (sh *shell) remember(text string) {
+	if sh.history == "" {
+		return
+	}
+
+	f, err := os.OpenFile(sh.history, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err == nil {
+		_, err = fmt.Fprintln(f, strings.Join(strings.Fields(text), " "))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(sh.out, "WARNING: saving the history: %v\n", err)
+	}
+}
+
+// exec runs the statements of text one at a time and prints their results.
+func (sh *shell) exec(text string) error {
+	scanner := parser.NewStatementScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		results, err := sh.query(scanner.Text())
+		if err != nil {
+			return err
+		}
+		sh.print(results)
+	}
+
+	return scanner.Err()
+}
+
+// query runs the statement text, drawing the progress of its jobs on a
+// line of its own while it runs when the shell has a monitor. The line is
+// cleared again once the statement is done.
+func (sh *shell) query(text string) (*sgsql.Results, error) {
+	if sh.monitor == nil {
+		return sh.conn.Query(text)
+	}
+
+	type result struct {
+		results *sgsql.Results
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		results, err := sh.conn.Query(text)
+		done <- result{results, err}
+	}()
+
+	ticker := time.NewTicker(sh.progress)
+	defer ticker.Stop()
+	drawn := false
+	for {
+		select {
+		case r := <-done:
+			if drawn {
+				fmt.Fprint(sh.out, "\r\x1b[K")
+			}
+			return r.results, r.err
+		case <-ticker.C:
+			line := sh.jobProgress()
+			switch {
+			case line != "":
+				fmt.Fprint(sh.out, "\r\x1b[K"+line)
+				drawn = true
+			case drawn:
+				fmt.Fprint(sh.out, "\r\x1b[K")
+				drawn = false
+			}
+		}
+	}
+}
+
+// jobProgress returns a line showing how far the latest job of the session
+// of the shell got, or an empty one when it runs none.
+func (sh *shell) jobProgress() string {
+	results, err := sh.monitor.Query("SELECT kind, table_name, rows_done, rows_total FROM __jobs WHERE session_id = $1", sh.conn.ID())
+	if err != nil || len(results.Rows) == 0 {
+		return ""
+	}
+
+	row := results.Rows[len(results.Rows)-1]
+	kind, _ := row[0].(string)
+	table, _ := row[1].(string)
+	done, _ := row[2].(int64)
+	total, _ := row[3].(int64)
+	return progressLine(kind, table, done, total)
+}
+
+// progressBar is how many characters wide the bar progressLine draws is.
+const progressBar = 30
+
+// progressLine draws the progress of a job of kind on table that is done
+// with done of total rows as a bar followed by the percentage and rows.
+func progressLine(kind, table string, done, total int64) string {
+	percent := int64(100)
+	if total > 0 && done < total {
+		percent = done * 100 / total
+	}
+
+	filled := int(percent * progressBar / 100)
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", progressBar-filled)
+	return fmt.Sprintf("%s %s [%s] %3d%% (%d/%d rows)", kind, table, bar, percent, done, total)
+}
+
+// print writes the rows of results in the format of the shell, and
+// nothing for statements without any columns. Aligned and unaligned output
+// end with the number of rows.
+func (sh *shell) print(results *sgsql.Results) {
+	if results == nil || len(results.Columns) == 0 {
+		return
+	}
+
+	switch {
+	case sh.format == formatCSV:
+		sh.printCSV(results)
+		return
+	case sh.format == formatJSON:
+		sh.printJSON(results)
+		return
+	case sh.expanded:
+		sh.printExpanded(results)
+	case sh.format == formatUnaligned:
+		sh.printUnaligned(results)
+	default:
+		sh.printAligned(results)
+	}
+
+	if len(results.Rows) == 1 {
+		fmt.Fprintln(sh.out, "(1 row)")
+	} else {
+		fmt.Fprintf(sh.out, "(%d rows)\n", len(results.Rows))
+	}
+}
+
+// printAligned writes results as a table whose columns are as wide as
+// their longest values, which are cut to the width of the shell.
+func (sh *shell) printAligned(results *sgsql.Results) {
+	w := tabwriter.NewWriter(sh.out, 0, 0, 2, ' ', 0)
+	for i, col := range results.Columns {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, col.Name)
+	}
+	fmt.Fprintln(w)
+	for _, row := range results.Rows {
+		for i, v := range row {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprint(w, truncate(shellValue(v), sh.width))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// printUnaligned writes results with their values separated by |.
+func (sh *shell) printUnaligned(results *sgsql.Results) {
+	names := make([]string, len(results.Columns))
+	for i, col := range results.Columns {
+		names[i] = col.Name
+	}
+	fmt.Fprintln(sh.out, strings.Join(names, "|"))
+
+	values := make([]string, len(results.Columns))
+	for _, row := range results.Rows {
+		for i, v := range row {
+			values[i] = shellValue(v)
+		}
+		fmt.Fprintln(sh.out, strings.Join(values, "|"))
+	}
+}
+
+// printExpanded writes every row as a block headed by its number, with a
+// line for each column holding its name and value. The values are whole,
+// since the point is to read the wide ones.
+func (sh *shell) printExpanded(results *sgsql.Results) {
+	separator := " | "
+	if sh.format == formatUnaligned {
+		separator = "|"
+	}
+
+	width := 0
+	for _, col := range results.Columns {
+		if n := utf8.RuneCountInString(col.Name); n > width {
+			width = n
+		}
+	}
+
+	for n, row := range results.Rows {
+		fmt.Fprintf(sh.out, "-[ RECORD %d ]\n", n+1)
+		for i, v := range row {
+			name := results.Columns[i].Name
+			if sh.format == formatAligned {
+				name += strings.Repeat(" ", width-utf8.RuneCountInString(name))
+			}
+			fmt.Fprintf(sh.out, "%s%s%s\n", name, separator, shellValue(v))
+		}
+	}
+}
+
+// printCSV writes results as csv with a header, NULL as an empty field.
+func (sh *shell) printCSV(results *sgsql.Results) {
+	w := csv.NewWriter(sh.out)
+	record := make([]string, len(results.Columns))
+	for i, col := range results.Columns {
+		record[i] = col.Name
+	}
+	w.Write(record)
+
+	for _, row := range results.Rows {
+		for i, v := range row {
+			record[i] = ""
+			if v != nil {
+				record[i] = shellValue(v)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+}
+
+// printJSON writes results as an array of an object per row, with a line
+// per row. Numbers and bools are written as they are and other values as
+// they are printed.
+func (sh *shell) printJSON(results *sgsql.Results) {
+	fmt.Fprint(sh.out, "[")
+	for n, row := range results.Rows {
+		if n > 0 {
+			fmt.Fprint(sh.out, ",")
+		}
+
+		var b strings.Builder
+		b.WriteString("\n  {")
+		for i, v := range row {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			name, _ := json.Marshal(results.Columns[i].Name)
+			b.Write(name)
+			b.WriteString(": ")
+
+			switch v.(type) {
+			case nil, int64, bool:
+			case float64:
+				// JSON has no numbers for NaN and the infinities
+				if f := v.(float64); math.IsNaN(f) || math.IsInf(f, 0) {
+					v = shellValue(v)
+				}
+			default:
+				v = shellValue(v)
+			}
+			value, _ := json.Marshal(v)
+			b.Write(value)
+		}
+		b.WriteString("}")
+		fmt.Fprint(sh.out, b.String())
+	}
+	if len(results.Rows) > 0 {
+		fmt.Fprintln(sh.out)
+	}
+	fmt.Fprintln(sh.out, "]")
+}
+
+// truncate cuts s to width characters, ending it with … when it is cut.
+// A width of 0 keeps all of s.
+func truncate(s string, width int) string {
+	if width == 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+func shellValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return types.FormatTimestamp(v)
+	}
+
+	return fmt.Sprint(v)
+}
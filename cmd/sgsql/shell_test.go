@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql"
+)
+
+func testShell(t *testing.T) (*shell, *bytes.Buffer) {
+	sh, out, _ := testShellDB(t)
+	return sh, out
+}
+
+// testShellDB is testShell that also returns the database of the shell.
+func testShellDB(t *testing.T) (*shell, *bytes.Buffer, *sgsql.DB) {
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := db.Conn()
+	t.Cleanup(func() {
+		conn.Close()
+		db.Close()
+	})
+
+	var out bytes.Buffer
+	sh := newShell(conn, &out)
+	sh.history = filepath.Join(t.TempDir(), "history")
+	return sh, &out, db
+}
+
+func TestShell(t *testing.T) {
+	sh, out := testShell(t)
+	script := filepath.Join(t.TempDir(), "script.sql")
+	if err := os.WriteFile(script, []byte("insert into t values (2, 'b');\ninsert into t values (3, null)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := strings.Join([]string{
+		"create table t (a int, b text);",
+		"select nope;",
+		"begin; insert into t",
+		"  values (1, 'a');",
+		`\i ` + script,
+		"commit;",
+		"select a, b from t;",
+		`\s`,
+		`\q`,
+		"select 1;",
+	}, "\n")
+	if err := sh.run(strings.NewReader(in), true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"ERROR: ",
+		"a  b\n1  a\n2  b\n3  NULL\n(3 rows)\n",
+		"select nope;\nbegin; insert into t values (1, 'a');\ncommit;\nselect a, b from t;\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "(1 row)") {
+		t.Errorf("ran a statement after \\q:\n%s", out.String())
+	}
+}
+
+func TestShellStopsWithoutTerminal(t *testing.T) {
+	sh, out := testShell(t)
+	in := "create table t (a int);\ninsert into t values ('x');\ninsert into t values (1);"
+	if err := sh.run(strings.NewReader(in), false); err == nil {
+		t.Fatal("the failing insert didn't stop the shell")
+	}
+
+	if err := sh.exec("select count(*) from t"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), "0\n(1 row)\n") {
+		t.Errorf("ran the statements after the one failing:\n%s", out.String())
+	}
+	if _, err := os.Stat(sh.history); !os.IsNotExist(err) {
+		t.Errorf("kept a history without a terminal: %v", err)
+	}
+}
+
+func TestShellIncludeErrors(t *testing.T) {
+	sh, _ := testShell(t)
+	for _, in := range []string{`\i`, `\i ` + filepath.Join(t.TempDir(), "missing.sql"), `\z`,
+		`\x maybe`, `\pset format html`, `\pset width -1`, `\pset border 2`,
+		`\watch`, "select 1;\n\\watch 0", "select 1;\n\\watch 1 0", "select 1;\n\\watch x", "select 1;\n\\watch 1 2 3"} {
+		if err := sh.run(strings.NewReader(in), false); err == nil {
+			t.Errorf("%s succeeded", in)
+		}
+	}
+}
+
+func TestShellOutputModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     string
+	}{
+		{"aligned", nil, "a  b\n1  x\n2  NULL\n(2 rows)\n"},
+		{"unaligned", []string{`\a`}, "Output format is unaligned.\na|b\n1|x\n2|NULL\n(2 rows)\n"},
+		{"back to aligned", []string{`\a`, `\a`}, "Output format is unaligned.\nOutput format is aligned.\na  b\n1  x\n2  NULL\n(2 rows)\n"},
+		{"expanded", []string{`\x`}, "Expanded display is on.\n-[ RECORD 1 ]\na | 1\nb | x\n-[ RECORD 2 ]\na | 2\nb | NULL\n(2 rows)\n"},
+		{"expanded off", []string{`\x on`, `\x off`}, "Expanded display is on.\nExpanded display is off.\na  b\n1  x\n2  NULL\n(2 rows)\n"},
+		{"csv", []string{`\pset format csv`}, "Output format is csv.\na,b\n1,x\n2,\n"},
+		{"json", []string{`\pset format json`}, "Output format is json.\n[\n  {\"a\": 1, \"b\": \"x\"},\n  {\"a\": 2, \"b\": null}\n]\n"},
+		{"width", []string{`\pset width 3`}, "Width is 3.\na  b\n1  x\n2  NU…\n(2 rows)\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh, out := testShell(t)
+			if err := sh.exec("create table t (a int, b text); insert into t values (1, 'x'); insert into t values (2, null)"); err != nil {
+				t.Fatal(err)
+			}
+
+			in := strings.Join(append(tt.commands, "select a, b from t;"), "\n")
+			if err := sh.run(strings.NewReader(in), false); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestShellTruncatesWideValues(t *testing.T) {
+	sh, out := testShell(t)
+	wide := strings.Repeat("é", defaultWidth+10)
+	if err := sh.exec("select '" + wide + "' as v, 'short' as w"); err != nil {
+		t.Fatal(err)
+	}
+
+	cut := strings.Repeat("é", defaultWidth-1) + "…"
+	if want := "v" + strings.Repeat(" ", defaultWidth+1) + "w\n" + cut + "  short\n(1 row)\n"; out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	// Expanded output shows the whole value
+	out.Reset()
+	sh.expanded = true
+	if err := sh.exec("select '" + wide + "' as v"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "v | "+wide+"\n") {
+		t.Errorf("expanded output cut the value:\n%s", out.String())
+	}
+}
+
+func TestShellWatch(t *testing.T) {
+	sh, out := testShell(t)
+	in := strings.Join([]string{
+		"create table t (a int);",
+		"insert into t values (1);",
+		"select count(*) from t;",
+		`\watch 0.01 3`,
+	}, "\n")
+	if err := sh.run(strings.NewReader(in), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(out.String(), " (every 0.01s)\n\n?column?\n1\n(1 row)\n"); n != 3 {
+		t.Errorf("ran the query %d times, want 3:\n%s", n, out.String())
+	}
+	if strings.Contains(out.String(), "\x1b") {
+		t.Errorf("cleared the screen without a terminal:\n%s", out.String())
+	}
+
+	// A statement that fails stops the watch
+	out.Reset()
+	if err := sh.run(strings.NewReader("select count(*) from missing;\n\\watch 0.01 3"), true); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "ERROR: "); n != 2 {
+		t.Errorf("got %d errors, want 2:\n%s", n, out.String())
+	}
+}
+
+func TestShellProgress(t *testing.T) {
+	sh, out, db := testShellDB(t)
+	sh.monitor, sh.progress = db.Conn(), time.Millisecond
+	t.Cleanup(func() { sh.monitor.Close() })
+
+	rows := make([][]interface{}, 200000)
+	for i := range rows {
+		rows[i] = []interface{}{int64(i)}
+	}
+	if err := sh.exec("create table t (a int)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.BulkInsert("t", rows); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sh.exec("create index t_a on t (a)"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\r\x1b[KCREATE INDEX t [") {
+		t.Errorf("drew no progress of building the index:\n%q", out.String())
+	}
+	if !strings.HasSuffix(out.String(), "\r\x1b[K") {
+		t.Errorf("left the progress drawn:\n%q", out.String())
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	tests := []struct {
+		done, total int64
+		want        string
+	}{
+		{0, 100, "ALTER TABLE t [..............................]   0% (0/100 rows)"},
+		{50, 100, "ALTER TABLE t [###############...............]  50% (50/100 rows)"},
+		{100, 100, "ALTER TABLE t [##############################] 100% (100/100 rows)"},
+		{0, 0, "ALTER TABLE t [##############################] 100% (0/0 rows)"},
+	}
+
+	for _, tt := range tests {
+		if got := progressLine("ALTER TABLE", "t", tt.done, tt.total); got != tt.want {
+			t.Errorf("progressLine(%d, %d) = %q, want %q", tt.done, tt.total, got, tt.want)
+		}
+	}
+}
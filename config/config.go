@@ -0,0 +1,580 @@
+// Package config holds the settings of the sgsql server. They are read from
+// a configuration file, the environment and command line flags, each
+// overriding the ones before it:
+//
+//	# sgsql.toml
+//	data = "/var/lib/sgsql/app.db"
+//	engine = "bolt"
+//	http = ":8080"
+//	synchronous = true
+//	memory-budget = 67_108_864
+//	log-level = "warn"
+//
+// The file is TOML with only keys and values: strings, integers, floats and
+// booleans, without tables or arrays. Durations and log levels are written
+// as strings, like "1h30m" and "debug". Files ending in .yaml or .yml are
+// read as YAML instead, with the same flat settings written as key: value:
+//
+//	# sgsql.yaml
+//	data: /var/lib/sgsql/app.db
+//	engine: bolt
+//	http: ":8080"
+//	memory-budget: 67108864
+//	log-level: warn
+//
+// Both formats are read without a dependency, so only these flat files are
+// understood: the settings have no structure that nested tables, mappings
+// or lists would describe. Every setting is also read from the
+// environment variable named after it, like SGSQL_MEMORY_BUDGET, and from
+// the flag of the same name, like -memory-budget. The server reads them
+// again on SIGHUP and on RELOAD CONFIG and applies those that can change
+// while it runs, see Config.NeedRestart.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/logging"
+	"github.com/nireo/sgsql/storage"
+	// Registers the engines besides memory the server can store rows in
+	_ "github.com/nireo/sgsql/storage/bolt"
+)
+
+var ErrUnknownSetting = errors.New("Unknown setting")
+
+// Config is how the server opens its database and what it serves it on.
+type Config struct {
+	// Data is the database file, sgsql.MemoryPath for a database kept in
+	// memory only
+	Data string
+	// Engine is the storage engine keeping the rows of the tables, see
+	// sgsql.WithEngine
+	Engine string
+	// HTTP, GRPC, MySQL and Postgres are the addresses the HTTP query API,
+	// the gRPC API and the MySQL and Postgres protocols are served on,
+	// empty for those not served
+	HTTP     string
+	GRPC     string
+	MySQL    string
+	Postgres string
+	// ReadOnly rejects any statement changing the database
+	ReadOnly bool
+	// KeyFile is the file holding the hex encoded key the database is
+	// encrypted with, empty if it isn't encrypted
+	KeyFile string
+	// Synchronous waits for commits to be synced to the statement log on
+	// disk, see sgsql.DB.SetSynchronous
+	Synchronous bool
+	// ResultCache is the number of query results cached, and MemoryBudget
+	// the bytes queries may buffer at once, 0 for no limit
+	ResultCache  int
+	MemoryBudget int64
+	// BufferPoolSize is the bytes of rows the storage engine caches from
+	// its file, see sgsql.DB.SetBufferPoolSize
+	BufferPoolSize int64
+	// AutoVacuum is how many times the statement log grows before it is
+	// vacuumed, 0 for never, see sgsql.DB.SetAutoVacuum
+	AutoVacuum float64
+	// HistoryRetention is how far back AS OF TIMESTAMP can read tables
+	HistoryRetention time.Duration
+	// ExternalDir is the directory external tables may read files from,
+	// none when empty
+	ExternalDir string
+	// AttachDir is the directory ATTACH may open database files in, none
+	// when empty
+	AttachDir string
+	// ForeignKeyIndexes indexes the columns made foreign keys when the key
+	// is created, see sgsql.DB.SetForeignKeyIndexes
+	ForeignKeyIndexes bool
+	// AuditLog is the file the audit log is appended to, none when empty
+	AuditLog string
+	// Credentials is the file of the users clients may connect as, see
+	// server.ReadCredentials. Without one clients can only connect as the
+	// default user
+	Credentials string
+	// TLSCert and TLSKey are the PEM files of the certificate and its key
+	// the Postgres protocol is served over TLS with, which clients must
+	// then use. Without them passwords are sent in the clear
+	TLSCert string
+	TLSKey  string
+	// MaxRows, MaxQueryDuration, MaxConcurrentQueries and QueueQueries are
+	// the quota of every user, see sgsql.Quota. Zero ones don't limit
+	// anything.
+	MaxRows              int
+	MaxQueryDuration     time.Duration
+	MaxConcurrentQueries int
+	QueueQueries         bool
+	// LogLevel is the least important level of the events logged
+	LogLevel logging.Level
+	// SlowQuery is how long a statement runs before it is logged as slow,
+	// 0 for never
+	SlowQuery time.Duration
+	// ShutdownGrace is how long the transactions running when the server
+	// is told to stop get to finish before they are rolled back
+	ShutdownGrace time.Duration
+}
+
+// Default returns the settings used when nothing else is given.
+func Default() *Config {
+	return &Config{
+		Data:           sgsql.MemoryPath,
+		Engine:         "memory",
+		Synchronous:    true,
+		BufferPoolSize: storage.DefaultBufferPoolSize,
+		LogLevel:       logging.LevelInfo,
+		ShutdownGrace:  10 * time.Second,
+	}
+}
+
+// setting is a setting of Config. field returns a pointer to the field of
+// c it is stored in, and reload is set for the settings a running server
+// applies when it reloads its configuration.
+type setting struct {
+	key    string
+	usage  string
+	reload bool
+	field  func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{"data", "database file to serve", false, func(c *Config) interface{} { return &c.Data }},
+	{"engine", "storage engine keeping the rows of tables: memory, or bolt for tables larger than memory", false, func(c *Config) interface{} { return &c.Engine }},
+	{"http", "address to serve the HTTP query API on, e.g. :8080", false, func(c *Config) interface{} { return &c.HTTP }},
+	{"grpc", "address to serve the gRPC API on, e.g. :9090", false, func(c *Config) interface{} { return &c.GRPC }},
+	{"mysql", "address to serve the MySQL protocol on, e.g. :3306", false, func(c *Config) interface{} { return &c.MySQL }},
+	{"postgres", "address to serve the Postgres protocol on, e.g. :5432", false, func(c *Config) interface{} { return &c.Postgres }},
+	{"read-only", "reject any statement that changes the database", false, func(c *Config) interface{} { return &c.ReadOnly }},
+	{"keyfile", "file holding the hex encoded key the database is encrypted with", false, func(c *Config) interface{} { return &c.KeyFile }},
+	{"synchronous", "wait for commits to be synced to disk, off risks losing the last commits in a crash", true, func(c *Config) interface{} { return &c.Synchronous }},
+	{"result-cache", "number of query results to cache, 0 for none", true, func(c *Config) interface{} { return &c.ResultCache }},
+	{"memory-budget", "bytes queries may buffer at once, 0 for no limit", true, func(c *Config) interface{} { return &c.MemoryBudget }},
+	{"buffer-pool-size", "bytes of rows the bolt engine caches from its file, 0 for none", true, func(c *Config) interface{} { return &c.BufferPoolSize }},
+	{"auto-vacuum", "vacuum the statement log once it grows this many times its size, 0 for never", true, func(c *Config) interface{} { return &c.AutoVacuum }},
+	{"history-retention", "how far back AS OF TIMESTAMP can read tables, e.g. 1h", true, func(c *Config) interface{} { return &c.HistoryRetention }},
+	{"external-dir", "directory external tables may read files from, none when empty", true, func(c *Config) interface{} { return &c.ExternalDir }},
+	{"attach-dir", "directory ATTACH may open database files in, only in-memory databases when empty", true, func(c *Config) interface{} { return &c.AttachDir }},
+	{"foreign-key-indexes", "index the columns made foreign keys that aren't indexed yet when the key is created", true, func(c *Config) interface{} { return &c.ForeignKeyIndexes }},
+	{"audit-log", "file to append the audit log of DDL, GRANT and admin statements to, none when empty", false, func(c *Config) interface{} { return &c.AuditLog }},
+	{"credentials", "file of the users clients may connect as with their password hashes, only the default user when empty", false, func(c *Config) interface{} { return &c.Credentials }},
+	{"tls-cert", "PEM certificate to serve the Postgres protocol over TLS with, required of clients once set", false, func(c *Config) interface{} { return &c.TLSCert }},
+	{"tls-key", "PEM key of the certificate of tls-cert", false, func(c *Config) interface{} { return &c.TLSKey }},
+	{"max-rows", "most rows a statement may return, 0 for no limit", true, func(c *Config) interface{} { return &c.MaxRows }},
+	{"max-query-duration", "longest a query may run before it is canceled, e.g. 30s, 0 for no limit", true, func(c *Config) interface{} { return &c.MaxQueryDuration }},
+	{"max-concurrent-queries", "most queries of a user that may run at once, 0 for no limit", true, func(c *Config) interface{} { return &c.MaxConcurrentQueries }},
+	{"queue-queries", "queue the queries of a user beyond max-concurrent-queries instead of rejecting them", true, func(c *Config) interface{} { return &c.QueueQueries }},
+	{"log-level", "least important events logged to stderr: debug, info, warn or error", true, func(c *Config) interface{} { return &c.LogLevel }},
+	{"slow-query", "log statements running at least this long as warnings, e.g. 500ms, 0 for none", true, func(c *Config) interface{} { return &c.SlowQuery }},
+	{"shutdown-grace", "how long running transactions get to finish on SIGTERM before they are rolled back", false, func(c *Config) interface{} { return &c.ShutdownGrace }},
+}
+
+func lookup(key string) (*setting, bool) {
+	for i := range settings {
+		if settings[i].key == key {
+			return &settings[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Set sets the setting called key to value, written as it is given on the
+// command line.
+func (c *Config) Set(key, value string) error {
+	s, ok := lookup(key)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+
+	if err := set(s.field(c), value); err != nil {
+		return fmt.Errorf("Invalid %s %q: %s", key, value, err)
+	}
+
+	return nil
+}
+
+func set(field interface{}, value string) error {
+	switch field := field.(type) {
+	case *string:
+		*field = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("expected true or false")
+		}
+		*field = b
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return errors.New("expected a whole number of at least 0")
+		}
+		*field = n
+	case *int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return errors.New("expected a whole number of at least 0")
+		}
+		*field = n
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return errors.New("expected a number of at least 0")
+		}
+		*field = f
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return errors.New("expected a duration of at least 0, like 90s or 1h")
+		}
+		*field = d
+	case *logging.Level:
+		l, err := logging.ParseLevel(value)
+		if err != nil {
+			return errors.New("expected debug, info, warn or error")
+		}
+		*field = l
+	}
+
+	return nil
+}
+
+// Validate checks what Set doesn't: that the addresses are host:port and
+// that there is a database file.
+func (c *Config) Validate() error {
+	for _, addr := range []struct{ key, value string }{{"http", c.HTTP}, {"grpc", c.GRPC}, {"mysql", c.MySQL}, {"postgres", c.Postgres}} {
+		if addr.value == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(addr.value); err != nil {
+			return fmt.Errorf("Invalid %s %q: expected host:port, or :port for every host", addr.key, addr.value)
+		}
+	}
+
+	if c.Data == "" {
+		return errors.New("Invalid data \"\": expected a file, or " + sgsql.MemoryPath)
+	}
+
+	engines := storage.Engines()
+	i := sort.SearchStrings(engines, c.Engine)
+	if i == len(engines) || engines[i] != c.Engine {
+		return fmt.Errorf("Invalid engine %q: expected one of %s", c.Engine, strings.Join(engines, ", "))
+	}
+
+	return nil
+}
+
+// NeedRestart returns the settings that differ between c and running, the
+// configuration a server runs with, but are only applied when it starts.
+func (c *Config) NeedRestart(running *Config) []string {
+	keys := []string{}
+	for _, s := range settings {
+		if !s.reload && reflect.ValueOf(s.field(c)).Elem().Interface() != reflect.ValueOf(s.field(running)).Elem().Interface() {
+			keys = append(keys, s.key)
+		}
+	}
+
+	return keys
+}
+
+// ReadFile reads the settings of the file called name into c, with ReadYAML
+// if its name ends in .yaml or .yml and Read otherwise. Errors name the file
+// and the line they are on.
+func (c *Config) ReadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	read := c.Read
+	if ext := strings.ToLower(filepath.Ext(name)); ext == ".yaml" || ext == ".yml" {
+		read = c.ReadYAML
+	}
+	if err := read(f); err != nil {
+		return fmt.Errorf("%s:%w", name, err)
+	}
+
+	return nil
+}
+
+// Read reads the settings of a TOML configuration file from r into c,
+// leaving those it doesn't set as they are. Errors start with the number of
+// the line they are on.
+func (c *Config) Read(r io.Reader) error {
+	return c.read(r, c.readLine)
+}
+
+// ReadYAML reads the settings of a YAML configuration file from r into c
+// like Read does.
+func (c *Config) ReadYAML(r io.Reader) error {
+	return c.read(r, c.readYAMLLine)
+}
+
+func (c *Config) read(r io.Reader, readLine func(string, map[string]bool) error) error {
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if err := readLine(scanner.Text(), seen); err != nil {
+			return fmt.Errorf("%d: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (c *Config) readLine(text string, seen map[string]bool) error {
+	text = strings.TrimSpace(text)
+	if text == "" || text[0] == '#' {
+		return nil
+	}
+	if text[0] == '[' {
+		return errors.New("Tables aren't supported, settings are written as key = value")
+	}
+
+	eq := strings.IndexByte(text, '=')
+	if eq < 0 {
+		return errors.New("Expected key = value")
+	}
+
+	key := strings.TrimSpace(text[:eq])
+	if err := c.checkKey(key, seen); err != nil {
+		return err
+	}
+
+	value, quoted, err := parseValue(strings.TrimSpace(text[eq+1:]))
+	if err != nil {
+		return fmt.Errorf("Invalid %s: %s", key, err)
+	}
+
+	// Durations and levels are written as strings like in the environment
+	// and on the command line, everything else by its TOML type
+	switch s, _ := lookup(key); s.field(c).(type) {
+	case *string, *time.Duration, *logging.Level:
+		if !quoted {
+			return fmt.Errorf("Invalid %s: expected a string in quotes", key)
+		}
+	default:
+		if quoted {
+			return fmt.Errorf("Invalid %s: expected a value without quotes", key)
+		}
+	}
+
+	return c.Set(key, value)
+}
+
+// readYAMLLine reads a line of a YAML file. Strings needn't be quoted in
+// YAML, so only the other settings are checked for their type.
+func (c *Config) readYAMLLine(text string, seen map[string]bool) error {
+	trimmed := strings.TrimSpace(text)
+	switch {
+	case trimmed == "" || trimmed[0] == '#' || trimmed == "---" || trimmed == "...":
+		return nil
+	case text[0] == ' ' || text[0] == '\t':
+		return errors.New("Nested settings aren't supported, settings are written as key: value")
+	case trimmed[0] == '-':
+		return errors.New("Lists aren't supported, settings are written as key: value")
+	}
+
+	colon := strings.Index(trimmed+" ", ": ")
+	if colon < 0 {
+		return errors.New("Expected key: value")
+	}
+
+	key := trimmed[:colon]
+	if err := c.checkKey(key, seen); err != nil {
+		return err
+	}
+
+	value, quoted, err := parseYAMLValue(strings.TrimSpace(trimmed[colon+1:]))
+	if err != nil {
+		return fmt.Errorf("Invalid %s: %s", key, err)
+	}
+
+	switch s, _ := lookup(key); s.field(c).(type) {
+	case *string, *time.Duration, *logging.Level:
+	default:
+		if quoted {
+			return fmt.Errorf("Invalid %s: expected a value without quotes", key)
+		}
+	}
+
+	return c.Set(key, value)
+}
+
+// checkKey checks that key is a setting that isn't in seen yet, and adds it.
+func (c *Config) checkKey(key string, seen map[string]bool) error {
+	if _, ok := lookup(key); !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	if seen[key] {
+		return fmt.Errorf("%s is set twice", key)
+	}
+	seen[key] = true
+
+	return nil
+}
+
+// parseValue parses a TOML value followed by an optional comment, returning
+// its text and whether it was a string.
+func parseValue(text string) (string, bool, error) {
+	var value, rest string
+	quoted := true
+	switch {
+	case text == "":
+		return "", false, errors.New("expected a value")
+	case text[0] == '"':
+		end := 1
+		for ; end < len(text) && text[end] != '"'; end++ {
+			if text[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(text) {
+			return "", false, errors.New("unterminated string")
+		}
+
+		unquoted, err := strconv.Unquote(text[:end+1])
+		if err != nil {
+			return "", false, errors.New("invalid string")
+		}
+		value, rest = unquoted, text[end+1:]
+	case text[0] == '\'':
+		end := strings.IndexByte(text[1:], '\'')
+		if end < 0 {
+			return "", false, errors.New("unterminated string")
+		}
+		value, rest = text[1:end+1], text[end+2:]
+	default:
+		quoted = false
+		value = text
+		if hash := strings.IndexByte(text, '#'); hash >= 0 {
+			value = text[:hash]
+		}
+		// Digits can be grouped with underscores
+		value = strings.ReplaceAll(strings.TrimSpace(value), "_", "")
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return "", false, fmt.Errorf("unexpected %s after the value", rest)
+	}
+
+	return value, quoted, nil
+}
+
+// parseYAMLValue parses a YAML scalar followed by an optional comment,
+// returning its text and whether it was quoted.
+func parseYAMLValue(text string) (string, bool, error) {
+	switch {
+	case text == "" || text[0] == '#':
+		return "", false, errors.New("expected a value")
+	case text[0] == '[' || text[0] == '{':
+		return "", false, errors.New("expected a single value")
+	case text[0] == '\'':
+		// Quotes are written twice in single quoted strings
+		var value strings.Builder
+		for i := 1; i < len(text); i++ {
+			if text[i] != '\'' {
+				value.WriteByte(text[i])
+				continue
+			}
+			if i+1 < len(text) && text[i+1] == '\'' {
+				value.WriteByte('\'')
+				i++
+				continue
+			}
+			if rest := strings.TrimSpace(text[i+1:]); rest != "" && rest[0] != '#' {
+				return "", false, fmt.Errorf("unexpected %s after the value", rest)
+			}
+			return value.String(), true, nil
+		}
+		return "", false, errors.New("unterminated string")
+	case text[0] == '"':
+		return parseValue(text)
+	}
+
+	// A comment in a plain value starts after a space
+	if hash := strings.Index(text, " #"); hash >= 0 {
+		text = strings.TrimSpace(text[:hash])
+	}
+
+	return text, false, nil
+}
+
+// ReadEnv sets the settings of c that are given in the environment, which
+// lookup reads like os.LookupEnv does. The variable of a setting is its
+// name in upper case with dashes as underscores, after SGSQL_.
+func (c *Config) ReadEnv(lookup func(string) (string, bool)) error {
+	for _, s := range settings {
+		name := EnvName(s.key)
+		if value, ok := lookup(name); ok {
+			if err := c.Set(s.key, value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// EnvName returns the environment variable the setting called key is read
+// from.
+func EnvName(key string) string {
+	return "SGSQL_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// Flags are the flags of the settings, which are applied over the settings
+// read from the file and environment once the command line is parsed.
+type Flags struct {
+	fs *flag.FlagSet
+}
+
+// RegisterFlags defines a flag for every setting on fs, named like the
+// setting and with its default value.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	defaults := Default()
+	for _, s := range settings {
+		switch field := s.field(defaults).(type) {
+		case *string:
+			fs.String(s.key, *field, s.usage)
+		case *bool:
+			fs.Bool(s.key, *field, s.usage)
+		case *int:
+			fs.Int(s.key, *field, s.usage)
+		case *int64:
+			fs.Int64(s.key, *field, s.usage)
+		case *float64:
+			fs.Float64(s.key, *field, s.usage)
+		case *time.Duration:
+			fs.Duration(s.key, *field, s.usage)
+		case *logging.Level:
+			fs.String(s.key, strings.ToLower(field.String()), s.usage)
+		}
+	}
+
+	return &Flags{fs: fs}
+}
+
+// Apply sets the settings of c given as flags.
+func (f *Flags) Apply(c *Config) error {
+	var err error
+	f.fs.Visit(func(fl *flag.Flag) {
+		if _, ok := lookup(fl.Name); ok && err == nil {
+			err = c.Set(fl.Name, fl.Value.String())
+		}
+	})
+
+	return err
+}
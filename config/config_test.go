@@ -0,0 +1,255 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql/logging"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name string
+		read func(c *Config) func(string) error
+		file string
+	}{
+		{"toml", func(c *Config) func(string) error {
+			return func(s string) error { return c.Read(strings.NewReader(s)) }
+		}, `
+# sgsql.toml
+data = "/var/lib/sgsql/app.db"
+engine = "bolt"
+http = ':8080' # the API
+synchronous = false
+memory-budget = 67_108_864
+auto-vacuum = 2.5
+history-retention = "1h30m"
+log-level = "warn"
+shutdown-grace = "30s"
+`},
+		{"yaml", func(c *Config) func(string) error {
+			return func(s string) error { return c.ReadYAML(strings.NewReader(s)) }
+		}, `
+---
+# sgsql.yaml
+data: /var/lib/sgsql/app.db
+engine: bolt
+http: ":8080" # the API
+synchronous: false
+memory-budget: 67108864
+auto-vacuum: 2.5
+history-retention: 1h30m
+log-level: 'warn'
+shutdown-grace: 30s
+`},
+	}
+
+	want := Default()
+	want.Data = "/var/lib/sgsql/app.db"
+	want.Engine = "bolt"
+	want.HTTP = ":8080"
+	want.Synchronous = false
+	want.MemoryBudget = 67108864
+	want.AutoVacuum = 2.5
+	want.HistoryRetention = 90 * time.Minute
+	want.LogLevel = logging.LevelWarn
+	want.ShutdownGrace = 30 * time.Second
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			if err := tt.read(c)(tt.file); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c, want) {
+				t.Errorf("got %+v, want %+v", c, want)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml bool
+		file string
+		// err is the start of the error, naming the line and the key
+		err string
+	}{
+		{"unknown", false, "http = \":80\"\nport = 80", "2: Unknown setting: port"},
+		{"twice", false, "http = \":80\"\nhttp = \":81\"", "2: http is set twice"},
+		{"table", false, "[server]", "1: Tables aren't supported"},
+		{"no value", false, "http", "1: Expected key = value"},
+		{"unquoted string", false, "log-level = warn", "1: Invalid log-level: expected a string in quotes"},
+		{"quoted number", false, `max-rows = "10"`, "1: Invalid max-rows: expected a value without quotes"},
+		{"unterminated", false, `data = "app.db`, "1: Invalid data: unterminated string"},
+		{"trailing", false, `data = "app.db" x`, "1: Invalid data: unexpected x after the value"},
+		{"negative", false, "max-rows = -1", `1: Invalid max-rows "-1": expected a whole number of at least 0`},
+		{"duration", false, `max-query-duration = "soon"`, `1: Invalid max-query-duration "soon"`},
+		{"yaml unknown", true, "# settings\nport: 80", "2: Unknown setting: port"},
+		{"yaml twice", true, "http: :80\nhttp: :81", "2: http is set twice"},
+		{"yaml nested", true, "http:\n  address: :80", "1: Invalid http: expected a value"},
+		{"yaml indented", true, "  address: :80", "1: Nested settings aren't supported"},
+		{"yaml list", true, "- http", "1: Lists aren't supported"},
+		{"yaml flow", true, "http: [a, b]", "1: Invalid http: expected a single value"},
+		{"yaml no value", true, "http", "1: Expected key: value"},
+		{"yaml quoted bool", true, "read-only: 'true'", "1: Invalid read-only: expected a value without quotes"},
+		{"yaml unterminated", true, "data: 'app.db", "1: Invalid data: unterminated string"},
+		{"yaml level", true, "log-level: loud", `1: Invalid log-level "loud": expected debug, info, warn or error`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			read := c.Read
+			if tt.yaml {
+				read = c.ReadYAML
+			}
+
+			err := read(strings.NewReader(tt.file))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadYAMLQuotes(t *testing.T) {
+	c := Default()
+	if err := c.ReadYAML(strings.NewReader(`data: 'it''s.db' # quoted` + "\n" + `http: "\x3a80"`)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Data != "it's.db" || c.HTTP != ":80" {
+		t.Errorf("got data %q and http %q", c.Data, c.HTTP)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"sgsql.toml": `http = ":8080"`,
+		"sgsql.yaml": "http: :8080",
+		"sgsql.yml":  "http: :8080",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		c := Default()
+		if err := c.ReadFile(path); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if c.HTTP != ":8080" {
+			t.Errorf("%s: http is %q", name, c.HTTP)
+		}
+	}
+
+	path := filepath.Join(dir, "bad.toml")
+	if err := os.WriteFile(path, []byte("\nport = 80"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Default().ReadFile(path); err == nil || err.Error() != path+":2: Unknown setting: port" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestReadEnv(t *testing.T) {
+	env := map[string]string{
+		"SGSQL_MEMORY_BUDGET": "1024",
+		"SGSQL_READ_ONLY":     "true",
+		"SGSQL_LOG_LEVEL":     "debug",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	c := Default()
+	if err := c.ReadEnv(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if c.MemoryBudget != 1024 || !c.ReadOnly || c.LogLevel != logging.LevelDebug {
+		t.Errorf("got %+v", c)
+	}
+
+	env["SGSQL_MAX_ROWS"] = "many"
+	if err := Default().ReadEnv(lookup); err == nil || !strings.HasPrefix(err.Error(), `SGSQL_MAX_ROWS: Invalid max-rows "many"`) {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestFlagsOverride(t *testing.T) {
+	fs := flag.NewFlagSet("sgsql", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-http", ":9090", "-max-query-duration", "5s", "-log-level", "error"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Default()
+	if err := c.Read(strings.NewReader("http = \":8080\"\nmax-rows = 10")); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Apply(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.HTTP != ":9090" || c.MaxRows != 10 || c.MaxQueryDuration != 5*time.Second || c.LogLevel != logging.LevelError {
+		t.Errorf("got %+v", c)
+	}
+}
+
+func TestSetUnknown(t *testing.T) {
+	if err := Default().Set("port", "80"); !errors.Is(err, ErrUnknownSetting) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownSetting)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(c *Config)
+		err  string
+	}{
+		{"default", func(c *Config) {}, ""},
+		{"addresses", func(c *Config) { c.HTTP, c.Postgres = ":8080", "localhost:5432" }, ""},
+		{"bad address", func(c *Config) { c.MySQL = "3306" }, `Invalid mysql "3306": expected host:port, or :port for every host`},
+		{"no data", func(c *Config) { c.Data = "" }, `Invalid data "": expected a file, or :memory:`},
+		{"bolt", func(c *Config) { c.Engine = "bolt" }, ""},
+		{"unknown engine", func(c *Config) { c.Engine = "paper" }, `Invalid engine "paper": expected one of bolt, memory`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.set(c)
+
+			err := c.Validate()
+			if tt.err == "" && err != nil {
+				t.Errorf("got error %v", err)
+			}
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("got error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNeedRestart(t *testing.T) {
+	running := Default()
+	c := Default()
+	c.LogLevel, c.SlowQuery, c.MaxRows = logging.LevelDebug, time.Second, 5
+	if keys := c.NeedRestart(running); len(keys) != 0 {
+		t.Errorf("restart needed for %v", keys)
+	}
+
+	c.HTTP, c.Data = ":8080", "app.db"
+	if keys := c.NeedRestart(running); !reflect.DeepEqual(keys, []string{"data", "http"}) {
+		t.Errorf("restart needed for %v, want data and http", keys)
+	}
+}
@@ -0,0 +1,526 @@
+package sgsql
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/trace"
+)
+
+var (
+	ErrTxInProgress = errors.New("There is already a transaction in progress")
+	ErrNoTx         = errors.New("There is no transaction in progress")
+	ErrTxAborted    = errors.New("Current transaction is aborted, commands ignored until end of transaction block")
+	ErrTxRolledBack = errors.New("Transaction was aborted and has been rolled back")
+	ErrCopyNoClient = errors.New("COPY streams rows to and from clients of the Postgres protocol only")
+)
+
+// Conn is a session on a database. Settings changed on a Conn only affect
+// the statements run through it.
+//
+// Statements run in a transaction of their own unless the session opened
+// one with BEGIN, which lasts until COMMIT or ROLLBACK. Once a statement in
+// such a transaction fails, the transaction is aborted and every further
+// statement is rejected until it ends. Cursors can only be declared in such
+// a transaction and are closed when it ends.
+type Conn struct {
+	db       *DB
+	readOnly bool
+	tx       *Tx
+	aborted  bool
+	cursors  map[string]*cursor
+	session  *functions.Session
+	state    sessionState
+	quota    Quota
+	// attached are the databases attached with ATTACH by their aliases
+	attached map[string]*attachment
+	// dryRun is set by SetDryRun
+	dryRun bool
+	// listening are the channels the session listens on, and notifications
+	// what is sent on them, both guarded by the listeners of the database
+	listening     map[string]bool
+	notifications chan Notification
+}
+
+// SetReadOnly controls whether the session rejects statements that would
+// change the database. Sessions on a read-only database can't be made
+// writable.
+func (c *Conn) SetReadOnly(readOnly bool) error {
+	if !readOnly && c.db.readOnly {
+		return ErrReadOnly
+	}
+
+	c.readOnly = readOnly
+	return nil
+}
+
+// SetSeed seeds RANDOM() so the session sees the same sequence of numbers
+// every time, which is mostly useful in tests.
+func (c *Conn) SetSeed(seed int64) {
+	c.session.SetSeed(seed)
+}
+
+// SetUser sets the user the session runs as, which CURRENT_USER returns.
+func (c *Conn) SetUser(user string) {
+	c.session.SetUser(user)
+	for _, a := range c.attached {
+		a.session.SetUser(user)
+	}
+
+	c.db.sessions.mu.Lock()
+	c.state.user = c.session.User()
+	c.db.sessions.mu.Unlock()
+}
+
+// ID identifies the session among the open sessions of its database, it is
+// what __sessions lists and KILL takes.
+func (c *Conn) ID() int64 {
+	return c.state.id
+}
+
+// InTransaction reports whether the session is inside a transaction opened
+// with BEGIN.
+func (c *Conn) InTransaction() bool {
+	return c.tx != nil
+}
+
+// Close ends the session, rolling back any transaction it left open and
+// detaching the databases it attached.
+func (c *Conn) Close() error {
+	c.db.removeSession(c)
+
+	c.db.listeners.mu.Lock()
+	c.unlistenAll()
+	c.db.listeners.mu.Unlock()
+
+	var err error
+	if c.tx != nil {
+		err = c.tx.Rollback()
+		c.tx, c.aborted, c.cursors = nil, false, nil
+	}
+
+	if derr := c.detachAll(); err == nil {
+		err = derr
+	}
+	return err
+}
+
+// Begin starts a transaction that is controlled through the returned Tx
+// rather than through SQL. Only one transaction runs at a time, so Begin
+// blocks until any other transaction has finished.
+func (c *Conn) Begin() (*Tx, error) {
+	if c.tx != nil {
+		return nil, ErrTxInProgress
+	}
+
+	return c.begin(), nil
+}
+
+// BulkInsert appends rows to table in the transaction of the session, or in
+// one of its own outside of any, see Tx.BulkInsert. Failing aborts the
+// transaction like a failed statement.
+func (c *Conn) BulkInsert(table string, rows [][]interface{}) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	if c.tx == nil {
+		_, err := c.autocommit(context.Background(), func(tx *Tx) (*Results, error) {
+			return nil, tx.BulkInsert(table, rows)
+		})
+		return err
+	}
+
+	if c.aborted {
+		return ErrTxAborted
+	}
+
+	if err := c.tx.BulkInsert(table, rows); err != nil {
+		c.aborted = true
+		return err
+	}
+	return nil
+}
+
+// autocommit runs fn in its own transaction.
+func (c *Conn) autocommit(ctx context.Context, fn func(*Tx) (*Results, error)) (*Results, error) {
+	tx := c.begin()
+
+	results, err := fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, span := trace.Start(ctx, "sgsql.commit")
+	err = tx.Commit()
+	endSpan(span, err)
+
+	return results, err
+}
+
+// endTx ends the transaction opened with BEGIN. Committing an aborted
+// transaction rolls it back instead.
+func (c *Conn) endTx(commit bool) error {
+	if c.tx == nil {
+		return ErrNoTx
+	}
+
+	tx, aborted := c.tx, c.aborted
+	c.tx, c.aborted, c.cursors = nil, false, nil
+
+	if commit && !aborted {
+		return tx.Commit()
+	}
+
+	if err := tx.Rollback(); err != nil {
+		return err
+	}
+
+	if commit {
+		return ErrTxRolledBack
+	}
+	return nil
+}
+
+// vacuum runs VACUUM, which rewrites the log outside of any transaction.
+// Only the superuser may run it, as it blocks every other session while
+// the log is rewritten.
+func (c *Conn) vacuum() (*Results, error) {
+	if err := c.checkSuperuser("vacuum the database"); err != nil {
+		return nil, err
+	}
+
+	if c.tx != nil {
+		return nil, ErrVacuumInTx
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	reclaimed, err := c.db.Vacuum()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Results{
+		Columns: []backend.ResultColumn{{Type: backend.IntType, Name: "reclaimed_bytes"}},
+		Rows:    [][]interface{}{{reclaimed}},
+	}, nil
+}
+
+// checkSuperuser fails with backend.ErrPermissionDenied unless the session
+// runs as functions.DefaultUser or is privileged, like the statements
+// administering the database require. what is what was denied, like
+// "rekey the database".
+func (c *Conn) checkSuperuser(what string) error {
+	if c.session.User() == functions.DefaultUser || c.session.Privileged() {
+		return nil
+	}
+
+	return fmt.Errorf("%w: only %s may %s", backend.ErrPermissionDenied, functions.DefaultUser, what)
+}
+
+// rekey runs REKEY, which rewrites the log outside of any transaction. Only
+// the superuser may run it, anyone else could lock the owner out.
+func (c *Conn) rekey(stmt *parser.RekeyStatement, args []interface{}) (*Results, error) {
+	if err := c.checkSuperuser("rekey the database"); err != nil {
+		return nil, err
+	}
+
+	if c.tx != nil {
+		return nil, ErrRekeyInTx
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	var key []byte
+	var err error
+	if stmt.Key.Type == parser.LiteralType {
+		key, err = hex.DecodeString(stmt.Key.Literal.String)
+	} else if n := stmt.Key.Param; n > uint(len(args)) {
+		return nil, fmt.Errorf("%w: $%d", backend.ErrMissingParameter, n)
+	} else {
+		switch v := args[n-1].(type) {
+		case []byte:
+			key = v
+		case string:
+			key, err = hex.DecodeString(v)
+		default:
+			err = errKeyFormat
+		}
+	}
+	if err != nil {
+		return nil, errKeyFormat
+	}
+
+	return &Results{}, c.db.Rekey(key)
+}
+
+// admin runs a statement administering the database rather than reading or
+// changing its tables.
+func (c *Conn) admin(stmt *parser.Statement, args []interface{}) (*Results, error) {
+	switch stmt.Type {
+	case parser.VacuumType:
+		return c.vacuum()
+	case parser.RekeyType:
+		return c.rekey(stmt.RekeyStatement, args)
+	case parser.KillType:
+		return c.kill(stmt.KillStatement, args)
+	case parser.AttachType:
+		return c.attach(stmt.AttachStatement)
+	case parser.ReloadConfigType:
+		return c.reloadConfig()
+	default:
+		return c.detach(stmt.DetachStatement)
+	}
+}
+
+// exec runs a single statement in the session.
+func (c *Conn) exec(ctx context.Context, stmt *parser.Statement, args []interface{}) (*Results, error) {
+	switch stmt.Type {
+	case parser.BeginType:
+		if c.tx != nil {
+			return nil, ErrTxInProgress
+		}
+
+		c.tx = c.begin()
+		return &Results{}, nil
+	case parser.CommitType:
+		return &Results{}, c.endTx(true)
+	case parser.RollbackType:
+		return &Results{}, c.endTx(false)
+	case parser.VacuumType, parser.RekeyType, parser.KillType, parser.AttachType, parser.DetachType, parser.ReloadConfigType:
+		if c.dryRun {
+			return &Results{}, nil
+		}
+
+		results, err := c.admin(stmt, args)
+		c.db.audit(c, c.session, stmt, err)
+		return results, err
+	case parser.DiscardType:
+		return c.discard()
+	case parser.CopyType:
+		return nil, ErrCopyNoClient
+	case parser.PrepareTransactionType:
+		return c.prepareTx(stmt.TwoPhaseStatement)
+	case parser.CommitPreparedType, parser.RollbackPreparedType:
+		return c.execPrepared(stmt)
+	case parser.CreateIndexType:
+		if stmt.CreateIndexStatement.Concurrently && !c.dryRun {
+			results, err := c.createIndexConcurrently(ctx, stmt)
+			c.db.audit(c, c.session, stmt, err)
+			return results, err
+		}
+	}
+
+	if c.tx == nil {
+		switch stmt.Type {
+		case parser.DeclareCursorType:
+			return nil, ErrCursorNoTx
+		case parser.FetchType, parser.CloseType:
+			return nil, ErrNoTx
+		}
+
+		// Queries of system tables alone don't wait for the transaction of
+		// another session to end, so __sessions can show what it runs
+		if !c.dryRun && stmt.Type == parser.SelectType && backend.SystemOnly(stmt.SelectStatement) {
+			return c.querySystem(ctx, stmt, args)
+		}
+
+		return c.autocommit(ctx, func(tx *Tx) (*Results, error) {
+			return tx.exec(ctx, stmt, args)
+		})
+	}
+
+	if c.aborted {
+		return nil, ErrTxAborted
+	}
+
+	var results *Results
+	var err error
+	switch stmt.Type {
+	case parser.DeclareCursorType:
+		results, err = c.declareCursor(ctx, stmt, args)
+	case parser.FetchType:
+		results, err = c.fetch(stmt.FetchStatement)
+	case parser.CloseType:
+		results, err = c.closeCursor(stmt.CloseStatement)
+	default:
+		results, err = c.tx.exec(ctx, stmt, args)
+	}
+	if err != nil {
+		c.aborted = true
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// run runs every statement in ast and returns the results of the last one.
+// It stops before the next statement once ctx is done.
+func (c *Conn) run(ctx context.Context, ast *parser.AST, args []interface{}) (*Results, error) {
+	// Rejected up front so no statement of the query runs at all
+	if c.readOnly && modifies(ast.Statements...) {
+		return nil, ErrReadOnly
+	}
+
+	results := &Results{}
+	for i, stmt := range ast.Statements {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Only the rows of the last statement are streamed, see StreamQuery
+		stmtCtx := backend.WithRowWriter(ctx, nil)
+		if i == len(ast.Statements)-1 && stmt.Type == parser.SelectType {
+			stmtCtx = limitRows(ctx)
+		}
+
+		var err error
+		results, err = c.exec(stmtCtx, stmt, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Exec runs every statement in query, binding args to the $1..$n
+// placeholders.
+func (c *Conn) Exec(query string, args ...interface{}) error {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext is Exec with a context, see QueryContext.
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) error {
+	_, err := c.QueryContext(ctx, query, args...)
+	return err
+}
+
+// Query runs every statement in query and returns the results of the last
+// one.
+func (c *Conn) Query(query string, args ...interface{}) (*Results, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext is Query with a context. The query is traced with the
+// tracer of ctx, or else the one set with SetTracer, and no further
+// statement of it runs once ctx is done. A statement that has started runs
+// to the end.
+func (c *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*Results, error) {
+	ctx, span := c.db.startQuery(ctx, query)
+	ctx, done, err := c.track(ctx, query)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	ast, err := parse(ctx, query)
+	if err != nil {
+		err = done(err)
+		endSpan(span, err)
+		return nil, err
+	}
+
+	results, err := c.run(ctx, ast, args)
+	err = done(err)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// ExecScript runs the statements read from r one at a time without reading
+// all of r into memory first. It stops at the first statement that fails,
+// leaving the ones before it committed unless they are in a transaction
+// the script opened.
+func (c *Conn) ExecScript(r io.Reader) error {
+	scanner := parser.NewStatementScanner(r)
+	for scanner.Scan() {
+		stmt := scanner.Statement()
+		ctx, span := c.db.startQuery(context.Background(), stmt.Text)
+		ctx, done, err := c.track(ctx, stmt.Text)
+		if err == nil {
+			_, err = c.run(ctx, &parser.AST{Statements: []*parser.Statement{stmt}}, nil)
+			err = done(err)
+		}
+		endSpan(span, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Prepare parses query once so it can be run many times with different
+// arguments.
+func (c *Conn) Prepare(query string) (*Stmt, error) {
+	ast, err := parser.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stmt{conn: c, query: query, ast: ast}, nil
+}
+
+// Stmt is a prepared statement.
+type Stmt struct {
+	conn  *Conn
+	query string
+	ast   *parser.AST
+	// ownsConn is set when the statement runs each time in a session made
+	// just for it, conn is closed then
+	ownsConn bool
+}
+
+func (s *Stmt) Exec(args ...interface{}) error {
+	return s.ExecContext(context.Background(), args...)
+}
+
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) error {
+	_, err := s.QueryContext(ctx, args...)
+	return err
+}
+
+func (s *Stmt) Query(args ...interface{}) (*Results, error) {
+	return s.QueryContext(context.Background(), args...)
+}
+
+// QueryContext is Query with a context, see Conn.QueryContext.
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Results, error) {
+	conn := s.conn
+	if s.ownsConn {
+		conn = s.conn.db.Conn()
+		defer conn.Close()
+	}
+
+	ctx, span := conn.db.startQuery(ctx, s.query)
+	ctx, done, err := conn.track(ctx, s.query)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	results, err := conn.run(ctx, s.ast, args)
+	err = done(err)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
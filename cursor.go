@@ -0,0 +1,70 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+var ErrCursorNoTx = errors.New("DECLARE CURSOR can only be used in transaction blocks")
+
+// cursor is a query declared with DECLARE CURSOR. Its rows are computed when
+// it is declared and handed out by FETCH from pos onwards.
+type cursor struct {
+	results *Results
+	pos     int
+}
+
+// declareCursor runs the cursor's query in the session's transaction.
+func (c *Conn) declareCursor(ctx context.Context, stmt *parser.Statement, args []interface{}) (*Results, error) {
+	decl := stmt.DeclareCursorStatement
+	if _, ok := c.cursors[decl.Name.Value]; ok {
+		return nil, fmt.Errorf("Cursor %q already exists", decl.Name.Value)
+	}
+
+	results, err := c.tx.exec(ctx, &parser.Statement{
+		SelectStatement: decl.Query,
+		Type:            parser.SelectType,
+	}, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cursors == nil {
+		c.cursors = map[string]*cursor{}
+	}
+	c.cursors[decl.Name.Value] = &cursor{results: results}
+
+	return &Results{}, nil
+}
+
+func (c *Conn) fetch(fetch *parser.FetchStatement) (*Results, error) {
+	cur, ok := c.cursors[fetch.Name.Value]
+	if !ok {
+		return nil, fmt.Errorf("Cursor %q does not exist", fetch.Name.Value)
+	}
+
+	end := len(cur.results.Rows)
+	if !fetch.All && fetch.Count < uint64(end-cur.pos) {
+		end = cur.pos + int(fetch.Count)
+	}
+
+	results := &Results{
+		Columns: cur.results.Columns,
+		Rows:    cur.results.Rows[cur.pos:end],
+	}
+	cur.pos = end
+
+	return results, nil
+}
+
+func (c *Conn) closeCursor(cls *parser.CloseStatement) (*Results, error) {
+	if _, ok := c.cursors[cls.Name.Value]; !ok {
+		return nil, fmt.Errorf("Cursor %q does not exist", cls.Name.Value)
+	}
+
+	delete(c.cursors, cls.Name.Value)
+	return &Results{}, nil
+}
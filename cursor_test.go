@@ -0,0 +1,76 @@
+package sgsql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db, "create table t (id int, name text)")
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := db.Exec("insert into t values ($1, $2)", int64(i+1), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := db.Conn()
+	defer c.Close()
+
+	if err := c.Exec("declare c cursor for select id from t"); !errors.Is(err, ErrCursorNoTx) {
+		t.Fatalf("got %v declaring outside a transaction, want %v", err, ErrCursorNoTx)
+	}
+	if err := c.Exec("fetch c"); !errors.Is(err, ErrNoTx) {
+		t.Fatalf("got %v fetching outside a transaction, want %v", err, ErrNoTx)
+	}
+
+	mustExec(t, c, "begin")
+	if err := c.Exec("declare c cursor for select id, name from t where id > $1", int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	// The rows are those when the cursor was declared
+	mustExec(t, c, "insert into t values (6, 'f')")
+
+	tests := []struct {
+		fetch string
+		rows  [][]interface{}
+	}{
+		{"fetch 2 from c", [][]interface{}{{int64(2), "b"}, {int64(3), "c"}}},
+		{"fetch c", [][]interface{}{{int64(4), "d"}}},
+		{"fetch 0 c", nil},
+		{"fetch all from c", [][]interface{}{{int64(5), "e"}}},
+		{"fetch 10 c", nil},
+	}
+	for _, tt := range tests {
+		results, err := c.Query(tt.fetch)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fetch, err)
+		}
+		if len(results.Columns) != 2 || results.Columns[1].Name != "name" {
+			t.Errorf("%s: got columns %v", tt.fetch, results.Columns)
+		}
+		if len(results.Rows) != len(tt.rows) || (len(tt.rows) > 0 && !reflect.DeepEqual(results.Rows, tt.rows)) {
+			t.Errorf("%s: got %v, want %v", tt.fetch, results.Rows, tt.rows)
+		}
+	}
+
+	if err := c.Exec("declare c cursor for select id from t"); err == nil {
+		t.Fatal("Declared a cursor twice")
+	}
+	mustExec(t, c, "rollback", "begin", "declare c cursor for select id from t", "close c")
+	if err := c.Exec("fetch c"); err == nil {
+		t.Fatal("Fetched from a closed cursor")
+	}
+	// Fetching from a cursor that doesn't exist aborts the transaction
+	if err := c.Exec("select 1"); !errors.Is(err, ErrTxAborted) {
+		t.Fatalf("got %v, want %v", err, ErrTxAborted)
+	}
+
+	// Cursors end with their transaction
+	mustExec(t, c, "rollback", "begin", "declare c cursor for select id from t", "commit", "begin")
+	if err := c.Exec("fetch c"); err == nil {
+		t.Fatal("Fetched from a cursor of a committed transaction")
+	}
+	mustExec(t, c, "rollback")
+}
@@ -0,0 +1,356 @@
+package sgsql
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// changeQueue is how many committed transactions a change stream holds
+// until they are read. A stream falling further behind is ended with
+// ErrStreamLagged rather than holding up the transactions committing.
+const changeQueue = 1024
+
+var (
+	ErrStreamLagged   = errors.New("Change stream fell too far behind the committed transactions")
+	ErrStreamClosed   = errors.New("Change stream is closed")
+	ErrUnknownDecoder = errors.New("No decoder is registered with that name")
+)
+
+// Change is a transaction committed to a database, made of the statements
+// it wrote to the statement log. Replaying the changes in order on a copy of
+// the database taken before the first one keeps the copy up to date.
+type Change struct {
+	// Seq numbers the transactions committed since the database was opened
+	Seq        uint64
+	Committed  time.Time
+	Statements []StatementChange
+}
+
+// StatementChange is a statement of a committed transaction. Params are
+// bound to its $1..$n placeholders, and User is the user it ran as, empty
+// for the default one.
+type StatementChange struct {
+	Query  string
+	Params []interface{}
+	User   string
+}
+
+// Decoder writes changes in the format an external system consumes them
+// in, so it doesn't need to know how sgsql stores them. Decoders are looked
+// up by name with LookupDecoder, "json" and "protobuf" are built in and
+// others can be added with RegisterDecoder.
+type Decoder interface {
+	// ContentType is the media type of what Decode writes
+	ContentType() string
+	// Decode writes change to w
+	Decode(w io.Writer, change Change) error
+}
+
+var decoders = struct {
+	sync.Mutex
+	byName map[string]Decoder
+}{byName: map[string]Decoder{"json": JSONDecoder{}, "protobuf": ProtobufDecoder{}}}
+
+// RegisterDecoder makes d available as name to LookupDecoder, replacing any
+// decoder registered as name before.
+func RegisterDecoder(name string, d Decoder) {
+	decoders.Lock()
+	defer decoders.Unlock()
+
+	decoders.byName[name] = d
+}
+
+// LookupDecoder returns the decoder registered as name.
+func LookupDecoder(name string) (Decoder, error) {
+	decoders.Lock()
+	defer decoders.Unlock()
+
+	d, ok := decoders.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDecoder, name)
+	}
+
+	return d, nil
+}
+
+// Decoders returns the names of the registered decoders in order.
+func Decoders() []string {
+	decoders.Lock()
+	defer decoders.Unlock()
+
+	names := make([]string, 0, len(decoders.byName))
+	for name := range decoders.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// changeStreams holds the open change streams of a database.
+type changeStreams struct {
+	mu      sync.Mutex
+	seq     uint64
+	streams map[*ChangeStream]bool
+}
+
+// ChangeStream receives the transactions committed to a database while it
+// is open, see DB.StreamChanges.
+type ChangeStream struct {
+	db      *DB
+	changes chan Change
+	// err ends the stream once the changes before it are read, it is
+	// guarded by the change streams of the database
+	err error
+}
+
+// StreamChanges opens a stream of the transactions committed to the
+// database from now on, in the order they commit. Rolled back transactions
+// are left out, apart from the sequence values they used up.
+func (db *DB) StreamChanges() *ChangeStream {
+	s := &ChangeStream{db: db, changes: make(chan Change, changeQueue)}
+
+	db.changes.mu.Lock()
+	defer db.changes.mu.Unlock()
+
+	if db.changes.streams == nil {
+		db.changes.streams = map[*ChangeStream]bool{}
+	}
+	db.changes.streams[s] = true
+	return s
+}
+
+// Next returns the next committed transaction, waiting for one until ctx
+// is done. Once the stream has fallen too far behind it returns
+// ErrStreamLagged, and the changes after those it returned are lost.
+func (s *ChangeStream) Next(ctx context.Context) (Change, error) {
+	select {
+	case change, ok := <-s.changes:
+		if !ok {
+			s.db.changes.mu.Lock()
+			defer s.db.changes.mu.Unlock()
+			return Change{}, s.err
+		}
+		return change, nil
+	case <-ctx.Done():
+		return Change{}, ctx.Err()
+	}
+}
+
+// Close stops the stream, Next fails with ErrStreamClosed after it.
+func (s *ChangeStream) Close() {
+	s.db.changes.mu.Lock()
+	defer s.db.changes.mu.Unlock()
+
+	s.end(ErrStreamClosed)
+}
+
+// end ends the stream with err. It must be called with the change streams
+// of the database locked.
+func (s *ChangeStream) end(err error) {
+	if !s.db.changes.streams[s] {
+		return
+	}
+
+	delete(s.db.changes.streams, s)
+	s.err = err
+	close(s.changes)
+}
+
+// publish sends the transaction that wrote entries to the statement log to
+// the change streams. It must be called before the database is unlocked,
+// so the streams receive the transactions in the order they commit.
+func (db *DB) publish(entries []logEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	db.changes.mu.Lock()
+	defer db.changes.mu.Unlock()
+
+	db.changes.seq++
+	if len(db.changes.streams) == 0 {
+		return
+	}
+
+	change := Change{Seq: db.changes.seq, Committed: time.Now().UTC()}
+	for _, entry := range entries {
+		if entry.Rows == nil {
+			change.Statements = append(change.Statements, StatementChange{Query: entry.Query, Params: entry.Params, User: entry.User})
+		}
+		// A bulk insert shows as the statements inserting each row
+		for _, params := range entry.Rows {
+			change.Statements = append(change.Statements, StatementChange{Query: entry.Query, Params: params, User: entry.User})
+		}
+	}
+
+	for s := range db.changes.streams {
+		select {
+		case s.changes <- change:
+		default:
+			s.end(ErrStreamLagged)
+		}
+	}
+}
+
+// closeStreams ends every change stream of the database.
+func (db *DB) closeStreams() {
+	db.changes.mu.Lock()
+	defer db.changes.mu.Unlock()
+
+	for s := range db.changes.streams {
+		s.end(ErrStreamClosed)
+	}
+}
+
+// JSONDecoder writes each change as a JSON object on a line of its own:
+//
+//	{"seq":1,"committed":"...","statements":[{"query":"...","params":[...],"user":"..."}]}
+type JSONDecoder struct{}
+
+type jsonChange struct {
+	Seq        uint64          `json:"seq"`
+	Committed  time.Time       `json:"committed"`
+	Statements []jsonStatement `json:"statements"`
+}
+
+type jsonStatement struct {
+	Query  string        `json:"query"`
+	Params []interface{} `json:"params,omitempty"`
+	User   string        `json:"user,omitempty"`
+}
+
+func (JSONDecoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (JSONDecoder) Decode(w io.Writer, change Change) error {
+	out := jsonChange{Seq: change.Seq, Committed: change.Committed, Statements: []jsonStatement{}}
+	for _, stmt := range change.Statements {
+		out.Statements = append(out.Statements, jsonStatement{Query: stmt.Query, Params: stmt.Params, User: stmt.User})
+	}
+
+	return json.NewEncoder(w).Encode(out)
+}
+
+// ProtobufDecoder writes each change as a protocol buffers message
+// preceded by its length as a varint, the usual framing for a stream of
+// them. The messages are:
+//
+//	message Change {
+//	  uint64 seq = 1;
+//	  int64 committed_unix_nanos = 2;
+//	  repeated Statement statements = 3;
+//	}
+//
+//	message Statement {
+//	  string query = 1;
+//	  repeated Value params = 2;
+//	  string user = 3;
+//	}
+//
+//	message Value {
+//	  oneof value {
+//	    bool null = 1;
+//	    int64 int = 2;
+//	    double float = 3;
+//	    string text = 4;
+//	    bool bool = 5;
+//	    bytes bytes = 6;
+//	    int64 timestamp_unix_nanos = 7;
+//	  }
+//	}
+//
+// Parameters of other types are sent as their text.
+type ProtobufDecoder struct{}
+
+// Wire types of protocol buffers
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+)
+
+func (ProtobufDecoder) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (ProtobufDecoder) Decode(w io.Writer, change Change) error {
+	var msg []byte
+	msg = pbAppendVarint(msg, 1, change.Seq)
+	msg = pbAppendVarint(msg, 2, uint64(change.Committed.UnixNano()))
+	for _, stmt := range change.Statements {
+		var s []byte
+		s = pbAppendBytes(s, 1, []byte(stmt.Query))
+		for _, param := range stmt.Params {
+			s = pbAppendBytes(s, 2, pbValue(param))
+		}
+		if stmt.User != "" {
+			s = pbAppendBytes(s, 3, []byte(stmt.User))
+		}
+		msg = pbAppendBytes(msg, 3, s)
+	}
+
+	_, err := w.Write(append(pbUvarint(nil, uint64(len(msg))), msg...))
+	return err
+}
+
+// pbValue encodes v as a Value message.
+func pbValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return pbAppendVarint(nil, 1, 1)
+	case int:
+		return pbAppendVarint(nil, 2, uint64(v))
+	case int64:
+		return pbAppendVarint(nil, 2, uint64(v))
+	case float64:
+		return pbAppendFixed64(nil, 3, math.Float64bits(v))
+	case string:
+		return pbAppendBytes(nil, 4, []byte(v))
+	case bool:
+		var b uint64
+		if v {
+			b = 1
+		}
+		return pbAppendVarint(nil, 5, b)
+	case []byte:
+		return pbAppendBytes(nil, 6, v)
+	case time.Time:
+		return pbAppendVarint(nil, 7, uint64(v.UnixNano()))
+	default:
+		return pbAppendBytes(nil, 4, []byte(fmt.Sprint(v)))
+	}
+}
+
+func pbAppendTag(b []byte, field int, wireType int) []byte {
+	return pbUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func pbAppendVarint(b []byte, field int, v uint64) []byte {
+	return pbUvarint(pbAppendTag(b, field, pbVarint), v)
+}
+
+func pbAppendFixed64(b []byte, field int, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(pbAppendTag(b, field, pbFixed64), buf[:]...)
+}
+
+func pbAppendBytes(b []byte, field int, v []byte) []byte {
+	b = pbUvarint(pbAppendTag(b, field, pbBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+func pbUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
@@ -0,0 +1,264 @@
+package sgsql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStreamChanges(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(db *DB) error
+		// queries are those of the change committed, nil when there is none
+		queries []string
+	}{
+		{"insert", func(db *DB) error {
+			return db.Exec("insert into t values ($1, $2)", int64(1), "a")
+		}, []string{"insert into t values ($1, $2)"}},
+		{"select", func(db *DB) error {
+			return db.Exec("select id from t")
+		}, nil},
+		{"committed transaction", func(db *DB) error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec("insert into t values (2, 'b')"); err != nil {
+				return err
+			}
+			if err := tx.Exec("insert into t values (3, 'c')"); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}, []string{"insert into t values (2, 'b')", "insert into t values (3, 'c')"}},
+		{"rolled back transaction", func(db *DB) error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec("insert into t values (4, 'd')"); err != nil {
+				return err
+			}
+			return tx.Rollback()
+		}, nil},
+		{"failed statement", func(db *DB) error {
+			if err := db.Exec("insert into t values ('x', 'y')"); err == nil {
+				return errors.New("inserted text into an int column")
+			}
+			return nil
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := openTest(t)
+			mustExec(t, db, "create table t (id int, name text)")
+
+			s := db.StreamChanges()
+			defer s.Close()
+			if err := tt.run(db); err != nil {
+				t.Fatal(err)
+			}
+			// A marker committed after tells where the changes of the test end
+			mustExec(t, db, "create table marker (id int)")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var queries []string
+			var seq uint64
+			for {
+				change, err := s.Next(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if change.Seq <= seq || change.Committed.IsZero() {
+					t.Errorf("got change %d committed at %v after change %d", change.Seq, change.Committed, seq)
+				}
+				seq = change.Seq
+
+				if change.Statements[0].Query == "create table marker (id int)" {
+					break
+				}
+				if queries != nil {
+					t.Errorf("got a second change %+v", change)
+				}
+				queries = []string{}
+				for _, stmt := range change.Statements {
+					queries = append(queries, stmt.Query)
+				}
+			}
+
+			if !reflect.DeepEqual(queries, tt.queries) {
+				t.Errorf("got a change of %q, want %q", queries, tt.queries)
+			}
+		})
+	}
+}
+
+func TestStreamReplays(t *testing.T) {
+	db, _ := openTest(t)
+	s := db.StreamChanges()
+	defer s.Close()
+
+	mustExec(t, db, "create table t (id int, name text)", "create sequence ids")
+	if err := db.Exec("insert into t values (nextval('ids'), $1)", "a"); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, connAs(t, db, "alice"), "insert into t values (nextval('ids'), current_user())")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	copied, _ := openTest(t)
+	for i := 0; i < 4; i++ {
+		change, err := s.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stmt := range change.Statements {
+			c := copied.Conn()
+			if stmt.User != "" {
+				c.SetUser(stmt.User)
+			}
+			if err := c.Exec(stmt.Query, stmt.Params...); err != nil {
+				t.Fatalf("%s: %v", stmt.Query, err)
+			}
+			c.Close()
+		}
+	}
+
+	want := queryRows(t, db, "select id, name from t")
+	if got := queryRows(t, copied, "select id, name from t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("replaying the changes gives %v, want %v", got, want)
+	}
+}
+
+func TestStreamEnds(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(db *DB, s *ChangeStream)
+		err  error
+	}{
+		{"closed", func(db *DB, s *ChangeStream) { s.Close() }, ErrStreamClosed},
+		{"database closed", func(db *DB, s *ChangeStream) { db.Close() }, ErrStreamClosed},
+		{"lagged", func(db *DB, s *ChangeStream) {
+			for i := 0; i <= changeQueue; i++ {
+				mustExec(t, db, "insert into t values (1)")
+			}
+		}, ErrStreamLagged},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(MemoryPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			mustExec(t, db, "create table t (id int)")
+
+			s := db.StreamChanges()
+			tt.end(db, s)
+
+			// The changes committed before the stream ended are still read
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for {
+				_, err := s.Next(ctx)
+				if err == nil {
+					continue
+				}
+				if !errors.Is(err, tt.err) {
+					t.Errorf("got %v, want %v", err, tt.err)
+				}
+				break
+			}
+		})
+	}
+}
+
+func TestDecoders(t *testing.T) {
+	change := Change{
+		Seq:       3,
+		Committed: time.Unix(1, 5).UTC(),
+		Statements: []StatementChange{
+			{Query: "insert into t values ($1, $2)", Params: []interface{}{int64(1), nil}, User: "alice"},
+			{Query: "select 1"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		want        []byte
+	}{
+		{"json", "application/x-ndjson", []byte(`{"seq":3,"committed":"1970-01-01T00:00:01.000000005Z","statements":[` +
+			`{"query":"insert into t values ($1, $2)","params":[1,null],"user":"alice"},{"query":"select 1"}]}` + "\n")},
+		{"protobuf", "application/x-protobuf", func() []byte {
+			stmt := []byte{0x0a, 29}
+			stmt = append(stmt, "insert into t values ($1, $2)"...)
+			stmt = append(stmt, 0x12, 2, 0x10, 1, 0x12, 2, 0x08, 1, 0x1a, 5)
+			stmt = append(stmt, "alice"...)
+			msg := []byte{0x08, 3, 0x10, 0x85, 0x94, 0xeb, 0xdc, 0x03, 0x1a, byte(len(stmt))}
+			msg = append(msg, stmt...)
+			msg = append(msg, 0x1a, 10, 0x0a, 8)
+			msg = append(msg, "select 1"...)
+			return append([]byte{byte(len(msg))}, msg...)
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := LookupDecoder(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.ContentType() != tt.contentType {
+				t.Errorf("content type %q, want %q", d.ContentType(), tt.contentType)
+			}
+
+			var b bytes.Buffer
+			if err := d.Decode(&b, change); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), tt.want) {
+				t.Errorf("got %q, want %q", b.Bytes(), tt.want)
+			}
+		})
+	}
+
+	if _, err := LookupDecoder("xml"); !errors.Is(err, ErrUnknownDecoder) {
+		t.Errorf("looking up xml: got %v, want %v", err, ErrUnknownDecoder)
+	}
+}
+
+type textDecoder struct{}
+
+func (textDecoder) ContentType() string { return "text/plain" }
+
+func (textDecoder) Decode(w io.Writer, change Change) error {
+	return json.NewEncoder(w).Encode(change.Seq)
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	RegisterDecoder("test", textDecoder{})
+	defer func() {
+		decoders.Lock()
+		delete(decoders.byName, "test")
+		decoders.Unlock()
+	}()
+
+	if d, err := LookupDecoder("test"); err != nil || d != (textDecoder{}) {
+		t.Errorf("looked up %v, %v, want the registered decoder", d, err)
+	}
+	if want := []string{"json", "protobuf", "test"}; !reflect.DeepEqual(Decoders(), want) {
+		t.Errorf("got decoders %v, want %v", Decoders(), want)
+	}
+}
@@ -0,0 +1,93 @@
+package sgsql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setupDependents creates t with an index and statistics, u with a policy,
+// and the views v reading t and w reading v. Views can't read tables with
+// policies.
+func setupDependents(t *testing.T, db *DB) {
+	t.Helper()
+
+	mustExec(t, db,
+		"create table t (a int, b int)",
+		"create index t_a on t (a)",
+		"create table u (a int)",
+		"create policy own on u using (a > 0)",
+		"create statistics s on a, b from t",
+		"create materialized view v as select a from t",
+		"create materialized view w as select a from v",
+	)
+}
+
+func TestDependencies(t *testing.T) {
+	db, _ := openTest(t)
+	setupDependents(t, db)
+
+	want := [][]interface{}{
+		{"index", "t_a", "t", "auto"},
+		{"statistics", "s", "t", "auto"},
+		{"materialized view", "v", "t", "normal"},
+		{"policy", "own", "u", "auto"},
+		{"materialized view", "w", "v", "normal"},
+	}
+	if got := queryRows(t, db, "select * from __dependencies"); !reflect.DeepEqual(got, want) {
+		t.Errorf("__dependencies holds\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestDropCascade(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		// err is part of the error of query, empty when it succeeds
+		err    string
+		tables []string
+	}{
+		{"restrict by default", "drop table t", "v reads t, w reads v, use CASCADE to drop them as well", []string{"t", "u", "v", "w"}},
+		{"restrict", "drop table t restrict", "v reads t", []string{"t", "u", "v", "w"}},
+		{"cascade", "drop table t cascade", "", []string{"u"}},
+		{"with a policy", "drop table u", "", []string{"t", "v", "w"}},
+		{"view read by a view", "drop materialized view v", "w reads v, use CASCADE to drop it as well", []string{"t", "u", "v", "w"}},
+		{"view cascade", "drop materialized view v cascade", "", []string{"t", "u"}},
+		{"last view", "drop materialized view w", "", []string{"t", "u", "v"}},
+		{"view as a table", "drop table v", "use DROP MATERIALIZED VIEW", []string{"t", "u", "v", "w"}},
+		{"table as a view", "drop materialized view t", "not a materialized view", []string{"t", "u", "v", "w"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			setupDependents(t, db)
+
+			err := db.Exec(tt.query)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("%s: %v", tt.query, err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("%s: got %v, want an error containing %q", tt.query, err, tt.err)
+			}
+
+			tables := func() []string {
+				var names []string
+				for _, name := range db.Catalog().Tables() {
+					if !strings.HasPrefix(name, "__") {
+						names = append(names, name)
+					}
+				}
+				return names
+			}
+			if got := tables(); !reflect.DeepEqual(got, tt.tables) {
+				t.Errorf("tables are %v, want %v", got, tt.tables)
+			}
+
+			db = reopen(t, db, path)
+			if got := tables(); !reflect.DeepEqual(got, tt.tables) {
+				t.Errorf("tables are %v after reopening, want %v", got, tt.tables)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package sgsql
+
+import (
+	"context"
+
+	"github.com/nireo/sgsql/analyzer"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+)
+
+// NumParams returns the number of arguments the statement takes, the
+// highest of its $n placeholders.
+func (s *Stmt) NumParams() int {
+	return int(s.ast.Params)
+}
+
+// Describe returns the columns the statement returns, without running it,
+// for protocols that report them when a statement is prepared. Only queries
+// are described, other statements return no columns. The columns are those
+// of the last statement, whose results running the statement returns.
+func (s *Stmt) Describe(ctx context.Context) ([]backend.ResultColumn, error) {
+	if len(s.ast.Statements) == 0 {
+		return nil, nil
+	}
+
+	stmt := s.ast.Statements[len(s.ast.Statements)-1]
+	if stmt.Type != parser.SelectType {
+		return nil, nil
+	}
+
+	conn := s.conn
+	if s.ownsConn {
+		conn = s.conn.db.Conn()
+		defer conn.Close()
+	}
+
+	tx := conn.tx
+	if tx == nil {
+		tx = conn.begin()
+		defer tx.Rollback()
+	}
+
+	return tx.describe(ctx, stmt)
+}
+
+// describe returns the columns the query stmt returns.
+func (tx *Tx) describe(ctx context.Context, stmt *parser.Statement) ([]backend.ResultColumn, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+
+	var catalog backend.Catalog = tx.db.backend
+	if len(tx.attached) > 0 {
+		catalog = attachedCatalog{Catalog: tx.db.backend, attached: tx.attached}
+		ctx = backend.WithAttached(ctx, tx.attachedBackends())
+	}
+
+	if err := analyzer.Analyze(catalog, stmt); err != nil {
+		return nil, err
+	}
+
+	return tx.db.backend.Describe(ctx, stmt.SelectStatement)
+}
+
+// ParamTypes returns the types of the arguments the statement takes, for
+// protocols that report them when a statement is prepared. Each has the
+// type of what its placeholder is compared with or inserted into, or text
+// when nothing gives it one. Only the last statement is checked, whose
+// placeholders are typed without running the ones before it.
+func (s *Stmt) ParamTypes() ([]backend.ColumnType, error) {
+	params := make([]backend.ColumnType, s.NumParams())
+	for i := range params {
+		params[i] = backend.TextType
+	}
+	if len(s.ast.Statements) == 0 {
+		return params, nil
+	}
+
+	conn := s.conn
+	if s.ownsConn {
+		conn = s.conn.db.Conn()
+		defer conn.Close()
+	}
+
+	tx := conn.tx
+	if tx == nil {
+		tx = conn.begin()
+		defer tx.Rollback()
+	}
+
+	inferred, err := tx.paramTypes(s.ast.Statements[len(s.ast.Statements)-1])
+	if err != nil {
+		return nil, err
+	}
+	for n, t := range inferred {
+		if n >= 1 && int(n) <= len(params) {
+			params[n-1] = t
+		}
+	}
+
+	return params, nil
+}
+
+// paramTypes returns the types of the placeholders of stmt by their
+// numbers, see analyzer.ParamTypes.
+func (tx *Tx) paramTypes(stmt *parser.Statement) (map[uint]backend.ColumnType, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+
+	var catalog backend.Catalog = tx.db.backend
+	if len(tx.attached) > 0 {
+		catalog = attachedCatalog{Catalog: tx.db.backend, attached: tx.attached}
+	}
+
+	if err := analyzer.Analyze(catalog, stmt); err != nil {
+		return nil, err
+	}
+
+	return analyzer.ParamTypes(catalog, stmt), nil
+}
@@ -0,0 +1,39 @@
+package sgsql
+
+import "errors"
+
+var ErrDiscardInTx = errors.New("DISCARD ALL can't run inside a transaction block")
+
+// Reset returns the session to the state of a new one, like DISCARD ALL,
+// so a pool can hand it to another client. Any transaction it has open is
+// rolled back, closing its cursors, the databases it attached are detached,
+// it stops listening on every channel and CURRVAL forgets the values
+// NEXTVAL returned. What the application set on it, like its user, quota or
+// read-only mode, stays as it is.
+func (c *Conn) Reset() error {
+	var err error
+	if c.tx != nil {
+		err = c.endTx(false)
+	}
+
+	c.db.listeners.mu.Lock()
+	c.unlistenAll()
+	c.db.listeners.mu.Unlock()
+
+	if derr := c.detachAll(); err == nil {
+		err = derr
+	}
+
+	c.session.Reset()
+	return err
+}
+
+// discard runs DISCARD ALL, which connection poolers issue between the
+// clients they hand a session to.
+func (c *Conn) discard() (*Results, error) {
+	if c.tx != nil {
+		return nil, ErrDiscardInTx
+	}
+
+	return &Results{}, c.Reset()
+}
@@ -0,0 +1,73 @@
+package sgsql
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	tests := []struct {
+		name  string
+		reset func(c *Conn) error
+	}{
+		{"Reset", func(c *Conn) error { return c.Reset() }},
+		{"DISCARD ALL", func(c *Conn) error {
+			// Inside a transaction block it is refused
+			if err := c.Exec("discard all"); !errors.Is(err, ErrDiscardInTx) {
+				return err
+			}
+			if err := c.Exec("rollback"); err != nil {
+				return err
+			}
+			return c.Exec("discard all")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := openTest(t)
+			mustExec(t, db, "create table t (id int)", "create sequence s")
+
+			c := connAs(t, db, "alice")
+			mustExec(t, c,
+				"select nextval('s')",
+				"attach database ':memory:' as aux",
+				"listen news",
+				"begin",
+				"insert into t values (1)",
+			)
+			if err := tt.reset(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if c.InTransaction() {
+				t.Error("the transaction is still open")
+			}
+			if got := queryRows(t, db, "select id from t"); len(got) != 0 {
+				t.Errorf("t holds %v, want the insert rolled back", got)
+			}
+			if err := c.Exec("detach aux"); err == nil {
+				t.Error("aux is still attached")
+			}
+			if err := c.Exec("select currval('s')"); err == nil {
+				t.Error("CURRVAL still knows the value NEXTVAL returned")
+			}
+
+			mustExec(t, db, "notify news, 'hi'")
+			select {
+			case n := <-c.Notifications():
+				t.Errorf("got %v after the reset", n)
+			default:
+			}
+
+			// The user set on the session is kept
+			results, err := c.Query("select current_user()")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user := results.Rows[0][0]; user != "alice" {
+				t.Errorf("runs as %v after the reset, want alice", user)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package sgsql
+
+// diskFree isn't known on platforms without statfs.
+func diskFree(path string) (int64, bool) {
+	return 0, false
+}
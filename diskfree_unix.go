@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package sgsql
+
+import "syscall"
+
+// diskFree returns the bytes unprivileged users can still write to the file
+// system holding path.
+func diskFree(path string) (int64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, false
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
@@ -0,0 +1,59 @@
+package sgsql
+
+import (
+	"context"
+
+	"github.com/nireo/sgsql/analyzer"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+)
+
+// SetDryRun controls whether the session only validates statements instead
+// of running them. Each statement is parsed, analyzed and planned against
+// the tables as they are, and fails with the error running it would have
+// failed with before reading or changing any rows, or returns no rows.
+// That makes it a cheap check of the queries of an application, in CI say.
+//
+// Since nothing runs, a statement using a table created by an earlier one
+// fails as if the table didn't exist. Transactions and ATTACH still work,
+// VACUUM, REKEY and KILL are accepted and ignored. EXPLAIN (VALIDATE)
+// checks a single statement the same way without the session being in dry
+// run.
+func (c *Conn) SetDryRun(dryRun bool) {
+	c.dryRun = dryRun
+}
+
+// dryRun reports whether the statements of tx are only validated.
+func (tx *Tx) dryRun() bool {
+	return tx.conn != nil && tx.conn.dryRun
+}
+
+// validate runs EXPLAIN (VALIDATE), returning whether stmt would run and
+// otherwise the error it would fail with as a row of the results.
+func (tx *Tx) validate(ctx context.Context, catalog backend.Catalog, stmt *parser.Statement) *Results {
+	var err error
+	if tx.readOnly && modifies(stmt) {
+		err = ErrReadOnly
+	}
+	if err == nil && len(tx.attached) > 0 {
+		_, _, _, err = tx.attachedTarget(stmt)
+	}
+	if err == nil {
+		err = analyzer.Analyze(catalog, stmt)
+	}
+	if err == nil {
+		err = tx.db.backend.Validate(ctx, stmt)
+	}
+
+	results := &Results{Columns: []backend.ResultColumn{
+		{Type: backend.BoolType, Name: "valid"},
+		{Type: backend.TextType, Name: "error"},
+	}}
+	if err != nil {
+		results.Rows = [][]interface{}{{false, err.Error()}}
+	} else {
+		results.Rows = [][]interface{}{{true, nil}}
+	}
+
+	return results
+}
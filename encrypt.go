@@ -0,0 +1,186 @@
+package sgsql
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/nireo/sgsql/storage"
+)
+
+var (
+	ErrKeySize   = errors.New("Encryption key must be 16, 24 or 32 bytes")
+	ErrDecrypt   = errors.New("Statement log can't be decrypted, the key is wrong or missing, or the log isn't encrypted")
+	ErrRekeyInTx = errors.New("REKEY can't run inside a transaction block")
+
+	errKeyFormat = errors.New("REKEY takes the key as bytes or a string of hex digits")
+)
+
+// OpenEncrypted opens the database stored at path like Open, encrypting the
+// statement log with key. The key selects AES-128, AES-192 or AES-256 by its
+// length, an empty key opens the database unencrypted.
+func OpenEncrypted(path string, key []byte, opts ...Option) (*DB, error) {
+	return openEncrypted(path, false, key, opts)
+}
+
+// OpenEncryptedReadOnly opens the existing database at path like
+// OpenReadOnly, decrypting its statement log with key.
+func OpenEncryptedReadOnly(path string, key []byte, opts ...Option) (*DB, error) {
+	return openEncrypted(path, true, key, opts)
+}
+
+func openEncrypted(path string, readOnly bool, key []byte, opts []Option) (*DB, error) {
+	c, err := newLogCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(path, readOnly, c, opts)
+}
+
+// encrypted reports whether the statement log of db is encrypted.
+func (db *DB) encrypted() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.cipher != nil
+}
+
+// logCipher encrypts the lines of the statement log with AES-GCM. Each line
+// is sealed with a nonce of its own, which is stored in front of it, and
+// the result is base64 encoded so the log stays one entry per line. The
+// number of the entry is authenticated along with it, so entries that are
+// moved, repeated or left out before others no longer decrypt. Nothing
+// authenticates how many entries there are though: a log cut short after
+// any entry still opens, without the transactions that were cut off. A nil
+// logCipher leaves lines as they are.
+type logCipher struct {
+	aead cipher.AEAD
+}
+
+// newLogCipher returns the cipher for key, which is nil for an empty key.
+func newLogCipher(key []byte) (*logCipher, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrKeySize
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logCipher{aead: aead}, nil
+}
+
+// position is the additional data entry n is sealed with.
+func position(n int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(n))
+	return b[:]
+}
+
+// seal encrypts line as entry n of the log.
+func (c *logCipher) seal(line []byte, n int) ([]byte, error) {
+	if c == nil {
+		return line, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, line, position(n))
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return out, nil
+}
+
+// open decrypts line, which must have been sealed as entry n.
+func (c *logCipher) open(line []byte, n int) ([]byte, error) {
+	if c == nil {
+		return line, nil
+	}
+
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	length, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[:length]
+
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("Entry is too short")
+	}
+
+	return c.aead.Open(nil, sealed[:size], sealed[size:], position(n))
+}
+
+// Rekey rewrites the statement log encrypted with key, or unencrypted when
+// key is empty. Like Vacuum, the new log only replaces the old one once it
+// is completely written.
+func (db *DB) Rekey(key []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.rekey(key)
+}
+
+func (db *DB) rekey(key []byte) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	c, err := newLogCipher(key)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := db.engine.(*storage.Memory); c != nil && !ok {
+		return ErrEngineEncrypted
+	}
+
+	if db.log != nil {
+		old, err := db.openLog()
+		if err != nil {
+			return err
+		}
+		defer old.Close()
+
+		// Entries are copied as they are, so the log keeps its history
+		lr := newLogReader(old, db.cipher)
+		n := 0
+		_, err = db.rewriteLog(func(w io.Writer) error {
+			for {
+				entry, err := lr.next()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+
+				n++
+				if err := writeEntry(w, entry, n, c); err != nil {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		db.entries = n
+	}
+
+	db.cipher = c
+	return nil
+}
@@ -0,0 +1,429 @@
+package sgsql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/logging"
+	"github.com/nireo/sgsql/trace"
+)
+
+var (
+	testKey   = bytes.Repeat([]byte{1}, 32)
+	otherKey  = bytes.Repeat([]byte{2}, 16)
+	secretRow = [][]interface{}{{"top secret"}}
+)
+
+// writeEncrypted writes a database holding secretRow encrypted with key and
+// returns its path.
+func writeEncrypted(t *testing.T, key []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenEncrypted(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db, "create table t (v text)", "insert into t values ('top secret')")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestEncryptedOpen(t *testing.T) {
+	tests := []struct {
+		name       string
+		key, again []byte
+		err        error
+	}{
+		{"same key", testKey, testKey, nil},
+		{"wrong key", testKey, otherKey, ErrDecrypt},
+		{"missing key", testKey, nil, ErrDecrypt},
+		{"key for a plain log", nil, testKey, ErrDecrypt},
+		{"no key", nil, nil, nil},
+		{"key of the wrong size", testKey, []byte("short"), ErrKeySize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeEncrypted(t, tt.key)
+
+			log, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted := !bytes.Contains(log, []byte("top secret")); encrypted != (tt.key != nil) {
+				t.Errorf("log is encrypted = %v, want %v", encrypted, tt.key != nil)
+			}
+
+			db, err := OpenEncrypted(path, tt.again)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			defer db.Close()
+
+			if got := queryRows(t, db, "select v from t"); !reflect.DeepEqual(got, secretRow) {
+				t.Errorf("t holds %v, want %v", got, secretRow)
+			}
+		})
+	}
+}
+
+func TestRekey(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		args  []interface{}
+		key   []byte
+	}{
+		{"literal", "rekey '02020202020202020202020202020202'", nil, otherKey},
+		{"hex parameter", "rekey $1", []interface{}{"02020202020202020202020202020202"}, otherKey},
+		{"bytes parameter", "rekey $1", []interface{}{otherKey}, otherKey},
+		{"decrypt", "rekey ''", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeEncrypted(t, testKey)
+			db, err := OpenEncrypted(path, testKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if err := db.Exec(tt.query, tt.args...); err != nil {
+				t.Fatal(err)
+			}
+			// Entries written after rekeying use the new key too
+			mustExec(t, db, "insert into t values ('more')")
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := OpenEncrypted(path, testKey); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("opening with the old key: got %v, want %v", err, ErrDecrypt)
+			}
+
+			db, err = OpenEncrypted(path, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			want := [][]interface{}{{"top secret"}, {"more"}}
+			if got := queryRows(t, db, "select v from t"); !reflect.DeepEqual(got, want) {
+				t.Errorf("t holds %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRekeyErrors(t *testing.T) {
+	path := writeEncrypted(t, testKey)
+	db, err := OpenEncrypted(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		queries []string
+		args    []interface{}
+		err     error
+	}{
+		{[]string{"rekey 'not hex'"}, nil, errKeyFormat},
+		{[]string{"rekey $1"}, []interface{}{int64(1)}, errKeyFormat},
+		{[]string{"rekey 'abcd'"}, nil, ErrKeySize},
+		{[]string{"begin", "rekey ''"}, nil, ErrRekeyInTx},
+	}
+
+	for _, tt := range tests {
+		c := db.Conn()
+		var err error
+		for _, query := range tt.queries {
+			if err = c.Exec(query, tt.args...); err != nil {
+				break
+			}
+		}
+		c.Close()
+
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: got %v, want %v", tt.queries, err, tt.err)
+		}
+	}
+
+	// The log still opens with the key it had
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenEncrypted(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if got := queryRows(t, db, "select v from t"); !reflect.DeepEqual(got, secretRow) {
+		t.Errorf("t holds %v, want %v", got, secretRow)
+	}
+}
+
+// moveEntries returns a damage function for writeEncryptedLog that builds
+// the log from the entries of the intact one, numbered from one, in order.
+func moveEntries(order ...int) func(log string) string {
+	return func(log string) string {
+		lines := strings.SplitAfter(log, "\n")
+		var b strings.Builder
+		for _, n := range order {
+			b.WriteString(lines[n-1])
+		}
+		return b.String()
+	}
+}
+
+// writeEncryptedLog writes a database of four entries encrypted with
+// testKey, changes its log with damage and returns its path.
+func writeEncryptedLog(t *testing.T, damage func(log string) string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenEncrypted(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db,
+		"create table t (v text)",
+		"insert into t values ('a')",
+		"insert into t values ('b')",
+		"insert into t values ('c')",
+	)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(damage(string(log))), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestEncryptedEntriesStayInPlace(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(log string) string
+		// entry is the first damaged entry, 0 when the log is intact
+		entry int
+		// damaged are the entries Fsck reports
+		damaged []int
+	}{
+		{"intact", moveEntries(1, 2, 3, 4), 0, nil},
+		{"swapped", moveEntries(1, 3, 2, 4), 2, []int{2, 3}},
+		{"moved to the end", moveEntries(1, 3, 4, 2), 2, []int{2, 3, 4}},
+		{"repeated", moveEntries(1, 2, 2, 3, 4), 3, []int{3, 4, 5}},
+		{"left out", moveEntries(1, 2, 4), 3, []int{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeEncryptedLog(t, tt.damage)
+
+			problems, err := Fsck(path, testKey, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var damaged []int
+			for _, p := range problems {
+				if p.Msg == msgUndecryptable {
+					damaged = append(damaged, p.Entry)
+				}
+			}
+			if !reflect.DeepEqual(damaged, tt.damaged) {
+				t.Errorf("Fsck reports entries %v, want %v: %v", damaged, tt.damaged, problems)
+			}
+
+			db, err := OpenEncrypted(path, testKey)
+			if tt.entry == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+
+				want := [][]interface{}{{"a"}, {"b"}, {"c"}}
+				if got := queryRows(t, db, "select v from t"); !reflect.DeepEqual(got, want) {
+					t.Errorf("t holds %v, want %v", got, want)
+				}
+				return
+			}
+
+			if err == nil {
+				db.Close()
+				t.Fatal("opened a log with entries out of place")
+			}
+			var corrupt *CorruptionError
+			if !errors.As(err, &corrupt) || corrupt.Entry != tt.entry || corrupt.Msg != msgUndecryptable {
+				t.Errorf("got %v, want entry %d to be undecryptable", err, tt.entry)
+			}
+		})
+	}
+}
+
+func TestEncryptedEntryNumbers(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"reopened", ""},
+		{"vacuumed", "vacuum"},
+		{"rekeyed", "rekey '0101010101010101010101010101010101010101010101010101010101010101'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeEncrypted(t, testKey)
+
+			// Entries appended after the log was opened, vacuumed or
+			// rekeyed carry on from the ones it holds
+			db, err := OpenEncrypted(path, testKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.query != "" {
+				mustExec(t, db, tt.query)
+			}
+			mustExec(t, db, "insert into t values ('more')", "insert into t values ('again')")
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			problems, err := Fsck(path, testKey, false)
+			if err != nil || len(problems) != 0 {
+				t.Fatalf("Fsck reports %v, %v", problems, err)
+			}
+		})
+	}
+}
+
+func TestRekeyOnlyBySuperuser(t *testing.T) {
+	path := writeEncrypted(t, testKey)
+	db, err := OpenEncrypted(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = connAs(t, db, "mallory").Exec("rekey '02020202020202020202020202020202'")
+	if !errors.Is(err, backend.ErrPermissionDenied) {
+		t.Errorf("got %v, want %v", err, backend.ErrPermissionDenied)
+	}
+
+	// The log still opens with the key it had
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenEncrypted(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+}
+
+// recorder keeps the text of every event and span attribute, to check what
+// the database gives away.
+type recorder struct {
+	mu   sync.Mutex
+	text []string
+}
+
+func (r *recorder) record(values ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, v := range values {
+		r.text = append(r.text, fmt.Sprint(v))
+	}
+}
+
+func (r *recorder) Log(ctx context.Context, level logging.Level, msg string, args ...interface{}) {
+	r.record(append([]interface{}{msg}, args...)...)
+}
+
+func (r *recorder) Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	return ctx, r
+}
+
+func (r *recorder) SetAttribute(key string, value interface{}) { r.record(key, value) }
+func (r *recorder) RecordError(err error)                      { r.record(err) }
+func (r *recorder) End()                                       {}
+
+func TestRekeyKeyIsRedacted(t *testing.T) {
+	const key = "02020202020202020202020202020202"
+
+	path := writeEncrypted(t, testKey)
+	db, err := OpenEncrypted(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r := &recorder{}
+	db.SetLogger(r)
+	db.SetTracer(r)
+	var audit bytes.Buffer
+	db.SetAuditSink(&audit)
+
+	if err := db.Exec("select 1; rekey '" + key + "'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Conn().Exec("rekey '" + key + "x'"); err == nil {
+		t.Fatal("rekeyed with a malformed key")
+	}
+
+	// A session running REKEY shows it in __sessions without the key
+	c := db.Conn()
+	defer c.Close()
+	_, done, err := c.track(context.Background(), "rekey '"+key+"'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := db.Query("select query from __sessions")
+	done(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range results.Rows {
+		r.record(row...)
+	}
+
+	r.record(audit.String())
+	redacted := 0
+	for _, text := range r.text {
+		if strings.Contains(text, key) {
+			t.Errorf("the key was given away in %q", text)
+		}
+		if strings.Contains(text, "<redacted>") {
+			redacted++
+		}
+	}
+	// The query span, the audit log and __sessions at least
+	if redacted < 3 {
+		t.Errorf("REKEY shows up redacted %d times, want at least 3: %q", redacted, r.text)
+	}
+}
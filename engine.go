@@ -0,0 +1,87 @@
+package sgsql
+
+import (
+	"errors"
+	"io"
+
+	"github.com/nireo/sgsql/storage"
+)
+
+var (
+	ErrEngineEncrypted = errors.New("Only the memory storage engine can be used with an encrypted database, the others don't encrypt the rows they store")
+	ErrCheckpointed    = errors.New("Database keeps the rows of its tables in its storage engine, it must be opened with that engine")
+)
+
+// defaultEngine is the storage engine of databases opened without
+// WithEngine.
+const defaultEngine = "memory"
+
+// Option changes how a database is opened by Open and its variants.
+type Option func(*openOptions)
+
+type openOptions struct {
+	engine string
+}
+
+// WithEngine stores the rows of the tables in the storage engine registered
+// under name, see storage.Register. Engines keeping them in a file keep
+// them next to the statement log, in a file named after it and the engine
+// like app.db.bolt. The default engine, memory, keeps them in memory.
+func WithEngine(name string) Option {
+	return func(o *openOptions) {
+		o.engine = name
+	}
+}
+
+// openEngine opens the storage engine opts choose for the database at path.
+func openEngine(path string, readOnly bool, c *logCipher, opts []Option) (storage.Engine, error) {
+	o := openOptions{engine: defaultEngine}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if c != nil && o.engine != defaultEngine {
+		return nil, ErrEngineEncrypted
+	}
+
+	engineOpts := storage.Options{ReadOnly: readOnly}
+	if path != "" && path != MemoryPath {
+		engineOpts.Path = path + "." + o.engine
+	}
+
+	return storage.Open(o.engine, engineOpts)
+}
+
+// recoverEngine brings back the tables the engine of db kept at the
+// checkpoint id its statement log starts from, or discards what the engine
+// kept when id is empty, see storage.Checkpointer. It must be called before
+// the log creates any table.
+func (db *DB) recoverEngine(id string) error {
+	c, ok := db.engine.(storage.Checkpointer)
+	if !ok {
+		if id != "" {
+			return ErrCheckpointed
+		}
+		return nil
+	}
+
+	return c.Recover(id)
+}
+
+// SetBufferPoolSize sets the bytes of rows the storage engine of db caches
+// from its file, see storage.BufferPool. Engines reading nothing from a
+// file ignore it.
+func (db *DB) SetBufferPoolSize(bytes int64) {
+	if p, ok := db.engine.(storage.BufferPool); ok {
+		p.SetBufferPoolSize(bytes)
+	}
+}
+
+// closeEngine closes engine if it holds anything to close.
+func closeEngine(engine storage.Engine) error {
+	if c, ok := engine.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package sgsql
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql/storage"
+	_ "github.com/nireo/sgsql/storage/bolt"
+)
+
+func TestOpenEngine(t *testing.T) {
+	tests := []struct {
+		name   string
+		engine string
+		key    []byte
+		err    error
+	}{
+		{"memory", "memory", nil, nil},
+		{"bolt", "bolt", nil, nil},
+		{"unknown", "paper", nil, storage.ErrNoSuchEngine},
+		{"encrypted", "bolt", make([]byte, 16), ErrEngineEncrypted},
+		{"encrypted memory", "memory", make([]byte, 16), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			db, err := OpenEncrypted(path, tt.key, WithEngine(tt.engine))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			mustExec(t, db, "create table t (id int)", "insert into t values (1)")
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Engines keeping their rows in a file keep it next to the log
+			_, err = os.Stat(path + "." + tt.engine)
+			if stored := err == nil; stored != (tt.engine == "bolt") {
+				t.Errorf("%s.%s exists: %v", path, tt.engine, stored)
+			}
+
+			db, err = OpenEncrypted(path, tt.key, WithEngine(tt.engine))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if got, want := queryRows(t, db, "select id from t"), [][]interface{}{{int64(1)}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("t holds %v, want %v", got, want)
+			}
+		})
+	}
+
+	// A file is needed to keep the rows in
+	if _, err := Open(MemoryPath, WithEngine("bolt")); err == nil {
+		t.Error("an in-memory database opened with bolt")
+	}
+}
+
+func TestEngineCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, WithEngine("bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db,
+		"create table t (id int generated always as identity, v text)",
+		"create index t_v on t (v)",
+		"insert into t values (default, 'a')",
+		"insert into t values (default, 'b')")
+	if _, err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rows stay in the file, the log only creates the table around them
+	log, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(log), "INSERT") {
+		t.Errorf("the vacuumed log inserts rows:\n%s", log)
+	}
+	mustExec(t, db, "insert into t values (default, 'c')")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		open func() (*DB, error)
+		err  error
+	}{
+		{"read-only", func() (*DB, error) { return OpenReadOnly(path, WithEngine("bolt")) }, nil},
+		{"memory", func() (*DB, error) { return Open(path) }, ErrCheckpointed},
+		{"bolt", func() (*DB, error) { return Open(path, WithEngine("bolt")) }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := tt.open()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			defer db.Close()
+
+			want := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}}
+			if got := queryRows(t, db, "select id, v from t"); !reflect.DeepEqual(got, want) {
+				t.Errorf("t holds %v, want %v", got, want)
+			}
+			if got, want := queryRows(t, db, "select id from t where v = 'b'"), [][]interface{}{{int64(2)}}; !reflect.DeepEqual(got, want) {
+				t.Errorf("the index finds %v, want %v", got, want)
+			}
+		})
+	}
+
+	// The identity goes on after the rows of the file
+	db, err = Open(path, WithEngine("bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mustExec(t, db, "insert into t values (default, 'd')")
+	if got, want := queryRows(t, db, "select id from t where v = 'd'"), [][]interface{}{{int64(4)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("d was given %v, want %v", got, want)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	tests := []struct {
+		name   string
+		engine string
+		size   int64
+		rows   [][]interface{}
+	}{
+		{"memory", "memory", storage.DefaultBufferPoolSize, nil},
+		// The first scan reads the page from the file, the second from the pool
+		{"bolt", "bolt", storage.DefaultBufferPoolSize, [][]interface{}{{int64(1), int64(1), int64(1), 0.5}}},
+		{"bolt without a pool", "bolt", 0, [][]interface{}{{int64(0), int64(0), int64(2), 0.0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(filepath.Join(t.TempDir(), "test.db"), WithEngine(tt.engine))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			db.SetBufferPoolSize(tt.size)
+
+			mustExec(t, db, "create table t (id int, v text)", "insert into t values (1, 'a')", "insert into t values (2, 'b')")
+			for i := 0; i < 2; i++ {
+				if got, want := queryRows(t, db, "select v from t"), [][]interface{}{{"a"}, {"b"}}; !reflect.DeepEqual(got, want) {
+					t.Fatalf("t holds %v, want %v", got, want)
+				}
+			}
+
+			got := queryRows(t, db, "select pages, hits, misses, hit_ratio from __buffer_pool")
+			if !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("got %v, want %v", got, tt.rows)
+			}
+		})
+	}
+}
+
+func TestEngineCompression(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, WithEngine("bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db,
+		"create table t (id int, v text) with (compression = 'lz')",
+		"insert into t values (1, 'a')",
+		"insert into t values (2, 'b')")
+	if _, err := db.Vacuum(); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db, "insert into t values (3, 'c')")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The vacuumed log creates the table compressed around the pages of
+	// the file
+	log, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), "WITH (compression = 'lz')") {
+		t.Errorf("the vacuumed log doesn't compress t:\n%s", log)
+	}
+
+	db, err = Open(path, WithEngine("bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	want := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}}
+	if got := queryRows(t, db, "select id, v from t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("t holds %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package sgsql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+func TestFlashback(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  [][]interface{}
+	}{
+		{"to a timestamp", "flashback table t to timestamp $1", [][]interface{}{{int64(1)}}},
+		{"to a number of rows", "flashback table t to rows 2", [][]interface{}{{int64(1)}, {int64(2)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			mustExec(t, db, "create table t (id int)", "insert into t values (1)")
+			time.Sleep(10 * time.Millisecond)
+			at := time.Now().UTC()
+			time.Sleep(10 * time.Millisecond)
+			mustExec(t, db, "insert into t values (2)", "insert into t values (3)")
+
+			var args []interface{}
+			if tt.query == "flashback table t to timestamp $1" {
+				args = append(args, at)
+			}
+			if err := db.Exec(tt.query, args...); err != nil {
+				t.Fatal(err)
+			}
+			if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("t holds %v, want %v", got, tt.want)
+			}
+
+			db = reopen(t, db, path)
+			if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("t holds %v after reopening, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnlySuperuserFlashesBack(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db,
+		"create table docs (owner text, body text)",
+		"insert into docs values ('alice', 'hers')",
+		"insert into docs values ('bob', 'his')",
+		"create policy own on docs using (owner = current_user() or current_user() = 'sgsql')",
+	)
+
+	// bob can't see alice's row, and mustn't remove his own along with it
+	bob := connAs(t, db, "bob")
+	for _, query := range []string{"flashback table docs to rows 0", "flashback table docs to timestamp now() - interval '1 hour'"} {
+		if err := bob.Exec(query); !errors.Is(err, backend.ErrPermissionDenied) {
+			t.Errorf("%s as bob: got %v, want %v", query, err, backend.ErrPermissionDenied)
+		}
+	}
+
+	want := [][]interface{}{{"hers"}, {"his"}}
+	if got := queryRows(t, db, "select body from docs"); !reflect.DeepEqual(got, want) {
+		t.Errorf("docs holds %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package foreign
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	Register("csv", csvFormat{})
+}
+
+// csvFormat reads comma separated files. A first line naming every column
+// is a header, the columns are then read from the fields under their names
+// and others are skipped. Without one, the fields are the columns in order.
+// Empty fields are NULL.
+type csvFormat struct{}
+
+func (csvFormat) Open(path string, columns []Column) (Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &csvReader{file: f, csv: csv.NewReader(f), columns: columns}
+	r.csv.FieldsPerRecord = -1
+
+	first, err := r.csv.Read()
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+
+	if fields, ok := header(first, columns); ok {
+		r.fields = fields
+	} else {
+		r.first = first
+	}
+
+	return r, nil
+}
+
+// header returns where each of columns is in record if it is a header.
+func header(record []string, columns []Column) ([]int, bool) {
+	if len(record) == 0 {
+		return nil, false
+	}
+
+	fields := make([]int, len(columns))
+	for i, col := range columns {
+		fields[i] = -1
+		for j, name := range record {
+			if strings.EqualFold(strings.TrimSpace(name), col.Name) {
+				fields[i] = j
+				break
+			}
+		}
+
+		if fields[i] < 0 {
+			return nil, false
+		}
+	}
+
+	return fields, true
+}
+
+type csvReader struct {
+	file    *os.File
+	csv     *csv.Reader
+	columns []Column
+	// fields is where the columns are in a record when the file has a
+	// header, nil when they are the fields in order
+	fields []int
+	// first is the first record of a file without a header, which was
+	// read looking for one
+	first []string
+}
+
+func (r *csvReader) Next() ([]interface{}, error) {
+	record := r.first
+	r.first = nil
+	if record == nil {
+		var err error
+		if record, err = r.csv.Read(); err != nil {
+			return nil, err
+		}
+	}
+
+	row := make([]interface{}, len(r.columns))
+	if r.fields == nil && len(record) != len(r.columns) {
+		line, _ := r.csv.FieldPos(0)
+		return nil, fmt.Errorf("Line %d has %d fields, expected %d", line, len(record), len(r.columns))
+	}
+
+	for i := range r.columns {
+		field := i
+		if r.fields != nil {
+			field = r.fields[i]
+		}
+
+		if field < len(record) && record[field] != "" {
+			row[i] = record[field]
+		}
+	}
+
+	return row, nil
+}
+
+func (r *csvReader) Close() error {
+	return r.file.Close()
+}
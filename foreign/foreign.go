@@ -0,0 +1,88 @@
+// Package foreign reads the files external tables are backed by, so files
+// can be queried where they are rather than imported first. Formats register
+// themselves under a name, which is what FORMAT in CREATE EXTERNAL TABLE
+// picks, or the extension of the file when it names none:
+//
+//	func init() {
+//		foreign.Register("parquet", parquetFormat{})
+//	}
+//
+// Formats return the values as they find them, converting them to the types
+// of the columns happens in the backend. Tables of remote Postgres and MySQL
+// databases are read through the format SQL returns for them.
+package foreign
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrNoSuchFormat = errors.New("External table format does not exist")
+	ErrFormatExists = errors.New("External table format is already registered")
+)
+
+// Column is a column of an external table.
+type Column struct {
+	Name string
+	Type types.Type
+}
+
+// Format reads the files of one format.
+type Format interface {
+	// Open starts reading the file at path for a table with columns.
+	Open(path string, columns []Column) (Reader, error)
+}
+
+// Reader reads the rows of a file.
+type Reader interface {
+	// Next returns the next row, which holds a value for each column in
+	// order, or io.EOF after the last row. Values are nil for NULL, text
+	// that is cast to the type of the column, or values of that type.
+	Next() ([]interface{}, error)
+	Close() error
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]Format{}
+)
+
+// Register makes format available under name. It panics if name is
+// already taken, like registering the same format twice.
+func Register(name string, format Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	if _, ok := formats[name]; ok {
+		panic(ErrFormatExists.Error() + ": " + name)
+	}
+
+	formats[name] = format
+}
+
+// Lookup returns the format registered under name.
+func Lookup(name string) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	format, ok := formats[name]
+	return format, ok
+}
+
+// Formats returns the names of the registered formats in order.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,129 @@
+package foreign
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// RemoteFormat is a format reading the tables of another database rather
+// than files. Its location is the name of the remote table, which is passed
+// on as written instead of being resolved to a path.
+type RemoteFormat interface {
+	Format
+	OpenRemote(ctx context.Context, location string, columns []Column) (Reader, error)
+}
+
+// Dialect is the flavor of SQL a remote database speaks.
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	MySQL
+)
+
+// quote quotes a table or column name, the parts of schema.table
+// separately.
+func (d Dialect) quote(name string) string {
+	q := `"`
+	if d == MySQL {
+		q = "`"
+	}
+
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = q + strings.ReplaceAll(part, q, q+q) + q
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// SQL returns a format reading the tables of db, a remote Postgres or MySQL
+// database opened with the driver of the embedding program. Registered under
+// a name, it lets external tables proxy reads to the remote tables:
+//
+//	pg, err := sql.Open("postgres", "postgres://ref.example.com/refdata")
+//	foreign.Register("refdata", foreign.SQL(pg, foreign.Postgres))
+//
+//	CREATE EXTERNAL TABLE rates (code TEXT, rate FLOAT) LOCATION 'public.rates' FORMAT refdata
+//
+// The columns are selected from the remote table by name, and every read of
+// the external table queries it again. The address and credentials of the
+// remote database stay with db, so they are never written to the log. As
+// every read uses those credentials, only the superuser of the database may
+// create external tables of the format.
+func SQL(db *sql.DB, dialect Dialect) RemoteFormat {
+	return sqlFormat{db: db, dialect: dialect}
+}
+
+type sqlFormat struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func (f sqlFormat) Open(location string, columns []Column) (Reader, error) {
+	return f.OpenRemote(context.Background(), location, columns)
+}
+
+func (f sqlFormat) OpenRemote(ctx context.Context, location string, columns []Column) (Reader, error) {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = f.dialect.quote(col.Name)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(names, ", "), f.dialect.quote(location))
+	rows, err := f.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlReader{rows: rows, columns: len(columns)}, nil
+}
+
+type sqlReader struct {
+	rows    *sql.Rows
+	columns int
+}
+
+func (r *sqlReader) Next() ([]interface{}, error) {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	values := make([]interface{}, r.columns)
+	dest := make([]interface{}, r.columns)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	if err := r.rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	// Drivers hand out text as bytes and may use the narrower Go types,
+	// which are cast to the columns like the fields of a file
+	for i, v := range values {
+		switch v := v.(type) {
+		case []byte:
+			values[i] = string(v)
+		case int32:
+			values[i] = int64(v)
+		case float32:
+			values[i] = float64(v)
+		case time.Time:
+			values[i] = v.UTC()
+		}
+	}
+
+	return values, nil
+}
+
+func (r *sqlReader) Close() error {
+	return r.rows.Close()
+}
@@ -0,0 +1,117 @@
+package sgsql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Problem is damage Fsck found in a database. Entry and Offset locate the
+// damaged entry of the statement log like in a CorruptionError, they are
+// zero for damage to what the log holds.
+type Problem struct {
+	Entry  int
+	Offset int64
+	Msg    string
+	// Repaired is set when Fsck repaired the damage
+	Repaired bool
+}
+
+func (p Problem) String() string {
+	s := p.Msg
+	if p.Entry != 0 {
+		s = fmt.Sprintf("entry %d, offset %d: %s", p.Entry, p.Offset, p.Msg)
+	}
+	if p.Repaired {
+		s += " (repaired)"
+	}
+
+	return s
+}
+
+// Fsck checks the database at path, whose statement log is encrypted with
+// key unless it is empty, without opening it. opts choose the storage
+// engine holding its rows like for Open. Nothing else may have it open
+// meanwhile. It checks that
+//
+//   - every entry of the statement log is intact,
+//   - the log replays without errors and
+//   - every index holds exactly the rows of its table once it has,
+//
+// and returns the damage it found. The log is only replayed when it is
+// intact, since replaying stops at the first damaged entry.
+//
+// With repair set, an entry cut off at the end of the log is removed. A
+// crash while the entry was being written leaves it behind, and its commit
+// never returned. Other damage is only reported: the entries after a
+// damaged one would run against the wrong state without it, restore a
+// backup instead.
+func Fsck(path string, key []byte, repair bool, opts ...Option) ([]Problem, error) {
+	c, err := newLogCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	flag := os.O_RDONLY
+	if repair {
+		flag = os.O_RDWR
+	}
+
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	problems := []Problem{}
+	lr := newLogReader(f, c)
+	for {
+		_, err := lr.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			continue
+		}
+
+		var corrupt *CorruptionError
+		if !errors.As(err, &corrupt) {
+			return nil, err
+		}
+		if corrupt.Entry == 1 && corrupt.Msg == msgUndecryptable {
+			return nil, ErrDecrypt
+		}
+
+		problems = append(problems, Problem{Entry: corrupt.Entry, Offset: corrupt.Offset, Msg: corrupt.Msg})
+	}
+
+	// Only the last entry can be incomplete, see logReader.next
+	if n := len(problems); repair && n > 0 && problems[n-1].Msg == msgIncomplete {
+		if err := f.Truncate(problems[n-1].Offset); err != nil {
+			return nil, err
+		}
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+		problems[n-1].Repaired = true
+	}
+
+	for _, p := range problems {
+		if !p.Repaired {
+			return problems, nil
+		}
+	}
+
+	db, err := open(path, true, c, opts)
+	if err != nil {
+		return append(problems, Problem{Msg: "Statement log doesn't replay: " + err.Error()}), nil
+	}
+	defer db.Close()
+
+	for _, msg := range db.backend.VerifyIndexes() {
+		problems = append(problems, Problem{Msg: msg})
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,121 @@
+package sgsql
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	unreplayable := func(log string) string {
+		var b bytes.Buffer
+		writeEntry(&b, logEntry{Query: "insert into missing values (1)"}, 4, nil)
+		return log + b.String()
+	}
+
+	tests := []struct {
+		name     string
+		damage   func(log string) string
+		repair   bool
+		problems func(log string) []Problem
+		// opens is whether the database opens after Fsck
+		opens bool
+	}{
+		{
+			"intact",
+			func(log string) string { return log },
+			false,
+			func(string) []Problem { return []Problem{} },
+			true,
+		},
+		{
+			"cut off",
+			func(log string) string { return log[:len(log)-5] },
+			false,
+			func(log string) []Problem {
+				return []Problem{{Entry: 3, Offset: entryOffset(log, 3), Msg: msgIncomplete}}
+			},
+			false,
+		},
+		{
+			"cut off repaired",
+			func(log string) string { return log[:len(log)-5] },
+			true,
+			func(log string) []Problem {
+				return []Problem{{Entry: 3, Offset: entryOffset(log, 3), Msg: msgIncomplete, Repaired: true}}
+			},
+			true,
+		},
+		{
+			"damaged in the middle",
+			func(log string) string { return strings.Replace(log, "values (1)", "values (7)", 1) },
+			true,
+			func(log string) []Problem {
+				return []Problem{{Entry: 2, Offset: entryOffset(log, 2), Msg: "Checksum mismatch"}}
+			},
+			false,
+		},
+		{
+			"doesn't replay",
+			unreplayable,
+			true,
+			func(string) []Problem {
+				return []Problem{{Msg: "Statement log doesn't replay: Table does not exist"}}
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log string
+			path := writeLog(t, func(intact string) string {
+				log = tt.damage(intact)
+				return log
+			})
+
+			problems, err := Fsck(path, nil, tt.repair)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.problems(log); !reflect.DeepEqual(problems, want) {
+				t.Errorf("Fsck = %v, want %v", problems, want)
+			}
+
+			// Only repairs change the log
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repaired := len(problems) > 0 && problems[len(problems)-1].Repaired
+			if changed := string(after) != log; changed != repaired {
+				t.Errorf("log changed = %v, want %v", changed, repaired)
+			}
+
+			db, err := Open(path)
+			if (err == nil) != tt.opens {
+				t.Fatalf("opening after Fsck: got %v, want it to open = %v", err, tt.opens)
+			}
+			if err == nil {
+				db.Close()
+			}
+		})
+	}
+}
+
+func TestFsckEncrypted(t *testing.T) {
+	path := writeEncrypted(t, testKey)
+
+	if problems, err := Fsck(path, testKey, false); err != nil || len(problems) != 0 {
+		t.Errorf("Fsck with the key = %v, %v, want no problems", problems, err)
+	}
+
+	for _, key := range [][]byte{nil, otherKey} {
+		if _, err := Fsck(path, key, false); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("Fsck with key %x: got %v, want %v", key, err, ErrDecrypt)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package functions
+
+import (
+	"fmt"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	Register(&Function{
+		Name:    "count",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Star:    true,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			return types.Int, true, nil
+		},
+		Aggregate: func() Aggregator { return &count{} },
+	})
+
+	Register(&Function{
+		Name:    "sum",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			if args[0].Known && args[0].Type == types.Interval {
+				return types.Interval, true, nil
+			}
+			return numeric(args)
+		},
+		Aggregate: func() Aggregator { return &sum{} },
+	})
+
+	Register(&Function{
+		Name:      "avg",
+		MinArgs:   1,
+		MaxArgs:   1,
+		Type:      floating,
+		Aggregate: func() Aggregator { return &avg{} },
+	})
+
+	Register(&Function{
+		Name:      "min",
+		MinArgs:   1,
+		MaxArgs:   1,
+		Type:      ordered,
+		Aggregate: func() Aggregator { return &extreme{op: "<"} },
+	})
+
+	Register(&Function{
+		Name:      "max",
+		MinArgs:   1,
+		MaxArgs:   1,
+		Type:      ordered,
+		Aggregate: func() Aggregator { return &extreme{op: ">"} },
+	})
+}
+
+// ordered accepts a value of any type that can be ordered.
+func ordered(args []Arg) (types.Type, bool, error) {
+	t := args[0].Type
+	if args[0].Known {
+		if _, ok := types.Binary("<", t, t); !ok {
+			return 0, false, fmt.Errorf("%w: %s values have no order", ErrInvalidArguments, t)
+		}
+	}
+
+	return t, args[0].Known, nil
+}
+
+// count counts rows, or the non-NULL values when it has an argument.
+type count struct {
+	n int64
+}
+
+func (c *count) Step(args []interface{}) error {
+	if len(args) == 0 || args[0] != nil {
+		c.n++
+	}
+	return nil
+}
+
+func (c *count) Result() (interface{}, error) {
+	return c.n, nil
+}
+
+// sum adds up the non-NULL values, it is NULL when there are none.
+type sum struct {
+	total interface{}
+}
+
+func (s *sum) Step(args []interface{}) error {
+	switch {
+	case args[0] == nil:
+		return nil
+	case s.total == nil:
+		s.total = args[0]
+		return nil
+	}
+
+	total, err := types.Apply("+", s.total, args[0])
+	if err != nil {
+		return err
+	}
+
+	s.total = total
+	return nil
+}
+
+func (s *sum) Result() (interface{}, error) {
+	return s.total, nil
+}
+
+// avg is the mean of the non-NULL values as a float.
+type avg struct {
+	total float64
+	n     int64
+}
+
+func (a *avg) Step(args []interface{}) error {
+	switch v := args[0].(type) {
+	case nil:
+		return nil
+	case int64:
+		a.total += float64(v)
+	case float64:
+		a.total += v
+	default:
+		return fmt.Errorf("%w: avg of %v", ErrInvalidArguments, v)
+	}
+
+	a.n++
+	return nil
+}
+
+func (a *avg) Result() (interface{}, error) {
+	if a.n == 0 {
+		return nil, nil
+	}
+
+	return a.total / float64(a.n), nil
+}
+
+// extreme keeps the value for which op holds against every other one.
+type extreme struct {
+	op    string
+	value interface{}
+}
+
+func (e *extreme) Step(args []interface{}) error {
+	switch {
+	case args[0] == nil:
+		return nil
+	case e.value == nil:
+		e.value = args[0]
+		return nil
+	}
+
+	better, err := types.Apply(e.op, args[0], e.value)
+	if err != nil {
+		return err
+	}
+
+	if better == true {
+		e.value = args[0]
+	}
+	return nil
+}
+
+func (e *extreme) Result() (interface{}, error) {
+	return e.value, nil
+}
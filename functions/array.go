@@ -0,0 +1,83 @@
+package functions
+
+import (
+	"fmt"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	Register(&Function{
+		Name:    "array_length",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			if err := expectArray(args[0]); err != nil {
+				return 0, false, err
+			}
+			if len(args) == 2 && args[1].Known && args[1].Type != types.Int {
+				return 0, false, fmt.Errorf("%w: dimension must be int", ErrInvalidArguments)
+			}
+
+			return types.Int, true, nil
+		},
+		Eval: arrayLength,
+	})
+
+	Register(&Function{
+		Name:    "cardinality",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			return types.Int, true, expectArray(args[0])
+		},
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			a, ok := args[0].(types.Array)
+			if !ok {
+				return nil, nil
+			}
+			return int64(len(a.Values)), nil
+		},
+	})
+
+	Register(&Function{
+		Name:    "unnest",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Set:     true,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			if err := expectArray(args[0]); err != nil {
+				return 0, false, err
+			}
+
+			return args[0].Type.Elem(), args[0].Known, nil
+		},
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			a, _ := args[0].(types.Array)
+			return a, nil
+		},
+	})
+}
+
+func expectArray(arg Arg) error {
+	if arg.Known && !arg.Type.IsArray() {
+		return fmt.Errorf("%w: expected an array, not %s", ErrInvalidArguments, arg.Type)
+	}
+
+	return nil
+}
+
+// arrayLength returns the length of an array along a dimension, which is
+// NULL for empty arrays and dimensions they don't have.
+func arrayLength(s *Session, args []interface{}) (interface{}, error) {
+	a, ok := args[0].(types.Array)
+	if !ok || len(a.Values) == 0 {
+		return nil, nil
+	}
+
+	if len(args) == 2 && args[1] != int64(1) {
+		return nil, nil
+	}
+
+	return int64(len(a.Values)), nil
+}
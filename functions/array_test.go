@@ -0,0 +1,50 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func TestArrayFunctions(t *testing.T) {
+	a := types.Array{Elem: types.Int, Values: []interface{}{int64(1), nil, int64(3)}}
+	empty := types.Array{Elem: types.Int, Values: []interface{}{}}
+
+	tests := []struct {
+		name string
+		args []interface{}
+		want interface{}
+	}{
+		{"array_length", []interface{}{a}, int64(3)},
+		{"array_length", []interface{}{a, int64(1)}, int64(3)},
+		// Arrays have one dimension, and empty ones none
+		{"array_length", []interface{}{a, int64(2)}, nil},
+		{"array_length", []interface{}{empty, int64(1)}, nil},
+		{"array_length", []interface{}{nil, int64(1)}, nil},
+		{"cardinality", []interface{}{a}, int64(3)},
+		{"cardinality", []interface{}{empty}, int64(0)},
+		{"cardinality", []interface{}{nil}, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := call(t, nil, tt.name, tt.args...)
+		if err != nil || got != tt.want {
+			t.Errorf("%s%v = %v, %v, want %v", tt.name, tt.args, got, err, tt.want)
+		}
+	}
+
+	unnest, _ := Lookup("unnest")
+	if typ, ok, err := unnest.Type([]Arg{{types.ArrayOf(types.Text), true}}); err != nil || !ok || typ != types.Text || !unnest.Set {
+		t.Errorf("unnest(text[]) is %s, %v, %v", typ, ok, err)
+	}
+	for _, name := range []string{"unnest", "array_length", "cardinality"} {
+		f, _ := Lookup(name)
+		if _, _, err := f.Type([]Arg{{types.Int, true}}); err == nil {
+			t.Errorf("%s accepted an int", name)
+		}
+	}
+	arrayLength, _ := Lookup("array_length")
+	if _, _, err := arrayLength.Type([]Arg{{types.ArrayOf(types.Int), true}, {types.Text, true}}); err == nil {
+		t.Error("array_length accepted a text dimension")
+	}
+}
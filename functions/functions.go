@@ -0,0 +1,116 @@
+// Package functions is the registry of scalar and aggregate functions
+// callable from SQL. The analyzer uses it to check calls and infer their
+// types, the evaluator to run them.
+package functions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nireo/sgsql/types"
+)
+
+var ErrInvalidArguments = errors.New("Invalid arguments")
+
+// Arg is the type of an argument as far as it is known before evaluation.
+// Known is false for NULL and parameters.
+type Arg struct {
+	Type  types.Type
+	Known bool
+}
+
+// Aggregator accumulates the rows of a group for an aggregate function.
+type Aggregator interface {
+	// Step adds the argument values of a row.
+	Step(args []interface{}) error
+	// Result returns the aggregate of the rows added so far.
+	Result() (interface{}, error)
+}
+
+// Function is a scalar function, or an aggregate function when Aggregate is
+// set.
+type Function struct {
+	Name    string
+	MinArgs int
+	// MaxArgs is -1 for functions taking any number of arguments
+	MaxArgs int
+	// Type returns the type of the result for arguments of the given types,
+	// or an error if the function doesn't accept them. The result type is
+	// unknown when ok is false.
+	Type func(args []Arg) (t types.Type, ok bool, err error)
+	// Eval computes the result from the arguments' values in session s.
+	Eval func(s *Session, args []interface{}) (interface{}, error)
+	// Set is true for set-returning functions, which can only be called as
+	// a whole SELECT item or in place of a table in FROM. Eval returns a
+	// types.Array whose elements each become a row, and Type returns the
+	// type of the elements.
+	Set bool
+	// Generate hands the elements of the set Eval returns to yield one at
+	// a time, stopping with the first error yield returns. Set-returning
+	// functions whose sets can be huge have it, so the evaluator can check
+	// the elements against the memory budget and stop on cancellation
+	// before the whole set is built.
+	Generate func(s *Session, args []interface{}, yield func(interface{}) error) error
+	// Modifies is true for functions that change the database, which
+	// read-only sessions can't call.
+	Modifies bool
+	// Volatile is true for functions whose result can change between calls
+	// with the same arguments, or depends on the session, so the results
+	// of queries calling them aren't cached.
+	Volatile bool
+	// Aggregate starts aggregating a group of rows. Aggregate functions
+	// have no Eval.
+	Aggregate func() Aggregator
+	// Star is true for functions that can be called with * in place of
+	// their arguments, which then gets no arguments for each row.
+	Star bool
+}
+
+var registry = map[string]*Function{}
+
+// Register makes f callable from SQL by its name.
+func Register(f *Function) {
+	registry[f.Name] = f
+}
+
+// Lookup returns the function called name.
+func Lookup(name string) (*Function, bool) {
+	f, ok := registry[name]
+	return f, ok
+}
+
+// Names returns the names of all registered functions.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// common returns the type all known args convert to.
+func common(args []Arg) (types.Type, bool, error) {
+	var t types.Type
+	found := false
+	for _, arg := range args {
+		if !arg.Known {
+			continue
+		}
+
+		if !found {
+			t, found = arg.Type, true
+			continue
+		}
+
+		c, ok := types.Common(t, arg.Type)
+		if !ok {
+			return 0, false, fmt.Errorf("%w: %s and %s can't be mixed", ErrInvalidArguments, t, arg.Type)
+		}
+		t = c
+	}
+
+	return t, found, nil
+}
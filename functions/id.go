@@ -0,0 +1,82 @@
+package functions
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	// Both draw from crypto/rand rather than the session's generator, which
+	// SetSeed can make repeat the same identifiers in every session
+	Register(&Function{
+		Name:     "uuid",
+		Volatile: true,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			return types.UUID, true, nil
+		},
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			return s.newID(randomUUID)
+		},
+	})
+
+	Register(&Function{
+		Name:     "ulid",
+		Volatile: true,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			return types.Text, true, nil
+		},
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			id, err := s.newID(s.nextULID)
+			if err != nil {
+				return nil, err
+			}
+
+			return id.ULID(), nil
+		},
+	})
+}
+
+// randomUUID returns a random version 4 UUID.
+func randomUUID() (types.UUIDValue, error) {
+	var u types.UUIDValue
+	if _, err := rand.Read(u[:]); err != nil {
+		return u, err
+	}
+
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return u, nil
+}
+
+// nextULID returns a ULID, the milliseconds since the Unix epoch followed
+// by 80 random bits. Within the same millisecond the session counts up from
+// the last one it made instead, so its ULIDs sort in the order they were
+// made.
+func (s *Session) nextULID() (types.UUIDValue, error) {
+	var u types.UUIDValue
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	var now [8]byte
+	binary.BigEndian.PutUint64(now[:], ms)
+	copy(u[:6], now[2:])
+
+	if s != nil && string(s.lastULID[:6]) == string(u[:6]) {
+		u = s.lastULID
+		for i := 15; i >= 6; i-- {
+			u[i]++
+			if u[i] != 0 {
+				break
+			}
+		}
+	} else if _, err := rand.Read(u[6:]); err != nil {
+		return u, err
+	}
+
+	if s != nil {
+		s.lastULID = u
+	}
+	return u, nil
+}
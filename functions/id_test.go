@@ -0,0 +1,119 @@
+package functions
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func TestUUID(t *testing.T) {
+	s := NewSession(nil)
+	seen := map[types.UUIDValue]bool{}
+	for i := 0; i < 100; i++ {
+		v, err := call(t, s, "uuid")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u := v.(types.UUIDValue)
+		if u[6]>>4 != 4 || u[8]>>6 != 2 {
+			t.Fatalf("%v is not a version 4 UUID", u)
+		}
+		if seen[u] {
+			t.Fatalf("made %v twice", u)
+		}
+		seen[u] = true
+	}
+
+	// A seeded session still makes different identifiers
+	a, b := NewSession(nil), NewSession(nil)
+	a.SetSeed(1)
+	b.SetSeed(1)
+	ua, _ := call(t, a, "uuid")
+	ub, _ := call(t, b, "uuid")
+	if ua == ub {
+		t.Errorf("sessions seeded the same made the same UUID %v", ua)
+	}
+}
+
+func TestULID(t *testing.T) {
+	s := NewSession(nil)
+	start := time.Now()
+
+	ids := []string{}
+	for i := 0; i < 1000; i++ {
+		v, err := call(t, s, "ulid")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, v.(string))
+	}
+
+	// Identifiers made in the same millisecond still sort in order
+	if !sort.StringsAreSorted(ids) {
+		t.Error("ULIDs don't sort in the order they were made")
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			t.Fatalf("made %s twice", ids[i])
+		}
+	}
+
+	u, err := types.ParseUUID(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := int64(u[0])<<40 | int64(u[1])<<32 | int64(u[2])<<24 | int64(u[3])<<16 | int64(u[4])<<8 | int64(u[5])
+	if made := time.UnixMilli(ms); made.Before(start.Truncate(time.Millisecond)) || made.After(time.Now()) {
+		t.Errorf("%s was made at %v, want between %v and now", ids[0], made, start)
+	}
+}
+
+func TestReplayIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   string
+	}{
+		{"uuid", "uuid"},
+		{"ulid", "ulid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSession(nil)
+			made := []interface{}{}
+			for i := 0; i < 3; i++ {
+				v, err := call(t, s, tt.fn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				made = append(made, v)
+			}
+			ids := s.TakeIDs()
+			if len(ids) != 3 || len(s.TakeIDs()) != 0 {
+				t.Fatalf("took %v, want the 3 identifiers made once", ids)
+			}
+
+			replay := NewSession(nil)
+			replay.ReplayIDs(ids)
+			replayed := []interface{}{}
+			for i := 0; i < 3; i++ {
+				v, err := call(t, replay, tt.fn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				replayed = append(replayed, v)
+			}
+			if !reflect.DeepEqual(replayed, made) {
+				t.Errorf("replayed %v, want %v", replayed, made)
+			}
+			if _, err := call(t, replay, tt.fn); !errors.Is(err, ErrReplayExhausted) {
+				t.Errorf("after the replayed identifiers: got %v, want %v", err, ErrReplayExhausted)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package functions
+
+import "github.com/nireo/sgsql/types"
+
+// Version is what VERSION() returns. It is shaped like a MySQL version,
+// since that is what the clients asking for it on connect parse.
+const Version = "8.0.0-sgsql"
+
+// DefaultUser is the user of sessions that haven't been given one.
+const DefaultUser = "sgsql"
+
+func init() {
+	text := func(args []Arg) (types.Type, bool, error) {
+		return types.Text, true, nil
+	}
+
+	Register(&Function{
+		Name: "version",
+		Type: text,
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			return Version, nil
+		},
+	})
+
+	Register(&Function{
+		Name:     "current_user",
+		Type:     text,
+		Volatile: true,
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			return s.User(), nil
+		},
+	})
+
+	// NULL when the session isn't on a named database, like MySQL before
+	// one is selected
+	Register(&Function{
+		Name:     "database",
+		Type:     text,
+		Volatile: true,
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			if s == nil || s.database == "" {
+				return nil, nil
+			}
+
+			return s.database, nil
+		},
+	})
+}
@@ -0,0 +1,247 @@
+package functions
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	Register(&Function{Name: "abs", MinArgs: 1, MaxArgs: 1, Type: numeric, Eval: abs})
+	Register(&Function{Name: "floor", MinArgs: 1, MaxArgs: 1, Type: numeric, Eval: roundWith(math.Floor)})
+	Register(&Function{Name: "ceil", MinArgs: 1, MaxArgs: 1, Type: numeric, Eval: roundWith(math.Ceil)})
+	Register(&Function{Name: "ceiling", MinArgs: 1, MaxArgs: 1, Type: numeric, Eval: roundWith(math.Ceil)})
+	Register(&Function{Name: "mod", MinArgs: 2, MaxArgs: 2, Type: numeric, Eval: mod})
+
+	Register(&Function{
+		Name:    "round",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			if len(args) == 2 {
+				if args[1].Known && args[1].Type != types.Int {
+					return 0, false, fmt.Errorf("%w: digits must be int", ErrInvalidArguments)
+				}
+				return floating(args[:1])
+			}
+
+			return numeric(args)
+		},
+		Eval: round,
+	})
+
+	Register(&Function{Name: "power", MinArgs: 2, MaxArgs: 2, Type: floating, Eval: power})
+	Register(&Function{Name: "pow", MinArgs: 2, MaxArgs: 2, Type: floating, Eval: power})
+
+	Register(&Function{
+		Name:     "random",
+		Volatile: true,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			return types.Float, true, nil
+		},
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			return s.random()
+		},
+	})
+
+	Register(&Function{
+		Name:     "setseed",
+		MinArgs:  1,
+		MaxArgs:  1,
+		Volatile: true,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			_, _, err := numeric(args)
+			return 0, false, err
+		},
+		Eval: setseed,
+	})
+}
+
+// numeric accepts numbers and returns the type they have in common.
+func numeric(args []Arg) (types.Type, bool, error) {
+	t, ok, err := common(args)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if ok && t != types.Int && t != types.Float {
+		return 0, false, fmt.Errorf("%w: expected numbers, not %s", ErrInvalidArguments, t)
+	}
+
+	return t, ok, nil
+}
+
+// floating accepts numbers and always returns a float.
+func floating(args []Arg) (types.Type, bool, error) {
+	if _, _, err := numeric(args); err != nil {
+		return 0, false, err
+	}
+
+	return types.Float, true, nil
+}
+
+// float converts a number for functions computing on floats.
+func float(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("%w: %v is not a number", ErrInvalidArguments, v)
+}
+
+func anyNull(args []interface{}) bool {
+	for _, arg := range args {
+		if arg == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func abs(s *Session, args []interface{}) (interface{}, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		if v == math.MinInt64 {
+			return nil, fmt.Errorf("%w: abs(%d)", types.ErrOutOfRange, v)
+		}
+		if v < 0 {
+			return -v, nil
+		}
+		return v, nil
+	}
+
+	f, err := float(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return math.Abs(f), nil
+}
+
+// roundWith returns a function rounding floats with fn and leaving ints as
+// they are.
+func roundWith(fn func(float64) float64) func(*Session, []interface{}) (interface{}, error) {
+	return func(s *Session, args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case nil, int64:
+			return v, nil
+		}
+
+		f, err := float(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return fn(f), nil
+	}
+}
+
+// round rounds half away from zero, to the given number of decimal digits
+// when there is a second argument.
+func round(s *Session, args []interface{}) (interface{}, error) {
+	if len(args) == 1 {
+		return roundWith(math.Round)(s, args)
+	}
+
+	if anyNull(args) {
+		return nil, nil
+	}
+
+	f, err := float(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	digits, ok := args[1].(int64)
+	if !ok {
+		return nil, fmt.Errorf("%w: digits must be int", ErrInvalidArguments)
+	}
+
+	scale := math.Pow(10, float64(digits))
+	if math.IsInf(scale, 0) || scale == 0 {
+		return f, nil
+	}
+
+	return math.Round(f*scale) / scale, nil
+}
+
+func mod(s *Session, args []interface{}) (interface{}, error) {
+	if anyNull(args) {
+		return nil, nil
+	}
+
+	l, lok := args[0].(int64)
+	r, rok := args[1].(int64)
+	if lok && rok {
+		if r == 0 {
+			return nil, types.ErrDivisionByZero
+		}
+		// MinInt64 % -1 is 0, which Go already gets right
+		return l % r, nil
+	}
+
+	lf, err := float(args[0])
+	if err != nil {
+		return nil, err
+	}
+	rf, err := float(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if rf == 0 {
+		return nil, types.ErrDivisionByZero
+	}
+	return math.Mod(lf, rf), nil
+}
+
+func power(s *Session, args []interface{}) (interface{}, error) {
+	if anyNull(args) {
+		return nil, nil
+	}
+
+	base, err := float(args[0])
+	if err != nil {
+		return nil, err
+	}
+	exp, err := float(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	v := math.Pow(base, exp)
+	if math.IsNaN(v) {
+		return nil, fmt.Errorf("%w: %v to the power of %v", ErrInvalidArguments, base, exp)
+	}
+	if math.IsInf(v, 0) && !math.IsInf(base, 0) {
+		return nil, fmt.Errorf("%w: %v to the power of %v", types.ErrOutOfRange, base, exp)
+	}
+
+	return v, nil
+}
+
+// setseed seeds RANDOM() in the session with a number between -1 and 1.
+func setseed(s *Session, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+
+	f, err := float(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if f < -1 || f > 1 {
+		return nil, fmt.Errorf("%w: seed %v is not between -1 and 1", types.ErrOutOfRange, f)
+	}
+
+	if s != nil {
+		s.SetSeed(int64(f * (1 << 62)))
+	}
+	return nil, nil
+}
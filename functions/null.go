@@ -0,0 +1,66 @@
+package functions
+
+import (
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	Register(&Function{
+		Name:    "coalesce",
+		MinArgs: 1,
+		MaxArgs: -1,
+		Type:    common,
+		Eval:    coalesce,
+	})
+
+	Register(&Function{
+		Name:    "ifnull",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Type:    common,
+		Eval:    coalesce,
+	})
+
+	Register(&Function{
+		Name:    "nullif",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			if _, _, err := common(args); err != nil {
+				return 0, false, err
+			}
+
+			return args[0].Type, args[0].Known, nil
+		},
+		Eval: nullif,
+	})
+}
+
+// coalesce returns the first of args that isn't NULL.
+func coalesce(s *Session, args []interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// nullif returns NULL if both args are equal and otherwise the first one.
+func nullif(s *Session, args []interface{}) (interface{}, error) {
+	if args[0] == nil || args[1] == nil {
+		return args[0], nil
+	}
+
+	eq, err := types.Apply("=", args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if eq == true {
+		return nil, nil
+	}
+
+	return args[0], nil
+}
@@ -0,0 +1,104 @@
+package functions
+
+import (
+	"fmt"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	Register(&Function{
+		Name:     "nextval",
+		MinArgs:  1,
+		MaxArgs:  1,
+		Modifies: true,
+		Volatile: true,
+		Type:     sequenceType,
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			name, ok := args[0].(string)
+			if !ok || s == nil || s.sequences == nil {
+				return nil, sequenceError(ok)
+			}
+
+			v, err := s.nextValue(name)
+			if err != nil {
+				return nil, err
+			}
+
+			s.currval[name] = v
+			s.handed = append(s.handed, v)
+			return v, nil
+		},
+	})
+
+	Register(&Function{
+		Name:     "currval",
+		MinArgs:  1,
+		MaxArgs:  1,
+		Volatile: true,
+		Type:     sequenceType,
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			name, ok := args[0].(string)
+			if !ok || s == nil {
+				return nil, sequenceError(ok)
+			}
+
+			v, ok := s.currval[name]
+			if !ok {
+				return nil, fmt.Errorf("Currval of sequence %q is not yet defined in this session", name)
+			}
+
+			return v, nil
+		},
+	})
+
+	Register(&Function{
+		Name:     "setval",
+		MinArgs:  2,
+		MaxArgs:  2,
+		Modifies: true,
+		Volatile: true,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			if args[1].Known && args[1].Type != types.Int {
+				return 0, false, fmt.Errorf("%w: value must be int", ErrInvalidArguments)
+			}
+
+			return sequenceType(args[:1])
+		},
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			name, ok := args[0].(string)
+			if !ok || s == nil || s.sequences == nil {
+				return nil, sequenceError(ok)
+			}
+
+			v, ok := args[1].(int64)
+			if !ok {
+				return nil, fmt.Errorf("%w: value must not be NULL", ErrInvalidArguments)
+			}
+
+			if err := s.sequences.SetValue(name, v); err != nil {
+				return nil, err
+			}
+
+			return v, nil
+		},
+	})
+}
+
+func sequenceType(args []Arg) (types.Type, bool, error) {
+	if args[0].Known && args[0].Type != types.Text {
+		return 0, false, fmt.Errorf("%w: sequence name must be text", ErrInvalidArguments)
+	}
+
+	return types.Int, true, nil
+}
+
+// sequenceError explains why a sequence function couldn't run, named is
+// false when the sequence name was NULL.
+func sequenceError(named bool) error {
+	if !named {
+		return fmt.Errorf("%w: sequence name must not be NULL", ErrInvalidArguments)
+	}
+
+	return ErrNoSequences
+}
@@ -0,0 +1,208 @@
+package functions
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	Register(&Function{
+		Name:     "generate_series",
+		MinArgs:  2,
+		MaxArgs:  3,
+		Set:      true,
+		Type:     seriesType,
+		Eval:     generateSeries,
+		Generate: eachInSeries,
+	})
+}
+
+// seriesType accepts numbers with an optional step, or timestamps with an
+// interval step.
+func seriesType(args []Arg) (types.Type, bool, error) {
+	temporal := false
+	for _, arg := range args {
+		temporal = temporal || (arg.Known && (arg.Type == types.Timestamp || arg.Type == types.Interval))
+	}
+
+	if !temporal {
+		return numeric(args)
+	}
+
+	for _, arg := range args[:2] {
+		if arg.Known && arg.Type != types.Timestamp {
+			return 0, false, fmt.Errorf("%w: expected timestamps, not %s", ErrInvalidArguments, arg.Type)
+		}
+	}
+	if len(args) != 3 || (args[2].Known && args[2].Type != types.Interval) {
+		return 0, false, fmt.Errorf("%w: a series of timestamps needs an interval step", ErrInvalidArguments)
+	}
+
+	return types.Timestamp, true, nil
+}
+
+// generateSeries returns the values from start to stop, both included, a
+// step apart. The series is empty when stop can't be reached or any of the
+// arguments is NULL.
+func generateSeries(s *Session, args []interface{}) (interface{}, error) {
+	if anyNull(args) {
+		return types.Array{}, nil
+	}
+
+	series := types.Array{Elem: types.Float}
+	if _, ok := args[0].(time.Time); ok {
+		series.Elem = types.Timestamp
+	} else if isIntSeries(args) {
+		series.Elem = types.Int
+	}
+
+	err := eachInSeries(s, args, func(v interface{}) error {
+		series.Values = append(series.Values, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+func isIntSeries(args []interface{}) bool {
+	for _, arg := range args {
+		if _, ok := arg.(int64); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// eachInSeries hands the values generateSeries returns to yield one at a
+// time, so huge series can be stopped before they are built.
+func eachInSeries(s *Session, args []interface{}, yield func(interface{}) error) error {
+	if anyNull(args) {
+		return nil
+	}
+
+	if start, ok := args[0].(time.Time); ok {
+		return timestampSeries(start, args[1:], yield)
+	}
+
+	if isIntSeries(args) {
+		step := int64(1)
+		if len(args) == 3 {
+			step = args[2].(int64)
+		}
+		return intSeries(args[0].(int64), args[1].(int64), step, yield)
+	}
+
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		f, err := float(arg)
+		if err != nil {
+			return err
+		}
+		values[i] = f
+	}
+	if len(values) == 2 {
+		values = append(values, 1)
+	}
+
+	return floatSeries(values[0], values[1], values[2], yield)
+}
+
+func intSeries(start, stop, step int64, yield func(interface{}) error) error {
+	if step == 0 {
+		return fmt.Errorf("%w: step can't be zero", ErrInvalidArguments)
+	}
+
+	for v := start; (step > 0 && v <= stop) || (step < 0 && v >= stop); v += step {
+		if err := yield(v); err != nil {
+			return err
+		}
+
+		// The next value would overflow, so it is past stop too
+		if (step > 0 && v > math.MaxInt64-step) || (step < 0 && v < math.MinInt64-step) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func floatSeries(start, stop, step float64, yield func(interface{}) error) error {
+	if step == 0 || math.IsNaN(step) {
+		return fmt.Errorf("%w: step can't be zero", ErrInvalidArguments)
+	}
+	if math.IsInf(start, 0) || math.IsInf(stop, 0) || math.IsInf(step, 0) {
+		return fmt.Errorf("%w: series bounds must be finite", ErrInvalidArguments)
+	}
+
+	for i := 0; ; i++ {
+		// Multiplying rather than adding up the steps keeps rounding errors
+		// from piling up
+		v := start + float64(i)*step
+		if (step > 0 && v > stop) || (step < 0 && v < stop) {
+			break
+		}
+		if err := yield(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func timestampSeries(start time.Time, args []interface{}, yield func(interface{}) error) error {
+	stop, ok := args[0].(time.Time)
+	if !ok {
+		return fmt.Errorf("%w: %v is not a timestamp", ErrInvalidArguments, args[0])
+	}
+
+	var step types.IntervalValue
+	if len(args) == 2 {
+		step, ok = args[1].(types.IntervalValue)
+	}
+	if !ok || len(args) != 2 {
+		return fmt.Errorf("%w: a series of timestamps needs an interval step", ErrInvalidArguments)
+	}
+
+	next := func(t time.Time) (time.Time, error) {
+		v, err := types.Apply("+", t, step)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return v.(time.Time), nil
+	}
+
+	second, err := next(start)
+	if err != nil {
+		return err
+	}
+	if second.Equal(start) {
+		return fmt.Errorf("%w: step can't be zero", ErrInvalidArguments)
+	}
+	forward := second.After(start)
+
+	for t := start; (forward && !t.After(stop)) || (!forward && !t.Before(stop)); {
+		if err := yield(t); err != nil {
+			return err
+		}
+
+		following, err := next(t)
+		if err != nil {
+			return err
+		}
+		// A step of months forward and days back can stand still at the
+		// end of a month
+		if following.Equal(t) {
+			break
+		}
+		t = following
+	}
+
+	return nil
+}
@@ -0,0 +1,271 @@
+package functions
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrNoSequences     = errors.New("Sequences are not available in this session")
+	ErrReplayExhausted = errors.New("No sequence values, identifiers, times or random numbers left to replay")
+)
+
+// Sequences is the store of sequences NEXTVAL and SETVAL advance.
+type Sequences interface {
+	// NextValue advances the sequence name and returns its new value.
+	NextValue(name string) (int64, error)
+	// SetValue makes v the last value the sequence name handed out.
+	SetValue(name string, v int64) error
+}
+
+// Session is the state functions keep per session. A nil *Session is valid
+// and behaves like a fresh one that is thrown away after each call, without
+// access to sequences.
+type Session struct {
+	rand      *rand.Rand
+	sequences Sequences
+	// currval is the last value NEXTVAL returned for each sequence
+	currval map[string]int64
+	// handed is every value NEXTVAL returned since TakeValues, replayed is
+	// what it returns instead of advancing sequences while replaying
+	handed    []int64
+	replayed  []int64
+	replaying bool
+	// ids, replayedIDs and replayingIDs are the same for the identifiers
+	// UUID() and ULID() make, lastULID is the last ULID made
+	ids          []types.UUIDValue
+	replayedIDs  []types.UUIDValue
+	replayingIDs bool
+	lastULID     types.UUIDValue
+	// times and randoms are the same for the times NOW(), CURRENT_TIMESTAMP
+	// and CURRENT_DATE read and the numbers RANDOM() returned
+	times            []time.Time
+	replayedTimes    []time.Time
+	replayingTimes   bool
+	randoms          []float64
+	replayedRandoms  []float64
+	replayingRandoms bool
+	// user and database are what CURRENT_USER and DATABASE() return
+	user     string
+	database string
+	// privileged sessions aren't checked for who may run a statement, see
+	// SetPrivileged
+	privileged bool
+}
+
+// NewSession creates a session whose sequence functions use seqs, which may
+// be nil.
+func NewSession(seqs Sequences) *Session {
+	return &Session{
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		sequences: seqs,
+		currval:   map[string]int64{},
+	}
+}
+
+// Reset forgets the values NEXTVAL returned, so CURRVAL fails until it is
+// called again.
+func (s *Session) Reset() {
+	s.currval = map[string]int64{}
+}
+
+// SetSeed makes the sequence RANDOM() returns in the session repeatable.
+func (s *Session) SetSeed(seed int64) {
+	s.rand = rand.New(rand.NewSource(seed))
+}
+
+// SetUser sets the user the session runs as.
+func (s *Session) SetUser(user string) {
+	s.user = user
+}
+
+// SetPrivileged makes the session skip the checks of whether its user may
+// run a statement. Replaying the statement log does, the statements were
+// checked when they first ran.
+func (s *Session) SetPrivileged(privileged bool) {
+	s.privileged = privileged
+}
+
+// Privileged reports whether the session skips the checks of whether its
+// user may run a statement.
+func (s *Session) Privileged() bool {
+	return s != nil && s.privileged
+}
+
+// SetDatabase sets the name of the database the session is on, which is
+// empty for one without a name.
+func (s *Session) SetDatabase(name string) {
+	s.database = name
+}
+
+// Database returns the name of the database the session is on.
+func (s *Session) Database() string {
+	if s == nil {
+		return ""
+	}
+
+	return s.database
+}
+
+// User returns the user the session runs as, which is DefaultUser for a
+// session that hasn't been given one.
+func (s *Session) User() string {
+	if s == nil || s.user == "" {
+		return DefaultUser
+	}
+
+	return s.user
+}
+
+// TakeValues returns the values NEXTVAL has returned in order since it was
+// last called. Replaying them with Replay makes NEXTVAL return the same
+// values again.
+func (s *Session) TakeValues() []int64 {
+	v := s.handed
+	s.handed = nil
+	return v
+}
+
+// Replay makes NEXTVAL return values in order instead of advancing
+// sequences, until Replay is called with nil.
+func (s *Session) Replay(values []int64) {
+	s.replayed = values
+	s.replaying = values != nil
+}
+
+func (s *Session) nextValue(name string) (int64, error) {
+	if !s.replaying {
+		return s.sequences.NextValue(name)
+	}
+
+	if len(s.replayed) == 0 {
+		return 0, ErrReplayExhausted
+	}
+
+	v := s.replayed[0]
+	s.replayed = s.replayed[1:]
+	return v, nil
+}
+
+// TakeIDs returns the identifiers UUID() and ULID() have made in order
+// since it was last called. Replaying them with ReplayIDs makes the
+// functions return the same identifiers again.
+func (s *Session) TakeIDs() []types.UUIDValue {
+	ids := s.ids
+	s.ids = nil
+	return ids
+}
+
+// ReplayIDs makes UUID() and ULID() return ids in order instead of making
+// new identifiers, until ReplayIDs is called with nil.
+func (s *Session) ReplayIDs(ids []types.UUIDValue) {
+	s.replayedIDs = ids
+	s.replayingIDs = ids != nil
+}
+
+// newID returns an identifier made with generate, or the next one replayed,
+// and records it for TakeIDs.
+func (s *Session) newID(generate func() (types.UUIDValue, error)) (types.UUIDValue, error) {
+	if s == nil {
+		return generate()
+	}
+
+	if !s.replayingIDs {
+		id, err := generate()
+		if err == nil {
+			s.ids = append(s.ids, id)
+		}
+		return id, err
+	}
+
+	if len(s.replayedIDs) == 0 {
+		return types.UUIDValue{}, ErrReplayExhausted
+	}
+
+	id := s.replayedIDs[0]
+	s.replayedIDs = s.replayedIDs[1:]
+	s.ids = append(s.ids, id)
+	return id, nil
+}
+
+// TakeTimes returns the times NOW(), CURRENT_TIMESTAMP and CURRENT_DATE
+// have read in order since it was last called. Replaying them with
+// ReplayTimes makes the functions return the same times again.
+func (s *Session) TakeTimes() []time.Time {
+	times := s.times
+	s.times = nil
+	return times
+}
+
+// ReplayTimes makes NOW(), CURRENT_TIMESTAMP and CURRENT_DATE read times in
+// order instead of the clock, until ReplayTimes is called with nil.
+func (s *Session) ReplayTimes(times []time.Time) {
+	s.replayedTimes = times
+	s.replayingTimes = times != nil
+}
+
+// now returns the time, or the next one replayed, and records it for
+// TakeTimes. Timestamps are kept to the microsecond, which is all a dump
+// writes of them.
+func (s *Session) now() (time.Time, error) {
+	if s == nil {
+		return time.Now().UTC().Truncate(time.Microsecond), nil
+	}
+
+	if !s.replayingTimes {
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		s.times = append(s.times, now)
+		return now, nil
+	}
+
+	if len(s.replayedTimes) == 0 {
+		return time.Time{}, ErrReplayExhausted
+	}
+
+	now := s.replayedTimes[0]
+	s.replayedTimes = s.replayedTimes[1:]
+	s.times = append(s.times, now)
+	return now, nil
+}
+
+// TakeRandom returns the numbers RANDOM() has returned in order since it
+// was last called. Replaying them with ReplayRandom makes it return the
+// same numbers again.
+func (s *Session) TakeRandom() []float64 {
+	randoms := s.randoms
+	s.randoms = nil
+	return randoms
+}
+
+// ReplayRandom makes RANDOM() return randoms in order instead of new random
+// numbers, until ReplayRandom is called with nil.
+func (s *Session) ReplayRandom(randoms []float64) {
+	s.replayedRandoms = randoms
+	s.replayingRandoms = randoms != nil
+}
+
+// random returns a random number, or the next one replayed, and records it
+// for TakeRandom.
+func (s *Session) random() (float64, error) {
+	if s == nil {
+		return rand.Float64(), nil
+	}
+
+	if !s.replayingRandoms {
+		f := s.rand.Float64()
+		s.randoms = append(s.randoms, f)
+		return f, nil
+	}
+
+	if len(s.replayedRandoms) == 0 {
+		return 0, ErrReplayExhausted
+	}
+
+	f := s.replayedRandoms[0]
+	s.replayedRandoms = s.replayedRandoms[1:]
+	s.randoms = append(s.randoms, f)
+	return f, nil
+}
@@ -0,0 +1,38 @@
+package functions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	Register(&Function{Name: "lower", MinArgs: 1, MaxArgs: 1, Type: textual, Eval: mapText(strings.ToLower)})
+	Register(&Function{Name: "upper", MinArgs: 1, MaxArgs: 1, Type: textual, Eval: mapText(strings.ToUpper)})
+}
+
+// textual accepts text and returns text.
+func textual(args []Arg) (types.Type, bool, error) {
+	for _, arg := range args {
+		if arg.Known && arg.Type != types.Text {
+			return 0, false, fmt.Errorf("%w: expected text, not %s", ErrInvalidArguments, arg.Type)
+		}
+	}
+
+	return types.Text, true, nil
+}
+
+// mapText returns a function applying f to its text argument.
+func mapText(f func(string) string) func(s *Session, args []interface{}) (interface{}, error) {
+	return func(s *Session, args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return nil, nil
+		case string:
+			return f(v), nil
+		}
+
+		return nil, fmt.Errorf("%w: %v is not text", ErrInvalidArguments, args[0])
+	}
+}
@@ -0,0 +1,218 @@
+package functions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql/types"
+)
+
+func init() {
+	returns := func(t types.Type) func([]Arg) (types.Type, bool, error) {
+		return func(args []Arg) (types.Type, bool, error) {
+			return t, true, nil
+		}
+	}
+
+	now := func(s *Session, args []interface{}) (interface{}, error) {
+		return s.now()
+	}
+
+	Register(&Function{Name: "now", Type: returns(types.Timestamp), Eval: now, Volatile: true})
+	Register(&Function{Name: "current_timestamp", Type: returns(types.Timestamp), Eval: now, Volatile: true})
+	Register(&Function{
+		Name:     "current_date",
+		Type:     returns(types.Timestamp),
+		Volatile: true,
+		Eval: func(s *Session, args []interface{}) (interface{}, error) {
+			now, err := s.now()
+			return now.Truncate(24 * time.Hour), err
+		},
+	})
+
+	Register(&Function{
+		Name:    "date_part",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			if err := expectField(args); err != nil {
+				return 0, false, err
+			}
+			if args[1].Known && args[1].Type != types.Timestamp && args[1].Type != types.Interval {
+				return 0, false, fmt.Errorf("%w: expected timestamp or interval, not %s", ErrInvalidArguments, args[1].Type)
+			}
+
+			return types.Float, true, nil
+		},
+		Eval: datePart,
+	})
+
+	Register(&Function{
+		Name:    "date_trunc",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Type: func(args []Arg) (types.Type, bool, error) {
+			if err := expectField(args); err != nil {
+				return 0, false, err
+			}
+			if args[1].Known && args[1].Type != types.Timestamp {
+				return 0, false, fmt.Errorf("%w: expected timestamp, not %s", ErrInvalidArguments, args[1].Type)
+			}
+
+			return types.Timestamp, true, nil
+		},
+		Eval: dateTrunc,
+	})
+}
+
+func expectField(args []Arg) error {
+	if args[0].Known && args[0].Type != types.Text {
+		return fmt.Errorf("%w: field must be text, not %s", ErrInvalidArguments, args[0].Type)
+	}
+
+	return nil
+}
+
+func fieldName(v interface{}) (string, error) {
+	field, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: field must be text", ErrInvalidArguments)
+	}
+
+	return strings.ToLower(field), nil
+}
+
+// timestamp converts text arguments the way comparisons would.
+func timestamp(v interface{}) (time.Time, error) {
+	v, err := types.Cast(v, types.Timestamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return v.(time.Time), nil
+}
+
+// datePart implements date_part(field, source) and EXTRACT(field FROM
+// source).
+func datePart(s *Session, args []interface{}) (interface{}, error) {
+	if anyNull(args) {
+		return nil, nil
+	}
+
+	field, err := fieldName(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if iv, ok := args[1].(types.IntervalValue); ok {
+		return intervalPart(field, iv)
+	}
+
+	t, err := timestamp(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	switch field {
+	case "year", "years":
+		return float64(t.Year()), nil
+	case "quarter":
+		return float64((int(t.Month())-1)/3 + 1), nil
+	case "month", "months":
+		return float64(t.Month()), nil
+	case "week":
+		_, week := t.ISOWeek()
+		return float64(week), nil
+	case "day", "days":
+		return float64(t.Day()), nil
+	case "dow":
+		return float64(t.Weekday()), nil
+	case "isodow":
+		return float64((int(t.Weekday())+6)%7 + 1), nil
+	case "doy":
+		return float64(t.YearDay()), nil
+	case "hour", "hours":
+		return float64(t.Hour()), nil
+	case "minute", "minutes":
+		return float64(t.Minute()), nil
+	case "second", "seconds":
+		return float64(t.Second()) + float64(t.Nanosecond())/1e9, nil
+	case "milliseconds":
+		return float64(t.Second())*1e3 + float64(t.Nanosecond())/1e6, nil
+	case "microseconds":
+		return float64(t.Second())*1e6 + float64(t.Nanosecond()/1e3), nil
+	case "epoch":
+		return float64(t.UnixNano()) / 1e9, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidArguments, field)
+}
+
+func intervalPart(field string, iv types.IntervalValue) (interface{}, error) {
+	d := iv.Duration
+	switch field {
+	case "year", "years":
+		return float64(iv.Months / 12), nil
+	case "month", "months":
+		return float64(iv.Months % 12), nil
+	case "day", "days":
+		return float64(d / (24 * time.Hour)), nil
+	case "hour", "hours":
+		return float64(d % (24 * time.Hour) / time.Hour), nil
+	case "minute", "minutes":
+		return float64(d % time.Hour / time.Minute), nil
+	case "second", "seconds":
+		return (d % time.Minute).Seconds(), nil
+	case "epoch":
+		// A month is 30 days, like when intervals are compared
+		return float64(iv.Months)*30*86400 + d.Seconds(), nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown field %q for interval", ErrInvalidArguments, field)
+}
+
+// dateTrunc truncates a timestamp to the start of the given field.
+func dateTrunc(s *Session, args []interface{}) (interface{}, error) {
+	if anyNull(args) {
+		return nil, nil
+	}
+
+	t, err := timestamp(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	field, err := fieldName(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	switch field {
+	case "microseconds":
+		return t.Truncate(time.Microsecond), nil
+	case "milliseconds":
+		return t.Truncate(time.Millisecond), nil
+	case "second":
+		return t.Truncate(time.Second), nil
+	case "minute":
+		return t.Truncate(time.Minute), nil
+	case "hour":
+		return t.Truncate(time.Hour), nil
+	case "day":
+		return t.Truncate(24 * time.Hour), nil
+	case "week":
+		// Weeks start on Monday
+		day := t.Truncate(24 * time.Hour)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7)), nil
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	case "quarter":
+		month := (t.Month()-1)/3*3 + 1
+		return time.Date(t.Year(), month, 1, 0, 0, 0, 0, time.UTC), nil
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidArguments, field)
+}
@@ -0,0 +1,161 @@
+package functions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql/types"
+)
+
+// call calls the function called name with args.
+func call(t *testing.T, s *Session, name string, args ...interface{}) (interface{}, error) {
+	t.Helper()
+
+	f, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("%s is not registered", name)
+	}
+	return f.Eval(s, args)
+}
+
+func TestDatePart(t *testing.T) {
+	ts := time.Date(2024, 2, 29, 13, 45, 30, 250000000, time.UTC)
+	iv := types.IntervalValue{Months: 14, Duration: 50*time.Hour + 30*time.Minute + 1500*time.Millisecond}
+
+	tests := []struct {
+		field  string
+		source interface{}
+		want   interface{}
+	}{
+		{"year", ts, 2024.0},
+		{"QUARTER", ts, 1.0},
+		{"month", ts, 2.0},
+		{"week", ts, 9.0},
+		{"day", ts, 29.0},
+		{"dow", ts, 4.0},
+		{"isodow", time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC), 7.0},
+		{"doy", ts, 60.0},
+		{"hour", ts, 13.0},
+		{"minute", ts, 45.0},
+		{"second", ts, 30.25},
+		{"milliseconds", ts, 30250.0},
+		{"epoch", time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC), 86400.0},
+		// Text is converted like in comparisons
+		{"day", "2024-03-01 10:00", 1.0},
+		{"year", iv, 1.0},
+		{"month", iv, 2.0},
+		{"day", iv, 2.0},
+		{"hour", iv, 2.0},
+		{"minute", iv, 30.0},
+		{"second", iv, 1.5},
+		{"epoch", iv, 14*30*86400 + 50*3600 + 30*60 + 1.5},
+		{"day", nil, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := call(t, nil, "date_part", tt.field, tt.source)
+		if err != nil || got != tt.want {
+			t.Errorf("date_part(%q, %v) = %v, %v, want %v", tt.field, tt.source, got, err, tt.want)
+		}
+	}
+
+	for _, args := range [][]interface{}{{"fortnight", ts}, {"dow", iv}, {"day", "yesterday"}} {
+		if got, err := call(t, nil, "date_part", args...); err == nil {
+			t.Errorf("date_part(%q, %v) = %v, want an error", args[0], args[1], got)
+		}
+	}
+}
+
+func TestDateTrunc(t *testing.T) {
+	ts := time.Date(2024, 8, 15, 13, 45, 30, 123456789, time.UTC)
+
+	tests := []struct {
+		field string
+		want  time.Time
+	}{
+		{"microseconds", time.Date(2024, 8, 15, 13, 45, 30, 123456000, time.UTC)},
+		{"milliseconds", time.Date(2024, 8, 15, 13, 45, 30, 123000000, time.UTC)},
+		{"second", time.Date(2024, 8, 15, 13, 45, 30, 0, time.UTC)},
+		{"minute", time.Date(2024, 8, 15, 13, 45, 0, 0, time.UTC)},
+		{"Hour", time.Date(2024, 8, 15, 13, 0, 0, 0, time.UTC)},
+		{"day", time.Date(2024, 8, 15, 0, 0, 0, 0, time.UTC)},
+		// 15 August 2024 is a Thursday, weeks start on Monday
+		{"week", time.Date(2024, 8, 12, 0, 0, 0, 0, time.UTC)},
+		{"month", time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{"quarter", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"year", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := call(t, nil, "date_trunc", tt.field, ts)
+		if err != nil || got != tt.want {
+			t.Errorf("date_trunc(%q) = %v, %v, want %v", tt.field, got, err, tt.want)
+		}
+	}
+
+	if got, err := call(t, nil, "date_trunc", "decade", ts); !errors.Is(err, ErrInvalidArguments) {
+		t.Errorf("date_trunc(decade) = %v, %v, want %v", got, err, ErrInvalidArguments)
+	}
+}
+
+func TestTimeArgumentTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []Arg
+		ok   bool
+	}{
+		{"date_part", []Arg{{types.Text, true}, {types.Timestamp, true}}, true},
+		{"date_part", []Arg{{types.Text, true}, {types.Interval, true}}, true},
+		{"date_part", []Arg{{types.Text, true}, {types.Int, true}}, false},
+		{"date_part", []Arg{{types.Int, true}, {types.Timestamp, true}}, false},
+		{"date_part", []Arg{{}, {}}, true},
+		{"date_trunc", []Arg{{types.Text, true}, {types.Timestamp, true}}, true},
+		{"date_trunc", []Arg{{types.Text, true}, {types.Interval, true}}, false},
+	}
+
+	for _, tt := range tests {
+		f, _ := Lookup(tt.name)
+		if _, _, err := f.Type(tt.args); (err == nil) != tt.ok {
+			t.Errorf("%s%v: got %v, want ok %v", tt.name, tt.args, err, tt.ok)
+		}
+	}
+}
+
+func TestNowReplayed(t *testing.T) {
+	s := NewSession(nil)
+
+	first, err := call(t, s, "now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.(time.Time).Location() != time.UTC || first.(time.Time).Nanosecond()%1000 != 0 {
+		t.Errorf("now() = %v, want UTC to the microsecond", first)
+	}
+	if _, err := call(t, s, "current_date"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying the times read returns them again, in order
+	times := s.TakeTimes()
+	if len(times) != 2 || times[0] != first {
+		t.Fatalf("took %v", times)
+	}
+	times[1] = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	s.ReplayTimes(times)
+
+	if got, err := call(t, s, "current_timestamp"); err != nil || got != first {
+		t.Errorf("current_timestamp() = %v, %v, want %v", got, err, first)
+	}
+	if got, err := call(t, s, "current_date"); err != nil || got != time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("current_date() = %v, %v", got, err)
+	}
+	if _, err := call(t, s, "now"); !errors.Is(err, ErrReplayExhausted) {
+		t.Errorf("got %v, want %v", err, ErrReplayExhausted)
+	}
+
+	s.ReplayTimes(nil)
+	if _, err := call(t, s, "now"); err != nil {
+		t.Error(err)
+	}
+}
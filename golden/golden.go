@@ -0,0 +1,270 @@
+// Package golden tests what SQL renders to against files holding what it
+// rendered to before, so a change to the grammar or the planner shows up
+// as a diff of those files. Every .sql file of a directory is a test case
+// whose expected output is kept next to it:
+//
+//	func TestParser(t *testing.T) {
+//		golden.Test(t, "testdata", ".ast", golden.AST)
+//	}
+//
+// compares the AST of testdata/select.sql with testdata/select.ast.golden.
+// Running the tests with -update writes the golden files instead, to be
+// reviewed like any other change.
+package golden
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql/analyzer"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+)
+
+var update = flag.Bool("update", false, "write golden files instead of comparing with them")
+
+// Test runs render on the contents of every .sql file in dir, each in a
+// subtest named after the file, and compares the output with the file of
+// the same name with the extension ext and .golden. An error rendering is
+// part of the output, so expected errors can be tested too.
+func Test(t *testing.T, dir, ext string, render func(src string) (string, error)) {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("No .sql files in %s", dir)
+	}
+
+	for _, path := range paths {
+		path := path
+		name := strings.TrimSuffix(filepath.Base(path), ".sql")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := render(string(src))
+			if err != nil {
+				got += "error: " + err.Error() + "\n"
+			}
+
+			goldenPath := strings.TrimSuffix(path, ".sql") + ext + ".golden"
+			if *update {
+				if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+
+			if got != string(want) {
+				t.Errorf("%s differs from the output:\n%s", goldenPath, diff(string(want), got))
+			}
+		})
+	}
+}
+
+// diff returns the lines of want missing from got prefixed with -, and
+// the lines of got missing from want prefixed with +.
+func diff(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+
+	// Only the changed lines and a few around them are kept
+	const context = 3
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if line[0] == ' ' {
+			continue
+		}
+
+		for k := i - context; k <= i+context; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for i, line := range lines {
+		if keep[i] {
+			sb.WriteString(line + "\n")
+		} else if i > 0 && keep[i-1] {
+			sb.WriteString("...\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// AST renders the syntax tree of every statement in src. Fields without a
+// value and locations are left out, so moving a statement around doesn't
+// change its tree.
+func AST(src string) (string, error) {
+	ast, err := parser.Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, stmt := range ast.Statements {
+		sb.WriteString("-- " + stmt.Text + "\n")
+		writeNode(&sb, reflect.ValueOf(*stmt), 0)
+		sb.WriteString("\n\n")
+	}
+
+	return sb.String(), nil
+}
+
+var tokenTypes = map[parser.TokenType]string{
+	parser.KeywordType:    "keyword",
+	parser.SymbolType:     "symbol",
+	parser.IdentifierType: "identifier",
+	parser.StringType:     "string",
+	parser.NumericType:    "numeric",
+	parser.ParameterType:  "parameter",
+	parser.HintType:       "hint",
+}
+
+var (
+	tokenType    = reflect.TypeOf(parser.Token{})
+	locationType = reflect.TypeOf(parser.Location{})
+)
+
+func writeNode(sb *strings.Builder, v reflect.Value, depth int) {
+	indent := strings.Repeat("  ", depth)
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		writeNode(sb, v.Elem(), depth)
+	case reflect.Slice:
+		sb.WriteString("[\n")
+		for i := 0; i < v.Len(); i++ {
+			sb.WriteString(indent + "  ")
+			writeNode(sb, v.Index(i), depth+1)
+			sb.WriteString("\n")
+		}
+		sb.WriteString(indent + "]")
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			t := v.Interface().(parser.Token)
+			sb.WriteString(fmt.Sprintf("%s %q", tokenTypes[t.Type], t.Value))
+			return
+		}
+
+		sb.WriteString(v.Type().Name() + "{\n")
+		for i := 0; i < v.NumField(); i++ {
+			field, f := v.Type().Field(i), v.Field(i)
+			if f.Type() == locationType || (field.Name == "Text" && v.Type().Name() == "Statement") {
+				continue
+			}
+
+			// Type fields tell which of the other fields is set, so they are
+			// kept even when they are zero
+			if f.IsZero() && field.Name != "Type" {
+				continue
+			}
+
+			sb.WriteString(indent + "  " + field.Name + ": ")
+			writeNode(sb, f, depth+1)
+			sb.WriteString("\n")
+		}
+		sb.WriteString(indent + "}")
+	case reflect.String:
+		sb.WriteString(strconv.Quote(v.String()))
+	default:
+		sb.WriteString(fmt.Sprint(v.Interface()))
+	}
+}
+
+// Plan runs the statements in src against an empty database and renders
+// the plan of every query among them. Other statements run without output,
+// so a file can create the tables its queries need.
+func Plan(src string) (string, error) {
+	ast, err := parser.Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	mb := backend.NewMemoryBackend()
+	var sb strings.Builder
+	for _, stmt := range ast.Statements {
+		if err := analyzer.Analyze(mb, stmt); err != nil {
+			return sb.String(), err
+		}
+
+		if stmt.Type != parser.SelectType {
+			if _, err := backend.Exec(context.Background(), mb, stmt, nil, nil); err != nil {
+				return sb.String(), err
+			}
+			continue
+		}
+
+		explain := &parser.Statement{
+			ExplainStatement: &parser.ExplainStatement{Statement: stmt},
+			Type:             parser.ExplainType,
+		}
+		results, err := backend.Exec(context.Background(), mb, explain, nil, nil)
+		if err != nil {
+			return sb.String(), err
+		}
+
+		sb.WriteString("-- " + stmt.Text + "\n")
+		for _, row := range results.Rows {
+			sb.WriteString(fmt.Sprint(row[0]) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,82 @@
+package golden
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGolden(t *testing.T) {
+	Test(t, "testdata", ".ast", AST)
+	Test(t, "testdata", ".plan", Plan)
+}
+
+func TestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	src := "select 1;\nselect 'a' || 'b';\n"
+	if err := os.WriteFile(filepath.Join(dir, "q.sql"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	*update = true
+	Test(t, dir, ".ast", AST)
+	*update = false
+
+	got, err := os.ReadFile(filepath.Join(dir, "q.ast.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := AST(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+
+	// The golden file written is then compared with
+	Test(t, dir, ".ast", AST)
+}
+
+func TestAST(t *testing.T) {
+	got, err := AST("select a from t where a = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"-- select a from t where a = 1\n", `Column: identifier "a"`, `Op: symbol "="`, "Int64: 1"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q is missing from:\n%s", want, got)
+		}
+	}
+	// Locations and fields without a value are left out
+	for _, missing := range []string{"Loc", "Line", "Float64", "Hints"} {
+		if strings.Contains(got, missing) {
+			t.Errorf("%q is in:\n%s", missing, got)
+		}
+	}
+
+	if _, err := AST("select from where"); err == nil {
+		t.Error("Rendered the AST of invalid SQL")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		want, got string
+		diff      string
+	}{
+		{"a\nb\nc", "a\nb\nc", ""},
+		{"a\nb\nc", "a\nx\nc", "  a\n- b\n+ x\n  c\n"},
+		{"a\nb", "a\nb\nc", "  a\n  b\n+ c\n"},
+		{"1\n2\n3\n4\n5\n6\n7\n8\n9", "1\n2\n3\n4\n5\n6\n7\n8\nx", "  6\n  7\n  8\n- 9\n+ x\n"},
+		{"x\n1\n2\n3\n4\n5\n6\n7\n8\n9\ny", "1\n2\n3\n4\n5\n6\n7\n8\n9", "- x\n  1\n  2\n  3\n...\n  7\n  8\n  9\n- y\n"},
+	}
+
+	for _, tt := range tests {
+		if got := diff(tt.want, tt.got); got != tt.diff {
+			t.Errorf("diff(%q, %q) =\n%s\nwant\n%s", tt.want, tt.got, got, tt.diff)
+		}
+	}
+}
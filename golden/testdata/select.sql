@@ -0,0 +1,4 @@
+create table t (id int, name text);
+create index t_id on t (id);
+select name from t where id = 1;
+select count(*) from t where name = 'a';
@@ -0,0 +1,56 @@
+package sgsql
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Health is how the database is doing, as reported by Health.
+type Health struct {
+	// Err is the error commits fail with, nil while they can succeed. It
+	// is ErrSyncFailed once syncing the statement log failed, which
+	// vacuuming or opening the database again recovers from.
+	Err error
+	// Vacuuming is set while the database vacuums itself in the
+	// background, which transactions wait for
+	Vacuuming bool
+	// ReadOnly is set when the database was opened read-only
+	ReadOnly bool
+	// DiskFree is the bytes left on the file system holding the statement
+	// log, -1 when it isn't known, like for databases kept in memory or
+	// on platforms it can't be read on
+	DiskFree int64
+	// Replayed is how many entries of the statement log were replayed to
+	// recover the database when it was opened, and ReplayTime how long
+	// that took
+	Replayed   int
+	ReplayTime time.Duration
+}
+
+// Health reports how the database is doing without starting a
+// transaction, so it answers right away even while transactions wait for
+// each other. The statement log is replayed before Open returns, so an open
+// database has always recovered. There are no replicas, so there is no
+// replication lag to report either.
+func (db *DB) Health() Health {
+	db.syncMu.Lock()
+	err := db.syncErr
+	db.syncMu.Unlock()
+
+	free := int64(-1)
+	if db.path != "" && db.path != MemoryPath {
+		if n, ok := diskFree(filepath.Dir(db.path)); ok {
+			free = n
+		}
+	}
+
+	return Health{
+		Err:        err,
+		Vacuuming:  atomic.LoadInt32(&db.vacuuming) == 1,
+		ReadOnly:   db.readOnly,
+		DiskFree:   free,
+		Replayed:   db.replayed,
+		ReplayTime: db.replayTime,
+	}
+}
@@ -0,0 +1,135 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nireo/sgsql/analyzer"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+)
+
+var ErrIndexInTx = errors.New("CREATE INDEX CONCURRENTLY can't run inside a transaction block")
+
+// createIndexConcurrently runs CREATE INDEX CONCURRENTLY. Rather than in a
+// single transaction, which would keep every other session waiting until
+// the index is built, the index is built between two short ones: the first
+// takes the values of the indexed column, the second indexes the rows
+// inserted meanwhile and adds the index. Other sessions write to the table
+// in between, and the build is listed in __jobs where KILL can cancel it.
+func (c *Conn) createIndexConcurrently(ctx context.Context, stmt *parser.Statement) (*Results, error) {
+	if c.tx != nil {
+		return nil, ErrIndexInTx
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	tx := c.begin()
+	build, err := tx.startIndex(stmt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err := c.db.backend.BuildIndex(backend.WithSessionID(ctx, c.ID()), build); err != nil {
+		return nil, err
+	}
+
+	return c.autocommit(ctx, func(tx *Tx) (*Results, error) {
+		if err := c.db.backend.FinishIndex(build); err != nil {
+			return nil, err
+		}
+
+		// Replaying the statement builds the index right away
+		entry := logEntry{Query: stmt.Text}
+		if user := tx.session.User(); user != functions.DefaultUser {
+			entry.User = user
+		}
+		tx.pending = append(tx.pending, entry)
+
+		return &Results{}, nil
+	})
+}
+
+// startIndex checks the CREATE INDEX stmt and starts building its index.
+func (tx *Tx) startIndex(stmt *parser.Statement) (*backend.IndexBuild, error) {
+	if _, _, _, err := tx.attachedTarget(stmt); err != nil {
+		return nil, err
+	}
+
+	if err := analyzer.Analyze(tx.db.backend, stmt); err != nil {
+		return nil, err
+	}
+	backend.Optimize(stmt)
+
+	return tx.db.backend.StartIndex(stmt.CreateIndexStatement, tx.session)
+}
+
+// SetForeignKeyIndexes makes the statements creating foreign keys, CREATE
+// TABLE and ALTER TABLE, also create an index over each of their columns
+// that isn't indexed yet. Without one, finding the rows referencing a row
+// scans the whole table referencing it. The indexes are created by CREATE
+// INDEX statements of their own, logged after the one creating the foreign
+// key, so the database is replayed with them whatever the setting is then.
+// It is off until set.
+func (db *DB) SetForeignKeyIndexes(on bool) {
+	db.foreignKeyIndexes.Store(on)
+}
+
+// indexForeignKeys creates the indexes over the foreign keys stmt created,
+// if SetForeignKeyIndexes turned that on.
+func (tx *Tx) indexForeignKeys(ctx context.Context, stmt *parser.Statement) error {
+	if on, _ := tx.db.foreignKeyIndexes.Load().(bool); !on {
+		return nil
+	}
+
+	var table string
+	created := []string{}
+	switch stmt.Type {
+	case parser.CreateTableType:
+		table = stmt.CreateTableStatement.Name.Value
+		if stmt.CreateTableStatement.Cols != nil {
+			for _, col := range *stmt.CreateTableStatement.Cols {
+				if col.References != nil {
+					created = append(created, col.Name.Value)
+				}
+			}
+		}
+	case parser.AlterTableType:
+		alter := stmt.AlterTableStatement
+		table = alter.Table.Value
+		switch {
+		case alter.Add != nil && alter.Add.References != nil:
+			created = append(created, alter.Add.Name.Value)
+		case alter.Constraint != nil && alter.Constraint.References != nil:
+			created = append(created, alter.Constraint.Column.Value)
+		}
+	}
+
+	unindexed := map[string]bool{}
+	for _, col := range tx.db.backend.UnindexedForeignKeys(table) {
+		unindexed[col] = true
+	}
+
+	for _, col := range created {
+		if !unindexed[col] {
+			continue
+		}
+
+		ast, err := parser.Parse("CREATE INDEX ON " + parser.FormatIdentifier(table) + " (" + parser.FormatIdentifier(col) + ")")
+		if err != nil {
+			return err
+		}
+		if _, err := tx.execStatement(ctx, ast.Statements[0], nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
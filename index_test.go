@@ -0,0 +1,131 @@
+package sgsql
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreateIndexConcurrently(t *testing.T) {
+	db, path := openTest(t)
+	mustExec(t, db, "create table t (a int, b text)")
+
+	rows := make([][]interface{}, 0, 20000)
+	for i := 0; i < 20000; i++ {
+		rows = append(rows, []interface{}{int64(i % 500), "before"})
+	}
+	if err := db.BulkInsert("t", rows); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows are inserted from another session until the index is built
+	done := make(chan struct{})
+	inserted := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-done:
+				inserted <- n
+				return
+			default:
+			}
+			if err := db.Exec("insert into t values ($1, 'during')", int64(n%500)); err != nil {
+				t.Error(err)
+				inserted <- n
+				return
+			}
+			n++
+		}
+	}()
+
+	err := db.Exec("create index concurrently t_a on t (a)")
+	close(done)
+	n := <-inserted
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Log("No rows were inserted while the index was built")
+	}
+	mustExec(t, db, "insert into t values (7, 'after')")
+
+	check := func(db *DB) {
+		t.Helper()
+
+		if got := queryRows(t, db, "select count(*) from t"); got[0][0] != int64(20001+n) {
+			t.Fatalf("got %v rows, want %d", got[0][0], 20001+n)
+		}
+
+		pairs := [][2]string{
+			{"a = 7", "a + 0 = 7"},
+			{"a >= 490", "a + 0 >= 490"},
+			{"a between 100 and 102", "a + 0 between 100 and 102"},
+		}
+		for _, p := range pairs {
+			plan := queryRows(t, db, "explain select b from t where "+p[0])
+			if !strings.Contains(fmt.Sprint(plan), " using t_a") {
+				t.Fatalf("%s: index not used:\n%v", p[0], plan)
+			}
+
+			indexed := queryRows(t, db, "select count(*), sum(a), min(b), max(b) from t where "+p[0])
+			scanned := queryRows(t, db, "select count(*), sum(a), min(b), max(b) from t where "+p[1])
+			if !reflect.DeepEqual(indexed, scanned) {
+				t.Errorf("%s: got %v, want %v", p[0], indexed, scanned)
+			}
+		}
+	}
+	check(db)
+
+	// The index is rebuilt from the log with every row in it
+	db = reopen(t, db, path)
+	check(db)
+}
+
+func TestCreateIndexConcurrentlyInTx(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db, "create table t (a int)")
+
+	c := db.Conn()
+	defer c.Close()
+	mustExec(t, c, "begin")
+	if err := c.Exec("create index concurrently t_a on t (a)"); !errors.Is(err, ErrIndexInTx) {
+		t.Fatalf("got %v, want %v", err, ErrIndexInTx)
+	}
+}
+
+func TestForeignKeyIndexes(t *testing.T) {
+	db, path := openTest(t)
+	mustExec(t, db, "create table p (id int)")
+	mustExec(t, db, "create table before (pid int references p (id))")
+
+	db.SetForeignKeyIndexes(true)
+	mustExec(t, db, "create table c (id int, pid int references p (id), name text)")
+	mustExec(t, db, "alter table c add column qid int references p (id)")
+	mustExec(t, db, "create table d (pid int)")
+	mustExec(t, db, "create index d_pid on d (pid)")
+	mustExec(t, db, "alter table d add foreign key (pid) references p (id)")
+
+	check := func(db *DB) {
+		t.Helper()
+
+		want := map[string][]string{"before": {"pid"}, "c": {}, "d": {}}
+		for table, columns := range want {
+			if got := db.backend.UnindexedForeignKeys(table); !reflect.DeepEqual(got, columns) {
+				t.Errorf("%s: got %v unindexed, want %v", table, got, columns)
+			}
+		}
+
+		plan := queryRows(t, db, "explain select id from c where qid = 1")
+		if !strings.Contains(fmt.Sprint(plan), " using ") {
+			t.Errorf("index not used:\n%v", plan)
+		}
+	}
+	check(db)
+
+	// The indexes are logged, so they are replayed with the setting off
+	db = reopen(t, db, path)
+	check(db)
+}
@@ -0,0 +1,223 @@
+// Package lint points out statements that work but are likely to be
+// mistakes or slow, like SELECT * or WHERE clauses that can't use an index.
+// Each check is a rule registered under a name, and programs can register
+// rules of their own next to the built-in ones:
+//
+//	func init() {
+//		lint.Register("no-nextval", lint.RuleFunc(func(stmt *parser.Statement, report lint.Report) {
+//			...
+//		}))
+//	}
+//
+// Rules only look at the statements, they don't know the tables, so they
+// also find issues in queries for databases that aren't at hand.
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+var (
+	ErrNoSuchRule = errors.New("Lint rule does not exist")
+	ErrRuleExists = errors.New("Lint rule is already registered")
+)
+
+// Issue is something a rule found wrong with a statement of a query.
+type Issue struct {
+	// Rule is the name of the rule that found it
+	Rule string
+	// Statement is the index of the statement in the query
+	Statement int
+	// Loc is where the expression the issue is about starts, it is zero
+	// when the issue is about the whole statement
+	Loc     parser.Location
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("[%d,%d]: %s (%s)", i.Loc.Line, i.Loc.Column, i.Message, i.Rule)
+}
+
+// Report records an issue of the statement being checked. at is the
+// expression it is about, or nil for the whole statement.
+type Report func(at *parser.Expression, message string)
+
+// Rule checks statements for one kind of issue.
+type Rule interface {
+	// Check calls report for every issue it finds in stmt
+	Check(stmt *parser.Statement, report Report)
+}
+
+// RuleFunc lets a function be used as a Rule.
+type RuleFunc func(stmt *parser.Statement, report Report)
+
+func (f RuleFunc) Check(stmt *parser.Statement, report Report) {
+	f(stmt, report)
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]Rule{}
+)
+
+// Register makes rule available under name. It panics if name is already
+// taken, like registering the same rule twice.
+func Register(name string, rule Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	if _, ok := rules[name]; ok {
+		panic(ErrRuleExists.Error() + ": " + name)
+	}
+
+	rules[name] = rule
+}
+
+// Rules returns the names of the registered rules in order.
+func Rules() []string {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Lint checks every statement of query with the rules called names, or
+// every registered rule when none are named. The issues are in the order
+// of the statements and of the rules. Queries that don't parse fail.
+func Lint(query string, names ...string) ([]Issue, error) {
+	if len(names) == 0 {
+		names = Rules()
+	}
+
+	rulesMu.RLock()
+	checks := make([]Rule, len(names))
+	for i, name := range names {
+		rule, ok := rules[name]
+		if !ok {
+			rulesMu.RUnlock()
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchRule, name)
+		}
+		checks[i] = rule
+	}
+	rulesMu.RUnlock()
+
+	ast, err := parser.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	issues := []Issue{}
+	for i, stmt := range ast.Statements {
+		for j, rule := range checks {
+			rule.Check(stmt, func(at *parser.Expression, message string) {
+				issue := Issue{Rule: names[j], Statement: i, Message: message}
+				if at != nil {
+					issue.Loc = at.Loc
+				}
+				issues = append(issues, issue)
+			})
+		}
+	}
+
+	return issues, nil
+}
+
+// Queries returns the queries of stmt, the subqueries of each following
+// it. Rules about queries check each of them, wherever it is used.
+func Queries(stmt *parser.Statement) []*parser.SelectStatement {
+	var top *parser.SelectStatement
+	var values []*parser.Expression
+	switch stmt.Type {
+	case parser.SelectType:
+		top = stmt.SelectStatement
+	case parser.DeclareCursorType:
+		top = stmt.DeclareCursorStatement.Query
+	case parser.CreateMaterializedViewType:
+		top = stmt.CreateMaterializedViewStatement.Query
+	case parser.InsertType:
+		if stmt.InsertStatement.Values != nil {
+			values = *stmt.InsertStatement.Values
+		}
+	case parser.ExplainType:
+		return Queries(stmt.ExplainStatement.Statement)
+	}
+
+	var queries []*parser.SelectStatement
+	var query func(slct *parser.SelectStatement)
+	var walk func(exp *parser.Expression)
+	query = func(slct *parser.SelectStatement) {
+		queries = append(queries, slct)
+		for _, item := range slct.Item {
+			if !item.Asterisk {
+				walk(item.Exp)
+			}
+		}
+		if slct.Function != nil {
+			walk(slct.Function)
+		}
+		if slct.Where != nil {
+			walk(slct.Where)
+		}
+		if slct.ConnectBy != nil && slct.ConnectBy.Start != nil {
+			walk(slct.ConnectBy.Start)
+		}
+		for _, order := range slct.OrderBy {
+			walk(order.Exp)
+		}
+	}
+	walk = func(exp *parser.Expression) {
+		switch exp.Type {
+		case parser.BinaryType:
+			walk(&exp.Binary.A)
+			walk(&exp.Binary.B)
+		case parser.CastType:
+			walk(&exp.Cast.Exp)
+		case parser.IndexType:
+			walk(&exp.Index.Exp)
+			walk(&exp.Index.Index)
+		case parser.CallType:
+			for i := range exp.Call.Args {
+				walk(&exp.Call.Args[i])
+			}
+		case parser.ArrayType:
+			for i := range exp.Array {
+				walk(&exp.Array[i])
+			}
+		case parser.RowType:
+			for i := range exp.Row {
+				walk(&exp.Row[i])
+			}
+		case parser.InType:
+			walk(&exp.In.Exp)
+			for i := range exp.In.List {
+				walk(&exp.In.List[i])
+			}
+		case parser.NotType:
+			walk(exp.Not)
+		case parser.ExistsType:
+			query(exp.Exists.Query)
+		case parser.SubqueryType:
+			query(exp.Subquery)
+		}
+	}
+
+	if top != nil {
+		query(top)
+	}
+	for _, exp := range values {
+		walk(exp)
+	}
+
+	return queries
+}
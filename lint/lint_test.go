@@ -0,0 +1,183 @@
+package lint
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+// messages returns the messages of the issues rule finds in query.
+func messages(t *testing.T, query string, rule string) []string {
+	t.Helper()
+
+	issues, err := Lint(query, rule)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+
+	var got []string
+	for _, issue := range issues {
+		if issue.Rule != rule {
+			t.Errorf("%v found by %s", issue, issue.Rule)
+		}
+		got = append(got, issue.Message)
+	}
+	return got
+}
+
+func TestSelectStar(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"select a, b from t", nil},
+		{"select * from t", []string{"SELECT * from t, list the columns instead"}},
+		// Queries without a table have no columns to list
+		{"select *", nil},
+		{"select a from t where exists (select * from u)", nil},
+		{"select a from t where a in (select * from u)", []string{"SELECT * from u, list the columns instead"}},
+		{"explain select * from t", []string{"SELECT * from t, list the columns instead"}},
+		{"insert into t values ((select * from u))", []string{"SELECT * from u, list the columns instead"}},
+		{"create table t (a int)", nil},
+	}
+
+	for _, tt := range tests {
+		if got := messages(t, tt.query, "select-star"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestNonSargable(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"select a from t where a = 1 and b > $1", nil},
+		{"select a from t where lower(name) = 'x'", []string{
+			"lower(name) computes on column name, so it is evaluated for every row, compare name itself instead",
+		}},
+		{"select a from t where 2 = a + 1 or not (b::text <> 'x')", []string{
+			"a + 1 computes on column a, so it is evaluated for every row, compare a itself instead",
+			"b::text computes on column b, so it is evaluated for every row, compare b itself instead",
+		}},
+		{"select a from t where name like '%x'", []string{
+			"Pattern '%x' starts with a wildcard, so every value of name has to be matched",
+		}},
+		{"select a from t where name like 'x%'", nil},
+		// Comparing two columns, or with a subquery, can't use an index
+		// either way
+		{"select a from t where a + 1 = b", nil},
+		{"select a from t where a + 1 = (select max(b) from u)", nil},
+		{"select a from t where coalesce(a, b) = 1", nil},
+		{"select a from t where exists (select 1 from u where abs(b) = 1)", []string{
+			"abs(b) computes on column b, so it is evaluated for every row, compare b itself instead",
+		}},
+	}
+
+	for _, tt := range tests {
+		if got := messages(t, tt.query, "non-sargable"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	Register("test-drops", RuleFunc(func(stmt *parser.Statement, report Report) {
+		if stmt.Type == parser.DropTableType {
+			report(nil, "Drop")
+		}
+	}))
+
+	names := Rules()
+	for _, name := range []string{"non-sargable", "select-star", "test-drops"} {
+		found := false
+		for _, n := range names {
+			found = found || n == name
+		}
+		if !found {
+			t.Errorf("%s isn't among the rules %v", name, names)
+		}
+	}
+
+	// Issues are in the order of the statements, then of the rules
+	issues, err := Lint("drop table t; select * from t where abs(a) = 1", "test-drops", "select-star", "non-sargable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.Rule)
+		if (issue.Rule == "test-drops") != (issue.Statement == 0) {
+			t.Errorf("%v is about statement %d", issue, issue.Statement)
+		}
+	}
+	if want := []string{"test-drops", "select-star", "non-sargable"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got rules %v, want %v", got, want)
+	}
+
+	// Every rule is run when none are named
+	if issues, err := Lint("drop table t"); err != nil || len(issues) != 1 {
+		t.Errorf("got %v, %v", issues, err)
+	}
+
+	// Issues about an expression are where it starts, those about the whole
+	// statement have no location
+	issues, err = Lint("select *\nfrom t\nwhere lower(name) = 'x'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"[2,6]: lower(name) computes on column name, so it is evaluated for every row, compare name itself instead (non-sargable)",
+		"[0,0]: SELECT * from t, list the columns instead (select-star)",
+	}
+	if len(issues) != 2 || issues[0].String() != want[0] || issues[1].String() != want[1] {
+		t.Errorf("got %v, want %q", issues, want)
+	}
+
+	if _, err := Lint("select 1", "select-star", "no-such-rule"); !errors.Is(err, ErrNoSuchRule) {
+		t.Errorf("got %v, want %v", err, ErrNoSuchRule)
+	}
+	if _, err := Lint("select from where"); err == nil {
+		t.Error("Linted a query that doesn't parse")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Registered a rule twice")
+		}
+	}()
+	Register("select-star", RuleFunc(selectStar))
+}
+
+func TestQueries(t *testing.T) {
+	tests := []struct {
+		query string
+		from  []string
+	}{
+		{"select a from t where a in (select b from u where exists (select 1 from v))", []string{"t", "u", "v"}},
+		{"select (select max(b) from u), abs((select 1 from v)) from t", []string{"t", "u", "v"}},
+		{"explain select a from t", []string{"t"}},
+		{"declare c cursor for select a from t where a = (select 1 from u)", []string{"t", "u"}},
+		{"create materialized view m as select a from t", []string{"t"}},
+		{"insert into t values (1, (select 2 from u))", []string{"u"}},
+		{"drop table t", nil},
+	}
+
+	for _, tt := range tests {
+		ast, err := parser.Parse(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+
+		var from []string
+		for _, slct := range Queries(ast.Statements[0]) {
+			from = append(from, slct.From.Value)
+		}
+		if !reflect.DeepEqual(from, tt.from) {
+			t.Errorf("%s: got queries of %v, want %v", tt.query, from, tt.from)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+func init() {
+	Register("select-star", RuleFunc(selectStar))
+	Register("non-sargable", RuleFunc(nonSargable))
+}
+
+// selectStar reports queries selecting *, whose results change shape when
+// columns are added to their tables and which read columns nobody uses.
+// The queries of EXISTS tests are fine, their columns are never read.
+func selectStar(stmt *parser.Statement, report Report) {
+	queries := Queries(stmt)
+
+	exists := map[*parser.SelectStatement]bool{}
+	for _, slct := range queries {
+		find := func(exp *parser.Expression) {
+			if exp.Type == parser.ExistsType {
+				exists[exp.Exists.Query] = true
+			}
+		}
+		for _, item := range slct.Item {
+			if !item.Asterisk {
+				visit(item.Exp, find)
+			}
+		}
+		visit(slct.Where, find)
+		if slct.ConnectBy != nil {
+			visit(slct.ConnectBy.Start, find)
+		}
+		for _, order := range slct.OrderBy {
+			visit(order.Exp, find)
+		}
+	}
+
+	for _, slct := range queries {
+		if slct.From == nil || exists[slct] {
+			continue
+		}
+
+		for _, item := range slct.Item {
+			if item.Asterisk {
+				report(nil, "SELECT * from "+slct.From.Value+", list the columns instead")
+				break
+			}
+		}
+	}
+}
+
+// comparisons are the operators an index on a column can serve when the
+// column is compared with a constant.
+var comparisons = map[string]bool{
+	"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+}
+
+// nonSargable reports filters that wrap the column they compare in a
+// function, a cast or arithmetic, like lower(name) = 'x' or a + 1 = 2, and
+// patterns starting with a wildcard. Either has to be evaluated for every
+// row, no index on the column can narrow the rows down.
+func nonSargable(stmt *parser.Statement, report Report) {
+	for _, slct := range Queries(stmt) {
+		filter := func(exp *parser.Expression) {
+			if exp.Type != parser.BinaryType {
+				return
+			}
+
+			op := strings.ToLower(exp.Binary.Op.Value)
+			a, b := &exp.Binary.A, &exp.Binary.B
+			switch {
+			case op == "like" || op == "ilike":
+				if a.Type == parser.ColumnRefType && b.Type == parser.LiteralType &&
+					b.Literal.Type == parser.StringValue && strings.HasPrefix(b.Literal.String, "%") {
+					report(b, fmt.Sprintf("Pattern %s starts with a wildcard, so every value of %s has to be matched",
+						b.String(), a.Column.Value))
+				}
+			case comparisons[op]:
+				if constant(b) {
+					wrapped(a, report)
+				} else if constant(a) {
+					wrapped(b, report)
+				}
+			}
+		}
+
+		if slct.Where != nil {
+			conditions(slct.Where, filter)
+		}
+		if slct.ConnectBy != nil && slct.ConnectBy.Start != nil {
+			conditions(slct.ConnectBy.Start, filter)
+		}
+	}
+}
+
+// wrapped reports exp if it computes something from a single column rather
+// than being the column itself.
+func wrapped(exp *parser.Expression, report Report) {
+	if exp.Type == parser.ColumnRefType {
+		return
+	}
+
+	columns := map[string]bool{}
+	subquery := false
+	visit(exp, func(exp *parser.Expression) {
+		switch exp.Type {
+		case parser.ColumnRefType:
+			columns[exp.Column.Value] = true
+		case parser.ExistsType, parser.SubqueryType:
+			subquery = true
+		}
+	})
+	if len(columns) != 1 || subquery {
+		return
+	}
+
+	for column := range columns {
+		report(exp, fmt.Sprintf("%s computes on column %s, so it is evaluated for every row, compare %s itself instead",
+			exp.String(), column, column))
+	}
+}
+
+// constant reports whether exp is the same for every row, which it is when
+// it doesn't refer to any column or run a subquery.
+func constant(exp *parser.Expression) bool {
+	ok := true
+	visit(exp, func(exp *parser.Expression) {
+		switch exp.Type {
+		case parser.ColumnRefType, parser.ExistsType, parser.SubqueryType:
+			ok = false
+		}
+	})
+
+	return ok
+}
+
+// conditions calls fn with exp and each of the conditions it combines with
+// AND, OR and NOT.
+func conditions(exp *parser.Expression, fn func(exp *parser.Expression)) {
+	fn(exp)
+
+	switch exp.Type {
+	case parser.BinaryType:
+		switch strings.ToLower(exp.Binary.Op.Value) {
+		case "and", "or":
+			conditions(&exp.Binary.A, fn)
+			conditions(&exp.Binary.B, fn)
+		}
+	case parser.NotType:
+		conditions(exp.Not, fn)
+	}
+}
+
+// visit calls fn with exp and every expression nested in it, but not those
+// of its subqueries.
+func visit(exp *parser.Expression, fn func(exp *parser.Expression)) {
+	if exp == nil {
+		return
+	}
+
+	fn(exp)
+	switch exp.Type {
+	case parser.BinaryType:
+		visit(&exp.Binary.A, fn)
+		visit(&exp.Binary.B, fn)
+	case parser.CastType:
+		visit(&exp.Cast.Exp, fn)
+	case parser.IndexType:
+		visit(&exp.Index.Exp, fn)
+		visit(&exp.Index.Index, fn)
+	case parser.CallType:
+		for i := range exp.Call.Args {
+			visit(&exp.Call.Args[i], fn)
+		}
+	case parser.ArrayType:
+		for i := range exp.Array {
+			visit(&exp.Array[i], fn)
+		}
+	case parser.RowType:
+		for i := range exp.Row {
+			visit(&exp.Row[i], fn)
+		}
+	case parser.InType:
+		visit(&exp.In.Exp, fn)
+		for i := range exp.In.List {
+			visit(&exp.In.List[i], fn)
+		}
+	case parser.NotType:
+		visit(exp.Not, fn)
+	}
+}
@@ -0,0 +1,52 @@
+package sgsql
+
+import (
+	"context"
+	"time"
+
+	"github.com/nireo/sgsql/logging"
+)
+
+// SetLogger sends the events of the database to l instead of the default
+// logger of package logging. A nil l goes back to the default.
+//
+// Statements starting and finishing and transactions beginning and ending
+// are logged at debug level, failed statements as warnings, vacuums as
+// information and failures in the background, like syncing the statement
+// log, as errors. Statements running longer than the threshold set with
+// SetSlowQueryThreshold are also logged as warnings.
+func (db *DB) SetLogger(l logging.Logger) {
+	db.logger.Store(loggerBox{l})
+}
+
+// SetSlowQueryThreshold logs every statement running for at least d as a
+// warning with its duration, whether it succeeds or not. A d that isn't
+// positive logs none, which is the default.
+func (db *DB) SetSlowQueryThreshold(d time.Duration) {
+	db.slowQuery.Store(d)
+}
+
+// logSlow logs the statement text if it ran for at least the threshold set
+// with SetSlowQueryThreshold.
+func (db *DB) logSlow(ctx context.Context, text string, d time.Duration) {
+	if slow, _ := db.slowQuery.Load().(time.Duration); slow <= 0 || d < slow {
+		return
+	}
+
+	db.logEvent(ctx, logging.LevelWarn, "Slow statement", "statement", text, "duration", d)
+}
+
+// loggerBox lets a nil logger be stored in an atomic.Value.
+type loggerBox struct {
+	l logging.Logger
+}
+
+// logEvent logs an event of the database.
+func (db *DB) logEvent(ctx context.Context, level logging.Level, msg string, args ...interface{}) {
+	l := logging.Default()
+	if box, ok := db.logger.Load().(loggerBox); ok && box.l != nil {
+		l = box.l
+	}
+
+	l.Log(ctx, level, msg, args...)
+}
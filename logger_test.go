@@ -0,0 +1,113 @@
+package sgsql
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nireo/sgsql/logging"
+)
+
+type event struct {
+	level logging.Level
+	msg   string
+	args  []interface{}
+}
+
+// eventLogger keeps every event logged.
+type eventLogger struct {
+	mu     sync.Mutex
+	events []event
+}
+
+func (l *eventLogger) Log(ctx context.Context, level logging.Level, msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, event{level, msg, args})
+}
+
+// find returns the first event logged with msg.
+func (l *eventLogger) find(msg string) (event, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, e := range l.events {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+
+	return event{}, false
+}
+
+func TestLogEvents(t *testing.T) {
+	tests := []struct {
+		query  string
+		events []event
+	}{
+		{"select id from t", []event{
+			{logging.LevelDebug, "Statement started", []interface{}{"statement", "select id from t"}},
+			{level: logging.LevelDebug, msg: "Statement finished"},
+		}},
+		{"insert into t values (2)", []event{
+			{level: logging.LevelDebug, msg: "Transaction started"},
+			{logging.LevelDebug, "Transaction committed", []interface{}{"entries", 1}},
+		}},
+		{"select nope from t", []event{
+			{level: logging.LevelWarn, msg: "Statement failed"},
+		}},
+		{"vacuum", []event{
+			{level: logging.LevelInfo, msg: "Vacuumed the statement log"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			db, _ := openTest(t)
+			mustExec(t, db, "create table t (id int)", "insert into t values (1)")
+
+			l := &eventLogger{}
+			db.SetLogger(l)
+			db.Exec(tt.query)
+
+			for _, want := range tt.events {
+				got, ok := l.find(want.msg)
+				if !ok {
+					t.Errorf("nothing logged %q", want.msg)
+					continue
+				}
+				if got.level != want.level {
+					t.Errorf("%s: logged at %v, want %v", want.msg, got.level, want.level)
+				}
+				for i, arg := range want.args {
+					if i >= len(got.args) || got.args[i] != arg {
+						t.Errorf("%s: logged with %v, want %v", want.msg, got.args, want.args)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLoggerFallsBackToDefault(t *testing.T) {
+	defer logging.SetDefault(nil)
+
+	db, _ := openTest(t)
+	def, own := &eventLogger{}, &eventLogger{}
+	logging.SetDefault(def)
+
+	mustExec(t, db, "select 1")
+	if _, ok := def.find("Statement started"); !ok {
+		t.Error("the default logger got no events of a database without a logger")
+	}
+
+	db.SetLogger(own)
+	def.events = nil
+	mustExec(t, db, "select 1")
+	if _, ok := own.find("Statement started"); !ok || len(def.events) != 0 {
+		t.Errorf("the logger of the database got %d events and the default %d, want only the former",
+			len(own.events), len(def.events))
+	}
+}
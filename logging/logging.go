@@ -0,0 +1,151 @@
+// Package logging is how sgsql reports what it is doing: statements
+// starting and finishing, transactions, vacuums and errors happening in the
+// background. Events go to a Logger, whose Log method is that of log/slog's
+// Logger but for the type of the level, so a slog.Logger is adapted with
+//
+//	type slogLogger struct{ l *slog.Logger }
+//
+//	func (s slogLogger) Log(ctx context.Context, level logging.Level, msg string, args ...interface{}) {
+//		s.l.Log(ctx, slog.Level(level), msg, args...)
+//	}
+//
+// Nothing is logged unless a logger is set, with SetDefault or for a single
+// database with its SetLogger.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the importance of an event. The levels have the values of the
+// levels of log/slog.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+var ErrUnknownLevel = errors.New("Unknown log level")
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel parses the name of a level, in any case.
+func ParseLevel(s string) (Level, error) {
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownLevel, s)
+}
+
+// Logger receives events. Args are alternating keys and values describing
+// the event, keys being strings.
+type Logger interface {
+	Log(ctx context.Context, level Level, msg string, args ...interface{})
+}
+
+type discard struct{}
+
+func (discard) Log(ctx context.Context, level Level, msg string, args ...interface{}) {}
+
+// Discard drops every event.
+var Discard Logger = discard{}
+
+// loggerBox lets loggers of different types be stored in an atomic.Value.
+type loggerBox struct {
+	l Logger
+}
+
+var defaultLogger atomic.Value
+
+// SetDefault makes l receive the events of every database that has no
+// logger of its own, and of the parser. A nil l drops them again.
+func SetDefault(l Logger) {
+	if l == nil {
+		l = Discard
+	}
+
+	defaultLogger.Store(loggerBox{l})
+}
+
+// Default returns the logger set with SetDefault, Discard if there is none.
+func Default() Logger {
+	if box, ok := defaultLogger.Load().(loggerBox); ok {
+		return box.l
+	}
+
+	return Discard
+}
+
+type textLogger struct {
+	mu  sync.Mutex
+	w   io.Writer
+	min Level
+}
+
+// NewText returns a logger writing events of level min and above to w, one
+// per line:
+//
+//	2024-01-02T15:04:05.000Z INFO Vacuumed the statement log reclaimed_bytes=4096
+func NewText(w io.Writer, min Level) Logger {
+	return &textLogger{w: w, min: min}
+}
+
+func (t *textLogger) Log(ctx context.Context, level Level, msg string, args ...interface{}) {
+	if level < t.min {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+	b.WriteString(" " + level.String() + " " + msg)
+	for i := 0; i < len(args); i += 2 {
+		key, value := fmt.Sprint(args[i]), "(missing)"
+		if i+1 < len(args) {
+			value = formatValue(args[i+1])
+		}
+		b.WriteString(" " + key + "=" + value)
+	}
+	b.WriteString("\n")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, b.String())
+}
+
+// formatValue formats v for a line of text, quoting it when it would
+// otherwise be hard to tell where it ends.
+func formatValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
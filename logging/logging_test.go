@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		s     string
+		level Level
+		err   error
+	}{
+		{"debug", LevelDebug, nil},
+		{"INFO", LevelInfo, nil},
+		{"Warn", LevelWarn, nil},
+		{"error", LevelError, nil},
+		{"warning", 0, ErrUnknownLevel},
+		{"", 0, ErrUnknownLevel},
+	}
+
+	for _, tt := range tests {
+		level, err := ParseLevel(tt.s)
+		if !errors.Is(err, tt.err) || level != tt.level {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v, %v", tt.s, level, err, tt.level, tt.err)
+		}
+		if err == nil {
+			if again, _ := ParseLevel(level.String()); again != level {
+				t.Errorf("%v parsed back as %v", level, again)
+			}
+		}
+	}
+
+	if s := Level(2).String(); s != "LEVEL(2)" {
+		t.Errorf("Level(2) is %q, want LEVEL(2)", s)
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		level Level
+		msg   string
+		args  []interface{}
+		// line is what follows the time, empty when nothing is written
+		line string
+	}{
+		{LevelDebug, "Skipped", nil, ""},
+		{LevelInfo, "Vacuumed", []interface{}{"reclaimed_bytes", 4096}, "INFO Vacuumed reclaimed_bytes=4096"},
+		{LevelWarn, "Statement failed", []interface{}{"statement", "select 1", "error", "a=b"},
+			`WARN Statement failed statement="select 1" error="a=b"`},
+		{LevelError, "Odd", []interface{}{"key"}, "ERROR Odd key=(missing)"},
+		{LevelError, "Empty", []interface{}{"value", ""}, `ERROR Empty value=""`},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		NewText(&b, LevelInfo).Log(context.Background(), tt.level, tt.msg, tt.args...)
+
+		if tt.line == "" {
+			if b.Len() != 0 {
+				t.Errorf("%s: wrote %q below the minimum level", tt.msg, b.String())
+			}
+			continue
+		}
+
+		// The line starts with a time of a fixed width
+		line := b.String()
+		if !strings.HasSuffix(line, "\n") || len(line) < 25 || line[24:len(line)-1] != " "+tt.line {
+			t.Errorf("%s: wrote %q, want a time followed by %q", tt.msg, line, tt.line)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	defer SetDefault(nil)
+
+	if Default() != Discard {
+		t.Errorf("the default logger is %v, want Discard", Default())
+	}
+
+	var b bytes.Buffer
+	SetDefault(NewText(&b, LevelDebug))
+	Default().Log(context.Background(), LevelDebug, "Logged")
+	if !strings.Contains(b.String(), "DEBUG Logged") {
+		t.Errorf("the default logger wrote %q", b.String())
+	}
+
+	SetDefault(nil)
+	if Default() != Discard {
+		t.Errorf("the default logger is %v after unsetting it, want Discard", Default())
+	}
+}
@@ -0,0 +1,355 @@
+// Package logictest runs test files in the sqllogictest format against a
+// database. A file is a sequence of records separated by blank lines:
+//
+//	statement ok
+//	CREATE TABLE t1 (a int, b int)
+//
+//	query II rowsort
+//	SELECT a, b FROM t1
+//	----
+//	1
+//	2
+//
+// Statements are expected to succeed or fail, queries to return the values
+// listed after ----, one per line, or "N values hashing to H" with H the
+// MD5 of the values, each followed by a newline. The letters after query
+// give the type of each column: I for integers, R for floats and T for
+// text. Results are compared in the order they are returned, or sorted by
+// row with rowsort or by value with valuesort.
+//
+// Records preceded by "skipif sgsql" are skipped, as are those preceded by
+// "onlyif" naming another engine. "halt" ends the file.
+package logictest
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/types"
+)
+
+// Engine is the name records are skipped or run for with skipif and
+// onlyif.
+const Engine = "sgsql"
+
+// Failure is a record whose outcome wasn't the expected one. Line is where
+// the record starts in its file.
+type Failure struct {
+	Line int
+	SQL  string
+	Msg  string
+}
+
+func (f Failure) String() string {
+	return fmt.Sprintf("line %d: %s\n%s", f.Line, f.Msg, f.SQL)
+}
+
+// Result is the outcome of running a file.
+type Result struct {
+	Passed   int
+	Skipped  int
+	Failures []Failure
+}
+
+// record is a statement or query of a file.
+type record struct {
+	line int
+	// kind is "statement" or "query", args the words following it
+	kind string
+	args []string
+	sql  string
+	// expected holds the lines after ----
+	expected []string
+}
+
+var hashed = regexp.MustCompile(`^(\d+) values hashing to ([0-9a-f]{32})$`)
+
+// Run runs the records of src against conn. It returns an error only when
+// src can't be read or is malformed, records failing are reported in the
+// result.
+func Run(conn *sgsql.Conn, src io.Reader) (*Result, error) {
+	result := &Result{}
+	labels := map[string]string{}
+
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(nil, 1<<24)
+	line := 0
+	next := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		line++
+		return strings.TrimRight(scanner.Text(), "\r"), true
+	}
+
+	skip := false
+	for {
+		text, ok := next()
+		if !ok {
+			break
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "halt":
+			return result, scanner.Err()
+		case "hash-threshold":
+			continue
+		case "skipif", "onlyif":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: %s needs an engine name", line, fields[0])
+			}
+			skip = skip || (fields[0] == "skipif") == (fields[1] == Engine)
+			continue
+		case "statement", "query":
+		default:
+			return nil, fmt.Errorf("line %d: unknown record %q", line, fields[0])
+		}
+
+		rec := record{line: line, kind: fields[0], args: fields[1:]}
+		var sql []string
+		inResults := false
+		for {
+			text, ok := next()
+			if !ok || strings.TrimSpace(text) == "" {
+				break
+			}
+
+			if text == "----" {
+				inResults = true
+				continue
+			}
+
+			if inResults {
+				rec.expected = append(rec.expected, text)
+			} else {
+				sql = append(sql, text)
+			}
+		}
+		rec.sql = strings.Join(sql, "\n")
+
+		if skip {
+			skip = false
+			result.Skipped++
+			continue
+		}
+
+		if msg := run(conn, &rec, labels); msg != "" {
+			result.Failures = append(result.Failures, Failure{Line: rec.line, SQL: rec.sql, Msg: msg})
+		} else {
+			result.Passed++
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+// run runs rec, returning why it failed or "" if it didn't.
+func run(conn *sgsql.Conn, rec *record, labels map[string]string) string {
+	if rec.kind == "statement" {
+		if len(rec.args) == 0 {
+			return "statement needs ok or error"
+		}
+
+		err := conn.Exec(rec.sql)
+		switch {
+		case rec.args[0] == "ok" && err != nil:
+			return "Statement failed: " + err.Error()
+		case rec.args[0] == "error" && err == nil:
+			return "Statement succeeded but was expected to fail"
+		case rec.args[0] != "ok" && rec.args[0] != "error":
+			return fmt.Sprintf("Unknown statement outcome %q", rec.args[0])
+		}
+
+		return ""
+	}
+
+	if len(rec.args) == 0 {
+		return "query needs the types of its columns"
+	}
+	columnTypes := rec.args[0]
+
+	results, err := conn.Query(rec.sql)
+	if err != nil {
+		// query error is how some files expect a query to fail
+		if columnTypes == "error" {
+			return ""
+		}
+		return "Query failed: " + err.Error()
+	}
+	if columnTypes == "error" {
+		return "Query succeeded but was expected to fail"
+	}
+
+	if len(results.Columns) != len(columnTypes) {
+		return fmt.Sprintf("Expected %d columns, got %d", len(columnTypes), len(results.Columns))
+	}
+
+	rows := make([][]string, len(results.Rows))
+	for i, row := range results.Rows {
+		rows[i] = make([]string, len(row))
+		for j, v := range row {
+			rows[i][j] = formatValue(v, columnTypes[j])
+		}
+	}
+
+	sortMode := "nosort"
+	if len(rec.args) > 1 {
+		sortMode = rec.args[1]
+	}
+
+	var values []string
+	switch sortMode {
+	case "nosort":
+	case "rowsort":
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k := range rows[i] {
+				if rows[i][k] != rows[j][k] {
+					return rows[i][k] < rows[j][k]
+				}
+			}
+			return false
+		})
+	case "valuesort":
+	default:
+		return fmt.Sprintf("Unknown sort mode %q", sortMode)
+	}
+
+	for _, row := range rows {
+		values = append(values, row...)
+	}
+	if sortMode == "valuesort" {
+		sort.Strings(values)
+	}
+
+	h := md5.New()
+	for _, v := range values {
+		io.WriteString(h, v+"\n")
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	// Queries with the same label have to return the same values
+	if len(rec.args) > 2 {
+		label := rec.args[2]
+		if previous, ok := labels[label]; ok && previous != hash {
+			return fmt.Sprintf("Results differ from the earlier query labeled %s", label)
+		}
+		labels[label] = hash
+	}
+
+	if len(rec.expected) == 1 {
+		if m := hashed.FindStringSubmatch(rec.expected[0]); m != nil {
+			if m[1] != strconv.Itoa(len(values)) || m[2] != hash {
+				return fmt.Sprintf("Expected %s, got %d values hashing to %s", rec.expected[0], len(values), hash)
+			}
+			return ""
+		}
+	}
+
+	// Results are listed one value per line, or by some tools one row per
+	// line
+	got := values
+	if len(rec.expected) != len(values) {
+		got = make([]string, len(rows))
+		for i, row := range rows {
+			got[i] = strings.Join(row, " ")
+		}
+	}
+
+	if len(got) != len(rec.expected) {
+		return fmt.Sprintf("Expected %d values, got %d:\n%s", len(rec.expected), len(values), strings.Join(values, "\n"))
+	}
+	for i := range got {
+		if strings.Join(strings.Fields(got[i]), " ") != strings.Join(strings.Fields(rec.expected[i]), " ") {
+			return fmt.Sprintf("Expected %q, got %q", rec.expected[i], got[i])
+		}
+	}
+
+	return ""
+}
+
+// formatValue formats v the way the sqllogictest reference does for a
+// column of type typ.
+func formatValue(v interface{}, typ byte) string {
+	if v == nil {
+		return "NULL"
+	}
+
+	switch typ {
+	case 'I':
+		switch v := v.(type) {
+		case int64:
+			return strconv.FormatInt(v, 10)
+		case float64:
+			return strconv.FormatInt(int64(v), 10)
+		case bool:
+			if v {
+				return "1"
+			}
+			return "0"
+		case string:
+			// Text starting with a number is that number, like in SQLite
+			n, _ := strconv.ParseInt(leadingInteger(v), 10, 64)
+			return strconv.FormatInt(n, 10)
+		}
+	case 'R':
+		switch v := v.(type) {
+		case int64:
+			return fmt.Sprintf("%.3f", float64(v))
+		case float64:
+			return fmt.Sprintf("%.3f", v)
+		case string:
+			f, _ := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return fmt.Sprintf("%.3f", f)
+		}
+	}
+
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case time.Time:
+		s = types.FormatTimestamp(v)
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" {
+		return "(empty)"
+	}
+
+	// Control characters would break the line based format
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '@'
+		}
+		return r
+	}, s)
+}
+
+// leadingInteger returns the optionally signed digits s starts with.
+func leadingInteger(s string) string {
+	s = strings.TrimSpace(s)
+	end := 0
+	if end < len(s) && (s[end] == '-' || s[end] == '+') {
+		end++
+	}
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+
+	return s[:end]
+}
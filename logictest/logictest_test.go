@@ -0,0 +1,193 @@
+package logictest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql"
+)
+
+// runString runs src against a new in-memory database.
+func runString(t *testing.T, src string) (*Result, error) {
+	t.Helper()
+
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	conn := db.Conn()
+	t.Cleanup(func() { conn.Close() })
+
+	return Run(conn, strings.NewReader(src))
+}
+
+func TestRun(t *testing.T) {
+	src := `# comments and hash-threshold are ignored
+hash-threshold 8
+
+statement ok
+CREATE TABLE t1 (a int, b text, c float)
+
+statement ok
+INSERT INTO t1 VALUES (3, 'x', 1.5)
+
+statement ok
+INSERT INTO t1 VALUES (1, '', null)
+
+statement ok
+INSERT INTO t1 VALUES (2, 'y z', 2)
+
+statement error
+INSERT INTO missing VALUES (1)
+
+query I nosort
+SELECT a FROM t1 WHERE a = 3
+----
+3
+
+query ITR rowsort
+SELECT a, b, c FROM t1
+----
+1
+(empty)
+NULL
+2
+y z
+2.000
+3
+x
+1.500
+
+query IT rowsort
+SELECT a, b
+  FROM t1
+----
+1 (empty)
+2 y z
+3 x
+
+query I valuesort label-a
+SELECT a FROM t1
+----
+3 values hashing to c0710d6b4f15dfa88f600b0e6b624077
+
+query I valuesort label-a
+SELECT a + 0 FROM t1
+----
+1
+2
+3
+
+query I nosort
+SELECT 1 FROM missing
+----
+
+query error
+SELECT 1 FROM missing
+
+skipif sgsql
+statement ok
+this isn't sql
+
+onlyif sqlite
+statement ok
+neither is this
+
+onlyif sgsql
+query T nosort
+SELECT 'run'
+----
+run
+
+halt
+
+statement ok
+not reached
+`
+
+	result, err := runString(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Passed != 12 || result.Skipped != 2 || len(result.Failures) != 1 {
+		t.Fatalf("got %d passed, %d skipped and failures %v", result.Passed, result.Skipped, result.Failures)
+	}
+	if f := result.Failures[0]; f.Line != 57 || f.SQL != "SELECT 1 FROM missing" || !strings.HasPrefix(f.Msg, "Query failed") {
+		t.Errorf("got failure %v", f)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	tests := []struct {
+		src string
+		msg string
+	}{
+		{"statement ok\nSELECT 1 FROM missing", "Statement failed"},
+		{"statement error\nSELECT 1", "Statement succeeded but was expected to fail"},
+		{"statement maybe\nSELECT 1", "Unknown statement outcome"},
+		{"statement\nSELECT 1", "statement needs ok or error"},
+		{"query\nSELECT 1", "query needs the types of its columns"},
+		{"query error\nSELECT 1", "Query succeeded but was expected to fail"},
+		{"query II\nSELECT 1\n----\n1", "Expected 2 columns, got 1"},
+		{"query I shuffle\nSELECT 1\n----\n1", "Unknown sort mode"},
+		{"query I\nSELECT 1\n----\n2", `Expected "2", got "1"`},
+		{"query I\nSELECT 1\n----\n1\n2", "Expected 2 values, got 1"},
+		{"query I\nSELECT 1\n----\n1 values hashing to 00000000000000000000000000000000", "Expected 1 values hashing to"},
+		{"query I nosort x\nSELECT 1\n----\n1\n\nquery I nosort x\nSELECT 2\n----\n2", "Results differ from the earlier query labeled x"},
+	}
+
+	for _, tt := range tests {
+		result, err := runString(t, tt.src)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		if len(result.Failures) != 1 || !strings.Contains(result.Failures[0].Msg, tt.msg) {
+			t.Errorf("%q: got failures %v, want %q", tt.src, result.Failures, tt.msg)
+		}
+	}
+}
+
+func TestRunMalformed(t *testing.T) {
+	for _, src := range []string{"select 1", "statement ok\nSELECT 1\n\nskipif\nstatement ok\nSELECT 1"} {
+		if result, err := runString(t, src); err == nil {
+			t.Errorf("%q: got %+v, want an error", src, result)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		typ  byte
+		want string
+	}{
+		{nil, 'I', "NULL"},
+		{int64(-7), 'I', "-7"},
+		{2.9, 'I', "2"},
+		{true, 'I', "1"},
+		{" 12abc", 'I', "12"},
+		{"abc", 'I', "0"},
+		{int64(2), 'R', "2.000"},
+		{1.23456, 'R', "1.235"},
+		{"2.5", 'R', "2.500"},
+		{"", 'T', "(empty)"},
+		{"a\tb\n", 'T', "a@b@"},
+		{int64(5), 'T', "5"},
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), 'T', "2024-03-01 12:00:00"},
+	}
+
+	for _, tt := range tests {
+		if got := formatValue(tt.v, tt.typ); got != tt.want {
+			t.Errorf("formatValue(%#v, %c) = %q, want %q", tt.v, tt.typ, got, tt.want)
+		}
+	}
+
+	if got := []string{leadingInteger("+12x"), leadingInteger("-"), leadingInteger(" 3 ")}; !reflect.DeepEqual(got, []string{"+12", "-", "3"}) {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,114 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nireo/sgsql/logging"
+)
+
+// ErrSyncFailed is returned by every commit once syncing the statement log
+// has failed. The changes written since the last successful sync may or
+// may not be on disk, so nothing more is committed until the database is
+// vacuumed, which rewrites the log from the state in memory.
+var ErrSyncFailed = errors.New("Statement log could not be synced to disk")
+
+// asyncSyncInterval is how often the statement log is synced while commits
+// don't wait for it, which bounds the commits a crash can lose.
+const asyncSyncInterval = 200 * time.Millisecond
+
+// SetSynchronous controls whether commits wait for the statement log to be
+// synced to disk, which is the default. Asynchronous commits return once
+// their changes are written, and the log is synced in the background every
+// 200 milliseconds, so a crash of the machine loses at most the commits
+// since then. A crash of only the process loses nothing.
+func (db *DB) SetSynchronous(on bool) {
+	db.syncMu.Lock()
+	defer db.syncMu.Unlock()
+
+	db.async = !on
+	if on && db.stopFlush != nil {
+		close(db.stopFlush)
+		db.stopFlush = nil
+	}
+
+	if !on && db.stopFlush == nil && db.syncFile != nil {
+		db.stopFlush = make(chan struct{})
+		go db.flush(db.stopFlush)
+	}
+}
+
+// waitSync returns once the first written appends to the statement log are
+// on disk, right away if commits are asynchronous and force isn't set.
+//
+// The first committer to wait syncs the log, and the ones arriving while
+// it does wait for it to finish and then sync everything written by then
+// together. That way concurrent commits share syncs.
+func (db *DB) waitSync(written int64, force bool) error {
+	db.syncMu.Lock()
+	defer db.syncMu.Unlock()
+
+	if db.async && !force {
+		return db.syncErr
+	}
+
+	for db.synced < written && db.syncErr == nil {
+		if db.syncing {
+			db.syncDone.Wait()
+			continue
+		}
+
+		db.sync()
+	}
+
+	return db.syncErr
+}
+
+// sync syncs everything written to the statement log so far. It must be
+// called with db.syncMu held, which is released while syncing.
+func (db *DB) sync() {
+	db.syncing = true
+	written, f := db.written, db.syncFile
+	db.syncMu.Unlock()
+
+	err := f.Sync()
+
+	db.syncMu.Lock()
+	db.syncing = false
+	defer db.syncDone.Broadcast()
+
+	// Vacuuming closes the log after syncing a new one holding everything
+	// written to it, so a closed log needs no syncing
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		db.syncErr = fmt.Errorf("%w: %v", ErrSyncFailed, err)
+		db.logEvent(context.Background(), logging.LevelError, "Syncing the statement log failed", "error", err)
+		return
+	}
+
+	if written > db.synced {
+		db.synced = written
+	}
+}
+
+// flush syncs the statement log in the background until stop is closed.
+func (db *DB) flush(stop chan struct{}) {
+	ticker := time.NewTicker(asyncSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		db.syncMu.Lock()
+		if !db.syncing && db.synced < db.written && db.syncErr == nil {
+			db.sync()
+		}
+		db.syncMu.Unlock()
+	}
+}
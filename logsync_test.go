@@ -0,0 +1,127 @@
+package sgsql
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// insertConcurrently inserts n rows into t from as many sessions at once.
+func insertConcurrently(t *testing.T, db *DB, n int) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- db.Exec("insert into t values ($1)", int64(i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	tests := []struct {
+		name        string
+		synchronous bool
+	}{
+		{"synchronous", true},
+		{"asynchronous", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			db.SetSynchronous(tt.synchronous)
+			mustExec(t, db, "create table t (id int)")
+
+			insertConcurrently(t, db, 50)
+
+			// Synchronous commits return once synced, asynchronous ones are
+			// synced in the background soon after
+			deadline := time.Now().Add(10 * asyncSyncInterval)
+			for {
+				db.syncMu.Lock()
+				synced := db.synced >= db.written
+				db.syncMu.Unlock()
+
+				if synced {
+					break
+				}
+				if tt.synchronous || time.Now().After(deadline) {
+					t.Fatal("commits are not synced")
+				}
+				time.Sleep(asyncSyncInterval / 4)
+			}
+
+			db = reopen(t, db, path)
+			if got := queryRows(t, db, "select count(*) from t"); got[0][0] != int64(50) {
+				t.Errorf("t holds %v rows after reopening, want 50", got[0][0])
+			}
+		})
+	}
+}
+
+func TestSyncFailureStopsCommits(t *testing.T) {
+	db, path := openTest(t)
+	mustExec(t, db, "create table t (id int)", "insert into t values (1)")
+
+	db.syncMu.Lock()
+	db.syncErr = fmt.Errorf("%w: disk on fire", ErrSyncFailed)
+	db.syncMu.Unlock()
+
+	if err := db.Exec("insert into t values (2)"); !errors.Is(err, ErrSyncFailed) {
+		t.Fatalf("commit after a failed sync: got %v, want %v", err, ErrSyncFailed)
+	}
+
+	// The refused commit was rolled back, and vacuuming rewrites the log
+	// from memory and lets commits through again
+	mustExec(t, db, "vacuum", "insert into t values (3)")
+
+	want := [][]interface{}{{int64(1)}, {int64(3)}}
+	db = reopen(t, db, path)
+	if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, want) {
+		t.Errorf("t holds %v after reopening, want %v", got, want)
+	}
+}
+
+func BenchmarkCommit(b *testing.B) {
+	for _, synchronous := range []bool{true, false} {
+		b.Run(fmt.Sprintf("synchronous=%v", synchronous), func(b *testing.B) {
+			db, err := Open(filepath.Join(b.TempDir(), "bench.db"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			db.SetSynchronous(synchronous)
+
+			if err := db.Exec("create table t (id int)"); err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := db.Exec("insert into t values (1)"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
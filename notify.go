@@ -0,0 +1,140 @@
+package sgsql
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+// notifyQueue is how many notifications a session holds until it receives
+// them. Notifications for a session whose queue is full are dropped rather
+// than holding up the transaction sending them.
+const notifyQueue = 1024
+
+var ErrListenNoSession = errors.New("LISTEN needs a session")
+
+// Notification is a NOTIFY received by a session listening on its channel.
+type Notification struct {
+	Channel string
+	Payload string
+	// Session is the id of the session that sent it
+	Session int64
+}
+
+// listenerList holds the sessions listening on each channel of a database.
+type listenerList struct {
+	mu       sync.Mutex
+	channels map[string]map[*Conn]bool
+}
+
+// Notifications returns the channel the notifications sent on the channels
+// the session listens on arrive on, see LISTEN. A notification sent in a
+// transaction is delivered once the transaction commits, and not at all if
+// it rolls back. The channel is never closed.
+func (c *Conn) Notifications() <-chan Notification {
+	c.db.listeners.mu.Lock()
+	defer c.db.listeners.mu.Unlock()
+
+	return c.queue()
+}
+
+// queue returns the notification queue of the session, making it on first
+// use. It must be called with the listeners of the database locked.
+func (c *Conn) queue() chan Notification {
+	if c.notifications == nil {
+		c.notifications = make(chan Notification, notifyQueue)
+	}
+
+	return c.notifications
+}
+
+// listen runs LISTEN and UNLISTEN. Unlike NOTIFY they take effect right
+// away, even in a transaction.
+func (c *Conn) listen(stmt *parser.ListenStatement) {
+	l := &c.db.listeners
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if stmt.Channel == nil {
+		c.unlistenAll()
+		return
+	}
+
+	channel := stmt.Channel.Value
+	if stmt.Unlisten {
+		delete(l.channels[channel], c)
+		if len(l.channels[channel]) == 0 {
+			delete(l.channels, channel)
+		}
+		delete(c.listening, channel)
+		return
+	}
+
+	if l.channels == nil {
+		l.channels = map[string]map[*Conn]bool{}
+	}
+	if l.channels[channel] == nil {
+		l.channels[channel] = map[*Conn]bool{}
+	}
+	l.channels[channel][c] = true
+
+	if c.listening == nil {
+		c.listening = map[string]bool{}
+	}
+	c.listening[channel] = true
+	c.queue()
+}
+
+// unlistenAll stops the session listening on any channel. It must be called
+// with the listeners of the database locked.
+func (c *Conn) unlistenAll() {
+	l := &c.db.listeners
+	for channel := range c.listening {
+		delete(l.channels[channel], c)
+		if len(l.channels[channel]) == 0 {
+			delete(l.channels, channel)
+		}
+	}
+
+	c.listening = nil
+}
+
+// notify runs NOTIFY, holding the notification until tx commits. Sending
+// the same notification twice in a transaction delivers it once.
+func (tx *Tx) notify(stmt *parser.NotifyStatement) {
+	n := Notification{Channel: stmt.Channel.Value}
+	if stmt.Payload != nil {
+		n.Payload = stmt.Payload.Value
+	}
+	if tx.conn != nil {
+		n.Session = tx.conn.ID()
+	}
+
+	for _, pending := range tx.notifications {
+		if pending == n {
+			return
+		}
+	}
+	tx.notifications = append(tx.notifications, n)
+}
+
+// deliver queues notifications for the sessions listening on their
+// channels.
+func (db *DB) deliver(notifications []Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+
+	db.listeners.mu.Lock()
+	defer db.listeners.mu.Unlock()
+
+	for _, n := range notifications {
+		for c := range db.listeners.channels[n.Channel] {
+			select {
+			case c.notifications <- n:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// String formats the expression back into SQL. Nested binary operations are
+// parenthesized and identifiers quoted when they need to be, so the result
+// parses to the same expression.
+func (e *Expression) String() string {
+	var b strings.Builder
+	e.format(&b)
+	return b.String()
+}
+
+func (e *Expression) format(b *strings.Builder) {
+	switch e.Type {
+	case LiteralType:
+		formatValue(b, e.Literal)
+	case ColumnRefType:
+		b.WriteString(FormatIdentifier(e.Column.Value))
+	case ParamType:
+		b.WriteString("$" + strconv.FormatUint(uint64(e.Param), 10))
+	case BinaryType:
+		formatOperand(b, &e.Binary.A)
+		b.WriteString(" " + e.Binary.Op.Value + " ")
+		formatOperand(b, &e.Binary.B)
+	case CastType:
+		formatOperand(b, &e.Cast.Exp)
+		b.WriteString("::" + e.Cast.Type.Value)
+	case CallType:
+		b.WriteString(FormatIdentifier(e.Call.Name.Value) + "(")
+		if e.Call.Star {
+			b.WriteString("*")
+		}
+		formatList(b, e.Call.Args)
+		b.WriteString(")")
+	case ArrayType:
+		b.WriteString("ARRAY[")
+		formatList(b, e.Array)
+		b.WriteString("]")
+	case IndexType:
+		formatOperand(b, &e.Index.Exp)
+		b.WriteString("[")
+		e.Index.Index.format(b)
+		b.WriteString("]")
+	case RowType:
+		b.WriteString("(")
+		formatList(b, e.Row)
+		b.WriteString(")")
+	case InType:
+		formatOperand(b, &e.In.Exp)
+		if e.In.Not {
+			b.WriteString(" NOT")
+		}
+		if e.In.Subquery {
+			b.WriteString(" IN ")
+			e.In.List[0].format(b)
+			break
+		}
+		b.WriteString(" IN (")
+		formatList(b, e.In.List)
+		b.WriteString(")")
+	case ExistsType:
+		if e.Exists.Not {
+			b.WriteString("NOT ")
+		}
+		b.WriteString("EXISTS (" + e.Exists.Query.String() + ")")
+	case SubqueryType:
+		b.WriteString("(" + e.Subquery.String() + ")")
+	case NotType:
+		b.WriteString("NOT ")
+		formatOperand(b, e.Not)
+	case DefaultType:
+		b.WriteString("DEFAULT")
+	}
+}
+
+// formatOperand formats an operand of an operator, parenthesizing it if it
+// is an operation itself.
+func formatOperand(b *strings.Builder, e *Expression) {
+	if e.Type == BinaryType || e.Type == InType || e.Type == NotType {
+		b.WriteString("(")
+		e.format(b)
+		b.WriteString(")")
+		return
+	}
+
+	e.format(b)
+}
+
+func formatList(b *strings.Builder, exps []Expression) {
+	for i := range exps {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		exps[i].format(b)
+	}
+}
+
+func formatValue(b *strings.Builder, v *Value) {
+	switch v.Type {
+	case Int64Value:
+		b.WriteString(strconv.FormatInt(v.Int64, 10))
+	case Float64Value:
+		s := strconv.FormatFloat(v.Float64, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		b.WriteString(s)
+	case StringValue:
+		b.WriteString("'" + strings.ReplaceAll(v.String, "'", "''") + "'")
+	case BoolValue:
+		b.WriteString(strconv.FormatBool(v.Bool))
+	default:
+		b.WriteString("NULL")
+	}
+}
+
+// String formats the query back into SQL.
+func (s *SelectStatement) String() string {
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	if len(s.Hints) > 0 {
+		b.WriteString("/*+")
+		for _, hint := range s.Hints {
+			b.WriteString(" " + strings.ToUpper(hint.Name.Value))
+			if len(hint.Args) > 0 {
+				args := make([]string, len(hint.Args))
+				for i, arg := range hint.Args {
+					args[i] = FormatIdentifier(arg.Value)
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+		}
+		b.WriteString(" */ ")
+	}
+
+	for i, item := range s.Item {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		if item.Asterisk {
+			b.WriteString("*")
+			continue
+		}
+
+		item.Exp.format(&b)
+		if item.As != nil {
+			b.WriteString(" AS " + FormatIdentifier(item.As.Value))
+		}
+	}
+
+	if s.From != nil {
+		b.WriteString(" FROM " + FormatIdentifier(s.From.Value))
+		if s.AsOf != nil {
+			b.WriteString(" AS OF TIMESTAMP ")
+			s.AsOf.format(&b)
+		}
+	} else if s.Function != nil {
+		b.WriteString(" FROM ")
+		s.Function.format(&b)
+	}
+
+	if s.Where != nil {
+		b.WriteString(" WHERE ")
+		s.Where.format(&b)
+	}
+
+	if c := s.ConnectBy; c != nil {
+		if c.Start != nil {
+			b.WriteString(" START WITH ")
+			c.Start.format(&b)
+		}
+		b.WriteString(" CONNECT BY ")
+		if c.NoCycle {
+			b.WriteString("NOCYCLE ")
+		}
+		b.WriteString("PRIOR " + FormatIdentifier(c.Prior.Value) + " = " + FormatIdentifier(c.Child.Value))
+	}
+
+	for i, item := range s.OrderBy {
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+
+		item.Exp.format(&b)
+		if item.Desc {
+			b.WriteString(" DESC")
+		}
+	}
+
+	if s.Limit != nil {
+		b.WriteString(" LIMIT ")
+		s.Limit.format(&b)
+	}
+	if s.Offset != nil {
+		b.WriteString(" OFFSET ")
+		s.Offset.format(&b)
+	}
+
+	return b.String()
+}
+
+// String formats the statement back into SQL.
+func (s *FlashbackStatement) String() string {
+	var b strings.Builder
+	b.WriteString("FLASHBACK TABLE " + FormatIdentifier(s.Table.Value) + " TO ")
+	if s.Rows != nil {
+		b.WriteString("ROWS " + strconv.FormatInt(*s.Rows, 10))
+	} else {
+		b.WriteString("TIMESTAMP ")
+		s.Timestamp.format(&b)
+	}
+
+	return b.String()
+}
+
+// String formats the statement back into SQL.
+func (s *InsertStatement) String() string {
+	var b strings.Builder
+	b.WriteString("INSERT INTO " + FormatIdentifier(s.Table.Value))
+	if s.Overriding != nil {
+		b.WriteString(" OVERRIDING " + strings.ToUpper(s.Overriding.Value) + " VALUE")
+	}
+	b.WriteString(" VALUES (")
+	if s.Values != nil {
+		for i, value := range *s.Values {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			value.format(&b)
+		}
+	}
+	b.WriteString(")")
+
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package parser
+
+import "testing"
+
+func TestParseQuotedIdentifiers(t *testing.T) {
+	tests := []struct {
+		src    string
+		column string
+		table  string
+		ok     bool
+	}{
+		{`select Name from Users`, "name", "users", true},
+		{`select "Name" from "Users"`, "Name", "Users", true},
+		{`select "select" from "from"`, "select", "from", true},
+		{`select "first name" from "my table"`, "first name", "my table", true},
+		{`select "say ""hi""" from t`, `say "hi"`, "t", true},
+		{`select "" from t`, "", "", false},
+	}
+
+	for _, tt := range tests {
+		ast, err := Parse(tt.src)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok %v", tt.src, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		slct := ast.Statements[0].SelectStatement
+		column := slct.Item[0].Exp.Column
+		if column == nil || column.Value != tt.column {
+			t.Errorf("%s: parsed column %+v, want identifier %q", tt.src, column, tt.column)
+		}
+		if slct.From.Type != IdentifierType || slct.From.Value != tt.table {
+			t.Errorf("%s: parsed table %+v, want identifier %q", tt.src, slct.From, tt.table)
+		}
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	for _, name := range []string{"users", "Users", "select", "my table", `a"b`, "x;drop table t"} {
+		src := "select a from " + QuoteIdentifier(name)
+		ast, err := Parse(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if got := ast.Statements[0].SelectStatement.From.Value; got != name {
+			t.Errorf("%s: read back %q, want %q", src, got, name)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package parser
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Normalize rewrites the queries of src so that queries differing only in
+// their constants read the same. The number and string literals of SELECT,
+// INSERT and DECLARE CURSOR statements are replaced with placeholders
+// numbered after any src already has, and every statement is laid out the
+// same way, without comments, so
+//
+//	SELECT a FROM t   WHERE b = 'x' AND c > -1.5 -- recent
+//
+// comes out as select a from t where b = $1 and c > $2. The literals of
+// other statements are kept, since they define the schema rather than pick
+// rows. Normalize returns the literals it replaced in the order of their
+// placeholders and fails for src that doesn't parse.
+func Normalize(src string) (string, []interface{}, error) {
+	n, err := normalize(src)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	prev := -1
+	for i := range n.tokens {
+		if n.skipped[i] {
+			continue
+		}
+
+		if prev >= 0 && spaced(&n.tokens[prev], &n.tokens[i]) {
+			b.WriteByte(' ')
+		}
+		if placeholder, ok := n.placeholders[i]; ok {
+			b.WriteString(placeholder)
+		} else {
+			b.WriteString(formatToken(&n.tokens[i]))
+		}
+		prev = i
+	}
+
+	return b.String(), n.values, nil
+}
+
+// Fingerprint identifies the shape of the queries of src: src normalized
+// like Normalize does, with every placeholder the same and lists of them
+// like IN ($1, $2, $3) counting as one. Queries differing only in their
+// constants, in how they are laid out or in whether their constants are
+// bound as parameters have the same fingerprint, which makes it a key to
+// aggregate the metrics of queries by or to cache their plans under.
+func Fingerprint(src string) (uint64, error) {
+	n, err := normalize(src)
+	if err != nil {
+		return 0, err
+	}
+
+	// after returns the index of the token following the one at i
+	after := func(i int) int {
+		for i++; i < len(n.tokens) && n.skipped[i]; i++ {
+		}
+		return i
+	}
+	cast := tokenFromPunct(castPunct)
+	comma := tokenFromPunct(commaPunct)
+
+	// Typed literals are replaced with a placeholder cast to their type,
+	// which is hashed like the tokens it is written as
+	word := func(i int) string {
+		if p, ok := n.placeholders[i]; ok {
+			if at := strings.Index(p, "::"); at >= 0 {
+				return "?\x00::\x00" + p[at+2:]
+			}
+			return "?"
+		}
+		if n.tokens[i].Type == ParameterType {
+			return "?"
+		}
+		return formatToken(&n.tokens[i])
+	}
+	// listed reports whether the token at i is a placeholder that can be
+	// part of a list counting as one
+	listed := func(i int) bool {
+		p, ok := n.placeholders[i]
+		if !(ok && !strings.Contains(p, "::")) && n.tokens[i].Type != ParameterType {
+			return false
+		}
+		next := after(i)
+		return next >= len(n.tokens) || !n.tokens[next].eq(&cast)
+	}
+
+	h := fnv.New64a()
+	for i := 0; i < len(n.tokens); i = after(i) {
+		if listed(i) {
+			for j := after(i); j+1 < len(n.tokens) && n.tokens[j].eq(&comma) && listed(j+1); j = after(i) {
+				i = j + 1
+			}
+		}
+
+		h.Write([]byte(word(i)))
+		h.Write([]byte{0})
+	}
+
+	return h.Sum64(), nil
+}
+
+// normalized is src with its literals found by normalize.
+type normalized struct {
+	tokens []Token
+	// placeholders replace the literals starting at the tokens with these
+	// indexes, the other tokens of the literals are skipped
+	placeholders map[int]string
+	skipped      map[int]bool
+	values       []interface{}
+}
+
+// normalize parses src and finds the literals Normalize replaces.
+func normalize(src string) (*normalized, error) {
+	tokens, ast, err := parseTokens(src)
+	if err != nil {
+		return nil, err
+	}
+
+	byOffset := make(map[uint]int, len(tokens))
+	next := uint64(1)
+	for i, token := range tokens {
+		byOffset[token.Loc.Offset] = i
+		if token.Type == ParameterType {
+			if n, err := strconv.ParseUint(token.Value[1:], 10, 32); err == nil && n >= next {
+				next = n + 1
+			}
+		}
+	}
+
+	n := &normalized{tokens: tokens, placeholders: map[int]string{}, skipped: map[int]bool{}}
+	replace := func(first, last int, cast string, v interface{}) {
+		placeholder := "$" + strconv.FormatUint(next, 10)
+		next++
+		if cast != "" {
+			placeholder += "::" + cast
+		}
+
+		n.placeholders[first] = placeholder
+		for i := first + 1; i <= last; i++ {
+			n.skipped[i] = true
+		}
+		n.values = append(n.values, v)
+	}
+
+	var query func(slct *SelectStatement)
+	var walk func(exp *Expression)
+	query = func(slct *SelectStatement) {
+		for _, item := range slct.Item {
+			if !item.Asterisk {
+				walk(item.Exp)
+			}
+		}
+		if slct.Function != nil {
+			walk(slct.Function)
+		}
+		if slct.Where != nil {
+			walk(slct.Where)
+		}
+		if slct.ConnectBy != nil && slct.ConnectBy.Start != nil {
+			walk(slct.ConnectBy.Start)
+		}
+		// ORDER BY is left alone, an integer there is the position of an
+		// item rather than a value
+		if slct.Limit != nil {
+			walk(slct.Limit)
+		}
+		if slct.Offset != nil {
+			walk(slct.Offset)
+		}
+	}
+	walk = func(exp *Expression) {
+		switch exp.Type {
+		case LiteralType:
+			// The zero negation subtracts from has no token of its own
+			i, ok := byOffset[exp.Loc.Offset]
+			if !ok {
+				return
+			}
+			// The smallest integer is parsed with its sign
+			if exp.Literal.Type == Int64Value && tokens[i].Value == string(minusPunct) &&
+				i+1 < len(tokens) && tokens[i+1].Type == NumericType {
+				replace(i, i+1, "", exp.Literal.Int64)
+				return
+			}
+			if tokens[i].Type != NumericType && tokens[i].Type != StringType {
+				return
+			}
+
+			switch exp.Literal.Type {
+			case Int64Value, Float64Value, StringValue:
+				replace(i, i, "", exp.Literal.Interface())
+			}
+		case BinaryType:
+			// -1 is parsed as 0 - 1, the number is replaced with its sign
+			a, b := &exp.Binary.A, &exp.Binary.B
+			if exp.Binary.Op.Value == string(minusPunct) && a.Type == LiteralType && a.Loc == exp.Loc &&
+				b.Type == LiteralType && (b.Literal.Type == Int64Value || b.Literal.Type == Float64Value) {
+				minus, ok := byOffset[exp.Loc.Offset]
+				num, numOk := byOffset[b.Loc.Offset]
+				if ok && numOk && num == minus+1 && tokens[num].Type == NumericType {
+					v := b.Literal.Interface()
+					if n, ok := v.(int64); ok {
+						v = -n
+					} else {
+						v = -v.(float64)
+					}
+					replace(minus, num, "", v)
+					return
+				}
+			}
+			walk(a)
+			walk(b)
+		case CastType:
+			// Typed literals like INTERVAL '1 day' become $1::interval
+			if i, ok := byOffset[exp.Loc.Offset]; ok && exp.Cast.Exp.Type == LiteralType &&
+				tokens[i].Type == IdentifierType && i+1 < len(tokens) && tokens[i+1].Type == StringType &&
+				tokens[i+1].Loc == exp.Cast.Exp.Loc {
+				replace(i, i+1, formatToken(&exp.Cast.Type), exp.Cast.Exp.Literal.String)
+				return
+			}
+			walk(&exp.Cast.Exp)
+		case IndexType:
+			walk(&exp.Index.Exp)
+			walk(&exp.Index.Index)
+		case CallType:
+			for i := range exp.Call.Args {
+				walk(&exp.Call.Args[i])
+			}
+		case ArrayType:
+			for i := range exp.Array {
+				walk(&exp.Array[i])
+			}
+		case RowType:
+			for i := range exp.Row {
+				walk(&exp.Row[i])
+			}
+		case InType:
+			walk(&exp.In.Exp)
+			for i := range exp.In.List {
+				walk(&exp.In.List[i])
+			}
+		case NotType:
+			walk(exp.Not)
+		case ExistsType:
+			query(exp.Exists.Query)
+		case SubqueryType:
+			query(exp.Subquery)
+		}
+	}
+
+	var statement func(stmt *Statement)
+	statement = func(stmt *Statement) {
+		switch stmt.Type {
+		case SelectType:
+			query(stmt.SelectStatement)
+		case DeclareCursorType:
+			query(stmt.DeclareCursorStatement.Query)
+		case InsertType:
+			if stmt.InsertStatement.Values != nil {
+				for _, exp := range *stmt.InsertStatement.Values {
+					walk(exp)
+				}
+			}
+		case ExplainType:
+			statement(stmt.ExplainStatement.Statement)
+		}
+	}
+	for _, stmt := range ast.Statements {
+		statement(stmt)
+	}
+
+	return n, nil
+}
+
+// formatToken writes token back the way it is read again.
+func formatToken(token *Token) string {
+	switch token.Type {
+	case IdentifierType:
+		// alias.table is a single identifier that reads back unquoted
+		for _, part := range strings.Split(token.Value, ".") {
+			if FormatIdentifier(part) != part {
+				return QuoteIdentifier(token.Value)
+			}
+		}
+		return token.Value
+	case StringType:
+		return "'" + strings.ReplaceAll(token.Value, "'", "''") + "'"
+	case HintType:
+		return "/*+" + token.Value + "*/"
+	}
+
+	return token.Value
+}
+
+// spaced reports whether Normalize separates the tokens prev and next with
+// a space, which it does except around parentheses, brackets and casts and
+// before commas and semicolons.
+func spaced(prev, next *Token) bool {
+	if prev.Type == SymbolType {
+		switch punct(prev.Value) {
+		case leftparenPunct, leftbracketPunct, castPunct:
+			return false
+		}
+	}
+
+	if next.Type == SymbolType {
+		switch punct(next.Value) {
+		case rightparenPunct, rightbracketPunct, commaPunct, semicolonPunct, castPunct, leftbracketPunct:
+			return false
+		case leftparenPunct:
+			return prev.Type != IdentifierType && !prev.eq(&Token{Type: KeywordType, Value: string(castKeyword)})
+		}
+	}
+
+	return true
+}
+
+// redactedKey replaces the keys Redact removes.
+const redactedKey = "'<redacted>'"
+
+// Redact returns src with the keys of its REKEY statements replaced, so it
+// can be shown or logged without giving them away. Keys bound as parameters
+// aren't in src to begin with. src that doesn't tokenize but mentions
+// REKEY is replaced whole, since where its key is can't be told.
+func Redact(src string) string {
+	if !mentionsRekey(src) {
+		return src
+	}
+
+	tokens, err := tokenize(src)
+	if err != nil {
+		return "REKEY " + redactedKey
+	}
+
+	var b strings.Builder
+	last := 0
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].Type != IdentifierType || tokens[i].Value != "rekey" || tokens[i+1].Type != StringType {
+			continue
+		}
+
+		start := tokens[i+1].Loc.Offset
+		_, end, ok := lexString(src, cursor{ptr: start})
+		if !ok {
+			continue
+		}
+
+		b.WriteString(src[last:start])
+		b.WriteString(redactedKey)
+		last = int(end.ptr)
+	}
+	b.WriteString(src[last:])
+
+	return b.String()
+}
+
+// mentionsRekey reports whether src holds the word rekey in any case,
+// which is much cheaper than tokenizing it.
+func mentionsRekey(src string) bool {
+	for i := 0; i+len("rekey") <= len(src); i++ {
+		if strings.EqualFold(src[i:i+len("rekey")], "rekey") {
+			return true
+		}
+	}
+
+	return false
+}
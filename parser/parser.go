@@ -1,14 +1,21 @@
 package parser
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
+
+	"github.com/nireo/sgsql/logging"
 )
 
 type keyword string
 type punct string
-type tokenType uint
+
+// TokenType is the lexical class of a Token.
+type TokenType uint
 
 const (
 	selectKeyword keyword = "select"
@@ -22,73 +29,357 @@ const (
 	valuesKeyword keyword = "values"
 	intKeyword    keyword = "int"
 	textKeyword   keyword = "text"
+	andKeyword    keyword = "and"
+	orKeyword     keyword = "or"
+	trueKeyword   keyword = "true"
+	falseKeyword  keyword = "false"
+	nullKeyword   keyword = "null"
+
+	beginKeyword       keyword = "begin"
+	commitKeyword      keyword = "commit"
+	rollbackKeyword    keyword = "rollback"
+	transactionKeyword keyword = "transaction"
+
+	declareKeyword keyword = "declare"
+	cursorKeyword  keyword = "cursor"
+	forKeyword     keyword = "for"
+	fetchKeyword   keyword = "fetch"
+	closeKeyword   keyword = "close"
+	allKeyword     keyword = "all"
+	castKeyword    keyword = "cast"
+	collateKeyword keyword = "collate"
+	likeKeyword    keyword = "like"
+	ilikeKeyword   keyword = "ilike"
+	regexpKeyword  keyword = "regexp"
+	inKeyword      keyword = "in"
+	notKeyword     keyword = "not"
+	existsKeyword  keyword = "exists"
+	explainKeyword keyword = "explain"
+	dropKeyword    keyword = "drop"
+	alterKeyword   keyword = "alter"
 
-	semicolonPunct  punct = ";"
-	asteriskPunct   punct = "*"
-	commaPunct      punct = ","
-	leftparenPunct  punct = "("
-	rightparenPunct punct = ")"
-
-	keywordType tokenType = iota
-	symbolType
-	identifierType
-	stringType
-	numericType
+	semicolonPunct    punct = ";"
+	asteriskPunct     punct = "*"
+	commaPunct        punct = ","
+	leftparenPunct    punct = "("
+	rightparenPunct   punct = ")"
+	eqPunct           punct = "="
+	neqPunct          punct = "<>"
+	bangNeqPunct      punct = "!="
+	ltPunct           punct = "<"
+	ltePunct          punct = "<="
+	gtPunct           punct = ">"
+	gtePunct          punct = ">="
+	plusPunct         punct = "+"
+	minusPunct        punct = "-"
+	slashPunct        punct = "/"
+	concatPunct       punct = "||"
+	castPunct         punct = "::"
+	matchPunct        punct = "~"
+	imatchPunct       punct = "~*"
+	notMatchPunct     punct = "!~"
+	notImatchPunct    punct = "!~*"
+	leftbracketPunct  punct = "["
+	rightbracketPunct punct = "]"
+
+	KeywordType TokenType = iota
+	SymbolType
+	IdentifierType
+	StringType
+	NumericType
+	ParameterType
+	// HintType holds the text of a /*+ ... */ comment right after SELECT
+	HintType
 )
 
-type loc struct {
-	line   uint
-	column uint
+// Location is the position of a token in the source, all zero-based.
+// Offset counts bytes from the start of the parsed source.
+type Location struct {
+	Line   uint
+	Column uint
+	Offset uint
 }
 
-type tok struct {
-	value string
-	tt    tokenType
-	loc   loc
+// Token is a single lexed item of SQL source.
+type Token struct {
+	Value string
+	Type  TokenType
+	Loc   Location
 }
 
 type cursor struct {
 	ptr uint
-	loc loc
+	loc Location
 }
 
-func (t *tok) eq(rhs *tok) bool {
-	return t.value == rhs.value && t.tt == rhs.tt
+func (t *Token) eq(rhs *Token) bool {
+	return t.Value == rhs.Value && t.Type == rhs.Type
 }
 
-type lexer func(string, cursor) (*tok, cursor, bool)
+// maxKeywordLength bounds the length of keywords, longer words are always
+// identifiers.
+const maxKeywordLength = 32
 
-func tokenize(src string) ([]*tok, error) {
-	tokens := []*tok{}
-	cur := cursor{}
+// maxSymbolLength is the length of the longest symbol.
+const maxSymbolLength = 3
 
-lex:
-	for cur.ptr < uint(len(src)) {
-		lexers := []lexer{lexNum, lexString}
+var (
+	// keywords holds the keywords by length, so a word is only compared
+	// with the few keywords as long as it
+	keywords [maxKeywordLength + 1][]keyword
+	symbols  = map[string]punct{}
+	// symbolChars has the bytes symbols are made of
+	symbolChars [256]bool
+)
 
-		for _, l := range lexers {
-			if token, newcursor, ok := l(src, cur); ok {
-				cur = newcursor
-				if token != nil {
-					tokens = append(tokens, token)
+func init() {
+	for _, k := range []keyword{
+		selectKeyword,
+		insertKeyword,
+		valuesKeyword,
+		tableKeyword,
+		createKeyword,
+		whereKeyword,
+		fromKeyword,
+		intoKeyword,
+		textKeyword,
+		intKeyword,
+		asKeyword,
+		andKeyword,
+		orKeyword,
+		trueKeyword,
+		falseKeyword,
+		nullKeyword,
+		beginKeyword,
+		commitKeyword,
+		rollbackKeyword,
+		transactionKeyword,
+		declareKeyword,
+		cursorKeyword,
+		forKeyword,
+		fetchKeyword,
+		closeKeyword,
+		allKeyword,
+		castKeyword,
+		collateKeyword,
+		likeKeyword,
+		ilikeKeyword,
+		regexpKeyword,
+		inKeyword,
+		notKeyword,
+		existsKeyword,
+		explainKeyword,
+		dropKeyword,
+		alterKeyword,
+	} {
+		keywords[len(k)] = append(keywords[len(k)], k)
+	}
+
+	for _, s := range []punct{
+		commaPunct,
+		leftparenPunct,
+		rightparenPunct,
+		semicolonPunct,
+		asteriskPunct,
+		eqPunct,
+		neqPunct,
+		bangNeqPunct,
+		ltPunct,
+		ltePunct,
+		gtPunct,
+		gtePunct,
+		plusPunct,
+		minusPunct,
+		slashPunct,
+		concatPunct,
+		castPunct,
+		matchPunct,
+		imatchPunct,
+		notMatchPunct,
+		notImatchPunct,
+		leftbracketPunct,
+		rightbracketPunct,
+	} {
+		symbols[string(s)] = s
+		for i := 0; i < len(s); i++ {
+			symbolChars[s[i]] = true
+		}
+	}
+}
+
+// skipWhitespace returns the cursor moved past any whitespace at ic.
+func skipWhitespace(src string, ic cursor) cursor {
+	cur := ic
+	for ; cur.ptr < uint(len(src)); cur.ptr++ {
+		switch src[cur.ptr] {
+		case '\n':
+			cur.loc.Line++
+			cur.loc.Column = 0
+		case '\t', ' ', '\r':
+			cur.loc.Column++
+		default:
+			return cur
+		}
+	}
+
+	return cur
+}
+
+// tokenize splits src into tokens. Token values are slices of src wherever
+// possible, so lexing mostly allocates only the returned slice.
+func tokenize(src string) ([]Token, error) {
+	return tokenizeFrom(src, Location{})
+}
+
+// tokenizeFrom tokenizes src as if it started at loc in a larger source.
+func tokenizeFrom(src string, loc Location) ([]Token, error) {
+	// Typical SQL has a token every four or five bytes, guessing high avoids
+	// regrowing the slice
+	tokens := make([]Token, 0, len(src)/4+1)
+	cur := cursor{loc: loc}
+
+	// ? placeholders are numbered in order within each statement, so a
+	// statement parsed again on its own binds the same arguments
+	params := 0
+
+	for {
+		cur = skipWhitespace(src, cur)
+		if cur.ptr >= uint(len(src)) {
+			break
+		}
+
+		if c := src[cur.ptr]; c == '-' || c == '/' {
+			hintToken, newcursor, ok, err := lexComment(src, cur)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				// Hints only mean something right after SELECT, anywhere
+				// else they are ordinary comments
+				selectHint := len(tokens) > 0 &&
+					tokens[len(tokens)-1].eq(&Token{Type: KeywordType, Value: string(selectKeyword)})
+				if hintToken != nil && selectHint {
+					hintToken.Loc.Offset = cur.ptr + uint(len("/*+"))
+					tokens = append(tokens, *hintToken)
 				}
 
-				continue lex
+				cur = newcursor
+				continue
+			}
+		}
+
+		token, newcursor, ok := lexToken(src, cur)
+		if !ok {
+			hint := ""
+			if len(tokens) > 0 {
+				hint = " after " + tokens[len(tokens)-1].Value
+			}
+			return nil, fmt.Errorf(
+				"unable to lex tokens%s, at %d:%d", hint, cur.loc.Line, cur.loc.Column)
+		}
+
+		switch token.Type {
+		case SymbolType:
+			if token.Value == string(semicolonPunct) {
+				params = 0
+			}
+		case ParameterType:
+			if token.Value == "?" {
+				params++
+				token.Value = "$" + strconv.Itoa(params)
 			}
 		}
 
-		hint := ""
-		if len(tokens) > 0 {
-			hint = "after " + tokens[len(tokens)-1].value
+		// Scripts denser than guessed get room for as many tokens as the
+		// density so far needs, append would copy large slices many times
+		// growing them a quarter at a time
+		if len(tokens) == cap(tokens) {
+			need := uint(len(tokens)) * uint(len(src)) / cur.ptr
+			grown := make([]Token, len(tokens), need+need/8+16)
+			copy(grown, tokens)
+			tokens = grown
 		}
-		return nil, fmt.Errorf(
-			"unable to lex tokens%s, at %d:%d", hint, cur.loc.line, cur.loc.column)
+
+		token.Loc.Offset = cur.ptr
+		cur = newcursor
+		tokens = append(tokens, token)
 	}
 
 	return tokens, nil
 }
 
-func lexNum(src string, ic cursor) (*tok, cursor, bool) {
+// lexToken lexes the token at ic. The first byte tells which lexer can
+// match it, so only that one is tried, or for words the keyword lexer and
+// then the identifier one.
+func lexToken(src string, ic cursor) (Token, cursor, bool) {
+	switch c := src[ic.ptr]; {
+	case isIdentifierStart(c):
+		if token, cur, ok := lexKeyword(src, ic); ok {
+			return token, cur, true
+		}
+		return lexIdentifier(src, ic)
+	case c == '"':
+		return lexIdentifier(src, ic)
+	case c >= '0' && c <= '9', c == '.':
+		return lexNum(src, ic)
+	case c == '\'':
+		return lexString(src, ic)
+	case c == '$', c == '?':
+		return lexParameter(src, ic)
+	}
+
+	return lexSymbol(src, ic)
+}
+
+// lexComment returns the cursor moved past the -- or /* */ comment at ic, if
+// there is one. For a hint, a block comment starting with /*+, it also
+// returns a token holding the text between /*+ and */.
+func lexComment(src string, ic cursor) (*Token, cursor, bool, error) {
+	rest := src[ic.ptr:]
+
+	var end int
+	switch {
+	case strings.HasPrefix(rest, "--"):
+		end = strings.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+	case strings.HasPrefix(rest, "/*"):
+		end = strings.Index(rest[2:], "*/")
+		if end < 0 {
+			return nil, ic, false, fmt.Errorf(
+				"unterminated comment, at %d:%d", ic.loc.Line, ic.loc.Column)
+		}
+		end += len("/**/")
+	default:
+		return nil, ic, false, nil
+	}
+
+	cur := ic
+	for _, c := range []byte(rest[:end]) {
+		cur.ptr++
+		if c == '\n' {
+			cur.loc.Line++
+			cur.loc.Column = 0
+		} else {
+			cur.loc.Column++
+		}
+	}
+
+	if !strings.HasPrefix(rest, "/*+") {
+		return nil, cur, true, nil
+	}
+
+	loc := ic.loc
+	loc.Column += uint(len("/*+"))
+	return &Token{
+		Value: rest[len("/*+") : end-len("*/")],
+		Type:  HintType,
+		Loc:   loc,
+	}, cur, true, nil
+}
+
+func lexNum(src string, ic cursor) (Token, cursor, bool) {
 	cur := ic
 
 	periodFound := false
@@ -96,7 +387,7 @@ func lexNum(src string, ic cursor) (*tok, cursor, bool) {
 
 	for ; cur.ptr < uint(len(src)); cur.ptr++ {
 		c := src[cur.ptr]
-		cur.loc.column++
+		cur.loc.Column++
 
 		isDigit := c >= '0' && c <= '9'
 		isPeriod := c == '.'
@@ -105,7 +396,7 @@ func lexNum(src string, ic cursor) (*tok, cursor, bool) {
 		// Must start with a digit or period
 		if cur.ptr == ic.ptr {
 			if !isDigit && !isPeriod {
-				return nil, ic, false
+				return Token{}, ic, false
 			}
 
 			periodFound = isPeriod
@@ -114,7 +405,7 @@ func lexNum(src string, ic cursor) (*tok, cursor, bool) {
 
 		if isPeriod {
 			if periodFound {
-				return nil, ic, false
+				return Token{}, ic, false
 			}
 
 			periodFound = true
@@ -123,7 +414,7 @@ func lexNum(src string, ic cursor) (*tok, cursor, bool) {
 
 		if isExpMarker {
 			if expMarkerFound {
-				return nil, ic, false
+				return Token{}, ic, false
 			}
 
 			// No periods allowed after expMarker
@@ -132,13 +423,13 @@ func lexNum(src string, ic cursor) (*tok, cursor, bool) {
 
 			// expMarker must be followed by digits
 			if cur.ptr == uint(len(src)-1) {
-				return nil, ic, false
+				return Token{}, ic, false
 			}
 
 			cNext := src[cur.ptr+1]
 			if cNext == '-' || cNext == '+' {
 				cur.ptr++
-				cur.loc.column++
+				cur.loc.Column++
 			}
 
 			continue
@@ -151,232 +442,271 @@ func lexNum(src string, ic cursor) (*tok, cursor, bool) {
 
 	// No characters accumulated
 	if cur.ptr == ic.ptr {
-		return nil, ic, false
+		return Token{}, ic, false
 	}
 
-	return &tok{
-		value: src[ic.ptr:cur.ptr],
-		loc:   ic.loc,
-		tt:    numericType,
+	return Token{
+		Value: src[ic.ptr:cur.ptr],
+		Loc:   ic.loc,
+		Type:  NumericType,
 	}, cur, true
 }
 
 func lexCharacterDelimited(src string, ic cursor, delimiter byte) (
-	*tok, cursor, bool,
+	Token, cursor, bool,
 ) {
 	cur := ic
 	if len(src[cur.ptr:]) == 0 {
-		return nil, ic, false
+		return Token{}, ic, false
 	}
 
 	if src[cur.ptr] != delimiter {
-		return nil, ic, false
+		return Token{}, ic, false
 	}
 
-	cur.loc.column++
+	cur.loc.Column++
 	cur.ptr++
 
-	var value []byte
+	start := cur.ptr
+	escaped := false
 	for ; cur.ptr < uint(len(src)); cur.ptr++ {
 		c := src[cur.ptr]
 
 		if c == delimiter {
+			// SQL escapes are via doubled delimiters, not backslash
 			if cur.ptr+1 >= uint(len(src)) || src[cur.ptr+1] != delimiter {
-				return &tok{
-					value: string(value),
-					loc:   ic.loc,
-					tt:    stringType,
-				}, cur, true
-			} else {
-				value = append(value, delimiter)
+				value := src[start:cur.ptr]
+				if escaped {
+					d := string(delimiter)
+					value = strings.ReplaceAll(value, d+d, d)
+				}
+
 				cur.ptr++
-				cur.loc.column++
+				cur.loc.Column++
+
+				return Token{
+					Value: value,
+					Loc:   ic.loc,
+					Type:  StringType,
+				}, cur, true
 			}
+
+			escaped = true
+			cur.ptr++
+			cur.loc.Column++
 		}
 
-		value = append(value, c)
-		cur.loc.column++
+		cur.loc.Column++
 	}
 
-	return nil, ic, false
+	return Token{}, ic, false
 }
 
-func lexString(src string, ic cursor) (*tok, cursor, bool) {
+func lexString(src string, ic cursor) (Token, cursor, bool) {
 	return lexCharacterDelimited(src, ic, '\'')
 }
 
-func lexSymbol(src string, ic cursor) (*tok, cursor, bool) {
-	c := src[ic.ptr]
+func lexSymbol(src string, ic cursor) (Token, cursor, bool) {
 	cur := ic
-	// Will get overwritten later if not an ignored syntax
-	cur.ptr++
-	cur.loc.column++
-
-	switch c {
-	// Syntax that should be thrown away
-	case '\n':
-		cur.loc.line++
-		cur.loc.column = 0
-		fallthrough
-	case '\t':
-		fallthrough
-	case ' ':
-		return nil, cur, true
-	}
-
-	// Syntax that should be kept
-	symbols := []punct{
-		commaPunct,
-		leftparenPunct,
-		rightparenPunct,
-		semicolonPunct,
-		asteriskPunct,
-	}
 
-	var options []string
-	for _, s := range symbols {
-		options = append(options, string(s))
+	// Only runs of symbol characters can be symbols
+	length := uint(0)
+	for length < maxSymbolLength && ic.ptr+length < uint(len(src)) && symbolChars[src[ic.ptr+length]] {
+		length++
 	}
 
-	// Use `ic`, not `cur`
-	match := longestMatch(src, ic, options)
-	// Unknown character
-	if match == "" {
-		return nil, ic, false
+	// Try longer symbols first so that "<=" isn't lexed as "<" followed by
+	// "="
+	for n := length; n > 0; n-- {
+		match, ok := symbols[src[ic.ptr:ic.ptr+n]]
+		if !ok {
+			continue
+		}
+
+		cur.ptr = ic.ptr + n
+		cur.loc.Column = ic.loc.Column + n
+
+		return Token{
+			Value: string(match),
+			Loc:   ic.loc,
+			Type:  SymbolType,
+		}, cur, true
 	}
 
-	cur.ptr = ic.ptr + uint(len(match))
-	cur.loc.column = ic.loc.column + uint(len(match))
+	// Unknown character
+	return Token{}, ic, false
+}
 
-	return &tok{
-		value: match,
-		loc:   ic.loc,
-		tt:    symbolType,
-	}, cur, true
+func isIdentifierStart(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '_'
 }
 
-func lexKeyword(source string, ic cursor) (*tok, cursor, bool) {
-	cur := ic
-	keywords := []keyword{
-		selectKeyword,
-		insertKeyword,
-		valuesKeyword,
-		tableKeyword,
-		createKeyword,
-		whereKeyword,
-		fromKeyword,
-		intoKeyword,
-		textKeyword,
-	}
+func isIdentifierChar(c byte) bool {
+	// Other characters count too, but ignoring non-ascii for now
+	return isIdentifierStart(c) || (c >= '0' && c <= '9') || c == '$' || c == '_'
+}
 
-	var options []string
-	for _, k := range keywords {
-		options = append(options, string(k))
+// scanWord returns the end of the unquoted identifier starting at ptr, which
+// is ptr itself if there is none.
+func scanWord(src string, ptr uint) uint {
+	if ptr >= uint(len(src)) || !isIdentifierStart(src[ptr]) {
+		return ptr
 	}
 
-	match := longestMatch(source, ic, options)
-	if match == "" {
-		return nil, ic, false
+	end := ptr + 1
+	for end < uint(len(src)) && isIdentifierChar(src[end]) {
+		end++
 	}
 
-	cur.ptr = ic.ptr + uint(len(match))
-	cur.loc.column = ic.loc.column + uint(len(match))
-
-	return &tok{
-		value: match,
-		tt:    keywordType,
-		loc:   ic.loc,
-	}, cur, true
+	return end
 }
 
-func longestMatch(src string, ic cursor, options []string) string {
-	var value []byte
-	var skipList []int
-	var match string
+// lexKeyword matches whole words only, so identifiers that merely start with
+// a keyword like "selected" are left for lexIdentifier.
+func lexKeyword(src string, ic cursor) (Token, cursor, bool) {
+	end := scanWord(src, ic.ptr)
+	length := end - ic.ptr
+	if length == 0 {
+		return Token{}, ic, false
+	}
 
-	cur := ic
+	match, ok := lookupKeyword(src[ic.ptr:end])
+	if !ok {
+		return Token{}, ic, false
+	}
 
-	for cur.ptr < uint(len(src)) {
-		value = append(value, strings.ToLower(string(src[cur.ptr]))...)
-		cur.ptr++
+	cur := ic
+	cur.ptr = end
+	cur.loc.Column = ic.loc.Column + length
 
-	match:
-		for i, option := range options {
-			for _, skip := range skipList {
-				if i == skip {
-					continue match
-				}
-			}
+	return Token{
+		Value: string(match),
+		Type:  KeywordType,
+		Loc:   ic.loc,
+	}, cur, true
+}
 
-			if option == string(value) {
-				skipList = append(skipList, i)
-				if len(option) > len(match) {
-					match = option
-				}
+// lookupKeyword returns the keyword word is, ignoring case.
+func lookupKeyword(word string) (keyword, bool) {
+	if len(word) > maxKeywordLength {
+		return "", false
+	}
 
-				continue
+	for _, k := range keywords[len(word)] {
+		i := 0
+		for ; i < len(word); i++ {
+			c := word[i]
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
 			}
-
-			sharesPrefix := string(value) == option[:cur.ptr-ic.ptr]
-			tooLong := len(value) > len(option)
-			if tooLong || !sharesPrefix {
-				skipList = append(skipList, i)
+			if c != k[i] {
+				break
 			}
 		}
 
-		if len(skipList) == len(options) {
-			break
+		if i == len(word) {
+			return k, true
 		}
 	}
 
-	return match
+	return "", false
 }
 
-func lexIdentifier(src string, ic cursor) (*tok, cursor, bool) {
-	if token, newCursor, ok := lexCharacterDelimited(src, ic, '"'); ok {
-		return token, newCursor, true
+// lexParameter lexes a $n placeholder, or a ? one as MySQL clients write
+// them, which tokenizeFrom numbers.
+func lexParameter(src string, ic cursor) (Token, cursor, bool) {
+	if src[ic.ptr] == '?' {
+		cur := ic
+		cur.ptr++
+		cur.loc.Column++
+		return Token{Value: "?", Loc: ic.loc, Type: ParameterType}, cur, true
+	}
+
+	if src[ic.ptr] != '$' {
+		return Token{}, ic, false
 	}
 
 	cur := ic
+	cur.ptr++
+	cur.loc.Column++
+	for ; cur.ptr < uint(len(src)); cur.ptr++ {
+		c := src[cur.ptr]
+		if c < '0' || c > '9' {
+			break
+		}
+		cur.loc.Column++
+	}
 
-	c := src[cur.ptr]
-	isAlphabetical := (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
-	if !isAlphabetical {
-		return nil, ic, false
+	// Need at least one digit after the dollar sign
+	if cur.ptr == ic.ptr+1 {
+		return Token{}, ic, false
 	}
-	cur.ptr++
-	cur.loc.column++
 
-	value := []byte{c}
-	for ; cur.ptr < uint(len(src)); cur.ptr++ {
-		c = src[cur.ptr]
-
-		// Other characters count too, big ignoring non-ascii for now
-		isAlphabetical := (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
-		isNumeric := c >= '0' && c <= '9'
-		if isAlphabetical || isNumeric || c == '$' || c == '_' {
-			value = append(value, c)
-			cur.loc.column++
-			continue
+	return Token{
+		Value: src[ic.ptr:cur.ptr],
+		Loc:   ic.loc,
+		Type:  ParameterType,
+	}, cur, true
+}
+
+func lexIdentifier(src string, ic cursor) (Token, cursor, bool) {
+	// Quoted identifiers keep their case and may contain anything, including
+	// spaces and reserved words
+	if token, newCursor, ok := lexCharacterDelimited(src, ic, '"'); ok {
+		if token.Value == "" {
+			return Token{}, ic, false
 		}
 
-		break
+		token.Type = IdentifierType
+		return token, newCursor, true
+	}
+
+	end := scanWord(src, ic.ptr)
+	if end == ic.ptr {
+		return Token{}, ic, false
 	}
 
-	if len(value) == 0 {
-		return nil, ic, false
+	// A table of an attached database is written alias.table, which is
+	// read as a single identifier
+	if end+1 < uint(len(src)) && src[end] == '.' && isIdentifierStart(src[end+1]) {
+		end = scanWord(src, end+1)
 	}
 
-	return &tok{
-		// Unquoted dentifiers are case-insensitive
-		value: strings.ToLower(string(value)),
-		loc:   ic.loc,
-		tt:    identifierType,
+	cur := ic
+	cur.ptr = end
+	cur.loc.Column = ic.loc.Column + (end - ic.ptr)
+
+	return Token{
+		// Unquoted identifiers are case-insensitive
+		Value: strings.ToLower(src[ic.ptr:end]),
+		Loc:   ic.loc,
+		Type:  IdentifierType,
 	}, cur, true
 }
 
+// QuoteIdentifier quotes name so it is always read back as exactly that
+// identifier, even if it is a reserved word or contains special characters.
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// FormatIdentifier returns name as it is if it reads back as exactly that
+// identifier, and quoted otherwise.
+func FormatIdentifier(name string) string {
+	if scanWord(name, 0) == uint(len(name)) && name != "" && strings.ToLower(name) == name {
+		if _, ok := lookupKeyword(name); !ok {
+			return name
+		}
+	}
+
+	return QuoteIdentifier(name)
+}
+
 type AST struct {
 	Statements []*Statement
+	// Params is the highest placeholder number in the statements, the
+	// number of arguments they take
+	Params uint
 }
 
 type ASTType uint
@@ -385,95 +715,3878 @@ const (
 	SelectType ASTType = iota
 	CreateTableType
 	InsertType
+	BeginType
+	CommitType
+	RollbackType
+	DeclareCursorType
+	FetchType
+	CloseType
+	CreateSequenceType
+	ExplainType
+	VacuumType
+	CheckTableType
+	RekeyType
+	ShowType
+	DropTableType
+	AlterTableType
+	KillType
+	CreateMaterializedViewType
+	RefreshType
+	AttachType
+	DetachType
+	CreatePolicyType
+	DropPolicyType
+	GrantType
+	CreateIndexType
+	DropIndexType
+	CreateRoleType
+	DropRoleType
+	DiscardType
+	ListenType
+	NotifyType
+	PrepareTransactionType
+	CommitPreparedType
+	RollbackPreparedType
+	FlashbackType
+	AnalyzeType
+	CreateStatisticsType
+	DropStatisticsType
+	RecommendIndexesType
+	CommentType
+	ReloadConfigType
+	CopyType
 )
 
+// Statement is a single parsed statement. Text is its source, without the
+// terminating semicolon.
 type Statement struct {
-	SelectStatement      *SelectStatement
-	CreateTableStatement *CreateTableStatement
-	InsertStatement      *InsertStatement
-	tt                   ASTType
+	SelectStatement                 *SelectStatement
+	CreateTableStatement            *CreateTableStatement
+	InsertStatement                 *InsertStatement
+	DeclareCursorStatement          *DeclareCursorStatement
+	FetchStatement                  *FetchStatement
+	CloseStatement                  *CloseStatement
+	CreateSequenceStatement         *CreateSequenceStatement
+	ExplainStatement                *ExplainStatement
+	CheckTableStatement             *CheckTableStatement
+	RekeyStatement                  *RekeyStatement
+	ShowStatement                   *ShowStatement
+	DropTableStatement              *DropTableStatement
+	AlterTableStatement             *AlterTableStatement
+	KillStatement                   *KillStatement
+	CreateMaterializedViewStatement *CreateMaterializedViewStatement
+	RefreshStatement                *RefreshStatement
+	AttachStatement                 *AttachStatement
+	DetachStatement                 *DetachStatement
+	CreatePolicyStatement           *CreatePolicyStatement
+	DropPolicyStatement             *DropPolicyStatement
+	GrantStatement                  *GrantStatement
+	CreateIndexStatement            *CreateIndexStatement
+	DropIndexStatement              *DropIndexStatement
+	CreateRoleStatement             *CreateRoleStatement
+	DropRoleStatement               *DropRoleStatement
+	ListenStatement                 *ListenStatement
+	NotifyStatement                 *NotifyStatement
+	TwoPhaseStatement               *TwoPhaseStatement
+	FlashbackStatement              *FlashbackStatement
+	AnalyzeStatement                *AnalyzeStatement
+	CreateStatisticsStatement       *CreateStatisticsStatement
+	DropStatisticsStatement         *DropStatisticsStatement
+	CommentStatement                *CommentStatement
+	CopyStatement                   *CopyStatement
+	Type                            ASTType
+	Text                            string
 }
 
-type expressionType uint
+type ExpressionType uint
 
 const (
-	literalType expressionType = iota
+	LiteralType ExpressionType = iota
+	BinaryType
+	ColumnRefType
+	ParamType
+	CastType
+	CallType
+	ArrayType
+	IndexType
+	RowType
+	InType
+	ExistsType
+	SubqueryType
+	NotType
+	DefaultType
 )
 
-type expression struct {
-	lit *tok
-	tt  expressionType
+// Expression is one of a constant, a binary operation, a reference to a
+// column, a cast, a function call, an ARRAY[...] constructor, an index
+// into an array, a (a, b, ...) row value, an IN test, an EXISTS test, a
+// scalar subquery, a NOT negation, a $n placeholder numbered from one, or
+// DEFAULT, which only stands for the default of a column among the values
+// of INSERT.
+// Loc is where the expression starts in the source.
+type Expression struct {
+	Literal  *Value
+	Binary   *BinaryExpression
+	Column   *Token
+	Cast     *CastExpression
+	Call     *CallExpression
+	Array    []Expression
+	Index    *IndexExpression
+	Row      []Expression
+	In       *InExpression
+	Exists   *ExistsExpression
+	Subquery *SelectStatement
+	Not      *Expression
+	Param    uint
+	Type     ExpressionType
+	Loc      Location
+}
+
+type BinaryExpression struct {
+	A  Expression
+	B  Expression
+	Op Token
+}
+
+// CastExpression converts Exp to the type named by Type, written either as
+// CAST(exp AS type) or exp::type.
+type CastExpression struct {
+	Exp  Expression
+	Type Token
+}
+
+// IndexExpression is Exp[Index].
+type IndexExpression struct {
+	Exp   Expression
+	Index Expression
+}
+
+// InExpression tests whether Exp equals any expression of List, or none of
+// them when Not is set. Subquery is set for IN (query), List then holds the
+// query as its only expression and Exp is compared with the value of every
+// row it returns.
+type InExpression struct {
+	Exp      Expression
+	List     []Expression
+	Not      bool
+	Subquery bool
 }
 
-type columnDefinition struct {
-	name     tok
-	datatype tok
+// ExistsExpression tests whether Query returns any rows, or none when Not is
+// set. The query may refer to columns of the enclosing one.
+type ExistsExpression struct {
+	Query *SelectStatement
+	Not   bool
 }
 
+// CallExpression calls the function called Name. Star is set for count(*),
+// which has no arguments.
+type CallExpression struct {
+	Name Token
+	Args []Expression
+	Star bool
+}
+
+// ColumnDefinition is a column of CREATE TABLE. Collate names how the
+// column's text is compared and is nil for the default. Identity is set for
+// identity columns, References for foreign keys and Unique for unique
+// columns.
+type ColumnDefinition struct {
+	Name       Token
+	Datatype   Token
+	Collate    *Token
+	Identity   *ColumnIdentity
+	References *ForeignKey
+	Unique     *Unique
+}
+
+// ForeignKey is REFERENCES Table (Column), which only lets a column hold
+// values that Column of Table holds, or NULL. Deferred is set by DEFERRABLE
+// INITIALLY DEFERRED, which checks the key when the transaction commits
+// rather than after each statement.
+type ForeignKey struct {
+	Table    Token
+	Column   Token
+	Deferred bool
+}
+
+// Unique is UNIQUE, which keeps a column from holding a value other than
+// NULL twice. Deferred is set like that of ForeignKey.
+type Unique struct {
+	Deferred bool
+}
+
+// TableConstraint is ADD FOREIGN KEY (Column) REFERENCES table (column),
+// which makes Column of the table altered a foreign key, or ADD UNIQUE
+// (Column), which makes it unique. Exactly one of References and Unique is
+// set.
+type TableConstraint struct {
+	Column     Token
+	References *ForeignKey
+	Unique     *Unique
+}
+
+// ColumnIdentity makes a column an identity column, GENERATED ALWAYS AS
+// IDENTITY or GENERATED BY DEFAULT AS IDENTITY, whose values count up from
+// Start by Increment when an insert leaves them to the DEFAULT. A value
+// given for a column generated ALWAYS is refused unless the insert
+// overrides the system value.
+type ColumnIdentity struct {
+	Always    bool
+	Start     *int64
+	Increment int64
+}
+
+// CreateTableStatement creates a table, or an external table reading its
+// rows from the file at Location when that is set. Format names how the
+// file is read, nil to go by the extension of the file. Compression names
+// the compressor the storage engine compresses the rows of the table with,
+// written WITH (compression = 'name'), nil for none.
 type CreateTableStatement struct {
-	name tok
-	cols *[]*columnDefinition
+	Name        Token
+	Cols        *[]*ColumnDefinition
+	Location    *Token
+	Format      *Token
+	Compression *Token
+}
+
+// DropTableStatement drops a table, or a materialized view when View is
+// set. Cascade drops the materialized views reading it too, rather than
+// failing because of them like RESTRICT, the default, does.
+type DropTableStatement struct {
+	Name    Token
+	View    bool
+	Cascade bool
+}
+
+// CreateMaterializedViewStatement creates a table holding the results of
+// Query, which REFRESH MATERIALIZED VIEW runs again to replace them.
+type CreateMaterializedViewStatement struct {
+	Name  Token
+	Query *SelectStatement
 }
 
+// RefreshStatement replaces the rows of a materialized view with the
+// current results of its query.
+type RefreshStatement struct {
+	Name Token
+}
+
+// AlterTableStatement changes the columns of Table. Exactly one of Add, Drop,
+// Alter, Mask, Rename and Constraint is set: Add adds a column, Drop removes
+// the one it names, Alter changes the type and collation of the column it
+// names, casting its values to the new type, Mask masks a column or stops
+// masking it, Rename renames the table or one of its columns and Constraint
+// adds a constraint the rows already held must meet.
+type AlterTableStatement struct {
+	Table      Token
+	Add        *ColumnDefinition
+	Drop       *Token
+	Alter      *ColumnDefinition
+	Mask       *ColumnMask
+	Rename     *Rename
+	Constraint *TableConstraint
+}
+
+// Rename is RENAME TO name, which renames the table to To, or RENAME
+// [COLUMN] column TO name, which renames its Column.
+type Rename struct {
+	Column *Token
+	To     Token
+}
+
+// ColumnMask is ALTER COLUMN ... SET MASKED WITH function, which hides the
+// values of Column from users without the UNMASK privilege, or DROP MASKED
+// when Function is nil.
+type ColumnMask struct {
+	Column   Token
+	Function *Expression
+}
+
+type SelectItem struct {
+	Exp      *Expression
+	Asterisk bool
+	As       *Token
+}
+
+// SelectStatement is a query. It reads the rows of the table From, or of
+// Function, a call to a set-returning function written in its place, or a
+// single empty row when both are nil.
 type SelectStatement struct {
-	item []*expression
-	from tok
+	Hints    []Hint
+	Item     []*SelectItem
+	From     *Token
+	Function *Expression
+	// AsOf is the time of FROM table AS OF TIMESTAMP time, which reads the
+	// table as it was then
+	AsOf      *Expression
+	Where     *Expression
+	ConnectBy *ConnectBy
+	// OrderBy sorts the rows, of which only Limit are returned after
+	// skipping Offset when they are set
+	OrderBy []*OrderItem
+	Limit   *Expression
+	Offset  *Expression
+}
+
+// OrderItem is an expression of ORDER BY, sorting in descending order when
+// Desc is set. An integer stands for the item of the query at that
+// position and a name for the item called that with AS, before any column.
+type OrderItem struct {
+	Exp  *Expression
+	Desc bool
+}
+
+// ConnectBy walks the rows of a table as a tree, written START WITH start
+// CONNECT BY PRIOR prior = child. The walk starts from the rows satisfying
+// Start, or from every row when it is nil, and the children of a row are
+// the rows whose Child column equals its Prior column. A row already on the
+// path to it is skipped when NoCycle is set and fails the query otherwise.
+type ConnectBy struct {
+	Start   *Expression
+	Prior   Token
+	Child   Token
+	NoCycle bool
+}
+
+// Hint asks the planner to run a query a certain way. Hints are written in
+// a /*+ ... */ comment right after SELECT, like /*+ NESTED_LOOP(orders) */.
+type Hint struct {
+	Name Token
+	Args []Token
 }
 
+// InsertStatement inserts a row of Values into Table. Overriding is
+// "system" or "user" for OVERRIDING SYSTEM VALUE and OVERRIDING USER VALUE,
+// which keep or ignore the values given for identity columns, and nil
+// otherwise.
 type InsertStatement struct {
-	table  tok
-	values *[]*expression
+	Table      Token
+	Overriding *Token
+	Values     *[]*Expression
 }
 
-func tokenFromKeyword(k keyword) tok {
-	return tok{
-		tt:    keywordType,
-		value: string(k),
+type DeclareCursorStatement struct {
+	Name  Token
+	Query *SelectStatement
+}
+
+// FetchStatement reads the next Count rows of a cursor, or all of the
+// remaining ones when All is set.
+type FetchStatement struct {
+	Name  Token
+	Count uint64
+	All   bool
+}
+
+type CloseStatement struct {
+	Name Token
+}
+
+// ExplainStatement describes how Statement would run instead of running it.
+// With Validate, written EXPLAIN (VALIDATE), it only reports whether
+// Statement would fail before running and with what error.
+type ExplainStatement struct {
+	Statement *Statement
+	Validate  bool
+}
+
+// CheckTableStatement verifies the stored data of Table.
+type CheckTableStatement struct {
+	Table Token
+}
+
+// RekeyStatement encrypts the database with a new key, given as a string of
+// hex digits or a parameter. An empty key stores it unencrypted.
+type RekeyStatement struct {
+	Key Expression
+}
+
+// KillStatement cancels the query running in a session, given as a number
+// or a parameter.
+type KillStatement struct {
+	Session Expression
+}
+
+// AttachStatement makes the database stored at Path available to the
+// session, whose statements then refer to its tables as alias.table.
+type AttachStatement struct {
+	Path  Token
+	Alias Token
+}
+
+// DetachStatement ends what an ATTACH with the same alias started.
+type DetachStatement struct {
+	Alias Token
+}
+
+// FlashbackStatement is FLASHBACK TABLE table TO TIMESTAMP time, which
+// takes the table back to the rows it had then, or FLASHBACK TABLE table TO
+// ROWS n, which keeps its first Rows rows. The statement log records the
+// first as the second, since the history of the table isn't replayed.
+type FlashbackStatement struct {
+	Table     Token
+	Timestamp *Expression
+	Rows      *int64
+}
+
+// TwoPhaseStatement is PREPARE TRANSACTION 'id', COMMIT PREPARED 'id' or
+// ROLLBACK PREPARED 'id', telling them apart by the type of the statement
+// holding it.
+type TwoPhaseStatement struct {
+	ID Token
+}
+
+// ListenStatement is LISTEN channel, or UNLISTEN channel when Unlisten is
+// set. Channel is nil for UNLISTEN *, which stops listening on every
+// channel.
+type ListenStatement struct {
+	Channel  *Token
+	Unlisten bool
+}
+
+// NotifyStatement is NOTIFY channel, or NOTIFY channel, 'payload'.
+type NotifyStatement struct {
+	Channel Token
+	Payload *Token
+}
+
+// CopyStatement is COPY table [(columns)] FROM STDIN, which reads rows from
+// the client, or COPY table [(columns)] TO STDOUT and COPY (query) TO
+// STDOUT, which send them to it. Format is text, csv or binary, and Header
+// is set when the first line of csv names the columns.
+type CopyStatement struct {
+	Table   *Token
+	Columns []Token
+	Query   *SelectStatement
+	From    bool
+	Format  string
+	Header  bool
+}
+
+// CreatePolicyStatement limits the rows of Table queries see to those Using
+// holds for, see backend.MemoryBackend.CreatePolicy.
+type CreatePolicyStatement struct {
+	Name  Token
+	Table Token
+	Using Expression
+}
+
+type DropPolicyStatement struct {
+	Name  Token
+	Table Token
+}
+
+// CreateIndexStatement indexes Column of Table, or Expression computed
+// from its columns when it is set. Name is nil when the index is named
+// after them. Concurrently builds it without keeping writes to the table
+// waiting while it does.
+type CreateIndexStatement struct {
+	Name       *Token
+	Table      Token
+	Column     Token
+	Expression *Expression
+	// Where is the predicate of a partial index, which only holds the rows
+	// it is true for
+	Where        *Expression
+	Concurrently bool
+}
+
+type DropIndexStatement struct {
+	Name Token
+}
+
+// AnalyzeStatement collects the statistics of Table, or of every table
+// when it is nil, that the planner estimates how many rows match with.
+type AnalyzeStatement struct {
+	Table *Token
+}
+
+// CreateStatisticsStatement defines statistics over Columns of Table taken
+// together, which ANALYZE collects. Kinds are "ndistinct", the number of
+// distinct combinations of their values, and "dependencies", how far the
+// value of one column determines another; both when it is empty.
+type CreateStatisticsStatement struct {
+	Name    Token
+	Kinds   []Token
+	Columns []Token
+	Table   Token
+}
+
+type DropStatisticsStatement struct {
+	Name Token
+}
+
+// GrantStatement is GRANT privilege TO user, or REVOKE privilege FROM user
+// when Revoke is set. Privilege can also name a role, which User is then
+// made a member of, and User can name a role too.
+type GrantStatement struct {
+	Privilege Token
+	User      Token
+	Revoke    bool
+}
+
+// CreateRoleStatement creates a role, which users and other roles are
+// granted to share its privileges.
+type CreateRoleStatement struct {
+	Name Token
+}
+
+type DropRoleStatement struct {
+	Name Token
+}
+
+// CommentStatement is COMMENT ON TABLE Table IS 'comment', or COMMENT ON
+// COLUMN Table.Column when Column is set. Comment is nil for IS NULL, which
+// removes the comment like an empty one does.
+type CommentStatement struct {
+	Table   Token
+	Column  *Token
+	Comment *Token
+}
+
+// ShowStatement lists the tables, or the columns of Table when it is set.
+type ShowStatement struct {
+	Table *Token
+}
+
+// CreateSequenceStatement creates a sequence. Start is nil when it isn't
+// given, the sequence then starts at 1, or at -1 when it counts down.
+// Cache is how many values are handed out between writes to the log.
+type CreateSequenceStatement struct {
+	Name      Token
+	Start     *int64
+	Increment int64
+	Cache     int64
+}
+
+func tokenFromKeyword(k keyword) Token {
+	return Token{
+		Type:  KeywordType,
+		Value: string(k),
 	}
 }
 
-func tokenFromPunct(s punct) tok {
-	return tok{
-		tt:    symbolType,
-		value: string(s),
+func tokenFromPunct(s punct) Token {
+	return Token{
+		Type:  SymbolType,
+		Value: string(s),
 	}
 }
 
-func expectToken(tokens []*tok, cursor uint, t tok) bool {
+func expectToken(tokens []Token, cursor uint, t Token) bool {
 	if cursor >= uint(len(tokens)) {
 		return false
 	}
 
-	return t.eq(tokens[cursor])
+	return t.eq(&tokens[cursor])
 }
 
-func helpMessage(tokens []*tok, cursor uint, msg string) {
-	var c *tok
+// helpMessage logs why the token at cursor didn't parse as a debug event.
+// Most of these are alternatives the parser went on to try, so only the
+// last ones before a failed parse tell what went wrong.
+func helpMessage(tokens []Token, cursor uint, msg string) {
+	var c *Token
 	if cursor < uint(len(tokens)) {
-		c = tokens[cursor]
+		c = &tokens[cursor]
 	} else {
-		c = tokens[cursor-1]
+		c = &tokens[cursor-1]
 	}
 
-	fmt.Printf("[%d,%d]: %s, got: %s\n", c.loc.line, c.loc.column, msg, c.value)
+	logging.Default().Log(context.Background(), logging.LevelDebug, "Parse hint",
+		"line", c.Loc.Line, "column", c.Loc.Column, "hint", msg, "got", c.Value)
 }
 
-func Parse(src string) (*AST, error) {
-	tokens, err := tokenize(src)
-	if err != nil {
-		return nil, err
+func parseToken(tokens []Token, initialCursor uint, t Token) (*Token, uint, bool) {
+	if !expectToken(tokens, initialCursor, t) {
+		return nil, initialCursor, false
 	}
 
-	a := AST{}
-	cursor := uint(0)
-	for cursor < uint(len(tokens)) {
-		stmt, newCursor, ok := parseStatement(tokens, cursor, tokenFromPunct(semicolonPunct))
-		if !ok {
-			helpMessage(tokens, cursor, "Expected statement")
-			return nil, errors.New("Failed to parse, expected statement")
-		}
-		cursor = newCursor
+	return &tokens[initialCursor], initialCursor + 1, true
+}
+
+func parseTokenType(tokens []Token, initialCursor uint, tt TokenType) (*Token, uint, bool) {
+	if initialCursor >= uint(len(tokens)) {
+		return nil, initialCursor, false
+	}
+
+	current := &tokens[initialCursor]
+	if current.Type != tt {
+		return nil, initialCursor, false
+	}
+
+	return current, initialCursor + 1, true
+}
+
+func parseLiteralExpression(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	if initialCursor >= uint(len(tokens)) {
+		return nil, initialCursor, false
+	}
+
+	current := &tokens[initialCursor]
+	switch current.Type {
+	case IdentifierType:
+		return &Expression{
+			Column: current,
+			Type:   ColumnRefType,
+			Loc:    current.Loc,
+		}, initialCursor + 1, true
+	case ParameterType:
+		n, err := strconv.ParseUint(current.Value[1:], 10, 32)
+		if err != nil || n == 0 {
+			helpMessage(tokens, initialCursor, "Invalid parameter")
+			return nil, initialCursor, false
+		}
+
+		return &Expression{
+			Param: uint(n),
+			Type:  ParamType,
+			Loc:   current.Loc,
+		}, initialCursor + 1, true
+	case NumericType, StringType, KeywordType:
+		v, ok := valueFromToken(current)
+		if !ok {
+			if current.Type == NumericType {
+				helpMessage(tokens, initialCursor, "Invalid number")
+			}
+			return nil, initialCursor, false
+		}
+
+		return &Expression{
+			Literal: v,
+			Type:    LiteralType,
+			Loc:     current.Loc,
+		}, initialCursor + 1, true
+	}
+
+	return nil, initialCursor, false
+}
+
+// parseTypeName parses the name of a type. Whether the type exists is only
+// checked when the statement is analyzed.
+func parseTypeName(tokens []Token, initialCursor uint) (*Token, uint, bool) {
+	if initialCursor >= uint(len(tokens)) {
+		return nil, initialCursor, false
+	}
+
+	current := &tokens[initialCursor]
+	switch {
+	case current.Type == IdentifierType,
+		current.eq(&Token{Type: KeywordType, Value: string(intKeyword)}),
+		current.eq(&Token{Type: KeywordType, Value: string(textKeyword)}):
+	default:
+		return nil, initialCursor, false
+	}
+
+	// Array types are written type[]
+	if expectToken(tokens, initialCursor+1, tokenFromPunct(leftbracketPunct)) &&
+		expectToken(tokens, initialCursor+2, tokenFromPunct(rightbracketPunct)) {
+		array := *current
+		array.Value += "[]"
+		return &array, initialCursor + 3, true
+	}
+
+	return current, initialCursor + 1, true
+}
+
+// isArrayConstructor reports whether the tokens at cursor start ARRAY[.
+func isArrayConstructor(tokens []Token, cursor uint) bool {
+	return expectToken(tokens, cursor, Token{Type: IdentifierType, Value: "array"}) &&
+		expectToken(tokens, cursor+1, tokenFromPunct(leftbracketPunct))
+}
+
+// parseArrayExpression parses ARRAY[exp, ...].
+func parseArrayExpression(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	cursor := initialCursor
+
+	array, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "array"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftbracketPunct))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	exp := Expression{Array: []Expression{}, Type: ArrayType, Loc: array.Loc}
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(rightbracketPunct)); ok {
+		return &exp, newCursor, true
+	}
+
+	for {
+		elem, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected array element")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		exp.Array = append(exp.Array, *elem)
+
+		if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+			break
+		}
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightbracketPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected closing bracket")
+		return nil, initialCursor, false
+	}
+
+	return &exp, cursor, true
+}
+
+// parseSubquery parses a parenthesized query used as a value.
+func parseSubquery(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	cursor := initialCursor
+
+	paren, cursor, ok := parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok || !expectToken(tokens, cursor, tokenFromKeyword(selectKeyword)) {
+		return nil, initialCursor, false
+	}
+
+	query, cursor, ok := parseSelectStatement(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected subquery")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected closing paren after subquery")
+		return nil, initialCursor, false
+	}
+
+	return &Expression{Subquery: query, Type: SubqueryType, Loc: paren.Loc}, cursor, true
+}
+
+// parseExistsExpression parses [NOT] EXISTS (query).
+func parseExistsExpression(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	cursor := initialCursor
+	if cursor >= uint(len(tokens)) {
+		return nil, initialCursor, false
+	}
+	loc := tokens[cursor].Loc
+
+	not := false
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(notKeyword)); ok {
+		cursor = newCursor
+		not = true
+	}
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(existsKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected opening paren after EXISTS")
+		return nil, initialCursor, false
+	}
+
+	query, cursor, ok := parseSelectStatement(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected query in EXISTS")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected closing paren")
+		return nil, initialCursor, false
+	}
+
+	// The expression is only allocated once it is sure to be one, every
+	// operand is tried as EXISTS first
+	exists := &ExistsExpression{Not: not, Query: query}
+	return &Expression{Exists: exists, Type: ExistsType, Loc: loc}, cursor, true
+}
+
+// parseCastExpression parses CAST(exp AS type).
+func parseCastExpression(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	cursor := initialCursor
+
+	cast, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(castKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected opening paren after CAST")
+		return nil, initialCursor, false
+	}
+
+	exp, cursor, ok := parseExpression(tokens, cursor, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected expression to cast")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(asKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected AS")
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTypeName(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected type name")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected closing paren")
+		return nil, initialCursor, false
+	}
+
+	return &Expression{
+		Cast: &CastExpression{Exp: *exp, Type: *name},
+		Type: CastType,
+		Loc:  cast.Loc,
+	}, cursor, true
+}
+
+// niladic are the functions the standard calls without parentheses.
+var niladic = map[string]bool{
+	"current_date":      true,
+	"current_timestamp": true,
+	"current_user":      true,
+}
+
+// parseExtract parses the rest of EXTRACT(field FROM exp) after the opening
+// paren, which is a call to date_part(field, exp).
+func parseExtract(tokens []Token, initialCursor uint, cursor uint) (*Expression, uint, bool) {
+	if cursor >= uint(len(tokens)) ||
+		(tokens[cursor].Type != IdentifierType && tokens[cursor].Type != StringType) {
+		helpMessage(tokens, cursor, "Expected field to extract")
+		return nil, initialCursor, false
+	}
+	field := tokens[cursor]
+	cursor++
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(fromKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected FROM")
+		return nil, initialCursor, false
+	}
+
+	exp, cursor, ok := parseExpression(tokens, cursor, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected expression to extract from")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected closing paren")
+		return nil, initialCursor, false
+	}
+
+	name := tokens[initialCursor]
+	name.Value = "date_part"
+
+	return &Expression{
+		Call: &CallExpression{
+			Name: name,
+			Args: []Expression{
+				{Literal: &Value{Type: StringValue, String: field.Value}, Type: LiteralType, Loc: field.Loc},
+				*exp,
+			},
+		},
+		Type: CallType,
+		Loc:  name.Loc,
+	}, cursor, true
+}
+
+// parseTypedLiteral parses a type name followed by a string, such as
+// TIMESTAMP '2024-01-01', which casts the string to the type.
+func parseTypedLiteral(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	if initialCursor+1 >= uint(len(tokens)) ||
+		tokens[initialCursor].Type != IdentifierType ||
+		tokens[initialCursor+1].Type != StringType {
+		return nil, initialCursor, false
+	}
+
+	name, str := tokens[initialCursor], tokens[initialCursor+1]
+	return &Expression{
+		Cast: &CastExpression{
+			Exp:  Expression{Literal: &Value{Type: StringValue, String: str.Value}, Type: LiteralType, Loc: str.Loc},
+			Type: name,
+		},
+		Type: CastType,
+		Loc:  name.Loc,
+	}, initialCursor + 2, true
+}
+
+// parseCallExpression parses name(args...).
+func parseCallExpression(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	cursor := initialCursor
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		if niladic[name.Value] {
+			return &Expression{Call: &CallExpression{Name: *name}, Type: CallType, Loc: name.Loc}, cursor, true
+		}
+		return nil, initialCursor, false
+	}
+
+	if name.Value == "extract" {
+		return parseExtract(tokens, initialCursor, cursor)
+	}
+
+	call := CallExpression{Name: *name}
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(rightparenPunct)); ok {
+		return &Expression{Call: &call, Type: CallType, Loc: name.Loc}, newCursor, true
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(asteriskPunct)); ok {
+		_, newCursor, ok = parseToken(tokens, newCursor, tokenFromPunct(rightparenPunct))
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected closing paren after *")
+			return nil, initialCursor, false
+		}
+
+		call.Star = true
+		return &Expression{Call: &call, Type: CallType, Loc: name.Loc}, newCursor, true
+	}
+
+	for {
+		arg, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected function argument")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		call.Args = append(call.Args, *arg)
+
+		if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+			break
+		}
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected closing paren")
+		return nil, initialCursor, false
+	}
+
+	return &Expression{Call: &call, Type: CallType, Loc: name.Loc}, cursor, true
+}
+
+// bindingPower returns how tightly a binary operator binds, zero means the
+// token is not a binary operator at all.
+func (t *Token) bindingPower() uint {
+	switch t.Type {
+	case KeywordType:
+		switch keyword(t.Value) {
+		case orKeyword:
+			return 1
+		case andKeyword:
+			return 2
+		case likeKeyword, ilikeKeyword, regexpKeyword, inKeyword, notKeyword:
+			return 3
+		}
+	case SymbolType:
+		switch punct(t.Value) {
+		case eqPunct, neqPunct, bangNeqPunct, ltPunct, ltePunct, gtPunct, gtePunct,
+			matchPunct, imatchPunct, notMatchPunct, notImatchPunct:
+			return 3
+		case plusPunct, minusPunct, concatPunct:
+			return 4
+		case asteriskPunct, slashPunct:
+			return 5
+		}
+	}
+
+	return 0
+}
+
+// parseExpressionList parses one or more comma separated expressions
+// followed by a closing paren.
+func parseExpressionList(tokens []Token, initialCursor uint) ([]Expression, uint, bool) {
+	cursor := initialCursor
+
+	list := []Expression{}
+	for {
+		exp, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		list = append(list, *exp)
+
+		if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(commaPunct)); ok {
+			cursor = newCursor
+			continue
+		}
+
+		_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+		if !ok {
+			helpMessage(tokens, cursor, "Expected closing paren")
+			return nil, initialCursor, false
+		}
+
+		return list, cursor, true
+	}
+}
+
+// parseInExpression parses [NOT] IN (list) or [NOT] IN (query) testing exp.
+func parseInExpression(tokens []Token, initialCursor uint, exp *Expression) (*Expression, uint, bool) {
+	cursor := initialCursor
+
+	in := InExpression{Exp: *exp}
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(notKeyword)); ok {
+		cursor = newCursor
+		in.Not = true
+	}
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(inKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected IN")
+		return nil, initialCursor, false
+	}
+
+	if query, newCursor, ok := parseSubquery(tokens, cursor); ok {
+		in.List, in.Subquery = []Expression{*query}, true
+		return &Expression{In: &in, Type: InType, Loc: exp.Loc}, newCursor, true
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected opening paren after IN")
+		return nil, initialCursor, false
+	}
+
+	list, cursor, ok := parseExpressionList(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected expressions in IN list")
+		return nil, initialCursor, false
+	}
+	in.List = list
+
+	return &Expression{In: &in, Type: InType, Loc: exp.Loc}, cursor, true
+}
+
+// parseBetweenExpression parses [NOT] BETWEEN low AND high testing exp.
+// Like IN it binds like a comparison. It is rewritten into the comparisons
+// it stands for, exp >= low AND exp <= high, or exp < low OR exp > high
+// when negated, which evaluates exp twice.
+func parseBetweenExpression(tokens []Token, initialCursor uint, exp *Expression) (*Expression, uint, bool) {
+	cursor := initialCursor
+
+	not, newCursor, negated := parseToken(tokens, cursor, tokenFromKeyword(notKeyword))
+	if negated {
+		cursor = newCursor
+	}
+
+	between, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "between"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	low, cursor, ok := parseExpression(tokens, cursor, 3)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected lower bound after BETWEEN")
+		return nil, initialCursor, false
+	}
+
+	and, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(andKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected AND after lower bound of BETWEEN")
+		return nil, initialCursor, false
+	}
+
+	high, cursor, ok := parseExpression(tokens, cursor, 3)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected upper bound after BETWEEN")
+		return nil, initialCursor, false
+	}
+
+	compare := func(op punct, bound *Expression) Expression {
+		return Expression{
+			Binary: &BinaryExpression{A: *exp, B: *bound, Op: Token{Type: SymbolType, Value: string(op), Loc: between.Loc}},
+			Type:   BinaryType,
+			Loc:    exp.Loc,
+		}
+	}
+
+	lowOp, highOp, join := gtePunct, ltePunct, *and
+	if negated {
+		lowOp, highOp = ltPunct, gtPunct
+		join = Token{Type: KeywordType, Value: string(orKeyword), Loc: not.Loc}
+	}
+
+	return &Expression{
+		Binary: &BinaryExpression{A: compare(lowOp, low), B: compare(highOp, high), Op: join},
+		Type:   BinaryType,
+		Loc:    exp.Loc,
+	}, cursor, true
+}
+
+// parseExpression parses operands joined by binary operators binding tighter
+// than minBp, stopping at the first token that can't continue the expression.
+func parseExpression(tokens []Token, initialCursor uint, minBp uint) (*Expression, uint, bool) {
+	cursor := initialCursor
+
+	var exp *Expression
+	if sub, newCursor, ok := parseSubquery(tokens, cursor); ok {
+		exp, cursor = sub, newCursor
+	} else if paren, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(leftparenPunct)); ok {
+		list, newCursor, ok := parseExpressionList(tokens, newCursor)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected expression after opening paren")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		// A parenthesized list of more than one expression is a row value
+		exp = &list[0]
+		if len(list) > 1 {
+			exp = &Expression{Row: list, Type: RowType, Loc: paren.Loc}
+		}
+	} else if minus, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(minusPunct)); ok {
+		// Negation binds tighter than any binary operator and is evaluated as
+		// subtracting from zero
+		operand, newCursor, ok := parseExpression(tokens, newCursor, 5)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected operand after -")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		exp = &Expression{
+			Binary: &BinaryExpression{
+				A:  Expression{Literal: &Value{Type: Int64Value}, Type: LiteralType, Loc: minus.Loc},
+				B:  *operand,
+				Op: *minus,
+			},
+			Type: BinaryType,
+			Loc:  minus.Loc,
+		}
+
+		// The smallest integer has no positive integer to negate, its
+		// number alone is a float
+		if num := tokens[newCursor-1]; newCursor == initialCursor+2 && num.Type == NumericType && operand.Type == LiteralType {
+			if i, err := strconv.ParseInt("-"+num.Value, 10, 64); err == nil && i == math.MinInt64 {
+				exp = &Expression{Literal: &Value{Type: Int64Value, Int64: i}, Type: LiteralType, Loc: minus.Loc}
+			}
+		}
+	} else if exists, newCursor, ok := parseExistsExpression(tokens, cursor); ok {
+		exp, cursor = exists, newCursor
+	} else if not, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(notKeyword)); ok {
+		// NOT binds looser than comparisons but tighter than AND and OR
+		operand, newCursor, ok := parseExpression(tokens, newCursor, 2)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected operand after NOT")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		exp = &Expression{Not: operand, Type: NotType, Loc: not.Loc}
+	} else if cast, newCursor, ok := parseCastExpression(tokens, cursor); ok {
+		exp, cursor = cast, newCursor
+	} else if isArrayConstructor(tokens, cursor) {
+		// Past ARRAY [ the tokens can't be anything else: indexing a column
+		// called array parses wherever the array does. Trying the other
+		// alternatives on them anyway takes exponential time when arrays
+		// nest
+		array, newCursor, ok := parseArrayExpression(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		exp, cursor = array, newCursor
+	} else if call, newCursor, ok := parseCallExpression(tokens, cursor); ok {
+		exp, cursor = call, newCursor
+	} else if lit, newCursor, ok := parseTypedLiteral(tokens, cursor); ok {
+		exp, cursor = lit, newCursor
+	} else {
+		exp, cursor, ok = parseLiteralExpression(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+	}
+
+	for cursor < uint(len(tokens)) {
+		op := &tokens[cursor]
+
+		// Indexing and :: are postfix and bind tighter than any binary
+		// operator
+		if op.eq(&Token{Type: SymbolType, Value: string(leftbracketPunct)}) {
+			index, newCursor, ok := parseExpression(tokens, cursor+1, 0)
+			if !ok {
+				helpMessage(tokens, cursor+1, "Expected index")
+				return nil, initialCursor, false
+			}
+
+			_, newCursor, ok = parseToken(tokens, newCursor, tokenFromPunct(rightbracketPunct))
+			if !ok {
+				helpMessage(tokens, newCursor, "Expected closing bracket")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			exp = &Expression{
+				Index: &IndexExpression{Exp: *exp, Index: *index},
+				Type:  IndexType,
+				Loc:   exp.Loc,
+			}
+			continue
+		}
+
+		if op.eq(&Token{Type: SymbolType, Value: string(castPunct)}) {
+			name, newCursor, ok := parseTypeName(tokens, cursor+1)
+			if !ok {
+				helpMessage(tokens, cursor+1, "Expected type name after ::")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+
+			exp = &Expression{
+				Cast: &CastExpression{Exp: *exp, Type: *name},
+				Type: CastType,
+				Loc:  exp.Loc,
+			}
+			continue
+		}
+
+		if between, newCursor, ok := parseBetweenExpression(tokens, cursor, exp); ok {
+			if minBp >= 3 {
+				break
+			}
+			exp, cursor = between, newCursor
+			continue
+		}
+
+		bp := op.bindingPower()
+		if bp == 0 || bp <= minBp {
+			break
+		}
+
+		if op.Type == KeywordType && (op.Value == string(inKeyword) || op.Value == string(notKeyword)) {
+			in, newCursor, ok := parseInExpression(tokens, cursor, exp)
+			if !ok {
+				return nil, initialCursor, false
+			}
+			exp, cursor = in, newCursor
+			continue
+		}
+
+		b, newCursor, ok := parseExpression(tokens, cursor+1, bp)
+		if !ok {
+			helpMessage(tokens, cursor+1, "Expected right operand")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		exp = &Expression{
+			Binary: &BinaryExpression{
+				A:  *exp,
+				B:  *b,
+				Op: *op,
+			},
+			Type: BinaryType,
+			Loc:  exp.Loc,
+		}
+	}
+
+	return exp, cursor, true
+}
+
+func parseSelectItem(tokens []Token, initialCursor uint) (*SelectItem, uint, bool) {
+	cursor := initialCursor
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(asteriskPunct)); ok {
+		return &SelectItem{Asterisk: true}, newCursor, true
+	}
+
+	exp, cursor, ok := parseExpression(tokens, cursor, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected expression")
+		return nil, initialCursor, false
+	}
+
+	si := SelectItem{Exp: exp}
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(asKeyword)); ok {
+		cursor = newCursor
+
+		si.As, cursor, ok = parseTokenType(tokens, cursor, IdentifierType)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected identifier after AS")
+			return nil, initialCursor, false
+		}
+	}
+
+	return &si, cursor, true
+}
+
+// parseHints parses the hints in a hint comment, each a name optionally
+// followed by arguments in parens.
+func parseHints(comment *Token) ([]Hint, bool) {
+	tokens, err := tokenizeFrom(comment.Value, comment.Loc)
+	if err != nil {
+		helpMessage([]Token{*comment}, 0, "Invalid hint: "+err.Error())
+		return nil, false
+	}
+	for i := range tokens {
+		tokens[i].Loc.Offset += comment.Loc.Offset
+	}
+
+	hints := []Hint{}
+	cursor := uint(0)
+	for cursor < uint(len(tokens)) {
+		name := tokens[cursor]
+		if name.Type != IdentifierType && name.Type != KeywordType {
+			helpMessage(tokens, cursor, "Expected hint name")
+			return nil, false
+		}
+		cursor++
+
+		hint := Hint{Name: name}
+		if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(leftparenPunct)); ok {
+			cursor = newCursor
+			for {
+				if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(rightparenPunct)); ok {
+					cursor = newCursor
+					break
+				}
+
+				arg, newCursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+				if !ok {
+					helpMessage(tokens, cursor, "Expected hint argument")
+					return nil, false
+				}
+				cursor = newCursor
+				hint.Args = append(hint.Args, *arg)
+
+				if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(commaPunct)); ok {
+					cursor = newCursor
+				}
+			}
+		}
+
+		hints = append(hints, hint)
+	}
+
+	return hints, true
+}
+
+func parseSelectStatement(tokens []Token, initialCursor uint) (*SelectStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(selectKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	slct := SelectStatement{}
+	if hint, newCursor, ok := parseTokenType(tokens, cursor, HintType); ok {
+		hints, ok := parseHints(hint)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		slct.Hints = hints
+	}
+
+	for {
+		item, newCursor, ok := parseSelectItem(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		slct.Item = append(slct.Item, item)
+
+		if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+			break
+		}
+		cursor = newCursor
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(fromKeyword)); ok {
+		cursor = newCursor
+
+		if expectToken(tokens, cursor+1, tokenFromPunct(leftparenPunct)) {
+			slct.Function, cursor, ok = parseCallExpression(tokens, cursor)
+		} else {
+			slct.From, cursor, ok = parseTokenType(tokens, cursor, IdentifierType)
+		}
+		if !ok {
+			helpMessage(tokens, cursor, "Expected table name after FROM")
+			return nil, initialCursor, false
+		}
+
+		if slct.From != nil {
+			if slct.AsOf, cursor, ok = parseAsOf(tokens, cursor); !ok {
+				return nil, initialCursor, false
+			}
+		}
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(whereKeyword)); ok {
+		cursor = newCursor
+
+		slct.Where, cursor, ok = parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected WHERE conditionals")
+			return nil, initialCursor, false
+		}
+	}
+
+	if slct.From != nil {
+		if connect, newCursor, ok := parseConnectBy(tokens, cursor); ok {
+			slct.ConnectBy, cursor = connect, newCursor
+		}
+	}
+
+	if slct.OrderBy, cursor, ok = parseOrderBy(tokens, cursor); !ok {
+		return nil, initialCursor, false
+	}
+
+	if slct.Limit, slct.Offset, cursor, ok = parseLimit(tokens, cursor); !ok {
+		return nil, initialCursor, false
+	}
+
+	return &slct, cursor, true
+}
+
+// parseOrderBy parses ORDER BY and its items, each optionally followed by
+// ASC or DESC, returning nil when there is no ORDER BY. None of the words
+// are reserved, so they are matched as identifiers.
+func parseOrderBy(tokens []Token, initialCursor uint) ([]*OrderItem, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "order"})
+	if !ok {
+		return nil, initialCursor, true
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "by"}); !ok {
+		helpMessage(tokens, cursor, "Expected BY")
+		return nil, initialCursor, false
+	}
+
+	items := []*OrderItem{}
+	for {
+		exp, newCursor, ok := parseExpression(tokens, cursor, 0)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected ORDER BY expression")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		item := &OrderItem{Exp: exp}
+		if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "desc"}); ok {
+			item.Desc, cursor = true, newCursor
+		} else if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "asc"}); ok {
+			cursor = newCursor
+		}
+		items = append(items, item)
+
+		if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+			break
+		}
+		cursor = newCursor
+	}
+
+	return items, cursor, true
+}
+
+// parseLimit parses LIMIT count and OFFSET skip, either of which may be
+// left out. LIMIT and OFFSET aren't reserved, so they are matched as
+// identifiers.
+func parseLimit(tokens []Token, initialCursor uint) (*Expression, *Expression, uint, bool) {
+	cursor := initialCursor
+
+	var limit, offset *Expression
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "limit"}); ok {
+		if limit, cursor, ok = parseExpression(tokens, newCursor, 0); !ok {
+			helpMessage(tokens, newCursor, "Expected LIMIT count")
+			return nil, nil, initialCursor, false
+		}
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "offset"}); ok {
+		if offset, cursor, ok = parseExpression(tokens, newCursor, 0); !ok {
+			helpMessage(tokens, newCursor, "Expected OFFSET count")
+			return nil, nil, initialCursor, false
+		}
+	}
+
+	return limit, offset, cursor, true
+}
+
+// parseAsOf parses the AS OF TIMESTAMP time of a table, returning nil when
+// the table isn't followed by AS. OF and TIMESTAMP aren't reserved, so they
+// are matched as identifiers.
+func parseAsOf(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(asKeyword))
+	if !ok {
+		return nil, initialCursor, true
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "of"}); !ok {
+		helpMessage(tokens, cursor, "Expected OF")
+		return nil, initialCursor, false
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "timestamp"}); !ok {
+		helpMessage(tokens, cursor, "Expected TIMESTAMP")
+		return nil, initialCursor, false
+	}
+
+	asOf, cursor, ok := parseExpression(tokens, cursor, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected the time to read the table as of")
+		return nil, initialCursor, false
+	}
+
+	return asOf, cursor, true
+}
+
+// parseConnectBy parses [START WITH condition] CONNECT BY [NOCYCLE] PRIOR
+// column = column, where PRIOR can also come after the equals sign. None of
+// the words are reserved, so they are matched as identifiers.
+func parseConnectBy(tokens []Token, initialCursor uint) (*ConnectBy, uint, bool) {
+	cursor := initialCursor
+
+	var start *Expression
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "start"}); ok {
+		cursor = newCursor
+		if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "with"}); !ok {
+			helpMessage(tokens, cursor, "Expected WITH")
+			return nil, initialCursor, false
+		}
+
+		if start, cursor, ok = parseExpression(tokens, cursor, 0); !ok {
+			helpMessage(tokens, cursor, "Expected START WITH condition")
+			return nil, initialCursor, false
+		}
+	}
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "connect"})
+	if !ok {
+		if start != nil {
+			helpMessage(tokens, cursor, "Expected CONNECT BY")
+		}
+		return nil, initialCursor, false
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "by"}); !ok {
+		helpMessage(tokens, cursor, "Expected BY")
+		return nil, initialCursor, false
+	}
+
+	connect := &ConnectBy{Start: start}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "nocycle"}); ok {
+		connect.NoCycle, cursor = true, newCursor
+	}
+
+	// column parses a side of the equality, reporting whether it was PRIOR
+	prior := Token{Type: IdentifierType, Value: "prior"}
+	column := func(cursor uint) (*Token, bool, uint, bool) {
+		_, newCursor, isPrior := parseToken(tokens, cursor, prior)
+		col, newCursor, ok := parseTokenType(tokens, newCursor, IdentifierType)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected column name")
+			return nil, false, cursor, false
+		}
+		return col, isPrior, newCursor, true
+	}
+
+	a, aPrior, cursor, ok := column(cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(eqPunct)); !ok {
+		helpMessage(tokens, cursor, "Expected =")
+		return nil, initialCursor, false
+	}
+
+	b, bPrior, cursor, ok := column(cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	switch {
+	case aPrior && !bPrior:
+		connect.Prior, connect.Child = *a, *b
+	case bPrior && !aPrior:
+		connect.Prior, connect.Child = *b, *a
+	default:
+		helpMessage(tokens, cursor, "Expected PRIOR on one side of CONNECT BY")
+		return nil, initialCursor, false
+	}
+
+	return connect, cursor, true
+}
+
+func parseInsertStatement(tokens []Token, initialCursor uint) (*InsertStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(insertKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(intoKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected into")
+		return nil, initialCursor, false
+	}
+
+	table, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	overriding, cursor, ok := parseOverriding(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(valuesKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected VALUES")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+
+	values := []*Expression{}
+	for {
+		exp, newCursor, ok := parseDefault(tokens, cursor)
+		if !ok {
+			exp, newCursor, ok = parseExpression(tokens, cursor, 0)
+		}
+		if !ok {
+			helpMessage(tokens, cursor, "Expected expression")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		values = append(values, exp)
+
+		if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+			break
+		}
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	return &InsertStatement{
+		Table:      *table,
+		Overriding: overriding,
+		Values:     &values,
+	}, cursor, true
+}
+
+// parseOverriding parses the optional OVERRIDING SYSTEM VALUE or OVERRIDING
+// USER VALUE of INSERT, returning a nil token when it isn't there.
+func parseOverriding(tokens []Token, initialCursor uint) (*Token, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "overriding"})
+	if !ok {
+		return nil, initialCursor, true
+	}
+
+	kind, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok || (kind.Value != "system" && kind.Value != "user") {
+		helpMessage(tokens, cursor, "Expected SYSTEM or USER")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "value"})
+	if !ok {
+		helpMessage(tokens, cursor, "Expected VALUE")
+		return nil, initialCursor, false
+	}
+
+	return kind, cursor, true
+}
+
+// parseDefault parses DEFAULT standing alone among the values of INSERT.
+// DEFAULT isn't reserved, so it is only taken to be one when nothing else
+// follows it in the value.
+func parseDefault(tokens []Token, initialCursor uint) (*Expression, uint, bool) {
+	def, cursor, ok := parseToken(tokens, initialCursor, Token{Type: IdentifierType, Value: "default"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	if !expectToken(tokens, cursor, tokenFromPunct(commaPunct)) && !expectToken(tokens, cursor, tokenFromPunct(rightparenPunct)) {
+		return nil, initialCursor, false
+	}
+
+	return &Expression{Type: DefaultType, Loc: def.Loc}, cursor, true
+}
+
+func parseColumnDefinitions(tokens []Token, initialCursor uint) (*[]*ColumnDefinition, uint, bool) {
+	cursor := initialCursor
+
+	cds := []*ColumnDefinition{}
+	for {
+		cd, newCursor, ok := parseColumnDefinition(tokens, cursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		cds = append(cds, cd)
+
+		if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+			break
+		}
+		cursor = newCursor
+	}
+
+	return &cds, cursor, true
+}
+
+// parseColumnDefinition parses a column name followed by its type, an
+// optional collation, an optional identity and then REFERENCES and UNIQUE,
+// each at most once and in either order.
+func parseColumnDefinition(tokens []Token, initialCursor uint) (*ColumnDefinition, uint, bool) {
+	cursor := initialCursor
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected column name")
+		return nil, initialCursor, false
+	}
+
+	cd, cursor, ok := parseColumnType(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	cd.Name = *name
+
+	cd.Identity, cursor, ok = parseColumnIdentity(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	for {
+		switch {
+		case cd.References == nil && expectToken(tokens, cursor, Token{Type: IdentifierType, Value: "references"}):
+			cd.References, cursor, ok = parseReferences(tokens, cursor)
+		case cd.Unique == nil && expectToken(tokens, cursor, Token{Type: IdentifierType, Value: "unique"}):
+			cd.Unique = &Unique{}
+			cd.Unique.Deferred, cursor, ok = parseDeferrable(tokens, cursor+1)
+		default:
+			return cd, cursor, true
+		}
+		if !ok {
+			return nil, initialCursor, false
+		}
+	}
+}
+
+// parseDeferrable parses when a constraint is checked, [NOT] DEFERRABLE
+// followed by INITIALLY DEFERRED or INITIALLY IMMEDIATE, each optional. It
+// reports whether the constraint is checked once the transaction commits,
+// which only INITIALLY DEFERRED does. None of the words are reserved.
+func parseDeferrable(tokens []Token, initialCursor uint) (bool, uint, bool) {
+	cursor := initialCursor
+
+	deferrable := true
+	if expectToken(tokens, cursor, tokenFromKeyword(notKeyword)) &&
+		expectToken(tokens, cursor+1, Token{Type: IdentifierType, Value: "deferrable"}) {
+		deferrable, cursor = false, cursor+1
+	}
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "deferrable"}); ok {
+		cursor = newCursor
+	}
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "initially"})
+	if !ok {
+		return false, cursor, true
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "immediate"}); ok {
+		return false, newCursor, true
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "deferred"})
+	if !ok {
+		helpMessage(tokens, cursor, "Expected DEFERRED or IMMEDIATE")
+		return false, initialCursor, false
+	}
+	if !deferrable {
+		helpMessage(tokens, cursor, "Constraints that aren't DEFERRABLE can't be INITIALLY DEFERRED")
+		return false, initialCursor, false
+	}
+
+	return true, cursor, true
+}
+
+// parseReferences parses REFERENCES table (column) and when the key is
+// checked, see parseDeferrable. It returns nil when there is no REFERENCES,
+// which isn't reserved and so is matched as an identifier.
+func parseReferences(tokens []Token, initialCursor uint) (*ForeignKey, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "references"})
+	if !ok {
+		return nil, initialCursor, true
+	}
+
+	table, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	column, cursor, ok := parseParenthesizedColumn(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	deferred, cursor, ok := parseDeferrable(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	return &ForeignKey{Table: *table, Column: *column, Deferred: deferred}, cursor, true
+}
+
+// parseParenthesizedColumn parses a column name in parentheses.
+func parseParenthesizedColumn(tokens []Token, initialCursor uint) (*Token, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+
+	column, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected column name")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	return column, cursor, true
+}
+
+// parseTableConstraint parses FOREIGN KEY (column) REFERENCES table
+// (column) or UNIQUE (column), either followed by when it is checked, see
+// parseDeferrable. None of FOREIGN, KEY and UNIQUE is reserved, so they are
+// matched as identifiers.
+func parseTableConstraint(tokens []Token, initialCursor uint) (*TableConstraint, uint, bool) {
+	cursor := initialCursor
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "unique"}); ok {
+		column, cursor, ok := parseParenthesizedColumn(tokens, newCursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+
+		unique := Unique{}
+		if unique.Deferred, cursor, ok = parseDeferrable(tokens, cursor); !ok {
+			return nil, initialCursor, false
+		}
+
+		return &TableConstraint{Column: *column, Unique: &unique}, cursor, true
+	}
+
+	for _, want := range []Token{{Type: IdentifierType, Value: "foreign"}, {Type: IdentifierType, Value: "key"}} {
+		var ok bool
+		if _, cursor, ok = parseToken(tokens, cursor, want); !ok {
+			helpMessage(tokens, cursor, "Expected FOREIGN KEY")
+			return nil, initialCursor, false
+		}
+	}
+
+	column, cursor, ok := parseParenthesizedColumn(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	references, cursor, ok := parseReferences(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+	if references == nil {
+		helpMessage(tokens, cursor, "Expected REFERENCES")
+		return nil, initialCursor, false
+	}
+
+	return &TableConstraint{Column: *column, References: references}, cursor, true
+}
+
+// parseColumnIdentity parses GENERATED ALWAYS AS IDENTITY or GENERATED BY
+// DEFAULT AS IDENTITY, optionally followed by the START and INCREMENT of a
+// sequence in parentheses. It returns nil when there is no GENERATED.
+func parseColumnIdentity(tokens []Token, initialCursor uint) (*ColumnIdentity, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "generated"})
+	if !ok {
+		return nil, initialCursor, true
+	}
+
+	identity := ColumnIdentity{Increment: 1}
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "always"}); ok {
+		identity.Always, cursor = true, newCursor
+	} else {
+		for _, want := range []Token{{Type: IdentifierType, Value: "by"}, {Type: IdentifierType, Value: "default"}} {
+			if _, cursor, ok = parseToken(tokens, cursor, want); !ok {
+				helpMessage(tokens, cursor, "Expected ALWAYS or BY DEFAULT")
+				return nil, initialCursor, false
+			}
+		}
+	}
+
+	for _, want := range []Token{tokenFromKeyword(asKeyword), {Type: IdentifierType, Value: "identity"}} {
+		if _, cursor, ok = parseToken(tokens, cursor, want); !ok {
+			helpMessage(tokens, cursor, "Expected AS IDENTITY")
+			return nil, initialCursor, false
+		}
+	}
+
+	_, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		return &identity, cursor, true
+	}
+	cursor = newCursor
+
+	for {
+		option, n, newCursor, ok := parseSequenceOption(tokens, cursor)
+		if !ok {
+			break
+		}
+		cursor = newCursor
+
+		switch option {
+		case "start":
+			identity.Start = &n
+		case "increment":
+			identity.Increment = n
+		default:
+			helpMessage(tokens, cursor, "Expected START or INCREMENT")
+			return nil, initialCursor, false
+		}
+	}
+
+	if identity.Increment == 0 {
+		helpMessage(tokens, cursor, "INCREMENT must not be zero")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	return &identity, cursor, true
+}
+
+// parseColumnType parses a type followed by an optional collation, into a
+// definition without a column name.
+func parseColumnType(tokens []Token, initialCursor uint) (*ColumnDefinition, uint, bool) {
+	cursor := initialCursor
+
+	datatype, cursor, ok := parseTypeName(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected column type")
+		return nil, initialCursor, false
+	}
+
+	cd := &ColumnDefinition{Datatype: *datatype}
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(collateKeyword)); ok {
+		cd.Collate, cursor, ok = parseTokenType(tokens, newCursor, IdentifierType)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected collation name")
+			return nil, initialCursor, false
+		}
+	}
+
+	return cd, cursor, true
+}
+
+// parseDropTableStatement parses DROP TABLE name and DROP MATERIALIZED VIEW
+// name, either followed by CASCADE or RESTRICT. Neither is reserved.
+func parseDropTableStatement(tokens []Token, initialCursor uint) (*DropTableStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(dropKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	drop := DropTableStatement{}
+	if newCursor, ok := parseMaterializedView(tokens, cursor); ok {
+		drop.View, cursor = true, newCursor
+	} else if _, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(tableKeyword)); !ok {
+		helpMessage(tokens, cursor, "Expected TABLE")
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	drop.Name = *name
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "cascade"}); ok {
+		drop.Cascade, cursor = true, newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "restrict"}); ok {
+		cursor = newCursor
+	}
+
+	return &drop, cursor, true
+}
+
+// parseMaterializedView parses MATERIALIZED VIEW. Neither word is reserved,
+// so they are matched as identifiers.
+func parseMaterializedView(tokens []Token, initialCursor uint) (uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "materialized"})
+	if !ok {
+		return initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "view"})
+	if !ok {
+		helpMessage(tokens, cursor, "Expected VIEW")
+		return initialCursor, false
+	}
+
+	return cursor, true
+}
+
+// parseCreateMaterializedViewStatement parses CREATE MATERIALIZED VIEW name
+// AS select.
+func parseCreateMaterializedViewStatement(tokens []Token, initialCursor uint) (*CreateMaterializedViewStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(createKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	cursor, ok = parseMaterializedView(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected view name")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(asKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected AS")
+		return nil, initialCursor, false
+	}
+
+	slct, cursor, ok := parseSelectStatement(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected SELECT statement")
+		return nil, initialCursor, false
+	}
+
+	return &CreateMaterializedViewStatement{Name: *name, Query: slct}, cursor, true
+}
+
+// parseRefreshStatement parses REFRESH MATERIALIZED VIEW name. REFRESH isn't
+// reserved, so it is matched as an identifier.
+func parseRefreshStatement(tokens []Token, initialCursor uint) (*RefreshStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "refresh"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	cursor, ok = parseMaterializedView(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected MATERIALIZED VIEW")
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected view name")
+		return nil, initialCursor, false
+	}
+
+	return &RefreshStatement{Name: *name}, cursor, true
+}
+
+// parseAlterTableStatement parses ALTER TABLE name followed by one of
+// ADD [COLUMN] definition, ADD FOREIGN KEY or ADD UNIQUE constraint, DROP
+// [COLUMN] name and ALTER [COLUMN] name TYPE type. ADD, COLUMN and TYPE
+// aren't reserved, so they are matched as identifiers.
+func parseAlterTableStatement(tokens []Token, initialCursor uint) (*AlterTableStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(alterKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(tableKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected TABLE")
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	alter := AlterTableStatement{Table: *name}
+	action := cursor
+	if action < uint(len(tokens)) {
+		cursor++
+	}
+
+	column := Token{Type: IdentifierType, Value: "column"}
+	explicit := expectToken(tokens, cursor, column)
+	if explicit {
+		cursor++
+	}
+
+	switch {
+	case expectToken(tokens, action, Token{Type: IdentifierType, Value: "add"}) && !explicit &&
+		(expectToken(tokens, cursor, Token{Type: IdentifierType, Value: "foreign"}) &&
+			expectToken(tokens, cursor+1, Token{Type: IdentifierType, Value: "key"}) ||
+			expectToken(tokens, cursor, Token{Type: IdentifierType, Value: "unique"}) &&
+				expectToken(tokens, cursor+1, tokenFromPunct(leftparenPunct))):
+		alter.Constraint, cursor, ok = parseTableConstraint(tokens, cursor)
+	case expectToken(tokens, action, Token{Type: IdentifierType, Value: "add"}):
+		alter.Add, cursor, ok = parseColumnDefinition(tokens, cursor)
+	case expectToken(tokens, action, tokenFromKeyword(dropKeyword)):
+		alter.Drop, cursor, ok = parseTokenType(tokens, cursor, IdentifierType)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected column name")
+		}
+	case expectToken(tokens, action, tokenFromKeyword(alterKeyword)):
+		var col *Token
+		if col, cursor, ok = parseTokenType(tokens, cursor, IdentifierType); !ok {
+			helpMessage(tokens, cursor, "Expected column name")
+			break
+		}
+
+		if newCursor, masked := parseMasked(tokens, cursor, "set"); masked {
+			cursor = newCursor
+			if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "with"}); !ok {
+				helpMessage(tokens, cursor, "Expected WITH")
+				break
+			}
+
+			alter.Mask = &ColumnMask{Column: *col}
+			if alter.Mask.Function, cursor, ok = parseCallExpression(tokens, cursor); !ok {
+				helpMessage(tokens, cursor, "Expected masking function")
+			}
+			break
+		}
+
+		if newCursor, masked := parseMasked(tokens, cursor, "drop"); masked {
+			alter.Mask, cursor = &ColumnMask{Column: *col}, newCursor
+			break
+		}
+
+		if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "type"}); !ok {
+			helpMessage(tokens, cursor, "Expected TYPE, SET MASKED or DROP MASKED")
+			break
+		}
+
+		if alter.Alter, cursor, ok = parseColumnType(tokens, cursor); ok {
+			alter.Alter.Name = *col
+		}
+	case expectToken(tokens, action, Token{Type: IdentifierType, Value: "rename"}):
+		alter.Rename, cursor, ok = parseRename(tokens, cursor, explicit)
+	default:
+		helpMessage(tokens, action, "Expected ADD, DROP, ALTER or RENAME")
+		ok = false
+	}
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	return &alter, cursor, true
+}
+
+// parseRename parses what follows RENAME [COLUMN], either TO name or column
+// TO name. Without COLUMN a column can still be called to, RENAME to TO name
+// renames it. Neither RENAME nor TO is reserved, so they are matched as
+// identifiers.
+func parseRename(tokens []Token, initialCursor uint, column bool) (*Rename, uint, bool) {
+	cursor := initialCursor
+	to := Token{Type: IdentifierType, Value: "to"}
+
+	first, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected TO or column name")
+		return nil, initialCursor, false
+	}
+
+	rename := Rename{}
+	if column || !to.eq(first) || expectToken(tokens, cursor, to) {
+		rename.Column = first
+		if _, cursor, ok = parseToken(tokens, cursor, to); !ok {
+			helpMessage(tokens, cursor, "Expected TO")
+			return nil, initialCursor, false
+		}
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected new name")
+		return nil, initialCursor, false
+	}
+	rename.To = *name
+
+	return &rename, cursor, true
+}
+
+// parseMasked parses SET MASKED or DROP MASKED, with verb being set or drop.
+// Neither SET nor MASKED is reserved, so they are matched as identifiers.
+func parseMasked(tokens []Token, initialCursor uint, verb string) (uint, bool) {
+	cursor := initialCursor
+
+	want := Token{Type: IdentifierType, Value: verb}
+	if verb == "drop" {
+		want = tokenFromKeyword(dropKeyword)
+	}
+
+	_, cursor, ok := parseToken(tokens, cursor, want)
+	if !ok {
+		return initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "masked"})
+	if !ok {
+		return initialCursor, false
+	}
+
+	return cursor, true
+}
+
+func parseCreateTableStatement(tokens []Token, initialCursor uint) (*CreateTableStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(createKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	// EXTERNAL isn't reserved, so it is matched as an identifier
+	_, cursor, external := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "external"})
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(tableKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+
+	cols, cursor, ok := parseColumnDefinitions(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	crt := CreateTableStatement{
+		Name: *name,
+		Cols: cols,
+	}
+	if !external {
+		if crt.Compression, cursor, ok = parseCompression(tokens, cursor); !ok {
+			return nil, initialCursor, false
+		}
+		return &crt, cursor, true
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "location"}); !ok {
+		helpMessage(tokens, cursor, "Expected LOCATION")
+		return nil, initialCursor, false
+	}
+
+	if crt.Location, cursor, ok = parseTokenType(tokens, cursor, StringType); !ok {
+		helpMessage(tokens, cursor, "Expected file name after LOCATION")
+		return nil, initialCursor, false
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "format"}); ok {
+		if crt.Format, cursor, ok = parseTokenType(tokens, newCursor, IdentifierType); !ok {
+			helpMessage(tokens, newCursor, "Expected format name after FORMAT")
+			return nil, initialCursor, false
+		}
+	}
+
+	return &crt, cursor, true
+}
+
+// parseCompression parses the WITH (compression = 'name') following the
+// columns of CREATE TABLE, if there is one. None of the words are
+// reserved, so they are matched as identifiers.
+func parseCompression(tokens []Token, initialCursor uint) (*Token, uint, bool) {
+	_, cursor, ok := parseToken(tokens, initialCursor, Token{Type: IdentifierType, Value: "with"})
+	if !ok {
+		return nil, initialCursor, true
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct)); !ok {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "compression"}); !ok {
+		helpMessage(tokens, cursor, "Expected COMPRESSION")
+		return nil, initialCursor, false
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(eqPunct)); !ok {
+		helpMessage(tokens, cursor, "Expected =")
+		return nil, initialCursor, false
+	}
+
+	compression, cursor, ok := parseTokenType(tokens, cursor, StringType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected compressor name as a string")
+		return nil, initialCursor, false
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct)); !ok {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	return compression, cursor, true
+}
+
+// parseTransactionStatement parses BEGIN, START TRANSACTION, COMMIT and
+// ROLLBACK, each optionally followed by TRANSACTION.
+func parseTransactionStatement(tokens []Token, initialCursor uint) (*Statement, uint, bool) {
+	if initialCursor >= uint(len(tokens)) {
+		return nil, initialCursor, false
+	}
+
+	cursor := initialCursor + 1
+	current := &tokens[initialCursor]
+
+	var tt ASTType
+	switch {
+	case current.eq(&Token{Type: KeywordType, Value: string(beginKeyword)}):
+		tt = BeginType
+	case current.eq(&Token{Type: KeywordType, Value: string(commitKeyword)}):
+		tt = CommitType
+	case current.eq(&Token{Type: KeywordType, Value: string(rollbackKeyword)}):
+		tt = RollbackType
+	case current.eq(&Token{Type: IdentifierType, Value: "start"}):
+		// START isn't reserved, it only starts a statement before TRANSACTION
+		if !expectToken(tokens, cursor, tokenFromKeyword(transactionKeyword)) {
+			return nil, initialCursor, false
+		}
+		tt = BeginType
+	default:
+		return nil, initialCursor, false
+	}
+
+	if expectToken(tokens, cursor, tokenFromKeyword(transactionKeyword)) {
+		cursor++
+	}
+
+	return &Statement{Type: tt}, cursor, true
+}
+
+// parseTwoPhaseStatement parses PREPARE TRANSACTION 'id', COMMIT PREPARED
+// 'id' and ROLLBACK PREPARED 'id'. PREPARE and PREPARED aren't reserved, so
+// they are matched as identifiers.
+func parseTwoPhaseStatement(tokens []Token, initialCursor uint) (*Statement, uint, bool) {
+	cursor := initialCursor
+
+	var tt ASTType
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "prepare"}); ok {
+		if _, newCursor, ok = parseToken(tokens, newCursor, tokenFromKeyword(transactionKeyword)); !ok {
+			return nil, initialCursor, false
+		}
+		tt, cursor = PrepareTransactionType, newCursor
+	} else {
+		_, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(commitKeyword))
+		tt = CommitPreparedType
+		if !ok {
+			_, newCursor, ok = parseToken(tokens, cursor, tokenFromKeyword(rollbackKeyword))
+			tt = RollbackPreparedType
+		}
+		if !ok {
+			return nil, initialCursor, false
+		}
+
+		if _, newCursor, ok = parseToken(tokens, newCursor, Token{Type: IdentifierType, Value: "prepared"}); !ok {
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+	}
+
+	id, cursor, ok := parseTokenType(tokens, cursor, StringType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected transaction id as a string")
+		return nil, initialCursor, false
+	}
+
+	return &Statement{TwoPhaseStatement: &TwoPhaseStatement{ID: *id}, Type: tt}, cursor, true
+}
+
+// parseDeclareCursorStatement parses DECLARE name CURSOR FOR select.
+func parseDeclareCursorStatement(tokens []Token, initialCursor uint) (*DeclareCursorStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(declareKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected cursor name")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(cursorKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected CURSOR")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(forKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected FOR")
+		return nil, initialCursor, false
+	}
+
+	slct, cursor, ok := parseSelectStatement(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected SELECT statement")
+		return nil, initialCursor, false
+	}
+
+	return &DeclareCursorStatement{
+		Name:  *name,
+		Query: slct,
+	}, cursor, true
+}
+
+// parseFetchStatement parses FETCH [n | ALL] [FROM] name. Without a count
+// a single row is fetched.
+func parseFetchStatement(tokens []Token, initialCursor uint) (*FetchStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(fetchKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	fetch := FetchStatement{Count: 1}
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(allKeyword)); ok {
+		cursor = newCursor
+		fetch.All = true
+	} else if count, newCursor, ok := parseTokenType(tokens, cursor, NumericType); ok {
+		n, err := strconv.ParseUint(count.Value, 10, 64)
+		if err != nil {
+			helpMessage(tokens, cursor, "Expected row count")
+			return nil, initialCursor, false
+		}
+
+		cursor = newCursor
+		fetch.Count = n
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(fromKeyword)); ok {
+		cursor = newCursor
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected cursor name")
+		return nil, initialCursor, false
+	}
+	fetch.Name = *name
+
+	return &fetch, cursor, true
+}
+
+func parseCloseStatement(tokens []Token, initialCursor uint) (*CloseStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(closeKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected cursor name")
+		return nil, initialCursor, false
+	}
+
+	return &CloseStatement{Name: *name}, cursor, true
+}
+
+// parseCheckTableStatement parses CHECK TABLE name. CHECK isn't reserved, so
+// it is matched as an identifier.
+func parseCheckTableStatement(tokens []Token, initialCursor uint) (*CheckTableStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "check"})
+	if !ok || !expectToken(tokens, cursor, tokenFromKeyword(tableKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	return &CheckTableStatement{Table: *name}, cursor, true
+}
+
+// parseFlashbackStatement parses FLASHBACK TABLE table TO TIMESTAMP time
+// and FLASHBACK TABLE table TO ROWS n. None of FLASHBACK, TO, TIMESTAMP and
+// ROWS are reserved, so they are matched as identifiers.
+func parseFlashbackStatement(tokens []Token, initialCursor uint) (*FlashbackStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "flashback"})
+	if !ok || !expectToken(tokens, cursor, tokenFromKeyword(tableKeyword)) {
+		return nil, initialCursor, false
+	}
+	cursor++
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "to"}); !ok {
+		helpMessage(tokens, cursor, "Expected TO")
+		return nil, initialCursor, false
+	}
+
+	flashback := FlashbackStatement{Table: *name}
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "rows"}); ok {
+		rows, newCursor, ok := parseSignedInteger(tokens, newCursor)
+		if !ok || rows < 0 {
+			helpMessage(tokens, newCursor, "Expected the number of rows to keep")
+			return nil, initialCursor, false
+		}
+
+		flashback.Rows = &rows
+		return &flashback, newCursor, true
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "timestamp"}); !ok {
+		helpMessage(tokens, cursor, "Expected TIMESTAMP or ROWS")
+		return nil, initialCursor, false
+	}
+
+	if flashback.Timestamp, cursor, ok = parseExpression(tokens, cursor, 0); !ok {
+		helpMessage(tokens, cursor, "Expected the time to take the table back to")
+		return nil, initialCursor, false
+	}
+
+	return &flashback, cursor, true
+}
+
+// parseRekeyStatement parses REKEY followed by a string or a parameter.
+// REKEY isn't reserved, so it is matched as an identifier.
+func parseRekeyStatement(tokens []Token, initialCursor uint) (*RekeyStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "rekey"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	key, cursor, ok := parseLiteralExpression(tokens, cursor)
+	if !ok || (key.Type != ParamType && (key.Type != LiteralType || key.Literal.Type != StringValue)) {
+		helpMessage(tokens, cursor, "Expected key as a string or a parameter")
+		return nil, initialCursor, false
+	}
+
+	return &RekeyStatement{Key: *key}, cursor, true
+}
+
+// parseKillStatement parses KILL followed by the id of a session. KILL
+// isn't reserved, so it is matched as an identifier.
+func parseKillStatement(tokens []Token, initialCursor uint) (*KillStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "kill"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	session, cursor, ok := parseLiteralExpression(tokens, cursor)
+	if !ok || (session.Type != ParamType && (session.Type != LiteralType || session.Literal.Type != Int64Value)) {
+		helpMessage(tokens, cursor, "Expected session id as an integer or a parameter")
+		return nil, initialCursor, false
+	}
+
+	return &KillStatement{Session: *session}, cursor, true
+}
+
+// parseAttachStatement parses ATTACH [DATABASE] 'path' AS alias. ATTACH and
+// DATABASE aren't reserved, so they are matched as identifiers.
+func parseAttachStatement(tokens []Token, initialCursor uint) (*AttachStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "attach"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "database"}); ok {
+		cursor = newCursor
+	}
+
+	path, cursor, ok := parseTokenType(tokens, cursor, StringType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected database file name")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(asKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected AS")
+		return nil, initialCursor, false
+	}
+
+	alias, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected database alias")
+		return nil, initialCursor, false
+	}
+
+	return &AttachStatement{Path: *path, Alias: *alias}, cursor, true
+}
+
+// parseDetachStatement parses DETACH [DATABASE] alias.
+func parseDetachStatement(tokens []Token, initialCursor uint) (*DetachStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "detach"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "database"}); ok {
+		cursor = newCursor
+	}
+
+	alias, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected database alias")
+		return nil, initialCursor, false
+	}
+
+	return &DetachStatement{Alias: *alias}, cursor, true
+}
+
+// parseListenStatement parses LISTEN channel, UNLISTEN channel and
+// UNLISTEN *. Neither word is reserved, so they are matched as identifiers.
+func parseListenStatement(tokens []Token, initialCursor uint) (*ListenStatement, uint, bool) {
+	cursor := initialCursor
+
+	unlisten := false
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "listen"})
+	if !ok {
+		_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "unlisten"})
+		if !ok {
+			return nil, initialCursor, false
+		}
+		unlisten = true
+
+		if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(asteriskPunct)); ok {
+			return &ListenStatement{Unlisten: true}, newCursor, true
+		}
+	}
+
+	channel, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected channel name")
+		return nil, initialCursor, false
+	}
+
+	return &ListenStatement{Unlisten: unlisten, Channel: channel}, cursor, true
+}
+
+// parseNotifyStatement parses NOTIFY channel [, 'payload']. NOTIFY isn't
+// reserved, so it is matched as an identifier.
+func parseNotifyStatement(tokens []Token, initialCursor uint) (*NotifyStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "notify"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	channel, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected channel name")
+		return nil, initialCursor, false
+	}
+	notify := NotifyStatement{Channel: *channel}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(commaPunct)); ok {
+		payload, newCursor, ok := parseTokenType(tokens, newCursor, StringType)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected payload as a string")
+			return nil, initialCursor, false
+		}
+		notify.Payload, cursor = payload, newCursor
+	}
+
+	return &notify, cursor, true
+}
+
+// parseCopyStatement parses COPY table [(columns)] FROM STDIN, COPY table
+// [(columns)] TO STDOUT and COPY (query) TO STDOUT, followed by [WITH] and
+// either (FORMAT name, HEADER [bool]) or the older CSV [HEADER] or BINARY.
+// None of the words are reserved, so they are matched as identifiers.
+func parseCopyStatement(tokens []Token, initialCursor uint) (*CopyStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "copy"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	stmt := CopyStatement{Format: "text"}
+	if query, newCursor, ok := parseSubquery(tokens, cursor); ok {
+		stmt.Query, cursor = query.Subquery, newCursor
+	} else {
+		if stmt.Table, cursor, ok = parseTokenType(tokens, cursor, IdentifierType); !ok {
+			helpMessage(tokens, cursor, "Expected table name or query")
+			return nil, initialCursor, false
+		}
+
+		if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(leftparenPunct)); ok {
+			cursor = newCursor
+			for {
+				column, newCursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+				if !ok {
+					helpMessage(tokens, cursor, "Expected column name")
+					return nil, initialCursor, false
+				}
+				cursor = newCursor
+				stmt.Columns = append(stmt.Columns, *column)
+
+				if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+					break
+				}
+				cursor = newCursor
+			}
+
+			if _, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct)); !ok {
+				helpMessage(tokens, cursor, "Expected right paren")
+				return nil, initialCursor, false
+			}
+		}
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(fromKeyword)); ok && stmt.Query == nil {
+		if _, cursor, ok = parseToken(tokens, newCursor, Token{Type: IdentifierType, Value: "stdin"}); !ok {
+			helpMessage(tokens, newCursor, "Expected STDIN")
+			return nil, initialCursor, false
+		}
+		stmt.From = true
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "to"}); ok {
+		if _, cursor, ok = parseToken(tokens, newCursor, Token{Type: IdentifierType, Value: "stdout"}); !ok {
+			helpMessage(tokens, newCursor, "Expected STDOUT")
+			return nil, initialCursor, false
+		}
+	} else {
+		helpMessage(tokens, cursor, "Expected FROM STDIN or TO STDOUT")
+		return nil, initialCursor, false
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "with"}); ok {
+		cursor = newCursor
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "csv"}); ok {
+		stmt.Format, cursor = "csv", newCursor
+		if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "header"}); ok {
+			stmt.Header, cursor = true, newCursor
+		}
+		return &stmt, cursor, true
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "binary"}); ok {
+		stmt.Format = "binary"
+		return &stmt, newCursor, true
+	}
+
+	_, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		return &stmt, cursor, true
+	}
+	cursor = newCursor
+	for {
+		option, newCursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected FORMAT or HEADER")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+
+		switch option.Value {
+		case "format":
+			format, newCursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+			if !ok || (format.Value != "text" && format.Value != "csv" && format.Value != "binary") {
+				helpMessage(tokens, cursor, "Expected TEXT, CSV or BINARY")
+				return nil, initialCursor, false
+			}
+			stmt.Format, cursor = format.Value, newCursor
+		case "header":
+			stmt.Header = true
+			if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(trueKeyword)); ok {
+				cursor = newCursor
+			} else if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(falseKeyword)); ok {
+				stmt.Header, cursor = false, newCursor
+			}
+		default:
+			helpMessage(tokens, cursor, "Expected FORMAT or HEADER")
+			return nil, initialCursor, false
+		}
+
+		if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+			break
+		}
+		cursor = newCursor
+	}
+
+	if _, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct)); !ok {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	return &stmt, cursor, true
+}
+
+// parseCreatePolicyStatement parses CREATE POLICY name ON table USING (exp).
+// POLICY, ON and USING aren't reserved, so they are matched as identifiers.
+func parseCreatePolicyStatement(tokens []Token, initialCursor uint) (*CreatePolicyStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(createKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "policy"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, table, cursor, ok := parsePolicyName(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "using"})
+	if !ok {
+		helpMessage(tokens, cursor, "Expected USING")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+
+	using, cursor, ok := parseExpression(tokens, cursor, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected policy expression")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	return &CreatePolicyStatement{Name: *name, Table: *table, Using: *using}, cursor, true
+}
+
+// parseDropPolicyStatement parses DROP POLICY name ON table.
+func parseDropPolicyStatement(tokens []Token, initialCursor uint) (*DropPolicyStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(dropKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "policy"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, table, cursor, ok := parsePolicyName(tokens, cursor)
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	return &DropPolicyStatement{Name: *name, Table: *table}, cursor, true
+}
+
+// parsePolicyName parses the name ON table naming a policy.
+func parsePolicyName(tokens []Token, initialCursor uint) (*Token, *Token, uint, bool) {
+	cursor := initialCursor
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected policy name")
+		return nil, nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "on"})
+	if !ok {
+		helpMessage(tokens, cursor, "Expected ON")
+		return nil, nil, initialCursor, false
+	}
+
+	table, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, nil, initialCursor, false
+	}
+
+	return name, table, cursor, true
+}
+
+// parseCreateIndexStatement parses CREATE INDEX [CONCURRENTLY] [name] ON
+// table (column) [WHERE predicate]. INDEX, CONCURRENTLY and ON aren't reserved, so they are
+// matched as identifiers.
+func parseCreateIndexStatement(tokens []Token, initialCursor uint) (*CreateIndexStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(createKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "index"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	crt := CreateIndexStatement{}
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "concurrently"}); ok {
+		crt.Concurrently, cursor = true, newCursor
+	}
+
+	// Without a name ON follows right away, an index can still be called on
+	on := Token{Type: IdentifierType, Value: "on"}
+	if name, newCursor, ok := parseTokenType(tokens, cursor, IdentifierType); ok {
+		if _, newCursor, ok = parseToken(tokens, newCursor, on); ok {
+			crt.Name, cursor = name, newCursor
+		}
+	}
+	if crt.Name == nil {
+		if _, cursor, ok = parseToken(tokens, cursor, on); !ok {
+			helpMessage(tokens, cursor, "Expected ON")
+			return nil, initialCursor, false
+		}
+	}
+
+	table, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	crt.Table = *table
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(leftparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected left paren")
+		return nil, initialCursor, false
+	}
+
+	exp, cursor, ok := parseExpression(tokens, cursor, 0)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected column name or expression")
+		return nil, initialCursor, false
+	}
+	if exp.Type == ColumnRefType {
+		crt.Column = *exp.Column
+	} else {
+		crt.Expression = exp
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected right paren")
+		return nil, initialCursor, false
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(whereKeyword)); ok {
+		where, newCursor, ok := parseExpression(tokens, newCursor, 0)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected WHERE predicate")
+			return nil, initialCursor, false
+		}
+		crt.Where, cursor = where, newCursor
+	}
+
+	return &crt, cursor, true
+}
+
+// parseCreateStatisticsStatement parses CREATE STATISTICS name [(kind,
+// ...)] ON column, column, ... FROM table.
+func parseCreateStatisticsStatement(tokens []Token, initialCursor uint) (*CreateStatisticsStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(createKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "statistics"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected statistics name")
+		return nil, initialCursor, false
+	}
+
+	crt := CreateStatisticsStatement{Name: *name}
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(leftparenPunct)); ok {
+		cursor = newCursor
+		for {
+			kind, newCursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+			if !ok || (kind.Value != "ndistinct" && kind.Value != "dependencies") {
+				helpMessage(tokens, cursor, "Expected NDISTINCT or DEPENDENCIES")
+				return nil, initialCursor, false
+			}
+			cursor = newCursor
+			crt.Kinds = append(crt.Kinds, *kind)
+
+			if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+				break
+			}
+			cursor = newCursor
+		}
+
+		_, cursor, ok = parseToken(tokens, cursor, tokenFromPunct(rightparenPunct))
+		if !ok {
+			helpMessage(tokens, cursor, "Expected right paren")
+			return nil, initialCursor, false
+		}
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "on"})
+	if !ok {
+		helpMessage(tokens, cursor, "Expected ON")
+		return nil, initialCursor, false
+	}
+
+	for {
+		column, newCursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected column name")
+			return nil, initialCursor, false
+		}
+		cursor = newCursor
+		crt.Columns = append(crt.Columns, *column)
+
+		if _, newCursor, ok = parseToken(tokens, cursor, tokenFromPunct(commaPunct)); !ok {
+			break
+		}
+		cursor = newCursor
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, tokenFromKeyword(fromKeyword))
+	if !ok {
+		helpMessage(tokens, cursor, "Expected FROM")
+		return nil, initialCursor, false
+	}
 
+	table, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+	crt.Table = *table
+
+	return &crt, cursor, true
+}
+
+// parseDropStatisticsStatement parses DROP STATISTICS name.
+func parseDropStatisticsStatement(tokens []Token, initialCursor uint) (*DropStatisticsStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(dropKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "statistics"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected statistics name")
+		return nil, initialCursor, false
+	}
+
+	return &DropStatisticsStatement{Name: *name}, cursor, true
+}
+
+// parseDropIndexStatement parses DROP INDEX name.
+func parseDropIndexStatement(tokens []Token, initialCursor uint) (*DropIndexStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(dropKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "index"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected index name")
+		return nil, initialCursor, false
+	}
+
+	return &DropIndexStatement{Name: *name}, cursor, true
+}
+
+// parseGrantStatement parses GRANT privilege TO user and REVOKE privilege
+// FROM user. GRANT, REVOKE and TO aren't reserved, so they are matched as
+// identifiers.
+func parseGrantStatement(tokens []Token, initialCursor uint) (*GrantStatement, uint, bool) {
+	cursor := initialCursor
+
+	revoke := false
+	to := Token{Type: IdentifierType, Value: "to"}
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "revoke"}); ok {
+		revoke, cursor, to = true, newCursor, tokenFromKeyword(fromKeyword)
+	} else if _, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "grant"}); !ok {
+		return nil, initialCursor, false
+	}
+	grant := &GrantStatement{Revoke: revoke}
+
+	privilege, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected privilege or role")
+		return nil, initialCursor, false
+	}
+	grant.Privilege = *privilege
+
+	_, cursor, ok = parseToken(tokens, cursor, to)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected "+strings.ToUpper(to.Value))
+		return nil, initialCursor, false
+	}
+
+	user, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected user name")
+		return nil, initialCursor, false
+	}
+	grant.User = *user
+
+	return grant, cursor, true
+}
+
+// parseRoleStatement parses CREATE ROLE name and DROP ROLE name, telling
+// them apart by verb. ROLE isn't reserved, so it is matched as an
+// identifier.
+func parseRoleStatement(tokens []Token, initialCursor uint, verb keyword) (*Token, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(verb))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "role"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected role name")
+		return nil, initialCursor, false
+	}
+
+	return name, cursor, true
+}
+
+// parseCommentStatement parses COMMENT ON TABLE name IS 'comment' and
+// COMMENT ON COLUMN table.column IS 'comment', with NULL in place of the
+// comment to remove it. The column is read as a single identifier like the
+// tables of attached databases are, so a table or column whose name has to
+// be quoted is written quoted as a whole: "My table.my column". COMMENT,
+// ON, COLUMN and IS aren't reserved, so they are matched as identifiers.
+func parseCommentStatement(tokens []Token, initialCursor uint) (*CommentStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "comment"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "on"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	comment := CommentStatement{}
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(tableKeyword)); ok {
+		table, newCursor, ok := parseTokenType(tokens, newCursor, IdentifierType)
+		if !ok {
+			helpMessage(tokens, newCursor, "Expected table name")
+			return nil, initialCursor, false
+		}
+
+		comment.Table, cursor = *table, newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "column"}); ok {
+		name, newCursor, ok := parseTokenType(tokens, newCursor, IdentifierType)
+		dot := -1
+		if ok {
+			dot = strings.LastIndexByte(name.Value, '.')
+		}
+		if dot <= 0 || dot == len(name.Value)-1 {
+			helpMessage(tokens, newCursor, "Expected table.column")
+			return nil, initialCursor, false
+		}
+
+		column := Token{Type: IdentifierType, Value: name.Value[dot+1:], Loc: name.Loc}
+		column.Loc.Column += uint(dot + 1)
+		column.Loc.Offset += uint(dot + 1)
+		comment.Table = Token{Type: IdentifierType, Value: name.Value[:dot], Loc: name.Loc}
+		comment.Column, cursor = &column, newCursor
+	} else {
+		helpMessage(tokens, cursor, "Expected TABLE or COLUMN")
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "is"})
+	if !ok {
+		helpMessage(tokens, cursor, "Expected IS")
+		return nil, initialCursor, false
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(nullKeyword)); ok {
+		return &comment, newCursor, true
+	}
+
+	text, cursor, ok := parseTokenType(tokens, cursor, StringType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected comment string or NULL")
+		return nil, initialCursor, false
+	}
+	comment.Comment = text
+
+	return &comment, cursor, true
+}
+
+// parseShowStatement parses SHOW TABLES and SHOW COLUMNS FROM name, which
+// can also be written with IN. SHOW, TABLES and COLUMNS aren't reserved, so
+// they are matched as identifiers.
+func parseShowStatement(tokens []Token, initialCursor uint) (*ShowStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "show"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "tables"}); ok {
+		return &ShowStatement{}, newCursor, true
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "columns"})
+	if !ok {
+		helpMessage(tokens, cursor, "Expected TABLES or COLUMNS")
+		return nil, initialCursor, false
+	}
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(fromKeyword)); ok {
+		cursor = newCursor
+	} else if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(inKeyword)); ok {
+		cursor = newCursor
+	} else {
+		helpMessage(tokens, cursor, "Expected FROM")
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected table name")
+		return nil, initialCursor, false
+	}
+
+	return &ShowStatement{Table: name}, cursor, true
+}
+
+// parseCreateSequenceStatement parses CREATE SEQUENCE name followed by any of
+// START [WITH] n, INCREMENT [BY] n and CACHE n. None of the words are
+// reserved, so they are matched as identifiers.
+func parseCreateSequenceStatement(tokens []Token, initialCursor uint) (*CreateSequenceStatement, uint, bool) {
+	cursor := initialCursor
+
+	_, cursor, ok := parseToken(tokens, cursor, tokenFromKeyword(createKeyword))
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	_, cursor, ok = parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "sequence"})
+	if !ok {
+		return nil, initialCursor, false
+	}
+
+	name, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected sequence name")
+		return nil, initialCursor, false
+	}
+
+	seq := CreateSequenceStatement{Name: *name, Increment: 1, Cache: 1}
+	for {
+		option, n, newCursor, ok := parseSequenceOption(tokens, cursor)
+		if !ok {
+			break
+		}
+		cursor = newCursor
+
+		switch option {
+		case "start":
+			seq.Start = &n
+		case "increment":
+			seq.Increment = n
+		case "cache":
+			seq.Cache = n
+		}
+	}
+
+	if cursor < uint(len(tokens)) && tokens[cursor].Type == IdentifierType {
+		helpMessage(tokens, cursor, "Expected START, INCREMENT or CACHE")
+		return nil, initialCursor, false
+	}
+
+	if seq.Increment == 0 {
+		helpMessage(tokens, cursor, "INCREMENT must not be zero")
+		return nil, initialCursor, false
+	}
+
+	if seq.Cache < 1 {
+		helpMessage(tokens, cursor, "CACHE must be at least 1")
+		return nil, initialCursor, false
+	}
+
+	return &seq, cursor, true
+}
+
+// parseSequenceOption parses START [WITH] n, INCREMENT [BY] n or CACHE n,
+// returning the name of the option and n.
+func parseSequenceOption(tokens []Token, initialCursor uint) (string, int64, uint, bool) {
+	cursor := initialCursor
+
+	option, cursor, ok := parseTokenType(tokens, cursor, IdentifierType)
+	if !ok {
+		return "", 0, initialCursor, false
+	}
+
+	var filler string
+	switch option.Value {
+	case "start":
+		filler = "with"
+	case "increment":
+		filler = "by"
+	case "cache":
+	default:
+		return "", 0, initialCursor, false
+	}
+
+	if filler != "" && expectToken(tokens, cursor, Token{Type: IdentifierType, Value: filler}) {
+		cursor++
+	}
+
+	n, cursor, ok := parseSignedInteger(tokens, cursor)
+	if !ok {
+		helpMessage(tokens, cursor, "Expected integer")
+		return "", 0, initialCursor, false
+	}
+
+	return option.Value, n, cursor, true
+}
+
+// parseSignedInteger parses an integer with an optional leading minus.
+func parseSignedInteger(tokens []Token, initialCursor uint) (int64, uint, bool) {
+	cursor := initialCursor
+
+	sign := ""
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromPunct(minusPunct)); ok {
+		cursor = newCursor
+		sign = "-"
+	}
+
+	num, cursor, ok := parseTokenType(tokens, cursor, NumericType)
+	if !ok {
+		return 0, initialCursor, false
+	}
+
+	n, err := strconv.ParseInt(sign+num.Value, 10, 64)
+	if err != nil {
+		return 0, initialCursor, false
+	}
+
+	return n, cursor, true
+}
+
+// parseExplainValidate parses the (VALIDATE) option of EXPLAIN, returning
+// false and initialCursor when it isn't there.
+func parseExplainValidate(tokens []Token, initialCursor uint) (bool, uint) {
+	cursor := initialCursor
+	for _, want := range []Token{
+		tokenFromPunct(leftparenPunct),
+		{Type: IdentifierType, Value: "validate"},
+		tokenFromPunct(rightparenPunct),
+	} {
+		var ok bool
+		if _, cursor, ok = parseToken(tokens, cursor, want); !ok {
+			return false, initialCursor
+		}
+	}
+
+	return true, cursor
+}
+
+func parseStatement(tokens []Token, initialCursor uint) (*Statement, uint, bool) {
+	cursor := initialCursor
+
+	if _, newCursor, ok := parseToken(tokens, cursor, tokenFromKeyword(explainKeyword)); ok {
+		validate, newCursor := parseExplainValidate(tokens, newCursor)
+		stmt, newCursor, ok := parseStatement(tokens, newCursor)
+		if !ok {
+			return nil, initialCursor, false
+		}
+
+		return &Statement{
+			ExplainStatement: &ExplainStatement{Statement: stmt, Validate: validate},
+			Type:             ExplainType,
+		}, newCursor, true
+	}
+
+	if stmt, newCursor, ok := parseTwoPhaseStatement(tokens, cursor); ok {
+		return stmt, newCursor, true
+	}
+
+	if stmt, newCursor, ok := parseTransactionStatement(tokens, cursor); ok {
+		return stmt, newCursor, true
+	}
+
+	// VACUUM isn't reserved, so tables can still be called vacuum
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "vacuum"}); ok {
+		return &Statement{Type: VacuumType}, newCursor, true
+	}
+
+	// Nor is ANALYZE
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "analyze"}); ok {
+		analyze := AnalyzeStatement{}
+		if table, newCursor, ok := parseTokenType(tokens, newCursor, IdentifierType); ok {
+			return &Statement{AnalyzeStatement: &AnalyzeStatement{Table: table}, Type: AnalyzeType}, newCursor, true
+		}
+
+		return &Statement{AnalyzeStatement: &analyze, Type: AnalyzeType}, newCursor, true
+	}
+
+	// Nor are RECOMMEND and INDEXES
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "recommend"}); ok {
+		if _, newCursor, ok = parseToken(tokens, newCursor, Token{Type: IdentifierType, Value: "indexes"}); !ok {
+			helpMessage(tokens, newCursor, "Expected INDEXES")
+			return nil, initialCursor, false
+		}
+
+		return &Statement{Type: RecommendIndexesType}, newCursor, true
+	}
+
+	// Nor are RELOAD and CONFIG
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "reload"}); ok {
+		if _, newCursor, ok = parseToken(tokens, newCursor, Token{Type: IdentifierType, Value: "config"}); !ok {
+			helpMessage(tokens, newCursor, "Expected CONFIG")
+			return nil, initialCursor, false
+		}
+
+		return &Statement{Type: ReloadConfigType}, newCursor, true
+	}
+
+	// DISCARD isn't reserved either
+	if _, newCursor, ok := parseToken(tokens, cursor, Token{Type: IdentifierType, Value: "discard"}); ok {
+		if _, newCursor, ok = parseToken(tokens, newCursor, tokenFromKeyword(allKeyword)); !ok {
+			helpMessage(tokens, newCursor, "Expected ALL")
+			return nil, initialCursor, false
+		}
+
+		return &Statement{Type: DiscardType}, newCursor, true
+	}
+
+	if check, newCursor, ok := parseCheckTableStatement(tokens, cursor); ok {
+		return &Statement{
+			CheckTableStatement: check,
+			Type:                CheckTableType,
+		}, newCursor, true
+	}
+
+	if flashback, newCursor, ok := parseFlashbackStatement(tokens, cursor); ok {
+		return &Statement{
+			FlashbackStatement: flashback,
+			Type:               FlashbackType,
+		}, newCursor, true
+	}
+
+	if rekey, newCursor, ok := parseRekeyStatement(tokens, cursor); ok {
+		return &Statement{
+			RekeyStatement: rekey,
+			Type:           RekeyType,
+		}, newCursor, true
+	}
+
+	if kill, newCursor, ok := parseKillStatement(tokens, cursor); ok {
+		return &Statement{
+			KillStatement: kill,
+			Type:          KillType,
+		}, newCursor, true
+	}
+
+	if attach, newCursor, ok := parseAttachStatement(tokens, cursor); ok {
+		return &Statement{
+			AttachStatement: attach,
+			Type:            AttachType,
+		}, newCursor, true
+	}
+
+	if detach, newCursor, ok := parseDetachStatement(tokens, cursor); ok {
+		return &Statement{
+			DetachStatement: detach,
+			Type:            DetachType,
+		}, newCursor, true
+	}
+
+	if listen, newCursor, ok := parseListenStatement(tokens, cursor); ok {
+		return &Statement{
+			ListenStatement: listen,
+			Type:            ListenType,
+		}, newCursor, true
+	}
+
+	if notify, newCursor, ok := parseNotifyStatement(tokens, cursor); ok {
+		return &Statement{
+			NotifyStatement: notify,
+			Type:            NotifyType,
+		}, newCursor, true
+	}
+
+	if stmt, newCursor, ok := parseCopyStatement(tokens, cursor); ok {
+		return &Statement{
+			CopyStatement: stmt,
+			Type:          CopyType,
+		}, newCursor, true
+	}
+
+	if refresh, newCursor, ok := parseRefreshStatement(tokens, cursor); ok {
+		return &Statement{
+			RefreshStatement: refresh,
+			Type:             RefreshType,
+		}, newCursor, true
+	}
+
+	if drop, newCursor, ok := parseDropPolicyStatement(tokens, cursor); ok {
+		return &Statement{
+			DropPolicyStatement: drop,
+			Type:                DropPolicyType,
+		}, newCursor, true
+	}
+
+	if name, newCursor, ok := parseRoleStatement(tokens, cursor, dropKeyword); ok {
+		return &Statement{
+			DropRoleStatement: &DropRoleStatement{Name: *name},
+			Type:              DropRoleType,
+		}, newCursor, true
+	}
+
+	if drop, newCursor, ok := parseDropStatisticsStatement(tokens, cursor); ok {
+		return &Statement{
+			DropStatisticsStatement: drop,
+			Type:                    DropStatisticsType,
+		}, newCursor, true
+	}
+
+	if drop, newCursor, ok := parseDropIndexStatement(tokens, cursor); ok {
+		return &Statement{
+			DropIndexStatement: drop,
+			Type:               DropIndexType,
+		}, newCursor, true
+	}
+
+	if drop, newCursor, ok := parseDropTableStatement(tokens, cursor); ok {
+		return &Statement{
+			DropTableStatement: drop,
+			Type:               DropTableType,
+		}, newCursor, true
+	}
+
+	if alter, newCursor, ok := parseAlterTableStatement(tokens, cursor); ok {
+		return &Statement{
+			AlterTableStatement: alter,
+			Type:                AlterTableType,
+		}, newCursor, true
+	}
+
+	if comment, newCursor, ok := parseCommentStatement(tokens, cursor); ok {
+		return &Statement{
+			CommentStatement: comment,
+			Type:             CommentType,
+		}, newCursor, true
+	}
+
+	if grant, newCursor, ok := parseGrantStatement(tokens, cursor); ok {
+		return &Statement{
+			GrantStatement: grant,
+			Type:           GrantType,
+		}, newCursor, true
+	}
+
+	if show, newCursor, ok := parseShowStatement(tokens, cursor); ok {
+		return &Statement{
+			ShowStatement: show,
+			Type:          ShowType,
+		}, newCursor, true
+	}
+
+	if slct, newCursor, ok := parseSelectStatement(tokens, cursor); ok {
+		return &Statement{
+			SelectStatement: slct,
+			Type:            SelectType,
+		}, newCursor, true
+	}
+
+	if inst, newCursor, ok := parseInsertStatement(tokens, cursor); ok {
+		return &Statement{
+			InsertStatement: inst,
+			Type:            InsertType,
+		}, newCursor, true
+	}
+
+	if crt, newCursor, ok := parseCreateTableStatement(tokens, cursor); ok {
+		return &Statement{
+			CreateTableStatement: crt,
+			Type:                 CreateTableType,
+		}, newCursor, true
+	}
+
+	if view, newCursor, ok := parseCreateMaterializedViewStatement(tokens, cursor); ok {
+		return &Statement{
+			CreateMaterializedViewStatement: view,
+			Type:                            CreateMaterializedViewType,
+		}, newCursor, true
+	}
+
+	if seq, newCursor, ok := parseCreateSequenceStatement(tokens, cursor); ok {
+		return &Statement{
+			CreateSequenceStatement: seq,
+			Type:                    CreateSequenceType,
+		}, newCursor, true
+	}
+
+	if name, newCursor, ok := parseRoleStatement(tokens, cursor, createKeyword); ok {
+		return &Statement{
+			CreateRoleStatement: &CreateRoleStatement{Name: *name},
+			Type:                CreateRoleType,
+		}, newCursor, true
+	}
+
+	if index, newCursor, ok := parseCreateIndexStatement(tokens, cursor); ok {
+		return &Statement{
+			CreateIndexStatement: index,
+			Type:                 CreateIndexType,
+		}, newCursor, true
+	}
+
+	if stats, newCursor, ok := parseCreateStatisticsStatement(tokens, cursor); ok {
+		return &Statement{
+			CreateStatisticsStatement: stats,
+			Type:                      CreateStatisticsType,
+		}, newCursor, true
+	}
+
+	if policy, newCursor, ok := parseCreatePolicyStatement(tokens, cursor); ok {
+		return &Statement{
+			CreatePolicyStatement: policy,
+			Type:                  CreatePolicyType,
+		}, newCursor, true
+	}
+
+	if decl, newCursor, ok := parseDeclareCursorStatement(tokens, cursor); ok {
+		return &Statement{
+			DeclareCursorStatement: decl,
+			Type:                   DeclareCursorType,
+		}, newCursor, true
+	}
+
+	if fetch, newCursor, ok := parseFetchStatement(tokens, cursor); ok {
+		return &Statement{
+			FetchStatement: fetch,
+			Type:           FetchType,
+		}, newCursor, true
+	}
+
+	if cls, newCursor, ok := parseCloseStatement(tokens, cursor); ok {
+		return &Statement{
+			CloseStatement: cls,
+			Type:           CloseType,
+		}, newCursor, true
+	}
+
+	return nil, initialCursor, false
+}
+
+// maxNesting bounds how deeply parentheses and brackets may nest. Parsing
+// recurses once per level, so unbounded nesting would let hostile input
+// exhaust the stack.
+const maxNesting = 1000
+
+func checkNesting(tokens []Token) error {
+	depth := 0
+	for i := range tokens {
+		if tokens[i].Type != SymbolType {
+			continue
+		}
+
+		switch punct(tokens[i].Value) {
+		case leftparenPunct, leftbracketPunct:
+			depth++
+			if depth > maxNesting {
+				return fmt.Errorf("Parentheses or brackets nested deeper than %d, at %d:%d",
+					maxNesting, tokens[i].Loc.Line, tokens[i].Loc.Column)
+			}
+		case rightparenPunct, rightbracketPunct:
+			depth--
+		}
+	}
+
+	return nil
+}
+
+// Parse parses every statement in src. It never panics, whatever src is.
+func Parse(src string) (*AST, error) {
+	_, a, err := parseTokens(src)
+	return a, err
+}
+
+// parseTokens parses every statement in src, also returning the tokens the
+// locations in the statements point into.
+func parseTokens(src string) ([]Token, *AST, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkNesting(tokens); err != nil {
+		return nil, nil, err
+	}
+
+	a := AST{}
+	cursor := uint(0)
+	for cursor < uint(len(tokens)) {
+		stmt, newCursor, ok := parseStatement(tokens, cursor)
+		if !ok {
+			helpMessage(tokens, cursor, "Expected statement")
+			return nil, nil, errors.New("Failed to parse, expected statement")
+		}
+
+		end := uint(len(src))
+		if newCursor < uint(len(tokens)) {
+			end = tokens[newCursor].Loc.Offset
+		}
+		stmt.Text = strings.TrimSpace(src[tokens[cursor].Loc.Offset:end])
+
+		for _, token := range tokens[cursor:newCursor] {
+			if token.Type != ParameterType {
+				continue
+			}
+			if n, err := strconv.ParseUint(token.Value[1:], 10, 32); err == nil && uint(n) > a.Params {
+				a.Params = uint(n)
+			}
+		}
+
+		cursor = newCursor
 		a.Statements = append(a.Statements, stmt)
 
 		atLeastOneSemicolon := false
@@ -482,11 +4595,11 @@ func Parse(src string) (*AST, error) {
 			atLeastOneSemicolon = true
 		}
 
-		if !atLeastOneSemicolon {
+		if !atLeastOneSemicolon && cursor < uint(len(tokens)) {
 			helpMessage(tokens, cursor, "Expected semi-colon delimiter between statements")
-			return nil, errors.New("Missing semi-colon between statements")
+			return nil, nil, errors.New("Missing semi-colon between statements")
 		}
 	}
 
-	return &a, nil
+	return tokens, &a, nil
 }
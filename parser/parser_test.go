@@ -0,0 +1,604 @@
+package parser
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkScript returns a few megabytes of SQL resembling a dump.
+func benchmarkScript() string {
+	var sb strings.Builder
+	sb.WriteString("CREATE TABLE users (id INT, name TEXT, email TEXT);\n")
+	for i := 0; i < 20000; i++ {
+		sb.WriteString("INSERT INTO users VALUES (1, 'Some Name', 'someone@example.com');\n")
+		sb.WriteString("SELECT id, name AS username FROM users WHERE id > 10 AND email <> 'x';\n")
+	}
+
+	return sb.String()
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	src := benchmarkScript()
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := tokenize(src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLex tokenizes scripts made mostly of one kind of token, to tell
+// how each is looked up.
+func BenchmarkLex(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		line string
+	}{
+		{"keywords", "Select a As b From t Where true And false Or x;\n"},
+		// Words starting with keywords are identifiers
+		{"identifiers", "select created_at, selected, fromage, into_addr, tablespace_name from user_accounts;\n"},
+		{"symbols", "select (a+b)*c-d/e, a||b, a<=b, a<>b, a!=b, a>=b, a<b, a>b from t;\n"},
+		// Token values are slices of the source, except for strings with
+		// doubled quotes and identifiers with upper case letters
+		{"literals", "insert into t values (12345, 3.25e-3, 'plain', 'it''s', \"Quoted\", \"lower\", Upper, $1);\n"},
+	} {
+		src := strings.Repeat(bm.line, 10000)
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := tokenize(src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	src := benchmarkScript()
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseStatements parses scripts repeating a single statement, to
+// tell what parsing each kind of statement allocates.
+func BenchmarkParseStatements(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		line string
+	}{
+		{"insert", "INSERT INTO users VALUES (1, 'it''s', 'someone@example.com', $1, 2.5);\n"},
+		{"select", "SELECT id, name AS username FROM users WHERE id > 10 AND email <> 'x' OR score < $1;\n"},
+		{"subquery", "SELECT a FROM t WHERE a IN (SELECT b FROM u) AND NOT EXISTS (SELECT 1 FROM v WHERE c = a);\n"},
+	} {
+		src := strings.Repeat(bm.line, 10000)
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := Parse(src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+var fuzzSeeds = []string{
+	"SELECT 1",
+	"select a, b as c from t where a = 1 and b <> 'it''s' or c > $1;",
+	"insert into t values (1, 'x', 2+3*4);",
+	"create table t (a int, b text);",
+	"create table t (a text collate nocase, b timestamp)",
+	`select "select", "my col" from "t"`,
+	"select (1+2)*3 - 4 - 5; select .5e-3",
+	"select cast(a as float), '1'::int + 2, $1::text from t",
+	"select coalesce(a, nullif(b, 0), 1), random() from t",
+	"select extract(year from now()), date_trunc('day', ts) + interval '1 day', current_date from t",
+	"select a like 'x%', b ilike '_y', c ~ '^a+$', c !~* 'b' from t where d regexp 'z'",
+	"create table t (a int[], b text[]); select array[1, 2][1], a[2], unnest(b), array_length(a, 1) from t",
+	"create sequence s start with 10 increment by -2 cache 5; select nextval('s'), currval('s') from t",
+	"select (a, b) = (1, 'x'), (a, b) in ((1, 2), (3, 4)), c not in (1, null) from t",
+	"select a from t where exists (select 1 from u where b = a) and not exists (select * from v)",
+	"explain select a, (select count(*) from u where b = a) from t where exists (select 1 from u where b = a)",
+	"select not a, not (a = 1 or not b <> 2) from t where not not (1 + 2 = 3 and true)",
+	"/* note */ select /*+ nested_loop(u) */ a, (select count(*) from u where b = a) from t -- done",
+	"vacuum; create table vacuum (vacuum int);",
+	"check table t; explain select check from check",
+	"explain (validate) insert into t select * from u; explain (validate) create table t (a int); explain (select 1)",
+	"rekey '00ff'; rekey $1",
+	"show tables; show columns from t; select version(), current_user, database()",
+	"alter table t add column c int; alter table t drop c; alter table t alter column b type text collate nocase; drop table t",
+	"select table_name, row_count from __table_stats where _a_ = 1",
+	"select * from __sessions; kill 3; kill $1",
+	"create materialized view v as select a, count(*) from t; refresh materialized view v; drop materialized view v",
+	"select id, level, connect_by_isleaf from t where level < 3 start with parent = 0 connect by nocycle prior id = parent",
+	"select * from generate_series(1, 10, 2) where generate_series > 3; select generate_series(1, 3), unnest(array[1])",
+	"create external table sales (region text, amount float) location 'sales.csv' format csv; create external table t (a int) location 't'",
+	"attach database 'other.db' as aux; select * from aux.t; insert into aux.t values (1); detach aux",
+	"create policy tenant on t using (owner = current_user()); drop policy tenant on t",
+	"create index concurrently t_a on t (a); create index on on (on); drop index t_a",
+	"create role readers; grant readers to alice; grant unmask to readers; revoke readers from alice; drop role readers",
+	"discard all; select discard from discard",
+	"listen jobs; notify jobs; notify jobs, 'done'; unlisten jobs; unlisten *",
+	"begin; insert into t values (1); prepare transaction 'tx1'; commit prepared 'tx1'; rollback prepared 'tx2'; commit; rollback transaction",
+	"select a from t as of timestamp now() where a = 1; select * from t as of timestamp '2020-01-01 00:00:00'",
+	"flashback table t to timestamp now() - $1; flashback table t to rows 10",
+	"create table t (id uuid, name text); insert into t values (uuid(), ulid()); select id::text from t where id = '6ba7b810-9dad-11d1-80b4-00c04fd430c8'",
+	"create table t (id int generated always as identity (start with 10 increment by -2), b int generated by default as identity); insert into t overriding system value values (default, 1); insert into t overriding user value values (2, default)",
+	"create statistics s (ndistinct, dependencies) on a, b from t; create statistics s2 on a, b, c from t; analyze t; analyze; drop statistics s",
+	"select * from t where a between 1 and 2 + 3 and b not between $1 and $2 or c > 5 and 1 < c",
+	"create index on t (lower(email)); create index i on t ((a + b)); select * from t where lower(email) = $1",
+	"create index on t (a) where deleted = false; create index concurrently i on t (lower(b)) where a > 10 and not deleted",
+	"select * from t where a = 1; recommend indexes; select recommend from recommend",
+	"drop table t cascade; drop materialized view v restrict; drop table cascade cascade; select * from __dependencies",
+	"alter table t rename to u; alter table t rename column a to b; alter table t rename a to b; alter table t rename to to x",
+	"comment on table t is 'x'; comment on column t.a is null; comment on column \"t.b c\" is ''; comment on column t is 'x'",
+	"select a from t where a = ? and b = ?; insert into t values (?, $2)",
+	"alter table t alter column ssn set masked with partial(4); alter table t alter column ssn drop masked; grant unmask to alice; revoke unmask from alice",
+	"begin; declare c cursor for select a from t; fetch 10 from c; close c; commit",
+	"select array[array[1, 2], array[]][1]; select array[array[array[",
+	"reload config; select reload, config from reload",
+	"copy t from stdin; copy t (a, b) to stdout with (format csv, header false); copy (select a from t) to stdout csv header",
+	"select a from t where a in (select b from u where u.c = t.c) and a not in ((select 1), 2)",
+	"SELECT -9223372036854775808, abs(-9223372036854775808 + 1)",
+	"select a, b as c from t where a > 1 order by c desc, 1, lower(b) asc limit 10 offset $1; select 1 offset 2; select * from t limit",
+	"create table c (id int, pid int references p (id), key text references k (key)); alter table c add foreign key (pid) references p (id); alter table c add column foreign key",
+	"create table u (id int unique deferrable initially deferred references u (id) not deferrable); alter table u add unique (id) initially immediate; alter table u add unique text",
+	"create table t (a text) with (compression = 'lz'); create table with (with text) with (compression = $1)",
+}
+
+// FuzzTokenize checks that tokenize never panics.
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		tokenize(src)
+	})
+}
+
+// FuzzParse checks that Parse never panics, since servers feed it bytes
+// straight from clients.
+func FuzzParse(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		Parse(src)
+	})
+}
+
+// FuzzStatementScanner checks that scanning never panics.
+func FuzzStatementScanner(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		scanner := NewStatementScanner(strings.NewReader(src))
+		for scanner.Scan() {
+		}
+	})
+}
+
+// FuzzNormalize checks that Normalize never panics and that what it returns
+// parses again with the same fingerprint.
+func FuzzNormalize(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		normalized, _, err := Normalize(src)
+		if err != nil {
+			return
+		}
+
+		want, _ := Fingerprint(src)
+		got, err := Fingerprint(normalized)
+		if err != nil {
+			t.Fatalf("normalized %q to %q, which doesn't parse: %v", src, normalized, err)
+		}
+		if got != want {
+			t.Fatalf("normalized %q to %q, which has another fingerprint", src, normalized)
+		}
+	})
+}
+
+func TestParseCopy(t *testing.T) {
+	tests := []struct {
+		src   string
+		want  *CopyStatement
+		query string
+	}{
+		{"copy t from stdin", &CopyStatement{Table: &Token{Value: "t"}, From: true, Format: "text"}, ""},
+		{"copy t (a, b) to stdout with (format csv, header)", &CopyStatement{Table: &Token{Value: "t"}, Columns: []Token{{Value: "a"}, {Value: "b"}}, Format: "csv", Header: true}, ""},
+		{"copy t from stdin (header false, format csv)", &CopyStatement{Table: &Token{Value: "t"}, From: true, Format: "csv"}, ""},
+		{"copy (select a from t) to stdout csv header", &CopyStatement{Format: "csv", Header: true}, "SELECT a FROM t"},
+		{"copy t to stdin", nil, ""},
+		{"copy (select a from t) from stdin", nil, ""},
+		{"copy t from stdin (format binary)", &CopyStatement{Table: &Token{Value: "t"}, From: true, Format: "binary"}, ""},
+		{"copy t (a) from stdin binary", &CopyStatement{Table: &Token{Value: "t"}, Columns: []Token{{Value: "a"}}, From: true, Format: "binary"}, ""},
+		{"copy t from stdin (format json)", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			ast, err := Parse(tt.src)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", ast.Statements[0])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ast.Statements[0].CopyStatement
+			if got == nil {
+				t.Fatalf("parsed as statement type %d", ast.Statements[0].Type)
+			}
+			if (got.Table == nil) != (tt.want.Table == nil) || (got.Table != nil && got.Table.Value != tt.want.Table.Value) {
+				t.Errorf("table %v, want %v", got.Table, tt.want.Table)
+			}
+			var columns []string
+			for _, col := range got.Columns {
+				columns = append(columns, col.Value)
+			}
+			var want []string
+			for _, col := range tt.want.Columns {
+				want = append(want, col.Value)
+			}
+			if strings.Join(columns, ",") != strings.Join(want, ",") {
+				t.Errorf("columns %v, want %v", columns, want)
+			}
+			if got.From != tt.want.From || got.Format != tt.want.Format || got.Header != tt.want.Header {
+				t.Errorf("got from %v, format %s, header %v, want %v, %s, %v",
+					got.From, got.Format, got.Header, tt.want.From, tt.want.Format, tt.want.Header)
+			}
+			var query string
+			if got.Query != nil {
+				query = got.Query.String()
+			}
+			if query != tt.query {
+				t.Errorf("query %q, want %q", query, tt.query)
+			}
+		})
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		src         string
+		compression string
+		fails       bool
+	}{
+		{"create table t (a text)", "", false},
+		{"create table t (a text) with (compression = 'lz')", "lz", false},
+		{"CREATE TABLE t (a text) WITH (COMPRESSION = 'lz')", "lz", false},
+		{"create table t (a text) with (compression = lz)", "", true},
+		{"create table t (a text) with (compression 'lz')", "", true},
+		{"create table t (a text) with (fillfactor = 'lz')", "", true},
+		{"create table t (a text) with (compression = 'lz'", "", true},
+		{"create external table t (a text) location 't.csv' with (compression = 'lz')", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			ast, err := Parse(tt.src)
+			if (err != nil) != tt.fails {
+				t.Fatalf("got %v, want failing %v", err, tt.fails)
+			}
+			if err != nil {
+				return
+			}
+
+			var compression string
+			if c := ast.Statements[0].CreateTableStatement.Compression; c != nil {
+				compression = c.Value
+			}
+			if compression != tt.compression {
+				t.Errorf("got %q, want %q", compression, tt.compression)
+			}
+		})
+	}
+}
+
+func TestParseInSubquery(t *testing.T) {
+	tests := []struct {
+		src      string
+		subquery bool
+		want     string
+	}{
+		{"select a from t where a in (select b from u)", true, "SELECT a FROM t WHERE a IN (SELECT b FROM u)"},
+		{"select a from t where a not in (select b from u where c = a)", true, "SELECT a FROM t WHERE a NOT IN (SELECT b FROM u WHERE c = a)"},
+		{"select a from t where a in ((select b from u), 1)", false, "SELECT a FROM t WHERE a IN ((SELECT b FROM u), 1)"},
+	}
+
+	for _, tt := range tests {
+		ast, err := Parse(tt.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+
+		slct := ast.Statements[0].SelectStatement
+		if slct.Where.Type != InType || slct.Where.In.Subquery != tt.subquery {
+			t.Errorf("%s: parsed %+v", tt.src, slct.Where)
+		}
+		if got := slct.String(); got != tt.want {
+			t.Errorf("%s: formatted as %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"select a from t order by a", "SELECT a FROM t ORDER BY a"},
+		{"select a, b as c from t where a > 1 order by c desc, 1 asc, lower(b) limit 10",
+			"SELECT a, b AS c FROM t WHERE a > 1 ORDER BY c DESC, 1, lower(b) LIMIT 10"},
+		{"select a from t limit $1 offset $2", "SELECT a FROM t LIMIT $1 OFFSET $2"},
+		{"select a from t offset 5", "SELECT a FROM t OFFSET 5"},
+		{"select a from t start with a = 1 connect by prior a = b order by level desc",
+			"SELECT a FROM t START WITH a = 1 CONNECT BY PRIOR a = b ORDER BY level DESC"},
+		{"select a from t where a in (select b from u order by b limit 1)",
+			"SELECT a FROM t WHERE a IN (SELECT b FROM u ORDER BY b LIMIT 1)"},
+		{"select a from t order a", ""},
+		{"select a from t order by", ""},
+		{"select a from t limit", ""},
+		{"select a from t limit 1 order by a", ""},
+	}
+
+	for _, tt := range tests {
+		ast, err := Parse(tt.src)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: parsed", tt.src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+
+		if got := ast.Statements[0].SelectStatement.String(); got != tt.want {
+			t.Errorf("%s: formatted as %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseConstraints(t *testing.T) {
+	tests := []struct {
+		src string
+		// want describes the constraints of the column made a foreign key
+		// or unique, empty when src doesn't parse
+		want string
+	}{
+		{"create table c (id int, pid int references p (id))", "pid references p (id)"},
+		{"create table c (pid int generated by default as identity references p (id))", "pid references p (id)"},
+		{"alter table c add column pid int references p (id)", "pid references p (id)"},
+		{"alter table c add foreign key (pid) references p (id)", "pid references p (id)"},
+		{"create table c (pid int references p (id) deferrable initially deferred)", "pid references p (id) deferred"},
+		{"create table c (pid int references p (id) deferrable)", "pid references p (id)"},
+		{"create table c (pid int references p (id) initially immediate)", "pid references p (id)"},
+		{"create table c (pid int references p (id) not deferrable unique)", "pid references p (id) unique"},
+		{"create table c (pid int unique deferrable initially deferred references p (id))", "pid references p (id) unique deferred"},
+		{"create table c (id int unique)", "id unique"},
+		{"alter table c add unique (id) initially deferred", "id unique deferred"},
+		{"alter table c add unique int", "unique"},
+		{"alter table c add foreign key (pid) references p (id) deferrable initially deferred", "pid references p (id) deferred"},
+		{"create table c (pid int references p)", ""},
+		{"create table c (pid int references (id))", ""},
+		{"create table c (id int unique unique)", ""},
+		{"create table c (id int unique not deferrable initially deferred)", ""},
+		{"create table c (id int unique initially)", ""},
+		{"alter table c add foreign key pid references p (id)", ""},
+		{"alter table c add foreign key (pid)", ""},
+		{"alter table c add unique (id", ""},
+	}
+
+	for _, tt := range tests {
+		ast, err := Parse(tt.src)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: parsed", tt.src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+
+		var got TableConstraint
+		switch stmt := ast.Statements[0]; {
+		case stmt.CreateTableStatement != nil:
+			cols := *stmt.CreateTableStatement.Cols
+			col := cols[len(cols)-1]
+			got = TableConstraint{Column: col.Name, References: col.References, Unique: col.Unique}
+		case stmt.AlterTableStatement.Add != nil:
+			add := stmt.AlterTableStatement.Add
+			got = TableConstraint{Column: add.Name, References: add.References, Unique: add.Unique}
+		default:
+			got = *stmt.AlterTableStatement.Constraint
+		}
+
+		described := got.Column.Value
+		if fk := got.References; fk != nil {
+			described += " references " + fk.Table.Value + " (" + fk.Column.Value + ")"
+			if fk.Deferred {
+				described += " deferred"
+			}
+		}
+		if got.Unique != nil {
+			described += " unique"
+			if got.Unique.Deferred {
+				described += " deferred"
+			}
+		}
+		if described != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.src, described, tt.want)
+		}
+	}
+}
+
+func TestParseSmallestInteger(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"select -9223372036854775808", int64(math.MinInt64)},
+		{"select - 9223372036854775808", int64(math.MinInt64)},
+		{"select -9223372036854775807", int64(-9223372036854775807)},
+		{"select 9223372036854775808", 9223372036854775808.0},
+		{"select -9223372036854775809", -9223372036854775809.0},
+	}
+
+	// value returns the number the first item of src is, whether it is
+	// parsed as a literal or subtracted from zero
+	value := func(src string) interface{} {
+		ast, err := Parse(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+
+		exp := ast.Statements[0].SelectStatement.Item[0].Exp
+		if exp.Type != BinaryType {
+			return exp.Literal.Interface()
+		}
+		switch v := exp.Binary.B.Literal.Interface().(type) {
+		case int64:
+			return -v
+		case float64:
+			return -v
+		}
+		return nil
+	}
+
+	for _, tt := range tests {
+		if got := value(tt.src); got != tt.want {
+			t.Errorf("%s: got %#v, want %#v", tt.src, got, tt.want)
+		}
+
+		ast, err := Parse(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted := ast.Statements[0].SelectStatement.String()
+		if got := value(formatted); got != tt.want {
+			t.Errorf("%s: formatted as %s, which is %#v", tt.src, formatted, got)
+		}
+
+		if _, values, err := Normalize(tt.src); err != nil || len(values) != 1 || values[0] != tt.want {
+			t.Errorf("%s: normalized to %v, %v", tt.src, values, err)
+		}
+	}
+}
+
+// TestParseNestedArraysInLinearTime checks that ARRAY[ nested without being
+// closed fails quickly. Every level used to be parsed again as indexing a
+// column called array, doubling the time with each level.
+func TestParseNestedArraysInLinearTime(t *testing.T) {
+	tests := []struct {
+		src string
+		ok  bool
+	}{
+		{"select " + strings.Repeat("array[", 1000), false},
+		{"select " + strings.Repeat("array[array[1], ", 500) + "1" + strings.Repeat("]", 500), true},
+		{"select " + strings.Repeat("array[1, array[", 500), false},
+		{"select array[1, 2][1], array[]", true},
+		{"select array from t", true},
+		{"select array[", false},
+	}
+
+	for _, tt := range tests {
+		done := make(chan error, 1)
+		go func() {
+			_, err := Parse(tt.src)
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if (err == nil) != tt.ok {
+				t.Errorf("Parse(%.40q...) = %v, want ok %v", tt.src, err, tt.ok)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Parse(%.40q...) took more than 5s", tt.src)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"select 'rekey'", "select 'rekey'"},
+		{"rekey 'abcd'", "rekey '<redacted>'"},
+		{"REKEY 'ab''cd'; select 1", "REKEY '<redacted>'; select 1"},
+		{"select 1; rekey 'ab' ; rekey 'cd'", "select 1; rekey '<redacted>' ; rekey '<redacted>'"},
+		{"rekey $1", "rekey $1"},
+		{"rekey 'abcd", "REKEY '<redacted>'"},
+	}
+
+	for _, tt := range tests {
+		if got := Redact(tt.src); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseLiterals(t *testing.T) {
+	tests := []struct {
+		src  string
+		typ  ValueType
+		want interface{}
+	}{
+		{"select 42", Int64Value, int64(42)},
+		{"select 9223372036854775807", Int64Value, int64(math.MaxInt64)},
+		{"select 1.5", Float64Value, 1.5},
+		{"select 2e3", Float64Value, 2000.0},
+		{"select 'text'", StringValue, "text"},
+		{"select 'it''s'", StringValue, "it's"},
+		{"select ''", StringValue, ""},
+		{"select true", BoolValue, true},
+		{"select FALSE", BoolValue, false},
+		{"select null", NullValue, nil},
+	}
+
+	for _, tt := range tests {
+		ast, err := Parse(tt.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+
+		exp := ast.Statements[0].SelectStatement.Item[0].Exp
+		if exp.Type != LiteralType || exp.Literal.Type != tt.typ {
+			t.Errorf("%s: parsed %+v, want a literal of type %v", tt.src, exp, tt.typ)
+			continue
+		}
+		if got := exp.Literal.Interface(); got != tt.want {
+			t.Errorf("%s: got %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
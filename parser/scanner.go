@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// StatementScanner reads statements from an io.Reader one at a time, so
+// scripts of any size can be parsed while holding only the current
+// statement in memory. Statements are split on semicolons outside of string
+// literals and quoted identifiers.
+type StatementScanner struct {
+	r    *bufio.Reader
+	loc  Location
+	buf  strings.Builder
+	stmt *Statement
+	text string
+	err  error
+}
+
+func NewStatementScanner(r io.Reader) *StatementScanner {
+	return &StatementScanner{r: bufio.NewReader(r)}
+}
+
+// Scan advances to the next statement. It returns false at the end of the
+// input or on the first error, which Err then reports.
+func (s *StatementScanner) Scan() bool {
+	for s.err == nil {
+		text, loc, err := s.readStatement()
+		if err != nil && err != io.EOF {
+			s.err = err
+			return false
+		}
+
+		if strings.TrimSpace(text) != "" {
+			stmt, err := parseStatementText(text, loc)
+			// Text holding only comments is skipped like blank text
+			if stmt != nil || err != nil {
+				s.stmt, s.err, s.text = stmt, err, text
+				return s.err == nil
+			}
+		}
+
+		if err == io.EOF {
+			return false
+		}
+	}
+
+	return false
+}
+
+// Statement returns the statement parsed by the last call to Scan.
+func (s *StatementScanner) Statement() *Statement {
+	return s.stmt
+}
+
+// Text returns the source of the statement parsed by the last call to Scan,
+// without the terminating semicolon.
+func (s *StatementScanner) Text() string {
+	return s.text
+}
+
+func (s *StatementScanner) Err() error {
+	return s.err
+}
+
+// readStatement reads up to the next semicolon that ends a statement and
+// returns the text before it along with where that text starts.
+func (s *StatementScanner) readStatement() (string, Location, error) {
+	s.buf.Reset()
+	start := s.loc
+
+	// comment is '-' inside a -- comment and '*' inside a /* */ comment,
+	// where semicolons don't end the statement
+	var quote, comment, prev byte
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return s.buf.String(), start, err
+		}
+
+		if c == '\n' {
+			s.loc.Line++
+			s.loc.Column = 0
+		} else {
+			s.loc.Column++
+		}
+
+		last := prev
+		prev = c
+		switch {
+		case quote != 0:
+			// Doubled quotes leave and immediately re-enter the quoted text,
+			// so they need no special handling
+			if c == quote {
+				quote = 0
+			}
+		case comment == '-':
+			if c == '\n' {
+				comment = 0
+			}
+		case comment == '*':
+			if last == '*' && c == '/' {
+				comment, prev = 0, 0
+			}
+		case last == '-' && c == '-':
+			comment = '-'
+		case last == '/' && c == '*':
+			// The * opening the comment can't also close it
+			comment, prev = '*', 0
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';':
+			return s.buf.String(), start, nil
+		}
+
+		s.buf.WriteByte(c)
+	}
+}
+
+// parseStatementText parses src as exactly one statement, or returns no
+// statement if src holds only comments.
+func parseStatementText(src string, loc Location) (*Statement, error) {
+	tokens, err := tokenizeFrom(src, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	if err := checkNesting(tokens); err != nil {
+		return nil, err
+	}
+
+	stmt, cursor, ok := parseStatement(tokens, 0)
+	if !ok {
+		helpMessage(tokens, 0, "Expected statement")
+		return nil, errors.New("Failed to parse, expected statement")
+	}
+
+	if cursor < uint(len(tokens)) {
+		helpMessage(tokens, cursor, "Expected end of statement")
+		return nil, errors.New("Unexpected tokens after statement")
+	}
+
+	stmt.Text = strings.TrimSpace(src)
+
+	return stmt, nil
+}
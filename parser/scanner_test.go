@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatementScanner(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		texts []string
+		ok    bool
+	}{
+		{"one statement", "select 1", []string{"select 1"}, true},
+		{"terminated", "select 1;\nselect 2;\n", []string{"select 1", "select 2"}, true},
+		{"semicolon in a string", "insert into t values ('a;b'); select 1", []string{"insert into t values ('a;b')", "select 1"}, true},
+		{"doubled quote", "insert into t values ('it''s;'); select 1", []string{"insert into t values ('it''s;')", "select 1"}, true},
+		{"quoted identifier", `select "a;b" from t; select 1`, []string{`select "a;b" from t`, "select 1"}, true},
+		{"line comment", "select 1 -- a; b\n; select 2", []string{"select 1 -- a; b", "select 2"}, true},
+		{"block comment", "select /* a; */ 1; select 2", []string{"select /* a; */ 1", "select 2"}, true},
+		{"blank statements", ";;\n select 1;;", []string{"select 1"}, true},
+		{"only comments", "-- nothing here;\n/* or; here */", nil, true},
+		{"empty", "", nil, true},
+		{"stops at an error", "select 1; selec 2; select 3", []string{"select 1"}, false},
+		{"two statements without a semicolon", "select 1 select 2", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStatementScanner(strings.NewReader(tt.src))
+			var texts []string
+			for s.Scan() {
+				if s.Statement() == nil {
+					t.Fatalf("scanned %q without a statement", s.Text())
+				}
+				texts = append(texts, s.Statement().Text)
+			}
+
+			if (s.Err() == nil) != tt.ok {
+				t.Errorf("got error %v, want ok %v", s.Err(), tt.ok)
+			}
+			if !reflect.DeepEqual(texts, tt.texts) {
+				t.Errorf("scanned %q, want %q", texts, tt.texts)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"strconv"
+)
+
+type ValueType uint
+
+const (
+	NullValue ValueType = iota
+	Int64Value
+	Float64Value
+	StringValue
+	BoolValue
+)
+
+// Value is a constant converted from its token at parse time. Only the field
+// matching Type is set.
+type Value struct {
+	Type    ValueType
+	Int64   int64
+	Float64 float64
+	String  string
+	Bool    bool
+}
+
+// Interface returns the value as nil, int64, float64, string or bool.
+func (v *Value) Interface() interface{} {
+	switch v.Type {
+	case Int64Value:
+		return v.Int64
+	case Float64Value:
+		return v.Float64
+	case StringValue:
+		return v.String
+	case BoolValue:
+		return v.Bool
+	}
+
+	return nil
+}
+
+// valueFromToken converts a literal token to its value. Numbers without a
+// fraction or exponent that fit in an int64 are integers, all others are
+// floats.
+func valueFromToken(t *Token) (*Value, bool) {
+	switch t.Type {
+	case StringType:
+		return &Value{Type: StringValue, String: t.Value}, true
+	case NumericType:
+		if i, err := strconv.ParseInt(t.Value, 10, 64); err == nil {
+			return &Value{Type: Int64Value, Int64: i}, true
+		}
+
+		f, err := strconv.ParseFloat(t.Value, 64)
+		if err != nil {
+			return nil, false
+		}
+
+		return &Value{Type: Float64Value, Float64: f}, true
+	case KeywordType:
+		switch keyword(t.Value) {
+		case trueKeyword:
+			return &Value{Type: BoolValue, Bool: true}, true
+		case falseKeyword:
+			return &Value{Type: BoolValue, Bool: false}, true
+		case nullKeyword:
+			return &Value{Type: NullValue}, true
+		}
+	}
+
+	return nil, false
+}
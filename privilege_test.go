@@ -0,0 +1,299 @@
+package sgsql
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/types"
+)
+
+// openTest opens a database in a file of its own, which is removed after
+// the test.
+func openTest(t *testing.T) (*DB, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, path
+}
+
+// connAs opens a session on db running as user.
+func connAs(t *testing.T, db *DB, user string) *Conn {
+	t.Helper()
+
+	c := db.Conn()
+	c.SetUser(user)
+	t.Cleanup(func() { c.Close() })
+
+	return c
+}
+
+func mustExec(t *testing.T, c interface {
+	Exec(string, ...interface{}) error
+}, queries ...string) {
+	t.Helper()
+
+	for _, query := range queries {
+		if err := c.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+}
+
+func TestOnlySuperuserGrants(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db,
+		"create table t (id int, ssn text)",
+		"insert into t values (1, '123-45-6789')",
+		"alter table t alter column ssn set masked with partial(4)",
+		"create role readers",
+	)
+
+	alice := connAs(t, db, "alice")
+	for _, query := range []string{
+		"grant unmask to alice",
+		"revoke unmask from alice",
+		"grant readers to alice",
+		"revoke readers from alice",
+		"create role admins",
+		"drop role readers",
+		"alter table t alter column ssn drop masked",
+		"alter table t alter column id set masked with default()",
+	} {
+		if err := alice.Exec(query); !errors.Is(err, backend.ErrPermissionDenied) {
+			t.Errorf("%s as alice: got %v, want %v", query, err, backend.ErrPermissionDenied)
+		}
+	}
+
+	results, err := alice.Query("select ssn from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := results.Rows[0][0]; got == "123-45-6789" {
+		t.Errorf("alice reads the masked ssn %v", got)
+	}
+
+	// The superuser still can, and what it granted applies
+	mustExec(t, db, "grant readers to alice", "grant unmask to readers")
+	results, err = alice.Query("select ssn from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := results.Rows[0][0]; got != "123-45-6789" {
+		t.Errorf("alice reads %v after being granted UNMASK, want the ssn", got)
+	}
+}
+
+func TestReplaySkipsPrivilegeChecks(t *testing.T) {
+	db, path := openTest(t)
+	mustExec(t, db, "create role readers")
+
+	// A log written before GRANT was checked holds grants by other users,
+	// which must still replay
+	entry := logEntry{Query: "grant readers to alice", User: "alice"}
+	if _, err := db.appendLog([]logEntry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	results, err := db.Query("select members from __roles where role_name = 'readers'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members := results.Rows[0][0].(types.Array).Values; len(members) != 1 || members[0] != "alice" {
+		t.Errorf("readers has members %v after replay, want alice", members)
+	}
+}
+
+func TestOnlySuperuserChangesPolicies(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db,
+		"create table docs (owner text, body text)",
+		"insert into docs values ('alice', 'hers')",
+		"insert into docs values ('bob', 'his')",
+		"create policy own on docs using (owner = current_user())",
+	)
+
+	alice := connAs(t, db, "alice")
+	for _, query := range []string{
+		"drop policy own on docs",
+		"create policy everything on docs using (true)",
+	} {
+		if err := alice.Exec(query); !errors.Is(err, backend.ErrPermissionDenied) {
+			t.Errorf("%s as alice: got %v, want %v", query, err, backend.ErrPermissionDenied)
+		}
+	}
+
+	results, err := alice.Query("select body from docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Rows) != 1 || results.Rows[0][0] != "hers" {
+		t.Errorf("alice reads %v, want only her own row", results.Rows)
+	}
+
+	mustExec(t, db, "drop policy own on docs")
+	results, err = alice.Query("select body from docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Rows) != 2 {
+		t.Errorf("alice reads %v once the policy is dropped, want every row", results.Rows)
+	}
+}
+
+func TestPoliciesCheckInserts(t *testing.T) {
+	db, path := openTest(t)
+	mustExec(t, db,
+		"create table docs (owner text, body text)",
+		"create policy own on docs using (owner = current_user())",
+		"create policy superuser on docs using (current_user() = 'sgsql')",
+		"insert into docs values ('alice', 'from the superuser')",
+	)
+
+	mallory := connAs(t, db, "mallory")
+	if err := mallory.Exec("insert into docs values ('alice', 'forged')"); !errors.Is(err, backend.ErrPolicyViolation) {
+		t.Errorf("inserting a row of alice as mallory: got %v, want %v", err, backend.ErrPolicyViolation)
+	}
+	mustExec(t, mallory, "insert into docs values ('mallory', 'hers')")
+
+	// Bulk inserts are held to the same policies, row by row
+	tx, err := mallory.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]interface{}{{"mallory", "bulk"}, {"alice", "forged in bulk"}}
+	if err := tx.BulkInsert("docs", rows); !errors.Is(err, backend.ErrPolicyViolation) {
+		t.Errorf("bulk inserting a row of alice as mallory: got %v, want %v", err, backend.ErrPolicyViolation)
+	}
+	if err := tx.BulkInsert("docs", rows[:1]); err != nil {
+		t.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connAs(t, db, "alice")
+	mustExec(t, alice, "insert into docs values ('alice', 'hers')")
+
+	db = reopen(t, db, path)
+	results, err := db.Query("select owner, body from docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Rows) != 4 {
+		t.Errorf("docs holds %v after replay, want the four rows allowed", results.Rows)
+	}
+}
+
+func TestPoliciesHideStats(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db,
+		"create table docs (owner text, body text)",
+		"insert into docs values ('alice', 'hers')",
+		"insert into docs values ('bob', 'his')",
+		"create index docs_owner on docs (owner)",
+		"create statistics docs_stats on owner, body from docs",
+		"create policy own on docs using (owner = current_user())",
+		"create table open (id int, name text)",
+		"create index open_id on open (id)",
+		"create statistics open_stats on id, name from open",
+		"analyze",
+	)
+
+	tests := []struct {
+		user string
+		// docs is whether the user sees the rows about docs
+		docs bool
+	}{
+		{"mallory", false},
+		{"alice", false},
+		{"sgsql", true},
+	}
+
+	for _, tt := range tests {
+		c := connAs(t, db, tt.user)
+		for _, table := range []string{"__table_stats", "__index_stats", "__column_stats", "__statistics"} {
+			t.Run(tt.user+" "+table, func(t *testing.T) {
+				results, err := c.Query("select table_name from " + table)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				docs, open := false, false
+				for _, row := range results.Rows {
+					docs = docs || row[0] == "docs"
+					open = open || row[0] == "open"
+				}
+				if docs != tt.docs || !open {
+					t.Errorf("got rows about %v, want docs %v and open", results.Rows, tt.docs)
+				}
+			})
+		}
+	}
+}
+
+func TestOnlySuperuserChangesProtectedTables(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db,
+		"create table docs (owner text, body text)",
+		"insert into docs values ('alice', 'hers')",
+		"create policy own on docs using (owner = current_user())",
+		"create table notes (id int, ssn text)",
+		"alter table notes alter column ssn set masked with partial(4)",
+		"create index docs_body on docs (body)",
+	)
+
+	mallory := connAs(t, db, "mallory")
+	for _, query := range []string{
+		"drop table docs",
+		"alter table docs rename to mine",
+		"alter table docs rename column owner to author",
+		"alter table docs add column extra text",
+		"create index docs_owner on docs (owner)",
+		"drop index docs_body",
+		"drop table notes",
+		"alter table notes drop column ssn",
+	} {
+		if err := mallory.Exec(query); !errors.Is(err, backend.ErrPermissionDenied) {
+			t.Errorf("%s as mallory: got %v, want %v", query, err, backend.ErrPermissionDenied)
+		}
+	}
+
+	mustExec(t, db,
+		"create index docs_owner on docs (owner)",
+		"drop index docs_body",
+		"alter table docs add column extra text",
+		"alter table notes drop column ssn",
+		"drop table docs",
+	)
+
+	// Tables nothing protects stay open to everyone
+	mustExec(t, mallory, "create table scratch (id int)", "create index scratch_id on scratch (id)", "drop table scratch")
+}
+
+func TestOnlySuperuserVacuums(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db, "create table t (id int)", "insert into t values (1)")
+
+	alice := connAs(t, db, "alice")
+	if err := alice.Exec("vacuum"); !errors.Is(err, backend.ErrPermissionDenied) {
+		t.Errorf("vacuum as alice: got %v, want %v", err, backend.ErrPermissionDenied)
+	}
+	mustExec(t, db, "vacuum")
+}
@@ -0,0 +1,189 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+var (
+	ErrTooManyQueries = errors.New("Too many queries running for the user")
+	ErrRowLimit       = errors.New("Query returned more rows than its quota allows")
+	ErrQueryTimeout   = errors.New("Query ran longer than its quota allows")
+)
+
+// Quota limits the queries of a user or a session. Zero fields don't limit
+// anything.
+type Quota struct {
+	// MaxRows is the most rows a statement may return, a query returning
+	// more fails
+	MaxRows int
+	// MaxDuration is the longest a query may run before it is canceled
+	MaxDuration time.Duration
+	// MaxConcurrent is the most queries of the user that may run at once,
+	// counted over all of their sessions. Further queries fail, or wait
+	// for one to finish when Queue is set.
+	MaxConcurrent int
+	Queue         bool
+}
+
+// quotaList holds the quotas of the users of a database and counts the
+// queries each of them is running.
+type quotaList struct {
+	mu      sync.Mutex
+	byUser  map[string]Quota
+	running map[string]int
+	// released is closed when a query of any user finishes, waking the
+	// queued ones to check whether they can run
+	released chan struct{}
+}
+
+// SetQuota limits the queries of user, or of every user without a quota of
+// their own when user is empty. A zero quota removes the limits. Sessions
+// can be given quotas of their own with Conn.SetQuota.
+func (db *DB) SetQuota(user string, quota Quota) {
+	db.quotas.mu.Lock()
+	defer db.quotas.mu.Unlock()
+
+	if db.quotas.byUser == nil {
+		db.quotas.byUser = map[string]Quota{}
+	}
+
+	if quota == (Quota{}) {
+		delete(db.quotas.byUser, user)
+		return
+	}
+	db.quotas.byUser[user] = quota
+}
+
+// SetQuota limits the queries of the session, replacing the quota of its
+// user. A zero quota goes back to that of the user.
+func (c *Conn) SetQuota(quota Quota) {
+	c.quota = quota
+}
+
+// currentQuota returns the quota the next query of the session runs with.
+func (c *Conn) currentQuota(user string) Quota {
+	if c.quota != (Quota{}) {
+		return c.quota
+	}
+
+	c.db.quotas.mu.Lock()
+	defer c.db.quotas.mu.Unlock()
+
+	if quota, ok := c.db.quotas.byUser[user]; ok {
+		return quota
+	}
+	return c.db.quotas.byUser[""]
+}
+
+// acquire counts a query of user as running until the returned function is
+// called, first waiting for one of theirs to finish if they are running as
+// many as quota allows and it queues.
+func (q *quotaList) acquire(ctx context.Context, user string, quota Quota) (func(), error) {
+	if quota.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	for q.running[user] >= quota.MaxConcurrent {
+		if !quota.Queue {
+			q.mu.Unlock()
+			return nil, fmt.Errorf("%w: at most %d", ErrTooManyQueries, quota.MaxConcurrent)
+		}
+
+		if q.released == nil {
+			q.released = make(chan struct{})
+		}
+		released := q.released
+		q.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		q.mu.Lock()
+	}
+
+	if q.running == nil {
+		q.running = map[string]int{}
+	}
+	q.running[user]++
+	q.mu.Unlock()
+
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		q.running[user]--
+		if q.running[user] == 0 {
+			delete(q.running, user)
+		}
+		if q.released != nil {
+			close(q.released)
+			q.released = nil
+		}
+	}, nil
+}
+
+type maxRowsKey struct{}
+
+// checkRows fails results that have more rows than the quota of the query
+// of ctx allows.
+func checkRows(ctx context.Context, results *Results) error {
+	max, _ := ctx.Value(maxRowsKey{}).(int)
+	if max > 0 && results != nil && len(results.Rows) > max {
+		return fmt.Errorf("%w: %d rows, at most %d", ErrRowLimit, len(results.Rows), max)
+	}
+
+	return nil
+}
+
+// limitRows returns ctx with its row writer, if it has one, failing once
+// more rows are written than the quota of the query of ctx allows.
+func limitRows(ctx context.Context) context.Context {
+	max, _ := ctx.Value(maxRowsKey{}).(int)
+	w := backend.RowWriterOf(ctx)
+	if max <= 0 || w == nil {
+		return ctx
+	}
+
+	return backend.WithRowWriter(ctx, &limitedWriter{RowWriter: w, max: max})
+}
+
+// limitedWriter is a row writer failing past the max rows of a quota.
+type limitedWriter struct {
+	backend.RowWriter
+	max, written int
+}
+
+func (w *limitedWriter) WriteRow(row []interface{}) error {
+	if w.written++; w.written > w.max {
+		return fmt.Errorf("%w: more than %d rows", ErrRowLimit, w.max)
+	}
+
+	return w.RowWriter.WriteRow(row)
+}
+
+// SetResultCache keeps the results of up to size recent queries to answer
+// them again while the tables they read are unchanged, see
+// backend.MemoryBackend.SetResultCache. A size that isn't positive turns
+// the cache off, which is the default.
+func (db *DB) SetResultCache(size int) {
+	db.backend.SetResultCache(size)
+}
+
+// SetMemoryBudget limits the memory the queries running at any moment may
+// buffer, like the rows of their results, to limit bytes. A query needing
+// more fails with an error matching budget.ErrExceeded rather than the
+// process running out of memory, though correlated subqueries whose hash
+// tables don't fit switch to running once per row instead. A limit that
+// isn't positive removes the limit.
+func (db *DB) SetMemoryBudget(limit int64) {
+	db.backend.SetMemoryBudget(limit)
+}
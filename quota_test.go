@@ -0,0 +1,153 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaRows(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db, "create table t (id int)", "insert into t values (1)", "insert into t values (2)", "insert into t values (3)")
+
+	// Users without a quota of their own have the default one
+	db.SetQuota("", Quota{MaxRows: 2})
+	db.SetQuota("bob", Quota{MaxRows: 3})
+	alice, bob := connAs(t, db, "alice"), connAs(t, db, "bob")
+
+	tests := []struct {
+		conn  *Conn
+		query string
+		err   error
+	}{
+		{alice, "select id from t where id < 3", nil},
+		{alice, "select id from t", ErrRowLimit},
+		{alice, "select * from generate_series(1, 3)", ErrRowLimit},
+		{bob, "select id from t", nil},
+		{bob, "select * from generate_series(1, 4)", ErrRowLimit},
+	}
+	for _, tt := range tests {
+		if _, err := tt.conn.Query(tt.query); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.query, err, tt.err)
+		}
+	}
+
+	// A quota of the session replaces that of its user, and a zero one
+	// removes it
+	alice.SetQuota(Quota{MaxRows: 10})
+	if _, err := alice.Query("select id from t"); err != nil {
+		t.Errorf("with a session quota: %v", err)
+	}
+	alice.SetQuota(Quota{})
+	if _, err := alice.Query("select id from t"); !errors.Is(err, ErrRowLimit) {
+		t.Errorf("without a session quota: got %v, want %v", err, ErrRowLimit)
+	}
+	db.SetQuota("", Quota{})
+	if _, err := alice.Query("select id from t"); err != nil {
+		t.Errorf("without a quota: %v", err)
+	}
+}
+
+func TestQuotaDuration(t *testing.T) {
+	db, _ := openTest(t)
+	db.SetQuota("", Quota{MaxDuration: 20 * time.Millisecond})
+	c := connAs(t, db, "alice")
+
+	start := time.Now()
+	if _, err := c.Query("select count(*) from generate_series(1, 1000000000)"); !errors.Is(err, ErrQueryTimeout) {
+		t.Fatalf("got %v, want %v", err, ErrQueryTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the query was canceled after %s", elapsed)
+	}
+
+	// The session can go on
+	if _, err := c.Query("select 1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuotaConcurrent(t *testing.T) {
+	db, _ := openTest(t)
+	db.SetQuota("alice", Quota{MaxConcurrent: 1})
+	first, second := connAs(t, db, "alice"), connAs(t, db, "alice")
+	bob := connAs(t, db, "bob")
+
+	// The first session looks like it is running a query until done
+	ctx, done, err := first.track(context.Background(), "select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := second.Query("select 1"); !errors.Is(err, ErrTooManyQueries) {
+		t.Errorf("got %v, want %v", err, ErrTooManyQueries)
+	}
+	// Queries of other users don't count
+	if _, err := bob.Query("select 1"); err != nil {
+		t.Error(err)
+	}
+
+	// Queued queries wait for their turn, showing as waiting meanwhile
+	db.SetQuota("alice", Quota{MaxConcurrent: 1, Queue: true})
+	result := make(chan error, 1)
+	go func() {
+		_, err := second.Query("select 2")
+		result <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rows := queryRows(t, db, "select count(*) from __sessions where state = 'waiting'")
+		if rows[0][0] == int64(1) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The queued query never showed as waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case err := <-result:
+		t.Fatalf("the queued query ran with %v before its turn", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := done(ctx.Err()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The queued query didn't run once the first one finished")
+	}
+}
+
+func TestQuotaQueueCanceled(t *testing.T) {
+	var quotas quotaList
+	quota := Quota{MaxConcurrent: 1, Queue: true}
+
+	release, err := quotas.acquire(context.Background(), "alice", quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := quotas.acquire(ctx, "alice", quota); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	release()
+	if n := len(quotas.running); n != 0 {
+		t.Errorf("%d users still counted as running queries", n)
+	}
+	if release, err = quotas.acquire(context.Background(), "alice", quota); err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -0,0 +1,33 @@
+package sgsql
+
+import "errors"
+
+var ErrNoReload = errors.New("There is no configuration to reload")
+
+// reloadBox lets a nil reload function be stored in an atomic.Value.
+type reloadBox struct {
+	reload func() error
+}
+
+// SetReload makes RELOAD CONFIG call reload, which reads the configuration
+// the database runs with again and applies it. The database doesn't know
+// where its settings come from, so whatever opened it provides this, like
+// the server reading its configuration file. A nil reload makes RELOAD
+// CONFIG fail with ErrNoReload.
+func (db *DB) SetReload(reload func() error) {
+	db.reload.Store(reloadBox{reload})
+}
+
+// reloadConfig runs RELOAD CONFIG, which only the superuser may run.
+func (c *Conn) reloadConfig() (*Results, error) {
+	if err := c.checkSuperuser("reload the configuration"); err != nil {
+		return nil, err
+	}
+
+	box, _ := c.db.reload.Load().(reloadBox)
+	if box.reload == nil {
+		return nil, ErrNoReload
+	}
+
+	return &Results{}, box.reload()
+}
@@ -0,0 +1,55 @@
+package sgsql
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+func TestReloadConfig(t *testing.T) {
+	failed := errors.New("config: 1: Unknown setting")
+	tests := []struct {
+		name   string
+		user   string
+		dryRun bool
+		reload func() error
+		// calls is how many times reload runs
+		calls int
+		err   error
+	}{
+		{"superuser", "", false, func() error { return nil }, 1, nil},
+		{"failing", "", false, func() error { return failed }, 1, failed},
+		{"nothing to reload", "", false, nil, 0, ErrNoReload},
+		{"other user", "alice", false, func() error { return nil }, 0, backend.ErrPermissionDenied},
+		{"dry run", "", true, func() error { return nil }, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := openTest(t)
+
+			calls := 0
+			if tt.reload != nil {
+				db.SetReload(func() error {
+					calls++
+					return tt.reload()
+				})
+			}
+
+			c := db.Conn()
+			t.Cleanup(func() { c.Close() })
+			if tt.user != "" {
+				c.SetUser(tt.user)
+			}
+			c.SetDryRun(tt.dryRun)
+
+			if err := c.Exec("reload config"); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+			if calls != tt.calls {
+				t.Errorf("reloaded %d times, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package sgsql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		name   string
+		rename string
+		// queries each return want once the rename is done, also after
+		// reopening the database
+		queries []string
+		want    [][][]interface{}
+		// gone reads what was renamed by its old name
+		gone string
+	}{
+		{
+			"table",
+			"alter table t rename to u",
+			[]string{
+				"select a, b from u where a = 2",
+				"select index_name, table_name from __index_stats",
+				"select table_name from __dependencies where object_name = 'v'",
+				"refresh materialized view v; select * from v",
+			},
+			[][][]interface{}{
+				{{int64(2), "two"}},
+				{{"t_a", "u"}},
+				{{"u"}},
+				{{int64(1)}, {int64(2)}},
+			},
+			"select * from t",
+		},
+		{
+			"column",
+			"alter table t rename column a to c",
+			[]string{
+				"select c, b from t where c = 2",
+				"refresh materialized view v; select * from v",
+			},
+			[][][]interface{}{
+				{{int64(2), "two"}},
+				{{int64(1)}, {int64(2)}},
+			},
+			"select a from t",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			mustExec(t, db,
+				"create table t (a int, b text)",
+				"create index t_a on t (a)",
+				"insert into t values (1, 'one')",
+				"create materialized view v as select a from t",
+				"insert into t values (2, 'two')",
+				tt.rename,
+			)
+
+			check := func() {
+				t.Helper()
+				for i, query := range tt.queries {
+					if got := queryRows(t, db, query); !reflect.DeepEqual(got, tt.want[i]) {
+						t.Errorf("%s = %v, want %v", query, got, tt.want[i])
+					}
+				}
+				if _, err := db.Query(tt.gone); err == nil {
+					t.Errorf("%s still works", tt.gone)
+				}
+			}
+			check()
+
+			db = reopen(t, db, path)
+			check()
+		})
+	}
+}
+
+func TestRenameErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{"alter table t rename to u", "already exists"},
+		{"alter table missing rename to x", "does not exist"},
+		{"alter table t rename to __tables", ""},
+		{"alter table t rename column missing to x", "does not exist"},
+		{"alter table t rename column a to b", "already exists"},
+	}
+
+	db, _ := openTest(t)
+	mustExec(t, db, "create table t (a int, b int)", "create table u (a int)")
+
+	for _, tt := range tests {
+		err := db.Exec(tt.query)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.query, err, tt.err)
+		}
+	}
+
+	// Nothing was renamed
+	if got := queryRows(t, db, "select a, b from t"); got != nil {
+		t.Errorf("t holds %v", got)
+	}
+}
@@ -0,0 +1,128 @@
+package sgsql
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+// reopen closes db and opens the database at path again.
+func reopen(t *testing.T, db *DB, path string) *DB {
+	t.Helper()
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func queryRows(t *testing.T, db *DB, query string) [][]interface{} {
+	t.Helper()
+
+	results, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return results.Rows
+}
+
+func TestReplayKeepsVolatileValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+	}{
+		{"now", []string{"insert into t values (1, null, now(), null)"}},
+		{"current_timestamp", []string{"insert into t values (1, null, current_timestamp, null)"}},
+		{"current_date", []string{"insert into t values (1, null, current_date, null)"}},
+		{"random", []string{"insert into t values (1, null, null, random())", "insert into t values (2, (random() * 1000)::int::text, null, null)"}},
+		{"uuid", []string{"insert into t values (1, uuid()::text, null, null)"}},
+		{"several in one statement", []string{"insert into t values (1, now()::text || random()::text, now() + interval '1 day', random() + random())"}},
+		{"transaction", []string{"begin", "insert into t values (1, null, null, random())", "insert into t values (2, null, now(), null)", "commit"}},
+		{"prepared", []string{"insert into t values ($1, null, null, random())"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			c := db.Conn()
+			t.Cleanup(func() { c.Close() })
+			mustExec(t, c, "create table t (id int, v text, ts timestamp, f float)")
+			for _, query := range tt.queries {
+				if err := c.Exec(query, int64(3)); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+			c.Close()
+
+			before := queryRows(t, db, "select * from t")
+			if len(before) == 0 {
+				t.Fatal("no rows written")
+			}
+
+			db = reopen(t, db, path)
+			if after := queryRows(t, db, "select * from t"); !reflect.DeepEqual(before, after) {
+				t.Errorf("rows changed on reopening:\nbefore %v\nafter  %v", before, after)
+			}
+
+			// Replaying the log again after vacuuming it gives the same rows
+			mustExec(t, db, "vacuum")
+			db = reopen(t, db, path)
+			if after := queryRows(t, db, "select * from t"); !reflect.DeepEqual(before, after) {
+				t.Errorf("rows changed on reopening after vacuum:\nbefore %v\nafter  %v", before, after)
+			}
+		})
+	}
+}
+
+func TestReplayRejectsUnrepeatableReads(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"as of", "insert into t values ((select max(a) from u as of timestamp now()))"},
+		{"as of in a nested query", "insert into t values ((select max(a) from u where exists (select a from u as of timestamp now())))"},
+		{"materialized view as of", "create materialized view v as select a from u as of timestamp now()"},
+		{"external table", "insert into t values ((select sum(amount) from sales))"},
+		{"system table", "insert into t values ((select count(*) from __sessions))"},
+		{"system table in a nested query", "insert into t values ((select max(a) from u where exists (select table_name from __table_stats)))"},
+		{"external table in a nested query", "insert into t values ((select max(a) from u where a in (select amount from sales)))"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "sales.csv"), []byte("1\n2\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			db.SetExternalDir(dir)
+			mustExec(t, db, "create table t (a int)", "create table u (a int)", "insert into u values (1)",
+				"create external table sales (amount int) location 'sales.csv'")
+
+			if err := db.Exec(tt.query); !errors.Is(err, backend.ErrUnreplayable) {
+				t.Fatalf("%s: got %v, want %v", tt.query, err, backend.ErrUnreplayable)
+			}
+
+			// Reading them is fine when nothing is logged
+			queryRows(t, db, "select a from u as of timestamp now()")
+			queryRows(t, db, "select sum(amount) from sales")
+			queryRows(t, db, "select count(*) from __sessions")
+
+			db = reopen(t, db, path)
+			if rows := queryRows(t, db, "select a from t"); len(rows) != 0 {
+				t.Errorf("got rows %v after reopening, want none", rows)
+			}
+		})
+	}
+}
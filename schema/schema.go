@@ -0,0 +1,357 @@
+// Package schema compares the tables of databases, so a schema can be kept
+// as the CREATE TABLE statements describing it and a database brought in
+// line with it:
+//
+//	desired, err := schema.Parse(script)
+//	...
+//	stmts, err := schema.Diff(schema.FromBackend(db.Catalog()), desired)
+//	...
+//	for _, stmt := range stmts {
+//		if err := db.Exec(stmt.Text); err != nil {
+//			...
+//		}
+//	}
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nireo/sgsql/analyzer"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrNotCreateTable = errors.New("Schema can only hold CREATE TABLE statements")
+	ErrNoColumns      = errors.New("Tables without columns can't be created")
+	ErrNotPlainTable  = errors.New("Materialized views and external tables can't be turned into tables")
+)
+
+// Catalog is the tables of a database.
+type Catalog struct {
+	Tables []Table
+	// others are the materialized views and external tables FromBackend
+	// found, which Diff leaves alone, and readers a view reading each of
+	// the tables views read
+	others  map[string]bool
+	readers map[string]string
+}
+
+// Table is a table and its columns in order. Collations are the names they
+// were registered under, empty for the default.
+type Table struct {
+	Name    string
+	Columns []backend.Column
+}
+
+// FromBackend returns the tables c holds now. When c knows the kinds of its
+// tables, materialized views and external tables are left out, since
+// CREATE TABLE doesn't describe them, but Diff keeps from changing them and
+// the tables views read.
+func FromBackend(c backend.Catalog) *Catalog {
+	catalog := &Catalog{others: map[string]bool{}, readers: map[string]string{}}
+	kinds, _ := c.(backend.KindCatalog)
+	for _, name := range c.Tables() {
+		columns, ok := c.Columns(name)
+		if !ok {
+			continue
+		}
+
+		if kinds != nil {
+			if kind, reads := kinds.Kind(name); kind != backend.PlainTable {
+				catalog.others[name] = true
+				for _, read := range reads {
+					catalog.readers[read] = name
+				}
+				continue
+			}
+		}
+
+		catalog.Tables = append(catalog.Tables, Table{Name: name, Columns: columns})
+	}
+
+	return catalog
+}
+
+// Parse returns the tables created by script, which may only hold CREATE
+// TABLE statements. They are analyzed like they would be when run, so a
+// schema Parse accepts can be created.
+func Parse(script string) (*Catalog, error) {
+	ast, err := parser.Parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &Catalog{}
+	for _, stmt := range ast.Statements {
+		if stmt.Type != parser.CreateTableType || stmt.CreateTableStatement.Location != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotCreateTable, stmt.Text)
+		}
+
+		if err := analyzer.Analyze(backendCatalog{catalog}, stmt); err != nil {
+			return nil, err
+		}
+
+		crt := stmt.CreateTableStatement
+		t := Table{Name: crt.Name.Value, Columns: []backend.Column{}}
+		if crt.Cols != nil {
+			for _, col := range *crt.Cols {
+				column := backend.Column{Name: col.Name.Value}
+				column.Type, _ = types.Parse(col.Datatype.Value)
+				if col.Collate != nil {
+					collation, _ := types.LookupCollation(col.Collate.Value)
+					column.Collation = collation.Name
+				}
+				if col.Identity != nil {
+					column.Identity = "by default"
+					if col.Identity.Always {
+						column.Identity = "always"
+					}
+				}
+				if col.References != nil {
+					column.References = backend.Reference{Table: col.References.Table.Value,
+						Column: col.References.Column.Value, Deferred: col.References.Deferred}
+				}
+				if col.Unique != nil {
+					column.Unique, column.UniqueDeferred = true, col.Unique.Deferred
+				}
+
+				t.Columns = append(t.Columns, column)
+			}
+		}
+
+		catalog.Tables = append(catalog.Tables, t)
+	}
+
+	return catalog, nil
+}
+
+func (c *Catalog) table(name string) (*Table, bool) {
+	for i := range c.Tables {
+		if c.Tables[i].Name == name {
+			return &c.Tables[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// names returns the names of the tables of c in order.
+func (c *Catalog) names() []string {
+	names := make([]string, len(c.Tables))
+	for i, t := range c.Tables {
+		names[i] = t.Name
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// backendCatalog lets the analyzer check statements against a Catalog.
+type backendCatalog struct {
+	*Catalog
+}
+
+func (c backendCatalog) Columns(table string) ([]backend.Column, bool) {
+	t, ok := c.table(table)
+	if !ok {
+		return nil, false
+	}
+
+	return t.Columns, true
+}
+
+func (c backendCatalog) Tables() []string {
+	return c.names()
+}
+
+// checkUnread fails when a materialized view reads the table name, which
+// keeps it from being dropped or having its columns changed.
+func (c *Catalog) checkUnread(name string) error {
+	if view, ok := c.readers[name]; ok {
+		return fmt.Errorf("%w: %s reads %s", backend.ErrTableInUse, view, name)
+	}
+
+	return nil
+}
+
+func (t *Table) column(name string) (backend.Column, bool) {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+
+	return backend.Column{}, false
+}
+
+// definition formats col the way CREATE TABLE and ALTER TABLE take it,
+// leaving out the column it references, see foreignKey.
+func definition(col backend.Column) string {
+	def := parser.FormatIdentifier(col.Name) + " " + columnType(col)
+	if col.Identity != "" {
+		def += " GENERATED " + strings.ToUpper(col.Identity) + " AS IDENTITY"
+	}
+	if col.Unique {
+		def += " UNIQUE" + deferral(col.UniqueDeferred)
+	}
+
+	return def
+}
+
+// deferral is what describes a constraint as deferred, if it is.
+func deferral(deferred bool) string {
+	if deferred {
+		return " DEFERRABLE INITIALLY DEFERRED"
+	}
+
+	return ""
+}
+
+// foreignKey returns the ALTER TABLE making col of the table called name a
+// foreign key. Diff adds them once every table has been created, so tables
+// can reference tables created after them.
+func foreignKey(name string, col backend.Column) string {
+	return "ALTER TABLE " + parser.FormatIdentifier(name) + " ADD FOREIGN KEY (" + parser.FormatIdentifier(col.Name) +
+		") REFERENCES " + parser.FormatIdentifier(col.References.Table) + " (" + parser.FormatIdentifier(col.References.Column) + ")" +
+		deferral(col.References.Deferred)
+}
+
+// columnType formats the type and collation of col.
+func columnType(col backend.Column) string {
+	if col.Collation == "" {
+		return col.Type.String()
+	}
+
+	return col.Type.String() + " COLLATE " + parser.FormatIdentifier(col.Collation)
+}
+
+// Diff returns the statements that change the tables of current into the
+// tables of desired, in the order they have to run. Tables are compared by
+// name and columns by name, type, collation, whether they are identity
+// columns, what they reference and whether they are unique.
+//
+// Missing tables are created first and extra ones dropped last. Columns
+// are added after the existing ones, so the order of columns is not
+// compared. A column changing to a type its values can be cast to keeps
+// them, other columns are dropped and added again, as are columns becoming
+// or ceasing to be identity columns, foreign keys ceasing to be ones or
+// referencing another column and unique columns ceasing to be ones. Those
+// changing when their constraints are checked are too, and so are unique
+// columns changing type, which could make their values equal. Foreign keys
+// are added after the tables are created and the columns added.
+//
+// A table of desired without columns, which a database is left with once
+// all of them are dropped, can only be compared with an existing table,
+// creating it fails with an error matching ErrNoColumns. Tables of desired
+// that are materialized views or external tables in current fail with
+// ErrNotPlainTable, and dropping or changing the existing columns of a
+// table a view reads with backend.ErrTableInUse, like running the
+// statements would.
+func Diff(current, desired *Catalog) ([]*parser.Statement, error) {
+	queries, foreignKeys := []string{}, []string{}
+	for _, name := range desired.names() {
+		want, _ := desired.table(name)
+		if current.others[name] {
+			return nil, fmt.Errorf("%w: %s", ErrNotPlainTable, name)
+		}
+
+		have, ok := current.table(name)
+		if !ok {
+			if len(want.Columns) == 0 {
+				return nil, fmt.Errorf("%w: %s", ErrNoColumns, name)
+			}
+
+			defs := make([]string, len(want.Columns))
+			for i, col := range want.Columns {
+				defs[i] = definition(col)
+				if col.References.Table != "" {
+					foreignKeys = append(foreignKeys, foreignKey(name, col))
+				}
+			}
+
+			queries = append(queries, "CREATE TABLE "+parser.FormatIdentifier(name)+
+				" ("+strings.Join(defs, ", ")+")")
+			continue
+		}
+
+		alter := "ALTER TABLE " + parser.FormatIdentifier(name) + " "
+		for _, col := range have.Columns {
+			if _, ok := want.column(col.Name); !ok {
+				if err := current.checkUnread(name); err != nil {
+					return nil, err
+				}
+				queries = append(queries, alter+"DROP COLUMN "+parser.FormatIdentifier(col.Name))
+			}
+		}
+
+		for _, col := range want.Columns {
+			was, ok := have.column(col.Name)
+			// Comments aren't part of the definitions a schema holds
+			was.Comment = col.Comment
+			// A column only needs to be added again to stop it referencing
+			// what it does, it is made a foreign key without changing it
+			references := col.References.Table != "" && (!ok || was.References != col.References)
+			if ok && was.References.Table == "" {
+				was.References = col.References
+			}
+			// Nor to be made unique, a column added is with its definition
+			unique := ok && col.Unique && !was.Unique
+			if unique {
+				was.Unique, was.UniqueDeferred = true, col.UniqueDeferred
+			}
+			if ok && was != col {
+				if err := current.checkUnread(name); err != nil {
+					return nil, err
+				}
+			}
+
+			switch {
+			case !ok:
+				queries = append(queries, alter+"ADD COLUMN "+definition(col))
+			case was == col:
+			case was.Identity == col.Identity && was.References == col.References && was.Unique == col.Unique &&
+				was.UniqueDeferred == col.UniqueDeferred && (unique || !col.Unique) && types.Castable(was.Type, col.Type):
+				queries = append(queries, alter+"ALTER COLUMN "+parser.FormatIdentifier(col.Name)+
+					" TYPE "+columnType(col))
+			default:
+				queries = append(queries, alter+"DROP COLUMN "+parser.FormatIdentifier(col.Name),
+					alter+"ADD COLUMN "+definition(col))
+				unique = false
+			}
+			if unique {
+				queries = append(queries, alter+"ADD UNIQUE ("+parser.FormatIdentifier(col.Name)+")"+deferral(col.UniqueDeferred))
+			}
+			if references {
+				foreignKeys = append(foreignKeys, foreignKey(name, col))
+			}
+		}
+	}
+	queries = append(queries, foreignKeys...)
+
+	for _, name := range current.names() {
+		if _, ok := desired.table(name); !ok {
+			if err := current.checkUnread(name); err != nil {
+				return nil, err
+			}
+			queries = append(queries, "DROP TABLE "+parser.FormatIdentifier(name))
+		}
+	}
+
+	stmts := make([]*parser.Statement, len(queries))
+	for i, query := range queries {
+		ast, err := parser.Parse(query)
+		if err != nil || len(ast.Statements) != 1 {
+			// Names are quoted when they need to be, so this is a bug
+			panic(fmt.Sprintf("Diff produced an invalid statement: %s", query))
+		}
+
+		stmts[i] = ast.Statements[0]
+	}
+
+	return stmts, nil
+}
@@ -0,0 +1,194 @@
+package schema_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/schema"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []string
+		desired string
+		want    []string
+	}{
+		{
+			"create",
+			nil,
+			"create table t (id int, name text)",
+			[]string{"CREATE TABLE t (id int, name text)"},
+		},
+		{
+			"drop",
+			[]string{"create table t (id int)"},
+			"",
+			[]string{"DROP TABLE t"},
+		},
+		{
+			"add and drop columns",
+			[]string{"create table t (id int, old text)"},
+			"create table t (id int, name text)",
+			[]string{"ALTER TABLE t DROP COLUMN old", "ALTER TABLE t ADD COLUMN name text"},
+		},
+		{
+			"cast column",
+			[]string{"create table t (id int)"},
+			"create table t (id float)",
+			[]string{"ALTER TABLE t ALTER COLUMN id TYPE float"},
+		},
+		{
+			"same",
+			[]string{"create table t (id int)"},
+			"create table t (id int)",
+			[]string{},
+		},
+		{
+			"columns of a table without any",
+			[]string{"create table t (id int)", "alter table t drop column id"},
+			"create table t (id int)",
+			[]string{"ALTER TABLE t ADD COLUMN id int"},
+		},
+		{
+			"foreign keys",
+			[]string{"create table p (id int)"},
+			"create table p (id int); create table c (pid int references p (id)); create table d (cid int references c (pid))",
+			[]string{"CREATE TABLE c (pid int)", "CREATE TABLE d (cid int)",
+				"ALTER TABLE c ADD FOREIGN KEY (pid) REFERENCES p (id)", "ALTER TABLE d ADD FOREIGN KEY (cid) REFERENCES c (pid)"},
+		},
+		{
+			"column made a foreign key",
+			[]string{"create table p (id int, code text)", "create table c (pid int, code text references p (code))"},
+			"create table p (id int, code text); create table c (pid int references p (id), code text)",
+			[]string{"ALTER TABLE c DROP COLUMN code", "ALTER TABLE c ADD COLUMN code text",
+				"ALTER TABLE c ADD FOREIGN KEY (pid) REFERENCES p (id)"},
+		},
+		{
+			"unique columns",
+			[]string{"create table t (a int, b text, c text unique)"},
+			"create table t (a int unique, b int unique deferrable initially deferred, c text); create table u (a int unique)",
+			[]string{"ALTER TABLE t ADD UNIQUE (a)", "ALTER TABLE t ALTER COLUMN b TYPE int",
+				"ALTER TABLE t ADD UNIQUE (b) DEFERRABLE INITIALLY DEFERRED", "ALTER TABLE t DROP COLUMN c", "ALTER TABLE t ADD COLUMN c text",
+				"CREATE TABLE u (a int UNIQUE)"},
+		},
+		{
+			"constraints deferred",
+			[]string{"create table p (id int)", "create table c (pid int references p (id), a int unique)"},
+			"create table p (id int); create table c (pid int references p (id) deferrable initially deferred, a int unique initially deferred)",
+			[]string{"ALTER TABLE c DROP COLUMN pid", "ALTER TABLE c ADD COLUMN pid int",
+				"ALTER TABLE c DROP COLUMN a", "ALTER TABLE c ADD COLUMN a int UNIQUE DEFERRABLE INITIALLY DEFERRED",
+				"ALTER TABLE c ADD FOREIGN KEY (pid) REFERENCES p (id) DEFERRABLE INITIALLY DEFERRED"},
+		},
+		{
+			"materialized view left alone",
+			[]string{"create table t (a int)", "create materialized view v as select a from t"},
+			"create table t (a int, b text)",
+			[]string{"ALTER TABLE t ADD COLUMN b text"},
+		},
+		{
+			"external table left alone",
+			[]string{"create external table e (a int) location 'e.csv' format csv"},
+			"",
+			[]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sgsql.Open(sgsql.MemoryPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			for _, query := range tt.current {
+				if err := db.Exec(query); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+			}
+
+			desired, err := schema.Parse(tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stmts, err := schema.Diff(schema.FromBackend(db.Catalog()), desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, stmt := range stmts {
+				got = append(got, stmt.Text)
+				if err := db.Exec(stmt.Text); err != nil {
+					t.Fatalf("%s: %v", stmt.Text, err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %q, want %q", got, tt.want)
+			}
+
+			// Running the statements brings the database in line
+			stmts, err = schema.Diff(schema.FromBackend(db.Catalog()), desired)
+			if err != nil || len(stmts) != 0 {
+				t.Errorf("Diff after running it = %v, %v, want nothing", stmts, err)
+			}
+		})
+	}
+}
+
+func TestDiffErrors(t *testing.T) {
+	current := []string{
+		"create table t (a int, b text)",
+		"create materialized view v as select a from t",
+		"create external table e (a int) location 'e.csv' format csv",
+	}
+
+	tests := []struct {
+		desired string
+		err     error
+	}{
+		{"create table v (a int)", schema.ErrNotPlainTable},
+		{"create table e (a int)", schema.ErrNotPlainTable},
+		{"", backend.ErrTableInUse},
+		{"create table t (a int)", backend.ErrTableInUse},
+		{"create table t (a float, b text)", backend.ErrTableInUse},
+		{"create table t (a int, b text)", nil},
+	}
+
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, query := range current {
+		if err := db.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	for _, tt := range tests {
+		desired, err := schema.Parse(tt.desired)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := schema.Diff(schema.FromBackend(db.Catalog()), desired); !errors.Is(err, tt.err) {
+			t.Errorf("Diff to %q: got %v, want %v", tt.desired, err, tt.err)
+		}
+	}
+}
+
+func TestDiffCreatingTableWithoutColumns(t *testing.T) {
+	current := &schema.Catalog{}
+	desired := &schema.Catalog{Tables: []schema.Table{{Name: "u"}}}
+
+	if _, err := schema.Diff(current, desired); !errors.Is(err, schema.ErrNoColumns) {
+		t.Errorf("got %v, want %v", err, schema.ErrNoColumns)
+	}
+}
@@ -0,0 +1,43 @@
+package sgsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSequencesReplay(t *testing.T) {
+	db, path := openTest(t)
+	mustExec(t, db,
+		"create sequence ids cache 10",
+		"create table t (id int, name text)",
+		"insert into t values (nextval('ids'), 'a')",
+		"insert into t values (nextval('ids'), 'b')",
+	)
+
+	// Values taken by a transaction that rolls back aren't handed out again
+	c := db.Conn()
+	mustExec(t, c, "begin", "insert into t values (nextval('ids'), 'c')", "rollback")
+	c.Close()
+	mustExec(t, db, "insert into t values (nextval('ids'), 'd')")
+
+	want := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(4), "d"}}
+	if got := queryRows(t, db, "select id, name from t"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// Replaying gives the same rows, and the sequence continues after the
+	// values it had reserved rather than after the last one handed out
+	db = reopen(t, db, path)
+	if got := queryRows(t, db, "select id, name from t"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v after reopening, want %v", got, want)
+	}
+	if got := queryRows(t, db, "select nextval('ids')"); got[0][0] != int64(11) {
+		t.Errorf("got %v after reopening, want 11", got[0][0])
+	}
+
+	mustExec(t, db, "select setval('ids', 100)")
+	db = reopen(t, db, path)
+	if got := queryRows(t, db, "select nextval('ids')"); got[0][0] != int64(101) {
+		t.Errorf("got %v after setval and reopening, want 101", got[0][0])
+	}
+}
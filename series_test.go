@@ -0,0 +1,73 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql/budget"
+)
+
+func TestGenerateSeries(t *testing.T) {
+	db, _ := openTest(t)
+
+	tests := []struct {
+		query string
+		rows  [][]interface{}
+	}{
+		{"select generate_series(1, 3)", [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}},
+		{"select * from generate_series(5, 1, -2)", [][]interface{}{{int64(5)}, {int64(3)}, {int64(1)}}},
+		{"select generate_series(0, 1, 0.5)", [][]interface{}{{0.0}, {0.5}, {1.0}}},
+		{"select * from generate_series(1, 0)", nil},
+		{"select generate_series(1, null)", nil},
+	}
+
+	for _, tt := range tests {
+		if got := queryRows(t, db, tt.query); !reflect.DeepEqual(got, tt.rows) {
+			t.Errorf("%s = %v, want %v", tt.query, got, tt.rows)
+		}
+	}
+
+	rows := queryRows(t, db, "select * from generate_series('2024-01-01'::timestamp, '2024-01-03'::timestamp, interval '1 day')")
+	if len(rows) != 3 || !rows[2][0].(time.Time).Equal(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily series = %v, want the three days", rows)
+	}
+
+	if _, err := db.Query("select generate_series(1, 2, 0)"); err == nil {
+		t.Error("a zero step is accepted")
+	}
+}
+
+func TestGenerateSeriesStopsEarly(t *testing.T) {
+	huge := []string{
+		"select generate_series(1, 10000000000)",
+		"select * from generate_series(1, 10000000000)",
+		"select * from generate_series(1.0, 10000000000.0)",
+	}
+
+	t.Run("memory budget", func(t *testing.T) {
+		db, _ := openTest(t)
+		db.SetMemoryBudget(1 << 20)
+
+		for _, query := range huge {
+			if _, err := db.Query(query); !errors.Is(err, budget.ErrExceeded) {
+				t.Errorf("%s: got %v, want %v", query, err, budget.ErrExceeded)
+			}
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		db, _ := openTest(t)
+
+		for _, query := range huge {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			_, err := db.QueryContext(ctx, query)
+			cancel()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s: got %v, want %v", query, err, context.DeadlineExceeded)
+			}
+		}
+	})
+}
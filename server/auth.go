@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nireo/sgsql/functions"
+)
+
+// Credentials are the users the servers let clients connect as, with what
+// MySQL stores for the mysql_native_password plugin in place of their
+// passwords: the SHA-1 of the SHA-1 of the password. A nil *Credentials
+// only lets clients connect as functions.DefaultUser, without a password.
+type Credentials struct {
+	hashes map[string][]byte
+}
+
+// HashPassword returns how password is stored in a credentials file, in
+// hex like MySQL's PASSWORD() without its leading '*'.
+func HashPassword(password string) string {
+	return hex.EncodeToString(doubleSHA1([]byte(password)))
+}
+
+func doubleSHA1(password []byte) []byte {
+	first := sha1.Sum(password)
+	second := sha1.Sum(first[:])
+	return second[:]
+}
+
+// ReadCredentialsFile reads the credentials in the file called name, see
+// ReadCredentials.
+func ReadCredentialsFile(name string) (*Credentials, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadCredentials(f)
+}
+
+// ReadCredentials reads credentials with a user on each line, given as its
+// name followed by the hash of its password like HashPassword returns it.
+// The hash may start with the '*' MySQL puts in front of it. Empty lines
+// and those starting with # are skipped.
+func ReadCredentials(r io.Reader) (*Credentials, error) {
+	c := &Credentials{hashes: map[string][]byte{}}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Line %d: expected a user and the hash of its password", n)
+		}
+
+		hash, err := hex.DecodeString(strings.TrimPrefix(fields[1], "*"))
+		if err != nil || len(hash) != sha1.Size {
+			return nil, fmt.Errorf("Line %d: password hash of %s is malformed", n, fields[0])
+		}
+		if _, ok := c.hashes[fields[0]]; ok {
+			return nil, fmt.Errorf("Line %d: %s is given twice", n, fields[0])
+		}
+		c.hashes[fields[0]] = hash
+	}
+
+	return c, scanner.Err()
+}
+
+// checkPassword reports whether password is that of user.
+func (c *Credentials) checkPassword(user, password string) bool {
+	if c == nil {
+		return user == functions.DefaultUser
+	}
+
+	hash, ok := c.hashes[user]
+	return ok && subtle.ConstantTimeCompare(hash, doubleSHA1([]byte(password))) == 1
+}
+
+// checkScramble reports whether response is what a client knowing the
+// password of user answers to the handshake sending salt, as the
+// mysql_native_password plugin computes it:
+//
+//	SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+func (c *Credentials) checkScramble(user string, salt, response []byte) bool {
+	if c == nil {
+		return user == functions.DefaultUser
+	}
+
+	hash, ok := c.hashes[user]
+	if !ok || len(response) != sha1.Size {
+		return false
+	}
+
+	mask := sha1.Sum(append(append([]byte{}, salt...), hash...))
+	password := make([]byte, sha1.Size)
+	for i := range password {
+		password[i] = response[i] ^ mask[i]
+	}
+
+	got := sha1.Sum(password)
+	return subtle.ConstantTimeCompare(got[:], hash) == 1
+}
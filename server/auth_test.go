@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql"
+)
+
+// scramble answers salt with password like mysql_native_password clients.
+func scramble(password string, salt []byte) []byte {
+	first := sha1.Sum([]byte(password))
+	second := sha1.Sum(first[:])
+	mask := sha1.Sum(append(append([]byte{}, salt...), second[:]...))
+
+	out := make([]byte, sha1.Size)
+	for i := range out {
+		out[i] = first[i] ^ mask[i]
+	}
+	return out
+}
+
+// handshake connects to s as user with password, answering with the
+// plugin named, and returns the first byte of the packet ending the
+// handshake: 0x00 once it is accepted and 0xff when it isn't.
+func handshake(t *testing.T, s *MySQLServer, user, password, plugin string) byte {
+	t.Helper()
+
+	c, reply := connect(t, s, user, password, plugin)
+	c.conn.Close()
+	return reply
+}
+
+// connect is handshake keeping the connection open, which is closed at the
+// end of the test.
+func connect(t *testing.T, s *MySQLServer, user, password, plugin string) (*mysqlConn, byte) {
+	t.Helper()
+
+	client, conn := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go s.handleConn(conn)
+
+	return greet(t, client, user, password, plugin)
+}
+
+// greet answers the handshake of the server at the other end of client,
+// like connect does.
+func greet(t *testing.T, client net.Conn, user, password, plugin string) (*mysqlConn, byte) {
+	t.Helper()
+
+	c := &mysqlConn{conn: client, r: bufio.NewReader(client), w: bufio.NewWriter(client)}
+	greeting, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The salt follows the version, connection id and filler, and again
+	// the capabilities, character set, status and reserved bytes
+	rest := greeting[1+strings.IndexByte(string(greeting[1:]), 0)+1:]
+	salt := append(append([]byte{}, rest[4:12]...), rest[12+1+2+1+2+2+1+10:][:12]...)
+
+	auth := scramble(password, salt)
+	if plugin != mysqlNativePassword {
+		auth = []byte("not a native answer")
+	}
+
+	caps := uint32(mysqlClientProtocol41 | mysqlClientSecureConnection | mysqlClientPluginAuth)
+	response := appendUint32(nil, caps)
+	response = append(response, make([]byte, 4+1+23)...)
+	response = append(response, user...)
+	response = append(response, 0, byte(len(auth)))
+	response = append(response, auth...)
+	response = append(response, plugin...)
+	response = append(response, 0)
+	if err := c.writePacket(response); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply[0] == 0xfe {
+		if got := string(reply[1 : 1+len(mysqlNativePassword)]); got != mysqlNativePassword {
+			t.Fatalf("switched to %q, want %s", got, mysqlNativePassword)
+		}
+		if err := c.writePacket(scramble(password, salt)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if reply, err = c.readPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return c, reply[0]
+}
+
+func testCredentials(t *testing.T) *Credentials {
+	t.Helper()
+
+	c, err := ReadCredentials(strings.NewReader("# users\nalice " + HashPassword("secret") + "\n\nsgsql *" + strings.ToUpper(HashPassword("root")) + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMySQLAuthentication(t *testing.T) {
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name           string
+		credentials    bool
+		user, password string
+		plugin         string
+		accepted       bool
+	}{
+		{"default user without credentials", false, "sgsql", "", mysqlNativePassword, true},
+		{"no user without credentials", false, "", "", mysqlNativePassword, true},
+		{"other user without credentials", false, "alice", "secret", mysqlNativePassword, false},
+		{"right password", true, "alice", "secret", mysqlNativePassword, true},
+		{"wrong password", true, "alice", "guess", mysqlNativePassword, false},
+		{"unknown user", true, "bob", "secret", mysqlNativePassword, false},
+		{"default user needs its password", true, "sgsql", "", mysqlNativePassword, false},
+		{"default user with its password", true, "sgsql", "root", mysqlNativePassword, true},
+		{"switched plugin", true, "alice", "secret", "caching_sha2_password", true},
+		{"switched plugin wrong password", true, "alice", "guess", "caching_sha2_password", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMySQLServer(db)
+			if tt.credentials {
+				s.SetCredentials(testCredentials(t))
+			}
+
+			got := handshake(t, s, tt.user, tt.password, tt.plugin)
+			if accepted := got == 0x00; accepted != tt.accepted {
+				t.Errorf("accepted = %v, want %v (reply %#x)", accepted, tt.accepted, got)
+			}
+		})
+	}
+}
+
+func TestReadCredentialsErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"alice", "Line 1: expected a user and the hash of its password"},
+		{"alice nothex", "Line 1: password hash of alice is malformed"},
+		{"alice abcd", "Line 1: password hash of alice is malformed"},
+		{"alice " + HashPassword("a") + "\nalice " + HashPassword("b"), "Line 2: alice is given twice"},
+	}
+
+	for _, tt := range tests {
+		_, err := ReadCredentials(strings.NewReader(tt.input))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("ReadCredentials(%q) = %v, want %s", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestHTTPAuthentication(t *testing.T) {
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name           string
+		credentials    bool
+		user, password string
+		status         int
+		body           string
+	}{
+		{"anonymous without credentials", false, "", "", http.StatusOK, `"sgsql"`},
+		{"other user without credentials", false, "alice", "secret", http.StatusUnauthorized, "Access denied"},
+		{"anonymous with credentials", true, "", "", http.StatusUnauthorized, "Access denied"},
+		{"right password", true, "alice", "secret", http.StatusOK, `"alice"`},
+		{"wrong password", true, "alice", "guess", http.StatusUnauthorized, "Access denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewHTTPServer(db)
+			if tt.credentials {
+				s.SetCredentials(testCredentials(t))
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query": "select current_user()"}`))
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("got %d %s, want %d containing %s", w.Code, w.Body.String(), tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestHTTPQueryBodyLimit(t *testing.T) {
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	body := `{"query": "select '` + strings.Repeat("x", maxQueryBody) + `'"}`
+	w := httptest.NewRecorder()
+	NewHTTPServer(db).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body)))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d %s, want %d", w.Code, w.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestHTTPChangesOnlyForDefaultUser(t *testing.T) {
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := NewHTTPServer(db)
+	s.SetCredentials(testCredentials(t))
+
+	r := httptest.NewRequest(http.MethodGet, "/changes", nil)
+	r.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got %d %s, want %d", w.Code, w.Body.String(), http.StatusForbidden)
+	}
+}
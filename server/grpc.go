@@ -0,0 +1,404 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/server/sgsqlpb"
+	"github.com/nireo/sgsql/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer serves a database over the gRPC API of package sgsqlpb, for
+// clients in any language gRPC has a library for. Calls authenticate with
+// HTTP basic credentials in their "authorization" metadata, checked against
+// those set with SetCredentials; without any they run as
+// functions.DefaultUser. Each call runs in a session of its own, unless it
+// names a transaction begun with BeginTx.
+type GRPCServer struct {
+	sgsqlpb.UnimplementedSQLServer
+
+	db          *sgsql.DB
+	credentials *Credentials
+	server      *grpc.Server
+
+	mu  sync.Mutex
+	txs map[string]*grpcTx
+}
+
+// grpcTx is a transaction begun with BeginTx, running in a session of its
+// own. Only the user that began it may use it, over the connection it was
+// begun on.
+type grpcTx struct {
+	// mu keeps the calls naming the transaction from running at once, and
+	// guards ended
+	mu    sync.Mutex
+	ended bool
+	conn  *sgsql.Conn
+	user  string
+	owner *grpcConn
+}
+
+// grpcConn identifies a client connection, see connTracker.
+type grpcConn struct {
+	remote net.Addr
+}
+
+type grpcConnKey struct{}
+
+func NewGRPCServer(db *sgsql.DB) *GRPCServer {
+	s := &GRPCServer{db: db, txs: map[string]*grpcTx{}}
+	s.server = grpc.NewServer(grpc.StatsHandler(connTracker{s}))
+	sgsqlpb.RegisterSQLServer(s.server, s)
+
+	return s
+}
+
+// SetCredentials sets the users calls may run as. It must be called before
+// the server is started.
+func (s *GRPCServer) SetCredentials(c *Credentials) {
+	s.credentials = c
+}
+
+// ListenAndServe listens on the TCP address addr and serves the
+// connections made to it, see Serve.
+func (s *GRPCServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve serves the connections made to l until it fails or the server is
+// shut down, when it returns ErrServerClosed.
+func (s *GRPCServer) Serve(l net.Listener) error {
+	err := s.server.Serve(l)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return ErrServerClosed
+	}
+
+	return err
+}
+
+// Shutdown stops accepting connections and waits for the calls running to
+// finish. When ctx is done first, the calls left are canceled. Either way
+// the connections are closed after, rolling back the transactions open on
+// them.
+func (s *GRPCServer) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+		<-stopped
+		err = ctx.Err()
+	}
+
+	s.rollback(nil)
+	return err
+}
+
+// connTracker rolls back the transactions of a connection once it closes.
+type connTracker struct {
+	s *GRPCServer
+}
+
+func (ct connTracker) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return context.WithValue(ctx, grpcConnKey{}, &grpcConn{remote: info.RemoteAddr})
+}
+
+func (ct connTracker) HandleConn(ctx context.Context, cs stats.ConnStats) {
+	if _, ok := cs.(*stats.ConnEnd); ok {
+		if conn, ok := ctx.Value(grpcConnKey{}).(*grpcConn); ok {
+			ct.s.rollback(conn)
+		}
+	}
+}
+
+func (ct connTracker) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (ct connTracker) HandleRPC(context.Context, stats.RPCStats) {}
+
+// rollback rolls back the transactions begun over conn, or every one when
+// conn is nil.
+func (s *GRPCServer) rollback(conn *grpcConn) {
+	s.mu.Lock()
+	ended := []*grpcTx{}
+	for id, tx := range s.txs {
+		if conn == nil || tx.owner == conn {
+			ended = append(ended, tx)
+			delete(s.txs, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, tx := range ended {
+		tx.mu.Lock()
+		tx.ended = true
+		if err := tx.conn.Close(); err != nil {
+			log.Printf("grpc: rolling back: %s", err)
+		}
+		tx.mu.Unlock()
+	}
+}
+
+// authenticate returns the user the call of ctx runs as, checking the
+// password it gives with basic credentials.
+func (s *GRPCServer) authenticate(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md.Get("authorization")
+	if len(auth) == 0 && s.credentials == nil {
+		return functions.DefaultUser, nil
+	}
+
+	// The credentials are those of HTTP, so net/http parses them
+	r := http.Request{Header: http.Header{"Authorization": auth}}
+	if user, password, ok := r.BasicAuth(); ok && s.credentials.checkPassword(user, password) {
+		return user, nil
+	}
+
+	return "", status.Error(codes.Unauthenticated, "Access denied")
+}
+
+// session calls fn with the session of the transaction called tx, or with
+// a session of its own when tx is empty, running as the user of ctx and
+// rejecting changes when readOnly is set.
+func (s *GRPCServer) session(ctx context.Context, tx string, readOnly bool, fn func(conn *sgsql.Conn) error) error {
+	user, err := s.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+
+	if tx == "" {
+		conn := s.db.Conn()
+		defer conn.Close()
+		conn.SetUser(user)
+		if readOnly {
+			conn.SetReadOnly(true)
+		}
+		return fn(conn)
+	}
+
+	s.mu.Lock()
+	t, ok := s.txs[tx]
+	s.mu.Unlock()
+	if ok {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+	}
+
+	// The transaction may have ended while the call waited for it
+	if !ok || t.ended || t.user != user || t.owner != connOf(ctx) {
+		return status.Errorf(codes.NotFound, "Transaction %s does not exist", tx)
+	}
+	return fn(t.conn)
+}
+
+func connOf(ctx context.Context) *grpcConn {
+	conn, _ := ctx.Value(grpcConnKey{}).(*grpcConn)
+	return conn
+}
+
+func (s *GRPCServer) Query(req *sgsqlpb.QueryRequest, stream sgsqlpb.SQL_QueryServer) error {
+	params, err := grpcParams(req.Params)
+	if err != nil {
+		return err
+	}
+
+	return s.session(stream.Context(), req.Tx, req.ReadOnly, func(conn *sgsql.Conn) error {
+		return grpcError(conn.StreamQuery(stream.Context(), grpcRowWriter{stream}, req.Query, params...))
+	})
+}
+
+func (s *GRPCServer) Exec(ctx context.Context, req *sgsqlpb.ExecRequest) (*sgsqlpb.ExecResponse, error) {
+	params, err := grpcParams(req.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.session(ctx, req.Tx, req.ReadOnly, func(conn *sgsql.Conn) error {
+		return grpcError(conn.ExecContext(ctx, req.Query, params...))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sgsqlpb.ExecResponse{}, nil
+}
+
+func (s *GRPCServer) BeginTx(ctx context.Context, req *sgsqlpb.BeginTxRequest) (*sgsqlpb.BeginTxResponse, error) {
+	user, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, grpcError(err)
+	}
+	id := hex.EncodeToString(b[:])
+
+	conn := s.db.Conn()
+	conn.SetUser(user)
+	if req.ReadOnly {
+		conn.SetReadOnly(true)
+	}
+	if err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		conn.Close()
+		return nil, grpcError(err)
+	}
+
+	s.mu.Lock()
+	s.txs[id] = &grpcTx{conn: conn, user: user, owner: connOf(ctx)}
+	s.mu.Unlock()
+
+	// A connection that closed meanwhile won't roll the transaction back
+	if err := ctx.Err(); err != nil {
+		s.endTx(ctx, id, "ROLLBACK")
+		return nil, grpcError(err)
+	}
+
+	return &sgsqlpb.BeginTxResponse{Tx: id}, nil
+}
+
+func (s *GRPCServer) Commit(ctx context.Context, req *sgsqlpb.EndTxRequest) (*sgsqlpb.EndTxResponse, error) {
+	return s.endTx(ctx, req.Tx, "COMMIT")
+}
+
+func (s *GRPCServer) Rollback(ctx context.Context, req *sgsqlpb.EndTxRequest) (*sgsqlpb.EndTxResponse, error) {
+	return s.endTx(ctx, req.Tx, "ROLLBACK")
+}
+
+// endTx ends the transaction called tx with query, COMMIT or ROLLBACK, and
+// closes its session. A commit that fails rolls it back.
+func (s *GRPCServer) endTx(ctx context.Context, tx, query string) (*sgsqlpb.EndTxResponse, error) {
+	err := s.session(ctx, tx, false, func(conn *sgsql.Conn) error {
+		s.mu.Lock()
+		t := s.txs[tx]
+		delete(s.txs, tx)
+		s.mu.Unlock()
+		t.ended = true
+
+		err := conn.ExecContext(ctx, query)
+		if cerr := conn.Close(); err == nil {
+			err = cerr
+		}
+		return grpcError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sgsqlpb.EndTxResponse{}, nil
+}
+
+// grpcError returns the status a call failing with err ends with, hiding
+// the details of internal errors like queryError does.
+func grpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, backend.ErrPermissionDenied), errors.Is(err, backend.ErrPolicyViolation):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case internalError(err):
+		log.Printf("grpc: query: %s", err)
+		return status.Error(codes.Internal, errInternal.Error())
+	}
+
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// grpcParams returns the parameters values hold.
+func grpcParams(values []*sgsqlpb.Value) ([]interface{}, error) {
+	params := make([]interface{}, len(values))
+	for i, v := range values {
+		switch kind := v.GetKind().(type) {
+		case *sgsqlpb.Value_Null:
+		case *sgsqlpb.Value_Int:
+			params[i] = kind.Int
+		case *sgsqlpb.Value_Float:
+			params[i] = kind.Float
+		case *sgsqlpb.Value_Bool:
+			params[i] = kind.Bool
+		case *sgsqlpb.Value_Text:
+			params[i] = kind.Text
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Parameter $%d has no value", i+1)
+		}
+	}
+
+	return params, nil
+}
+
+// grpcValue returns v as a value of a row, with the types the API has no
+// field for as text.
+func grpcValue(v interface{}) *sgsqlpb.Value {
+	switch v := v.(type) {
+	case nil:
+		return &sgsqlpb.Value{Kind: &sgsqlpb.Value_Null{Null: true}}
+	case int64:
+		return &sgsqlpb.Value{Kind: &sgsqlpb.Value_Int{Int: v}}
+	case float64:
+		return &sgsqlpb.Value{Kind: &sgsqlpb.Value_Float{Float: v}}
+	case bool:
+		return &sgsqlpb.Value{Kind: &sgsqlpb.Value_Bool{Bool: v}}
+	case string:
+		return &sgsqlpb.Value{Kind: &sgsqlpb.Value_Text{Text: v}}
+	}
+
+	text, err := types.Cast(v, types.Text)
+	if err != nil {
+		text = fmt.Sprint(v)
+	}
+	return &sgsqlpb.Value{Kind: &sgsqlpb.Value_Text{Text: text.(string)}}
+}
+
+// grpcRowWriter sends the rows of a query to the client as they are
+// computed.
+type grpcRowWriter struct {
+	stream sgsqlpb.SQL_QueryServer
+}
+
+func (w grpcRowWriter) WriteColumns(columns []backend.ResultColumn) error {
+	resp := &sgsqlpb.QueryResponse{Columns: []*sgsqlpb.Column{}}
+	for _, col := range columns {
+		resp.Columns = append(resp.Columns, &sgsqlpb.Column{Name: col.Name, Type: col.Type.String()})
+	}
+
+	return w.stream.Send(resp)
+}
+
+func (w grpcRowWriter) WriteRow(row []interface{}) error {
+	values := make([]*sgsqlpb.Value, len(row))
+	for i, v := range row {
+		values[i] = grpcValue(v)
+	}
+
+	return w.stream.Send(&sgsqlpb.QueryResponse{Row: &sgsqlpb.Row{Values: values}})
+}
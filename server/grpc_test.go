@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/server/sgsqlpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcConnect serves db over gRPC with credentials and returns a client
+// connected to it.
+func grpcConnect(t *testing.T, db *sgsql.DB, credentials *Credentials) (*grpc.ClientConn, sgsqlpb.SQLClient) {
+	t.Helper()
+
+	s := NewGRPCServer(db)
+	s.SetCredentials(credentials)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, sgsqlpb.NewSQLClient(conn)
+}
+
+// grpcAs returns a context authenticating calls as user with password.
+func grpcAs(user, password string) context.Context {
+	auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Basic "+auth)
+}
+
+// grpcQuery runs query with params in tx and returns its columns and rows.
+func grpcQuery(ctx context.Context, client sgsqlpb.SQLClient, tx, query string, params ...*sgsqlpb.Value) ([]string, [][]interface{}, error) {
+	stream, err := client.Query(ctx, &sgsqlpb.QueryRequest{Query: query, Params: params, Tx: tx})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns, rows := []string{}, [][]interface{}{}
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return columns, rows, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		for _, col := range resp.Columns {
+			columns = append(columns, col.Name+" "+col.Type)
+		}
+		if resp.Row != nil {
+			row := []interface{}{}
+			for _, v := range resp.Row.Values {
+				switch kind := v.Kind.(type) {
+				case *sgsqlpb.Value_Null:
+					row = append(row, nil)
+				case *sgsqlpb.Value_Int:
+					row = append(row, kind.Int)
+				case *sgsqlpb.Value_Float:
+					row = append(row, kind.Float)
+				case *sgsqlpb.Value_Bool:
+					row = append(row, kind.Bool)
+				case *sgsqlpb.Value_Text:
+					row = append(row, kind.Text)
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+}
+
+func TestGRPCQuery(t *testing.T) {
+	_, client := grpcConnect(t, testPostgresServer(t).db, testCredentials(t))
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		query   string
+		params  []*sgsqlpb.Value
+		code    codes.Code
+		columns []string
+		rows    [][]interface{}
+	}{
+		{"rows", grpcAs("sgsql", "root"), "select id, name from t", nil, codes.OK,
+			[]string{"id int", "name text"}, [][]interface{}{{int64(1), "a"}, {int64(2), "b"}}},
+		{"params", grpcAs("sgsql", "root"), "select name from t where id = $1",
+			[]*sgsqlpb.Value{{Kind: &sgsqlpb.Value_Int{Int: 2}}}, codes.OK, []string{"name text"}, [][]interface{}{{"b"}}},
+		{"values", grpcAs("sgsql", "root"), "select null, 1.5, true, '2024-03-01 10:00:00'::timestamp", nil, codes.OK,
+			nil, [][]interface{}{{nil, 1.5, true, "2024-03-01 10:00:00"}}},
+		{"aggregate", grpcAs("alice", "secret"), "select count(*) as n from t", nil, codes.OK,
+			[]string{"n int"}, [][]interface{}{{int64(2)}}},
+		{"no parameter value", grpcAs("sgsql", "root"), "select $1", []*sgsqlpb.Value{{}}, codes.InvalidArgument, nil, nil},
+		{"wrong password", grpcAs("alice", "guess"), "select 1", nil, codes.Unauthenticated, nil, nil},
+		{"no credentials", context.Background(), "select 1", nil, codes.Unauthenticated, nil, nil},
+		{"denied", grpcAs("alice", "secret"), "vacuum", nil, codes.PermissionDenied, nil, nil},
+		{"bad query", grpcAs("sgsql", "root"), "select * from missing", nil, codes.InvalidArgument, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, rows, err := grpcQuery(tt.ctx, client, "", tt.query, tt.params...)
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("got %v, want %v", err, tt.code)
+			}
+			if err != nil {
+				return
+			}
+			if tt.columns != nil && !reflect.DeepEqual(columns, tt.columns) {
+				t.Errorf("got columns %v, want %v", columns, tt.columns)
+			}
+			if !reflect.DeepEqual(rows, tt.rows) {
+				t.Errorf("got rows %v, want %v", rows, tt.rows)
+			}
+		})
+	}
+}
+
+func TestGRPCTransactions(t *testing.T) {
+	db := testPostgresServer(t).db
+	conn, client := grpcConnect(t, db, nil)
+	ctx := context.Background()
+
+	count := func(t *testing.T) int64 {
+		t.Helper()
+
+		_, rows, err := grpcQuery(ctx, client, "", "select count(*) from t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows[0][0].(int64)
+	}
+
+	tests := []struct {
+		name string
+		end  func(client sgsqlpb.SQLClient, tx string) error
+		// rows are those of t after the transaction inserted one and ended
+		rows int64
+	}{
+		{"commit", func(client sgsqlpb.SQLClient, tx string) error {
+			_, err := client.Commit(ctx, &sgsqlpb.EndTxRequest{Tx: tx})
+			return err
+		}, 3},
+		{"rollback", func(client sgsqlpb.SQLClient, tx string) error {
+			_, err := client.Rollback(ctx, &sgsqlpb.EndTxRequest{Tx: tx})
+			return err
+		}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			begun, err := client.BeginTx(ctx, &sgsqlpb.BeginTxRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Exec(ctx, &sgsqlpb.ExecRequest{Query: "insert into t values (3, 'c')", Tx: begun.Tx}); err != nil {
+				t.Fatal(err)
+			}
+			if _, rows, err := grpcQuery(ctx, client, begun.Tx, "select count(*) from t where id = 3"); err != nil || rows[0][0] == int64(0) {
+				t.Fatalf("the transaction sees %v rows: %v", rows, err)
+			}
+
+			if err := tt.end(client, begun.Tx); err != nil {
+				t.Fatal(err)
+			}
+			if got := count(t); got != tt.rows {
+				t.Errorf("t holds %d rows, want %d", got, tt.rows)
+			}
+
+			// The transaction is gone once it ended
+			_, err = client.Commit(ctx, &sgsqlpb.EndTxRequest{Tx: begun.Tx})
+			if status.Code(err) != codes.NotFound {
+				t.Errorf("ending it again: got %v, want %v", err, codes.NotFound)
+			}
+		})
+	}
+
+	// A transaction can't be used over another connection, and is rolled
+	// back once its own closes
+	begun, err := client.BeginTx(ctx, &sgsqlpb.BeginTxRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Exec(ctx, &sgsqlpb.ExecRequest{Query: "insert into t values (4, 'd')", Tx: begun.Tx}); err != nil {
+		t.Fatal(err)
+	}
+	other, err := grpc.Dial(conn.Target(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	otherClient := sgsqlpb.NewSQLClient(other)
+	if _, err := otherClient.Commit(ctx, &sgsqlpb.EndTxRequest{Tx: begun.Tx}); status.Code(err) != codes.NotFound {
+		t.Errorf("committing over another connection: got %v, want %v", err, codes.NotFound)
+	}
+
+	conn.Close()
+	timeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, rows, err := grpcQuery(timeout, otherClient, "", "select count(*) from t where id = 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0][0] != int64(0) {
+		t.Errorf("t holds the row of the transaction after its connection closed")
+	}
+}
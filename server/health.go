@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nireo/sgsql"
+)
+
+type healthResponse struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	ReadOnly  bool   `json:"read_only"`
+	Vacuuming bool   `json:"vacuuming"`
+	// DiskFree is the bytes left on the disk of the database, nil when it
+	// isn't known
+	DiskFree *int64           `json:"disk_free_bytes"`
+	Recovery recoveryResponse `json:"recovery"`
+}
+
+// recoveryResponse describes how the database recovered when it was opened.
+// Open only returns once the statement log is replayed, so the recovery of
+// a database being served is always complete. There are no replicas, so
+// there is no replication lag to report.
+type recoveryResponse struct {
+	Status          string `json:"status"`
+	ReplayedEntries int    `json:"replayed_entries"`
+	DurationMs      int64  `json:"duration_ms"`
+}
+
+// handleHealthz answers liveness probes: 200 while the database can commit,
+// and 503 once it can't until it is opened again, like after syncing the
+// statement log failed.
+func (s *HTTPServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, r, func(h sgsql.Health) bool {
+		return h.Err == nil
+	})
+}
+
+// handleReadyz answers readiness probes: 200 while queries are answered
+// right away, and 503 also while the database vacuums itself, which
+// transactions wait for.
+func (s *HTTPServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, r, func(h sgsql.Health) bool {
+		return h.Err == nil && !h.Vacuuming
+	})
+}
+
+// writeHealth writes the health of the database, with 503 as the status
+// unless ok says it is fine.
+func (s *HTTPServer) writeHealth(w http.ResponseWriter, r *http.Request, ok func(sgsql.Health) bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Only GET and HEAD are allowed"))
+		return
+	}
+
+	h := s.db.Health()
+	resp := healthResponse{
+		Status:    "ok",
+		ReadOnly:  h.ReadOnly,
+		Vacuuming: h.Vacuuming,
+		Recovery: recoveryResponse{
+			Status:          "complete",
+			ReplayedEntries: h.Replayed,
+			DurationMs:      h.ReplayTime.Milliseconds(),
+		},
+	}
+	if h.DiskFree >= 0 {
+		resp.DiskFree = &h.DiskFree
+	}
+	status := http.StatusOK
+	if !ok(h) {
+		resp.Status, status = "unavailable", http.StatusServiceUnavailable
+	}
+	// The endpoints answer without authentication, so the error is only
+	// named: the underlying one may tell file paths of the server
+	switch {
+	case errors.Is(h.Err, sgsql.ErrSyncFailed):
+		resp.Error = sgsql.ErrSyncFailed.Error()
+	case h.Err != nil:
+		resp.Error = "Commits are failing"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
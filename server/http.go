@@ -0,0 +1,406 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+)
+
+var errInternal = errors.New("Internal error")
+
+// flushEvery is how many rows are written between flushes when streaming.
+const flushEvery = 100
+
+// maxQueryBody is the most bytes the body of a request to /query may have.
+const maxQueryBody = 16 << 20
+
+// QueryRequest is the body accepted by POST /query. Params are bound to the
+// $1..$n placeholders in the query. Each request runs in its own session,
+// which rejects changes when ReadOnly is set.
+type QueryRequest struct {
+	Query    string        `json:"query"`
+	Params   []interface{} `json:"params"`
+	ReadOnly bool          `json:"read_only"`
+	// DryRun only validates the query, see sgsql.Conn.SetDryRun
+	DryRun bool `json:"dry_run"`
+}
+
+type columnResponse struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type queryResponse struct {
+	Columns []columnResponse `json:"columns"`
+	Rows    [][]interface{}  `json:"rows"`
+}
+
+type notificationResponse struct {
+	Channel string `json:"channel"`
+	Payload string `json:"payload"`
+	Session int64  `json:"session"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// HTTPServer exposes a database over a small JSON API, with /healthz and
+// /readyz for liveness and readiness probes. Requests authenticate with
+// HTTP basic authentication against the credentials set with
+// SetCredentials, without any they run as functions.DefaultUser.
+type HTTPServer struct {
+	db          *sgsql.DB
+	mux         *http.ServeMux
+	credentials *Credentials
+}
+
+func NewHTTPServer(db *sgsql.DB) *HTTPServer {
+	s := &HTTPServer{
+		db:  db,
+		mux: http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/query", s.handleQuery)
+	s.mux.HandleFunc("/listen", s.handleListen)
+	s.mux.HandleFunc("/changes", s.handleChanges)
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+
+	return s
+}
+
+// SetCredentials sets the users requests may run as. It must be called
+// before the server is started.
+func (s *HTTPServer) SetCredentials(c *Credentials) {
+	s.credentials = c
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+// decodeParams converts JSON numbers into integers where possible and
+// floats otherwise.
+func decodeParams(params []interface{}) ([]interface{}, error) {
+	decoded := make([]interface{}, len(params))
+	for i, p := range params {
+		switch v := p.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				decoded[i] = n
+				break
+			}
+
+			f, err := v.Float64()
+			if err != nil {
+				return nil, err
+			}
+			decoded[i] = f
+		case nil, string, bool:
+			decoded[i] = v
+		default:
+			return nil, errors.New("Unsupported parameter type")
+		}
+	}
+
+	return decoded, nil
+}
+
+// authenticate returns the user r runs as, checking the password it gives
+// with basic authentication. Failing that it answers r with 401 and returns
+// false.
+func (s *HTTPServer) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok && s.credentials == nil {
+		return functions.DefaultUser, true
+	}
+
+	if ok && s.credentials.checkPassword(user, password) {
+		return user, true
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="sgsql"`)
+	writeError(w, http.StatusUnauthorized, errors.New("Access denied"))
+	return "", false
+}
+
+func wantsStream(r *http.Request) bool {
+	return r.URL.Query().Get("stream") == "true" ||
+		strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+func (s *HTTPServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Only POST is allowed"))
+		return
+	}
+
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req QueryRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBody))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		// The error has no type of its own before Go 1.19
+		if strings.Contains(err.Error(), "request body too large") {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("Request body is larger than %d bytes", maxQueryBody))
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	params, err := decodeParams(req.Params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	conn := s.db.Conn()
+	defer conn.Close()
+	conn.SetUser(user)
+	if req.ReadOnly {
+		conn.SetReadOnly(true)
+	}
+	conn.SetDryRun(req.DryRun)
+
+	if wantsStream(r) {
+		streamQuery(w, r, conn, req.Query, params)
+		return
+	}
+
+	results, err := conn.QueryContext(r.Context(), req.Query, params...)
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	rows := results.Rows
+	if rows == nil {
+		rows = [][]interface{}{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queryResponse{Columns: columnResponses(results.Columns), Rows: rows})
+}
+
+func columnResponses(columns []backend.ResultColumn) []columnResponse {
+	resp := []columnResponse{}
+	for _, col := range columns {
+		resp = append(resp, columnResponse{Name: col.Name, Type: col.Type.String()})
+	}
+
+	return resp
+}
+
+// queryError returns the status answering a query failing with err, and
+// the error to tell the client. Internal errors are logged and hidden
+// behind a generic one, like in /healthz.
+func queryError(err error) (int, error) {
+	switch {
+	case errors.Is(err, backend.ErrPermissionDenied), errors.Is(err, backend.ErrPolicyViolation):
+		return http.StatusForbidden, err
+	case internalError(err):
+		log.Printf("http: query: %s", err)
+		return http.StatusInternalServerError, errInternal
+	}
+
+	return http.StatusBadRequest, err
+}
+
+// internalError reports whether a query failing with err is the fault of
+// the server rather than of the query, which clients aren't told the
+// details of.
+func internalError(err error) bool {
+	return errors.Is(err, sgsql.ErrSyncFailed) || errors.Is(err, sgsql.ErrCorrupt) ||
+		errors.Is(err, storage.ErrCorrupt) || errors.As(err, new(*fs.PathError))
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	status, err := queryError(err)
+	writeError(w, status, err)
+}
+
+// streamQuery answers r with the column metadata followed by one JSON array
+// per row, each on its own line, written as the query computes them and
+// flushed periodically so clients can consume large results incrementally.
+// A query failing once the rows started ends them with a line holding the
+// error, as the status is already sent.
+func streamQuery(w http.ResponseWriter, r *http.Request, conn *sgsql.Conn, query string, params []interface{}) {
+	nw := &ndjsonWriter{w: w, enc: json.NewEncoder(w)}
+	nw.flusher, _ = w.(http.Flusher)
+
+	err := conn.StreamQuery(r.Context(), nw, query, params...)
+	if err != nil && !nw.started {
+		writeQueryError(w, err)
+		return
+	}
+
+	if err != nil {
+		_, err = queryError(err)
+		nw.enc.Encode(errorResponse{Error: err.Error()})
+	}
+	if nw.flusher != nil {
+		nw.flusher.Flush()
+	}
+}
+
+// ndjsonWriter writes the rows of a query streamed to an HTTP client.
+type ndjsonWriter struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	flusher http.Flusher
+	started bool
+	rows    int
+}
+
+func (nw *ndjsonWriter) WriteColumns(columns []backend.ResultColumn) error {
+	nw.started = true
+	nw.w.Header().Set("Content-Type", "application/x-ndjson")
+
+	return nw.enc.Encode(struct {
+		Columns []columnResponse `json:"columns"`
+	}{columnResponses(columns)})
+}
+
+func (nw *ndjsonWriter) WriteRow(row []interface{}) error {
+	if err := nw.enc.Encode(row); err != nil {
+		return err
+	}
+
+	if nw.rows++; nw.flusher != nil && nw.rows%flushEvery == 0 {
+		nw.flusher.Flush()
+	}
+	return nil
+}
+
+// handleListen serves GET /listen?channel=name, which can name several
+// channels. It listens on them in a session of its own and streams each
+// notification as a line of JSON until the client goes away.
+func (s *HTTPServer) handleListen(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Only GET is allowed"))
+		return
+	}
+
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	channels := r.URL.Query()["channel"]
+	if len(channels) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("Expected a channel to listen on"))
+		return
+	}
+
+	conn := s.db.Conn()
+	defer conn.Close()
+	conn.SetUser(user)
+	for _, channel := range channels {
+		if err := conn.Exec("LISTEN " + parser.FormatIdentifier(channel)); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	enc := json.NewEncoder(w)
+	notifications := conn.Notifications()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case n := <-notifications:
+			if err := enc.Encode(notificationResponse{Channel: n.Channel, Payload: n.Payload, Session: n.Session}); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// handleChanges serves GET /changes?format=name, which streams the
+// transactions committed from then on as the registered decoder format
+// writes them, json unless the request names another, until the client
+// goes away. A client falling too far behind has its stream ended, as it
+// would miss changes otherwise. The changes hold every row written, whatever
+// policies and masks hide, so only functions.DefaultUser may stream them.
+func (s *HTTPServer) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("Only GET is allowed"))
+		return
+	}
+
+	user, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if user != functions.DefaultUser {
+		writeError(w, http.StatusForbidden, fmt.Errorf("Only %s may stream the changes", functions.DefaultUser))
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	decoder, err := sgsql.LookupDecoder(format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stream := s.db.StreamChanges()
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", decoder.ContentType())
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		change, err := stream.Next(r.Context())
+		if err != nil {
+			return
+		}
+		if err := decoder.Decode(w, change); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/storage"
+)
+
+func TestHTTPQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		want   string
+	}{
+		{
+			"rows with columns", http.MethodPost, "/query",
+			`{"query": "select * from t"}`,
+			http.StatusOK, `{"columns":[{"name":"id","type":"int"},{"name":"name","type":"text"}],"rows":[[1,"a"],[2,"b"]]}` + "\n",
+		},
+		{
+			"params", http.MethodPost, "/query",
+			`{"query": "select $1, $2, $3, $4, $5", "params": [1, 1.5, "x", true, null]}`,
+			http.StatusOK, `"rows":[[1,1.5,"x",true,null]]`,
+		},
+		{
+			"no rows", http.MethodPost, "/query",
+			`{"query": "select * from t where id > 5"}`,
+			http.StatusOK, `"rows":[]`,
+		},
+		{
+			"streamed", http.MethodPost, "/query?stream=true",
+			`{"query": "select * from t"}`,
+			http.StatusOK, `{"columns":[{"name":"id","type":"int"},{"name":"name","type":"text"}]}` + "\n[1,\"a\"]\n[2,\"b\"]\n",
+		},
+		{
+			"streamed aggregate", http.MethodPost, "/query?stream=true",
+			`{"query": "select count(*) from t"}`,
+			http.StatusOK, `{"columns":[{"name":"?column?","type":"int"}]}` + "\n[2]\n",
+		},
+		{
+			"streamed failing", http.MethodPost, "/query?stream=true",
+			`{"query": "select 1 / (id - 2) from t"}`,
+			http.StatusOK, "[-1]\n{\"error\":",
+		},
+		{
+			"read-only", http.MethodPost, "/query",
+			`{"query": "insert into t values (3, 'c')", "read_only": true}`,
+			http.StatusBadRequest, `"error":`,
+		},
+		{
+			"invalid query", http.MethodPost, "/query",
+			`{"query": "select from"}`,
+			http.StatusBadRequest, `"error":`,
+		},
+		{
+			"invalid body", http.MethodPost, "/query",
+			`{"query": `,
+			http.StatusBadRequest, `"error":`,
+		},
+		{
+			"unsupported param", http.MethodPost, "/query",
+			`{"query": "select $1", "params": [[1]]}`,
+			http.StatusBadRequest, "Unsupported parameter type",
+		},
+		{
+			"wrong method", http.MethodGet, "/query",
+			"",
+			http.StatusMethodNotAllowed, "Only POST is allowed",
+		},
+	}
+
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, query := range []string{"create table t (id int, name text)", "insert into t values (1, 'a')", "insert into t values (2, 'b')"} {
+		if err := db.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	s := NewHTTPServer(db)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("got %d %s, want %d containing %s", w.Code, w.Body.String(), tt.status, tt.want)
+			}
+		})
+	}
+
+	// Neither the read-only nor the dry-run request changed the table
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(`{"query": "insert into t values (3, 'c')", "dry_run": true}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("dry run: got %d %s", w.Code, w.Body.String())
+	}
+
+	results, err := db.Query("select count(*) from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := results.Rows[0][0]; n != int64(2) {
+		t.Errorf("t has %v rows, want 2", n)
+	}
+}
+
+func TestHealthRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sgsql.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"create table t (a int)", "insert into t values (1)", "insert into t values (2)"} {
+		if err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = sgsql.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	w := httptest.NewRecorder()
+	NewHTTPServer(db).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+
+	var resp healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Recovery.Status != "complete" || resp.Recovery.ReplayedEntries != 3 || resp.Recovery.DurationMs < 0 {
+		t.Errorf("recovery is %+v", resp.Recovery)
+	}
+}
+
+func TestHealthDiskFree(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		// known is whether the free disk space is reported as a number
+		known bool
+	}{
+		{"in memory", sgsql.MemoryPath, false},
+		{"file", filepath.Join(t.TempDir(), "test.db"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sgsql.Open(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			for _, target := range []string{"/healthz", "/readyz"} {
+				w := httptest.NewRecorder()
+				NewHTTPServer(db).ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s: got %d %s", target, w.Code, w.Body.String())
+				}
+
+				var resp struct {
+					DiskFree interface{} `json:"disk_free_bytes"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if n, ok := resp.DiskFree.(float64); ok != tt.known || (ok && n <= 0) || (!ok && resp.DiskFree != nil) {
+					t.Errorf("%s: disk_free_bytes is %v", target, resp.DiskFree)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPQueryStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		want   string
+	}{
+		{"permission", fmt.Errorf("%w: t", backend.ErrPermissionDenied), http.StatusForbidden, "t"},
+		{"policy", backend.ErrPolicyViolation, http.StatusForbidden, backend.ErrPolicyViolation.Error()},
+		{"sync", sgsql.ErrSyncFailed, http.StatusInternalServerError, "Internal error"},
+		{"row", storage.ErrCorrupt, http.StatusInternalServerError, "Internal error"},
+		{"file", &fs.PathError{Op: "write", Path: "/secret/db", Err: fs.ErrPermission}, http.StatusInternalServerError, "Internal error"},
+		{"query", backend.ErrTableDoesNotExist, http.StatusBadRequest, backend.ErrTableDoesNotExist.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := queryError(tt.err)
+			if status != tt.status || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %d %v, want %d containing %s", status, err, tt.status, tt.want)
+			}
+		})
+	}
+
+	// Only the superuser may vacuum, streaming or not
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := NewHTTPServer(db)
+	s.SetCredentials(testCredentials(t))
+	for _, target := range []string{"/query", "/query?stream=true"} {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"query": "vacuum"}`))
+		r.SetBasicAuth("alice", "secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d %s, want %d", target, w.Code, w.Body.String(), http.StatusForbidden)
+		}
+	}
+}
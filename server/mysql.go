@@ -0,0 +1,632 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/types"
+)
+
+// Subset of the MySQL client/server protocol constants that the frontend
+// needs. See https://dev.mysql.com/doc/dev/mysql-server/latest/PAGE_PROTOCOL.html
+const (
+	mysqlServerVersion = functions.Version
+
+	mysqlClientLongPassword     = 0x00000001
+	mysqlClientLongFlag         = 0x00000004
+	mysqlClientConnectWithDB    = 0x00000008
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientTransactions     = 0x00002000
+	mysqlClientSecureConnection = 0x00008000
+	mysqlClientPluginAuth       = 0x00080000
+	// Only sent by clients, the server doesn't announce it
+	mysqlClientPluginAuthLenEncData = 0x00200000
+
+	mysqlNativePassword = "mysql_native_password"
+
+	mysqlStatusInTrans    = 0x0001
+	mysqlStatusAutocommit = 0x0002
+
+	mysqlComQuit             = 0x01
+	mysqlComInitDB           = 0x02
+	mysqlComQuery            = 0x03
+	mysqlComPing             = 0x0e
+	mysqlComStmtPrepare      = 0x16
+	mysqlComStmtExecute      = 0x17
+	mysqlComStmtSendLongData = 0x18
+	mysqlComStmtClose        = 0x19
+	mysqlComStmtReset        = 0x1a
+	// Sent by connection pools between the clients they hand a connection to
+	mysqlComResetConnection = 0x1f
+
+	mysqlTypeDecimal    = 0x00
+	mysqlTypeTiny       = 0x01
+	mysqlTypeShort      = 0x02
+	mysqlTypeLong       = 0x03
+	mysqlTypeFloat      = 0x04
+	mysqlTypeDouble     = 0x05
+	mysqlTypeNull       = 0x06
+	mysqlTypeTimestamp  = 0x07
+	mysqlTypeLongLong   = 0x08
+	mysqlTypeInt24      = 0x09
+	mysqlTypeDate       = 0x0a
+	mysqlTypeDatetime   = 0x0c
+	mysqlTypeYear       = 0x0d
+	mysqlTypeNewDecimal = 0xf6
+	mysqlTypeVarString  = 0xfd
+
+	mysqlCharsetUTF8 = 0x21
+
+	// Payloads longer than a packet can hold are split across packets,
+	// clients may send up to mysqlMaxPacket bytes
+	mysqlMaxPayload = 0xffffff
+	mysqlMaxPacket  = 1 << 30
+
+	mysqlErrAccessDenied   = 1045
+	mysqlErrUnknown        = 1105
+	mysqlErrUnknownCommand = 1047
+	mysqlErrUnknownStmt    = 1243
+)
+
+// MySQLServer speaks enough of the MySQL protocol for clients to connect
+// and run text queries and prepared statements. Clients authenticate with
+// mysql_native_password against the credentials set with SetCredentials,
+// without any only as functions.DefaultUser. Each connection runs in its
+// own session.
+type MySQLServer struct {
+	db          *sgsql.DB
+	connID      uint32
+	credentials *Credentials
+	conns       connSet
+}
+
+func NewMySQLServer(db *sgsql.DB) *MySQLServer {
+	return &MySQLServer{db: db}
+}
+
+// SetCredentials sets the users clients may connect as. It must be called
+// before the server is started.
+func (s *MySQLServer) SetCredentials(c *Credentials) {
+	s.credentials = c
+}
+
+func (s *MySQLServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it fails or the server is shut
+// down, handling each connection in its own goroutine.
+func (s *MySQLServer) Serve(l net.Listener) error {
+	return s.conns.serve(l, "mysql", s.handleConn)
+}
+
+// Shutdown stops the server without interrupting transactions: it stops
+// accepting connections and closes those waiting for a command outside a
+// transaction, the others once their transaction ends. When ctx is done
+// first, the connections left are closed, rolling back their transactions,
+// and its error is returned. Serve returns ErrServerClosed after Shutdown.
+func (s *MySQLServer) Shutdown(ctx context.Context) error {
+	return s.conns.shutdown(ctx)
+}
+
+type mysqlConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	seq  byte
+	// status is sent in OK and EOF packets
+	status uint16
+	// stmts are the statements the client prepared by their ids
+	stmts    map[uint32]*mysqlStmt
+	lastStmt uint32
+}
+
+// readPacket reads a payload, joining the packets it was split into when
+// it is too long for one.
+func (c *mysqlConn) readPacket() ([]byte, error) {
+	var payload []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return nil, err
+		}
+
+		length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		c.seq = header[3] + 1
+		if len(payload)+length > mysqlMaxPacket {
+			return nil, errPacketTooLarge
+		}
+
+		start := len(payload)
+		payload = append(payload, make([]byte, length)...)
+		if _, err := io.ReadFull(c.r, payload[start:]); err != nil {
+			return nil, err
+		}
+
+		// A full packet is followed by the rest of the payload, which is
+		// empty when the payload is a multiple of the packet size
+		if length < mysqlMaxPayload {
+			return payload, nil
+		}
+	}
+}
+
+// writePacket writes payload, split into as many packets as it needs.
+func (c *mysqlConn) writePacket(payload []byte) error {
+	for {
+		n := len(payload)
+		if n > mysqlMaxPayload {
+			n = mysqlMaxPayload
+		}
+
+		header := []byte{byte(n), byte(n >> 8), byte(n >> 16), c.seq}
+		c.seq++
+
+		if _, err := c.w.Write(header); err != nil {
+			return err
+		}
+		if _, err := c.w.Write(payload[:n]); err != nil {
+			return err
+		}
+
+		payload = payload[n:]
+		if n < mysqlMaxPayload {
+			return nil
+		}
+	}
+}
+
+// The little-endian append helpers of encoding/binary need a newer Go than
+// the module targets.
+func appendUint16(b []byte, n uint16) []byte {
+	return append(b, byte(n), byte(n>>8))
+}
+
+func appendUint32(b []byte, n uint32) []byte {
+	return append(b, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+}
+
+func appendUint64(b []byte, n uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(n)), uint32(n>>32))
+}
+
+func appendLenEncInt(b []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(b, byte(n))
+	case n < 1<<16:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+
+	b = append(b, 0xfe)
+	return appendUint64(b, n)
+}
+
+func appendLenEncString(b []byte, s string) []byte {
+	b = appendLenEncInt(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// setStatus sets the status sent to the client after a statement of
+// session ran.
+func (c *mysqlConn) setStatus(session *sgsql.Conn) {
+	c.status = mysqlStatusAutocommit
+	if session.InTransaction() {
+		c.status = mysqlStatusInTrans
+	}
+}
+
+func (c *mysqlConn) writeOK() error {
+	payload := []byte{0x00}
+	payload = appendLenEncInt(payload, 0) // affected rows
+	payload = appendLenEncInt(payload, 0) // last insert id
+	payload = appendUint16(payload, c.status)
+	payload = appendUint16(payload, 0) // warnings
+
+	return c.writePacket(payload)
+}
+
+func (c *mysqlConn) writeEOF() error {
+	payload := []byte{0xfe}
+	payload = appendUint16(payload, 0) // warnings
+	payload = appendUint16(payload, c.status)
+
+	return c.writePacket(payload)
+}
+
+func (c *mysqlConn) writeError(code uint16, msg string) error {
+	payload := []byte{0xff}
+	payload = appendUint16(payload, code)
+	state := "#HY000"
+	if code == mysqlErrAccessDenied {
+		state = "#28000"
+	}
+	payload = append(payload, state...)
+	payload = append(payload, msg...)
+
+	return c.writePacket(payload)
+}
+
+// writeHandshake greets the client, returning the salt it sent for the
+// client to answer with its password.
+func (s *MySQLServer) writeHandshake(c *mysqlConn) ([]byte, error) {
+	salt := make([]byte, 20)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	// The salt is sent NUL terminated, so it must not contain any NULs
+	for i := range salt {
+		salt[i] = salt[i]%127 + 1
+	}
+
+	caps := uint32(mysqlClientLongPassword | mysqlClientLongFlag |
+		mysqlClientConnectWithDB | mysqlClientProtocol41 |
+		mysqlClientTransactions | mysqlClientSecureConnection |
+		mysqlClientPluginAuth)
+
+	payload := []byte{0x0a}
+	payload = append(payload, mysqlServerVersion...)
+	payload = append(payload, 0)
+	payload = appendUint32(payload, atomic.AddUint32(&s.connID, 1))
+	payload = append(payload, salt[:8]...)
+	payload = append(payload, 0)
+	payload = appendUint16(payload, uint16(caps))
+	payload = append(payload, mysqlCharsetUTF8)
+	payload = appendUint16(payload, mysqlStatusAutocommit)
+	payload = appendUint16(payload, uint16(caps>>16))
+	payload = append(payload, byte(len(salt)+1))
+	payload = append(payload, make([]byte, 10)...)
+	payload = append(payload, salt[8:]...)
+	payload = append(payload, 0)
+	payload = append(payload, mysqlNativePassword...)
+	payload = append(payload, 0)
+
+	return salt, c.writePacket(payload)
+}
+
+func mysqlColumnType(t backend.ColumnType) byte {
+	switch t {
+	case backend.IntType:
+		return mysqlTypeLongLong
+	case backend.BoolType:
+		return mysqlTypeTiny
+	case backend.FloatType:
+		return mysqlTypeDouble
+	case backend.TimestampType:
+		return mysqlTypeDatetime
+	}
+
+	return mysqlTypeVarString
+}
+
+func mysqlTextValue(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case string:
+		return v
+	case time.Time:
+		return types.FormatTimestamp(v)
+	case types.IntervalValue:
+		return v.String()
+	case types.UUIDValue:
+		return v.String()
+	case types.Array:
+		return v.String()
+	}
+
+	return ""
+}
+
+func (c *mysqlConn) writeResults(results *backend.Results) error {
+	if len(results.Columns) == 0 {
+		return c.writeOK()
+	}
+
+	if err := c.writePacket(appendLenEncInt(nil, uint64(len(results.Columns)))); err != nil {
+		return err
+	}
+
+	for _, col := range results.Columns {
+		if err := c.writeColumn(col.Name, mysqlColumnType(col.Type)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeEOF(); err != nil {
+		return err
+	}
+
+	for _, row := range results.Rows {
+		var payload []byte
+		for _, v := range row {
+			if v == nil {
+				payload = append(payload, 0xfb)
+				continue
+			}
+
+			payload = appendLenEncString(payload, mysqlTextValue(v))
+		}
+
+		if err := c.writePacket(payload); err != nil {
+			return err
+		}
+	}
+
+	return c.writeEOF()
+}
+
+func (c *mysqlConn) writeColumn(name string, t byte) error {
+	payload := appendLenEncString(nil, "def")
+	payload = appendLenEncString(payload, "") // schema
+	payload = appendLenEncString(payload, "") // table
+	payload = appendLenEncString(payload, "") // original table
+	payload = appendLenEncString(payload, name)
+	payload = appendLenEncString(payload, name)
+	payload = append(payload, 0x0c)
+	payload = appendUint16(payload, mysqlCharsetUTF8)
+	payload = appendUint32(payload, 1<<16)
+	payload = append(payload, t)
+	payload = appendUint16(payload, 0) // flags
+
+	// Timestamps have microseconds, which clients reading binary rows only
+	// show when the column says so
+	decimals := byte(0)
+	if t == mysqlTypeDatetime {
+		decimals = 6
+	}
+	payload = append(payload, decimals, 0, 0)
+
+	return c.writePacket(payload)
+}
+
+// handshakeResponse is what a client answers the handshake with: the user
+// it connects as, its answer to the salt and the authentication plugin that
+// computed it, empty for clients that don't name one.
+type handshakeResponse struct {
+	user   string
+	auth   []byte
+	plugin string
+}
+
+var (
+	errMalformedHandshake = errors.New("Malformed handshake response")
+	errPacketTooLarge     = errors.New("Packet is too large")
+)
+
+// parseHandshake parses a protocol 4.1 handshake response. The user name
+// follows the capabilities, maximum packet size, character set and 23
+// reserved bytes, terminated by a NUL, then come the answer to the salt and,
+// when the capabilities say so, the database and the plugin.
+func parseHandshake(response []byte) (handshakeResponse, error) {
+	const start = 4 + 4 + 1 + 23
+	if len(response) < start {
+		return handshakeResponse{}, errMalformedHandshake
+	}
+
+	caps := readUint32(response)
+	rest := response[start:]
+	nulTerminated := func() (string, bool) {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return "", false
+		}
+		s := string(rest[:i])
+		rest = rest[i+1:]
+		return s, true
+	}
+
+	var hr handshakeResponse
+	var ok bool
+	if hr.user, ok = nulTerminated(); !ok {
+		return handshakeResponse{}, errMalformedHandshake
+	}
+
+	switch {
+	case caps&mysqlClientPluginAuthLenEncData != 0:
+		n, size, ok := readLenEncInt(rest)
+		if !ok || n > uint64(len(rest)-size) {
+			return handshakeResponse{}, errMalformedHandshake
+		}
+		hr.auth, rest = rest[size:size+int(n)], rest[size+int(n):]
+	case caps&mysqlClientSecureConnection != 0:
+		if len(rest) == 0 || int(rest[0]) > len(rest)-1 {
+			return handshakeResponse{}, errMalformedHandshake
+		}
+		n := int(rest[0])
+		hr.auth, rest = rest[1:1+n], rest[1+n:]
+	default:
+		auth, ok := nulTerminated()
+		if !ok {
+			return handshakeResponse{}, errMalformedHandshake
+		}
+		hr.auth = []byte(auth)
+	}
+
+	// Old clients end the response early, leaving out what follows
+	if caps&mysqlClientConnectWithDB != 0 {
+		if _, ok := nulTerminated(); !ok {
+			return hr, nil
+		}
+	}
+	if caps&mysqlClientPluginAuth != 0 {
+		hr.plugin, _ = nulTerminated()
+	}
+
+	return hr, nil
+}
+
+// authenticate checks the credentials of the handshake response hr to the
+// handshake that sent salt, and returns the user the client connects as.
+// A client whose plugin isn't mysql_native_password is asked to switch to
+// it first.
+func (s *MySQLServer) authenticate(c *mysqlConn, salt []byte, hr handshakeResponse) (string, bool, error) {
+	user := hr.user
+	if user == "" {
+		user = functions.DefaultUser
+	}
+
+	if s.credentials != nil && hr.plugin != "" && hr.plugin != mysqlNativePassword {
+		payload := []byte{0xfe}
+		payload = append(payload, mysqlNativePassword...)
+		payload = append(payload, 0)
+		payload = append(payload, salt...)
+		payload = append(payload, 0)
+		if err := c.writePacket(payload); err != nil {
+			return "", false, err
+		}
+		if err := c.w.Flush(); err != nil {
+			return "", false, err
+		}
+
+		auth, err := c.readPacket()
+		if err != nil {
+			return "", false, err
+		}
+		hr.auth = auth
+	}
+
+	return user, s.credentials.checkScramble(user, salt, hr.auth), nil
+}
+
+func (s *MySQLServer) handleConn(conn net.Conn) error {
+	defer conn.Close()
+
+	c := &mysqlConn{
+		conn:   conn,
+		r:      bufio.NewReader(conn),
+		w:      bufio.NewWriter(conn),
+		status: mysqlStatusAutocommit,
+	}
+
+	tracked := s.conns.add(conn)
+	defer s.conns.remove(tracked)
+
+	session := s.db.Conn()
+	defer session.Close()
+	salt, err := s.writeHandshake(c)
+	if err != nil {
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	if !s.conns.waitCommand(tracked, false) {
+		return nil
+	}
+	response, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	s.conns.runCommand(tracked)
+
+	hr, err := parseHandshake(response)
+	if err != nil {
+		return err
+	}
+	user, ok, err := s.authenticate(c, salt, hr)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := c.writeError(mysqlErrAccessDenied, fmt.Sprintf("Access denied for user '%s'", user)); err != nil {
+			return err
+		}
+		return c.w.Flush()
+	}
+	session.SetUser(user)
+	if err := c.writeOK(); err != nil {
+		return err
+	}
+
+	for {
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		if !s.conns.waitCommand(tracked, session.InTransaction()) {
+			return nil
+		}
+		packet, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		s.conns.runCommand(tracked)
+
+		if len(packet) == 0 {
+			return errors.New("Empty command packet")
+		}
+
+		switch packet[0] {
+		case mysqlComQuit:
+			return nil
+		case mysqlComInitDB, mysqlComPing:
+			err = c.writeOK()
+		case mysqlComResetConnection:
+			if rerr := session.Reset(); rerr != nil {
+				err = c.writeError(mysqlErrUnknown, rerr.Error())
+				break
+			}
+
+			c.stmts = nil
+			c.status = mysqlStatusAutocommit
+			err = c.writeOK()
+		case mysqlComQuery:
+			results, qerr := session.Query(string(packet[1:]))
+			c.setStatus(session)
+
+			if qerr != nil {
+				err = c.writeError(mysqlErrUnknown, qerr.Error())
+				break
+			}
+
+			err = c.writeResults(results)
+		case mysqlComStmtPrepare:
+			err = c.prepare(session, string(packet[1:]))
+		case mysqlComStmtExecute:
+			err = c.execute(session, packet[1:])
+		case mysqlComStmtSendLongData:
+			// Neither this nor closing a statement is answered
+			c.sendLongData(packet[1:])
+		case mysqlComStmtClose:
+			if len(packet) >= 5 {
+				delete(c.stmts, readUint32(packet[1:]))
+			}
+		case mysqlComStmtReset:
+			err = c.resetStmt(packet[1:])
+		default:
+			err = c.writeError(mysqlErrUnknownCommand, "Unsupported command")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
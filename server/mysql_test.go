@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nireo/sgsql"
+)
+
+// query sends query to the server of c and returns the first packet of the
+// answer, reading the rest of any result set.
+func query(t *testing.T, c *mysqlConn, query string) []byte {
+	t.Helper()
+
+	c.seq = 0
+	if err := c.writePacket(append([]byte{mysqlComQuery}, query...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first[0] == 0x00 || first[0] == 0xff {
+		return first
+	}
+
+	// The column definitions and the rows each end with an EOF packet
+	for eofs := 0; eofs < 2; {
+		packet, err := c.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet[0] == 0xfe && len(packet) < 9 {
+			eofs++
+		}
+	}
+
+	return first
+}
+
+func TestMySQLTransactions(t *testing.T) {
+	tests := []struct {
+		query string
+		// err is part of the message of the error the query fails with,
+		// empty when it succeeds
+		err     string
+		inTrans bool
+	}{
+		{"begin", "", true},
+		{"insert into t values (1)", "", true},
+		{"commit", "", false},
+
+		{"begin", "", true},
+		{"insert into t values (2)", "", true},
+		{"rollback", "", false},
+
+		{"begin", "", true},
+		{"begin", sgsql.ErrTxInProgress.Error(), true},
+		{"insert into t values (3)", "", true},
+		{"insert into missing values (1)", "does not exist", true},
+		{"select 1", sgsql.ErrTxAborted.Error(), true},
+		{"insert into t values (6)", sgsql.ErrTxAborted.Error(), true},
+		{"commit", sgsql.ErrTxRolledBack.Error(), false},
+
+		{"commit", sgsql.ErrNoTx.Error(), false},
+		{"insert into t values (4)", "", false},
+		{"begin", "", true},
+		{"insert into t values (5)", "", true},
+	}
+
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Exec("create table t (id int)"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, reply := connect(t, NewMySQLServer(db), "sgsql", "", mysqlNativePassword)
+	if reply != 0x00 {
+		t.Fatalf("handshake failed with %#x", reply)
+	}
+
+	for _, tt := range tests {
+		packet := query(t, c, tt.query)
+		switch {
+		case tt.err == "" && packet[0] != 0x00:
+			t.Fatalf("%s: failed with %s", tt.query, packet[9:])
+		case tt.err != "" && (packet[0] != 0xff || !strings.Contains(string(packet[9:]), tt.err)):
+			t.Fatalf("%s: got %q, want an error containing %s", tt.query, packet, tt.err)
+		case tt.err == "" && len(packet) >= 5:
+			status := uint16(packet[3]) | uint16(packet[4])<<8
+			if inTrans := status&mysqlStatusInTrans != 0; inTrans != tt.inTrans {
+				t.Errorf("%s: in a transaction = %v, want %v", tt.query, inTrans, tt.inTrans)
+			}
+		}
+	}
+
+	// Closing the connection rolls back the transaction left open
+	c.conn.Close()
+	results, err := db.Query("select id from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Rows) != 2 || results.Rows[0][0] != int64(1) || results.Rows[1][0] != int64(4) {
+		t.Errorf("t holds %v, want 1 and 4", results.Rows)
+	}
+}
+
+func TestMySQLPacketSplit(t *testing.T) {
+	tests := []struct {
+		size int
+		// packets are the lengths of the packets the payload is sent in
+		packets []int
+	}{
+		{0, []int{0}},
+		{10, []int{10}},
+		{mysqlMaxPayload - 1, []int{mysqlMaxPayload - 1}},
+		{mysqlMaxPayload, []int{mysqlMaxPayload, 0}},
+		{mysqlMaxPayload + 10, []int{mysqlMaxPayload, 10}},
+		{2 * mysqlMaxPayload, []int{mysqlMaxPayload, mysqlMaxPayload, 0}},
+	}
+
+	for _, tt := range tests {
+		payload := make([]byte, tt.size)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+
+		var buf bytes.Buffer
+		c := &mysqlConn{r: bufio.NewReader(&buf), w: bufio.NewWriter(&buf), seq: 3}
+		if err := c.writePacket(payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if want := 3 + byte(len(tt.packets)); c.seq != want {
+			t.Errorf("%d bytes: sequence is %d after writing, want %d", tt.size, c.seq, want)
+		}
+
+		// Walk the headers of the packets without consuming them
+		var packets []int
+		for b := buf.Bytes(); len(b) >= 4; {
+			length := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+			if want := 3 + byte(len(packets)); b[3] != want {
+				t.Errorf("%d bytes: packet %d has sequence %d, want %d", tt.size, len(packets), b[3], want)
+			}
+			packets = append(packets, length)
+			b = b[4+length:]
+		}
+		if !reflect.DeepEqual(packets, tt.packets) {
+			t.Errorf("%d bytes: written in packets of %v, want %v", tt.size, packets, tt.packets)
+		}
+
+		got, err := c.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%d bytes: read back %d bytes that differ", tt.size, len(got))
+		}
+		if buf.Len() != 0 || c.r.Buffered() != 0 {
+			t.Errorf("%d bytes: %d bytes left after reading", tt.size, buf.Len()+c.r.Buffered())
+		}
+	}
+}
+
+func TestMySQLLargeValues(t *testing.T) {
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Exec("create table t (v text)"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, reply := connect(t, NewMySQLServer(db), "sgsql", "", mysqlNativePassword)
+	if reply != 0x00 {
+		t.Fatalf("handshake failed with %#x", reply)
+	}
+
+	// The query and the row sent back are both longer than a packet
+	value := strings.Repeat("x", mysqlMaxPayload+100)
+	if packet := query(t, c, "insert into t values ('"+value+"')"); packet[0] != 0x00 {
+		t.Fatalf("insert failed with %s", packet[9:])
+	}
+
+	c.seq = 0
+	if err := c.writePacket(append([]byte{mysqlComQuery}, "select v from t"...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The column count, its definition and an EOF packet come before the row
+	for i := 0; i < 3; i++ {
+		if _, err := c.readPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, n, ok := readLenEncInt(row)
+	if !ok || int(length) != len(value) || string(row[n:]) != value {
+		t.Fatalf("got a row of %d bytes, want the %d inserted", len(row), len(value))
+	}
+	if packet, err := c.readPacket(); err != nil || packet[0] != 0xfe {
+		t.Fatalf("got %q, %v after the row, want an EOF packet", packet, err)
+	}
+
+	if packet := query(t, c, "select 1"); packet[0] == 0xff {
+		t.Fatalf("select failed with %s after the large row", packet[9:])
+	}
+}
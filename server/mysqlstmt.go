@@ -0,0 +1,452 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/backend"
+)
+
+var (
+	errStmtPacket    = errors.New("Malformed prepared statement packet")
+	errUnknownStmt   = errors.New("Unknown prepared statement")
+	errParamOverflow = errors.New("Unsigned parameter is out of range")
+)
+
+// mysqlStmt is a statement a client prepared with COM_STMT_PREPARE. Its
+// parameters are bound in binary, each sent in the format of its type.
+type mysqlStmt struct {
+	stmt   *sgsql.Stmt
+	params int
+	// types are the types of the parameters the client bound last, which
+	// it only sends again when they change
+	types []uint16
+	// long holds the parameters sent in pieces with
+	// COM_STMT_SEND_LONG_DATA, which aren't sent again on execution
+	long map[int][]byte
+}
+
+func readUint16(b []byte) uint16 {
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func readUint32(b []byte) uint32 {
+	return uint32(readUint16(b)) | uint32(readUint16(b[2:]))<<16
+}
+
+func readUint64(b []byte) uint64 {
+	return uint64(readUint32(b)) | uint64(readUint32(b[4:]))<<32
+}
+
+// readLenEncInt reads a length-encoded integer, returning it and the number
+// of bytes it takes.
+func readLenEncInt(b []byte) (uint64, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+
+	size := 1
+	switch b[0] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	}
+	if len(b) < size {
+		return 0, 0, false
+	}
+
+	switch size {
+	case 3:
+		return uint64(readUint16(b[1:])), size, true
+	case 4:
+		return uint64(readUint16(b[1:])) | uint64(b[3])<<16, size, true
+	case 9:
+		return readUint64(b[1:]), size, true
+	}
+	return uint64(b[0]), size, true
+}
+
+// prepare answers COM_STMT_PREPARE with the id of the statement, its
+// parameters and the columns it returns.
+func (c *mysqlConn) prepare(session *sgsql.Conn, query string) error {
+	stmt, err := session.Prepare(query)
+	if err != nil {
+		return c.writeError(mysqlErrUnknown, err.Error())
+	}
+
+	columns, err := stmt.Describe(context.Background())
+	if err != nil {
+		return c.writeError(mysqlErrUnknown, err.Error())
+	}
+
+	c.lastStmt++
+	if c.stmts == nil {
+		c.stmts = map[uint32]*mysqlStmt{}
+	}
+	c.stmts[c.lastStmt] = &mysqlStmt{stmt: stmt, params: stmt.NumParams()}
+
+	payload := []byte{0x00}
+	payload = appendUint32(payload, c.lastStmt)
+	payload = appendUint16(payload, uint16(len(columns)))
+	payload = appendUint16(payload, uint16(stmt.NumParams()))
+	payload = append(payload, 0)       // filler
+	payload = appendUint16(payload, 0) // warnings
+	if err := c.writePacket(payload); err != nil {
+		return err
+	}
+
+	if stmt.NumParams() > 0 {
+		for i := 0; i < stmt.NumParams(); i++ {
+			if err := c.writeColumn("?", mysqlTypeVarString); err != nil {
+				return err
+			}
+		}
+		if err := c.writeEOF(); err != nil {
+			return err
+		}
+	}
+
+	if len(columns) > 0 {
+		for _, col := range columns {
+			if err := c.writeColumn(col.Name, mysqlColumnType(col.Type)); err != nil {
+				return err
+			}
+		}
+		return c.writeEOF()
+	}
+
+	return nil
+}
+
+// execute answers COM_STMT_EXECUTE, which carries the id of the statement
+// and the parameters to run it with, with its results in binary rows.
+func (c *mysqlConn) execute(session *sgsql.Conn, packet []byte) error {
+	if len(packet) < 9 {
+		return c.writeError(mysqlErrUnknown, errStmtPacket.Error())
+	}
+
+	s, ok := c.stmts[readUint32(packet)]
+	if !ok {
+		return c.writeError(mysqlErrUnknownStmt, errUnknownStmt.Error())
+	}
+
+	// The flags and iteration count that follow the id are always 0 and 1
+	args, err := s.bind(packet[9:])
+	s.long = nil
+	if err != nil {
+		return c.writeError(mysqlErrUnknown, err.Error())
+	}
+
+	results, err := s.stmt.Query(args...)
+	c.setStatus(session)
+	if err != nil {
+		return c.writeError(mysqlErrUnknown, err.Error())
+	}
+
+	return c.writeBinaryResults(results)
+}
+
+// bind decodes the parameters of a COM_STMT_EXECUTE packet: a bitmap of
+// the NULL ones, whether their types follow, the types if they do and then
+// the value of each parameter that isn't NULL.
+func (s *mysqlStmt) bind(b []byte) ([]interface{}, error) {
+	if s.params == 0 {
+		return nil, nil
+	}
+
+	nulls := (s.params + 7) / 8
+	if len(b) < nulls+1 {
+		return nil, errStmtPacket
+	}
+	bitmap, bound := b[:nulls], b[nulls]
+	b = b[nulls+1:]
+
+	if bound == 1 {
+		if len(b) < 2*s.params {
+			return nil, errStmtPacket
+		}
+
+		s.types = make([]uint16, s.params)
+		for i := range s.types {
+			s.types[i] = readUint16(b[2*i:])
+		}
+		b = b[2*s.params:]
+	}
+	if s.types == nil {
+		return nil, errStmtPacket
+	}
+
+	args := make([]interface{}, s.params)
+	for i := range args {
+		if long, ok := s.long[i]; ok {
+			args[i] = string(long)
+			continue
+		}
+
+		if bitmap[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+
+		v, n, err := binaryParam(b, s.types[i])
+		if err != nil {
+			return nil, err
+		}
+		args[i], b = v, b[n:]
+	}
+
+	return args, nil
+}
+
+// binaryParam decodes a parameter of type t, the MySQL type in the low
+// byte with 0x80 in the high one for unsigned integers, returning it and
+// the number of bytes it takes. There is no integer type as small as TINY,
+// so it stands for bool like it does in results.
+func binaryParam(b []byte, t uint16) (interface{}, int, error) {
+	unsigned := t&0x8000 != 0
+
+	size := 0
+	switch byte(t) {
+	case mysqlTypeNull:
+		return nil, 0, nil
+	case mysqlTypeTiny:
+		size = 1
+	case mysqlTypeShort, mysqlTypeYear:
+		size = 2
+	case mysqlTypeLong, mysqlTypeInt24, mysqlTypeFloat:
+		size = 4
+	case mysqlTypeLongLong, mysqlTypeDouble:
+		size = 8
+	case mysqlTypeDate, mysqlTypeDatetime, mysqlTypeTimestamp:
+		if len(b) == 0 {
+			return nil, 0, errStmtPacket
+		}
+		size = 1 + int(b[0])
+	default:
+		// Strings, blobs and decimals are sent as length-encoded strings
+		n, prefix, ok := readLenEncInt(b)
+		if !ok || uint64(len(b)-prefix) < n {
+			return nil, 0, errStmtPacket
+		}
+
+		s := string(b[prefix : prefix+int(n)])
+		switch byte(t) {
+		case mysqlTypeDecimal, mysqlTypeNewDecimal:
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f, prefix + int(n), nil
+			}
+		}
+		return s, prefix + int(n), nil
+	}
+	if len(b) < size {
+		return nil, 0, errStmtPacket
+	}
+
+	switch byte(t) {
+	case mysqlTypeTiny:
+		// Clients send booleans as TINY, which results send bools as too
+		return b[0] != 0, size, nil
+	case mysqlTypeShort, mysqlTypeYear:
+		if unsigned {
+			return int64(readUint16(b)), size, nil
+		}
+		return int64(int16(readUint16(b))), size, nil
+	case mysqlTypeLong, mysqlTypeInt24:
+		if unsigned {
+			return int64(readUint32(b)), size, nil
+		}
+		return int64(int32(readUint32(b))), size, nil
+	case mysqlTypeLongLong:
+		n := readUint64(b)
+		if unsigned && n > math.MaxInt64 {
+			return nil, 0, errParamOverflow
+		}
+		return int64(n), size, nil
+	case mysqlTypeFloat:
+		return float64(math.Float32frombits(readUint32(b))), size, nil
+	case mysqlTypeDouble:
+		return math.Float64frombits(readUint64(b)), size, nil
+	}
+
+	return binaryTime(b[1:size]), size, nil
+}
+
+// binaryTime decodes a date or time of day, which leaves out the parts
+// that are zero from the end: the microseconds, the time, or everything.
+func binaryTime(b []byte) time.Time {
+	var year, month, day, hour, minute, sec, usec int
+	if len(b) >= 4 {
+		year, month, day = int(readUint16(b)), int(b[2]), int(b[3])
+	}
+	if len(b) >= 7 {
+		hour, minute, sec = int(b[4]), int(b[5]), int(b[6])
+	}
+	if len(b) >= 11 {
+		usec = int(readUint32(b[7:]))
+	}
+
+	return time.Date(year, time.Month(month), day, hour, minute, sec, usec*1000, time.UTC)
+}
+
+// sendLongData adds a piece of a parameter sent with
+// COM_STMT_SEND_LONG_DATA to the statement, whose next execution binds it.
+func (c *mysqlConn) sendLongData(packet []byte) {
+	if len(packet) < 6 {
+		return
+	}
+
+	s, ok := c.stmts[readUint32(packet)]
+	param := int(readUint16(packet[4:]))
+	if !ok || param >= s.params {
+		return
+	}
+
+	if s.long == nil {
+		s.long = map[int][]byte{}
+	}
+	s.long[param] = append(s.long[param], packet[6:]...)
+}
+
+// resetStmt answers COM_STMT_RESET, which drops the long data sent for a
+// statement.
+func (c *mysqlConn) resetStmt(packet []byte) error {
+	if len(packet) < 4 {
+		return c.writeError(mysqlErrUnknown, errStmtPacket.Error())
+	}
+
+	s, ok := c.stmts[readUint32(packet)]
+	if !ok {
+		return c.writeError(mysqlErrUnknownStmt, errUnknownStmt.Error())
+	}
+
+	s.long = nil
+	return c.writeOK()
+}
+
+// writeBinaryResults writes results as a result set of binary rows, which
+// is how the results of prepared statements are sent. Integers, floats,
+// bools and timestamps are sent in their binary form, which is smaller and
+// cheaper to produce and parse than their text, everything else as text.
+func (c *mysqlConn) writeBinaryResults(results *backend.Results) error {
+	if len(results.Columns) == 0 {
+		return c.writeOK()
+	}
+
+	if err := c.writePacket(appendLenEncInt(nil, uint64(len(results.Columns)))); err != nil {
+		return err
+	}
+
+	types := make([]byte, len(results.Columns))
+	for i, col := range results.Columns {
+		types[i] = binaryColumnType(col.Type, results.Rows, i)
+		if err := c.writeColumn(col.Name, types[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeEOF(); err != nil {
+		return err
+	}
+
+	// The NULL bitmap of a binary row starts at its third bit
+	nulls := (len(results.Columns) + 7 + 2) / 8
+	for _, row := range results.Rows {
+		payload := make([]byte, 1+nulls)
+		for i, v := range row {
+			if v == nil {
+				payload[1+(i+2)/8] |= 1 << ((i + 2) % 8)
+				continue
+			}
+
+			payload = appendBinaryValue(payload, types[i], v)
+		}
+
+		if err := c.writePacket(payload); err != nil {
+			return err
+		}
+	}
+
+	return c.writeEOF()
+}
+
+// binaryColumnType returns the type column i of rows is sent as, that of
+// its type t unless it holds values of another type. The type of a column
+// computed from a placeholder depends on the argument bound to it, so it
+// is sent as text then.
+func binaryColumnType(t backend.ColumnType, rows [][]interface{}, i int) byte {
+	mt := mysqlColumnType(t)
+	for _, row := range rows {
+		var ok bool
+		switch row[i].(type) {
+		case nil:
+			ok = true
+		case int64:
+			ok = mt == mysqlTypeLongLong
+		case float64:
+			ok = mt == mysqlTypeDouble
+		case bool:
+			ok = mt == mysqlTypeTiny
+		case time.Time:
+			ok = mt == mysqlTypeDatetime
+		}
+
+		if !ok {
+			return mysqlTypeVarString
+		}
+	}
+
+	return mt
+}
+
+// appendBinaryValue appends v, which isn't NULL, in the binary form of
+// type t, as binaryColumnType decided.
+func appendBinaryValue(b []byte, t byte, v interface{}) []byte {
+	switch t {
+	case mysqlTypeLongLong:
+		return appendUint64(b, uint64(v.(int64)))
+	case mysqlTypeDouble:
+		return appendUint64(b, math.Float64bits(v.(float64)))
+	case mysqlTypeTiny:
+		if v.(bool) {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case mysqlTypeDatetime:
+		return appendBinaryTime(b, v.(time.Time))
+	}
+
+	return appendLenEncString(b, mysqlTextValue(v))
+}
+
+// appendBinaryTime appends t in UTC the way binaryTime reads it, leaving
+// out the parts that are zero from the end.
+func appendBinaryTime(b []byte, t time.Time) []byte {
+	t = t.UTC()
+	usec := t.Nanosecond() / 1000
+
+	size := byte(11)
+	switch {
+	case usec == 0 && t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0:
+		size = 4
+	case usec == 0:
+		size = 7
+	}
+
+	b = append(b, size)
+	b = appendUint16(b, uint16(t.Year()))
+	b = append(b, byte(t.Month()), byte(t.Day()))
+	if size >= 7 {
+		b = append(b, byte(t.Hour()), byte(t.Minute()), byte(t.Second()))
+	}
+	if size == 11 {
+		b = appendUint32(b, uint32(usec))
+	}
+
+	return b
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+func TestBinaryColumnType(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    backend.ColumnType
+		values []interface{}
+		want   byte
+	}{
+		{"int", backend.IntType, []interface{}{int64(1), nil}, mysqlTypeLongLong},
+		{"float", backend.FloatType, []interface{}{1.5}, mysqlTypeDouble},
+		{"bool", backend.BoolType, []interface{}{true, false}, mysqlTypeTiny},
+		{"timestamp", backend.TimestampType, []interface{}{time.Now()}, mysqlTypeDatetime},
+		{"text", backend.TextType, []interface{}{"a"}, mysqlTypeVarString},
+		{"only nulls", backend.IntType, []interface{}{nil, nil}, mysqlTypeLongLong},
+		{"no rows", backend.FloatType, nil, mysqlTypeDouble},
+		// A column computed from a placeholder holds what was bound to it
+		{"int holding text", backend.IntType, []interface{}{int64(1), "2"}, mysqlTypeVarString},
+		{"float holding an int", backend.FloatType, []interface{}{int64(1)}, mysqlTypeVarString},
+	}
+
+	for _, tt := range tests {
+		rows := make([][]interface{}, len(tt.values))
+		for i, v := range tt.values {
+			rows[i] = []interface{}{"other", v}
+		}
+
+		if got := binaryColumnType(tt.typ, rows, 1); got != tt.want {
+			t.Errorf("%s: got type %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAppendBinaryValue(t *testing.T) {
+	tests := []struct {
+		typ  byte
+		v    interface{}
+		want []byte
+	}{
+		{mysqlTypeLongLong, int64(-2), []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{mysqlTypeLongLong, int64(258), []byte{2, 1, 0, 0, 0, 0, 0, 0}},
+		{mysqlTypeDouble, 1.0, []byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{mysqlTypeTiny, true, []byte{1}},
+		{mysqlTypeTiny, false, []byte{0}},
+		{mysqlTypeDatetime, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), []byte{4, 0xe8, 0x07, 3, 1}},
+		{mysqlTypeDatetime, time.Date(2024, 3, 1, 12, 30, 5, 0, time.UTC), []byte{7, 0xe8, 0x07, 3, 1, 12, 30, 5}},
+		{mysqlTypeDatetime, time.Date(2024, 3, 1, 0, 0, 0, 1000, time.UTC), []byte{11, 0xe8, 0x07, 3, 1, 0, 0, 0, 1, 0, 0, 0}},
+		{mysqlTypeVarString, "hi", []byte{2, 'h', 'i'}},
+		{mysqlTypeVarString, int64(12), []byte{2, '1', '2'}},
+	}
+
+	for _, tt := range tests {
+		if got := appendBinaryValue(nil, tt.typ, tt.v); !bytes.Equal(got, tt.want) {
+			t.Errorf("%#x %v: got %v, want %v", tt.typ, tt.v, got, tt.want)
+		}
+	}
+
+	if got := appendBinaryValue(nil, mysqlTypeDouble, math.Inf(-1)); len(got) != 8 {
+		t.Errorf("-Inf took %d bytes, want 8", len(got))
+	}
+}
+
+func TestAppendBinaryTime(t *testing.T) {
+	tests := []time.Time{
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+		time.Date(1999, 1, 2, 3, 4, 5, 678901000, time.UTC),
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.FixedZone("", 2*60*60)),
+	}
+
+	for _, want := range tests {
+		b := appendBinaryTime(nil, want)
+		if int(b[0]) != len(b)-1 {
+			t.Errorf("%v: length byte %d for %d bytes", want, b[0], len(b)-1)
+		}
+		if got := binaryTime(b[1:]); !got.Equal(want) {
+			t.Errorf("%v: read back as %v", want, got)
+		}
+	}
+
+	// Microseconds are all the protocol keeps
+	if got := binaryTime(appendBinaryTime(nil, time.Date(2024, 3, 1, 0, 0, 0, 1999, time.UTC))[1:]); got.Nanosecond() != 1000 {
+		t.Errorf("read back %d nanoseconds, want 1000", got.Nanosecond())
+	}
+}
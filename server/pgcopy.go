@@ -0,0 +1,555 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+)
+
+// Messages of the COPY sub-protocol, see
+// https://www.postgresql.org/docs/current/protocol-flow.html#PROTOCOL-COPY
+const (
+	pgMsgCopyData = 'd'
+	pgMsgCopyDone = 'c'
+	pgMsgCopyFail = 'f'
+
+	// pgCopyNull is how the text format writes NULL
+	pgCopyNull = `\N`
+
+	// pgCopySignature starts the data of the binary format
+	pgCopySignature = "PGCOPY\n\xff\r\n\x00"
+)
+
+// copy runs a COPY statement, streaming its rows to or from the client,
+// and returns the tag completing it. Errors are those of the statement,
+// the messages of the sub-protocol were sent or read by then.
+func (c *pgConn) copy(session *sgsql.Conn, stmt *parser.CopyStatement) (string, error) {
+	var n int
+	var err error
+	if stmt.From {
+		n, err = c.copyIn(session, stmt)
+	} else {
+		n, err = c.copyOut(session, stmt)
+	}
+
+	return "COPY " + strconv.Itoa(n), err
+}
+
+// copyOut runs COPY TO STDOUT, sending each row of the table or query in a
+// CopyData message of its own. Rows are only sent as text or csv.
+func (c *pgConn) copyOut(session *sgsql.Conn, stmt *parser.CopyStatement) (int, error) {
+	if stmt.Format == "binary" {
+		return 0, pgErrorf(pgErrNotSupported, "Binary COPY TO STDOUT is not supported")
+	}
+
+	var query string
+	switch {
+	case stmt.Query != nil:
+		query = stmt.Query.String()
+	case len(stmt.Columns) == 0:
+		query = "SELECT * FROM " + parser.FormatIdentifier(stmt.Table.Value)
+	default:
+		columns := make([]string, len(stmt.Columns))
+		for i, col := range stmt.Columns {
+			columns[i] = parser.FormatIdentifier(col.Value)
+		}
+		query = "SELECT " + strings.Join(columns, ", ") + " FROM " + parser.FormatIdentifier(stmt.Table.Value)
+	}
+
+	results, err := session.Query(query)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.writeCopyResponse('H', pgFormatText, len(results.Columns)); err != nil {
+		return 0, err
+	}
+
+	line := []byte{}
+	if stmt.Header && stmt.Format == "csv" {
+		for i, col := range results.Columns {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line = appendCSVField(line, col.Name)
+		}
+		if err := c.writeMessage(pgMsgCopyData, append(line, '\n')); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, row := range results.Rows {
+		line = line[:0]
+		for i, v := range row {
+			switch {
+			case i > 0 && stmt.Format == "csv":
+				line = append(line, ',')
+			case i > 0:
+				line = append(line, '\t')
+			}
+
+			switch {
+			case v == nil && stmt.Format == "csv":
+			case v == nil:
+				line = append(line, pgCopyNull...)
+			case stmt.Format == "csv":
+				line = appendCSVField(line, pgTextValue(v))
+			default:
+				line = appendTextField(line, pgTextValue(v))
+			}
+		}
+		if err := c.writeMessage(pgMsgCopyData, append(line, '\n')); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(results.Rows), c.writeMessage(pgMsgCopyDone, nil)
+}
+
+// writeCopyResponse starts copying columns in format, in CopyOutResponse
+// when typ is 'H' and CopyInResponse when it is 'G'.
+func (c *pgConn) writeCopyResponse(typ byte, format int16, columns int) error {
+	payload := pgAppendInt16(append([]byte{}, byte(format)), int16(columns))
+	for i := 0; i < columns; i++ {
+		payload = pgAppendInt16(payload, format)
+	}
+
+	return c.writeMessage(typ, payload)
+}
+
+// appendTextField appends s to b escaped for the text format, which puts
+// a backslash before the characters separating fields and rows.
+func appendTextField(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			b = append(b, `\\`...)
+		case '\t':
+			b = append(b, `\t`...)
+		case '\n':
+			b = append(b, `\n`...)
+		case '\r':
+			b = append(b, `\r`...)
+		default:
+			b = append(b, s[i])
+		}
+	}
+
+	return b
+}
+
+// appendCSVField appends s to b as a field of csv, quoted when it holds a
+// separator or quote or is empty, as an unquoted empty field is NULL.
+func appendCSVField(b []byte, s string) []byte {
+	if s != "" && !strings.ContainsAny(s, ",\"\r\n") {
+		return append(b, s...)
+	}
+
+	b = append(b, '"')
+	b = append(b, strings.ReplaceAll(s, `"`, `""`)...)
+	return append(b, '"')
+}
+
+// copyBatch is how many rows COPY FROM STDIN inserts at a time.
+const copyBatch = 1000
+
+// copyIn runs COPY FROM STDIN, inserting the rows the client sends in
+// CopyData messages until CopyDone. The rows are decoded as they arrive and
+// bulk inserted in batches of copyBatch, in a transaction of their own
+// unless the session is in one, so either all of them are inserted or none.
+// Fields of the binary format are decoded like binary parameters of the
+// types of their columns.
+func (c *pgConn) copyIn(session *sgsql.Conn, stmt *parser.CopyStatement) (int, error) {
+	columns, positions, err := copyColumns(session, stmt)
+	if err != nil {
+		return 0, err
+	}
+	oids := make([]int32, len(positions))
+	for i, p := range positions {
+		oids[i] = pgColumnOID(columns[p].Type)
+	}
+
+	own := !session.InTransaction()
+	if own {
+		if err := session.Exec("BEGIN"); err != nil {
+			return 0, err
+		}
+	}
+
+	format := int16(pgFormatText)
+	if stmt.Format == "binary" {
+		format = pgFormatBinary
+	}
+	if err := c.writeCopyResponse('G', format, len(oids)); err != nil {
+		return 0, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+
+	// The rows are read from the messages of the client through a pipe,
+	// closed with the error of the first row that fails so the rest of the
+	// data is skipped
+	pr, pw := io.Pipe()
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var batch [][]interface{}
+		n, err := copyRows(pr, stmt, len(oids), func(fields []*string) error {
+			// Columns left out of the COPY take their defaults
+			row := make([]interface{}, len(columns))
+			if len(positions) < len(columns) {
+				for i := range row {
+					row[i] = sgsql.Default
+				}
+			}
+			for i, field := range fields {
+				var v interface{}
+				if field != nil {
+					var err error
+					if v, err = pgParam(oids[i], format, []byte(*field)); err != nil {
+						return err
+					}
+				}
+				row[positions[i]] = v
+			}
+
+			if batch = append(batch, row); len(batch) < copyBatch {
+				return nil
+			}
+			err := session.BulkInsert(stmt.Table.Value, batch)
+			batch = nil
+			return err
+		})
+		if err == nil && len(batch) > 0 {
+			err = session.BulkInsert(stmt.Table.Value, batch)
+		}
+		pr.CloseWithError(err)
+		done <- result{n, err}
+	}()
+
+	err = c.readCopyData(pw)
+	res := <-done
+	if err == nil {
+		err = res.err
+	}
+
+	if own {
+		end := "COMMIT"
+		if err != nil {
+			end = "ROLLBACK"
+		}
+		if endErr := session.Exec(end); err == nil {
+			err = endErr
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return res.n, nil
+}
+
+// copyColumns returns the columns of the table of stmt, and the positions
+// among them of the columns of the fields of each row.
+func copyColumns(session *sgsql.Conn, stmt *parser.CopyStatement) ([]backend.ResultColumn, []int, error) {
+	described, err := session.Prepare("SELECT * FROM " + parser.FormatIdentifier(stmt.Table.Value))
+	if err != nil {
+		return nil, nil, err
+	}
+	columns, err := described.Describe(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var positions []int
+	if len(stmt.Columns) == 0 {
+		for i := range columns {
+			positions = append(positions, i)
+		}
+		return columns, positions, nil
+	}
+
+	given := make([]bool, len(columns))
+	for _, col := range stmt.Columns {
+		i := 0
+		for i < len(columns) && columns[i].Name != col.Value {
+			i++
+		}
+		if i == len(columns) {
+			return nil, nil, fmt.Errorf("%w: %s", backend.ErrColumnDoesNotExist, col.Value)
+		}
+		if given[i] {
+			return nil, nil, fmt.Errorf("Column %s is given twice", col.Value)
+		}
+		given[i] = true
+		positions = append(positions, i)
+	}
+
+	return columns, positions, nil
+}
+
+// readCopyData writes the data of the CopyData messages of the client to w
+// until CopyDone, which closes it, or CopyFail, which fails.
+func (c *pgConn) readCopyData(w *io.PipeWriter) error {
+	for {
+		msg := <-c.incoming
+		if msg.err != nil {
+			w.CloseWithError(msg.err)
+			return msg.err
+		}
+
+		switch msg.typ {
+		case pgMsgCopyData:
+			// A failed write means a row failed, the rest is skipped
+			w.Write(msg.payload)
+		case pgMsgCopyDone:
+			return w.Close()
+		case pgMsgCopyFail:
+			r := pgReader{b: msg.payload}
+			err := pgErrorf(pgErrCanceled, "COPY from stdin failed: %s", r.string())
+			w.CloseWithError(err)
+			return err
+		case pgMsgFlush, pgMsgSync:
+		default:
+			err := pgErrorf(pgErrProtocol, "Unexpected message type %q during COPY", msg.typ)
+			w.CloseWithError(err)
+			return err
+		}
+	}
+}
+
+// copyRows decodes the rows of r in the format of stmt, each with columns
+// fields, and hands them to insert. It returns how many rows there were.
+func copyRows(r io.Reader, stmt *parser.CopyStatement, columns int, insert func([]*string) error) (int, error) {
+	br := bufio.NewReader(r)
+	read := readTextRow
+	switch stmt.Format {
+	case "csv":
+		read = readCSVRow
+	case "binary":
+		if err := readBinaryHeader(br); err != nil {
+			return 0, err
+		}
+		read = readBinaryRow
+	}
+
+	n := 0
+	for row := 1; ; row++ {
+		fields, err := read(br)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("COPY row %d: %w", row, err)
+		}
+		if row == 1 && stmt.Header && stmt.Format == "csv" {
+			continue
+		}
+
+		if len(fields) != columns {
+			return n, fmt.Errorf("COPY row %d: %d fields for %d columns", row, len(fields), columns)
+		}
+		if err := insert(fields); err != nil {
+			return n, fmt.Errorf("COPY row %d: %w", row, err)
+		}
+		n++
+	}
+}
+
+// readTextRow reads a row of the text format, where fields are separated by
+// tabs, NULL is \N and backslashes escape the separators. The line \.
+// ends the data like the end of r does.
+func readTextRow(r *bufio.Reader) ([]*string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if line == `\.` {
+		return nil, io.EOF
+	}
+
+	var fields []*string
+	for _, raw := range strings.Split(line, "\t") {
+		if raw == pgCopyNull {
+			fields = append(fields, nil)
+			continue
+		}
+
+		var b strings.Builder
+		for i := 0; i < len(raw); i++ {
+			if raw[i] != '\\' || i+1 == len(raw) {
+				b.WriteByte(raw[i])
+				continue
+			}
+
+			i++
+			switch raw[i] {
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'v':
+				b.WriteByte('\v')
+			default:
+				b.WriteByte(raw[i])
+			}
+		}
+		field := b.String()
+		fields = append(fields, &field)
+	}
+
+	return fields, nil
+}
+
+// readCSVRow reads a row of csv, which may span lines inside quotes. An
+// unquoted empty field is NULL, a quoted one the empty string.
+func readCSVRow(r *bufio.Reader) ([]*string, error) {
+	if _, err := r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+
+	var fields []*string
+	var b strings.Builder
+	quoted, inQuotes := false, false
+	end := func() {
+		field := b.String()
+		if field == "" && !quoted {
+			fields = append(fields, nil)
+		} else {
+			fields = append(fields, &field)
+		}
+		b.Reset()
+		quoted = false
+	}
+
+	for {
+		ch, err := r.ReadByte()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errors.New("Unterminated quoted field")
+			}
+			if len(fields) == 0 && !quoted && b.String() == `\.` {
+				return nil, io.EOF
+			}
+			end()
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case inQuotes && ch == '"':
+			if next, err := r.Peek(1); err == nil && next[0] == '"' {
+				r.ReadByte()
+				b.WriteByte('"')
+			} else {
+				inQuotes = false
+			}
+		case inQuotes:
+			b.WriteByte(ch)
+		case ch == '"':
+			inQuotes, quoted = true, true
+		case ch == ',':
+			end()
+		case ch == '\r':
+		case ch == '\n':
+			// psql may end the data with \. on a line of its own
+			if len(fields) == 0 && !quoted && b.String() == `\.` {
+				return nil, io.EOF
+			}
+			end()
+			return fields, nil
+		default:
+			b.WriteByte(ch)
+		}
+	}
+}
+
+// readBinaryHeader reads the header of the binary format: its signature,
+// flags of which only those that can be ignored may be set, and an
+// extension, which is skipped.
+func readBinaryHeader(r *bufio.Reader) error {
+	header := make([]byte, len(pgCopySignature)+8)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(pgCopySignature)]) != pgCopySignature {
+		return pgErrorf(pgErrBinaryFormat, "COPY file signature not recognized")
+	}
+
+	flags := binary.BigEndian.Uint32(header[len(pgCopySignature):])
+	if flags&0xffff0000 != 0 {
+		return pgErrorf(pgErrBinaryFormat, "Unrecognized critical flags in COPY file header")
+	}
+
+	extension := int64(binary.BigEndian.Uint32(header[len(pgCopySignature)+4:]))
+	if _, err := io.CopyN(io.Discard, r, extension); err != nil {
+		return fmt.Errorf("COPY binary header: %w", err)
+	}
+
+	return nil
+}
+
+// readBinaryRow reads a row of the binary format, the number of its fields
+// followed by each field as its length and bytes, -1 for NULL. A count of
+// -1 ends the data like the end of r does.
+func readBinaryRow(r *bufio.Reader) ([]*string, error) {
+	var count [2]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return nil, err
+	}
+
+	n := int16(binary.BigEndian.Uint16(count[:]))
+	if n == -1 {
+		return nil, io.EOF
+	}
+	if n < 0 {
+		return nil, pgErrorf(pgErrBinaryFormat, "Invalid field count %d", n)
+	}
+
+	fields := make([]*string, n)
+	for i := range fields {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		length := int32(binary.BigEndian.Uint32(size[:]))
+		if length == -1 {
+			continue
+		}
+		if length < 0 {
+			return nil, pgErrorf(pgErrBinaryFormat, "Invalid field length %d", length)
+		}
+
+		b := make([]byte, length)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		field := string(b)
+		fields[i] = &field
+	}
+
+	return fields, nil
+}
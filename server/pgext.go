@@ -0,0 +1,408 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+// pgStmt is a statement a client prepared with Parse.
+type pgStmt struct {
+	stmt *sgsql.Stmt
+	// parsed is the statement, nil for an empty query
+	parsed *parser.Statement
+	// params are the OIDs of the types of the parameters, those the client
+	// gave and the types of the others inferred from the query
+	params  []int32
+	columns []backend.ResultColumn
+}
+
+// pgPortal is a statement a client bound parameters to with Bind, ready to
+// be executed.
+type pgPortal struct {
+	stmt    *pgStmt
+	args    []interface{}
+	formats []int16
+	// results are those of the first execution, of which sent rows were
+	// sent when an execution was limited to fewer rows
+	results *backend.Results
+	sent    int
+}
+
+// extended handles a message of the extended protocol. A message that
+// fails skips the ones after it until the next Sync.
+func (c *pgConn) extended(session *sgsql.Conn, typ byte, payload []byte) error {
+	r := &pgReader{b: payload}
+
+	var err error
+	switch typ {
+	case pgMsgParse:
+		err = c.parse(session, r)
+	case pgMsgBind:
+		err = c.bind(r)
+	case pgMsgDescribe:
+		err = c.describe(r)
+	case pgMsgExecute:
+		err = c.execute(session, r)
+	case pgMsgClose:
+		err = c.close(r)
+	default:
+		err = pgErrorf(pgErrProtocol, "Unsupported message type %q", typ)
+	}
+
+	if err == nil {
+		return nil
+	}
+	c.skipping = true
+	return c.writeError(err)
+}
+
+// parse prepares the statement of a Parse message, which carries its name,
+// the query and the OIDs of the types of its parameters, 0 for those whose
+// type is inferred. The query holds one statement at most.
+func (c *pgConn) parse(session *sgsql.Conn, r *pgReader) error {
+	name, query := r.string(), r.string()
+	oids := make([]int32, r.int16())
+	for i := range oids {
+		oids[i] = r.int32()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	if _, ok := c.stmts[name]; ok && name != "" {
+		return pgErrorf(pgErrDuplicateStmt, "Prepared statement %q already exists", name)
+	}
+
+	ast, err := parser.Parse(query)
+	if err != nil {
+		return &pgError{code: pgErrSyntax, msg: err.Error()}
+	}
+	if len(ast.Statements) > 1 {
+		return pgErrorf(pgErrSyntax, "Prepared statements hold one statement only")
+	}
+
+	stmt, err := session.Prepare(query)
+	if err != nil {
+		return err
+	}
+	ps := &pgStmt{stmt: stmt}
+
+	if len(ast.Statements) == 1 {
+		ps.parsed = ast.Statements[0]
+
+		inferred, err := stmt.ParamTypes()
+		if err != nil {
+			return err
+		}
+		ps.params = make([]int32, len(inferred))
+		for i, t := range inferred {
+			ps.params[i] = pgColumnOID(t)
+		}
+
+		if ps.columns, err = stmt.Describe(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	// Parameters the client gives a type beyond those the query uses are
+	// bound and left unused
+	for i, oid := range oids {
+		if i == len(ps.params) {
+			ps.params = append(ps.params, pgOIDText)
+		}
+		if oid != 0 {
+			ps.params[i] = oid
+		}
+	}
+
+	if c.stmts == nil {
+		c.stmts = map[string]*pgStmt{}
+	}
+	c.stmts[name] = ps
+
+	return c.writeMessage('1', nil)
+}
+
+// bind binds the parameters of a Bind message to a statement, making the
+// portal named in it. It carries the names of the portal and statement,
+// the formats of the parameters, their values and the formats the columns
+// of the results are sent in.
+func (c *pgConn) bind(r *pgReader) error {
+	portal, name := r.string(), r.string()
+	formats := make([]int16, r.int16())
+	for i := range formats {
+		formats[i] = r.int16()
+	}
+	values := make([][]byte, r.int16())
+	for i := range values {
+		if n := r.int32(); n >= 0 {
+			values[i] = r.bytes(int(n))
+			if values[i] == nil {
+				values[i] = []byte{}
+			}
+		}
+	}
+	results := make([]int16, r.int16())
+	for i := range results {
+		results[i] = r.int16()
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	ps, ok := c.stmts[name]
+	if !ok {
+		return pgErrorf(pgErrUndefinedStmt, "Prepared statement %q does not exist", name)
+	}
+	if len(values) != len(ps.params) {
+		return pgErrorf(pgErrProtocol, "Bind gives %d parameters but the statement takes %d", len(values), len(ps.params))
+	}
+	if len(formats) > 1 && len(formats) != len(values) {
+		return pgErrorf(pgErrProtocol, "Bind gives %d parameter formats for %d parameters", len(formats), len(values))
+	}
+	if len(results) > 1 && len(results) != len(ps.columns) {
+		return pgErrorf(pgErrProtocol, "Bind gives %d result formats for %d columns", len(results), len(ps.columns))
+	}
+	for _, format := range append(append([]int16{}, formats...), results...) {
+		if format != pgFormatText && format != pgFormatBinary {
+			return pgErrorf(pgErrProtocol, "Unsupported format code %d", format)
+		}
+	}
+
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+
+		arg, err := pgParam(ps.params[i], resultFormat(formats, i), value)
+		if err != nil {
+			return err
+		}
+		args[i] = arg
+	}
+
+	if c.portals == nil {
+		c.portals = map[string]*pgPortal{}
+	}
+	c.portals[portal] = &pgPortal{stmt: ps, args: args, formats: results}
+
+	return c.writeMessage('2', nil)
+}
+
+// describe answers a Describe message, which names a statement or a
+// portal, with the types of the parameters of a statement and the columns
+// either returns.
+func (c *pgConn) describe(r *pgReader) error {
+	kind, name := r.bytes(1), r.string()
+	if r.err != nil {
+		return r.err
+	}
+
+	var ps *pgStmt
+	var formats []int16
+	switch kind[0] {
+	case 'S':
+		var ok bool
+		if ps, ok = c.stmts[name]; !ok {
+			return pgErrorf(pgErrUndefinedStmt, "Prepared statement %q does not exist", name)
+		}
+
+		payload := pgAppendInt16(nil, int16(len(ps.params)))
+		for _, oid := range ps.params {
+			payload = pgAppendInt32(payload, oid)
+		}
+		if err := c.writeMessage('t', payload); err != nil {
+			return err
+		}
+	case 'P':
+		p, ok := c.portals[name]
+		if !ok {
+			return pgErrorf(pgErrUndefinedPortal, "Portal %q does not exist", name)
+		}
+		ps, formats = p.stmt, p.formats
+	default:
+		return errMalformedMessage
+	}
+
+	if len(ps.columns) == 0 {
+		return c.writeMessage('n', nil)
+	}
+	return c.writeRowDescription(ps.columns, formats)
+}
+
+// execute runs the portal named in an Execute message, sending at most the
+// number of rows it gives unless that is 0. The rest are sent by the next
+// executions of the portal.
+func (c *pgConn) execute(session *sgsql.Conn, r *pgReader) error {
+	name, limit := r.string(), r.int32()
+	if r.err != nil {
+		return r.err
+	}
+
+	p, ok := c.portals[name]
+	if !ok {
+		return pgErrorf(pgErrUndefinedPortal, "Portal %q does not exist", name)
+	}
+	if p.stmt.parsed == nil {
+		return c.writeMessage('I', nil)
+	}
+	if p.stmt.parsed.Type == parser.CopyType {
+		tag, err := c.copy(session, p.stmt.parsed.CopyStatement)
+		c.ran(session, err)
+		if err != nil {
+			return err
+		}
+		return c.writeMessage('C', pgAppendString(nil, tag))
+	}
+
+	if p.results == nil {
+		results, err := p.stmt.stmt.Query(p.args...)
+		c.ran(session, err)
+		if err != nil {
+			return err
+		}
+		p.results = results
+	}
+
+	rows := p.results.Rows[p.sent:]
+	suspended := limit > 0 && len(rows) > int(limit)
+	if suspended {
+		rows = rows[:limit]
+	}
+	if err := c.writeRows(p.results.Columns, rows, p.formats); err != nil {
+		return err
+	}
+	p.sent += len(rows)
+
+	if suspended {
+		return c.writeMessage('s', nil)
+	}
+	return c.writeMessage('C', pgAppendString(nil, pgCommandTag(p.stmt.parsed, p.results)))
+}
+
+// close drops the statement or portal named in a Close message.
+func (c *pgConn) close(r *pgReader) error {
+	kind, name := r.bytes(1), r.string()
+	if r.err != nil {
+		return r.err
+	}
+
+	switch kind[0] {
+	case 'S':
+		delete(c.stmts, name)
+	case 'P':
+		delete(c.portals, name)
+	default:
+		return errMalformedMessage
+	}
+
+	return c.writeMessage('3', nil)
+}
+
+// pgParam decodes the value of a parameter of the type with oid sent in
+// format. Text is decoded for the types whose text the database doesn't
+// convert by itself, the rest is left as text. Timestamps, intervals and
+// uuids sent as text are converted where they are used.
+func pgParam(oid int32, format int16, value []byte) (interface{}, error) {
+	if format == pgFormatBinary {
+		return pgBinaryParam(oid, value)
+	}
+
+	s := string(value)
+	switch oid {
+	case pgOIDInt2, pgOIDInt4, pgOIDInt8, pgOIDOID:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, pgErrorf(pgErrInvalidText, "Invalid integer %q", s)
+		}
+		return n, nil
+	case pgOIDFloat4, pgOIDFloat8, pgOIDNumeric:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, pgErrorf(pgErrInvalidText, "Invalid number %q", s)
+		}
+		return f, nil
+	case pgOIDBool:
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "t", "true", "y", "yes", "on", "1":
+			return true, nil
+		case "f", "false", "n", "no", "off", "0":
+			return false, nil
+		}
+		return nil, pgErrorf(pgErrInvalidText, "Invalid bool %q", s)
+	case pgOIDTimestamp, pgOIDTimestamptz, pgOIDDate:
+		if t, err := types.ParseTimestamp(s); err == nil {
+			return t, nil
+		}
+	}
+
+	return s, nil
+}
+
+// pgBinarySizes are the sizes of the binary parameters of fixed size by
+// the OIDs of their types.
+var pgBinarySizes = map[int32]int{
+	pgOIDBool: 1, pgOIDInt2: 2, pgOIDInt4: 4, pgOIDOID: 4, pgOIDInt8: 8,
+	pgOIDFloat4: 4, pgOIDFloat8: 8, pgOIDTimestamp: 8, pgOIDTimestamptz: 8,
+	pgOIDDate: 4, pgOIDInterval: 16, pgOIDUUID: 16,
+}
+
+// pgBinaryParam decodes a parameter sent in the binary format of the type
+// with oid. Text is sent as it is in either format.
+func pgBinaryParam(oid int32, b []byte) (interface{}, error) {
+	if n, ok := pgBinarySizes[oid]; ok && len(b) != n {
+		return nil, pgErrorf(pgErrBinaryFormat, "Binary parameter of type %d is %d bytes, expected %d", oid, len(b), n)
+	}
+
+	switch oid {
+	case pgOIDBool:
+		return b[0] != 0, nil
+	case pgOIDInt2:
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case pgOIDInt4:
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case pgOIDOID:
+		return int64(binary.BigEndian.Uint32(b)), nil
+	case pgOIDInt8:
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case pgOIDFloat4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case pgOIDFloat8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case pgOIDTimestamp, pgOIDTimestamptz:
+		return pgEpoch.Add(time.Duration(int64(binary.BigEndian.Uint64(b))) * time.Microsecond), nil
+	case pgOIDDate:
+		return pgEpoch.AddDate(0, 0, int(int32(binary.BigEndian.Uint32(b)))), nil
+	case pgOIDInterval:
+		micros := int64(binary.BigEndian.Uint64(b))
+		days := int64(int32(binary.BigEndian.Uint32(b[8:])))
+		return types.IntervalValue{
+			Months:   int64(int32(binary.BigEndian.Uint32(b[12:]))),
+			Duration: time.Duration(micros)*time.Microsecond + time.Duration(days)*24*time.Hour,
+		}, nil
+	case pgOIDUUID:
+		var u types.UUIDValue
+		copy(u[:], b)
+		return u, nil
+	case pgOIDJSONB:
+		// A version byte precedes the text
+		if len(b) == 0 || b[0] != 1 {
+			return nil, pgErrorf(pgErrBinaryFormat, "Unsupported jsonb version")
+		}
+		return string(b[1:]), nil
+	case 0, pgOIDText, pgOIDVarchar, pgOIDBpchar, pgOIDName, pgOIDUnknown, pgOIDJSON, pgOIDBytea:
+		return string(b), nil
+	}
+
+	return nil, pgErrorf(pgErrNotSupported, "Binary parameters of type %d aren't supported", oid)
+}
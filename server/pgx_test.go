@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// pgxConnect serves s on a local port and connects to it with pgx.
+func pgxConnect(t *testing.T, s *PostgresServer) *pgx.Conn {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	conn, err := pgx.Connect(context.Background(), "postgres://sgsql@"+l.Addr().String()+"/test?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close(context.Background()) })
+
+	return conn
+}
+
+// pgxRows returns the rows of t as id and name.
+func pgxRows(t *testing.T, conn *pgx.Conn) [][]interface{} {
+	t.Helper()
+
+	rows, err := conn.Query(context.Background(), "select id, name from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	got := [][]interface{}{}
+	for rows.Next() {
+		var id int64
+		var name *string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+
+		row := []interface{}{id, nil}
+		if name != nil {
+			row[1] = *name
+		}
+		got = append(got, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func TestPgxCopyFrom(t *testing.T) {
+	conn := pgxConnect(t, testPostgresServer(t))
+
+	n, err := conn.CopyFrom(context.Background(), pgx.Identifier{"t"}, []string{"id", "name"},
+		pgx.CopyFromRows([][]interface{}{{int64(3), "c"}, {int64(4), nil}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("copied %d rows, want 2", n)
+	}
+
+	want := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}, {int64(3), "c"}, {int64(4), nil}}
+	if got := pgxRows(t, conn); !reflect.DeepEqual(got, want) {
+		t.Errorf("t holds %v, want %v", got, want)
+	}
+}
+
+func TestPgxCopyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		code  string
+	}{
+		// The client sends its data without waiting for the server, which
+		// drops what arrives once the COPY failed
+		{"missing table", "copy missing from stdin", pgErrUndefinedTable},
+		{"invalid binary data", "copy t from stdin binary", pgErrBinaryFormat},
+		{"binary copy to stdout", "copy t to stdout (format binary)", pgErrNotSupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := pgxConnect(t, testPostgresServer(t))
+
+			var err error
+			if strings.Contains(tt.query, "stdin") {
+				_, err = conn.PgConn().CopyFrom(context.Background(), strings.NewReader("3\tc\n"), tt.query)
+			} else {
+				_, err = conn.PgConn().CopyTo(context.Background(), &strings.Builder{}, tt.query)
+			}
+
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) || pgErr.Code != tt.code {
+				t.Fatalf("got %v, want an error with code %s", err, tt.code)
+			}
+
+			want := [][]interface{}{{int64(1), "a"}, {int64(2), "b"}}
+			if got := pgxRows(t, conn); !reflect.DeepEqual(got, want) {
+				t.Errorf("t holds %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPgxCastParams(t *testing.T) {
+	conn := pgxConnect(t, testPostgresServer(t))
+
+	var n int64
+	if err := conn.QueryRow(context.Background(), "select $1::int + 1", 41).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 42 {
+		t.Errorf("got %d, want 42", n)
+	}
+
+	err := conn.QueryRow(context.Background(), "select $1::int / 0", 1).Scan(&n)
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgErrDivisionByZero {
+		t.Errorf("got %v, want an error with code %s", err, pgErrDivisionByZero)
+	}
+}
@@ -0,0 +1,1079 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/analyzer"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+// Subset of version 3.0 of the Postgres frontend/backend protocol that the
+// frontend needs. See https://www.postgresql.org/docs/current/protocol.html
+const (
+	pgProtocolVersion = 196608
+	pgSSLRequest      = 80877103
+	pgGSSENCRequest   = 80877104
+	pgCancelRequest   = 80877102
+
+	// Startup packets and passwords are small, other messages may carry
+	// large parameters. Those are read as they arrive rather than into a
+	// buffer of the length the client claims
+	pgMaxStartup = 10000
+	pgMaxMessage = 1 << 30
+
+	pgMsgQuery     = 'Q'
+	pgMsgParse     = 'P'
+	pgMsgBind      = 'B'
+	pgMsgDescribe  = 'D'
+	pgMsgExecute   = 'E'
+	pgMsgSync      = 'S'
+	pgMsgFlush     = 'H'
+	pgMsgClose     = 'C'
+	pgMsgTerminate = 'X'
+	pgMsgPassword  = 'p'
+
+	pgAuthOK                = 0
+	pgAuthCleartextPassword = 3
+
+	pgFormatText   = 0
+	pgFormatBinary = 1
+
+	pgOIDBool           = 16
+	pgOIDBytea          = 17
+	pgOIDName           = 19
+	pgOIDInt8           = 20
+	pgOIDInt2           = 21
+	pgOIDInt4           = 23
+	pgOIDText           = 25
+	pgOIDOID            = 26
+	pgOIDJSON           = 114
+	pgOIDFloat4         = 700
+	pgOIDFloat8         = 701
+	pgOIDUnknown        = 705
+	pgOIDBoolArray      = 1000
+	pgOIDTextArray      = 1009
+	pgOIDInt8Array      = 1016
+	pgOIDFloat8Array    = 1022
+	pgOIDBpchar         = 1042
+	pgOIDVarchar        = 1043
+	pgOIDDate           = 1082
+	pgOIDTimestamp      = 1114
+	pgOIDTimestampArray = 1115
+	pgOIDTimestamptz    = 1184
+	pgOIDInterval       = 1186
+	pgOIDIntervalArray  = 1187
+	pgOIDNumeric        = 1700
+	pgOIDUUID           = 2950
+	pgOIDUUIDArray      = 2951
+	pgOIDJSONB          = 3802
+
+	pgErrProtocol         = "08P01"
+	pgErrSyntax           = "42601"
+	pgErrUndefinedTable   = "42P01"
+	pgErrUndefinedColumn  = "42703"
+	pgErrInvalidStatement = "42000"
+	pgErrPermissionDenied = "42501"
+	pgErrDuplicateStmt    = "42P05"
+	pgErrUndefinedStmt    = "26000"
+	pgErrUndefinedPortal  = "34000"
+	pgErrInvalidText      = "22P02"
+	pgErrDivisionByZero   = "22012"
+	pgErrOutOfRange       = "22003"
+	pgErrInvalidRegex     = "2201B"
+	pgErrUndefinedOp      = "42883"
+	pgErrBinaryFormat     = "22P03"
+	pgErrNotSupported     = "0A000"
+	pgErrTxAborted        = "25P02"
+	pgErrReadOnly         = "25006"
+	pgErrCanceled         = "57014"
+	pgErrInvalidPassword  = "28P01"
+	pgErrInvalidAuth      = "28000"
+	pgErrInternal         = "XX000"
+)
+
+// pgEpoch is where Postgres counts binary timestamps and dates from.
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// pgError is an error sent to the client with its SQLSTATE code, for the
+// errors of the protocol rather than the statements run.
+type pgError struct {
+	code string
+	msg  string
+}
+
+func (e *pgError) Error() string {
+	return e.msg
+}
+
+func pgErrorf(code, format string, args ...interface{}) error {
+	return &pgError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// pgErrorCode returns the SQLSTATE code err is sent with.
+func pgErrorCode(err error) string {
+	var pe *pgError
+	switch {
+	case errors.As(err, &pe):
+		return pe.code
+	case errors.Is(err, backend.ErrTableDoesNotExist):
+		return pgErrUndefinedTable
+	case errors.Is(err, backend.ErrColumnDoesNotExist):
+		return pgErrUndefinedColumn
+	case errors.As(err, new(*analyzer.Error)):
+		return pgErrInvalidStatement
+	case errors.Is(err, backend.ErrPermissionDenied):
+		return pgErrPermissionDenied
+	case errors.Is(err, sgsql.ErrTxAborted):
+		return pgErrTxAborted
+	case errors.Is(err, sgsql.ErrReadOnly):
+		return pgErrReadOnly
+	case errors.Is(err, sgsql.ErrKilled), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return pgErrCanceled
+	case errors.Is(err, types.ErrDivisionByZero):
+		return pgErrDivisionByZero
+	case errors.Is(err, types.ErrOutOfRange):
+		return pgErrOutOfRange
+	case errors.Is(err, types.ErrInvalidCast):
+		return pgErrInvalidText
+	case errors.Is(err, types.ErrInvalidPattern):
+		return pgErrInvalidRegex
+	case errors.Is(err, types.ErrInvalidOperands):
+		return pgErrUndefinedOp
+	}
+
+	return pgErrInternal
+}
+
+// PostgresServer speaks enough of the Postgres protocol for drivers like
+// pgx and JDBC to connect, run queries and prepare statements with the
+// extended protocol, binding parameters and reading results in text or
+// binary. Clients authenticate with a cleartext password checked against
+// the credentials set with SetCredentials, without any only as
+// functions.DefaultUser without a password. The password is only safe on a
+// trusted network unless TLS is set up with SetTLSConfig, which every
+// client must then use. Each connection runs in its own session, which is
+// sent the notifications of the channels it listens on once its queries and
+// transactions are done, and while it waits for the next query. COPY FROM
+// STDIN reads text, csv and the binary format bulk loaders like pgx's
+// CopyFrom send, COPY TO STDOUT writes text and csv.
+type PostgresServer struct {
+	db          *sgsql.DB
+	credentials *Credentials
+	tls         *tls.Config
+	conns       connSet
+
+	// mu guards secrets, which clients cancel the queries of the sessions
+	// with by their ids
+	mu      sync.Mutex
+	secrets map[int64]uint32
+}
+
+func NewPostgresServer(db *sgsql.DB) *PostgresServer {
+	return &PostgresServer{db: db, secrets: map[int64]uint32{}}
+}
+
+// SetCredentials sets the users clients may connect as. It must be called
+// before the server is started.
+func (s *PostgresServer) SetCredentials(c *Credentials) {
+	s.credentials = c
+}
+
+// SetTLSConfig makes the server accept the requests of clients for TLS
+// with config, and refuse clients that don't ask for it before they send
+// their password. It must be called before the server is started.
+func (s *PostgresServer) SetTLSConfig(config *tls.Config) {
+	s.tls = config
+}
+
+func (s *PostgresServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it fails or the server is shut
+// down, handling each connection in its own goroutine.
+func (s *PostgresServer) Serve(l net.Listener) error {
+	return s.conns.serve(l, "postgres", s.handleConn)
+}
+
+// Shutdown stops the server like MySQLServer.Shutdown does.
+func (s *PostgresServer) Shutdown(ctx context.Context) error {
+	return s.conns.shutdown(ctx)
+}
+
+type pgConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	// secure is set once the connection uses TLS
+	secure bool
+	// incoming are the messages read from the client once the session has
+	// started, see readMessages, and notifications those its session
+	// receives on the channels it listens on
+	incoming      chan pgIncoming
+	notifications <-chan sgsql.Notification
+	// stmts and portals are the statements the client prepared and the
+	// portals it bound them to, by their names
+	stmts   map[string]*pgStmt
+	portals map[string]*pgPortal
+	// failed is set once a statement fails in a transaction, until the
+	// transaction ends
+	failed bool
+	// skipping is set once a message of the extended protocol fails, the
+	// messages up to the next Sync are skipped then
+	skipping bool
+}
+
+func pgAppendInt16(b []byte, n int16) []byte {
+	return append(b, byte(n>>8), byte(n))
+}
+
+func pgAppendInt32(b []byte, n int32) []byte {
+	return append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func pgAppendInt64(b []byte, n int64) []byte {
+	return pgAppendInt32(pgAppendInt32(b, int32(n>>32)), int32(n))
+}
+
+func pgAppendString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+// pgReader reads the fields of a message. Reading past its end sets err
+// and returns zero values, so a message is checked once it is read.
+type pgReader struct {
+	b   []byte
+	err error
+}
+
+var errMalformedMessage = &pgError{code: pgErrProtocol, msg: "Malformed message"}
+
+func (r *pgReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.b) {
+		r.err = errMalformedMessage
+		return nil
+	}
+
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *pgReader) int16() int16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (r *pgReader) int32() int32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (r *pgReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+
+	for i, c := range r.b {
+		if c == 0 {
+			s := string(r.b[:i])
+			r.b = r.b[i+1:]
+			return s
+		}
+	}
+
+	r.err = errMalformedMessage
+	return ""
+}
+
+// readStartup reads a startup packet, which unlike other messages has no
+// type.
+func (c *pgConn) readStartup() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length < 8 || length > pgMaxStartup {
+		return nil, errMalformedMessage
+	}
+
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// readMessage reads a message of at most max bytes, returning its type and
+// what follows its length.
+func (c *pgConn) readMessage(max uint32) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < 4 || length > max {
+		return 0, nil, errMalformedMessage
+	}
+
+	if length <= pgMaxStartup {
+		payload := make([]byte, length-4)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return 0, nil, err
+		}
+		return header[0], payload, nil
+	}
+
+	// The buffer grows only as the payload actually arrives
+	var payload bytes.Buffer
+	if _, err := io.CopyN(&payload, c.r, int64(length-4)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	return header[0], payload.Bytes(), nil
+}
+
+func (c *pgConn) writeMessage(typ byte, payload []byte) error {
+	header := pgAppendInt32([]byte{typ}, int32(len(payload)+4))
+	if _, err := c.w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := c.w.Write(payload)
+	return err
+}
+
+func (c *pgConn) writeError(err error) error {
+	payload := pgAppendString([]byte{'S'}, "ERROR")
+	payload = pgAppendString(append(payload, 'V'), "ERROR")
+	payload = pgAppendString(append(payload, 'C'), pgErrorCode(err))
+	payload = pgAppendString(append(payload, 'M'), err.Error())
+
+	return c.writeMessage('E', append(payload, 0))
+}
+
+// pgIncoming is a message read from the client, or the error reading it.
+type pgIncoming struct {
+	typ     byte
+	payload []byte
+	err     error
+}
+
+// readMessages reads the messages of the client into c.incoming until
+// reading one fails or done is closed, so the server can send notifications
+// while it waits for the next one.
+func (c *pgConn) readMessages(done <-chan struct{}) {
+	for {
+		typ, payload, err := c.readMessage(pgMaxMessage)
+		select {
+		case c.incoming <- pgIncoming{typ, payload, err}:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeNotification sends a notification the session received.
+func (c *pgConn) writeNotification(n sgsql.Notification) error {
+	payload := pgAppendInt32(nil, int32(n.Session))
+	payload = pgAppendString(pgAppendString(payload, n.Channel), n.Payload)
+	return c.writeMessage('A', payload)
+}
+
+// writeReady tells the client the server waits for its next query, and
+// whether the session is in a transaction, or in one that failed. Outside
+// of a transaction the notifications received meanwhile are sent first.
+func (c *pgConn) writeReady(session *sgsql.Conn) error {
+	status := byte('I')
+	switch {
+	case !session.InTransaction():
+		c.failed = false
+		for pending := true; pending; {
+			select {
+			case n := <-c.notifications:
+				if err := c.writeNotification(n); err != nil {
+					return err
+				}
+			default:
+				pending = false
+			}
+		}
+	case c.failed:
+		status = 'E'
+	default:
+		status = 'T'
+	}
+
+	return c.writeMessage('Z', []byte{status})
+}
+
+// ran records that a statement ran in session, failing with err unless it
+// is nil.
+func (c *pgConn) ran(session *sgsql.Conn, err error) {
+	if err != nil && session.InTransaction() {
+		c.failed = true
+	}
+}
+
+// startup reads the startup packet of the client, answering requests for
+// TLS and to cancel a query. TLS is declined unless the server has a TLS
+// config, and is required then. It returns the parameters the client
+// started the session with, nil when there is no session to start.
+func (s *PostgresServer) startup(c *pgConn) (map[string]string, error) {
+	for {
+		packet, err := c.readStartup()
+		if err != nil {
+			return nil, err
+		}
+
+		r := pgReader{b: packet}
+		switch code := r.int32(); code {
+		case pgSSLRequest:
+			if s.tls == nil || c.secure {
+				if err := c.decline(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := c.startTLS(s.tls); err != nil {
+				return nil, err
+			}
+		case pgGSSENCRequest:
+			if err := c.decline(); err != nil {
+				return nil, err
+			}
+		case pgCancelRequest:
+			id, secret := r.int32(), r.int32()
+			if r.err == nil {
+				s.cancel(int64(id), uint32(secret))
+			}
+			return nil, nil
+		case pgProtocolVersion:
+			params := map[string]string{}
+			for r.err == nil && len(r.b) > 0 && r.b[0] != 0 {
+				key := r.string()
+				params[key] = r.string()
+			}
+			if r.err != nil {
+				return nil, r.err
+			}
+			if s.tls != nil && !c.secure {
+				err := pgErrorf(pgErrInvalidAuth, "Connections must use TLS")
+				c.writeError(err)
+				c.w.Flush()
+				return nil, err
+			}
+			return params, nil
+		default:
+			err := pgErrorf(pgErrNotSupported, "Unsupported protocol version %d.%d", code>>16, code&0xffff)
+			c.writeError(err)
+			c.w.Flush()
+			return nil, err
+		}
+	}
+}
+
+// decline answers a request for encryption that the server doesn't accept.
+func (c *pgConn) decline() error {
+	if _, err := c.w.Write([]byte{'N'}); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// startTLS accepts the request of the client for TLS and goes on over TLS
+// once the handshake is done.
+func (c *pgConn) startTLS(config *tls.Config) error {
+	// Anything sent after the request would have been sent in the clear,
+	// and could have been put there by someone else
+	if c.r.Buffered() > 0 {
+		return pgErrorf(pgErrProtocol, "Data received along with the request for TLS")
+	}
+
+	if _, err := c.w.Write([]byte{'S'}); err != nil {
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	conn := tls.Server(c.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	c.w = bufio.NewWriter(conn)
+	c.secure = true
+	return nil
+}
+
+// cancel cancels the query the session with id runs if secret is the one
+// it was started with.
+func (s *PostgresServer) cancel(id int64, secret uint32) {
+	s.mu.Lock()
+	known, ok := s.secrets[id]
+	s.mu.Unlock()
+	if !ok || known != secret {
+		return
+	}
+
+	session := s.db.Conn()
+	defer session.Close()
+	session.Exec("kill $1", id)
+}
+
+// authenticate asks the client for the password of user, unless there are
+// no credentials and only the default user connects without one, and
+// reports whether it is right.
+func (s *PostgresServer) authenticate(c *pgConn, user string) (bool, error) {
+	if s.credentials == nil {
+		return user == functions.DefaultUser, nil
+	}
+
+	if err := c.writeMessage('R', pgAppendInt32(nil, pgAuthCleartextPassword)); err != nil {
+		return false, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return false, err
+	}
+
+	typ, payload, err := c.readMessage(pgMaxStartup)
+	if err != nil {
+		return false, err
+	}
+	r := pgReader{b: payload}
+	password := r.string()
+	if typ != pgMsgPassword || r.err != nil {
+		return false, errMalformedMessage
+	}
+
+	return s.credentials.checkPassword(user, password), nil
+}
+
+// greet tells the client it is authenticated, the settings it may depend
+// on and the key it cancels queries with.
+func (s *PostgresServer) greet(c *pgConn, session *sgsql.Conn) error {
+	if err := c.writeMessage('R', pgAppendInt32(nil, pgAuthOK)); err != nil {
+		return err
+	}
+
+	for _, param := range [][2]string{
+		{"server_version", "14.0 (" + functions.Version + ")"},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+	} {
+		if err := c.writeMessage('S', pgAppendString(pgAppendString(nil, param[0]), param[1])); err != nil {
+			return err
+		}
+	}
+
+	var secret [4]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.secrets[session.ID()] = binary.BigEndian.Uint32(secret[:])
+	s.mu.Unlock()
+
+	payload := pgAppendInt32(nil, int32(session.ID()))
+	return c.writeMessage('K', append(payload, secret[:]...))
+}
+
+func (s *PostgresServer) handleConn(conn net.Conn) error {
+	defer conn.Close()
+
+	tracked := s.conns.add(conn)
+	defer s.conns.remove(tracked)
+
+	c := &pgConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+
+	if !s.conns.waitCommand(tracked, false) {
+		return nil
+	}
+	params, err := s.startup(c)
+	if err != nil || params == nil {
+		return err
+	}
+	s.conns.runCommand(tracked)
+
+	user := params["user"]
+	if user == "" {
+		user = functions.DefaultUser
+	}
+	ok, err := s.authenticate(c, user)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := c.writeError(pgErrorf(pgErrInvalidPassword, "Password authentication failed for user %q", user)); err != nil {
+			return err
+		}
+		return c.w.Flush()
+	}
+
+	session := s.db.Conn()
+	defer session.Close()
+	session.SetUser(user)
+	defer func() {
+		s.mu.Lock()
+		delete(s.secrets, session.ID())
+		s.mu.Unlock()
+	}()
+
+	if err := s.greet(c, session); err != nil {
+		return err
+	}
+	c.notifications = session.Notifications()
+	if err := c.writeReady(session); err != nil {
+		return err
+	}
+
+	c.incoming = make(chan pgIncoming)
+	done := make(chan struct{})
+	defer close(done)
+	go c.readMessages(done)
+
+	for {
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		if !s.conns.waitCommand(tracked, session.InTransaction()) {
+			return nil
+		}
+
+		// Notifications wait for the transaction to end, like those sent
+		// in it do
+		notifications := c.notifications
+		if session.InTransaction() {
+			notifications = nil
+		}
+
+		var msg pgIncoming
+		select {
+		case msg = <-c.incoming:
+		case n := <-notifications:
+			if err := c.writeNotification(n); err != nil {
+				return err
+			}
+			continue
+		}
+		typ, payload, err := msg.typ, msg.payload, msg.err
+		if err != nil {
+			return err
+		}
+		s.conns.runCommand(tracked)
+
+		if typ == pgMsgTerminate {
+			return nil
+		}
+		if c.skipping && typ != pgMsgSync {
+			continue
+		}
+
+		switch typ {
+		case pgMsgQuery:
+			r := pgReader{b: payload}
+			query := r.string()
+			if r.err != nil {
+				return r.err
+			}
+			err = c.simpleQuery(session, query)
+		case pgMsgSync:
+			c.skipping = false
+			err = c.writeReady(session)
+		case pgMsgFlush:
+		case pgMsgCopyData, pgMsgCopyDone, pgMsgCopyFail:
+			// What a client sends after a COPY FROM STDIN failed is
+			// dropped, like the protocol asks outside of copying
+		default:
+			err = c.extended(session, typ, payload)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// simpleQuery runs every statement of query, sending the results of each.
+func (c *pgConn) simpleQuery(session *sgsql.Conn, query string) error {
+	ast, err := parser.Parse(query)
+	if err != nil {
+		if err := c.writeError(&pgError{code: pgErrSyntax, msg: err.Error()}); err != nil {
+			return err
+		}
+		return c.writeReady(session)
+	}
+
+	if len(ast.Statements) == 0 {
+		if err := c.writeMessage('I', nil); err != nil {
+			return err
+		}
+		return c.writeReady(session)
+	}
+
+	for _, stmt := range ast.Statements {
+		if stmt.Type == parser.CopyType {
+			tag, err := c.copy(session, stmt.CopyStatement)
+			c.ran(session, err)
+			if err != nil {
+				if err := c.writeError(err); err != nil {
+					return err
+				}
+				break
+			}
+			if err := c.writeMessage('C', pgAppendString(nil, tag)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		results, err := session.Query(stmt.Text)
+		c.ran(session, err)
+		if err != nil {
+			if err := c.writeError(err); err != nil {
+				return err
+			}
+			break
+		}
+
+		if len(results.Columns) > 0 {
+			if err := c.writeRowDescription(results.Columns, nil); err != nil {
+				return err
+			}
+		}
+		if err := c.writeRows(results.Columns, results.Rows, nil); err != nil {
+			return err
+		}
+		if err := c.writeMessage('C', pgAppendString(nil, pgCommandTag(stmt, results))); err != nil {
+			return err
+		}
+	}
+
+	return c.writeReady(session)
+}
+
+// pgCommandTags are the tags of the statements that don't return rows and
+// aren't named by their first word alone.
+var pgCommandTags = map[parser.ASTType]string{
+	parser.CreateTableType:            "CREATE TABLE",
+	parser.DropTableType:              "DROP TABLE",
+	parser.AlterTableType:             "ALTER TABLE",
+	parser.CreateIndexType:            "CREATE INDEX",
+	parser.DropIndexType:              "DROP INDEX",
+	parser.CreateSequenceType:         "CREATE SEQUENCE",
+	parser.CreateMaterializedViewType: "CREATE MATERIALIZED VIEW",
+	parser.RefreshType:                "REFRESH MATERIALIZED VIEW",
+	parser.CreatePolicyType:           "CREATE POLICY",
+	parser.DropPolicyType:             "DROP POLICY",
+	parser.CreateRoleType:             "CREATE ROLE",
+	parser.DropRoleType:               "DROP ROLE",
+	parser.CreateStatisticsType:       "CREATE STATISTICS",
+	parser.DropStatisticsType:         "DROP STATISTICS",
+	parser.DeclareCursorType:          "DECLARE CURSOR",
+	parser.CloseType:                  "CLOSE CURSOR",
+	parser.PrepareTransactionType:     "PREPARE TRANSACTION",
+	parser.CommitPreparedType:         "COMMIT PREPARED",
+	parser.RollbackPreparedType:       "ROLLBACK PREPARED",
+	parser.DiscardType:                "DISCARD ALL",
+	parser.ReloadConfigType:           "RELOAD CONFIG",
+}
+
+// pgCommandTag returns the tag completing stmt, which returned results:
+// the number of rows a query returned and inserted, or what it did.
+func pgCommandTag(stmt *parser.Statement, results *backend.Results) string {
+	switch {
+	case stmt.Type == parser.InsertType:
+		return "INSERT 0 1"
+	case stmt.Type == parser.FetchType:
+		return "FETCH " + strconv.Itoa(len(results.Rows))
+	case len(results.Columns) > 0:
+		return "SELECT " + strconv.Itoa(len(results.Rows))
+	}
+
+	if tag, ok := pgCommandTags[stmt.Type]; ok {
+		return tag
+	}
+
+	first := stmt.Text
+	for i, r := range stmt.Text {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '(' {
+			first = stmt.Text[:i]
+			break
+		}
+	}
+	return upper(first)
+}
+
+func upper(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	return string(b)
+}
+
+// pgColumnOID returns the OID of the type columns of type t are described
+// with.
+func pgColumnOID(t backend.ColumnType) int32 {
+	if t.IsArray() {
+		switch t.Elem() {
+		case backend.IntType:
+			return pgOIDInt8Array
+		case backend.BoolType:
+			return pgOIDBoolArray
+		case backend.FloatType:
+			return pgOIDFloat8Array
+		case backend.TimestampType:
+			return pgOIDTimestampArray
+		case backend.IntervalType:
+			return pgOIDIntervalArray
+		case backend.UUIDType:
+			return pgOIDUUIDArray
+		}
+		return pgOIDTextArray
+	}
+
+	switch t {
+	case backend.IntType:
+		return pgOIDInt8
+	case backend.BoolType:
+		return pgOIDBool
+	case backend.FloatType:
+		return pgOIDFloat8
+	case backend.TimestampType:
+		return pgOIDTimestamp
+	case backend.IntervalType:
+		return pgOIDInterval
+	case backend.UUIDType:
+		return pgOIDUUID
+	}
+
+	return pgOIDText
+}
+
+// pgTypeSize returns the size of the values of the type with oid, -1 for
+// those of varying sizes.
+func pgTypeSize(oid int32) int16 {
+	switch oid {
+	case pgOIDBool:
+		return 1
+	case pgOIDInt8, pgOIDFloat8, pgOIDTimestamp:
+		return 8
+	case pgOIDInterval, pgOIDUUID:
+		return 16
+	}
+
+	return -1
+}
+
+// resultFormat returns the format column i is sent in, formats holding
+// either one format for every column or one for each.
+func resultFormat(formats []int16, i int) int16 {
+	switch len(formats) {
+	case 0:
+		return pgFormatText
+	case 1:
+		return formats[0]
+	}
+
+	return formats[i]
+}
+
+func (c *pgConn) writeRowDescription(columns []backend.ResultColumn, formats []int16) error {
+	payload := pgAppendInt16(nil, int16(len(columns)))
+	for i, col := range columns {
+		oid := pgColumnOID(col.Type)
+		payload = pgAppendString(payload, col.Name)
+		payload = pgAppendInt32(payload, 0) // table
+		payload = pgAppendInt16(payload, 0) // column of the table
+		payload = pgAppendInt32(payload, oid)
+		payload = pgAppendInt16(payload, pgTypeSize(oid))
+		payload = pgAppendInt32(payload, -1) // type modifier
+		payload = pgAppendInt16(payload, resultFormat(formats, i))
+	}
+
+	return c.writeMessage('T', payload)
+}
+
+func (c *pgConn) writeRows(columns []backend.ResultColumn, rows [][]interface{}, formats []int16) error {
+	for _, row := range rows {
+		payload := pgAppendInt16(nil, int16(len(row)))
+		for i, v := range row {
+			if v == nil {
+				payload = pgAppendInt32(payload, -1)
+				continue
+			}
+
+			var value []byte
+			if resultFormat(formats, i) == pgFormatBinary {
+				value = pgBinaryValue(columns[i].Type, v)
+			} else {
+				value = []byte(pgTextValue(v))
+			}
+			payload = append(pgAppendInt32(payload, int32(len(value))), value...)
+		}
+
+		if err := c.writeMessage('D', payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func pgTextValue(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "t"
+		}
+		return "f"
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		case math.IsNaN(v):
+			return "NaN"
+		}
+	}
+
+	return mysqlTextValue(v)
+}
+
+// pgBinaryValue returns v, which isn't NULL, in the binary format of the
+// type of its column t. A value of another type than its column, like one
+// computed from a placeholder, is sent as text, which only columns of text
+// are described as.
+func pgBinaryValue(t backend.ColumnType, v interface{}) []byte {
+	if t.IsArray() {
+		if a, ok := v.(types.Array); ok {
+			return pgBinaryArray(t.Elem(), a)
+		}
+	}
+
+	switch v := v.(type) {
+	case int64:
+		switch t {
+		case backend.IntType:
+			return pgAppendInt64(nil, v)
+		case backend.FloatType:
+			return pgAppendInt64(nil, int64(math.Float64bits(float64(v))))
+		}
+	case float64:
+		if t == backend.FloatType {
+			return pgAppendInt64(nil, int64(math.Float64bits(v)))
+		}
+	case bool:
+		if t == backend.BoolType {
+			if v {
+				return []byte{1}
+			}
+			return []byte{0}
+		}
+	case time.Time:
+		if t == backend.TimestampType {
+			return pgAppendInt64(nil, v.Sub(pgEpoch).Microseconds())
+		}
+	case types.IntervalValue:
+		if t == backend.IntervalType {
+			payload := pgAppendInt64(nil, v.Duration.Microseconds())
+			payload = pgAppendInt32(payload, 0) // days, which are in the microseconds
+			return pgAppendInt32(payload, int32(v.Months))
+		}
+	case types.UUIDValue:
+		if t == backend.UUIDType {
+			return v[:]
+		}
+	}
+
+	return []byte(pgTextValue(v))
+}
+
+// pgBinaryArray returns a in the binary format of arrays: its dimensions,
+// whether it holds NULLs and the type of its elements, then the length and
+// lower bound of its one dimension and each element with its length.
+func pgBinaryArray(elem backend.ColumnType, a types.Array) []byte {
+	if len(a.Values) == 0 {
+		payload := pgAppendInt32(nil, 0)
+		payload = pgAppendInt32(payload, 0)
+		return pgAppendInt32(payload, pgColumnOID(elem))
+	}
+
+	nulls := int32(0)
+	for _, v := range a.Values {
+		if v == nil {
+			nulls = 1
+		}
+	}
+
+	payload := pgAppendInt32(nil, 1)
+	payload = pgAppendInt32(payload, nulls)
+	payload = pgAppendInt32(payload, pgColumnOID(elem))
+	payload = pgAppendInt32(payload, int32(len(a.Values)))
+	payload = pgAppendInt32(payload, 1)
+	for _, v := range a.Values {
+		if v == nil {
+			payload = pgAppendInt32(payload, -1)
+			continue
+		}
+
+		value := pgBinaryValue(elem, v)
+		payload = append(pgAppendInt32(payload, int32(len(value))), value...)
+	}
+
+	return payload
+}
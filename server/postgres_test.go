@@ -0,0 +1,822 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql"
+)
+
+// pgMessage is a message the server sent.
+type pgMessage struct {
+	typ     byte
+	payload []byte
+}
+
+// pgStartup connects to s as user, answering a request for a password with
+// password, and returns the connection and the messages up to the first
+// ReadyForQuery or error.
+func pgStartup(t *testing.T, s *PostgresServer, user, password string) (*pgConn, []pgMessage) {
+	t.Helper()
+
+	client, conn := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go s.handleConn(conn)
+
+	c := &pgConn{conn: client, r: bufio.NewReader(client), w: bufio.NewWriter(client)}
+
+	// Clients ask for TLS first, which is declined
+	c.w.Write(pgAppendInt32(pgAppendInt32(nil, 8), pgSSLRequest))
+	if err := c.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.r.ReadByte(); err != nil || b != 'N' {
+		t.Fatalf("TLS request answered with %q, %v", b, err)
+	}
+
+	packet := pgAppendInt32(nil, pgProtocolVersion)
+	packet = pgAppendString(pgAppendString(packet, "user"), user)
+	packet = pgAppendString(pgAppendString(packet, "database"), "test")
+	packet = append(packet, 0)
+	c.w.Write(pgAppendInt32(nil, int32(len(packet)+4)))
+	c.w.Write(packet)
+	if err := c.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []pgMessage
+	for {
+		msg := pgRead(t, c)
+		if msg.typ == 'R' && binary.BigEndian.Uint32(msg.payload) == pgAuthCleartextPassword {
+			pgSend(t, c, pgMsgPassword, pgAppendString(nil, password))
+			continue
+		}
+
+		msgs = append(msgs, msg)
+		if msg.typ == 'Z' || msg.typ == 'E' {
+			return c, msgs
+		}
+	}
+}
+
+func pgRead(t *testing.T, c *pgConn) pgMessage {
+	t.Helper()
+
+	typ, payload, err := c.readMessage(pgMaxMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pgMessage{typ, payload}
+}
+
+func pgSend(t *testing.T, c *pgConn, typ byte, payload []byte) {
+	t.Helper()
+
+	if err := c.writeMessage(typ, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pgUntilReady reads the messages the server sends up to ReadyForQuery,
+// which is left out.
+func pgUntilReady(t *testing.T, c *pgConn) ([]pgMessage, byte) {
+	t.Helper()
+
+	var msgs []pgMessage
+	for {
+		msg := pgRead(t, c)
+		if msg.typ == 'Z' {
+			return msgs, msg.payload[0]
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// pgSummary describes msgs by their types, with the values of data rows,
+// the tags of completed commands and the codes of errors.
+func pgSummary(msgs []pgMessage) []string {
+	var summary []string
+	for _, msg := range msgs {
+		s := string(msg.typ)
+		switch msg.typ {
+		case 'D':
+			r := pgReader{b: msg.payload}
+			values := []string{}
+			for n := r.int16(); n > 0; n-- {
+				if size := r.int32(); size < 0 {
+					values = append(values, "NULL")
+				} else {
+					values = append(values, string(r.bytes(int(size))))
+				}
+			}
+			s += " " + strings.Join(values, ",")
+		case 'C':
+			s += " " + strings.TrimSuffix(string(msg.payload), "\x00")
+		case 'd':
+			s += " " + strings.TrimSuffix(string(msg.payload), "\n")
+		case 'A':
+			r := pgReader{b: msg.payload}
+			r.int32()
+			s += " " + r.string() + "," + r.string()
+		case 'E':
+			for _, field := range strings.Split(string(msg.payload), "\x00") {
+				if strings.HasPrefix(field, "C") {
+					s += " " + field[1:]
+				}
+			}
+		}
+		summary = append(summary, s)
+	}
+
+	return summary
+}
+
+func testPostgresServer(t *testing.T) *PostgresServer {
+	t.Helper()
+
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, query := range []string{"create table t (id int, name text)", "insert into t values (1, 'a')", "insert into t values (2, 'b')"} {
+		if err := db.Exec(query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	return NewPostgresServer(db)
+}
+
+func TestPostgresAuthentication(t *testing.T) {
+	credentials, err := ReadCredentials(strings.NewReader("alice " + HashPassword("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		credentials *Credentials
+		user        string
+		password    string
+		// last is the type of the last message of the startup, Z once the
+		// client is in and E when it isn't
+		last byte
+	}{
+		{"default user", nil, "sgsql", "", 'Z'},
+		{"other user", nil, "alice", "", 'E'},
+		{"password", credentials, "alice", "secret", 'Z'},
+		{"wrong password", credentials, "alice", "guess", 'E'},
+		{"unknown user", credentials, "bob", "secret", 'E'},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testPostgresServer(t)
+			s.SetCredentials(tt.credentials)
+
+			_, msgs := pgStartup(t, s, tt.user, tt.password)
+			if last := msgs[len(msgs)-1].typ; last != tt.last {
+				t.Errorf("startup ended with %q, want %q: %v", last, tt.last, pgSummary(msgs))
+			}
+			if tt.last == 'Z' && msgs[0].typ != 'R' {
+				t.Errorf("startup began with %q, want an AuthenticationOk", msgs[0].typ)
+			}
+		})
+	}
+}
+
+func TestPostgresReadMessage(t *testing.T) {
+	large := strings.Repeat("x", 100000)
+	tests := []struct {
+		name    string
+		length  uint32
+		payload string
+		max     uint32
+		err     error
+	}{
+		{"small", 9, "hello", pgMaxStartup, nil},
+		{"large", uint32(len(large)) + 4, large, pgMaxMessage, nil},
+		{"password too long", pgMaxStartup + 1, "secret", pgMaxStartup, errMalformedMessage},
+		{"too short", 3, "", pgMaxMessage, errMalformedMessage},
+		{"cut off", 1 << 29, "hello", pgMaxMessage, io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := append([]byte{pgMsgPassword}, pgAppendInt32(nil, int32(tt.length))...)
+			c := &pgConn{r: bufio.NewReader(bytes.NewReader(append(msg, tt.payload...)))}
+
+			// What a client claims to send isn't allocated before it arrives
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			typ, payload, err := c.readMessage(tt.max)
+			runtime.ReadMemStats(&after)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+				t.Errorf("allocated %d bytes", allocated)
+			}
+			if err == nil && (typ != pgMsgPassword || string(payload) != tt.payload) {
+				t.Errorf("got %q with %d bytes", typ, len(payload))
+			}
+		})
+	}
+}
+
+// testCertificate returns a self-signed certificate for localhost.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestPostgresTLS(t *testing.T) {
+	credentials, err := ReadCredentials(strings.NewReader("alice " + HashPassword("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := testCertificate(t)
+	roots := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots.AddCert(leaf)
+
+	for _, secure := range []bool{true, false} {
+		s := testPostgresServer(t)
+		s.SetCredentials(credentials)
+		s.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}})
+
+		client, conn := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go s.handleConn(conn)
+
+		c := &pgConn{conn: client, r: bufio.NewReader(client), w: bufio.NewWriter(client)}
+		if secure {
+			c.w.Write(pgAppendInt32(pgAppendInt32(nil, 8), pgSSLRequest))
+			if err := c.w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := c.r.ReadByte(); err != nil || b != 'S' {
+				t.Fatalf("TLS request answered with %q, %v", b, err)
+			}
+
+			tlsClient := tls.Client(client, &tls.Config{ServerName: "localhost", RootCAs: roots})
+			if err := tlsClient.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+			c = &pgConn{conn: tlsClient, r: bufio.NewReader(tlsClient), w: bufio.NewWriter(tlsClient)}
+		}
+
+		packet := pgAppendInt32(nil, pgProtocolVersion)
+		packet = append(pgAppendString(pgAppendString(packet, "user"), "alice"), 0)
+		c.w.Write(pgAppendInt32(nil, int32(len(packet)+4)))
+		c.w.Write(packet)
+		if err := c.w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		msg := pgRead(t, c)
+		if !secure {
+			if got := pgSummary([]pgMessage{msg}); !reflect.DeepEqual(got, []string{"E 28000"}) {
+				t.Errorf("startup without TLS got %v, want it refused", got)
+			}
+			continue
+		}
+
+		if msg.typ != 'R' || binary.BigEndian.Uint32(msg.payload) != pgAuthCleartextPassword {
+			t.Fatalf("got %q, want a request for the password", msg.typ)
+		}
+		pgSend(t, c, pgMsgPassword, pgAppendString(nil, "secret"))
+		if msgs, _ := pgUntilReady(t, c); msgs[0].typ != 'R' {
+			t.Errorf("startup over TLS got %v, want an AuthenticationOk", pgSummary(msgs))
+		}
+
+		pgSend(t, c, pgMsgQuery, pgAppendString(nil, "select name from t where id = 1"))
+		msgs, _ := pgUntilReady(t, c)
+		if got := pgSummary(msgs); !reflect.DeepEqual(got, []string{"T", "D a", "C SELECT 1"}) {
+			t.Errorf("query over TLS got %v", got)
+		}
+	}
+}
+
+func TestPostgresSimpleQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+		// status is the transaction status of ReadyForQuery
+		status byte
+	}{
+		{"select id, name from t", []string{"T", "D 1,a", "D 2,b", "C SELECT 2"}, 'I'},
+		{"select 1 = 1, 1.5, null", []string{"T", "D t,1.5,NULL", "C SELECT 1"}, 'I'},
+		{"insert into t values (3, 'c'); select count(*) from t", []string{"C INSERT 0 1", "T", "D 3", "C SELECT 1"}, 'I'},
+		{"create table u (a int); drop table u", []string{"C CREATE TABLE", "C DROP TABLE"}, 'I'},
+		{"", []string{"I"}, 'I'},
+		{"select from", []string{"E 42601"}, 'I'},
+		{"select * from missing; select 1", []string{"E 42P01"}, 'I'},
+		{"select missing from t", []string{"E 42703"}, 'I'},
+		{"select 1 / 0", []string{"E 22012"}, 'I'},
+		{"select 9223372036854775807 + id from t", []string{"E 22003"}, 'I'},
+		{"select 'x'::int", []string{"E 22P02"}, 'I'},
+		{"select name ~ '(' from t", []string{"E 2201B"}, 'I'},
+		{"begin", []string{"C BEGIN"}, 'T'},
+		{"begin; select * from missing", []string{"C BEGIN", "E 42P01"}, 'E'},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, _ := pgStartup(t, testPostgresServer(t), "sgsql", "")
+
+			pgSend(t, c, pgMsgQuery, pgAppendString(nil, tt.query))
+			msgs, status := pgUntilReady(t, c)
+			if got := pgSummary(msgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if status != tt.status {
+				t.Errorf("transaction status is %q, want %q", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestPostgresNotifications(t *testing.T) {
+	s := testPostgresServer(t)
+	c, _ := pgStartup(t, s, "sgsql", "")
+
+	// Notifications sent by the session itself come before ReadyForQuery
+	pgSend(t, c, pgMsgQuery, pgAppendString(nil, "listen news; notify news, 'own'"))
+	msgs, _ := pgUntilReady(t, c)
+	if got, want := pgSummary(msgs), []string{"C LISTEN", "C NOTIFY", "A news,own"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Those of other sessions reach it while it is idle
+	if err := s.db.Exec("notify news, 'other'"); err != nil {
+		t.Fatal(err)
+	}
+	if got := pgSummary([]pgMessage{pgRead(t, c)}); !reflect.DeepEqual(got, []string{"A news,other"}) {
+		t.Errorf("idle session got %v, want the notification", got)
+	}
+
+	// Those sent in a transaction wait for it to commit
+	pgSend(t, c, pgMsgQuery, pgAppendString(nil, "begin; notify news, 'later'"))
+	msgs, _ = pgUntilReady(t, c)
+	if got, want := pgSummary(msgs), []string{"C BEGIN", "C NOTIFY"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("in a transaction got %v, want %v", got, want)
+	}
+	pgSend(t, c, pgMsgQuery, pgAppendString(nil, "commit"))
+	msgs, _ = pgUntilReady(t, c)
+	if got, want := pgSummary(msgs), []string{"C COMMIT", "A news,later"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after the transaction got %v, want %v", got, want)
+	}
+}
+
+func TestPostgresCopyOut(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"copy t to stdout", []string{"H", "d 1\ta", "d 2\tb", "c", "C COPY 2"}},
+		{"copy t (name) to stdout", []string{"H", "d a", "d b", "c", "C COPY 2"}},
+		{"copy (select id, name from t where id = 2) to stdout with (format csv, header)", []string{"H", "d id,name", "d 2,b", "c", "C COPY 1"}},
+		{"copy (select null, '', 'a,\"b', 'c\td') to stdout csv", []string{"H", `d ,"","a,""b",c` + "\td", "c", "C COPY 1"}},
+		{"copy (select null, 'a\\b', 'c\td') to stdout", []string{"H", `d \N	a\\b	c\td`, "c", "C COPY 1"}},
+		{"copy missing to stdout", []string{"E 42P01"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, _ := pgStartup(t, testPostgresServer(t), "sgsql", "")
+
+			pgSend(t, c, pgMsgQuery, pgAppendString(nil, tt.query))
+			msgs, _ := pgUntilReady(t, c)
+			if got := pgSummary(msgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostgresCopyIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		// data are the payloads of the CopyData messages, the last one is
+		// sent as CopyFail when fail is set
+		data []string
+		fail bool
+		want []string
+		rows []string
+	}{
+		{
+			"text",
+			"copy t from stdin",
+			[]string{"3\tc\n4\t\\N\n5\tx", "\\ty\n"},
+			false,
+			[]string{"C COPY 3"},
+			[]string{"D 1,a", "D 2,b", "D 3,c", "D 4,NULL", "D 5,x\ty"},
+		},
+		{
+			"end marker",
+			"copy t from stdin",
+			[]string{"3\tc\n\\.\n"},
+			false,
+			[]string{"C COPY 1"},
+			[]string{"D 1,a", "D 2,b", "D 3,c"},
+		},
+		{
+			"csv with columns",
+			"copy t (name, id) from stdin with (format csv, header true)",
+			[]string{"name,id\n\"q,\"\"1\"\"\",6\n,7\n\"\",8\n\"two\nlines\",9"},
+			false,
+			[]string{"C COPY 4"},
+			[]string{"D 1,a", "D 2,b", "D 6,q,\"1\"", "D 7,NULL", "D 8,", "D 9,two\nlines"},
+		},
+		{
+			"invalid row",
+			"copy t from stdin",
+			[]string{"3\tc\nfour\td\n", "5\te\n"},
+			false,
+			[]string{"E 22P02"},
+			[]string{"D 1,a", "D 2,b"},
+		},
+		{
+			"missing field",
+			"copy t from stdin",
+			[]string{"3\n"},
+			false,
+			[]string{"E XX000"},
+			[]string{"D 1,a", "D 2,b"},
+		},
+		{
+			"failed by the client",
+			"copy t from stdin",
+			[]string{"3\tc\n", "gave up"},
+			true,
+			[]string{"E 57014"},
+			[]string{"D 1,a", "D 2,b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := pgStartup(t, testPostgresServer(t), "sgsql", "")
+
+			pgSend(t, c, pgMsgQuery, pgAppendString(nil, tt.query))
+			if msg := pgRead(t, c); msg.typ != 'G' {
+				t.Fatalf("got %v, want CopyInResponse", pgSummary([]pgMessage{msg}))
+			}
+			for i, data := range tt.data {
+				if tt.fail && i == len(tt.data)-1 {
+					c.writeMessage(pgMsgCopyFail, pgAppendString(nil, data))
+					continue
+				}
+				c.writeMessage(pgMsgCopyData, []byte(data))
+			}
+			if !tt.fail {
+				c.writeMessage(pgMsgCopyDone, nil)
+			}
+			if err := c.w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			msgs, status := pgUntilReady(t, c)
+			if got := pgSummary(msgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if status != 'I' {
+				t.Errorf("transaction status is %q, want I", status)
+			}
+
+			pgSend(t, c, pgMsgQuery, pgAppendString(nil, "select id, name from t"))
+			msgs, _ = pgUntilReady(t, c)
+			if got := pgSummary(msgs)[1 : len(msgs)-1]; !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("t holds %q, want %q", got, tt.rows)
+			}
+		})
+	}
+}
+
+func TestPostgresCopyInLog(t *testing.T) {
+	tests := []struct {
+		name string
+		rows int
+		// entries are those of the log after creating t and copying
+		entries int
+	}{
+		{"one batch", 5, 2},
+		{"two batches", copyBatch + 1, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			db, err := sgsql.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Exec("create table t (id int generated always as identity, name text)"); err != nil {
+				t.Fatal(err)
+			}
+
+			var data strings.Builder
+			for i := 0; i < tt.rows; i++ {
+				fmt.Fprintf(&data, "row %d\n", i)
+			}
+			c, _ := pgStartup(t, NewPostgresServer(db), "sgsql", "")
+			pgSend(t, c, pgMsgQuery, pgAppendString(nil, "copy t (name) from stdin"))
+			if msg := pgRead(t, c); msg.typ != 'G' {
+				t.Fatalf("got %v, want CopyInResponse", pgSummary([]pgMessage{msg}))
+			}
+			c.writeMessage(pgMsgCopyData, []byte(data.String()))
+			pgSend(t, c, pgMsgCopyDone, nil)
+			msgs, _ := pgUntilReady(t, c)
+			if got, want := pgSummary(msgs), []string{fmt.Sprintf("C COPY %d", tt.rows)}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+			db.Close()
+
+			db, err = sgsql.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if h := db.Health(); h.Replayed != tt.entries {
+				t.Errorf("the log has %d entries, want %d", h.Replayed, tt.entries)
+			}
+			results, err := db.Query("select count(*), max(id) from t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []interface{}{int64(tt.rows), int64(tt.rows)}; !reflect.DeepEqual(results.Rows[0], want) {
+				t.Errorf("t has count and max id %v, want %v", results.Rows[0], want)
+			}
+		})
+	}
+}
+
+// pgParse returns the payload of a Parse message.
+func pgParse(name, query string, oids ...int32) []byte {
+	payload := pgAppendString(pgAppendString(nil, name), query)
+	payload = pgAppendInt16(payload, int16(len(oids)))
+	for _, oid := range oids {
+		payload = pgAppendInt32(payload, oid)
+	}
+	return payload
+}
+
+// pgBind returns the payload of a Bind message with the parameters values
+// in format and the results in results.
+func pgBind(portal, stmt string, format int16, values [][]byte, results int16) []byte {
+	payload := pgAppendString(pgAppendString(nil, portal), stmt)
+	payload = pgAppendInt16(pgAppendInt16(payload, 1), format)
+	payload = pgAppendInt16(payload, int16(len(values)))
+	for _, v := range values {
+		if v == nil {
+			payload = pgAppendInt32(payload, -1)
+			continue
+		}
+		payload = append(pgAppendInt32(payload, int32(len(v))), v...)
+	}
+	return pgAppendInt16(pgAppendInt16(payload, 1), results)
+}
+
+func pgDescribe(kind byte, name string) []byte {
+	return pgAppendString([]byte{kind}, name)
+}
+
+func pgExecute(portal string, limit int32) []byte {
+	return pgAppendInt32(pgAppendString(nil, portal), limit)
+}
+
+func TestPostgresExtendedQuery(t *testing.T) {
+	type message struct {
+		typ     byte
+		payload []byte
+	}
+	tests := []struct {
+		name     string
+		messages []message
+		want     []string
+	}{
+		{
+			"text parameters",
+			[]message{
+				{pgMsgParse, pgParse("", "select name from t where id = $1")},
+				{pgMsgBind, pgBind("", "", pgFormatText, [][]byte{[]byte("2")}, pgFormatText)},
+				{pgMsgExecute, pgExecute("", 0)},
+			},
+			[]string{"1", "2", "D b", "C SELECT 1"},
+		},
+		{
+			"binary parameters and results",
+			[]message{
+				{pgMsgParse, pgParse("s", "select id from t where id = $1")},
+				{pgMsgBind, pgBind("", "s", pgFormatBinary, [][]byte{pgAppendInt64(nil, 1)}, pgFormatBinary)},
+				{pgMsgExecute, pgExecute("", 0)},
+			},
+			[]string{"1", "2", "D \x00\x00\x00\x00\x00\x00\x00\x01", "C SELECT 1"},
+		},
+		{
+			"described",
+			[]message{
+				{pgMsgParse, pgParse("s", "select id, name from t where name = $1 and id > $2")},
+				{pgMsgDescribe, pgDescribe('S', "s")},
+				{pgMsgBind, pgBind("p", "s", pgFormatText, [][]byte{[]byte("a"), []byte("0")}, pgFormatText)},
+				{pgMsgDescribe, pgDescribe('P', "p")},
+				{pgMsgExecute, pgExecute("p", 0)},
+			},
+			[]string{"1", "t", "T", "2", "T", "D 1,a", "C SELECT 1"},
+		},
+		{
+			"inserted",
+			[]message{
+				{pgMsgParse, pgParse("", "insert into t values ($1, $2)")},
+				{pgMsgBind, pgBind("", "", pgFormatText, [][]byte{[]byte("3"), nil}, pgFormatText)},
+				{pgMsgExecute, pgExecute("", 0)},
+				{pgMsgParse, pgParse("", "select id, name from t where id = 3")},
+				{pgMsgBind, pgBind("", "", pgFormatText, nil, pgFormatText)},
+				{pgMsgExecute, pgExecute("", 0)},
+			},
+			[]string{"1", "2", "C INSERT 0 1", "1", "2", "D 3,NULL", "C SELECT 1"},
+		},
+		{
+			"limited",
+			[]message{
+				{pgMsgParse, pgParse("", "select id from t")},
+				{pgMsgBind, pgBind("", "", pgFormatText, nil, pgFormatText)},
+				{pgMsgExecute, pgExecute("", 1)},
+				{pgMsgExecute, pgExecute("", 1)},
+			},
+			[]string{"1", "2", "D 1", "s", "D 2", "C SELECT 2"},
+		},
+		{
+			"copied",
+			[]message{
+				{pgMsgParse, pgParse("", "copy t to stdout")},
+				{pgMsgBind, pgBind("", "", pgFormatText, nil, pgFormatText)},
+				{pgMsgExecute, pgExecute("", 0)},
+			},
+			[]string{"1", "2", "H", "d 1\ta", "d 2\tb", "c", "C COPY 2"},
+		},
+		{
+			"no statement",
+			[]message{
+				{pgMsgParse, pgParse("", "")},
+				{pgMsgBind, pgBind("", "", pgFormatText, nil, pgFormatText)},
+				{pgMsgDescribe, pgDescribe('P', "")},
+				{pgMsgExecute, pgExecute("", 0)},
+			},
+			[]string{"1", "2", "n", "I"},
+		},
+		{
+			"closed",
+			[]message{
+				{pgMsgParse, pgParse("s", "select 1")},
+				{pgMsgClose, pgDescribe('S', "s")},
+				{pgMsgBind, pgBind("", "s", pgFormatText, nil, pgFormatText)},
+			},
+			[]string{"1", "3", "E 26000"},
+		},
+		{
+			"error skips to sync",
+			[]message{
+				{pgMsgParse, pgParse("", "select * from missing")},
+				{pgMsgBind, pgBind("", "", pgFormatText, nil, pgFormatText)},
+				{pgMsgExecute, pgExecute("", 0)},
+			},
+			[]string{"E 42P01"},
+		},
+		{
+			"invalid parameter",
+			[]message{
+				{pgMsgParse, pgParse("", "select name from t where id = $1")},
+				{pgMsgBind, pgBind("", "", pgFormatText, [][]byte{[]byte("one")}, pgFormatText)},
+			},
+			[]string{"1", "E 22P02"},
+		},
+		{
+			"wrong number of parameters",
+			[]message{
+				{pgMsgParse, pgParse("", "select $1, $2")},
+				{pgMsgBind, pgBind("", "", pgFormatText, [][]byte{[]byte("1")}, pgFormatText)},
+			},
+			[]string{"1", "E 08P01"},
+		},
+		{
+			"several statements",
+			[]message{
+				{pgMsgParse, pgParse("", "select 1; select 2")},
+			},
+			[]string{"E 42601"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := pgStartup(t, testPostgresServer(t), "sgsql", "")
+
+			for _, msg := range tt.messages {
+				if err := c.writeMessage(msg.typ, msg.payload); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pgSend(t, c, pgMsgSync, nil)
+
+			msgs, status := pgUntilReady(t, c)
+			if got := pgSummary(msgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if status != 'I' {
+				t.Errorf("transaction status is %q, want I", status)
+			}
+		})
+	}
+}
+
+func TestPostgresParamTypes(t *testing.T) {
+	tests := []struct {
+		query string
+		oids  []int32
+		want  []int32
+	}{
+		{"select name from t where id = $1", nil, []int32{pgOIDInt8}},
+		{"select id from t where $1 = name and id in ($2, 3)", nil, []int32{pgOIDText, pgOIDInt8}},
+		{"insert into t values ($1, $2)", nil, []int32{pgOIDInt8, pgOIDText}},
+		{"select id from t where $1", nil, []int32{pgOIDBool}},
+		{"select id from t where id = (select max(id) from t where name = $1)", nil, []int32{pgOIDText}},
+		{"select $1", nil, []int32{pgOIDText}},
+		{"select $1::int + 1", nil, []int32{pgOIDInt8}},
+		{"select $1::float * 2, $2::timestamp", nil, []int32{pgOIDFloat8, pgOIDTimestamp}},
+		{"select name from t where id = $1::int", nil, []int32{pgOIDInt8}},
+		{"select id from t where id = $1", []int32{pgOIDInt4}, []int32{pgOIDInt4}},
+		{"select 1", []int32{pgOIDFloat8}, []int32{pgOIDFloat8}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, _ := pgStartup(t, testPostgresServer(t), "sgsql", "")
+
+			c.writeMessage(pgMsgParse, pgParse("", tt.query, tt.oids...))
+			c.writeMessage(pgMsgDescribe, pgDescribe('S', ""))
+			pgSend(t, c, pgMsgSync, nil)
+
+			msgs, _ := pgUntilReady(t, c)
+			if len(msgs) < 2 || msgs[1].typ != 't' {
+				t.Fatalf("got %v, want a ParameterDescription", pgSummary(msgs))
+			}
+
+			r := pgReader{b: msgs[1].payload}
+			var got []int32
+			for n := r.int16(); n > 0; n-- {
+				got = append(got, r.int32())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
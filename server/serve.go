@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by Serve once Shutdown is called.
+var ErrServerClosed = errors.New("Server closed")
+
+// connSet holds what Shutdown closes for a server speaking a wire protocol:
+// the listeners it serves and the connections open on them.
+type connSet struct {
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]bool
+	conns     map[*trackedConn]bool
+	// handlers counts the connections still being handled
+	handlers sync.WaitGroup
+}
+
+// trackedConn is a connection of a connSet. idle is set while it waits for
+// a command outside a transaction, guarded by the mu of its set.
+type trackedConn struct {
+	conn net.Conn
+	idle bool
+}
+
+// serve accepts connections on l until it fails or the set is shut down,
+// handling each connection in its own goroutine. Errors handling them are
+// logged starting with protocol.
+func (cs *connSet) serve(l net.Listener, protocol string, handle func(net.Conn) error) error {
+	defer l.Close()
+
+	cs.mu.Lock()
+	if cs.closing {
+		cs.mu.Unlock()
+		return ErrServerClosed
+	}
+	if cs.listeners == nil {
+		cs.listeners = map[net.Listener]bool{}
+	}
+	cs.listeners[l] = true
+	cs.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+
+		cs.mu.Lock()
+		if cs.closing {
+			cs.mu.Unlock()
+			if err == nil {
+				conn.Close()
+			}
+			return ErrServerClosed
+		}
+		if err != nil {
+			delete(cs.listeners, l)
+			cs.mu.Unlock()
+			return err
+		}
+		cs.handlers.Add(1)
+		cs.mu.Unlock()
+
+		go func() {
+			defer cs.handlers.Done()
+			if err := handle(conn); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("%s: %s: %s", protocol, conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// shutdown stops accepting connections and closes those waiting for a
+// command outside a transaction, the others once their transaction ends.
+// When ctx is done first, the connections left are closed, rolling back
+// their transactions, and its error is returned.
+func (cs *connSet) shutdown(ctx context.Context) error {
+	cs.mu.Lock()
+	cs.closing = true
+	for l := range cs.listeners {
+		l.Close()
+	}
+	for c := range cs.conns {
+		if c.idle {
+			c.conn.Close()
+		}
+	}
+	cs.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		cs.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for c := range cs.conns {
+		c.conn.Close()
+	}
+
+	return ctx.Err()
+}
+
+// add tracks conn until it is removed.
+func (cs *connSet) add(conn net.Conn) *trackedConn {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.conns == nil {
+		cs.conns = map[*trackedConn]bool{}
+	}
+	c := &trackedConn{conn: conn}
+	cs.conns[c] = true
+
+	return c
+}
+
+func (cs *connSet) remove(c *trackedConn) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	delete(cs.conns, c)
+}
+
+// waitCommand marks c as waiting for the client to send a command, idle
+// when its session isn't in a transaction. It returns false if c is idle
+// and the server is shutting down, when c should be closed instead.
+func (cs *connSet) waitCommand(c *trackedConn, inTransaction bool) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.closing && !inTransaction {
+		return false
+	}
+	c.idle = !inTransaction
+	return true
+}
+
+// runCommand marks c as running a command the client sent, so Shutdown
+// lets it finish.
+func (cs *connSet) runCommand(c *trackedConn) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	c.idle = false
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql"
+)
+
+// TestShutdown checks that shutting a server down lets the transactions of
+// its connections finish within the grace period, and only then closes
+// them.
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// before runs before shutting down, during while shutting down
+		before, during []string
+		grace          time.Duration
+		err            error
+		rows           [][]interface{}
+	}{
+		{"idle", []string{"insert into t values (1)"}, nil, time.Minute, nil, [][]interface{}{{int64(1)}}},
+		{
+			"committed",
+			[]string{"begin", "insert into t values (1)"},
+			[]string{"insert into t values (2)", "commit"},
+			time.Minute, nil, [][]interface{}{{int64(1)}, {int64(2)}},
+		},
+		{
+			"rolled back",
+			[]string{"begin", "insert into t values (1)"},
+			[]string{"rollback"},
+			time.Minute, nil, nil,
+		},
+		{
+			"grace runs out",
+			[]string{"insert into t values (1)", "begin", "insert into t values (2)"},
+			nil,
+			50 * time.Millisecond, context.DeadlineExceeded, [][]interface{}{{int64(1)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sgsql.Open(sgsql.MemoryPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if err := db.Exec("create table t (id int)"); err != nil {
+				t.Fatal(err)
+			}
+
+			s := NewMySQLServer(db)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			served := make(chan error, 1)
+			go func() { served <- s.Serve(l) }()
+
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			c, reply := greet(t, client, "sgsql", "", mysqlNativePassword)
+			if reply != 0x00 {
+				t.Fatalf("handshake failed with %#x", reply)
+			}
+			for _, q := range tt.before {
+				if packet := query(t, c, q); packet[0] != 0x00 {
+					t.Fatalf("%s: failed with %s", q, packet[9:])
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.grace)
+			defer cancel()
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- s.Shutdown(ctx) }()
+
+			// Nothing new is served while shutting down
+			if err := <-served; !errors.Is(err, ErrServerClosed) {
+				t.Errorf("Serve returned %v, want %v", err, ErrServerClosed)
+			}
+			if err := s.Serve(l); !errors.Is(err, ErrServerClosed) {
+				t.Errorf("Serve after Shutdown returned %v, want %v", err, ErrServerClosed)
+			}
+
+			for _, q := range tt.during {
+				select {
+				case err := <-shutdown:
+					t.Fatalf("shut down with %v before %s", err, q)
+				default:
+				}
+
+				if packet := query(t, c, q); packet[0] != 0x00 {
+					t.Fatalf("%s: failed with %s", q, packet[9:])
+				}
+			}
+
+			if err := <-shutdown; !errors.Is(err, tt.err) {
+				t.Errorf("Shutdown returned %v, want %v", err, tt.err)
+			}
+			if _, err := c.readPacket(); err == nil {
+				t.Error("the connection is still open")
+			}
+
+			results, err := db.Query("select id from t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results.Rows, tt.rows) {
+				t.Errorf("t holds %v, want %v", results.Rows, tt.rows)
+			}
+		})
+	}
+}
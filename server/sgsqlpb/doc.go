@@ -0,0 +1,5 @@
+// Package sgsqlpb holds the messages and the service of the gRPC API,
+// generated from sgsql.proto. The server is server.GRPCServer.
+package sgsqlpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sgsql.proto
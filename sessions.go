@@ -0,0 +1,215 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nireo/sgsql/analyzer"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/trace"
+)
+
+var (
+	ErrNoSuchSession = errors.New("Session does not exist")
+	ErrKilled        = errors.New("Query was canceled with KILL")
+)
+
+// sessionList holds the open sessions of a database, which __sessions lists
+// and KILL finds the session to cancel in.
+type sessionList struct {
+	mu    sync.Mutex
+	next  int64
+	conns map[int64]*Conn
+}
+
+// sessionState is what __sessions shows of a session, guarded by the mutex
+// of the database's sessionList.
+type sessionState struct {
+	id        int64
+	user      string
+	connected time.Time
+	// query is running since started, it is empty when the session is idle
+	query   string
+	started time.Time
+	cancel  context.CancelFunc
+	killed  bool
+	// waiting is set while the query waits for its quota to let it run
+	waiting bool
+}
+
+func (db *DB) addSession(c *Conn) {
+	db.sessions.mu.Lock()
+	defer db.sessions.mu.Unlock()
+
+	if db.sessions.conns == nil {
+		db.sessions.conns = map[int64]*Conn{}
+	}
+
+	db.sessions.next++
+	c.state.id, c.state.user, c.state.connected = db.sessions.next, c.session.User(), time.Now().UTC()
+	db.sessions.conns[c.state.id] = c
+}
+
+func (db *DB) removeSession(c *Conn) {
+	db.sessions.mu.Lock()
+	defer db.sessions.mu.Unlock()
+
+	delete(db.sessions.conns, c.state.id)
+}
+
+// sessionInfo lists the open sessions in the order they were opened.
+func (db *DB) sessionInfo() []backend.SessionInfo {
+	db.sessions.mu.Lock()
+	defer db.sessions.mu.Unlock()
+
+	infos := make([]backend.SessionInfo, 0, len(db.sessions.conns))
+	for _, c := range db.sessions.conns {
+		infos = append(infos, backend.SessionInfo{
+			ID:        c.state.id,
+			User:      c.state.user,
+			Connected: c.state.connected,
+			Query:     c.state.query,
+			Started:   c.state.started,
+			Waiting:   c.state.waiting,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	return infos
+}
+
+// track marks query as running in the session until the returned function
+// is called with the error the query ended with. That function returns the
+// error, or ErrKilled if the query was canceled with KILL and
+// ErrQueryTimeout if it ran out of time. The query runs under the quota of
+// the session, which may have it wait for its turn first, and track fails
+// if the quota doesn't let it run.
+func (c *Conn) track(ctx context.Context, query string) (context.Context, func(error) error, error) {
+	c.db.sessions.mu.Lock()
+
+	// A query run by one already running is part of it
+	if c.state.cancel != nil {
+		c.db.sessions.mu.Unlock()
+		return ctx, func(err error) error { return err }, nil
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	c.state.query, c.state.started, c.state.cancel = parser.Redact(query), time.Now().UTC(), cancel
+	c.state.killed, c.state.waiting = false, true
+	user := c.state.user
+	c.db.sessions.mu.Unlock()
+
+	// finish marks the session idle again, reporting whether the query was
+	// killed
+	finish := func() bool {
+		cancel()
+
+		c.db.sessions.mu.Lock()
+		defer c.db.sessions.mu.Unlock()
+
+		killed := c.state.killed
+		c.state.query, c.state.started, c.state.cancel = "", time.Time{}, nil
+		c.state.killed, c.state.waiting = false, false
+		return killed
+	}
+
+	quota := c.currentQuota(user)
+	release, err := c.db.quotas.acquire(ctx, user, quota)
+	if err != nil {
+		if finish() && errors.Is(err, context.Canceled) {
+			err = ErrKilled
+		}
+		return nil, nil, err
+	}
+
+	c.db.sessions.mu.Lock()
+	c.state.started, c.state.waiting = time.Now().UTC(), false
+	c.db.sessions.mu.Unlock()
+
+	stop := func() {}
+	if quota.MaxDuration > 0 {
+		ctx, stop = context.WithTimeout(ctx, quota.MaxDuration)
+	}
+	if quota.MaxRows > 0 {
+		ctx = context.WithValue(ctx, maxRowsKey{}, quota.MaxRows)
+	}
+
+	return ctx, func(err error) error {
+		stop()
+		release()
+
+		switch {
+		case finish() && errors.Is(err, context.Canceled):
+			return ErrKilled
+		case errors.Is(err, context.DeadlineExceeded) && parent.Err() == nil:
+			return fmt.Errorf("%w: %s", ErrQueryTimeout, quota.MaxDuration)
+		}
+		return err
+	}, nil
+}
+
+// querySystem runs a query of system tables alone outside of any
+// transaction.
+func (c *Conn) querySystem(ctx context.Context, stmt *parser.Statement, args []interface{}) (*Results, error) {
+	ctx, span := trace.Start(ctx, "sgsql.statement")
+	span.SetAttribute("db.statement", stmt.Text)
+
+	err := analyzer.Analyze(c.db.backend, stmt)
+	var results *Results
+	if err == nil {
+		results, err = backend.Exec(ctx, c.db.backend, stmt, c.session, args)
+	}
+	if err == nil {
+		err = checkRows(ctx, results)
+	}
+	endSpan(span, err)
+
+	return results, err
+}
+
+// kill runs KILL, which cancels the query running in another session. The
+// query stops at its next row, a session that is idle is left alone. Users
+// may only kill their own sessions, the superuser any of them.
+func (c *Conn) kill(stmt *parser.KillStatement, args []interface{}) (*Results, error) {
+	var id int64
+	if stmt.Session.Type == parser.LiteralType {
+		id = stmt.Session.Literal.Int64
+	} else if n := stmt.Session.Param; n > uint(len(args)) {
+		return nil, fmt.Errorf("%w: $%d", backend.ErrMissingParameter, n)
+	} else {
+		switch v := args[n-1].(type) {
+		case int:
+			id = int64(v)
+		case int64:
+			id = v
+		default:
+			return nil, fmt.Errorf("%w: %v", ErrNoSuchSession, args[n-1])
+		}
+	}
+
+	c.db.sessions.mu.Lock()
+	defer c.db.sessions.mu.Unlock()
+
+	target, ok := c.db.sessions.conns[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrNoSuchSession, id)
+	}
+	if target.state.user != c.session.User() {
+		if err := c.checkSuperuser("kill the sessions of other users"); err != nil {
+			return nil, err
+		}
+	}
+
+	if target.state.cancel != nil && target != c {
+		target.state.killed = true
+		target.state.cancel()
+	}
+
+	return &Results{}, nil
+}
@@ -0,0 +1,80 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+)
+
+func TestSessionsShowOwnSessions(t *testing.T) {
+	db, _ := openTest(t)
+	alice := connAs(t, db, "alice")
+	connAs(t, db, "bob")
+
+	tests := []struct {
+		c    *Conn
+		want [][]interface{}
+	}{
+		{db.Conn(), [][]interface{}{{"alice"}, {"bob"}, {functions.DefaultUser}}},
+		{alice, [][]interface{}{{"alice"}}},
+	}
+
+	for _, tt := range tests {
+		results, err := tt.c.Query("select user_name from __sessions")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(results.Rows, func(i, j int) bool {
+			return results.Rows[i][0].(string) < results.Rows[j][0].(string)
+		})
+		if !reflect.DeepEqual(results.Rows, tt.want) {
+			t.Errorf("%s sees %v, want %v", tt.c.session.User(), results.Rows, tt.want)
+		}
+	}
+}
+
+func TestKillOnlyOwnSessions(t *testing.T) {
+	db, _ := openTest(t)
+
+	tests := []struct {
+		name         string
+		killer, user string
+		err          error
+	}{
+		{"own session", "alice", "alice", nil},
+		{"other user", "mallory", "alice", backend.ErrPermissionDenied},
+		{"superuser", functions.DefaultUser, "alice", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := connAs(t, db, tt.user)
+			killer := connAs(t, db, tt.killer)
+
+			// The target looks like it is running a query until done
+			ctx, done, err := target.track(context.Background(), "select 1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = killer.Exec("kill " + strconv.FormatInt(target.ID(), 10))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+
+			want := ErrKilled
+			if tt.err != nil {
+				want = nil
+			}
+			if err := done(ctx.Err()); !errors.Is(err, want) {
+				t.Errorf("the query ended with %v, want %v", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+// Package sgsql is the embeddable entry point to the database. It wires the
+// parser and the backend together behind a small API:
+//
+//	db, err := sgsql.Open("data.sgsql")
+//	err = db.Exec("CREATE TABLE users (id INT, name TEXT)")
+//	res, err := db.Query("SELECT name FROM users WHERE id = $1", 1)
+package sgsql
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/logging"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+// MemoryPath opens a database that is never written to disk.
+const MemoryPath = ":memory:"
+
+type Results = backend.Results
+
+var (
+	ErrTxDone   = errors.New("Transaction has already been committed or rolled back")
+	ErrReadOnly = errors.New("Cannot modify a read-only database")
+)
+
+// DB is a handle to a database. It is safe for concurrent use, statements
+// run one transaction at a time.
+type DB struct {
+	// id orders the databases of the process, see lastDBID
+	id      int64
+	mu      sync.Mutex
+	backend *backend.MemoryBackend
+	// engine stores the rows of the tables of backend, see WithEngine
+	engine storage.Engine
+	// path is where the statement log is, the name log was opened with
+	// changes when it is rewritten
+	path     string
+	log      *os.File
+	cipher   *logCipher
+	readOnly bool
+
+	// logSize is the size of the statement log, vacuumedSize its size when
+	// it was opened or last vacuumed
+	logSize      int64
+	vacuumedSize int64
+	// entries counts the entries of the statement log, encrypted ones
+	// are sealed with their number, see logCipher
+	entries    int
+	autoVacuum float64
+	// vacuuming is set while a background vacuum is running
+	vacuuming int32
+	// replayed is how many entries of the statement log Open replayed and
+	// replayTime how long that took, see Health
+	replayed   int
+	replayTime time.Duration
+
+	// syncMu guards the syncing of the statement log, which happens
+	// outside of mu so commits can be synced together, see waitSync
+	syncMu   sync.Mutex
+	syncDone *sync.Cond
+	syncFile *os.File
+	// written counts the appends to the log and synced the ones that are
+	// known to be on disk
+	written int64
+	synced  int64
+	syncing bool
+	syncErr error
+	// async is set when commits don't wait for the log to be synced,
+	// stopFlush stops syncing it in the background then
+	async     bool
+	stopFlush chan struct{}
+
+	// tracer holds the tracerBox set by SetTracer and logger the
+	// loggerBox set by SetLogger
+	tracer atomic.Value
+	logger atomic.Value
+	// slowQuery holds the time.Duration set by SetSlowQueryThreshold
+	slowQuery atomic.Value
+	// attachDir holds the directory set by SetAttachDir and reload the
+	// reloadBox set by SetReload
+	attachDir atomic.Value
+	reload    atomic.Value
+	// foreignKeyIndexes holds the bool set by SetForeignKeyIndexes
+	foreignKeyIndexes atomic.Value
+
+	sessions  sessionList
+	quotas    quotaList
+	auditLog  auditLog
+	listeners listenerList
+	prepared  preparedTx
+	changes   changeStreams
+}
+
+// logEntry is a single committed query in the statement log. Replaying every
+// entry in order rebuilds the database. Nextval holds the values NEXTVAL
+// returned while the query ran, IDs the identifiers UUID() and ULID() made,
+// Times the times NOW(), CURRENT_TIMESTAMP and CURRENT_DATE read and Random
+// the numbers RANDOM() returned, which replaying returns again so the same
+// rows are written. User is the user it ran as unless that was the default
+// one, since policies and masks show each user other rows. Prepared holds
+// the id of the prepared transaction the entry belongs to, which only runs
+// once the transaction is committed with COMMIT PREPARED. Rows holds the
+// parameters of each row of a bulk insert, which runs the query once for
+// each of them instead of with Params. Checkpoint is only set on the first
+// entry of a log vacuumed by a database whose storage engine keeps the rows
+// of its tables, and has no query: it names the checkpoint of the engine
+// the rest of the log starts from, see storage.Checkpointer.
+type logEntry struct {
+	Query      string            `json:"query"`
+	Params     []interface{}     `json:"params,omitempty"`
+	Rows       [][]interface{}   `json:"rows,omitempty"`
+	Nextval    []int64           `json:"nextval,omitempty"`
+	IDs        []types.UUIDValue `json:"ids,omitempty"`
+	Times      []time.Time       `json:"times,omitempty"`
+	Random     []float64         `json:"random,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Prepared   string            `json:"prepared,omitempty"`
+	Checkpoint string            `json:"checkpoint,omitempty"`
+}
+
+// Open opens the database stored at path, creating it if it doesn't exist.
+// The file is a log of every committed query that changed the database.
+func Open(path string, opts ...Option) (*DB, error) {
+	return open(path, false, nil, opts)
+}
+
+// OpenReadOnly opens the existing database at path. Every session on it is
+// read-only and any statement that would change it is rejected.
+func OpenReadOnly(path string, opts ...Option) (*DB, error) {
+	return open(path, true, nil, opts)
+}
+
+func open(path string, readOnly bool, c *logCipher, opts []Option) (*DB, error) {
+	engine, err := openEngine(path, readOnly, c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openBackend(path, readOnly, c, engine)
+	if err != nil {
+		closeEngine(engine)
+		return nil, err
+	}
+	return db, nil
+}
+
+// openBackend opens the database at path with its tables in engine.
+func openBackend(path string, readOnly bool, c *logCipher, engine storage.Engine) (*DB, error) {
+	db := &DB{id: nextDBID(), backend: backend.NewBackend(engine), engine: engine, cipher: c, readOnly: readOnly}
+	db.backend.SetSessions(db.sessionInfo)
+	db.backend.SetAudit(db.auditEntries)
+	db.backend.SetPrepared(db.preparedInfo)
+	db.syncDone = sync.NewCond(&db.syncMu)
+	if path == "" || path == MemoryPath {
+		return db, nil
+	}
+
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := db.replay(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	db.replayed, db.replayTime = db.entries, time.Since(start)
+	db.backend.RecordVersions(time.Now().UTC())
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	db.path, db.log, db.logSize, db.vacuumedSize = path, f, size, size
+	db.syncFile = f
+	return db, nil
+}
+
+// replay runs the entries of the statement log read from r. It stops at
+// the first damaged entry, since the entries after it would run against
+// the wrong state. A transaction the log leaves prepared is prepared again,
+// unless the database is read-only.
+func (db *DB) replay(r io.Reader) error {
+	lr := newLogReader(r, db.cipher)
+
+	// Sequences are restored with setval, which mustn't be logged again
+	session := functions.NewSession(db.backend)
+	defer db.backend.TakeSequenceLog()
+
+	// The entries of prepared transactions by their ids, which run once
+	// they are committed
+	prepared := map[string][]logEntry{}
+
+	for {
+		entry, err := lr.next()
+		if err == io.EOF {
+			if lr.entry == 0 {
+				if err := db.recoverEngine(""); err != nil {
+					return err
+				}
+			}
+
+			db.entries = lr.entry
+			if db.readOnly {
+				return nil
+			}
+
+			// There is at most one, see preparedTx
+			for id, entries := range prepared {
+				return db.recoverPrepared(id, entries[1:])
+			}
+			return nil
+		}
+
+		// With the wrong key every entry fails to decrypt, which isn't
+		// damage
+		var corrupt *CorruptionError
+		if errors.As(err, &corrupt) && corrupt.Entry == 1 && corrupt.Msg == msgUndecryptable {
+			return ErrDecrypt
+		}
+		if err != nil {
+			return err
+		}
+
+		if lr.entry == 1 {
+			if err := db.recoverEngine(entry.Checkpoint); err != nil {
+				return err
+			}
+		}
+		if entry.Checkpoint != "" {
+			continue
+		}
+
+		if entry.Prepared != "" {
+			prepared[entry.Prepared] = append(prepared[entry.Prepared], entry)
+			continue
+		}
+
+		ast, err := parser.Parse(entry.Query)
+		if err != nil {
+			return err
+		}
+
+		if len(ast.Statements) == 1 {
+			switch stmt := ast.Statements[0]; stmt.Type {
+			case parser.CommitPreparedType, parser.RollbackPreparedType:
+				id := stmt.TwoPhaseStatement.ID.Value
+				entries := prepared[id]
+				delete(prepared, id)
+
+				if stmt.Type == parser.RollbackPreparedType || len(entries) == 0 {
+					continue
+				}
+				for _, entry := range entries[1:] {
+					if err := db.replayEntry(session, entry); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+
+		if err := db.runEntry(session, ast, entry); err != nil {
+			return err
+		}
+	}
+}
+
+// replayEntry runs a single entry of the statement log in session.
+func (db *DB) replayEntry(session *functions.Session, entry logEntry) error {
+	ast, err := parser.Parse(entry.Query)
+	if err != nil {
+		return err
+	}
+
+	return db.runEntry(session, ast, entry)
+}
+
+// runEntry runs the statements of entry, parsed as ast, in session.
+func (db *DB) runEntry(session *functions.Session, ast *parser.AST, entry logEntry) error {
+	session.Replay(entry.Nextval)
+	session.ReplayIDs(entry.IDs)
+	session.ReplayTimes(entry.Times)
+	session.ReplayRandom(entry.Random)
+	session.SetUser(entry.User)
+	session.SetPrivileged(true)
+	defer session.Replay(nil)
+	defer session.ReplayIDs(nil)
+	defer session.ReplayTimes(nil)
+	defer session.ReplayRandom(nil)
+
+	params := [][]interface{}{entry.Params}
+	if entry.Rows != nil {
+		params = entry.Rows
+	}
+	for _, p := range params {
+		for _, stmt := range ast.Statements {
+			if _, err := backend.Exec(context.Background(), db.backend, stmt, session, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// appendLog writes entries to the end of the statement log and returns the
+// number to wait for with waitSync until they are on disk. A failed write
+// is cut off again so the log never ends in a partial entry.
+func (db *DB) appendLog(entries []logEntry) (int64, error) {
+	db.syncMu.Lock()
+	err := db.syncErr
+	db.syncMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := db.log.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(db.log)
+	for i, entry := range entries {
+		if err = writeEntry(w, entry, db.entries+i+1, db.cipher); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err != nil {
+		db.log.Truncate(end)
+		return 0, err
+	}
+
+	db.entries += len(entries)
+	if db.logSize, err = db.log.Seek(0, io.SeekCurrent); err != nil {
+		return 0, err
+	}
+	db.maybeAutoVacuum()
+
+	db.syncMu.Lock()
+	defer db.syncMu.Unlock()
+
+	db.written++
+	return db.written, nil
+}
+
+// Close syncs and closes the underlying statement log. A prepared
+// transaction is left as it is, to be resolved once the database is opened
+// again.
+func (db *DB) Close() (err error) {
+	db.abandonPrepared()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.closeStreams()
+
+	if db.log == nil {
+		return nil
+	}
+	defer func() {
+		if cerr := closeEngine(db.engine); err == nil {
+			err = cerr
+		}
+	}()
+
+	db.syncMu.Lock()
+	if db.stopFlush != nil {
+		close(db.stopFlush)
+		db.stopFlush = nil
+	}
+	db.syncMu.Unlock()
+
+	err = db.waitSync(db.written, true)
+	if cerr := db.log.Close(); err == nil {
+		err = cerr
+	}
+	db.log = nil
+	return err
+}
+
+// begin starts a transaction, waiting for any other one to finish first.
+func (db *DB) begin(readOnly bool, session *functions.Session) *Tx {
+	db.mu.Lock()
+	db.logEvent(context.Background(), logging.LevelDebug, "Transaction started", "read_only", readOnly)
+
+	return &Tx{
+		db:       db,
+		snapshot: db.backend.Snapshot(),
+		session:  session,
+		readOnly: readOnly,
+	}
+}
+
+// Conn opens a new session on the database.
+func (db *DB) Conn() *Conn {
+	session := functions.NewSession(db.backend)
+	session.SetDatabase(db.name())
+
+	c := &Conn{db: db, readOnly: db.readOnly, session: session}
+	db.addSession(c)
+	return c
+}
+
+// name is the name of the database, its file name without the extension,
+// and empty for an in-memory database.
+func (db *DB) name() string {
+	if db.path == "" {
+		return ""
+	}
+
+	base := filepath.Base(db.path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// SetHistoryRetention sets how far back AS OF TIMESTAMP can read tables,
+// see backend.MemoryBackend.SetHistoryRetention. Versions from before the
+// database was opened are never kept.
+func (db *DB) SetHistoryRetention(d time.Duration) {
+	db.backend.SetHistoryRetention(d)
+}
+
+// Catalog describes the tables of the database as they are when it is
+// called.
+func (db *DB) Catalog() backend.Catalog {
+	return db.backend
+}
+
+// SetExternalDir lets external tables read the files under dir, see
+// backend.MemoryBackend.SetExternalDir. Until it is set, reading an external
+// table fails.
+func (db *DB) SetExternalDir(dir string) {
+	db.backend.SetExternalDir(dir)
+}
+
+// Begin starts a transaction in a new session, which ends with it.
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	tx.ownsConn = true
+	return tx, nil
+}
+
+// Exec runs every statement in query in a new session, binding args to the
+// $1..$n placeholders.
+func (db *DB) Exec(query string, args ...interface{}) error {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext is Exec with a context, see Conn.QueryContext.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) error {
+	_, err := db.QueryContext(ctx, query, args...)
+	return err
+}
+
+// Query runs every statement in query in a new session and returns the
+// results of the last one. A transaction the query leaves open is rolled
+// back.
+func (db *DB) Query(query string, args ...interface{}) (*Results, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext is Query with a context, see Conn.QueryContext.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Results, error) {
+	c := db.Conn()
+	defer c.Close()
+
+	return c.QueryContext(ctx, query, args...)
+}
+
+// ExecScript runs the statements read from r one at a time in a new
+// session, see Conn.ExecScript.
+func (db *DB) ExecScript(r io.Reader) error {
+	c := db.Conn()
+	defer c.Close()
+
+	return c.ExecScript(r)
+}
+
+// BulkInsert appends rows to table in a transaction of its own, see
+// Tx.BulkInsert.
+func (db *DB) BulkInsert(table string, rows [][]interface{}) error {
+	c := db.Conn()
+	defer c.Close()
+
+	return c.BulkInsert(table, rows)
+}
+
+// Prepare parses query once so it can be run many times with different
+// arguments, each time in a new session.
+func (db *DB) Prepare(query string) (*Stmt, error) {
+	c := db.Conn()
+	s, err := c.Prepare(query)
+	c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	s.ownsConn = true
+	return s, nil
+}
@@ -0,0 +1,175 @@
+package sgsql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEmbedded(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(db *DB) error
+		rows [][]interface{}
+	}{
+		{"exec with args", func(db *DB) error {
+			return db.Exec("insert into t values ($1, $2)", int64(1), "a")
+		}, [][]interface{}{{int64(1), "a"}}},
+		{"committed transaction", func(db *DB) error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec("insert into t values (1, 'a')"); err != nil {
+				return err
+			}
+			if err := tx.Exec("insert into t values (2, 'b')"); err != nil {
+				return err
+			}
+			return tx.Commit()
+		}, [][]interface{}{{int64(1), "a"}, {int64(2), "b"}}},
+		{"rolled back transaction", func(db *DB) error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec("insert into t values (1, 'a')"); err != nil {
+				return err
+			}
+			return tx.Rollback()
+		}, nil},
+		{"prepared statement", func(db *DB) error {
+			stmt, err := db.Prepare("insert into t values ($1, $2)")
+			if err != nil {
+				return err
+			}
+			if err := stmt.Exec(int64(1), "a"); err != nil {
+				return err
+			}
+			return stmt.Exec(int64(2), nil)
+		}, [][]interface{}{{int64(1), "a"}, {int64(2), nil}}},
+		{"script", func(db *DB) error {
+			return db.ExecScript(strings.NewReader("insert into t values (1, 'a');\ninsert into t values (2, 'b; c');\n"))
+		}, [][]interface{}{{int64(1), "a"}, {int64(2), "b; c"}}},
+		{"bulk insert", func(db *DB) error {
+			return db.BulkInsert("t", [][]interface{}{{int64(1), "a"}, {int64(2), "b"}})
+		}, [][]interface{}{{int64(1), "a"}, {int64(2), "b"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			mustExec(t, db, "create table t (id int, name text)")
+
+			if err := tt.run(db); err != nil {
+				t.Fatal(err)
+			}
+			if got := queryRows(t, db, "select id, name from t"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("t holds %v, want %v", got, tt.rows)
+			}
+
+			// What was committed is back after opening the database again
+			db = reopen(t, db, path)
+			if got := queryRows(t, db, "select id, name from t"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("t holds %v after reopening, want %v", got, tt.rows)
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	db, path := openTest(t)
+	mustExec(t, db, "create table t (id int)", "insert into t values (1)")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	writable, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writable.Close()
+	session := writable.Conn()
+	defer session.Close()
+	if err := session.SetReadOnly(true); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnly, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	conn := readOnly.Conn()
+	defer conn.Close()
+	if err := conn.SetReadOnly(false); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("making a session on a read-only database writable: got %v, want %v", err, ErrReadOnly)
+	}
+
+	tests := []struct {
+		name string
+		run  func(c *Conn) error
+		err  error
+	}{
+		{"select", func(c *Conn) error {
+			return c.Exec("select id from t")
+		}, nil},
+		{"insert", func(c *Conn) error {
+			return c.Exec("insert into t values (2)")
+		}, ErrReadOnly},
+		{"create table", func(c *Conn) error {
+			return c.Exec("create table u (id int)")
+		}, ErrReadOnly},
+		{"select then insert", func(c *Conn) error {
+			return c.Exec("select id from t; insert into t values (2)")
+		}, ErrReadOnly},
+		{"vacuum", func(c *Conn) error {
+			return c.Exec("vacuum")
+		}, ErrReadOnly},
+		{"insert in a transaction", func(c *Conn) error {
+			tx, err := c.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			return tx.Exec("insert into t values (2)")
+		}, ErrReadOnly},
+		{"bulk insert", func(c *Conn) error {
+			tx, err := c.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			return tx.BulkInsert("t", [][]interface{}{{int64(2)}})
+		}, ErrReadOnly},
+	}
+
+	for _, c := range []struct {
+		name string
+		conn *Conn
+	}{{"read-only session", session}, {"read-only database", conn}} {
+		for _, tt := range tests {
+			t.Run(c.name+" "+tt.name, func(t *testing.T) {
+				if err := tt.run(c.conn); !errors.Is(err, tt.err) {
+					t.Errorf("got %v, want %v", err, tt.err)
+				}
+			})
+		}
+	}
+
+	if err := readOnly.BulkInsert("t", [][]interface{}{{int64(2)}}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("bulk inserting into a read-only database: got %v, want %v", err, ErrReadOnly)
+	}
+
+	results, err := writable.Query("select id from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]interface{}{{int64(1)}}; !reflect.DeepEqual(results.Rows, want) {
+		t.Errorf("t holds %v, want %v", results.Rows, want)
+	}
+
+	// Writable sessions on the same database still write
+	mustExec(t, writable, "insert into t values (2)")
+}
@@ -0,0 +1,213 @@
+// Package sq builds statements from Go instead of from SQL text:
+//
+//	q := sq.Select("id", "name").From("users").Where(sq.Col("age").Ge(18))
+//	text, err := q.SQL()
+//	...
+//	results, err := db.Query(text)
+//
+// The builders construct the same nodes the parser produces and format them
+// with every value written as a literal and every name quoted when it needs
+// to be, so no value or name can change the shape of the statement.
+package sq
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nireo/sgsql/parser"
+)
+
+var (
+	ErrNoColumns = errors.New("Statement has no columns")
+	ErrNoValues  = errors.New("Statement has no values")
+	ErrHintName  = errors.New("Hint names are plain words")
+)
+
+// SelectBuilder builds a SELECT statement.
+type SelectBuilder struct {
+	stmt parser.SelectStatement
+	err  error
+}
+
+// Select selects the columns called columns, with "*" selecting all of
+// them. More items can be added with Column and ColumnAs.
+func Select(columns ...string) *SelectBuilder {
+	b := &SelectBuilder{}
+	for _, col := range columns {
+		if col == "*" {
+			b.stmt.Item = append(b.stmt.Item, &parser.SelectItem{Asterisk: true})
+			continue
+		}
+
+		b.Column(Col(col))
+	}
+
+	return b
+}
+
+func (b *SelectBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Column selects e.
+func (b *SelectBuilder) Column(e Expr) *SelectBuilder {
+	b.fail(e.err)
+	exp := e.exp
+	b.stmt.Item = append(b.stmt.Item, &parser.SelectItem{Exp: &exp})
+	return b
+}
+
+// ColumnAs selects e as the column called name.
+func (b *SelectBuilder) ColumnAs(e Expr, name string) *SelectBuilder {
+	b.Column(e)
+	b.stmt.Item[len(b.stmt.Item)-1].As = &parser.Token{Value: name, Type: parser.IdentifierType}
+	return b
+}
+
+// From selects from the table called table.
+func (b *SelectBuilder) From(table string) *SelectBuilder {
+	b.stmt.From = &parser.Token{Value: table, Type: parser.IdentifierType}
+	return b
+}
+
+// Where filters the rows by cond. Calling it again filters by both
+// conditions.
+func (b *SelectBuilder) Where(cond Expr) *SelectBuilder {
+	b.fail(cond.err)
+	if b.stmt.Where != nil {
+		cond = Expr{exp: *b.stmt.Where}.And(cond)
+	}
+
+	exp := cond.exp
+	b.stmt.Where = &exp
+	return b
+}
+
+// OrderBy sorts the rows by e, after the expressions given before. An
+// integer sorts by the column at that position.
+func (b *SelectBuilder) OrderBy(e Expr) *SelectBuilder {
+	b.fail(e.err)
+	exp := e.exp
+	b.stmt.OrderBy = append(b.stmt.OrderBy, &parser.OrderItem{Exp: &exp})
+	return b
+}
+
+// OrderByDesc is OrderBy in descending order.
+func (b *SelectBuilder) OrderByDesc(e Expr) *SelectBuilder {
+	b.OrderBy(e)
+	b.stmt.OrderBy[len(b.stmt.OrderBy)-1].Desc = true
+	return b
+}
+
+// Limit returns at most n rows.
+func (b *SelectBuilder) Limit(n int64) *SelectBuilder {
+	exp := Val(n).exp
+	b.stmt.Limit = &exp
+	return b
+}
+
+// Offset skips the first n rows.
+func (b *SelectBuilder) Offset(n int64) *SelectBuilder {
+	exp := Val(n).exp
+	b.stmt.Offset = &exp
+	return b
+}
+
+// Hint adds the planner hint called name for tables, like
+// Hint("nested_loop", "orders").
+func (b *SelectBuilder) Hint(name string, tables ...string) *SelectBuilder {
+	// Hints are written as they are inside a comment
+	if parser.FormatIdentifier(name) != name {
+		b.fail(fmt.Errorf("%w: %q", ErrHintName, name))
+	}
+
+	hint := parser.Hint{Name: parser.Token{Value: name, Type: parser.IdentifierType}}
+	for _, table := range tables {
+		hint.Args = append(hint.Args, parser.Token{Value: table, Type: parser.IdentifierType})
+	}
+
+	b.stmt.Hints = append(b.stmt.Hints, hint)
+	return b
+}
+
+// Statement returns the built statement, with its Text set to its SQL.
+func (b *SelectBuilder) Statement() (*parser.Statement, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if len(b.stmt.Item) == 0 {
+		return nil, ErrNoColumns
+	}
+
+	stmt := b.stmt
+	return &parser.Statement{SelectStatement: &stmt, Type: parser.SelectType, Text: stmt.String()}, nil
+}
+
+// SQL returns the built statement as SQL.
+func (b *SelectBuilder) SQL() (string, error) {
+	stmt, err := b.Statement()
+	if err != nil {
+		return "", err
+	}
+
+	return stmt.Text, nil
+}
+
+// InsertBuilder builds an INSERT statement.
+type InsertBuilder struct {
+	stmt   parser.InsertStatement
+	values []*parser.Expression
+	err    error
+}
+
+// Insert inserts into the table called table.
+func Insert(table string) *InsertBuilder {
+	return &InsertBuilder{stmt: parser.InsertStatement{
+		Table: parser.Token{Value: table, Type: parser.IdentifierType},
+	}}
+}
+
+// Values sets the values of the row to insert, which are expressions or
+// values taken by Val, one for each column in order.
+func (b *InsertBuilder) Values(values ...interface{}) *InsertBuilder {
+	exps, err := list(values)
+	if b.err == nil {
+		b.err = err
+	}
+
+	b.values = make([]*parser.Expression, len(exps))
+	for i := range exps {
+		b.values[i] = &exps[i]
+	}
+
+	return b
+}
+
+// Statement returns the built statement, with its Text set to its SQL.
+func (b *InsertBuilder) Statement() (*parser.Statement, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if len(b.values) == 0 {
+		return nil, ErrNoValues
+	}
+
+	stmt := b.stmt
+	values := append([]*parser.Expression{}, b.values...)
+	stmt.Values = &values
+	return &parser.Statement{InsertStatement: &stmt, Type: parser.InsertType, Text: stmt.String()}, nil
+}
+
+// SQL returns the built statement as SQL.
+func (b *InsertBuilder) SQL() (string, error) {
+	stmt, err := b.Statement()
+	if err != nil {
+		return "", err
+	}
+
+	return stmt.Text, nil
+}
@@ -0,0 +1,290 @@
+package sq
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/types"
+)
+
+var (
+	ErrUnsupportedValue = errors.New("Value can't be written as a literal")
+	ErrNoSuchType       = errors.New("Type does not exist")
+	ErrEmptyList        = errors.New("IN needs at least one value")
+)
+
+// Expr is an expression. Values that can't be written as literals are
+// remembered and reported by the statement using the expression, so
+// expressions can be chained without checking each one.
+type Expr struct {
+	exp parser.Expression
+	err error
+}
+
+// Col refers to the column called name.
+func Col(name string) Expr {
+	return Expr{exp: parser.Expression{
+		Column: &parser.Token{Value: name, Type: parser.IdentifierType},
+		Type:   parser.ColumnRefType,
+	}}
+}
+
+// Val is v as a literal. It takes nil, booleans, integers, floats, strings,
+// time.Time, types.IntervalValue and types.UUIDValue.
+func Val(v interface{}) Expr {
+	literal := func(value parser.Value) Expr {
+		return Expr{exp: parser.Expression{Literal: &value, Type: parser.LiteralType}}
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return literal(parser.Value{Type: parser.NullValue})
+	case bool:
+		return literal(parser.Value{Type: parser.BoolValue, Bool: v})
+	case string:
+		return literal(parser.Value{Type: parser.StringValue, String: v})
+	case int:
+		return Val(int64(v))
+	case int8:
+		return Val(int64(v))
+	case int16:
+		return Val(int64(v))
+	case int32:
+		return Val(int64(v))
+	case int64:
+		// The parser reads -n as 0 - n, so negative numbers are built the
+		// same way
+		switch {
+		case v == math.MinInt64:
+			return Val(0).Minus(int64(math.MaxInt64)).Minus(1)
+		case v < 0:
+			return Val(0).Minus(-v)
+		}
+		return literal(parser.Value{Type: parser.Int64Value, Int64: v})
+	case uint8:
+		return literal(parser.Value{Type: parser.Int64Value, Int64: int64(v)})
+	case uint16:
+		return literal(parser.Value{Type: parser.Int64Value, Int64: int64(v)})
+	case uint32:
+		return literal(parser.Value{Type: parser.Int64Value, Int64: int64(v)})
+	case uint:
+		return Val(uint64(v))
+	case uint64:
+		if v > math.MaxInt64 {
+			return Expr{err: fmt.Errorf("%w: %d is out of range", ErrUnsupportedValue, v)}
+		}
+		return literal(parser.Value{Type: parser.Int64Value, Int64: int64(v)})
+	case float32:
+		return Val(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Expr{err: fmt.Errorf("%w: %v", ErrUnsupportedValue, v)}
+		}
+		if v < 0 {
+			return Val(0).Minus(-v)
+		}
+		return literal(parser.Value{Type: parser.Float64Value, Float64: v})
+	case time.Time:
+		return Val(v.UTC().Format(time.RFC3339Nano)).Cast("timestamp")
+	case types.IntervalValue:
+		return Val(v.String()).Cast("interval")
+	case types.UUIDValue:
+		return Val(v.String()).Cast("uuid")
+	case Expr:
+		return v
+	}
+
+	return Expr{err: fmt.Errorf("%w: %T", ErrUnsupportedValue, v)}
+}
+
+// Param is the $n placeholder, numbered from one.
+func Param(n uint) Expr {
+	return Expr{exp: parser.Expression{Param: n, Type: parser.ParamType}}
+}
+
+// Call calls the function called name with args, which are expressions or
+// values taken by Val.
+func Call(name string, args ...interface{}) Expr {
+	call := Expr{exp: parser.Expression{
+		Call: &parser.CallExpression{Name: parser.Token{Value: name, Type: parser.IdentifierType}},
+		Type: parser.CallType,
+	}}
+	call.exp.Call.Args, call.err = list(args)
+
+	return call
+}
+
+// CountAll is count(*).
+func CountAll() Expr {
+	call := Call("count")
+	call.exp.Call.Star = true
+	return call
+}
+
+// Not negates e.
+func Not(e interface{}) Expr {
+	operand := Val(e)
+	return Expr{exp: parser.Expression{Not: &operand.exp, Type: parser.NotType}, err: operand.err}
+}
+
+// And is true when every one of exps is, and true for none.
+func And(exps ...interface{}) Expr {
+	return chain("and", true, exps)
+}
+
+// Or is true when any one of exps is, and false for none.
+func Or(exps ...interface{}) Expr {
+	return chain("or", false, exps)
+}
+
+func chain(op string, empty bool, exps []interface{}) Expr {
+	if len(exps) == 0 {
+		return Val(empty)
+	}
+
+	e := Val(exps[0])
+	for _, next := range exps[1:] {
+		e = e.keyword(op, next)
+	}
+
+	return e
+}
+
+// Exists is true when q returns any rows.
+func Exists(q *SelectBuilder) Expr {
+	stmt := q.stmt
+	return Expr{exp: parser.Expression{
+		Exists: &parser.ExistsExpression{Query: &stmt},
+		Type:   parser.ExistsType,
+	}, err: q.err}
+}
+
+// Subquery is the single value q returns.
+func Subquery(q *SelectBuilder) Expr {
+	stmt := q.stmt
+	return Expr{exp: parser.Expression{Subquery: &stmt, Type: parser.SubqueryType}, err: q.err}
+}
+
+// list converts values to expressions, returning the first error among
+// them.
+func list(values []interface{}) ([]parser.Expression, error) {
+	exps := make([]parser.Expression, len(values))
+	var err error
+	for i, v := range values {
+		e := Val(v)
+		exps[i] = e.exp
+		if err == nil {
+			err = e.err
+		}
+	}
+
+	return exps, err
+}
+
+func (e Expr) binary(op parser.Token, other interface{}) Expr {
+	b := Val(other)
+	err := e.err
+	if err == nil {
+		err = b.err
+	}
+
+	return Expr{exp: parser.Expression{
+		Binary: &parser.BinaryExpression{A: e.exp, B: b.exp, Op: op},
+		Type:   parser.BinaryType,
+	}, err: err}
+}
+
+func (e Expr) symbol(op string, other interface{}) Expr {
+	return e.binary(parser.Token{Value: op, Type: parser.SymbolType}, other)
+}
+
+func (e Expr) keyword(op string, other interface{}) Expr {
+	return e.binary(parser.Token{Value: op, Type: parser.KeywordType}, other)
+}
+
+// The comparisons and arithmetic take expressions or values taken by Val.
+
+func (e Expr) Eq(other interface{}) Expr     { return e.symbol("=", other) }
+func (e Expr) Ne(other interface{}) Expr     { return e.symbol("<>", other) }
+func (e Expr) Lt(other interface{}) Expr     { return e.symbol("<", other) }
+func (e Expr) Le(other interface{}) Expr     { return e.symbol("<=", other) }
+func (e Expr) Gt(other interface{}) Expr     { return e.symbol(">", other) }
+func (e Expr) Ge(other interface{}) Expr     { return e.symbol(">=", other) }
+func (e Expr) Plus(other interface{}) Expr   { return e.symbol("+", other) }
+func (e Expr) Minus(other interface{}) Expr  { return e.symbol("-", other) }
+func (e Expr) Times(other interface{}) Expr  { return e.symbol("*", other) }
+func (e Expr) Div(other interface{}) Expr    { return e.symbol("/", other) }
+func (e Expr) Concat(other interface{}) Expr { return e.symbol("||", other) }
+func (e Expr) Like(other interface{}) Expr   { return e.keyword("like", other) }
+func (e Expr) ILike(other interface{}) Expr  { return e.keyword("ilike", other) }
+func (e Expr) And(other interface{}) Expr    { return e.keyword("and", other) }
+func (e Expr) Or(other interface{}) Expr     { return e.keyword("or", other) }
+
+// In is true when e equals any of values.
+func (e Expr) In(values ...interface{}) Expr {
+	return e.in(false, values)
+}
+
+// NotIn is true when e equals none of values.
+func (e Expr) NotIn(values ...interface{}) Expr {
+	return e.in(true, values)
+}
+
+func (e Expr) in(not bool, values []interface{}) Expr {
+	exps, err := list(values)
+	if e.err != nil {
+		err = e.err
+	} else if len(exps) == 0 && err == nil {
+		err = ErrEmptyList
+	}
+
+	return Expr{exp: parser.Expression{
+		In:   &parser.InExpression{Exp: e.exp, List: exps, Not: not},
+		Type: parser.InType,
+	}, err: err}
+}
+
+// Cast converts e to the type called typ, like e::typ.
+func (e Expr) Cast(typ string) Expr {
+	err := e.err
+	t, ok := types.Parse(typ)
+	if !ok && err == nil {
+		err = fmt.Errorf("%w: %q", ErrNoSuchType, typ)
+	}
+
+	// int and text are keywords, the other type names identifiers
+	name := parser.Token{Value: t.String(), Type: parser.IdentifierType}
+	if base := t; t.IsArray() {
+		t = base.Elem()
+	}
+	if t == types.Int || t == types.Text {
+		name.Type = parser.KeywordType
+	}
+
+	return Expr{exp: parser.Expression{
+		Cast: &parser.CastExpression{Exp: e.exp, Type: name},
+		Type: parser.CastType,
+	}, err: err}
+}
+
+// Index is the element of the array e at index, which counts from one.
+func (e Expr) Index(index interface{}) Expr {
+	i := Val(index)
+	err := e.err
+	if err == nil {
+		err = i.err
+	}
+
+	return Expr{exp: parser.Expression{
+		Index: &parser.IndexExpression{Exp: e.exp, Index: i.exp},
+		Type:  parser.IndexType,
+	}, err: err}
+}
+
+// String formats e as SQL.
+func (e Expr) String() string {
+	return e.exp.String()
+}
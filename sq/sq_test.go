@@ -0,0 +1,189 @@
+package sq_test
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/sq"
+	"github.com/nireo/sgsql/types"
+)
+
+func TestSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  func() (string, error)
+		want string
+	}{
+		{
+			"select",
+			sq.Select("id", "name").From("users").Where(sq.Col("age").Ge(18)).SQL,
+			"SELECT id, name FROM users WHERE age >= 18",
+		},
+		{
+			"where twice",
+			sq.Select("*").From("t").Where(sq.Col("a").Eq(1)).Where(sq.Col("b").Lt(sq.Col("c").Plus(2.5))).SQL,
+			"SELECT * FROM t WHERE (a = 1) and (b < (c + 2.5))",
+		},
+		{
+			"column as",
+			sq.Select().ColumnAs(sq.CountAll(), "n").ColumnAs(sq.Call("coalesce", sq.Col("x"), nil), "x").From("t").SQL,
+			"SELECT count(*) AS n, coalesce(x, NULL) AS x FROM t",
+		},
+		{
+			"quoted names",
+			sq.Select("select", "two words").From("from").SQL,
+			`SELECT "select", "two words" FROM "from"`,
+		},
+		{
+			"in",
+			sq.Select("a").From("t").Where(sq.Or(sq.Col("a").In(1, 2), sq.Not(sq.Col("b").NotIn("x")))).SQL,
+			"SELECT a FROM t WHERE (a IN (1, 2)) or (NOT (b NOT IN ('x')))",
+		},
+		{
+			"exists",
+			sq.Select("a").From("t").Where(sq.Exists(sq.Select("*").From("u").Where(sq.Col("b").Eq(sq.Param(1))))).SQL,
+			"SELECT a FROM t WHERE EXISTS (SELECT * FROM u WHERE b = $1)",
+		},
+		{
+			"order by",
+			sq.Select("a", "b").From("t").OrderByDesc(sq.Col("b")).OrderBy(sq.Val(1)).Limit(10).Offset(20).SQL,
+			"SELECT a, b FROM t ORDER BY b DESC, 1 LIMIT 10 OFFSET 20",
+		},
+		{
+			"hint",
+			sq.Select("a").From("t").Hint("nested_loop", "t").SQL,
+			"SELECT /*+ NESTED_LOOP(t) */ a FROM t",
+		},
+		{
+			"insert",
+			sq.Insert("t").Values(1, "it's", sq.Val(2).Times(3), nil).SQL,
+			"INSERT INTO t VALUES (1, 'it''s', 2 * 3, NULL)",
+		},
+		{
+			"cast and index",
+			sq.Select().Column(sq.Col("tags").Cast("text[]").Index(1)).Column(sq.Val("1").Cast("INT")).SQL,
+			"SELECT tags::text[][1], '1'::int",
+		},
+		{
+			"empty and",
+			sq.Select("a").From("t").Where(sq.And()).SQL,
+			"SELECT a FROM t WHERE true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sql()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  func() (string, error)
+		err  error
+	}{
+		{"no columns", sq.Select().From("t").SQL, sq.ErrNoColumns},
+		{"no values", sq.Insert("t").SQL, sq.ErrNoValues},
+		{"hint name", sq.Select("a").Hint("x */ drop").SQL, sq.ErrHintName},
+		{"NaN", sq.Select().Column(sq.Val(math.NaN())).SQL, sq.ErrUnsupportedValue},
+		{"uint64", sq.Insert("t").Values(uint64(math.MaxUint64)).SQL, sq.ErrUnsupportedValue},
+		{"struct", sq.Select().Column(sq.Call("f", struct{}{})).SQL, sq.ErrUnsupportedValue},
+		{"type", sq.Select().Column(sq.Col("a").Cast("money")).SQL, sq.ErrNoSuchType},
+		{"empty in", sq.Select("a").Where(sq.Col("a").In()).SQL, sq.ErrEmptyList},
+		// Errors are kept through whatever is built on the expression
+		{"nested", sq.Select("a").Where(sq.And(sq.Col("a").Eq(1), sq.Not(sq.Col("b").Plus(math.Inf(1))))).SQL, sq.ErrUnsupportedValue},
+		{"subquery", sq.Select().Column(sq.Subquery(sq.Select().Column(sq.Val(math.NaN())))).SQL, sq.ErrUnsupportedValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.sql(); !errors.Is(err, tt.err) {
+				t.Errorf("got %q, %v, want %v", got, err, tt.err)
+			}
+		})
+	}
+}
+
+// TestValues checks that values written as literals read back the same and
+// that no value or name changes the shape of the statement.
+func TestValues(t *testing.T) {
+	db, err := sgsql.Open(sgsql.MemoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	id, err := types.ParseUUID("123e4567-e89b-12d3-a456-426614174000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		v, want interface{}
+	}{
+		{nil, nil},
+		{true, true},
+		{42, int64(42)},
+		{int8(-8), int64(-8)},
+		{int64(math.MinInt64), int64(math.MinInt64)},
+		{int64(math.MaxInt64), int64(math.MaxInt64)},
+		{uint32(7), int64(7)},
+		{-0.25, -0.25},
+		{1e300, 1e300},
+		{"it's -- not a comment", "it's -- not a comment"},
+		{"'); drop table t; --", "'); drop table t; --"},
+		{time.Date(2024, 3, 1, 12, 30, 0, 5000, time.FixedZone("", 3600)), time.Date(2024, 3, 1, 11, 30, 0, 5000, time.UTC)},
+		{types.IntervalValue{Months: 14, Duration: -90 * time.Minute}, types.IntervalValue{Months: 14, Duration: -90 * time.Minute}},
+		{id, id},
+	}
+
+	for _, tt := range tests {
+		query, err := sq.Select().Column(sq.Val(tt.v)).SQL()
+		if err != nil {
+			t.Fatalf("%v: %v", tt.v, err)
+		}
+
+		results, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if len(results.Rows) != 1 || !reflect.DeepEqual(results.Rows[0][0], tt.want) {
+			t.Errorf("%s: got %v, want %v", query, results.Rows, tt.want)
+		}
+	}
+
+	// Names are quoted rather than read as SQL
+	if err := db.Exec(`create table "a"" b" ("x y" text)`); err != nil {
+		t.Fatal(err)
+	}
+	insert, err := sq.Insert(`a" b`).Values("'); drop table t; --").SQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(insert); err != nil {
+		t.Fatalf("%s: %v", insert, err)
+	}
+	query, err := sq.Select("x y").From(`a" b`).Where(sq.Col("x y").Like("%drop%")).SQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := db.Query(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	if want := [][]interface{}{{"'); drop table t; --"}}; !reflect.DeepEqual(results.Rows, want) {
+		t.Errorf("%s: got %v, want %v", query, results.Rows, want)
+	}
+}
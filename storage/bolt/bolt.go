@@ -0,0 +1,770 @@
+// Package bolt is a storage engine keeping the rows of tables in a bbolt
+// file instead of in memory, for tables larger than the memory of the
+// machine. Importing it registers it as "bolt", which a database is opened
+// with like this:
+//
+//	import _ "github.com/nireo/sgsql/storage/bolt"
+//
+//	db, err := sgsql.Open("app.db", sgsql.WithEngine("bolt"))
+//
+// The file is where the rows are kept. Every insert is synced to it, and
+// vacuuming the database checkpoints it: the statement log is rewritten to
+// hold the schema without the rows, which are read back from the file when
+// the database is opened again instead of being inserted by the log.
+//
+// Rows are read from the file a page at a time into a buffer pool shared by
+// every table, of storage.DefaultBufferPoolSize bytes until it is resized,
+// see storage.BufferPool. The pages of tables created with a compressor are
+// written to the file compressed, see storage.Compressing.
+package bolt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nireo/sgsql/storage"
+	bbolt "go.etcd.io/bbolt"
+)
+
+var (
+	errNoPath            = errors.New("The bolt storage engine needs a file, it can't store a database kept in memory")
+	errNoCheckpoint      = errors.New("Checkpoint is not in the storage file")
+	errCorruptCheckpoint = errors.New("Checkpoint in the storage file is corrupt")
+)
+
+func init() {
+	storage.Register("bolt", func(opts storage.Options) (storage.Engine, error) {
+		if opts.Path == "" {
+			return nil, errNoPath
+		}
+		if opts.ReadOnly {
+			return OpenReadOnly(opts.Path)
+		}
+		return Open(opts.Path)
+	})
+}
+
+// scanBatch is how many rows an iterator reads in each read transaction of
+// the file.
+const scanBatch = 256
+
+// checkpointsBucket holds the checkpoints by their ids. The buckets of
+// tables are named by numbers, so it can't be one of them.
+var checkpointsBucket = []byte("checkpoints")
+
+// Engine stores each table in a bucket of its own, named by a number rather
+// than the table, so a table dropped and created again gets a new bucket.
+// The buckets of dropped tables are kept until the engine is recovered
+// again, since a snapshot from before the drop can bring the table back, the
+// history of tables read AS OF TIMESTAMP can still scan it and a checkpoint
+// may hold it.
+type Engine struct {
+	db       *bbolt.DB
+	readOnly bool
+	tables   map[string]*table
+	// recovered are the tables of the checkpoint the engine was recovered
+	// from that weren't created yet
+	recovered map[string]*table
+	last      uint64
+	pool      *pool
+}
+
+// Open opens the file at path, creating it if it doesn't exist. Recover
+// must be called before any table is created.
+func Open(path string) (*Engine, error) {
+	return open(path, false)
+}
+
+// OpenReadOnly opens the file at path without ever writing to it. Rows
+// inserted into the tables are kept in memory after those of the file.
+func OpenReadOnly(path string) (*Engine, error) {
+	return open(path, true)
+}
+
+func open(path string, readOnly bool) (*Engine, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second, NoFreelistSync: true, ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{
+		db:        db,
+		readOnly:  readOnly,
+		tables:    map[string]*table{},
+		recovered: map[string]*table{},
+		pool:      newPool(storage.DefaultBufferPoolSize),
+	}
+	err = db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if n, err := strconv.ParseUint(string(name), 10, 64); err == nil && n > e.last {
+				e.last = n
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Close closes the file. The engine can't be used after.
+func (e *Engine) Close() error {
+	return e.db.Close()
+}
+
+// SetBufferPoolSize sets the bytes of rows the buffer pool holds, see
+// storage.BufferPool.
+func (e *Engine) SetBufferPoolSize(bytes int64) {
+	e.pool.resize(bytes)
+}
+
+func (e *Engine) BufferPoolStats() storage.BufferPoolStats {
+	return e.pool.statistics()
+}
+
+// page returns the rows of the table stored in bucket from the first row
+// of a page to end, which is at most the first row of the next page, from
+// the pool or else from the file. c is the compressor of the table, nil if
+// it isn't compressed. A row that can't be read, or is missing from the
+// file, fails it with an error.
+func (e *Engine) page(bucket []byte, c storage.Compressor, first, end storage.RowID) ([]storage.Row, error) {
+	key, n := pageKey{bucket: string(bucket), page: first / pageRows}, int(end-first)
+	if rows := e.pool.get(key, n); rows != nil {
+		return rows, nil
+	}
+
+	var rows []storage.Row
+	var size int64
+	err := e.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		if c != nil {
+			rows, size, err = readPage(tx.Bucket(bucket), c, first)
+		} else {
+			rows, size, err = readRows(tx.Bucket(bucket), first, end)
+		}
+		return err
+	})
+	if err == nil && len(rows) < n {
+		err = errCorruptRow
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A compressed page may hold rows a restore forgot after them
+	rows = rows[:n:n]
+	e.pool.put(key, rows, size)
+	return rows, nil
+}
+
+// readRows reads the rows of b from first up to end, and returns the bytes
+// they take.
+func readRows(b *bbolt.Bucket, first, end storage.RowID) ([]storage.Row, int64, error) {
+	rows := make([]storage.Row, 0, end-first)
+	size := int64(0)
+	c := b.Cursor()
+	for k, v := c.Seek(rowKey(first)); k != nil && len(rows) < int(end-first); k, v = c.Next() {
+		if storage.RowID(binary.BigEndian.Uint64(k)) != first+storage.RowID(len(rows)) {
+			return nil, 0, errCorruptRow
+		}
+
+		row, err := decodeRow(v)
+		if err != nil {
+			return nil, 0, err
+		}
+		rows = append(rows, row)
+		size += int64(len(v))
+	}
+
+	return rows, size, nil
+}
+
+// readPage reads the page of b starting at first, compressed with c, and
+// returns the bytes it takes.
+func readPage(b *bbolt.Bucket, c storage.Compressor, first storage.RowID) ([]storage.Row, int64, error) {
+	v := b.Get(rowKey(first))
+	if v == nil {
+		return nil, 0, errCorruptRow
+	}
+
+	data, err := c.Decompress(nil, v)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errCorruptRow, err)
+	}
+
+	rows, err := decodeRows(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rows, int64(len(v)), nil
+}
+
+// Checkpoint records how many rows the tables called names hold, see
+// storage.Checkpointer. The rows were synced to the file as they were
+// inserted, so only the record is left to write.
+func (e *Engine) Checkpoint(id string, names []string) error {
+	tables := make([]*table, len(names))
+	for i, name := range names {
+		t, ok := e.tables[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", storage.ErrNoSuchTable, name)
+		}
+		if err := t.detach(); err != nil {
+			return err
+		}
+		tables[i] = t
+	}
+
+	err := e.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(checkpointsBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), encodeCheckpoint(names, tables))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tables {
+		t.durable = t.rows
+	}
+	return nil
+}
+
+// Recover brings back the tables of the checkpoint id, see
+// storage.Checkpointer. Unless the file is read-only, every other
+// checkpoint and bucket is deleted from it.
+func (e *Engine) Recover(id string) error {
+	tables := map[string]*table{}
+	load := func(tx *bbolt.Tx) error {
+		if id == "" {
+			return nil
+		}
+
+		var data []byte
+		if b := tx.Bucket(checkpointsBucket); b != nil {
+			data = b.Get([]byte(id))
+		}
+		if data == nil {
+			return fmt.Errorf("%w: %s", errNoCheckpoint, id)
+		}
+
+		var err error
+		if tables, err = e.decodeCheckpoint(data); err != nil {
+			return err
+		}
+		for _, t := range tables {
+			if tx.Bucket(t.bucket) == nil {
+				return errCorruptCheckpoint
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if e.readOnly {
+		err = e.db.View(load)
+	} else {
+		err = e.db.Update(func(tx *bbolt.Tx) error {
+			if err := load(tx); err != nil {
+				return err
+			}
+			return discard(tx, id, tables)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	e.recovered = tables
+	return nil
+}
+
+// discard deletes every bucket of tx but those of tables, and every
+// checkpoint but id.
+func discard(tx *bbolt.Tx, id string, tables map[string]*table) error {
+	kept := map[string]bool{}
+	for _, t := range tables {
+		kept[string(t.bucket)] = true
+	}
+
+	var checkpoint []byte
+	if b := tx.Bucket(checkpointsBucket); b != nil && id != "" {
+		checkpoint = append([]byte{}, b.Get([]byte(id))...)
+	}
+
+	names := [][]byte{}
+	if err := tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+		if !kept[string(name)] {
+			names = append(names, append([]byte{}, name...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+
+	if checkpoint == nil {
+		return nil
+	}
+	b, err := tx.CreateBucket(checkpointsBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(id), checkpoint)
+}
+
+// encodeCheckpoint encodes the name, bucket, number of rows and compressor
+// of each of tables, called names.
+func encodeCheckpoint(names []string, tables []*table) []byte {
+	b := []byte{}
+	for i, t := range tables {
+		b = appendUvarint(b, uint64(len(names[i])))
+		b = append(b, names[i]...)
+		b = appendUvarint(b, uint64(len(t.bucket)))
+		b = append(b, t.bucket...)
+		b = appendUvarint(b, uint64(t.rows))
+		b = appendUvarint(b, uint64(len(t.compressor)))
+		b = append(b, t.compressor...)
+	}
+
+	return b
+}
+
+// decodeCheckpoint decodes the tables encoded by encodeCheckpoint, by their
+// names.
+func (e *Engine) decodeCheckpoint(b []byte) (map[string]*table, error) {
+	bytes := func() ([]byte, bool) {
+		n, size := binary.Uvarint(b)
+		if size <= 0 || n > uint64(len(b)-size) {
+			return nil, false
+		}
+		data := append([]byte{}, b[size:size+int(n)]...)
+		b = b[size+int(n):]
+		return data, true
+	}
+
+	tables := map[string]*table{}
+	for len(b) > 0 {
+		name, ok := bytes()
+		if !ok {
+			return nil, errCorruptCheckpoint
+		}
+		bucket, ok := bytes()
+		if !ok {
+			return nil, errCorruptCheckpoint
+		}
+		rows, size := binary.Uvarint(b)
+		if size <= 0 {
+			return nil, errCorruptCheckpoint
+		}
+		b = b[size:]
+		compressor, ok := bytes()
+		if !ok {
+			return nil, errCorruptCheckpoint
+		}
+
+		t := &table{e: e, bucket: bucket, rows: int(rows), durable: int(rows), compressor: string(compressor)}
+		if len(compressor) > 0 {
+			var err error
+			if t.c, err = storage.LookupCompressor(t.compressor); err != nil {
+				return nil, err
+			}
+		}
+		tables[string(name)] = t
+	}
+
+	return tables, nil
+}
+
+func (e *Engine) CreateTable(name string) (storage.Table, error) {
+	return e.createTable(name, "", nil)
+}
+
+// CreateCompressedTable creates a table whose pages are compressed with
+// the compressor registered under compressor, see storage.Compressing.
+func (e *Engine) CreateCompressedTable(name, compressor string) (storage.Table, error) {
+	c, err := storage.LookupCompressor(compressor)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.createTable(name, compressor, c)
+}
+
+// createTable creates a table whose pages are compressed with c, registered
+// under compressor, or not compressed when c is nil.
+func (e *Engine) createTable(name, compressor string, c storage.Compressor) (storage.Table, error) {
+	if _, ok := e.tables[name]; ok {
+		return nil, storage.ErrTableExists
+	}
+
+	// The table comes back with the rows the checkpoint holds of it, in
+	// the pages it had then
+	if t, ok := e.recovered[name]; ok {
+		delete(e.recovered, name)
+		e.tables[name] = t
+		return t, nil
+	}
+
+	t := &table{e: e, compressor: compressor, c: c}
+	if !e.readOnly {
+		err := e.db.Update(func(tx *bbolt.Tx) error {
+			var err error
+			t.bucket, err = e.createBucket(tx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.tables[name] = t
+	return t, nil
+}
+
+// createBucket creates the bucket after the last one.
+func (e *Engine) createBucket(tx *bbolt.Tx) ([]byte, error) {
+	e.last++
+	bucket := []byte(strconv.FormatUint(e.last, 10))
+	if _, err := tx.CreateBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	return bucket, nil
+}
+
+func (e *Engine) DropTable(name string) error {
+	if _, ok := e.tables[name]; !ok {
+		return storage.ErrNoSuchTable
+	}
+
+	delete(e.tables, name)
+	return nil
+}
+
+func (e *Engine) Table(name string) (storage.Table, bool) {
+	t, ok := e.tables[name]
+	return t, ok
+}
+
+// snapshot holds the tables and how many rows each had. Rows are only ever
+// appended, so restoring a table only takes forgetting the rows after them,
+// which the next insert overwrites.
+type snapshot struct {
+	tables map[string]*table
+	rows   map[*table]int
+}
+
+func (e *Engine) Snapshot() storage.Snapshot {
+	s := snapshot{tables: map[string]*table{}, rows: map[*table]int{}}
+	for name, t := range e.tables {
+		s.tables[name] = t
+		s.rows[t] = t.rows
+	}
+
+	return s
+}
+
+func (e *Engine) Restore(s storage.Snapshot) {
+	saved := s.(snapshot)
+	e.tables = map[string]*table{}
+	for name, t := range saved.tables {
+		t.rows = saved.rows[t]
+		e.tables[name] = t
+	}
+}
+
+// table holds its rows under their ids as big-endian numbers, so they are
+// kept in the order they were inserted. Keys from rows an engine restore
+// forgot may follow the first rows, which are the ones the table holds.
+// A compressed table holds its pages instead, each under the id of its
+// first row, encoded by encodeRows and compressed. The last page may hold
+// rows a restore forgot after those of the table.
+type table struct {
+	e *Engine
+	// compressor is the name c is registered under, empty and nil for
+	// tables that aren't compressed
+	compressor string
+	c          storage.Compressor
+	// bucket is nil for the tables of a read-only file created after it
+	// was opened
+	bucket []byte
+	rows   int
+	// durable is how many of the first rows a checkpoint holds, which
+	// inserting mustn't overwrite
+	durable int
+	// tail holds the rows after the durable ones of a read-only file,
+	// which can't be written to it
+	tail  []storage.Row
+	hooks []storage.IndexHook
+}
+
+func rowKey(id storage.RowID) []byte {
+	return appendUint64(nil, uint64(id))
+}
+
+// inFile returns how many of the first rows of t are read from its file.
+func (t *table) inFile() int {
+	if t.e.readOnly && t.durable < t.rows {
+		return t.durable
+	}
+
+	return t.rows
+}
+
+func (t *table) Insert(rows ...storage.Row) error {
+	first := t.rows
+	var err error
+	if t.e.readOnly {
+		err = t.keep(rows)
+	} else {
+		err = t.write(rows)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.rows += len(rows)
+	for i, row := range rows {
+		for _, hook := range t.hooks {
+			hook.Inserted(storage.RowID(first+i), row)
+		}
+	}
+
+	return nil
+}
+
+// write writes rows to the file after those of t.
+func (t *table) write(rows []storage.Row) error {
+	if err := t.detach(); err != nil {
+		return err
+	}
+
+	// The rows may take the ids of rows a restore forgot, whose pages the
+	// pool mustn't serve anymore
+	defer t.e.pool.invalidate(t.bucket, storage.RowID(t.rows), storage.RowID(t.rows+len(rows)))
+
+	if t.c != nil {
+		return t.writePages(rows)
+	}
+
+	return t.e.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(t.bucket)
+		for i, row := range rows {
+			value, err := encodeRow(row)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(rowKey(storage.RowID(t.rows+i)), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writePages writes rows to the compressed pages of t after its rows. The
+// last page of t is written again with the rows it holds followed by the
+// first of rows.
+func (t *table) writePages(rows []storage.Row) error {
+	first := storage.RowID(t.rows - t.rows%pageRows)
+	if first < storage.RowID(t.rows) {
+		held, err := t.e.page(t.bucket, t.c, first, storage.RowID(t.rows))
+		if err != nil {
+			return err
+		}
+		// held may be shared by the pool, appending copies it
+		rows = append(held[:len(held):len(held)], rows...)
+	}
+
+	return t.e.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(t.bucket)
+		for i := 0; i < len(rows); i += pageRows {
+			end := i + pageRows
+			if end > len(rows) {
+				end = len(rows)
+			}
+
+			data, err := encodeRows(rows[i:end])
+			if err != nil {
+				return err
+			}
+			if err := b.Put(rowKey(first+storage.RowID(i)), t.c.Compress(nil, data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// detach copies the rows of t to a new bucket once a restore forgot rows a
+// checkpoint holds, which inserting would overwrite otherwise.
+func (t *table) detach() error {
+	if t.e.readOnly || t.rows >= t.durable {
+		return nil
+	}
+
+	var bucket []byte
+	err := t.e.db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		if bucket, err = t.e.createBucket(tx); err != nil {
+			return err
+		}
+
+		step := storage.RowID(1)
+		if t.c != nil {
+			step = pageRows
+		}
+
+		from, to := tx.Bucket(t.bucket), tx.Bucket(bucket)
+		for id := storage.RowID(0); id < storage.RowID(t.rows); id += step {
+			if err := to.Put(rowKey(id), from.Get(rowKey(id))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	t.bucket, t.durable = bucket, 0
+	return nil
+}
+
+// keep keeps rows in memory after those of t, for a read-only file.
+func (t *table) keep(rows []storage.Row) error {
+	for _, row := range rows {
+		if _, err := encodeRow(row); err != nil {
+			return err
+		}
+	}
+
+	// Once a restore forgot rows of the file, the ones left move to memory
+	// as well
+	if t.rows < t.durable {
+		kept := []storage.Row{}
+		scan := t.Scan()
+		for _, row, ok := scan.Next(); ok; _, row, ok = scan.Next() {
+			kept = append(kept, row)
+		}
+		if err := scan.Err(); err != nil {
+			return err
+		}
+		t.bucket, t.durable, t.tail = nil, 0, kept
+	}
+
+	// A restore may have forgotten rows of the tail, which scans started
+	// before it may still read
+	n := t.rows - t.durable
+	if n < len(t.tail) {
+		t.tail = t.tail[:n:n]
+	}
+	t.tail = append(t.tail, rows...)
+	return nil
+}
+
+func (t *table) Scan() storage.Iterator {
+	end := t.inFile()
+	return &iterator{bucket: t.bucket, c: t.c, e: t.e, end: storage.RowID(end), tail: t.tail[:t.rows-end]}
+}
+
+func (t *table) Lookup(id storage.RowID) (storage.Row, bool, error) {
+	if id < 0 || id >= storage.RowID(t.rows) {
+		return nil, false, nil
+	}
+	if end := storage.RowID(t.inFile()); id >= end {
+		return t.tail[id-end], true, nil
+	}
+
+	// The whole page is read, the rows next to one looked up are often
+	// looked up next
+	first := id - id%pageRows
+	end := first + pageRows
+	if inFile := storage.RowID(t.inFile()); end > inFile {
+		end = inFile
+	}
+	rows, err := t.e.page(t.bucket, t.c, first, end)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return rows[id-first], true, nil
+}
+
+func (t *table) Len() int {
+	return t.rows
+}
+
+func (t *table) AddIndexHook(hook storage.IndexHook) {
+	t.hooks = append(t.hooks, hook)
+}
+
+// iterator reads the rows of the file before end a page at a time, then
+// those of tail.
+type iterator struct {
+	e      *Engine
+	bucket []byte
+	c      storage.Compressor
+	end    storage.RowID
+	tail   []storage.Row
+	next   storage.RowID
+	batch  []storage.Row
+	// first is the id of the first row of batch
+	first storage.RowID
+	err   error
+}
+
+func (it *iterator) Next() (storage.RowID, storage.Row, bool) {
+	if it.err != nil {
+		return 0, nil, false
+	}
+
+	if it.next >= it.end {
+		i := int(it.next - it.end)
+		if i >= len(it.tail) {
+			return 0, nil, false
+		}
+		it.next++
+		return it.end + storage.RowID(i), it.tail[i], true
+	}
+
+	if int(it.next-it.first) >= len(it.batch) {
+		if !it.read() {
+			return 0, nil, false
+		}
+	}
+
+	id := it.next
+	it.next++
+	return id, it.batch[id-it.first], true
+}
+
+func (it *iterator) Err() error {
+	return it.err
+}
+
+// read reads the page of the row next. A row that can't be read ends the
+// scan with an error.
+func (it *iterator) read() bool {
+	it.first = it.next - it.next%pageRows
+	end := it.first + pageRows
+	if end > it.end {
+		end = it.end
+	}
+
+	it.batch, it.err = it.e.page(it.bucket, it.c, it.first, end)
+	return it.err == nil
+}
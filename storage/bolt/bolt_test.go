@@ -0,0 +1,444 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+	bbolt "go.etcd.io/bbolt"
+)
+
+func testEngine(t *testing.T, path string) *Engine {
+	t.Helper()
+
+	e, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	if err := e.Recover(""); err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func scan(t *testing.T, tbl storage.Table) []storage.Row {
+	t.Helper()
+
+	rows := []storage.Row{}
+	it := tbl.Scan()
+	for _, row, ok := it.Next(); ok; _, row, ok = it.Next() {
+		rows = append(rows, row)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return rows
+}
+
+func TestEncodeRow(t *testing.T) {
+	row := storage.Row{
+		nil, int64(-3), 1.5, true, false, "text",
+		time.Date(2024, 3, 1, 12, 30, 0, 5, time.UTC),
+		types.IntervalValue{Months: -2, Duration: 90 * time.Minute},
+		types.UUIDValue{1, 2, 3, 15: 16},
+		types.Array{Elem: types.Int, Values: []interface{}{int64(1), nil}},
+		types.Array{Elem: types.Text, Values: []interface{}{}},
+	}
+
+	b, err := encodeRow(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeRow(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, row) {
+		t.Errorf("got %#v, want %#v", got, row)
+	}
+
+	for i := 0; i < len(b); i++ {
+		if _, err := decodeRow(b[:i]); err == nil {
+			t.Errorf("decoded the first %d bytes of the row", i)
+		}
+	}
+
+	if _, err := encodeRow(storage.Row{struct{}{}}); err == nil {
+		t.Error("encoded a value of an unknown type")
+	}
+}
+
+func TestEngine(t *testing.T) {
+	e := testEngine(t, filepath.Join(t.TempDir(), "rows.bolt"))
+
+	tbl, err := e.CreateTable("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CreateTable("t"); err != storage.ErrTableExists {
+		t.Errorf("creating t again: got %v, want %v", err, storage.ErrTableExists)
+	}
+
+	// Enough rows for scans to read more than one batch
+	want := []storage.Row{}
+	for i := 0; i < scanBatch*2+10; i++ {
+		want = append(want, storage.Row{int64(i), "row"})
+	}
+	if err := tbl.Insert(want...); err != nil {
+		t.Fatal(err)
+	}
+	if got := scan(t, tbl); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %d rows, want %d", len(got), len(want))
+	}
+	if row, ok, err := tbl.Lookup(scanBatch + 1); err != nil || !ok || !reflect.DeepEqual(row, want[scanBatch+1]) {
+		t.Errorf("looked up %v, want %v", row, want[scanBatch+1])
+	}
+	if _, ok, _ := tbl.Lookup(storage.RowID(len(want))); ok {
+		t.Error("looked up a row after the last one")
+	}
+
+	// Rows that can't be stored insert nothing
+	if err := tbl.Insert(storage.Row{int64(-1)}, storage.Row{struct{}{}}); err == nil {
+		t.Error("inserted a value of an unknown type")
+	}
+	if tbl.Len() != len(want) {
+		t.Errorf("t holds %d rows after a failed insert, want %d", tbl.Len(), len(want))
+	}
+
+	s := e.Snapshot()
+	if err := tbl.Insert(storage.Row{int64(-1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.DropTable("t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.Table("t"); ok {
+		t.Error("t exists after it was dropped")
+	}
+
+	e.Restore(s)
+	restored, ok := e.Table("t")
+	if !ok || restored != tbl {
+		t.Fatal("restoring the snapshot didn't bring t back")
+	}
+	if got := scan(t, tbl); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %d rows after restoring, want %d", len(got), len(want))
+	}
+
+	// Inserting after the restore replaces the rows it forgot
+	if err := tbl.Insert(storage.Row{int64(-2)}); err != nil {
+		t.Fatal(err)
+	}
+	if row, _, _ := tbl.Lookup(storage.RowID(len(want))); !reflect.DeepEqual(row, storage.Row{int64(-2)}) {
+		t.Errorf("looked up %v after inserting, want [-2]", row)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rows.bolt")
+	rows := func(from, to int) []storage.Row {
+		rows := []storage.Row{}
+		for i := from; i < to; i++ {
+			rows = append(rows, storage.Row{int64(i)})
+		}
+		return rows
+	}
+
+	e := testEngine(t, path)
+	tbl, err := e.CreateTable("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CreateTable("u"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(rows(0, 3)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Checkpoint("a", []string{"t"}); err != nil {
+		t.Fatal(err)
+	}
+	s := e.Snapshot()
+	if err := tbl.Insert(rows(3, 5)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Checkpoint("b", []string{"t"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inserting after a restore forgot rows of b leaves them in the file
+	e.Restore(s)
+	if err := tbl.Insert(storage.Row{int64(-1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := scan(t, tbl), append(rows(0, 3), storage.Row{int64(-1)}); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %v, want %v", got, want)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovering a writable file discards the other checkpoints
+	tests := []struct {
+		id       string
+		readOnly bool
+		rows     []storage.Row
+		err      error
+	}{
+		{"a", true, rows(0, 3), nil},
+		{"b", true, rows(0, 5), nil},
+		{"b", false, rows(0, 5), nil},
+		{"a", false, nil, errNoCheckpoint},
+		{"", false, []storage.Row{}, nil},
+	}
+
+	for _, tt := range tests {
+		open := Open
+		if tt.readOnly {
+			open = OpenReadOnly
+		}
+		e, err := open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := e.Recover(tt.id); !errors.Is(err, tt.err) {
+			t.Errorf("recovering %q: got %v, want %v", tt.id, err, tt.err)
+		}
+		if tt.err == nil {
+			tbl, err := e.CreateTable("t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := scan(t, tbl); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("recovering %q: t holds %v, want %v", tt.id, got, tt.rows)
+			}
+
+			// A read-only file keeps the rows inserted after its own in
+			// memory
+			if err := tbl.Insert(storage.Row{int64(-2)}); err != nil {
+				t.Fatal(err)
+			}
+			if row, ok, err := tbl.Lookup(storage.RowID(len(tt.rows))); err != nil || !ok || !reflect.DeepEqual(row, storage.Row{int64(-2)}) {
+				t.Errorf("recovering %q: looked up %v, want [-2]", tt.id, row)
+			}
+
+			u, err := e.CreateTable("u")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Len() != 0 {
+				t.Errorf("recovering %q: u holds %d rows, want 0", tt.id, u.Len())
+			}
+		}
+
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCorruptRow(t *testing.T) {
+	e := testEngine(t, filepath.Join(t.TempDir(), "rows.bolt"))
+	tbl, err := e.CreateTable("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(storage.Row{int64(1)}, storage.Row{int64(2)}, storage.Row{int64(3)}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tbl.(*table).bucket).Put(rowKey(1), []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := tbl.Scan()
+	for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+	}
+	if !errors.Is(it.Err(), storage.ErrCorrupt) {
+		t.Errorf("scanning the rows: got %v, want %v", it.Err(), storage.ErrCorrupt)
+	}
+	if _, _, err := tbl.Lookup(1); !errors.Is(err, storage.ErrCorrupt) {
+		t.Errorf("looking up the row: got %v, want %v", err, storage.ErrCorrupt)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	e := testEngine(t, filepath.Join(t.TempDir(), "rows.bolt"))
+	mb := backend.NewBackend(e)
+	session := functions.NewSession(mb)
+
+	run := func(query string) *backend.Results {
+		t.Helper()
+
+		ast, err := parser.Parse(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var results *backend.Results
+		for _, stmt := range ast.Statements {
+			if results, err = backend.Exec(context.Background(), mb, stmt, session, nil); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+		}
+		return results
+	}
+
+	run("create table t (id int, name text, tags text[]); create index t_id on t (id)")
+	run("insert into t values (1, 'a', array['x']); insert into t values (2, 'b', null); insert into t values (3, 'c', array['y', 'z'])")
+	s := mb.Snapshot()
+	run("insert into t values (4, 'd', null)")
+	mb.Restore(s)
+
+	tests := []struct {
+		query string
+		rows  [][]interface{}
+	}{
+		{"select count(*) from t", [][]interface{}{{int64(3)}}},
+		{"select name from t where id = 3", [][]interface{}{{"c"}}},
+		{"select tags[2] from t where name = 'c'", [][]interface{}{{"z"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := run(tt.query).Rows; !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("got %v, want %v", got, tt.rows)
+			}
+		})
+	}
+
+	// A row that can't be read fails the query instead of ending it early.
+	// The pool is emptied for the rows to be read from the file again
+	e.SetBufferPoolSize(0)
+	tbl, _ := e.Table("t")
+	err := e.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tbl.(*table).bucket).Put(rowKey(1), []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"select count(*) from t", "select name from t", "select name from t where id = 2"} {
+		ast, err := parser.Parse(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.Exec(context.Background(), mb, ast.Statements[0], session, nil); !errors.Is(err, storage.ErrCorrupt) {
+			t.Errorf("%s: got %v, want %v", query, err, storage.ErrCorrupt)
+		}
+	}
+}
+
+func TestCompressedTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rows.bolt")
+	e := testEngine(t, path)
+	if _, err := e.CreateCompressedTable("t", "paper"); !errors.Is(err, storage.ErrNoSuchCompressor) {
+		t.Errorf("creating t with an unknown compressor: got %v, want %v", err, storage.ErrNoSuchCompressor)
+	}
+
+	compressed, err := e.CreateCompressedTable("t", "lz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := e.CreateTable("u")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Inserted one by one, which writes the last page again each time,
+	// into more than one page
+	want := []storage.Row{}
+	for i := 0; i < pageRows+10; i++ {
+		row := storage.Row{int64(i), "the same text over and over again"}
+		for _, tbl := range []storage.Table{compressed, plain} {
+			if err := tbl.Insert(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want = append(want, row)
+	}
+
+	e.SetBufferPoolSize(0)
+	if got := scan(t, compressed); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %d rows, want %d", len(got), len(want))
+	}
+	if row, ok, err := compressed.Lookup(pageRows + 1); err != nil || !ok || !reflect.DeepEqual(row, want[pageRows+1]) {
+		t.Errorf("looked up %v, want %v", row, want[pageRows+1])
+	}
+
+	// The pool holds the pages as they are in the file
+	sizes := map[string]int64{}
+	for name, tbl := range map[string]storage.Table{"t": compressed, "u": plain} {
+		e.SetBufferPoolSize(storage.DefaultBufferPoolSize)
+		scan(t, tbl)
+		sizes[name] = e.BufferPoolStats().Used
+		e.SetBufferPoolSize(0)
+	}
+	if sizes["t"]*4 > sizes["u"] {
+		t.Errorf("t takes %d bytes, u without compression %d", sizes["t"], sizes["u"])
+	}
+
+	// A restore forgetting rows of the last page leaves them in it until
+	// the page is written again
+	s := e.Snapshot()
+	if err := compressed.Insert(storage.Row{int64(-1), "forgotten"}); err != nil {
+		t.Fatal(err)
+	}
+	e.Restore(s)
+	if err := compressed.Insert(storage.Row{int64(-2), "kept"}); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, storage.Row{int64(-2), "kept"})
+	if got := scan(t, compressed); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %v after the restore, want %v", got[len(got)-1], want[len(want)-1])
+	}
+
+	// The checkpoint keeps which compressor the pages were written with
+	if err := e.Checkpoint("a", []string{"t"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	e, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if err := e.Recover("a"); err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := e.CreateTable("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scan(t, tbl); !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %d rows after recovering, want %d", len(got), len(want))
+	}
+
+	// A page that can't be decompressed fails the scan
+	err = e.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tbl.(*table).bucket).Put(rowKey(0), []byte{1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetBufferPoolSize(0)
+	if _, _, err := tbl.Lookup(1); !errors.Is(err, storage.ErrCorrupt) {
+		t.Errorf("looking up a row of a corrupt page: got %v, want %v", err, storage.ErrCorrupt)
+	}
+}
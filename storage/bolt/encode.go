@@ -0,0 +1,239 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nireo/sgsql/storage"
+	"github.com/nireo/sgsql/types"
+)
+
+var errCorruptRow = storage.ErrCorrupt
+
+// The tags written before each value, telling how the value is encoded.
+const (
+	tagNull byte = iota
+	tagInt
+	tagFloat
+	tagBool
+	tagText
+	tagTimestamp
+	tagInterval
+	tagUUID
+	tagArray
+)
+
+// encodeRow encodes row as its number of values followed by each value.
+func encodeRow(row storage.Row) ([]byte, error) {
+	b := appendUvarint(nil, uint64(len(row)))
+	return encodeValues(b, row)
+}
+
+func encodeValues(b []byte, values []interface{}) ([]byte, error) {
+	for _, v := range values {
+		var err error
+		if b, err = encodeValue(b, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+func encodeValue(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, tagNull), nil
+	case int64:
+		return appendUint64(append(b, tagInt), uint64(v)), nil
+	case float64:
+		return appendUint64(append(b, tagFloat), math.Float64bits(v)), nil
+	case bool:
+		if v {
+			return append(b, tagBool, 1), nil
+		}
+		return append(b, tagBool, 0), nil
+	case string:
+		b = appendUvarint(append(b, tagText), uint64(len(v)))
+		return append(b, v...), nil
+	case time.Time:
+		t, err := v.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		b = appendUvarint(append(b, tagTimestamp), uint64(len(t)))
+		return append(b, t...), nil
+	case types.IntervalValue:
+		b = appendUint64(append(b, tagInterval), uint64(v.Months))
+		return appendUint64(b, uint64(v.Duration)), nil
+	case types.UUIDValue:
+		return append(append(b, tagUUID), v[:]...), nil
+	case types.Array:
+		b = appendUvarint(append(b, tagArray), uint64(v.Elem))
+		b = appendUvarint(b, uint64(len(v.Values)))
+		return encodeValues(b, v.Values)
+	}
+
+	return nil, fmt.Errorf("Can't store values of type %T", v)
+}
+
+// encodeRows encodes the number of rows followed by each of them encoded
+// like encodeRow does, the way the pages of compressed tables hold them.
+func encodeRows(rows []storage.Row) ([]byte, error) {
+	b := appendUvarint(nil, uint64(len(rows)))
+	for _, row := range rows {
+		var err error
+		if b, err = encodeValues(appendUvarint(b, uint64(len(row))), row); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// decodeRows decodes the rows encoded by encodeRows.
+func decodeRows(b []byte) ([]storage.Row, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || n > uint64(len(b)) {
+		return nil, errCorruptRow
+	}
+	b = b[size:]
+
+	rows := make([]storage.Row, n)
+	for i := range rows {
+		values, size := binary.Uvarint(b)
+		if size <= 0 || values > uint64(len(b)) {
+			return nil, errCorruptRow
+		}
+
+		var err error
+		if rows[i], b, err = decodeValues(b[size:], int(values)); err != nil {
+			return nil, err
+		}
+	}
+	if len(b) != 0 {
+		return nil, errCorruptRow
+	}
+
+	return rows, nil
+}
+
+// decodeRow decodes a row encoded by encodeRow.
+func decodeRow(b []byte) (storage.Row, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || n > uint64(len(b)) {
+		return nil, errCorruptRow
+	}
+
+	row, rest, err := decodeValues(b[size:], int(n))
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errCorruptRow
+	}
+
+	return row, nil
+}
+
+func decodeValues(b []byte, n int) ([]interface{}, []byte, error) {
+	values := make([]interface{}, n)
+	for i := range values {
+		var err error
+		if values[i], b, err = decodeValue(b); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return values, b, nil
+}
+
+// decodeValue decodes the value at the start of b and returns what follows
+// it.
+func decodeValue(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errCorruptRow
+	}
+
+	tag, b := b[0], b[1:]
+	switch tag {
+	case tagNull:
+		return nil, b, nil
+	case tagInt, tagFloat:
+		if len(b) < 8 {
+			return nil, nil, errCorruptRow
+		}
+		bits := binary.BigEndian.Uint64(b)
+		if tag == tagFloat {
+			return math.Float64frombits(bits), b[8:], nil
+		}
+		return int64(bits), b[8:], nil
+	case tagBool:
+		if len(b) < 1 {
+			return nil, nil, errCorruptRow
+		}
+		return b[0] == 1, b[1:], nil
+	case tagText, tagTimestamp:
+		n, size := binary.Uvarint(b)
+		if size <= 0 || n > uint64(len(b)-size) {
+			return nil, nil, errCorruptRow
+		}
+		data, rest := b[size:size+int(n)], b[size+int(n):]
+		if tag == tagText {
+			return string(data), rest, nil
+		}
+
+		var t time.Time
+		if err := t.UnmarshalBinary(data); err != nil {
+			return nil, nil, errCorruptRow
+		}
+		return t, rest, nil
+	case tagInterval:
+		if len(b) < 16 {
+			return nil, nil, errCorruptRow
+		}
+		return types.IntervalValue{
+			Months:   int64(binary.BigEndian.Uint64(b)),
+			Duration: time.Duration(binary.BigEndian.Uint64(b[8:])),
+		}, b[16:], nil
+	case tagUUID:
+		var u types.UUIDValue
+		if len(b) < len(u) {
+			return nil, nil, errCorruptRow
+		}
+		copy(u[:], b)
+		return u, b[len(u):], nil
+	case tagArray:
+		elem, size := binary.Uvarint(b)
+		if size <= 0 {
+			return nil, nil, errCorruptRow
+		}
+		b = b[size:]
+
+		n, size := binary.Uvarint(b)
+		if size <= 0 || n > uint64(len(b)) {
+			return nil, nil, errCorruptRow
+		}
+
+		values, rest, err := decodeValues(b[size:], int(n))
+		if err != nil {
+			return nil, nil, err
+		}
+		return types.Array{Elem: types.Type(elem), Values: values}, rest, nil
+	}
+
+	return nil, nil, errCorruptRow
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
@@ -0,0 +1,146 @@
+package bolt
+
+import (
+	"sync"
+
+	"github.com/nireo/sgsql/storage"
+)
+
+// pageRows is how many rows a page holds. The rows of a table are split
+// into pages by their ids, the first page holding ids 0 to pageRows-1.
+const pageRows = scanBatch
+
+// pageKey identifies a page of the table stored in bucket.
+type pageKey struct {
+	bucket string
+	page   storage.RowID
+}
+
+// frame holds a page in the pool. rows are the first rows of the page,
+// those the table had when it was read, and size the bytes they take in the
+// file. referenced is set when the page is read from the pool and cleared
+// as the clock passes it.
+type frame struct {
+	key        pageKey
+	rows       []storage.Row
+	size       int64
+	referenced bool
+}
+
+// pool is the buffer pool of an engine, see storage.BufferPool. Pages are
+// evicted by the clock algorithm: the hand sweeps the frames, sparing once
+// every page read from the pool since it last passed and evicting the first
+// one that wasn't. Pages only read once, like those of a scan, aren't spared
+// and so don't push out the pages read over and over.
+type pool struct {
+	mu       sync.Mutex
+	capacity int64
+	used     int64
+	// frames are swept by the hand, nil for the free ones listed in free
+	frames []*frame
+	free   []int
+	pages  map[pageKey]int
+	hand   int
+	stats  storage.BufferPoolStats
+}
+
+func newPool(capacity int64) *pool {
+	return &pool{capacity: capacity, pages: map[pageKey]int{}}
+}
+
+// get returns the first n rows of the page key, or nil if the pool doesn't
+// hold that many of them.
+func (p *pool) get(key pageKey, n int) []storage.Row {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i, ok := p.pages[key]
+	if !ok || len(p.frames[i].rows) < n {
+		p.stats.Misses++
+		return nil
+	}
+
+	p.stats.Hits++
+	p.frames[i].referenced = true
+	return p.frames[i].rows[:n:n]
+}
+
+// put adds rows, the first rows of the page key taking size bytes in the
+// file, replacing what the pool held of the page. Pages are evicted until
+// they fit, unless they are larger than the pool.
+func (p *pool) put(key pageKey, rows []storage.Row, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i, ok := p.pages[key]; ok {
+		p.remove(i)
+	}
+	if p.capacity == 0 || size > p.capacity {
+		return
+	}
+	p.evict(p.capacity - size)
+
+	f := &frame{key: key, rows: rows, size: size}
+	if n := len(p.free); n > 0 {
+		p.frames[p.free[n-1]] = f
+		p.pages[key] = p.free[n-1]
+		p.free = p.free[:n-1]
+	} else {
+		p.frames = append(p.frames, f)
+		p.pages[key] = len(p.frames) - 1
+	}
+	p.used += size
+}
+
+// invalidate drops the pages of the table stored in bucket holding rows
+// from from to to, which are being written over.
+func (p *pool) invalidate(bucket []byte, from, to storage.RowID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for page := from / pageRows; page*pageRows < to; page++ {
+		if i, ok := p.pages[pageKey{bucket: string(bucket), page: page}]; ok {
+			p.remove(i)
+		}
+	}
+}
+
+// resize sets the bytes the pool may hold and evicts pages until they fit.
+func (p *pool) resize(capacity int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.capacity = capacity
+	p.evict(capacity)
+}
+
+// evict evicts pages until those left take at most limit bytes. It must be
+// called with p.mu held.
+func (p *pool) evict(limit int64) {
+	for p.used > limit {
+		if f := p.frames[p.hand]; f != nil && f.referenced {
+			f.referenced = false
+		} else if f != nil {
+			p.remove(p.hand)
+			p.stats.Evictions++
+		}
+		p.hand = (p.hand + 1) % len(p.frames)
+	}
+}
+
+// remove frees the frame at i. It must be called with p.mu held.
+func (p *pool) remove(i int) {
+	delete(p.pages, p.frames[i].key)
+	p.used -= p.frames[i].size
+	p.frames[i] = nil
+	p.free = append(p.free, i)
+}
+
+func (p *pool) statistics() storage.BufferPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	stats.Size, stats.Used, stats.Pages = p.capacity, p.used, len(p.pages)
+	return stats
+}
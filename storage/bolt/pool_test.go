@@ -0,0 +1,94 @@
+package bolt
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/nireo/sgsql/storage"
+)
+
+func TestPool(t *testing.T) {
+	// Pages 0 to 2 are put before each test, 10 bytes of a row each
+	page := func(p *pool, n storage.RowID) {
+		p.put(pageKey{bucket: "1", page: n}, []storage.Row{{int64(n)}}, 10)
+	}
+	get := func(p *pool, n storage.RowID) {
+		p.get(pageKey{bucket: "1", page: n}, 1)
+	}
+
+	tests := []struct {
+		name  string
+		run   func(p *pool)
+		pages []storage.RowID
+		stats storage.BufferPoolStats
+	}{
+		{"fits", func(p *pool) {}, []storage.RowID{0, 1, 2}, storage.BufferPoolStats{Size: 30, Used: 30, Pages: 3}},
+		{"evicts the first", func(p *pool) { page(p, 3) }, []storage.RowID{1, 2, 3}, storage.BufferPoolStats{Size: 30, Used: 30, Pages: 3, Evictions: 1}},
+		{
+			"spares those read",
+			func(p *pool) { get(p, 0); get(p, 1); page(p, 3) },
+			[]storage.RowID{0, 1, 3},
+			storage.BufferPoolStats{Size: 30, Used: 30, Pages: 3, Hits: 2, Evictions: 1},
+		},
+		{
+			"spares once",
+			func(p *pool) { get(p, 0); get(p, 1); get(p, 2); page(p, 3); page(p, 4) },
+			[]storage.RowID{2, 3, 4},
+			storage.BufferPoolStats{Size: 30, Used: 30, Pages: 3, Hits: 3, Evictions: 2},
+		},
+		{"misses", func(p *pool) { get(p, 3); get(p, 0) }, []storage.RowID{0, 1, 2}, storage.BufferPoolStats{Size: 30, Used: 30, Pages: 3, Hits: 1, Misses: 1}},
+		{"replaces", func(p *pool) { page(p, 1) }, []storage.RowID{0, 1, 2}, storage.BufferPoolStats{Size: 30, Used: 30, Pages: 3}},
+		{"too large", func(p *pool) { p.put(pageKey{bucket: "1", page: 3}, nil, 40) }, []storage.RowID{0, 1, 2}, storage.BufferPoolStats{Size: 30, Used: 30, Pages: 3}},
+		{
+			"invalidate",
+			func(p *pool) { p.invalidate([]byte("1"), pageRows+1, 2*pageRows+1) },
+			[]storage.RowID{0},
+			storage.BufferPoolStats{Size: 30, Used: 10, Pages: 1},
+		},
+		{"invalidate other table", func(p *pool) { p.invalidate([]byte("2"), 0, 3*pageRows) }, []storage.RowID{0, 1, 2}, storage.BufferPoolStats{Size: 30, Used: 30, Pages: 3}},
+		{"shrink", func(p *pool) { get(p, 0); p.resize(15) }, []storage.RowID{0}, storage.BufferPoolStats{Size: 15, Used: 10, Pages: 1, Hits: 1, Evictions: 2}},
+		{"empty", func(p *pool) { p.resize(0); page(p, 3) }, []storage.RowID{}, storage.BufferPoolStats{Evictions: 3}},
+		{"reuses frames", func(p *pool) { p.resize(0); p.resize(20); page(p, 3); page(p, 4) }, []storage.RowID{3, 4}, storage.BufferPoolStats{Size: 20, Used: 20, Pages: 2, Evictions: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPool(30)
+			for n := storage.RowID(0); n < 3; n++ {
+				page(p, n)
+			}
+			tt.run(p)
+
+			pages := []storage.RowID{}
+			for key := range p.pages {
+				pages = append(pages, key.page)
+			}
+			sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+			if !reflect.DeepEqual(pages, tt.pages) {
+				t.Errorf("got pages %v, want %v", pages, tt.pages)
+			}
+			if stats := p.statistics(); stats != tt.stats {
+				t.Errorf("got %+v, want %+v", stats, tt.stats)
+			}
+		})
+	}
+}
+
+func TestPoolGet(t *testing.T) {
+	p := newPool(100)
+	key := pageKey{bucket: "1", page: 0}
+	p.put(key, []storage.Row{{int64(1)}, {int64(2)}}, 10)
+
+	// A page read before rows were added to it doesn't hold them
+	if rows := p.get(key, 3); rows != nil {
+		t.Errorf("got %v for 3 rows of a page holding 2", rows)
+	}
+	rows := p.get(key, 1)
+	if want := []storage.Row{{int64(1)}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+	if cap(rows) != 1 {
+		t.Errorf("rows can be appended to over those of the page")
+	}
+}
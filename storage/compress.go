@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrNoSuchCompressor = errors.New("Compressor does not exist")
+	ErrCompressorExists = errors.New("Compressor is already registered")
+)
+
+// Compressor compresses the pages of rows an engine stores in its file.
+// Compressors register themselves under a name, which is how tables choose
+// theirs, see Compressing:
+//
+//	func init() {
+//		storage.RegisterCompressor("lz", lz{})
+//	}
+//
+// A compressor is used by every table of every engine at once, so it must
+// be safe for concurrent use.
+type Compressor interface {
+	// Compress appends src compressed to dst and returns the result.
+	Compress(dst, src []byte) []byte
+	// Decompress appends what src was before it was compressed to dst and
+	// returns the result. It fails if src wasn't returned by Compress.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{}
+)
+
+// RegisterCompressor makes c available under name. It panics if name is
+// already taken, like registering the same compressor twice.
+func RegisterCompressor(name string, c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	if _, ok := compressors[name]; ok {
+		panic(ErrCompressorExists.Error() + ": " + name)
+	}
+
+	compressors[name] = c
+}
+
+// LookupCompressor returns the compressor registered under name.
+func LookupCompressor(name string) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	c, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchCompressor, name)
+	}
+
+	return c, nil
+}
+
+// Compressors returns the names of the registered compressors in order.
+func Compressors() []string {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
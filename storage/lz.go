@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCorruptLZ = errors.New("Data compressed with lz is corrupt")
+
+func init() {
+	RegisterCompressor("lz", lz{})
+}
+
+const (
+	// lzMinMatch is the length of the shortest copy, shorter repeats are
+	// cheaper written as literals
+	lzMinMatch = 4
+	// lzMaxOffset is how far back a copy may start, which keeps the table
+	// of positions from pointing at matches too far to be likely
+	lzMaxOffset = 1 << 16
+	lzHashBits  = 14
+)
+
+// lz is a compressor in the style of Snappy and LZ4, favoring speed over
+// how much it compresses. Repeats of at least lzMinMatch bytes are found
+// with a table of the last position each 4 bytes hashed to, so text with
+// repeated words and values compresses well, and data without repeats
+// grows by a few bytes only.
+//
+// The compressed data is the length of the data followed by literals and
+// copies. Each starts with a uvarint holding its length shifted left by a
+// bit, set for copies. Literals are followed by their bytes, copies by a
+// uvarint holding how far back what they repeat starts. A copy may repeat
+// bytes it writes itself, when it is longer than how far back it starts.
+type lz struct{}
+
+func (lz) Compress(dst, src []byte) []byte {
+	dst = appendUvarint(dst, uint64(len(src)))
+
+	var positions [1 << lzHashBits]int32
+	literal := 0
+	for i := 0; i+lzMinMatch <= len(src); {
+		h := lzHash(binary.LittleEndian.Uint32(src[i:]))
+		// Positions are stored plus one, so 0 is one never seen
+		candidate := int(positions[h]) - 1
+		positions[h] = int32(i + 1)
+		if candidate < 0 || i-candidate > lzMaxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+
+		n := lzMinMatch
+		for i+n < len(src) && src[candidate+n] == src[i+n] {
+			n++
+		}
+
+		dst = lzLiteral(dst, src[literal:i])
+		dst = appendUvarint(dst, uint64(n)<<1|1)
+		dst = appendUvarint(dst, uint64(i-candidate))
+		i += n
+		literal = i
+	}
+
+	return lzLiteral(dst, src[literal:])
+}
+
+func (lz) Decompress(dst, src []byte) ([]byte, error) {
+	n, size := binary.Uvarint(src)
+	if size <= 0 {
+		return nil, errCorruptLZ
+	}
+	src = src[size:]
+
+	start := len(dst)
+	for len(src) > 0 {
+		tag, size := binary.Uvarint(src)
+		if size <= 0 {
+			return nil, errCorruptLZ
+		}
+		src = src[size:]
+
+		length := tag >> 1
+		if length == 0 || length > n-uint64(len(dst)-start) {
+			return nil, errCorruptLZ
+		}
+
+		if tag&1 == 0 {
+			if length > uint64(len(src)) {
+				return nil, errCorruptLZ
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		}
+
+		offset, size := binary.Uvarint(src)
+		if size <= 0 || offset == 0 || offset > uint64(len(dst)-start) {
+			return nil, errCorruptLZ
+		}
+		src = src[size:]
+
+		// Byte by byte, since the copy may repeat what it writes
+		from := len(dst) - int(offset)
+		for i := 0; i < int(length); i++ {
+			dst = append(dst, dst[from+i])
+		}
+	}
+
+	if uint64(len(dst)-start) != n {
+		return nil, errCorruptLZ
+	}
+
+	return dst, nil
+}
+
+// lzLiteral appends b as a literal to dst, unless it is empty.
+func lzLiteral(dst, b []byte) []byte {
+	if len(b) == 0 {
+		return dst
+	}
+
+	dst = appendUvarint(dst, uint64(len(b))<<1)
+	return append(dst, b...)
+}
+
+func lzHash(v uint32) uint32 {
+	return (v * 0x1e35a7bd) >> (32 - lzHashBits)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLZ(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name string
+		data []byte
+		// most is the most bytes the data may be compressed to
+		most int
+	}{
+		{"empty", []byte{}, 1},
+		{"short", []byte("abc"), 5},
+		{"repeated", []byte(strings.Repeat("a", 1000)), 10},
+		{"text", []byte(strings.Repeat("the rows of a table holding text, ", 30)), 60},
+		{"overlapping", []byte("abcabcabcabcabcabcabcabc"), 10},
+		{"random", random, 1010},
+	}
+
+	c, err := LookupCompressor("lz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := c.Compress([]byte("prefix"), tt.data)
+			if !bytes.HasPrefix(compressed, []byte("prefix")) {
+				t.Fatal("Compress didn't append to dst")
+			}
+			compressed = compressed[len("prefix"):]
+			if len(compressed) > tt.most {
+				t.Errorf("compressed %d bytes to %d, want at most %d", len(tt.data), len(compressed), tt.most)
+			}
+
+			got, err := c.Decompress([]byte("prefix"), compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, append([]byte("prefix"), tt.data...)) {
+				t.Errorf("decompressed %q, want %q", got, tt.data)
+			}
+
+			// Cut short, the data can't be decompressed
+			for i := 0; i < len(compressed); i++ {
+				if _, err := c.Decompress(nil, compressed[:i]); err == nil {
+					t.Errorf("decompressed the first %d bytes", i)
+				}
+			}
+		})
+	}
+}
+
+func TestLZCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no length", []byte{}},
+		{"empty literal", []byte{1, 0, 'a'}},
+		{"literal too long", []byte{1, 4, 'a', 'b'}},
+		{"copy before the data", []byte{5, 2, 'a', 9, 2}},
+		{"no offset", []byte{5, 2, 'a', 9, 0}},
+		{"copy too long", []byte{2, 2, 'a', 9, 1}},
+		{"too short", []byte{3, 2, 'a'}},
+	}
+
+	c, _ := LookupCompressor("lz")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.Decompress(nil, tt.data); err == nil {
+				t.Error("decompressed corrupt data")
+			}
+		})
+	}
+
+	if _, err := LookupCompressor("paper"); !errors.Is(err, ErrNoSuchCompressor) {
+		t.Errorf("looking up paper: got %v, want %v", err, ErrNoSuchCompressor)
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+func init() {
+	Register("memory", func(Options) (Engine, error) { return NewMemory(), nil })
+}
+
+// Memory is an engine keeping every table in memory.
+type Memory struct {
+	tables map[string]*memoryTable
+}
+
+func NewMemory() *Memory {
+	return &Memory{tables: map[string]*memoryTable{}}
+}
+
+func (m *Memory) CreateTable(name string) (Table, error) {
+	if _, ok := m.tables[name]; ok {
+		return nil, ErrTableExists
+	}
+
+	t := &memoryTable{}
+	m.tables[name] = t
+	return t, nil
+}
+
+func (m *Memory) DropTable(name string) error {
+	if _, ok := m.tables[name]; !ok {
+		return ErrNoSuchTable
+	}
+
+	delete(m.tables, name)
+	return nil
+}
+
+func (m *Memory) Table(name string) (Table, bool) {
+	t, ok := m.tables[name]
+	return t, ok
+}
+
+// memorySnapshot holds the rows each table had. Rows are only ever appended
+// and never modified in place, so copying the slice headers is enough to be
+// able to restore them later.
+type memorySnapshot map[string]memorySaved
+
+type memorySaved struct {
+	table *memoryTable
+	rows  []Row
+}
+
+func (m *Memory) Snapshot() Snapshot {
+	s := memorySnapshot{}
+	for name, t := range m.tables {
+		s[name] = memorySaved{table: t, rows: t.rows}
+	}
+
+	return s
+}
+
+func (m *Memory) Restore(s Snapshot) {
+	m.tables = map[string]*memoryTable{}
+	for name, saved := range s.(memorySnapshot) {
+		saved.table.rows = saved.rows
+		m.tables[name] = saved.table
+	}
+}
+
+// NewTable returns a table holding rows that belongs to no engine, for rows
+// that are computed rather than stored. It can't be inserted into.
+func NewTable(rows ...Row) Table {
+	return &memoryTable{rows: rows, readOnly: true}
+}
+
+// memoryTable identifies rows by their position, which never changes since
+// rows are never removed.
+type memoryTable struct {
+	rows     []Row
+	hooks    []IndexHook
+	readOnly bool
+}
+
+func (t *memoryTable) Insert(rows ...Row) error {
+	if t.readOnly {
+		return ErrReadOnlyTable
+	}
+
+	first := len(t.rows)
+	t.rows = append(t.rows, rows...)
+	for i, row := range rows {
+		for _, hook := range t.hooks {
+			hook.Inserted(RowID(first+i), row)
+		}
+	}
+
+	return nil
+}
+
+func (t *memoryTable) Scan() Iterator {
+	return &memoryIterator{rows: t.rows}
+}
+
+func (t *memoryTable) Lookup(id RowID) (Row, bool, error) {
+	if id < 0 || id >= RowID(len(t.rows)) {
+		return nil, false, nil
+	}
+
+	return t.rows[id], true, nil
+}
+
+func (t *memoryTable) Len() int {
+	return len(t.rows)
+}
+
+func (t *memoryTable) AddIndexHook(hook IndexHook) {
+	t.hooks = append(t.hooks, hook)
+}
+
+type memoryIterator struct {
+	rows []Row
+	next int
+}
+
+func (it *memoryIterator) Next() (RowID, Row, bool) {
+	if it.next >= len(it.rows) {
+		return 0, nil, false
+	}
+
+	it.next++
+	return RowID(it.next - 1), it.rows[it.next-1], true
+}
+
+// Err is always nil, rows in memory can always be read.
+func (it *memoryIterator) Err() error {
+	return nil
+}
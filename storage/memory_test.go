@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func scan(t Table) []Row {
+	rows := []Row{}
+	it := t.Scan()
+	for _, row, ok := it.Next(); ok; _, row, ok = it.Next() {
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// hook records the ids of the rows inserted.
+type hook []RowID
+
+func (h *hook) Inserted(id RowID, _ Row) {
+	*h = append(*h, id)
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+
+	tbl, err := m.CreateTable("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CreateTable("t"); err != ErrTableExists {
+		t.Errorf("creating t again: got %v, want %v", err, ErrTableExists)
+	}
+
+	var inserted hook
+	tbl.AddIndexHook(&inserted)
+	if err := tbl.Insert(Row{int64(1), "a"}, Row{int64(2), "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(Row{int64(3), "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (hook{0, 1, 2}); !reflect.DeepEqual(inserted, want) {
+		t.Errorf("hook was called for %v, want %v", inserted, want)
+	}
+
+	// A scan sees the rows the table held when it started
+	it := tbl.Scan()
+	if err := tbl.Insert(Row{int64(4), "d"}); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("scan started before the insert read %d rows, want 3", n)
+	}
+
+	lookups := []struct {
+		id  RowID
+		row Row
+		ok  bool
+	}{
+		{0, Row{int64(1), "a"}, true},
+		{3, Row{int64(4), "d"}, true},
+		{4, nil, false},
+		{-1, nil, false},
+	}
+	for _, tt := range lookups {
+		if row, ok, err := tbl.Lookup(tt.id); err != nil || ok != tt.ok || !reflect.DeepEqual(row, tt.row) {
+			t.Errorf("Lookup(%d) = %v, %v, %v, want %v, %v", tt.id, row, ok, err, tt.row, tt.ok)
+		}
+	}
+
+	s := m.Snapshot()
+	if err := tbl.Insert(Row{int64(5), "e"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CreateTable("u"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DropTable("t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DropTable("t"); err != ErrNoSuchTable {
+		t.Errorf("dropping t again: got %v, want %v", err, ErrNoSuchTable)
+	}
+
+	m.Restore(s)
+	restored, ok := m.Table("t")
+	if !ok || restored != tbl {
+		t.Fatal("restoring the snapshot didn't bring t back")
+	}
+	if _, ok := m.Table("u"); ok {
+		t.Error("u exists after restoring a snapshot from before it was created")
+	}
+	if tbl.Len() != 4 || len(scan(tbl)) != 4 {
+		t.Errorf("t holds %d rows after restoring, want 4", tbl.Len())
+	}
+}
+
+func TestNewTable(t *testing.T) {
+	rows := []Row{{int64(1)}, {int64(2)}}
+	tbl := NewTable(rows...)
+
+	if got := scan(tbl); !reflect.DeepEqual(got, rows) {
+		t.Errorf("scanned %v, want %v", got, rows)
+	}
+	if err := tbl.Insert(Row{int64(3)}); err != ErrReadOnlyTable {
+		t.Errorf("inserting: got %v, want %v", err, ErrReadOnlyTable)
+	}
+	if tbl.Len() != 2 {
+		t.Errorf("holds %d rows after a failed insert, want 2", tbl.Len())
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"memory", nil},
+		{"nope", ErrNoSuchEngine},
+	}
+
+	for _, tt := range tests {
+		if _, err := Open(tt.name, Options{}); !errors.Is(err, tt.err) {
+			t.Errorf("Open(%q): got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// Each call opens an engine of its own
+	a, _ := Open("memory", Options{})
+	b, _ := Open("memory", Options{})
+	if _, err := a.CreateTable("t"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Table("t"); ok {
+		t.Error("a table created in one engine exists in another")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering memory again didn't panic")
+		}
+	}()
+	Register("memory", func(Options) (Engine, error) { return NewMemory(), nil })
+}
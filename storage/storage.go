@@ -0,0 +1,215 @@
+// Package storage defines how the backend stores the rows of its tables, so
+// the engine holding them can be swapped without touching the executor.
+// Engines register themselves under a name, and are opened by it with the
+// options of the database they store:
+//
+//	func init() {
+//		storage.Register("memory", func(storage.Options) (storage.Engine, error) {
+//			return NewMemory(), nil
+//		})
+//	}
+//
+// Engines holding resources like open files implement io.Closer as well,
+// which is called once the database is closed.
+//
+// Engines store rows as they are given. Checking values against the types of
+// the columns and evaluating queries happen above them, in the backend.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrTableExists   = errors.New("Table already exists")
+	ErrNoSuchTable   = errors.New("Table does not exist")
+	ErrNoSuchEngine  = errors.New("Storage engine does not exist")
+	ErrEngineExists  = errors.New("Storage engine is already registered")
+	ErrReadOnlyTable = errors.New("Table can't be modified")
+	ErrCorrupt       = errors.New("Stored row is corrupt")
+)
+
+// Row holds the values of a row in the order of its table's columns.
+type Row = []interface{}
+
+// RowID identifies a row within its table. It stays the same for as long as
+// the row exists.
+type RowID int64
+
+// Engine stores tables of rows. Reads may run concurrently with each other,
+// but the caller never runs them concurrently with writes.
+type Engine interface {
+	// CreateTable creates an empty table called name.
+	CreateTable(name string) (Table, error)
+	// DropTable removes the table called name with all of its rows.
+	DropTable(name string) error
+	// Table returns the table called name, or false if there is none.
+	Table(name string) (Table, bool)
+
+	// Snapshot captures the contents of every table and Restore discards
+	// every change made since, which is how transactions roll back. Tables
+	// that existed when the snapshot was taken keep their identity.
+	Snapshot() Snapshot
+	Restore(Snapshot)
+}
+
+// Snapshot is the state of an engine at some point in time. Only the engine
+// that took it can interpret it.
+type Snapshot interface{}
+
+// Table is a table of an engine.
+type Table interface {
+	// Insert appends rows to the table, either all of them or none.
+	Insert(rows ...Row) error
+	// Scan iterates over the rows the table holds when it is called, in the
+	// order they were inserted.
+	Scan() Iterator
+	// Lookup returns the row identified by id, or false if there is none.
+	// It fails if the row can't be read.
+	Lookup(id RowID) (Row, bool, error)
+	// Len returns the number of rows in the table.
+	Len() int
+	// AddIndexHook calls hook for every row inserted from now on, so an
+	// index over the table can be kept up to date.
+	AddIndexHook(hook IndexHook)
+}
+
+// Iterator steps through the rows of a table.
+type Iterator interface {
+	// Next returns the next row and its id, or false after the last one,
+	// or once a row can't be read.
+	Next() (RowID, Row, bool)
+	// Err returns the error that ended the scan early, nil when it ran
+	// through every row. A scan that returns rows has to check it, or it
+	// can't tell a row that failed to read from the end of the table.
+	Err() error
+}
+
+// IndexHook is told about changes to the rows of a table.
+type IndexHook interface {
+	Inserted(id RowID, row Row)
+}
+
+// Options configure an engine as it is opened. Engines ignore the options
+// they have no use for.
+type Options struct {
+	// Path is the file the engine may keep its tables in, empty when the
+	// database is kept in memory only
+	Path string
+	// ReadOnly is set when the database is opened read-only. Its tables
+	// are still written to as it is opened, but the engine must not
+	// change its file
+	ReadOnly bool
+}
+
+// Checkpointer is an engine keeping its tables in a file across opens.
+// Checkpointing it makes the rows of its tables durable in the file, so the
+// statements that inserted them needn't be kept to insert them again.
+type Checkpointer interface {
+	Engine
+	// Checkpoint syncs the rows the tables called names hold to the file,
+	// under id. Earlier checkpoints are kept until the engine is opened
+	// again, so a database whose statements still start from one of them
+	// can be opened from it.
+	Checkpoint(id string, names []string) error
+	// Recover must be called once after the engine is opened, before any
+	// table is created. It brings back the tables of the checkpoint id,
+	// which CreateTable returns with their rows instead of new empty ones,
+	// and discards every other checkpoint and table of the file. An empty
+	// id starts over without any.
+	Recover(id string) error
+}
+
+// DefaultBufferPoolSize is the bytes of rows the buffer pool of an engine
+// holds until it is given another size, see BufferPool.
+const DefaultBufferPoolSize = 64 << 20
+
+// BufferPool is an engine caching the pages of rows it reads from its file
+// in memory, shared by all of its tables. When the pool is full, pages are
+// evicted for those read next.
+type BufferPool interface {
+	Engine
+	// SetBufferPoolSize sets the bytes of rows, as they are stored in the
+	// file, the pool may hold. Pages are evicted until they fit, and 0
+	// turns the pool off.
+	SetBufferPoolSize(bytes int64)
+	// BufferPoolStats tells what the pool holds and how well it serves
+	// reads.
+	BufferPoolStats() BufferPoolStats
+}
+
+// BufferPoolStats describes a buffer pool. Size is the bytes it may hold,
+// and Used those the Pages it holds take. Hits and Misses count the pages
+// read from the pool and from the file, and Evictions the pages evicted to
+// make room for others.
+type BufferPoolStats struct {
+	Size      int64
+	Used      int64
+	Pages     int
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+// Compressing is an engine that can compress the pages of rows of a table
+// in its file, which shrinks tables whose rows repeat themselves, like
+// those holding text, at the cost of compressing the pages as they are
+// written and decompressing them as they are read.
+type Compressing interface {
+	Engine
+	// CreateCompressedTable creates an empty table called name like
+	// CreateTable, whose pages are compressed with the compressor
+	// registered under compressor, see RegisterCompressor.
+	CreateCompressedTable(name, compressor string) (Table, error)
+}
+
+// Factory opens an instance of an engine with opts.
+type Factory func(opts Options) (Engine, error)
+
+var (
+	enginesMu sync.RWMutex
+	engines   = map[string]Factory{}
+)
+
+// Register makes an engine available under name. It panics if name is
+// already taken, like registering the same engine twice.
+func Register(name string, factory Factory) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	if _, ok := engines[name]; ok {
+		panic(ErrEngineExists.Error() + ": " + name)
+	}
+
+	engines[name] = factory
+}
+
+// Open opens a new instance of the engine registered under name.
+func Open(name string, opts Options) (Engine, error) {
+	enginesMu.RLock()
+	factory, ok := engines[name]
+	enginesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchEngine, name)
+	}
+
+	return factory(opts)
+}
+
+// Engines returns the names of the registered engines in order.
+func Engines() []string {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
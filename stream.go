@@ -0,0 +1,49 @@
+package sgsql
+
+import (
+	"context"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+// RowWriter takes the columns and rows of a query from StreamQuery.
+type RowWriter = backend.RowWriter
+
+// StreamQuery runs every statement in query like QueryContext, but hands
+// the columns and rows of the last one to w rather than returning them. A
+// SELECT scanning a table writes its rows as they are computed, so results
+// larger than memory can be passed on. Other statements, like aggregating
+// queries, write theirs once they are complete. The rows quota still
+// applies, the query fails once it would write more rows than allowed.
+// A query failing after its columns were written may have written some of
+// its rows.
+func (c *Conn) StreamQuery(ctx context.Context, w RowWriter, query string, args ...interface{}) error {
+	sw := &streamWriter{RowWriter: w}
+	results, err := c.QueryContext(backend.WithRowWriter(ctx, sw), query, args...)
+	if err != nil || sw.started {
+		return err
+	}
+
+	if err := w.WriteColumns(results.Columns); err != nil {
+		return err
+	}
+	for _, row := range results.Rows {
+		if err := w.WriteRow(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// streamWriter records whether a query wrote its rows to the writer of
+// StreamQuery as it computed them.
+type streamWriter struct {
+	RowWriter
+	started bool
+}
+
+func (w *streamWriter) WriteColumns(columns []backend.ResultColumn) error {
+	w.started = true
+	return w.RowWriter.WriteColumns(columns)
+}
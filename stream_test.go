@@ -0,0 +1,99 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql/backend"
+)
+
+// rowRecorder is a row writer keeping what it is given, failing once it
+// has written fail rows when that is set.
+type rowRecorder struct {
+	columns []string
+	rows    [][]interface{}
+	fail    int
+}
+
+var errRecorderFull = errors.New("recorder is full")
+
+func (r *rowRecorder) WriteColumns(columns []backend.ResultColumn) error {
+	for _, col := range columns {
+		r.columns = append(r.columns, col.Name)
+	}
+	return nil
+}
+
+func (r *rowRecorder) WriteRow(row []interface{}) error {
+	if r.fail > 0 && len(r.rows) == r.fail {
+		return errRecorderFull
+	}
+	r.rows = append(r.rows, row)
+	return nil
+}
+
+func TestStreamQuery(t *testing.T) {
+	db, _ := openTest(t)
+	mustExec(t, db, "create table t (id int)", "insert into t values (1)", "insert into t values (2)", "insert into t values (3)")
+	db.SetQuota("alice", Quota{MaxRows: 2})
+
+	tests := []struct {
+		name  string
+		user  string
+		query string
+		fail  int
+		// streamed is whether the rows were written while scanning t,
+		// rather than once the query returned them
+		streamed bool
+		rows     [][]interface{}
+		err      error
+	}{
+		{"scan", "", "select id from t where id > 1", 0, true, [][]interface{}{{int64(2)}, {int64(3)}}, nil},
+		{"aggregate", "", "select count(*) from t", 0, false, [][]interface{}{{int64(3)}}, nil},
+		{"last statement", "", "select 1; select id from t where id = 1", 0, true, [][]interface{}{{int64(1)}}, nil},
+		{"writer failing", "", "select id from t", 1, true, [][]interface{}{{int64(1)}}, errRecorderFull},
+		{"quota", "alice", "select id from t", 0, true, [][]interface{}{{int64(1)}, {int64(2)}}, ErrRowLimit},
+		{"within quota", "alice", "select id from t where id < 3", 0, true, [][]interface{}{{int64(1)}, {int64(2)}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := db.Conn()
+			defer c.Close()
+			if tt.user != "" {
+				c.SetUser(tt.user)
+			}
+
+			// Queries only write to the writer of their context the rows
+			// they stream, StreamQuery writes the others
+			w := &rowRecorder{fail: tt.fail}
+			results, err := c.QueryContext(backend.WithRowWriter(context.Background(), w), tt.query)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if streamed := w.columns != nil; streamed != tt.streamed {
+				t.Errorf("streamed = %v, want %v", streamed, tt.streamed)
+			}
+			if err != nil {
+				// Only the rows before the error were written
+				if !reflect.DeepEqual(w.rows, tt.rows) {
+					t.Errorf("got rows %v, want %v", w.rows, tt.rows)
+				}
+				return
+			}
+			if tt.streamed && len(results.Rows) > 0 {
+				t.Errorf("streamed rows were returned too: %v", results.Rows)
+			}
+
+			w = &rowRecorder{fail: tt.fail}
+			if err := c.StreamQuery(context.Background(), w, tt.query); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(w.rows, tt.rows) {
+				t.Errorf("got rows %v, want %v", w.rows, tt.rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package stress
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/nireo/sgsql/sq"
+)
+
+// column is a column of a generated table. Its type is one of "int",
+// "float", "text" and "bool".
+type column struct {
+	name string
+	typ  string
+}
+
+// table is a generated table with the rows inserted into it, which is the
+// model queries are checked against.
+type table struct {
+	name    string
+	columns []column
+	rows    [][]interface{}
+}
+
+// query is a generated query with a way of computing its results from the
+// model.
+type query struct {
+	sql  string
+	eval func() [][]interface{}
+}
+
+// generator draws schemas, rows and queries from a single source, so the
+// same seed always generates the same workload.
+type generator struct {
+	rnd *rand.Rand
+}
+
+func (g *generator) table(i int) *table {
+	types := []string{"int", "float", "text", "bool"}
+
+	t := &table{name: fmt.Sprintf("t%d", i)}
+	for c := 0; c < 1+g.rnd.Intn(5); c++ {
+		t.columns = append(t.columns, column{name: fmt.Sprintf("c%d", c), typ: types[g.rnd.Intn(len(types))]})
+	}
+
+	return t
+}
+
+func (t *table) create() string {
+	defs := make([]string, len(t.columns))
+	for i, col := range t.columns {
+		defs[i] = col.name + " " + col.typ
+	}
+
+	return "CREATE TABLE " + t.name + " (" + strings.Join(defs, ", ") + ")"
+}
+
+// value draws a value of type typ, NULL about one time in eight. Values are
+// small, so arithmetic on them never overflows, and floats are multiples of
+// a half, so they are exact.
+func (g *generator) value(typ string) interface{} {
+	if g.rnd.Intn(8) == 0 {
+		return nil
+	}
+
+	switch typ {
+	case "int":
+		return int64(g.rnd.Intn(21) - 10)
+	case "float":
+		return float64(g.rnd.Intn(41)-20) / 2
+	case "text":
+		letters := []byte("abc'")
+		b := make([]byte, g.rnd.Intn(4))
+		for i := range b {
+			b[i] = letters[g.rnd.Intn(len(letters))]
+		}
+		return string(b)
+	default:
+		return g.rnd.Intn(2) == 0
+	}
+}
+
+func (g *generator) row(t *table) []interface{} {
+	row := make([]interface{}, len(t.columns))
+	for i, col := range t.columns {
+		row[i] = g.value(col.typ)
+	}
+
+	return row
+}
+
+// predicate is a generated condition, as SQL and as its evaluation over a
+// row of the model. Evaluating returns nil for NULL.
+type predicate struct {
+	exp  sq.Expr
+	eval func(row []interface{}) interface{}
+}
+
+func (g *generator) predicate(t *table, depth int) predicate {
+	if depth > 0 {
+		switch g.rnd.Intn(4) {
+		case 0:
+			a, b := g.predicate(t, depth-1), g.predicate(t, depth-1)
+			return predicate{exp: a.exp.And(b.exp), eval: func(row []interface{}) interface{} {
+				return and(a.eval(row), b.eval(row))
+			}}
+		case 1:
+			a, b := g.predicate(t, depth-1), g.predicate(t, depth-1)
+			return predicate{exp: a.exp.Or(b.exp), eval: func(row []interface{}) interface{} {
+				return not(and(not(a.eval(row)), not(b.eval(row))))
+			}}
+		case 2:
+			a := g.predicate(t, depth-1)
+			return predicate{exp: sq.Not(a.exp), eval: func(row []interface{}) interface{} {
+				return not(a.eval(row))
+			}}
+		}
+	}
+
+	i := g.rnd.Intn(len(t.columns))
+	col := t.columns[i]
+
+	// A value that isn't NULL, comparisons with NULL are covered by the
+	// rows holding them
+	v := g.value(col.typ)
+	for v == nil {
+		v = g.value(col.typ)
+	}
+
+	if g.rnd.Intn(4) == 0 {
+		list := []interface{}{v, g.value(col.typ)}
+		for list[1] == nil {
+			list[1] = g.value(col.typ)
+		}
+
+		return predicate{exp: sq.Col(col.name).In(list...), eval: func(row []interface{}) interface{} {
+			if row[i] == nil {
+				return nil
+			}
+			return row[i] == list[0] || row[i] == list[1]
+		}}
+	}
+
+	ops := []string{"=", "<>", "<", "<=", ">", ">="}
+	if col.typ == "bool" {
+		ops = ops[:2]
+	}
+	op := ops[g.rnd.Intn(len(ops))]
+
+	exp := sq.Col(col.name)
+	switch op {
+	case "=":
+		exp = exp.Eq(v)
+	case "<>":
+		exp = exp.Ne(v)
+	case "<":
+		exp = exp.Lt(v)
+	case "<=":
+		exp = exp.Le(v)
+	case ">":
+		exp = exp.Gt(v)
+	case ">=":
+		exp = exp.Ge(v)
+	}
+
+	return predicate{exp: exp, eval: func(row []interface{}) interface{} {
+		if row[i] == nil {
+			return nil
+		}
+		return compare(op, row[i], v)
+	}}
+}
+
+func (g *generator) query(t *table) query {
+	where := g.predicate(t, 2)
+	matching := func() [][]interface{} {
+		rows := [][]interface{}{}
+		for _, row := range t.rows {
+			if where.eval(row) == true {
+				rows = append(rows, row)
+			}
+		}
+		return rows
+	}
+
+	if g.rnd.Intn(4) == 0 {
+		b := sq.Select().Column(sq.CountAll()).From(t.name).Where(where.exp)
+		text, _ := b.SQL()
+		return query{sql: text, eval: func() [][]interface{} {
+			return [][]interface{}{{int64(len(matching()))}}
+		}}
+	}
+
+	// Columns are selected in a random order, some more than once, and int
+	// columns sometimes with a number added
+	b := sq.Select()
+	type item struct {
+		i   int
+		add int64
+	}
+	items := []item{}
+	for n := 0; n < 1+g.rnd.Intn(len(t.columns)+1); n++ {
+		it := item{i: g.rnd.Intn(len(t.columns))}
+		exp := sq.Col(t.columns[it.i].name)
+		if t.columns[it.i].typ == "int" && g.rnd.Intn(3) == 0 {
+			it.add = int64(g.rnd.Intn(10) + 1)
+			exp = exp.Plus(it.add)
+		}
+
+		b.Column(exp)
+		items = append(items, it)
+	}
+	b.From(t.name).Where(where.exp)
+
+	text, _ := b.SQL()
+	return query{sql: text, eval: func() [][]interface{} {
+		rows := [][]interface{}{}
+		for _, row := range matching() {
+			projected := make([]interface{}, len(items))
+			for j, it := range items {
+				projected[j] = row[it.i]
+				if it.add != 0 && row[it.i] != nil {
+					projected[j] = row[it.i].(int64) + it.add
+				}
+			}
+			rows = append(rows, projected)
+		}
+		return rows
+	}}
+}
+
+// and is AND over SQL's three values, nil being NULL.
+func and(a, b interface{}) interface{} {
+	if a == false || b == false {
+		return false
+	}
+	if a == nil || b == nil {
+		return nil
+	}
+	return true
+}
+
+func not(a interface{}) interface{} {
+	if a == nil {
+		return nil
+	}
+	return !a.(bool)
+}
+
+// compare compares two values of the same type that aren't NULL.
+func compare(op string, a, b interface{}) bool {
+	var c int
+	switch a := a.(type) {
+	case int64:
+		c = cmp(float64(a), float64(b.(int64)))
+	case float64:
+		c = cmp(a, b.(float64))
+	case string:
+		c = strings.Compare(a, b.(string))
+	case bool:
+		if a != b.(bool) {
+			c = 1
+		}
+	}
+
+	switch op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func cmp(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,198 @@
+// Package stress runs a random workload against a database and checks the
+// results of its queries against a model of what they should return. The
+// workload is generated from a seed, so a failing run can be repeated:
+//
+//	report, err := stress.Run(db, stress.Config{Seed: 42})
+//	var mismatch *stress.Mismatch
+//	if errors.As(err, &mismatch) {
+//		...
+//	}
+//
+// Tables are created and filled first, then queried. Both phases run their
+// statements from several connections at once, rows are compared without
+// regard to their order.
+package stress
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nireo/sgsql"
+	"github.com/nireo/sgsql/sq"
+)
+
+// Config sizes a workload. Zero fields take the defaults.
+type Config struct {
+	Seed    int64
+	Tables  int // 3 by default
+	Rows    int // rows inserted into each table, 100 by default
+	Queries int // 500 by default
+	Workers int // connections running statements at once, 4 by default
+}
+
+func (c *Config) defaults() {
+	if c.Tables <= 0 {
+		c.Tables = 3
+	}
+	if c.Rows <= 0 {
+		c.Rows = 100
+	}
+	if c.Queries <= 0 {
+		c.Queries = 500
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+}
+
+// Report counts the statements a run ran.
+type Report struct {
+	Statements int
+	Queries    int
+}
+
+// Mismatch is a query whose results differ from those of the model. Rows
+// are formatted and sorted.
+type Mismatch struct {
+	Query string
+	Got   []string
+	Want  []string
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("Query returned other rows than the model: %s\ngot:\n%s\nwant:\n%s",
+		m.Query, strings.Join(m.Got, "\n"), strings.Join(m.Want, "\n"))
+}
+
+var ErrStatementFailed = errors.New("Generated statement failed")
+
+// Run runs a workload generated from cfg against db, which must not hold
+// any of the tables t0, t1 and so on. It stops at the first statement that
+// fails or query returning other rows than the model, returning the
+// failure as a *Mismatch or an error matching ErrStatementFailed.
+func Run(db *sgsql.DB, cfg Config) (*Report, error) {
+	cfg.defaults()
+	g := &generator{rnd: rand.New(rand.NewSource(cfg.Seed))}
+	report := &Report{}
+
+	tables := make([]*table, cfg.Tables)
+	for i := range tables {
+		tables[i] = g.table(i)
+		if err := db.Exec(tables[i].create()); err != nil {
+			return report, fmt.Errorf("%w: %s: %v", ErrStatementFailed, tables[i].create(), err)
+		}
+		report.Statements++
+	}
+
+	inserts := []string{}
+	for _, t := range tables {
+		for i := 0; i < cfg.Rows; i++ {
+			row := g.row(t)
+			t.rows = append(t.rows, row)
+
+			text, err := sq.Insert(t.name).Values(row...).SQL()
+			if err != nil {
+				return report, err
+			}
+			inserts = append(inserts, text)
+		}
+	}
+
+	// Rows inserted into the same table concurrently can end up in any
+	// order, which queries don't depend on
+	err := parallel(db, cfg.Workers, len(inserts), func(conn *sgsql.Conn, i int) error {
+		if err := conn.Exec(inserts[i]); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrStatementFailed, inserts[i], err)
+		}
+		return nil
+	})
+	report.Statements += len(inserts)
+	if err != nil {
+		return report, err
+	}
+
+	queries := make([]query, cfg.Queries)
+	for i := range queries {
+		queries[i] = g.query(tables[g.rnd.Intn(len(tables))])
+	}
+
+	err = parallel(db, cfg.Workers, len(queries), func(conn *sgsql.Conn, i int) error {
+		results, err := conn.Query(queries[i].sql)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrStatementFailed, queries[i].sql, err)
+		}
+
+		got, want := formatRows(results.Rows), formatRows(queries[i].eval())
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			return &Mismatch{Query: queries[i].sql, Got: got, Want: want}
+		}
+		return nil
+	})
+	report.Statements += len(queries)
+	report.Queries = len(queries)
+
+	return report, err
+}
+
+// parallel calls fn for every i below n from workers goroutines, each with
+// its own connection to db, and returns the first error any call returned.
+// The remaining calls are skipped after an error.
+func parallel(db *sgsql.DB, workers, n int, fn func(conn *sgsql.Conn, i int) error) error {
+	var (
+		mu       sync.Mutex
+		next     int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn := db.Conn()
+			defer conn.Close()
+
+			for {
+				mu.Lock()
+				i := next
+				next++
+				stop := i >= n || firstErr != nil
+				mu.Unlock()
+				if stop {
+					return
+				}
+
+				if err := fn(conn, i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// formatRows formats each row on a line and sorts the lines.
+func formatRows(rows [][]interface{}) []string {
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		values := make([]string, len(row))
+		for j, v := range row {
+			values[j] = fmt.Sprintf("%#v", v)
+		}
+		lines[i] = strings.Join(values, ", ")
+	}
+	sort.Strings(lines)
+
+	return lines
+}
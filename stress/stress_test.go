@@ -0,0 +1,101 @@
+package stress
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nireo/sgsql"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		// long runs are skipped with -short
+		long bool
+	}{
+		{"small", Config{Seed: 1, Tables: 2, Rows: 20, Queries: 50, Workers: 2}, false},
+		{"one worker", Config{Seed: 2, Tables: 1, Rows: 30, Queries: 50, Workers: 1}, false},
+		{"defaults", Config{Seed: 3}, true},
+		{"many workers", Config{Seed: 4, Tables: 5, Rows: 300, Queries: 2000, Workers: 16}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.long && testing.Short() {
+				t.Skip("long run")
+			}
+
+			db, err := sgsql.Open(filepath.Join(t.TempDir(), "stress.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			report, err := Run(db, tt.cfg)
+			var mismatch *Mismatch
+			if errors.As(err, &mismatch) {
+				t.Fatalf("seed %d: %v", tt.cfg.Seed, mismatch)
+			}
+			if err != nil {
+				t.Fatalf("seed %d: %v", tt.cfg.Seed, err)
+			}
+
+			cfg := tt.cfg
+			cfg.defaults()
+			if report.Queries != cfg.Queries || report.Statements != cfg.Tables*(1+cfg.Rows)+cfg.Queries {
+				t.Errorf("ran %+v, want %d queries of %d statements", report, cfg.Queries, cfg.Tables*(1+cfg.Rows)+cfg.Queries)
+			}
+		})
+	}
+}
+
+func TestRunFailsOnExistingTable(t *testing.T) {
+	db, err := sgsql.Open(filepath.Join(t.TempDir(), "stress.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Exec("create table t0 (id int)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(db, Config{Seed: 1, Tables: 1, Rows: 1, Queries: 1}); !errors.Is(err, ErrStatementFailed) {
+		t.Errorf("got %v, want %v", err, ErrStatementFailed)
+	}
+}
+
+func TestThreeValuedLogic(t *testing.T) {
+	tests := []struct {
+		a, b     interface{}
+		and, not interface{}
+	}{
+		{true, true, true, false},
+		{true, false, false, false},
+		{false, nil, false, true},
+		{true, nil, nil, false},
+		{nil, nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		if got := and(tt.a, tt.b); got != tt.and {
+			t.Errorf("%v AND %v = %v, want %v", tt.a, tt.b, got, tt.and)
+		}
+		if got := and(tt.b, tt.a); got != tt.and {
+			t.Errorf("%v AND %v = %v, want %v", tt.b, tt.a, got, tt.and)
+		}
+		if got := not(tt.a); got != tt.not {
+			t.Errorf("NOT %v = %v, want %v", tt.a, got, tt.not)
+		}
+	}
+}
+
+func TestFormatRows(t *testing.T) {
+	got := formatRows([][]interface{}{{int64(2), "b"}, {int64(1), nil}, {int64(1), "a"}})
+	want := []string{`1, "a"`, "1, <nil>", `2, "b"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+// Package trace lets the time a query takes be broken down into spans, for
+// parsing, analyzing and the steps of executing it, without sgsql depending
+// on a tracing library. A Tracer is passed along in the context of a query
+// and adapting OpenTelemetry to it takes a few lines:
+//
+//	type otelTracer struct{ t oteltrace.Tracer }
+//
+//	func (o otelTracer) Start(ctx context.Context, name string) (context.Context, trace.Span) {
+//		ctx, span := o.t.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ oteltrace.Span }
+//
+//	func (s otelSpan) SetAttribute(key string, value interface{}) {
+//		s.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+//	}
+//
+//	func (s otelSpan) End() { s.Span.End() }
+//
+//	func (s otelSpan) RecordError(err error) { s.Span.RecordError(err) }
+package trace
+
+import "context"
+
+// Tracer starts spans, as children of the span in ctx if there is one.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation being traced. Values of attributes are strings,
+// integers or booleans.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type tracerKey struct{}
+
+// WithTracer returns a context that traces queries run with it with t.
+func WithTracer(ctx context.Context, t Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// FromContext returns the tracer of ctx, if it has one.
+func FromContext(ctx context.Context) (Tracer, bool) {
+	t, ok := ctx.Value(tracerKey{}).(Tracer)
+	return t, ok
+}
+
+// Start starts a span with the tracer of ctx. Without one the span does
+// nothing, so tracing costs next to nothing when it is off.
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	t, ok := FromContext(ctx)
+	if !ok {
+		return ctx, nopSpan{}
+	}
+
+	return t.Start(ctx, name)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) RecordError(err error)                      {}
+func (nopSpan) End()                                       {}
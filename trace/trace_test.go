@@ -0,0 +1,40 @@
+package trace
+
+import (
+	"context"
+	"testing"
+)
+
+type countingTracer struct {
+	started []string
+}
+
+func (c *countingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	c.started = append(c.started, name)
+	return ctx, nopSpan{}
+}
+
+func TestStart(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := FromContext(ctx); ok {
+		t.Error("a context without a tracer has one")
+	}
+
+	got, span := Start(ctx, "untraced")
+	if got != ctx {
+		t.Error("starting a span without a tracer changed the context")
+	}
+	if _, ok := span.(nopSpan); !ok {
+		t.Errorf("started %T without a tracer, want a span doing nothing", span)
+	}
+
+	c := &countingTracer{}
+	ctx = WithTracer(ctx, c)
+	if tracer, ok := FromContext(ctx); !ok || tracer != c {
+		t.Errorf("the context has tracer %v, want %v", tracer, c)
+	}
+	Start(ctx, "traced")
+	if len(c.started) != 1 || c.started[0] != "traced" {
+		t.Errorf("the tracer started %v, want the span traced", c.started)
+	}
+}
@@ -0,0 +1,63 @@
+package sgsql
+
+import (
+	"context"
+
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/trace"
+)
+
+// tracerBox lets a nil tracer be stored in an atomic.Value.
+type tracerBox struct {
+	t trace.Tracer
+}
+
+// SetTracer traces every query run on the database with t, unless the
+// context it is run with has a tracer of its own. A nil t turns tracing
+// off again.
+//
+// Queries are traced as a sgsql.query span with a sgsql.parse span and a
+// sgsql.statement span for each statement, which holds the spans of
+// analyzing, optimizing and executing it, like sgsql.scan for reading a
+// table.
+func (db *DB) SetTracer(t trace.Tracer) {
+	db.tracer.Store(tracerBox{t})
+}
+
+// startQuery starts the span of running query, giving ctx the database's
+// tracer if it has none.
+func (db *DB) startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	if _, ok := trace.FromContext(ctx); !ok {
+		if box, ok := db.tracer.Load().(tracerBox); ok && box.t != nil {
+			ctx = trace.WithTracer(ctx, box.t)
+		}
+	}
+
+	ctx, span := trace.Start(ctx, "sgsql.query")
+	if query != "" {
+		span.SetAttribute("db.statement", parser.Redact(query))
+	}
+
+	return ctx, span
+}
+
+// parse parses query in a span of its own.
+func parse(ctx context.Context, query string) (*parser.AST, error) {
+	_, span := trace.Start(ctx, "sgsql.parse")
+	defer span.End()
+
+	ast, err := parser.Parse(query)
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	return ast, err
+}
+
+// endSpan ends span, recording err on it first if there was one.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
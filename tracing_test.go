@@ -0,0 +1,142 @@
+package sgsql
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nireo/sgsql/trace"
+)
+
+type spanKey struct{}
+
+// spanTracer keeps every span it starts, named by the path of spans down
+// to it.
+type spanTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	path  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (r *spanTracer) Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	span := &testSpan{path: name, attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		span.path = parent.path + "/" + name
+	}
+
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	r.mu.Unlock()
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// find returns the span at path.
+func (r *spanTracer) find(path string) (*testSpan, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, span := range r.spans {
+		if span.path == path {
+			return span, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *testSpan) RecordError(err error)                      { s.err = err }
+func (s *testSpan) End()                                       { s.ended = true }
+
+func TestTracing(t *testing.T) {
+	tests := []struct {
+		query string
+		// spans are paths of spans the query starts
+		spans []string
+		// failed is the span that records the error of the query
+		failed string
+	}{
+		{"select id from t where id = 1", []string{
+			"sgsql.query",
+			"sgsql.query/sgsql.parse",
+			"sgsql.query/sgsql.statement",
+			"sgsql.query/sgsql.statement/sgsql.analyze",
+			"sgsql.query/sgsql.statement/sgsql.optimize",
+			"sgsql.query/sgsql.statement/sgsql.scan",
+		}, ""},
+		{"insert into t values (2); select 1", []string{
+			"sgsql.query/sgsql.statement",
+			"sgsql.query/sgsql.commit",
+		}, ""},
+		{"selec 1", []string{"sgsql.query/sgsql.parse"}, "sgsql.query/sgsql.parse"},
+		{"select id from missing", []string{"sgsql.query/sgsql.statement/sgsql.analyze"}, "sgsql.query/sgsql.statement/sgsql.analyze"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			db, _ := openTest(t)
+			mustExec(t, db, "create table t (id int)", "insert into t values (1)")
+
+			r := &spanTracer{}
+			db.SetTracer(r)
+			_, err := db.Query(tt.query)
+			if (err != nil) != (tt.failed != "") {
+				t.Fatalf("got error %v, want it recorded on %q", err, tt.failed)
+			}
+
+			for _, path := range tt.spans {
+				span, ok := r.find(path)
+				if !ok {
+					t.Errorf("no span %s", path)
+					continue
+				}
+				if !span.ended {
+					t.Errorf("span %s wasn't ended", path)
+				}
+			}
+
+			query, ok := r.find("sgsql.query")
+			if !ok || query.attrs["db.statement"] != tt.query {
+				t.Errorf("the query span has no db.statement %q", tt.query)
+			}
+			if tt.failed != "" {
+				for _, path := range []string{tt.failed, "sgsql.query"} {
+					if span, ok := r.find(path); !ok || span.err == nil {
+						t.Errorf("span %s didn't record the error", path)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTracerOfContext(t *testing.T) {
+	db, _ := openTest(t)
+	dbTracer, ctxTracer := &spanTracer{}, &spanTracer{}
+	db.SetTracer(dbTracer)
+
+	c := db.Conn()
+	defer c.Close()
+	if err := c.ExecContext(trace.WithTracer(context.Background(), ctxTracer), "select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(dbTracer.spans) != 0 || len(ctxTracer.spans) == 0 {
+		t.Errorf("the tracer of the database started %d spans and the tracer of the context %d, want only the latter",
+			len(dbTracer.spans), len(ctxTracer.spans))
+	}
+
+	db.SetTracer(nil)
+	if err := db.Exec("select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(dbTracer.spans) != 0 {
+		t.Errorf("started %d spans with tracing turned off", len(dbTracer.spans))
+	}
+}
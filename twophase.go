@@ -0,0 +1,309 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/logging"
+	"github.com/nireo/sgsql/parser"
+)
+
+var (
+	ErrNoPrepared   = errors.New("Prepared transaction does not exist")
+	ErrPreparedInTx = errors.New("COMMIT PREPARED and ROLLBACK PREPARED can't run inside a transaction block")
+)
+
+// preparedTx is the transaction of a database prepared with PREPARE
+// TRANSACTION. Only one transaction runs at a time, so there is at most one
+// and it keeps the database locked until it is committed or rolled back.
+type preparedTx struct {
+	mu sync.Mutex
+	id string
+	tx *Tx
+	// at is when the transaction was prepared, zero if it was recovered
+	// from the statement log
+	at time.Time
+}
+
+// Prepare ends the transaction without committing or rolling it back, so
+// its changes can be committed later with DB.CommitPrepared, from any
+// session and even after the database was closed and opened again, or
+// discarded with DB.RollbackPrepared. This is the first phase of a two-phase
+// commit: a coordinator prepares the transactions of every database taking
+// part, and commits them once all of them are prepared.
+//
+// Until it is resolved the transaction keeps the database locked, every
+// other transaction waits for it, so the session that prepared it must not
+// start another one on the database before it is committed or rolled back.
+// The transactions on the databases attached to the session are prepared
+// with the same id.
+func (tx *Tx) Prepare(id string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	// Nothing was changed to keep for later
+	if tx.dryRun() {
+		return tx.Commit()
+	}
+
+	tx.done = true
+	if tx.ownsConn {
+		defer tx.conn.Close()
+	}
+
+	aliases := tx.attachedAliases()
+	if err := tx.prepare(id); err != nil {
+		for _, alias := range aliases {
+			tx.attached[alias].Rollback()
+		}
+		return err
+	}
+
+	for i, alias := range aliases {
+		if err := tx.attached[alias].prepare(id); err != nil {
+			tx.db.RollbackPrepared(id)
+			for _, prepared := range aliases[:i] {
+				tx.attached[prepared].db.RollbackPrepared(id)
+			}
+			for _, rest := range aliases[i+1:] {
+				tx.attached[rest].Rollback()
+			}
+			return fmt.Errorf("Attached database %s: %w", alias, err)
+		}
+	}
+
+	return nil
+}
+
+// prepare prepares the transaction on the database of tx alone. Its
+// statements are written to the statement log marked with id, the entry
+// committing or rolling them back comes later. The deferred constraints
+// are checked now, so committing it can't fail on them.
+func (tx *Tx) prepare(id string) error {
+	if err := tx.db.backend.CheckDeferred(tx.snapshot); err != nil {
+		tx.db.backend.Restore(tx.snapshot)
+		tx.db.mu.Unlock()
+		tx.db.logEvent(context.Background(), logging.LevelDebug, "Prepare failed", "error", err)
+		return err
+	}
+
+	if tx.db.log != nil {
+		entries := []logEntry{{Query: "PREPARE TRANSACTION " + literal(id), Prepared: id}}
+		for _, entry := range tx.pending {
+			entry.Prepared = id
+			entries = append(entries, entry)
+		}
+
+		written, err := tx.db.appendLog(entries)
+		if err == nil {
+			err = tx.db.waitSync(written, false)
+		}
+		if err != nil {
+			tx.db.backend.Restore(tx.snapshot)
+			tx.db.mu.Unlock()
+			tx.db.logEvent(context.Background(), logging.LevelError, "Prepare failed", "error", err)
+			return err
+		}
+	}
+
+	tx.db.prepared.mu.Lock()
+	tx.db.prepared.id, tx.db.prepared.tx, tx.db.prepared.at = id, tx, time.Now().UTC()
+	tx.db.prepared.mu.Unlock()
+
+	tx.db.logEvent(context.Background(), logging.LevelDebug, "Transaction prepared", "id", id)
+	return nil
+}
+
+// takePrepared returns the transaction prepared as id and forgets it, or
+// nil if there is none.
+func (db *DB) takePrepared(id string) *Tx {
+	db.prepared.mu.Lock()
+	defer db.prepared.mu.Unlock()
+
+	tx := db.prepared.tx
+	if tx == nil || db.prepared.id != id {
+		return nil
+	}
+
+	db.prepared.id, db.prepared.tx = "", nil
+	return tx
+}
+
+// CommitPrepared commits the transaction prepared as id, see Tx.Prepare.
+// Once it returns the changes are as permanent as those of Tx.Commit.
+func (db *DB) CommitPrepared(id string) error {
+	tx := db.takePrepared(id)
+	if tx == nil {
+		return fmt.Errorf("%w: %s", ErrNoPrepared, id)
+	}
+
+	sequences := tx.sequenceEntries()
+	entries := append([]logEntry{{Query: "COMMIT PREPARED " + literal(id)}}, sequences...)
+	if err := db.resolvePrepared(entries, append(tx.pending, sequences...)); err != nil {
+		return err
+	}
+
+	db.deliver(tx.notifications)
+	db.logEvent(context.Background(), logging.LevelDebug, "Prepared transaction committed", "id", id)
+	return nil
+}
+
+// RollbackPrepared discards the changes of the transaction prepared as id,
+// see Tx.Prepare.
+func (db *DB) RollbackPrepared(id string) error {
+	tx := db.takePrepared(id)
+	if tx == nil {
+		return fmt.Errorf("%w: %s", ErrNoPrepared, id)
+	}
+
+	db.backend.Restore(tx.snapshot)
+	db.logEvent(context.Background(), logging.LevelDebug, "Prepared transaction rolled back", "id", id)
+
+	sequences := tx.sequenceEntries()
+	entries := append([]logEntry{{Query: "ROLLBACK PREPARED " + literal(id)}}, sequences...)
+	return db.resolvePrepared(entries, sequences)
+}
+
+// resolvePrepared writes the entries ending the prepared transaction,
+// publishes the changes it made in the end and unlocks the database it kept
+// locked.
+func (db *DB) resolvePrepared(entries, changes []logEntry) error {
+	if db.log == nil {
+		db.backend.RecordVersions(time.Now().UTC())
+		db.publish(changes)
+		db.mu.Unlock()
+		return nil
+	}
+
+	written, err := db.appendLog(entries)
+	if err == nil {
+		db.backend.RecordVersions(time.Now().UTC())
+		db.publish(changes)
+	}
+	db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return db.waitSync(written, false)
+}
+
+// abandonPrepared unlocks the database kept locked by its prepared
+// transaction without resolving it, which is left to whoever opens the
+// database next.
+func (db *DB) abandonPrepared() {
+	db.prepared.mu.Lock()
+	defer db.prepared.mu.Unlock()
+
+	if db.prepared.tx != nil {
+		db.prepared.id, db.prepared.tx = "", nil
+		db.mu.Unlock()
+	}
+}
+
+// recoverPrepared prepares again the transaction the statement log left
+// prepared as id, made of entries, when the database is opened.
+func (db *DB) recoverPrepared(id string, entries []logEntry) error {
+	tx := db.begin(false, functions.NewSession(db.backend))
+	for _, entry := range entries {
+		if err := db.replayEntry(tx.session, entry); err != nil {
+			db.backend.Restore(tx.snapshot)
+			db.mu.Unlock()
+			return err
+		}
+	}
+
+	tx.done, tx.pending = true, entries
+	db.prepared.id, db.prepared.tx, db.prepared.at = id, tx, time.Time{}
+	return nil
+}
+
+// preparedInfo lists the prepared transaction for __prepared_xacts.
+func (db *DB) preparedInfo() []backend.PreparedInfo {
+	db.prepared.mu.Lock()
+	defer db.prepared.mu.Unlock()
+
+	if db.prepared.tx == nil {
+		return nil
+	}
+	return []backend.PreparedInfo{{ID: db.prepared.id, At: db.prepared.at}}
+}
+
+// execPrepared runs COMMIT PREPARED or ROLLBACK PREPARED, resolving the
+// transaction prepared with the id of stmt on the database of the session
+// and on each database attached to it.
+func (c *Conn) execPrepared(stmt *parser.Statement) (*Results, error) {
+	if c.tx != nil {
+		return nil, ErrPreparedInTx
+	}
+
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	if c.dryRun {
+		return &Results{}, nil
+	}
+
+	resolve := (*DB).CommitPrepared
+	if stmt.Type == parser.RollbackPreparedType {
+		resolve = (*DB).RollbackPrepared
+	}
+
+	id := stmt.TwoPhaseStatement.ID.Value
+	dbs := []*DB{c.db}
+	for _, a := range c.attached {
+		dbs = append(dbs, a.db)
+	}
+
+	found := false
+	var err error
+	for _, db := range dbs {
+		rerr := resolve(db, id)
+		if errors.Is(rerr, ErrNoPrepared) {
+			continue
+		}
+
+		found = true
+		if err == nil {
+			err = rerr
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrNoPrepared, id)
+	}
+	return &Results{}, err
+}
+
+// prepareTx runs PREPARE TRANSACTION, which prepares the transaction opened
+// with BEGIN. Preparing an aborted transaction rolls it back instead.
+func (c *Conn) prepareTx(stmt *parser.TwoPhaseStatement) (*Results, error) {
+	if c.tx == nil {
+		return nil, ErrNoTx
+	}
+
+	tx, aborted := c.tx, c.aborted
+	c.tx, c.aborted, c.cursors = nil, false, nil
+
+	if aborted {
+		if err := tx.Rollback(); err != nil {
+			return nil, err
+		}
+		return nil, ErrTxRolledBack
+	}
+
+	return &Results{}, tx.Prepare(stmt.ID.Value)
+}
+
+// literal returns s as an SQL string literal.
+func literal(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
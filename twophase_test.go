@@ -0,0 +1,161 @@
+package sgsql
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTwoPhaseCommit(t *testing.T) {
+	tests := []struct {
+		name string
+		// steps are run in one session, "reopen" closes the database and
+		// opens it again in a new one
+		steps []string
+		rows  [][]interface{}
+	}{
+		{
+			"commit",
+			[]string{"begin", "insert into t values (1)", "prepare transaction 'a'", "commit prepared 'a'"},
+			[][]interface{}{{int64(1)}},
+		},
+		{
+			"rollback",
+			[]string{"begin", "insert into t values (1)", "prepare transaction 'a'", "rollback prepared 'a'"},
+			nil,
+		},
+		{
+			"commit after reopening",
+			[]string{"begin", "insert into t values (1)", "prepare transaction 'a'", "reopen", "commit prepared 'a'"},
+			[][]interface{}{{int64(1)}},
+		},
+		{
+			"rollback after reopening",
+			[]string{"begin", "insert into t values (1)", "prepare transaction 'a'", "reopen", "rollback prepared 'a'"},
+			nil,
+		},
+		{
+			"later transactions",
+			[]string{"begin", "insert into t values (1)", "prepare transaction 'a'", "commit prepared 'a'", "insert into t values (2)"},
+			[][]interface{}{{int64(1)}, {int64(2)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			mustExec(t, db, "create table t (id int)")
+
+			c := db.Conn()
+			for _, step := range tt.steps {
+				if step == "reopen" {
+					c.Close()
+					db = reopen(t, db, path)
+					c = db.Conn()
+					continue
+				}
+				mustExec(t, c, step)
+			}
+			c.Close()
+
+			if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("t holds %v, want %v", got, tt.rows)
+			}
+
+			db = reopen(t, db, path)
+			if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("t holds %v after reopening, want %v", got, tt.rows)
+			}
+		})
+	}
+}
+
+func TestTwoPhaseCommitErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		err     string
+	}{
+		{"unknown id", []string{"commit prepared 'missing'"}, ErrNoPrepared.Error()},
+		{"unknown id rolled back", []string{"rollback prepared 'missing'"}, ErrNoPrepared.Error()},
+		{"without a transaction", []string{"prepare transaction 'a'"}, ErrNoTx.Error()},
+		{"inside a transaction", []string{"begin", "commit prepared 'a'"}, ErrPreparedInTx.Error()},
+		{"aborted", []string{"begin", "create table t (id int)", "prepare transaction 'a'"}, ErrTxRolledBack.Error()},
+		{"deferred constraint", []string{"begin", "insert into t values (1)", "insert into t values (1)", "prepare transaction 'a'", "commit prepared 'a'"},
+			ErrNoPrepared.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := openTest(t)
+			mustExec(t, db, "create table t (id int unique initially deferred)")
+
+			c := db.Conn()
+			t.Cleanup(func() { c.Close() })
+			var err error
+			for _, query := range tt.queries {
+				err = c.Exec(query)
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTwoPhaseCommitAttached(t *testing.T) {
+	tests := []struct {
+		resolve string
+		rows    [][]interface{}
+	}{
+		{"commit prepared 'a'", [][]interface{}{{int64(1)}}},
+		{"rollback prepared 'a'", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resolve, func(t *testing.T) {
+			dir := t.TempDir()
+			aux, err := Open(filepath.Join(dir, "aux.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustExec(t, aux, "create table t (id int)")
+			if err := aux.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			db, path := openTest(t)
+			db.SetAttachDir(dir)
+
+			c := db.Conn()
+			mustExec(t, c,
+				"create table t (id int)",
+				"attach database 'aux.db' as aux",
+				"begin",
+				"insert into t values (1)",
+				"insert into aux.t values (1)",
+				"prepare transaction 'a'",
+				tt.resolve,
+			)
+			c.Close()
+
+			db = reopen(t, db, path)
+			db.SetAttachDir(dir)
+			c = db.Conn()
+			t.Cleanup(func() { c.Close() })
+			mustExec(t, c, "attach database 'aux.db' as aux")
+
+			for _, table := range []string{"t", "aux.t"} {
+				results, err := c.Query("select id from " + table)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(results.Rows, tt.rows) {
+					t.Errorf("%s holds %v, want %v", table, results.Rows, tt.rows)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package sgsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nireo/sgsql/analyzer"
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/functions"
+	"github.com/nireo/sgsql/logging"
+	"github.com/nireo/sgsql/parser"
+	"github.com/nireo/sgsql/trace"
+)
+
+var ErrTxControl = errors.New("Transaction control statements can't run inside Tx, use Commit or Rollback")
+
+// Tx is a transaction. Its changes are visible to nothing else until Commit
+// and are discarded by Rollback.
+type Tx struct {
+	db       *DB
+	snapshot *backend.MemorySnapshot
+	session  *functions.Session
+	pending  []logEntry
+	readOnly bool
+	done     bool
+	// conn is the session the transaction was started in, ownsConn is set
+	// when the session was made just for it
+	conn     *Conn
+	ownsConn bool
+	// attached are the transactions on the databases attached to the
+	// session by their aliases, which end with this one
+	attached map[string]*Tx
+	// notifications are delivered once the transaction commits
+	notifications []Notification
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) error {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) error {
+	_, err := tx.QueryContext(ctx, query, args...)
+	return err
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*Results, error) {
+	return tx.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext is Query with a context, see Conn.QueryContext.
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Results, error) {
+	ctx, span := tx.db.startQuery(ctx, query)
+	done := func(err error) error { return err }
+	if tx.conn != nil {
+		var err error
+		if ctx, done, err = tx.conn.track(ctx, query); err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+	}
+
+	results, err := tx.query(ctx, query, args)
+	err = done(err)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (tx *Tx) query(ctx context.Context, query string, args []interface{}) (*Results, error) {
+	ast, err := parse(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rejected up front so no statement of the query runs at all
+	if tx.readOnly && modifies(ast.Statements...) {
+		return nil, ErrReadOnly
+	}
+
+	results := &Results{}
+	for _, stmt := range ast.Statements {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		results, err = tx.exec(ctx, stmt, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Default generates the value of an identity column in a row passed to
+// BulkInsert, the way DEFAULT does in INSERT.
+var Default = backend.Default
+
+// BulkInsert appends rows to table, which is much faster than inserting them
+// one statement at a time. The values are Go values like those bound to
+// placeholders, or Default, and either all of the rows are inserted or none.
+// The rows are written to the log as a single entry. In a dry run nothing
+// is inserted.
+func (tx *Tx) BulkInsert(table string, rows [][]interface{}) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if tx.readOnly {
+		return ErrReadOnly
+	}
+
+	if tx.dryRun() {
+		return nil
+	}
+
+	if attached, name, ok := tx.attachedTx(table); ok {
+		return attached.BulkInsert(name, rows)
+	}
+
+	insert, params, err := tx.db.backend.BulkInsert(table, rows, tx.session)
+	if err != nil {
+		return err
+	}
+
+	if len(params) > 0 {
+		tx.pending = append(tx.pending, logEntry{Query: insert, Rows: params})
+	}
+	return nil
+}
+
+// modifies reports whether any of stmts would change the database.
+func modifies(stmts ...*parser.Statement) bool {
+	for _, stmt := range stmts {
+		switch stmt.Type {
+		case parser.SelectType:
+			if callsModifying(stmt.SelectStatement) {
+				return true
+			}
+		case parser.DeclareCursorType:
+			if callsModifying(stmt.DeclareCursorStatement.Query) {
+				return true
+			}
+		case parser.BeginType, parser.CommitType, parser.RollbackType,
+			parser.FetchType, parser.CloseType, parser.ExplainType, parser.CheckTableType,
+			parser.ShowType, parser.RecommendIndexesType, parser.KillType, parser.AttachType, parser.DetachType, parser.DiscardType,
+			parser.ListenType, parser.NotifyType, parser.PrepareTransactionType, parser.ReloadConfigType:
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// logged reports whether stmt is written to the statement log. Queries are
+// not, even when they advance sequences, those are logged separately.
+func logged(stmt *parser.Statement) bool {
+	return stmt.Type != parser.SelectType && stmt.Type != parser.DeclareCursorType &&
+		modifies(stmt)
+}
+
+// callsModifying reports whether slct calls a function that changes the
+// database, like NEXTVAL.
+func callsModifying(slct *parser.SelectStatement) bool {
+	var walk func(exp *parser.Expression) bool
+	walk = func(exp *parser.Expression) bool {
+		if exp == nil {
+			return false
+		}
+
+		switch exp.Type {
+		case parser.BinaryType:
+			return walk(&exp.Binary.A) || walk(&exp.Binary.B)
+		case parser.CastType:
+			return walk(&exp.Cast.Exp)
+		case parser.IndexType:
+			return walk(&exp.Index.Exp) || walk(&exp.Index.Index)
+		case parser.ArrayType, parser.RowType:
+			for i := range exp.Array {
+				if walk(&exp.Array[i]) {
+					return true
+				}
+			}
+			for i := range exp.Row {
+				if walk(&exp.Row[i]) {
+					return true
+				}
+			}
+		case parser.ExistsType:
+			return callsModifying(exp.Exists.Query)
+		case parser.SubqueryType:
+			return callsModifying(exp.Subquery)
+		case parser.NotType:
+			return walk(exp.Not)
+		case parser.InType:
+			if walk(&exp.In.Exp) {
+				return true
+			}
+			for i := range exp.In.List {
+				if walk(&exp.In.List[i]) {
+					return true
+				}
+			}
+		case parser.CallType:
+			if f, ok := functions.Lookup(exp.Call.Name.Value); ok && f.Modifies {
+				return true
+			}
+			for i := range exp.Call.Args {
+				if walk(&exp.Call.Args[i]) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	for _, item := range slct.Item {
+		if walk(item.Exp) {
+			return true
+		}
+	}
+
+	if slct.Function != nil && walk(slct.Function) {
+		return true
+	}
+
+	if slct.ConnectBy != nil && walk(slct.ConnectBy.Start) {
+		return true
+	}
+
+	for _, order := range slct.OrderBy {
+		if walk(order.Exp) {
+			return true
+		}
+	}
+
+	return walk(slct.Where) || walk(slct.Limit) || walk(slct.Offset)
+}
+
+// sequenceEntries returns the log entries restoring the sequences advanced
+// since they were last taken.
+func (tx *Tx) sequenceEntries() []logEntry {
+	entries := []logEntry{}
+	for _, seq := range tx.db.backend.TakeSequenceLog() {
+		entries = append(entries, logEntry{
+			Query:  "SELECT setval($1, $2)",
+			Params: []interface{}{seq.Name, seq.Value},
+		})
+	}
+
+	return entries
+}
+
+// exec runs a single statement in the transaction.
+func (tx *Tx) exec(ctx context.Context, stmt *parser.Statement, args []interface{}) (*Results, error) {
+	// The text of a statement may hold a key to redact
+	text := parser.Redact(stmt.Text)
+	ctx, span := trace.Start(ctx, "sgsql.statement")
+	if text != "" {
+		span.SetAttribute("db.statement", text)
+	}
+
+	tx.db.logEvent(ctx, logging.LevelDebug, "Statement started", "statement", text)
+	start := time.Now()
+
+	results, err := tx.execStatement(ctx, stmt, args)
+	endSpan(span, err)
+	if !tx.dryRun() {
+		tx.db.audit(tx.conn, tx.session, stmt, err)
+	}
+
+	elapsed := time.Since(start)
+	if err != nil {
+		tx.db.logEvent(ctx, logging.LevelWarn, "Statement failed", "statement", text, "error", err)
+	} else {
+		tx.db.logEvent(ctx, logging.LevelDebug, "Statement finished", "statement", text,
+			"duration", elapsed, "rows", len(results.Rows))
+	}
+	tx.db.logSlow(ctx, text, elapsed)
+
+	return results, err
+}
+
+func (tx *Tx) execStatement(ctx context.Context, stmt *parser.Statement, args []interface{}) (*Results, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+
+	switch stmt.Type {
+	case parser.BeginType, parser.CommitType, parser.RollbackType,
+		parser.PrepareTransactionType, parser.CommitPreparedType, parser.RollbackPreparedType:
+		return nil, ErrTxControl
+	}
+
+	if tx.readOnly && modifies(stmt) {
+		return nil, ErrReadOnly
+	}
+
+	switch stmt.Type {
+	case parser.ListenType:
+		if tx.conn == nil {
+			return nil, ErrListenNoSession
+		}
+		tx.conn.listen(stmt.ListenStatement)
+		return &Results{}, nil
+	case parser.NotifyType:
+		if !tx.dryRun() {
+			tx.notify(stmt.NotifyStatement)
+		}
+		return &Results{}, nil
+	}
+
+	var catalog backend.Catalog = tx.db.backend
+	if len(tx.attached) > 0 {
+		if !tx.dryRun() {
+			if results, ok, err := tx.execAttached(ctx, stmt, args); ok {
+				return results, err
+			}
+		} else if _, _, _, err := tx.attachedTarget(stmt); err != nil {
+			return nil, err
+		}
+
+		catalog = attachedCatalog{Catalog: tx.db.backend, attached: tx.attached}
+		ctx = backend.WithAttached(ctx, tx.attachedBackends())
+	}
+
+	if stmt.Type == parser.ExplainType && stmt.ExplainStatement.Validate {
+		return tx.validate(ctx, catalog, stmt.ExplainStatement.Statement), nil
+	}
+
+	_, span := trace.Start(ctx, "sgsql.analyze")
+	err := analyzer.Analyze(catalog, stmt)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if logged(stmt) {
+		if err := tx.db.backend.CheckReplayable(ctx, stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	if tx.dryRun() {
+		return &Results{}, tx.db.backend.Validate(ctx, stmt)
+	}
+
+	if stmt.Type == parser.CheckTableType {
+		return tx.checkTable()
+	}
+
+	if tx.conn != nil {
+		ctx = backend.WithSessionID(ctx, tx.conn.ID())
+	}
+
+	// The history of the table isn't replayed, so the log records how many
+	// rows were kept rather than the time
+	if stmt.Type == parser.FlashbackType && stmt.FlashbackStatement.Rows == nil {
+		rows, err := tx.db.backend.FlashbackRows(ctx, stmt.FlashbackStatement, tx.session, args)
+		if err != nil {
+			return nil, err
+		}
+
+		flashback := parser.FlashbackStatement{Table: stmt.FlashbackStatement.Table, Rows: &rows}
+		stmt = &parser.Statement{FlashbackStatement: &flashback, Type: parser.FlashbackType, Text: flashback.String()}
+		args = nil
+	}
+
+	tx.session.TakeValues()
+	tx.session.TakeIDs()
+	tx.session.TakeTimes()
+	tx.session.TakeRandom()
+	results, err := backend.Exec(ctx, tx.db.backend, stmt, tx.session, args)
+	if err == nil {
+		err = checkRows(ctx, results)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if logged(stmt) {
+		entry := logEntry{
+			Query:   stmt.Text,
+			Params:  args,
+			Nextval: tx.session.TakeValues(),
+			IDs:     tx.session.TakeIDs(),
+			Times:   tx.session.TakeTimes(),
+			Random:  tx.session.TakeRandom(),
+		}
+		if user := tx.session.User(); user != functions.DefaultUser {
+			entry.User = user
+		}
+		tx.pending = append(tx.pending, entry)
+	}
+
+	if err := tx.indexForeignKeys(ctx, stmt); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Commit makes the transaction's changes permanent, writing them to the
+// statement log before returning. Unless the database was made asynchronous
+// with SetSynchronous, Commit returns once they are synced to disk, and an
+// ErrSyncFailed leaves it unknown whether they survive a crash. The
+// deferred foreign keys and unique constraints are checked first, and the
+// transaction is rolled back if its changes break one.
+//
+// The changes to attached databases are committed after those to the
+// database of the session, each to its own log. Should one of those commits
+// fail, the changes committed before it stay committed.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if tx.ownsConn {
+		defer tx.conn.Close()
+	}
+
+	aliases := tx.attachedAliases()
+	if err := tx.commit(); err != nil {
+		for _, alias := range aliases {
+			tx.attached[alias].Rollback()
+		}
+		return err
+	}
+	tx.db.deliver(tx.notifications)
+
+	for i, alias := range aliases {
+		if err := tx.attached[alias].Commit(); err != nil {
+			for _, rest := range aliases[i+1:] {
+				tx.attached[rest].Rollback()
+			}
+			return fmt.Errorf("Attached database %s: %w", alias, err)
+		}
+	}
+
+	return nil
+}
+
+// commit commits the changes to the database of tx alone, once they meet
+// the deferred constraints.
+func (tx *Tx) commit() error {
+	if err := tx.db.backend.CheckDeferred(tx.snapshot); err != nil {
+		tx.db.backend.Restore(tx.snapshot)
+		tx.db.mu.Unlock()
+		tx.db.logEvent(context.Background(), logging.LevelDebug, "Commit failed", "error", err)
+		return err
+	}
+
+	// Sequences are restored after the statements that created them
+	entries := append(tx.pending, tx.sequenceEntries()...)
+	if tx.db.log == nil || len(entries) == 0 {
+		if len(entries) > 0 {
+			tx.db.backend.RecordVersions(time.Now().UTC())
+		}
+		tx.db.publish(entries)
+		tx.db.mu.Unlock()
+		tx.db.logEvent(context.Background(), logging.LevelDebug, "Transaction committed", "entries", 0)
+		return nil
+	}
+
+	written, err := tx.db.appendLog(entries)
+	if err != nil {
+		tx.db.backend.Restore(tx.snapshot)
+		tx.db.mu.Unlock()
+		tx.db.logEvent(context.Background(), logging.LevelError, "Commit failed", "error", err)
+		return err
+	}
+	tx.db.backend.RecordVersions(time.Now().UTC())
+	tx.db.publish(entries)
+	tx.db.mu.Unlock()
+
+	// Waiting after unlocking lets the transactions after this one write
+	// their entries meanwhile, to be synced together with them
+	if err := tx.db.waitSync(written, false); err != nil {
+		tx.db.logEvent(context.Background(), logging.LevelError, "Commit failed", "error", err)
+		return err
+	}
+
+	tx.db.logEvent(context.Background(), logging.LevelDebug, "Transaction committed", "entries", len(entries))
+	return nil
+}
+
+// Rollback discards the transaction's changes. Values handed out by the
+// sequences that remain are not rolled back and are still logged.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if tx.ownsConn {
+		defer tx.conn.Close()
+	}
+
+	for _, attached := range tx.attached {
+		attached.Rollback()
+	}
+
+	tx.db.backend.Restore(tx.snapshot)
+	tx.db.logEvent(context.Background(), logging.LevelDebug, "Transaction rolled back")
+
+	entries := tx.sequenceEntries()
+	if tx.db.log == nil || len(entries) == 0 {
+		tx.db.publish(entries)
+		tx.db.mu.Unlock()
+		return nil
+	}
+
+	written, err := tx.db.appendLog(entries)
+	if err == nil {
+		tx.db.publish(entries)
+	}
+	tx.db.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return tx.db.waitSync(written, false)
+}
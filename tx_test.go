@@ -0,0 +1,207 @@
+package sgsql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionTransactions(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		// errs are parts of the errors of the queries, empty for those
+		// succeeding
+		errs []string
+		rows [][]interface{}
+	}{
+		{
+			"commit",
+			[]string{"begin", "insert into t values (1)", "insert into t values (2)", "commit"},
+			nil,
+			[][]interface{}{{int64(1)}, {int64(2)}},
+		},
+		{
+			"rollback",
+			[]string{"insert into t values (1)", "begin", "insert into t values (2)", "rollback"},
+			nil,
+			[][]interface{}{{int64(1)}},
+		},
+		{
+			"aborted",
+			[]string{"begin", "insert into t values (1)", "create table t (id int)", "insert into t values (2)", "commit"},
+			[]string{"", "", "already exists", ErrTxAborted.Error(), ErrTxRolledBack.Error()},
+			nil,
+		},
+		{
+			"nested begin",
+			[]string{"begin", "begin", "insert into t values (1)", "commit"},
+			[]string{"", ErrTxInProgress.Error(), "", ""},
+			[][]interface{}{{int64(1)}},
+		},
+		{
+			"commit without begin",
+			[]string{"commit", "rollback", "insert into t values (1)"},
+			[]string{ErrNoTx.Error(), ErrNoTx.Error(), ""},
+			[][]interface{}{{int64(1)}},
+		},
+		{
+			"left open",
+			[]string{"insert into t values (1)", "begin", "insert into t values (2)"},
+			nil,
+			[][]interface{}{{int64(1)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			mustExec(t, db, "create table t (id int)")
+
+			c := db.Conn()
+			t.Cleanup(func() { c.Close() })
+			for i, query := range tt.queries {
+				want := ""
+				if tt.errs != nil {
+					want = tt.errs[i]
+				}
+				err := c.Exec(query)
+				if (err == nil) != (want == "") || (err != nil && !strings.Contains(err.Error(), want)) {
+					t.Fatalf("%s: got %v, want an error containing %q", query, err, want)
+				}
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("t holds %v, want %v", got, tt.rows)
+			}
+
+			db = reopen(t, db, path)
+			if got := queryRows(t, db, "select id from t"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("t holds %v after reopening, want %v", got, tt.rows)
+			}
+		})
+	}
+}
+
+func TestDeferredConstraints(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		// errs are parts of the errors of the queries, empty for those
+		// succeeding
+		errs []string
+		rows [][]interface{}
+	}{
+		{
+			"referenced before commit",
+			[]string{"begin", "insert into c values (2, 1)", "insert into p values (2)", "commit"},
+			nil,
+			[][]interface{}{{int64(1), int64(1)}, {int64(2), int64(1)}},
+		},
+		{
+			"not referenced",
+			[]string{"begin", "insert into c values (2, 1)", "insert into c values (3, 1)", "commit", "insert into c values (4, 1)"},
+			[]string{"", "", "", "Foreign key violation", "Foreign key violation"},
+			[][]interface{}{{int64(1), int64(1)}},
+		},
+		{
+			"duplicate",
+			[]string{"begin", "insert into c values (1, 2)", "insert into p values (1)", "commit"},
+			[]string{"", "", "", "Unique violation"},
+			[][]interface{}{{int64(1), int64(1)}},
+		},
+		{
+			"rolled back",
+			[]string{"begin", "insert into c values (2, 1)", "rollback", "insert into p values (2)"},
+			nil,
+			[][]interface{}{{int64(1), int64(1)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTest(t)
+			mustExec(t, db, "create table p (id int)")
+			mustExec(t, db, "create table c (pid int references p (id) deferrable initially deferred, n int)")
+			mustExec(t, db, "alter table p add unique (id) deferrable initially deferred")
+			mustExec(t, db, "insert into p values (1)")
+			mustExec(t, db, "insert into c values (1, 1)")
+
+			c := db.Conn()
+			t.Cleanup(func() { c.Close() })
+			for i, query := range tt.queries {
+				want := ""
+				if tt.errs != nil {
+					want = tt.errs[i]
+				}
+				err := c.Exec(query)
+				if (err == nil) != (want == "") || (err != nil && !strings.Contains(err.Error(), want)) {
+					t.Fatalf("%s: got %v, want an error containing %q", query, err, want)
+				}
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := queryRows(t, db, "select pid, n from c"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("c holds %v, want %v", got, tt.rows)
+			}
+
+			// The rows are replayed in the order they were inserted
+			db = reopen(t, db, path)
+			if got := queryRows(t, db, "select pid, n from c"); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("c holds %v after reopening, want %v", got, tt.rows)
+			}
+		})
+	}
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		slow      bool
+	}{
+		{"off", 0, false},
+		{"above", time.Hour, false},
+		{"below", time.Nanosecond, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(MemoryPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			r := &recorder{}
+			db.SetLogger(r)
+			db.SetSlowQueryThreshold(tt.threshold)
+			if err := db.Exec("create table t (a int); insert into t values (1)"); err != nil {
+				t.Fatal(err)
+			}
+			db.Exec("select b from t")
+
+			var slow []string
+			for i, text := range r.text {
+				if text == "Slow statement" {
+					slow = append(slow, r.text[i+2])
+				}
+			}
+			if !tt.slow {
+				if len(slow) != 0 {
+					t.Errorf("logged %q as slow", slow)
+				}
+				return
+			}
+			if want := []string{"create table t (a int)", "insert into t values (1)", "select b from t"}; !reflect.DeepEqual(slow, want) {
+				t.Errorf("logged %q as slow, want %q", slow, want)
+			}
+		})
+	}
+}
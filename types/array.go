@@ -0,0 +1,179 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// arrayFlag marks a Type as an array of the type in the lower bits.
+const arrayFlag Type = 1 << 8
+
+// ArrayOf returns the type of arrays of elem.
+func ArrayOf(elem Type) Type {
+	return elem | arrayFlag
+}
+
+// IsArray reports whether t is an array type.
+func (t Type) IsArray() bool {
+	return t&arrayFlag != 0
+}
+
+// Elem returns the element type of an array type.
+func (t Type) Elem() Type {
+	return t &^ arrayFlag
+}
+
+// Array is an array value. Its elements are all of type Elem or NULL, and
+// it is indexed from one in SQL.
+type Array struct {
+	Elem   Type
+	Values []interface{}
+}
+
+// String formats a like '{1,2,NULL}', quoting text elements where needed.
+func (a Array) String() string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range a.Values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		if v == nil {
+			b.WriteString("NULL")
+			continue
+		}
+
+		s := castText(v)
+		if s == "" || strings.EqualFold(s, "null") || strings.ContainsAny(s, "{},\"\\ \t\n") {
+			s = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+		}
+		b.WriteString(s)
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+func (a Array) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Index returns the element at the one-based index i, or NULL when i is out
+// of range.
+func (a Array) Index(i int64) interface{} {
+	if i < 1 || i > int64(len(a.Values)) {
+		return nil
+	}
+
+	return a.Values[i-1]
+}
+
+// ParseArray parses the text form of an array of elem, like '{1,2,3}' or
+// '{"a b",NULL}'.
+func ParseArray(s string, elem Type) (Array, error) {
+	invalid := fmt.Errorf("%w: %q is not an array", ErrInvalidCast, s)
+
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return Array{}, invalid
+	}
+	s = s[1 : len(s)-1]
+
+	a := Array{Elem: elem, Values: []interface{}{}}
+	if strings.TrimSpace(s) == "" {
+		return a, nil
+	}
+
+	for i := 0; ; i++ {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+
+		var field string
+		quoted := i < len(s) && s[i] == '"'
+		if quoted {
+			var b strings.Builder
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return Array{}, invalid
+			}
+			i++
+			field = b.String()
+		} else {
+			start := i
+			for i < len(s) && s[i] != ',' {
+				if s[i] == '{' || s[i] == '"' {
+					return Array{}, invalid
+				}
+				i++
+			}
+			field = strings.TrimSpace(s[start:i])
+		}
+
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+
+		if !quoted && strings.EqualFold(field, "null") {
+			a.Values = append(a.Values, nil)
+		} else {
+			v, err := Cast(field, elem)
+			if err != nil {
+				return Array{}, err
+			}
+			a.Values = append(a.Values, v)
+		}
+
+		if i >= len(s) {
+			return a, nil
+		}
+		if s[i] != ',' {
+			return Array{}, invalid
+		}
+	}
+}
+
+// castArray converts every element of a to elem.
+func castArray(a Array, elem Type) (Array, error) {
+	converted := Array{Elem: elem, Values: make([]interface{}, len(a.Values))}
+	for i, v := range a.Values {
+		c, err := Cast(v, elem)
+		if err != nil {
+			return Array{}, err
+		}
+		converted.Values[i] = c
+	}
+
+	return converted, nil
+}
+
+func applyArray(op string, l, r Array) (interface{}, error) {
+	if op != "=" && op != "<>" && op != "!=" {
+		return nil, fmt.Errorf("%w: %v %s %v", ErrInvalidOperands, l, op, r)
+	}
+
+	equal := len(l.Values) == len(r.Values)
+	for i := 0; equal && i < len(l.Values); i++ {
+		switch {
+		case l.Values[i] == nil || r.Values[i] == nil:
+			equal = l.Values[i] == nil && r.Values[i] == nil
+		default:
+			eq, err := Apply("=", l.Values[i], r.Values[i])
+			if err != nil {
+				return nil, err
+			}
+			equal = eq == true
+		}
+	}
+
+	if op == "=" {
+		return equal, nil
+	}
+	return !equal, nil
+}
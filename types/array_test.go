@@ -0,0 +1,104 @@
+package types
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseArray(t *testing.T) {
+	tests := []struct {
+		s    string
+		elem Type
+		want []interface{}
+		text string
+	}{
+		{"{1,2,3}", Int, []interface{}{int64(1), int64(2), int64(3)}, "{1,2,3}"},
+		{" { 1 , NULL } ", Int, []interface{}{int64(1), nil}, "{1,NULL}"},
+		{"{}", Int, []interface{}{}, "{}"},
+		{`{a,"b c","",NULL,"null","x\"y"}`, Text, []interface{}{"a", "b c", "", nil, "null", `x"y`}, `{a,"b c","",NULL,"null","x\"y"}`},
+		{"{1.5,2}", Float, []interface{}{1.5, 2.0}, "{1.5,2}"},
+		{"{t,false}", Bool, []interface{}{true, false}, "{true,false}"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseArray(tt.s, tt.elem)
+		if err != nil || got.Elem != tt.elem || !reflect.DeepEqual(got.Values, tt.want) {
+			t.Errorf("ParseArray(%q) = %v, %v, want %v", tt.s, got.Values, err, tt.want)
+			continue
+		}
+		if got.String() != tt.text {
+			t.Errorf("%q formatted as %s, want %s", tt.s, got, tt.text)
+		}
+
+		// Arrays cast to text read back the same
+		again, err := ParseArray(got.String(), tt.elem)
+		if err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("%s read back as %v, %v", got, again, err)
+		}
+	}
+
+	for _, s := range []string{"", "1,2", "{1,2", "{1,,2}", "{{1},{2}}", `{"a}`, `{"a"b}`} {
+		if _, err := ParseArray(s, Int); err == nil {
+			t.Errorf("ParseArray(%q) succeeded", s)
+		}
+	}
+	if _, err := ParseArray("{1,x}", Int); !errors.Is(err, ErrInvalidCast) {
+		t.Errorf("got %v, want %v", err, ErrInvalidCast)
+	}
+}
+
+func TestArrayIndex(t *testing.T) {
+	a := Array{Elem: Int, Values: []interface{}{int64(10), nil, int64(30)}}
+
+	for i, want := range map[int64]interface{}{0: nil, 1: int64(10), 2: nil, 3: int64(30), 4: nil, -1: nil} {
+		if got := a.Index(i); got != want {
+			t.Errorf("Index(%d) = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestArrayCoercion(t *testing.T) {
+	ints := Array{Elem: Int, Values: []interface{}{int64(1), nil, int64(3)}}
+
+	tests := []struct {
+		op   string
+		l, r interface{}
+		want interface{}
+	}{
+		{"=", ints, Array{Elem: Int, Values: []interface{}{int64(1), nil, int64(3)}}, true},
+		{"=", ints, Array{Elem: Int, Values: []interface{}{int64(1), nil}}, false},
+		{"<>", ints, Array{Elem: Int, Values: []interface{}{int64(1), int64(2), int64(3)}}, true},
+		// Text meeting an array is one of its type
+		{"=", ints, "{1,NULL,3}", true},
+		{"=", "{1,2}", ints, false},
+	}
+
+	for _, tt := range tests {
+		got, err := Apply(tt.op, tt.l, tt.r)
+		if err != nil || got != tt.want {
+			t.Errorf("Apply(%q, %v, %v) = %v, %v, want %v", tt.op, tt.l, tt.r, got, err, tt.want)
+		}
+	}
+
+	if _, err := Apply("<", ints, ints); !errors.Is(err, ErrInvalidOperands) {
+		t.Errorf("got %v comparing arrays, want %v", err, ErrInvalidOperands)
+	}
+
+	texts, err := Cast(ints, ArrayOf(Text))
+	if want := (Array{Elem: Text, Values: []interface{}{"1", nil, "3"}}); err != nil || !reflect.DeepEqual(texts, want) {
+		t.Errorf("Cast(%v, text[]) = %#v, %v", ints, texts, err)
+	}
+	if _, err := Cast(Array{Elem: Text, Values: []interface{}{"x"}}, ArrayOf(Int)); !errors.Is(err, ErrInvalidCast) {
+		t.Errorf("got %v, want %v", err, ErrInvalidCast)
+	}
+	if Castable(ArrayOf(Int), Int) || !Castable(ArrayOf(Int), Text) || !Assignable(Text, ArrayOf(Int)) {
+		t.Error("Array casts are misreported")
+	}
+	if ArrayOf(Int).String() != "int[]" || !ArrayOf(Text).IsArray() || ArrayOf(UUID).Elem() != UUID {
+		t.Error("Array types are misreported")
+	}
+	if typ, ok := Parse("TEXT[]"); !ok || typ != ArrayOf(Text) {
+		t.Errorf("Parse(TEXT[]) = %s, %v", typ, ok)
+	}
+}
@@ -0,0 +1,491 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The coercion rules are:
+//
+//   - int is promoted to float wherever the two meet, in arithmetic,
+//     comparisons and when an int is stored in a float column
+//   - text compared with or stored as a timestamp, interval, uuid or array
+//     is converted to it, so literals like '2024-01-01' or '{1,2}' can be
+//     written without a cast
+//   - no other conversion happens implicitly, text and numbers only convert
+//     through Cast
+//   - int arithmetic that doesn't fit in 64 bits is an error rather than
+//     wrapping around, as is float arithmetic that overflows to infinity
+//     and text cast to a float that isn't finite, like 'NaN' or 'inf'
+
+// Common returns the type both l and r are converted to before an operator
+// is applied to them.
+func Common(l, r Type) (Type, bool) {
+	switch {
+	case l == r:
+		return l, true
+	case l == Int && r == Float, l == Float && r == Int:
+		return Float, true
+	case fromText(l) && r == Text:
+		return l, true
+	case l == Text && fromText(r):
+		return r, true
+	}
+
+	return 0, false
+}
+
+// Binary returns the type of applying op to operands of type l and r.
+func Binary(op string, l, r Type) (Type, bool) {
+	switch op {
+	case "and", "or":
+		return Bool, l == Bool && r == Bool
+	case "||":
+		return Text, l == Text && r == Text
+	}
+
+	if IsMatch(op) {
+		return Bool, l == Text && r == Text
+	}
+
+	l, r = resolveText(op, l, r)
+	if t, ok := binaryTemporal(op, l, r); ok {
+		return t, true
+	}
+
+	t, ok := Common(l, r)
+	if !ok {
+		return 0, false
+	}
+
+	switch op {
+	case "=", "<>", "!=":
+		return Bool, true
+	case "<", "<=", ">", ">=":
+		return Bool, t != Bool && !t.IsArray()
+	case "+", "-", "*", "/":
+		return t, t == Int || t == Float
+	}
+
+	return 0, false
+}
+
+// resolveText returns the types text operands of op are converted to when
+// they meet a timestamp, interval, uuid or array. Text is taken to be of the same
+// type as the other operand, except that adding to an interval needs a
+// timestamp.
+func resolveText(op string, l, r Type) (Type, Type) {
+	switch {
+	case op == "+" && l == Interval && r == Text:
+		return l, Timestamp
+	case op == "+" && l == Text && r == Interval:
+		return Timestamp, r
+	case fromText(l) && r == Text:
+		return l, l
+	case l == Text && fromText(r):
+		return r, r
+	case l.IsArray() && r == Text:
+		return l, l
+	case l == Text && r.IsArray():
+		return r, r
+	}
+
+	return l, r
+}
+
+// fromText reports whether text meeting values of type t is converted to
+// t, arrays aside.
+func fromText(t Type) bool {
+	return temporal(t) || t == UUID
+}
+
+// Apply applies op to l and r, which must not be NULL.
+func Apply(op string, l, r interface{}) (interface{}, error) {
+	invalid := fmt.Errorf("%w: %v %s %v", ErrInvalidOperands, l, op, r)
+
+	lt, lok := Of(l)
+	rt, rok := Of(r)
+	if !lok || !rok {
+		return nil, invalid
+	}
+
+	if _, ok := Binary(op, lt, rt); !ok {
+		return nil, invalid
+	}
+
+	if IsMatch(op) {
+		re, err := CompilePattern(op, r.(string))
+		if err != nil {
+			return nil, err
+		}
+		return Match(op, l.(string), re), nil
+	}
+
+	// Text meeting a timestamp, interval, uuid or array is converted first
+	var err error
+	nl, nr := resolveText(op, lt, rt)
+	if nl != lt {
+		l, err = Cast(l, nl)
+	}
+	if nr != rt && err == nil {
+		r, err = Cast(r, nr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lt, rt = nl, nr
+
+	if _, ok := binaryTemporal(op, lt, rt); ok {
+		return applyTemporal(op, l, r)
+	}
+
+	t, _ := Common(lt, rt)
+	switch {
+	case t == Float:
+		l, r = toFloat(l), toFloat(r)
+	case temporal(t):
+		return applyTemporal(op, l, r)
+	}
+
+	if t.IsArray() {
+		return applyArray(op, l.(Array), r.(Array))
+	}
+
+	switch t {
+	case Int:
+		return applyInt(op, l.(int64), r.(int64))
+	case Float:
+		return applyFloat(op, l.(float64), r.(float64))
+	case Text:
+		return applyText(op, l.(string), r.(string))
+	case Bool:
+		return applyBool(op, l.(bool), r.(bool))
+	case UUID:
+		return compareUUIDs(op, l.(UUIDValue), r.(UUIDValue))
+	}
+
+	return nil, invalid
+}
+
+func toFloat(v interface{}) interface{} {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+
+	return v
+}
+
+func applyInt(op string, l, r int64) (interface{}, error) {
+	switch op {
+	case "=":
+		return l == r, nil
+	case "<>", "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "+":
+		v := l + r
+		if (v > l) != (r > 0) {
+			return nil, fmt.Errorf("%w: %d + %d", ErrOutOfRange, l, r)
+		}
+		return v, nil
+	case "-":
+		v := l - r
+		if (v < l) != (r > 0) {
+			return nil, fmt.Errorf("%w: %d - %d", ErrOutOfRange, l, r)
+		}
+		return v, nil
+	case "*":
+		if l == 0 || r == 0 {
+			return int64(0), nil
+		}
+		v := l * r
+		if v/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			return nil, fmt.Errorf("%w: %d * %d", ErrOutOfRange, l, r)
+		}
+		return v, nil
+	case "/":
+		if r == 0 {
+			return nil, ErrDivisionByZero
+		}
+		if l == math.MinInt64 && r == -1 {
+			return nil, fmt.Errorf("%w: %d / %d", ErrOutOfRange, l, r)
+		}
+		return l / r, nil
+	}
+
+	return nil, fmt.Errorf("%w: %d %s %d", ErrInvalidOperands, l, op, r)
+}
+
+func applyFloat(op string, l, r float64) (interface{}, error) {
+	var v float64
+	switch op {
+	case "=":
+		return l == r, nil
+	case "<>", "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "+":
+		v = l + r
+	case "-":
+		v = l - r
+	case "*":
+		v = l * r
+	case "/":
+		if r == 0 {
+			return nil, ErrDivisionByZero
+		}
+		v = l / r
+	default:
+		return nil, fmt.Errorf("%w: %v %s %v", ErrInvalidOperands, l, op, r)
+	}
+
+	if math.IsInf(v, 0) && !math.IsInf(l, 0) && !math.IsInf(r, 0) {
+		return nil, fmt.Errorf("%w: %v %s %v", ErrOutOfRange, l, op, r)
+	}
+
+	return v, nil
+}
+
+func applyText(op string, l, r string) (interface{}, error) {
+	switch op {
+	case "=":
+		return l == r, nil
+	case "<>", "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "||":
+		return l + r, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q %s %q", ErrInvalidOperands, l, op, r)
+}
+
+func applyBool(op string, l, r bool) (interface{}, error) {
+	switch op {
+	case "=":
+		return l == r, nil
+	case "<>", "!=":
+		return l != r, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v %s %v", ErrInvalidOperands, l, op, r)
+}
+
+// Assignable reports whether values of type from can be stored in a column
+// of type to.
+func Assignable(from, to Type) bool {
+	return from == to || (from == Int && to == Float) ||
+		(from == Text && (fromText(to) || to.IsArray()))
+}
+
+// Assign converts v for storing in a column of type t.
+func Assign(v interface{}, t Type) (interface{}, bool) {
+	if v == nil {
+		return nil, true
+	}
+
+	from, ok := Of(v)
+	if !ok || !Assignable(from, t) {
+		return nil, false
+	}
+
+	if t == Float {
+		return toFloat(v), true
+	}
+
+	if fromText(t) || t.IsArray() {
+		v, err := Cast(v, t)
+		return v, err == nil
+	}
+
+	return v, true
+}
+
+// Castable reports whether Cast can convert values of type from to type to.
+// A cast from text can still fail on the particular value.
+func Castable(from, to Type) bool {
+	switch {
+	case from == to, from == Text || to == Text:
+		return true
+	case from.IsArray() && to.IsArray():
+		return Castable(from.Elem(), to.Elem())
+	case fromText(from) || fromText(to), from.IsArray() || to.IsArray():
+		return false
+	}
+
+	return !(from == Float && to == Bool) && !(from == Bool && to == Float)
+}
+
+// Cast explicitly converts v to type t. Floats are rounded to the nearest
+// int, and text must spell a value of t.
+func Cast(v interface{}, t Type) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	from, ok := Of(v)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported value %v", ErrInvalidCast, v)
+	}
+
+	if !Castable(from, t) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidCast, from, t)
+	}
+
+	if t.IsArray() {
+		switch v := v.(type) {
+		case string:
+			return ParseArray(v, t.Elem())
+		case Array:
+			return castArray(v, t.Elem())
+		}
+	}
+
+	switch t {
+	case Text:
+		return castText(v), nil
+	case Int:
+		return castInt(v)
+	case Float:
+		return castFloat(v)
+	case Bool:
+		return castBool(v)
+	case Timestamp:
+		if s, ok := v.(string); ok {
+			return ParseTimestamp(s)
+		}
+		return v, nil
+	case Interval:
+		if s, ok := v.(string); ok {
+			return ParseInterval(s)
+		}
+		return v, nil
+	case UUID:
+		if s, ok := v.(string); ok {
+			return ParseUUID(s)
+		}
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s to %s", ErrInvalidCast, from, t)
+}
+
+func castText(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	case time.Time:
+		return FormatTimestamp(v)
+	case IntervalValue:
+		return v.String()
+	case UUIDValue:
+		return v.String()
+	case Array:
+		return v.String()
+	}
+
+	return ""
+}
+
+func castInt(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		r := math.Round(v)
+		// float64(MaxInt64) rounds up to 2^63, which doesn't fit
+		if math.IsNaN(r) || r < math.MinInt64 || r >= math.MaxInt64 {
+			return nil, fmt.Errorf("%w: %v for int", ErrOutOfRange, v)
+		}
+		return int64(r), nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+				return nil, fmt.Errorf("%w: %q for int", ErrOutOfRange, v)
+			}
+			return nil, fmt.Errorf("%w: %q is not an int", ErrInvalidCast, v)
+		}
+		return i, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v to int", ErrInvalidCast, v)
+}
+
+func castFloat(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+				return nil, fmt.Errorf("%w: %q for float", ErrOutOfRange, v)
+			}
+			return nil, fmt.Errorf("%w: %q is not a float", ErrInvalidCast, v)
+		}
+		// Arithmetic never makes NaN or infinity, so casts don't either
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%w: %q for float", ErrOutOfRange, v)
+		}
+		return f, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v to float", ErrInvalidCast, v)
+}
+
+func castBool(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "t", "true", "yes", "on", "1":
+			return true, nil
+		case "f", "false", "no", "off", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%w: %q is not a bool", ErrInvalidCast, v)
+	}
+
+	return nil, fmt.Errorf("%w: %v to bool", ErrInvalidCast, v)
+}
@@ -0,0 +1,172 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBinary(t *testing.T) {
+	tests := []struct {
+		op   string
+		l, r Type
+		want Type
+		ok   bool
+	}{
+		{"+", Int, Int, Int, true},
+		{"+", Int, Float, Float, true},
+		{"/", Float, Int, Float, true},
+		{"+", Int, Text, 0, false},
+		{"||", Text, Text, Text, true},
+		{"||", Text, Int, 0, false},
+		{"=", Int, Float, Bool, true},
+		{"=", Text, Int, 0, false},
+		{"<", Bool, Bool, 0, false},
+		{"=", Bool, Bool, Bool, true},
+		{"<", Timestamp, Text, Bool, true},
+		{"=", Text, UUID, Bool, true},
+		{"=", ArrayOf(Int), Text, Bool, true},
+		{"<", ArrayOf(Int), ArrayOf(Int), 0, false},
+		{"-", Timestamp, Timestamp, Interval, true},
+		{"+", Timestamp, Interval, Timestamp, true},
+		{"+", Text, Interval, Timestamp, true},
+		{"and", Bool, Bool, Bool, true},
+		{"and", Bool, Int, 0, false},
+		{"like", Text, Text, Bool, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := Binary(tt.op, tt.l, tt.r)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("Binary(%q, %s, %s) = %s, %v, want %s, %v", tt.op, tt.l, tt.r, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		op   string
+		l, r interface{}
+		want interface{}
+		err  error
+	}{
+		{"+", int64(1), int64(2), int64(3), nil},
+		{"+", int64(1), 0.5, 1.5, nil},
+		{"=", int64(2), 2.0, true, nil},
+		{"/", int64(7), int64(2), int64(3), nil},
+		{"/", int64(7), 2.0, 3.5, nil},
+		{"/", int64(1), int64(0), nil, ErrDivisionByZero},
+		{"/", 1.0, 0.0, nil, ErrDivisionByZero},
+		{"||", "a", "b", "ab", nil},
+		{"<", "a", "b", true, nil},
+		{"+", "a", int64(1), nil, ErrInvalidOperands},
+		{"<", true, false, nil, ErrInvalidOperands},
+
+		// Integers don't wrap around
+		{"+", int64(math.MaxInt64), int64(1), nil, ErrOutOfRange},
+		{"+", int64(math.MaxInt64), int64(0), int64(math.MaxInt64), nil},
+		{"+", int64(math.MinInt64), int64(-1), nil, ErrOutOfRange},
+		{"-", int64(math.MinInt64), int64(1), nil, ErrOutOfRange},
+		{"-", int64(0), int64(math.MinInt64), nil, ErrOutOfRange},
+		{"-", int64(-1), int64(math.MaxInt64), int64(math.MinInt64), nil},
+		{"*", int64(math.MaxInt64), int64(2), nil, ErrOutOfRange},
+		{"*", int64(math.MinInt64), int64(-1), nil, ErrOutOfRange},
+		{"*", int64(-1), int64(math.MinInt64), nil, ErrOutOfRange},
+		{"*", int64(math.MinInt64), int64(1), int64(math.MinInt64), nil},
+		{"*", int64(1 << 32), int64(1 << 31), nil, ErrOutOfRange},
+		{"/", int64(math.MinInt64), int64(-1), nil, ErrOutOfRange},
+
+		// Nor do floats overflow to infinity, unless they already are
+		{"*", math.MaxFloat64, 2.0, nil, ErrOutOfRange},
+		{"+", math.Inf(1), 1.0, math.Inf(1), nil},
+
+		{"-", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), "2024-01-01", IntervalValue{Duration: 24 * time.Hour}, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := Apply(tt.op, tt.l, tt.r)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Apply(%q, %v, %v) = %v, %v, want %v", tt.op, tt.l, tt.r, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Apply(%q, %v, %v) = %v, %v, want %v", tt.op, tt.l, tt.r, got, err, tt.want)
+		}
+	}
+}
+
+func TestAssign(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		t    Type
+		want interface{}
+		ok   bool
+	}{
+		{nil, Int, nil, true},
+		{int64(3), Float, 3.0, true},
+		{3.5, Int, nil, false},
+		{"3", Int, nil, false},
+		{int64(1), Text, nil, false},
+		{"2024-01-01", Timestamp, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"not a time", Timestamp, nil, false},
+		{"{1,2}", ArrayOf(Int), Array{Elem: Int, Values: []interface{}{int64(1), int64(2)}}, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := Assign(tt.v, tt.t)
+		if ok != tt.ok || (ok && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("Assign(%#v, %s) = %#v, %v, want %#v, %v", tt.v, tt.t, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCast(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		t    Type
+		want interface{}
+		err  error
+	}{
+		{2.5, Int, int64(3), nil},
+		{-2.5, Int, int64(-3), nil},
+		{" 42 ", Int, int64(42), nil},
+		{"-9223372036854775808", Int, int64(math.MinInt64), nil},
+		{"9223372036854775808", Int, nil, ErrOutOfRange},
+		{"forty", Int, nil, ErrInvalidCast},
+		{float64(math.MaxInt64), Int, nil, ErrOutOfRange},
+		{-9223372036854775808.0, Int, int64(math.MinInt64), nil},
+		{math.NaN(), Int, nil, ErrOutOfRange},
+		{true, Int, int64(1), nil},
+		{"1e400", Float, nil, ErrOutOfRange},
+		{"1.5", Float, 1.5, nil},
+		{"NaN", Float, nil, ErrOutOfRange},
+		{"nan", Float, nil, ErrOutOfRange},
+		{"inf", Float, nil, ErrOutOfRange},
+		{" -Infinity", Float, nil, ErrOutOfRange},
+		{"+inf", Float, nil, ErrOutOfRange},
+		{int64(math.MinInt64), Text, "-9223372036854775808", nil},
+		{0.1, Text, "0.1", nil},
+		{"yes", Bool, true, nil},
+		{"maybe", Bool, nil, ErrInvalidCast},
+		{1.0, Bool, nil, ErrInvalidCast},
+		{int64(1), Timestamp, nil, ErrInvalidCast},
+		{nil, Int, nil, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := Cast(tt.v, tt.t)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Cast(%#v, %s) = %#v, %v, want %v", tt.v, tt.t, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Cast(%#v, %s) = %#v, %v, want %#v", tt.v, tt.t, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package types
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Collation decides how text is compared.
+type Collation struct {
+	Name    string
+	Compare func(a, b string) int
+}
+
+// DefaultCollation compares text byte by byte.
+const DefaultCollation = "binary"
+
+var collations = map[string]*Collation{
+	"binary":  {Name: "binary", Compare: strings.Compare},
+	"nocase":  {Name: "nocase", Compare: compareASCIIFold},
+	"unicode": {Name: "unicode", Compare: compareUnicodeFold},
+}
+
+// LookupCollation returns the collation called name.
+func LookupCollation(name string) (*Collation, bool) {
+	c, ok := collations[strings.ToLower(name)]
+	return c, ok
+}
+
+// compareASCIIFold ignores the case of ASCII letters only, like SQLite's
+// NOCASE.
+func compareASCIIFold(a, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := a[i], b[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+
+		if ca != cb {
+			if ca < cb {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// compareUnicodeFold ignores case across all of Unicode, comparing the
+// lower case forms of the characters.
+func compareUnicodeFold(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		a, b = a[na:], b[nb:]
+
+		ra, rb = unicode.ToLower(ra), unicode.ToLower(rb)
+		if ra != rb {
+			if ra < rb {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case a == "" && b != "":
+		return -1
+	case a != "" && b == "":
+		return 1
+	}
+	return 0
+}
+
+// ApplyCollated is Apply with comparisons between text done by c. A nil c
+// is the default collation.
+func ApplyCollated(op string, l, r interface{}, c *Collation) (interface{}, error) {
+	ls, lok := l.(string)
+	rs, rok := r.(string)
+	if c == nil || !lok || !rok {
+		return Apply(op, l, r)
+	}
+
+	n := c.Compare(ls, rs)
+	switch op {
+	case "=":
+		return n == 0, nil
+	case "<>", "!=":
+		return n != 0, nil
+	case "<":
+		return n < 0, nil
+	case "<=":
+		return n <= 0, nil
+	case ">":
+		return n > 0, nil
+	case ">=":
+		return n >= 0, nil
+	}
+
+	return Apply(op, l, r)
+}
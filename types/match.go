@@ -0,0 +1,81 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidPattern = errors.New("Invalid pattern")
+
+// IsMatch reports whether op matches text against a pattern: LIKE, ILIKE,
+// or one of the regular expression operators ~, ~*, !~, !~* and REGEXP.
+func IsMatch(op string) bool {
+	switch op {
+	case "like", "ilike", "~", "~*", "!~", "!~*", "regexp":
+		return true
+	}
+
+	return false
+}
+
+// CompilePattern compiles the pattern of a match operator. LIKE patterns
+// are turned into the equivalent regular expression.
+func CompilePattern(op, pattern string) (*regexp.Regexp, error) {
+	var expr string
+	switch op {
+	case "like", "ilike":
+		expr = "(?s)^" + likeToRegexp(pattern) + "$"
+		if op == "ilike" {
+			expr = "(?i)" + expr
+		}
+	case "~*", "!~*":
+		expr = "(?i)" + pattern
+	default:
+		expr = pattern
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPattern, err)
+	}
+
+	return re, nil
+}
+
+// likeToRegexp translates % and _, which a backslash escapes.
+func likeToRegexp(pattern string) string {
+	var b strings.Builder
+	literal := func(s string) {
+		b.WriteString(regexp.QuoteMeta(s))
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			literal(pattern[i : i+1])
+		default:
+			literal(pattern[i : i+1])
+		}
+	}
+
+	return b.String()
+}
+
+// Match applies a match operator to s with its compiled pattern.
+func Match(op, s string, re *regexp.Regexp) bool {
+	matched := re.MatchString(s)
+	if strings.HasPrefix(op, "!") {
+		return !matched
+	}
+
+	return matched
+}
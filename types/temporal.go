@@ -0,0 +1,389 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IntervalValue is a span of time. Months are kept apart from the rest
+// because how long a month is depends on the timestamp it is added to.
+type IntervalValue struct {
+	Months   int64
+	Duration time.Duration
+}
+
+// approx is the length of i with every month taken as 30 days, which is how
+// intervals are compared.
+func (i IntervalValue) approx() time.Duration {
+	return time.Duration(i.Months)*30*24*time.Hour + i.Duration
+}
+
+func (i IntervalValue) String() string {
+	var parts []string
+	plural := func(n int64, unit string) {
+		if n == 1 || n == -1 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, unit))
+		} else if n != 0 {
+			parts = append(parts, fmt.Sprintf("%d %ss", n, unit))
+		}
+	}
+
+	plural(i.Months/12, "year")
+	plural(i.Months%12, "mon")
+
+	d := i.Duration
+	days := int64(d / (24 * time.Hour))
+	plural(days, "day")
+	d -= time.Duration(days) * 24 * time.Hour
+
+	if d != 0 || len(parts) == 0 {
+		sign := ""
+		if d < 0 {
+			sign, d = "-", -d
+		}
+
+		s := fmt.Sprintf("%s%02d:%02d:%02d", sign, int64(d/time.Hour), int64(d/time.Minute%60), int64(d/time.Second%60))
+		if micros := int64(d / time.Microsecond % 1e6); micros != 0 {
+			s += strings.TrimRight(fmt.Sprintf(".%06d", micros), "0")
+		}
+		parts = append(parts, s)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (i IntervalValue) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+var intervalUnits = map[string]time.Duration{
+	"microsecond": time.Microsecond, "microseconds": time.Microsecond, "us": time.Microsecond,
+	"millisecond": time.Millisecond, "milliseconds": time.Millisecond, "ms": time.Millisecond,
+	"second": time.Second, "seconds": time.Second, "sec": time.Second, "secs": time.Second, "s": time.Second,
+	"minute": time.Minute, "minutes": time.Minute, "min": time.Minute, "mins": time.Minute, "m": time.Minute,
+	"hour": time.Hour, "hours": time.Hour, "hr": time.Hour, "hrs": time.Hour, "h": time.Hour,
+	"day": 24 * time.Hour, "days": 24 * time.Hour, "d": 24 * time.Hour,
+	"week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour, "w": 7 * 24 * time.Hour,
+}
+
+var intervalMonths = map[string]int64{
+	"month": 1, "months": 1, "mon": 1, "mons": 1,
+	"year": 12, "years": 12, "y": 12,
+}
+
+// ParseInterval parses intervals such as "1 day", "2 hours 30 minutes",
+// "1 year 2 mons 03:04:05" or "3 days ago". Intervals whose months or rest
+// don't fit in 64 bits are out of range.
+func ParseInterval(s string) (IntervalValue, error) {
+	invalid := fmt.Errorf("%w: %q is not an interval", ErrInvalidCast, s)
+	outOfRange := fmt.Errorf("%w: %q for interval", ErrOutOfRange, s)
+
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 {
+		return IntervalValue{}, invalid
+	}
+
+	ago := fields[len(fields)-1] == "ago"
+	if ago {
+		fields = fields[:len(fields)-1]
+		if len(fields) == 0 {
+			return IntervalValue{}, invalid
+		}
+	}
+
+	var iv IntervalValue
+
+	for i := 0; i < len(fields); i++ {
+		if strings.Contains(fields[i], ":") {
+			d, err := parseClock(fields[i])
+			if errors.Is(err, ErrOutOfRange) {
+				return IntervalValue{}, outOfRange
+			}
+			if err != nil {
+				return IntervalValue{}, invalid
+			}
+
+			var ok bool
+			if iv, ok = addIntervals(iv, IntervalValue{Duration: d}); !ok {
+				return IntervalValue{}, outOfRange
+			}
+			continue
+		}
+
+		n, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || i+1 == len(fields) {
+			return IntervalValue{}, invalid
+		}
+		i++
+
+		var part IntervalValue
+		if unit, ok := intervalUnits[fields[i]]; ok {
+			d, ok := fitInt64(n * float64(unit))
+			if !ok {
+				return IntervalValue{}, outOfRange
+			}
+			part.Duration = time.Duration(d)
+		} else if months, ok := intervalMonths[fields[i]]; ok {
+			m, ok := fitInt64(math.Round(n * float64(months)))
+			if !ok {
+				return IntervalValue{}, outOfRange
+			}
+			part.Months = m
+		} else {
+			return IntervalValue{}, invalid
+		}
+
+		var ok bool
+		if iv, ok = addIntervals(iv, part); !ok {
+			return IntervalValue{}, outOfRange
+		}
+	}
+
+	if ago {
+		if iv.Months == math.MinInt64 || iv.Duration == math.MinInt64 {
+			return IntervalValue{}, outOfRange
+		}
+		iv.Months, iv.Duration = -iv.Months, -iv.Duration
+	}
+
+	return iv, nil
+}
+
+// fitInt64 returns f as an int64, or false if it is outside the range of
+// one.
+func fitInt64(f float64) (int64, bool) {
+	// float64(MaxInt64) rounds up to 2^63, which doesn't fit
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+
+	return int64(f), true
+}
+
+// addIntervals adds l and r, or returns false if their months or the rest
+// of them don't fit in 64 bits.
+func addIntervals(l, r IntervalValue) (IntervalValue, bool) {
+	months, d := l.Months+r.Months, l.Duration+r.Duration
+	if (months > l.Months) != (r.Months > 0) || (d > l.Duration) != (r.Duration > 0) {
+		return IntervalValue{}, false
+	}
+
+	return IntervalValue{Months: months, Duration: d}, true
+}
+
+// parseClock parses [-]HH:MM[:SS[.fraction]]. Clocks too long for a
+// time.Duration are out of range.
+func parseClock(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, ErrInvalidCast
+	}
+
+	h, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	var sec float64
+	if len(parts) == 3 {
+		if sec, err = strconv.ParseFloat(parts[2], 64); err != nil || sec < 0 {
+			return 0, ErrInvalidCast
+		}
+	}
+
+	d, ok := fitInt64(float64(h)*float64(time.Hour) + float64(m)*float64(time.Minute) + sec*float64(time.Second))
+	if !ok {
+		return 0, ErrOutOfRange
+	}
+
+	return sign * time.Duration(d), nil
+}
+
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTimestamp parses timestamps such as "2024-03-01", "2024-03-01
+// 12:30:00" or RFC 3339. Timestamps without a zone are taken to be in UTC.
+func ParseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: %q is not a timestamp", ErrInvalidCast, s)
+}
+
+// FormatTimestamp formats t the way timestamps are cast to text.
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.999999")
+}
+
+func temporal(t Type) bool {
+	return t == Timestamp || t == Interval
+}
+
+// binaryTemporal returns the type of arithmetic involving timestamps and
+// intervals.
+func binaryTemporal(op string, l, r Type) (Type, bool) {
+	number := func(t Type) bool { return t == Int || t == Float }
+
+	switch {
+	case op == "+" && l == Timestamp && r == Interval,
+		op == "+" && l == Interval && r == Timestamp,
+		op == "-" && l == Timestamp && r == Interval:
+		return Timestamp, true
+	case op == "-" && l == Timestamp && r == Timestamp,
+		(op == "+" || op == "-") && l == Interval && r == Interval,
+		(op == "*" || op == "/") && l == Interval && number(r),
+		op == "*" && number(l) && r == Interval:
+		return Interval, true
+	}
+
+	return 0, false
+}
+
+func applyTemporal(op string, l, r interface{}) (interface{}, error) {
+	invalid := fmt.Errorf("%w: %v %s %v", ErrInvalidOperands, l, op, r)
+
+	switch lv := l.(type) {
+	case time.Time:
+		switch rv := r.(type) {
+		case time.Time:
+			if op == "-" {
+				return IntervalValue{Duration: lv.Sub(rv)}, nil
+			}
+			return compareTimestamps(op, lv, rv, invalid)
+		case IntervalValue:
+			if op == "-" {
+				rv = IntervalValue{Months: -rv.Months, Duration: -rv.Duration}
+			} else if op != "+" {
+				return nil, invalid
+			}
+			return addInterval(lv, rv), nil
+		}
+	case IntervalValue:
+		switch rv := r.(type) {
+		case time.Time:
+			if op == "+" {
+				return addInterval(rv, lv), nil
+			}
+		case IntervalValue:
+			switch op {
+			case "+", "-":
+				outOfRange := fmt.Errorf("%w: %v %s %v", ErrOutOfRange, l, op, r)
+				if op == "-" {
+					if rv.Months == math.MinInt64 || rv.Duration == math.MinInt64 {
+						return nil, outOfRange
+					}
+					rv = IntervalValue{Months: -rv.Months, Duration: -rv.Duration}
+				}
+
+				sum, ok := addIntervals(lv, rv)
+				if !ok {
+					return nil, outOfRange
+				}
+				return sum, nil
+			}
+			return compareDurations(op, lv.approx(), rv.approx(), invalid)
+		case int64, float64:
+			f, _ := toFloat(rv).(float64)
+			return scaleInterval(op, lv, f, invalid)
+		}
+	case int64, float64:
+		if rv, ok := r.(IntervalValue); ok && op == "*" {
+			f, _ := toFloat(lv).(float64)
+			return scaleInterval(op, rv, f, invalid)
+		}
+	}
+
+	return nil, invalid
+}
+
+// addInterval adds iv to t. Adding months keeps the day of the month unless
+// the resulting month is shorter, then it is the last day of that month.
+func addInterval(t time.Time, iv IntervalValue) time.Time {
+	if iv.Months != 0 {
+		year, month, day := t.Date()
+		first := time.Date(year, month+time.Month(iv.Months), 1, 0, 0, 0, 0, time.UTC)
+		if last := first.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+
+		clock := t.Sub(t.Truncate(24 * time.Hour))
+		t = first.AddDate(0, 0, day-1).Add(clock)
+	}
+
+	return t.Add(iv.Duration)
+}
+
+func scaleInterval(op string, iv IntervalValue, f float64, invalid error) (interface{}, error) {
+	switch op {
+	case "*":
+	case "/":
+		if f == 0 {
+			return nil, ErrDivisionByZero
+		}
+		f = 1 / f
+	default:
+		return nil, invalid
+	}
+
+	d, ok := fitInt64(float64(iv.Duration) * f)
+	months, monthsOk := fitInt64(math.Round(float64(iv.Months) * f))
+	if !ok || !monthsOk {
+		return nil, fmt.Errorf("%w: %v %s %v", ErrOutOfRange, iv, op, f)
+	}
+
+	return IntervalValue{Months: months, Duration: time.Duration(d)}, nil
+}
+
+func compareTimestamps(op string, l, r time.Time, invalid error) (interface{}, error) {
+	c := time.Duration(0)
+	if l.Before(r) {
+		c = -1
+	} else if l.After(r) {
+		c = 1
+	}
+
+	return compareDurations(op, c, 0, invalid)
+}
+
+func compareDurations(op string, l, r time.Duration, invalid error) (interface{}, error) {
+	switch op {
+	case "=":
+		return l == r, nil
+	case "<>", "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	}
+
+	return nil, invalid
+}
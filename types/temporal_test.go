@@ -0,0 +1,159 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		s    string
+		want IntervalValue
+		text string
+	}{
+		{"1 day", IntervalValue{Duration: 24 * time.Hour}, "1 day"},
+		{"2 hours 30 minutes", IntervalValue{Duration: 150 * time.Minute}, "02:30:00"},
+		{"1 year 2 mons 03:04:05", IntervalValue{Months: 14, Duration: 3*time.Hour + 4*time.Minute + 5*time.Second}, "1 year 2 mons 03:04:05"},
+		{"3 days ago", IntervalValue{Duration: -72 * time.Hour}, "-3 days"},
+		{"1.5 seconds", IntervalValue{Duration: 1500 * time.Millisecond}, "00:00:01.5"},
+		{"-01:30", IntervalValue{Duration: -90 * time.Minute}, "-01:30:00"},
+		{"2 Weeks 1 ms", IntervalValue{Duration: 14*24*time.Hour + time.Millisecond}, "14 days 00:00:00.001"},
+		{"0 days", IntervalValue{}, "00:00:00"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseInterval(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseInterval(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+			continue
+		}
+		if got.String() != tt.text {
+			t.Errorf("%q formatted as %q, want %q", tt.s, got.String(), tt.text)
+		}
+
+		// Intervals cast to text read back the same
+		again, err := ParseInterval(got.String())
+		if err != nil || again != got {
+			t.Errorf("%q read back as %v, %v", got.String(), again, err)
+		}
+	}
+
+	for _, s := range []string{"", "day", "1", "1 fortnight", "1:2:3:4", "ago", "nan hours", "inf days", "-infinity months", "NaN years"} {
+		if _, err := ParseInterval(s); !errors.Is(err, ErrInvalidCast) {
+			t.Errorf("ParseInterval(%q) = %v, want %v", s, err, ErrInvalidCast)
+		}
+	}
+
+	for _, s := range []string{
+		"1e300 hours",
+		"1e300 months",
+		"-1e300 years",
+		"9223372036854775807 months",
+		"768614336404564650 years",
+		"106751 days 106751 days",
+		"9223372036854775807 months 1 month",
+		"4000000000:00",
+		"00:00:1e300",
+		"-9223372036854775808 months ago",
+	} {
+		if _, err := ParseInterval(s); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("ParseInterval(%q) = %v, want %v", s, err, ErrOutOfRange)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		s    string
+		want time.Time
+	}{
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-03-01 12:30", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{" 2024-03-01 12:30:15.5 ", time.Date(2024, 3, 1, 12, 30, 15, 500000000, time.UTC)},
+		{"2024-03-01T12:30:15Z", time.Date(2024, 3, 1, 12, 30, 15, 0, time.UTC)},
+		{"2024-03-01T12:30:15+02:00", time.Date(2024, 3, 1, 10, 30, 15, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTimestamp(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "yesterday", "2024-13-01", "2024-02-30"} {
+		if _, err := ParseTimestamp(s); !errors.Is(err, ErrInvalidCast) {
+			t.Errorf("ParseTimestamp(%q) = %v, want %v", s, err, ErrInvalidCast)
+		}
+	}
+
+	if got := FormatTimestamp(time.Date(2024, 3, 1, 12, 30, 15, 123456789, time.UTC)); got != "2024-03-01 12:30:15.123456" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestApplyTemporal(t *testing.T) {
+	day := func(s string) time.Time {
+		t.Helper()
+
+		ts, err := ParseTimestamp(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	month := IntervalValue{Months: 1}
+	hours := IntervalValue{Duration: 36 * time.Hour}
+
+	tests := []struct {
+		op   string
+		l, r interface{}
+		want interface{}
+		err  error
+	}{
+		// Months added to the end of a month end at the end of the next one
+		{"+", day("2024-01-31"), month, day("2024-02-29"), nil},
+		{"+", day("2023-01-31 10:00"), month, day("2023-02-28 10:00"), nil},
+		{"+", month, day("2024-03-15"), day("2024-04-15"), nil},
+		{"-", day("2024-03-31"), month, day("2024-02-29"), nil},
+		{"+", day("2024-03-01"), hours, day("2024-03-02 12:00"), nil},
+		{"+", day("2024-12-31"), IntervalValue{Months: 2}, day("2025-02-28"), nil},
+		{"-", day("2024-03-02"), day("2024-03-01"), IntervalValue{Duration: 24 * time.Hour}, nil},
+		{"+", month, hours, IntervalValue{Months: 1, Duration: 36 * time.Hour}, nil},
+		{"-", hours, month, IntervalValue{Months: -1, Duration: 36 * time.Hour}, nil},
+		{"*", hours, int64(2), IntervalValue{Duration: 72 * time.Hour}, nil},
+		{"*", 0.5, hours, IntervalValue{Duration: 18 * time.Hour}, nil},
+		{"/", IntervalValue{Months: 3, Duration: time.Hour}, 2.0, IntervalValue{Months: 2, Duration: 30 * time.Minute}, nil},
+		{"/", hours, int64(0), nil, ErrDivisionByZero},
+		{"*", hours, 1e300, nil, ErrOutOfRange},
+		{"*", month, 1e300, nil, ErrOutOfRange},
+		{"+", IntervalValue{Months: math.MaxInt64}, month, nil, ErrOutOfRange},
+		{"-", IntervalValue{Months: math.MinInt64}, month, nil, ErrOutOfRange},
+		{"+", IntervalValue{Duration: math.MaxInt64}, hours, nil, ErrOutOfRange},
+		{"-", hours, IntervalValue{Duration: math.MinInt64}, nil, ErrOutOfRange},
+		// Text meeting a timestamp is one
+		{"<", day("2024-03-01"), "2024-03-02", true, nil},
+		{"=", "2024-03-01", day("2024-03-01"), true, nil},
+		// A month compares as 30 days
+		{">", month, IntervalValue{Duration: 29 * 24 * time.Hour}, true, nil},
+		{"=", month, IntervalValue{Duration: 30 * 24 * time.Hour}, true, nil},
+		{"+", day("2024-03-01"), day("2024-03-01"), nil, ErrInvalidOperands},
+		{"-", month, day("2024-03-01"), nil, ErrInvalidOperands},
+		{"*", day("2024-03-01"), int64(2), nil, ErrInvalidOperands},
+	}
+
+	for _, tt := range tests {
+		got, err := Apply(tt.op, tt.l, tt.r)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Apply(%q, %v, %v) = %v, %v, want %v", tt.op, tt.l, tt.r, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Apply(%q, %v, %v) = %v, %v, want %v", tt.op, tt.l, tt.r, got, err, tt.want)
+		}
+	}
+}
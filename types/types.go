@@ -0,0 +1,114 @@
+// Package types defines the SQL types values can have and the rules for
+// converting between them. Both the analyzer and the evaluator use it, so
+// an expression the analyzer accepts evaluates the way it was typed.
+//
+// Values are represented as nil for NULL, or an int64, float64, string,
+// bool, time.Time in UTC for timestamps, IntervalValue for intervals,
+// UUIDValue for uuids or Array for arrays.
+package types
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Type is the type of values stored in a column or produced by an
+// expression.
+type Type uint
+
+const (
+	Text Type = iota
+	Int
+	Bool
+	Float
+	Timestamp
+	Interval
+	UUID
+)
+
+func (t Type) String() string {
+	if t.IsArray() {
+		return t.Elem().String() + "[]"
+	}
+
+	switch t {
+	case Text:
+		return "text"
+	case Int:
+		return "int"
+	case Bool:
+		return "bool"
+	case Float:
+		return "float"
+	case Timestamp:
+		return "timestamp"
+	case Interval:
+		return "interval"
+	case UUID:
+		return "uuid"
+	}
+
+	return "unknown"
+}
+
+var (
+	ErrInvalidOperands = errors.New("Operands are invalid for operator")
+	ErrDivisionByZero  = errors.New("Division by zero")
+	ErrOutOfRange      = errors.New("Value out of range")
+	ErrInvalidCast     = errors.New("Invalid cast")
+)
+
+// names maps every accepted spelling of a type name to its type.
+var names = map[string]Type{
+	"text":        Text,
+	"varchar":     Text,
+	"int":         Int,
+	"integer":     Int,
+	"bigint":      Int,
+	"bool":        Bool,
+	"boolean":     Bool,
+	"float":       Float,
+	"double":      Float,
+	"real":        Float,
+	"timestamp":   Timestamp,
+	"timestamptz": Timestamp,
+	"interval":    Interval,
+	"uuid":        UUID,
+}
+
+// Parse returns the type called name.
+func Parse(name string) (Type, bool) {
+	name = strings.ToLower(name)
+	if elem := strings.TrimSuffix(name, "[]"); elem != name {
+		t, ok := names[elem]
+		return ArrayOf(t), ok
+	}
+
+	t, ok := names[name]
+	return t, ok
+}
+
+// Of returns the type of a non-NULL value.
+func Of(v interface{}) (Type, bool) {
+	switch v := v.(type) {
+	case int64:
+		return Int, true
+	case float64:
+		return Float, true
+	case string:
+		return Text, true
+	case bool:
+		return Bool, true
+	case time.Time:
+		return Timestamp, true
+	case IntervalValue:
+		return Interval, true
+	case UUIDValue:
+		return UUID, true
+	case Array:
+		return ArrayOf(v.Elem), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,151 @@
+package types
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// UUIDValue is a 128-bit identifier. It is kept as its 16 bytes rather than
+// its text, and compares byte by byte, so identifiers that start with the
+// time they were made in, like ULIDs, sort in the order they were made.
+type UUIDValue [16]byte
+
+// String formats u the usual way, as 32 lowercase hex digits in groups of
+// 8-4-4-4-12.
+func (u UUIDValue) String() string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+func (u UUIDValue) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *UUIDValue) UnmarshalText(b []byte) error {
+	v, err := ParseUUID(string(b))
+	if err != nil {
+		return err
+	}
+
+	*u = v
+	return nil
+}
+
+// crockford is the alphabet ULIDs are written in.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID formats u as a ULID, 26 characters of Crockford's base32 that sort
+// the same way u does.
+func (u UUIDValue) ULID() string {
+	var b [26]byte
+	// 130 bits are written, the first two are always zero
+	for i := 25; i >= 0; i-- {
+		bit := (25 - i) * 5
+		var v byte
+		for j := 0; j < 5 && bit+j < 128; j++ {
+			if u[15-(bit+j)/8]>>((bit+j)%8)&1 == 1 {
+				v |= 1 << j
+			}
+		}
+		b[i] = crockford[v]
+	}
+
+	return string(b[:])
+}
+
+// ParseUUID parses a UUID written as 32 hex digits, with or without the
+// hyphens and braces around them, or as a ULID.
+func ParseUUID(s string) (UUIDValue, error) {
+	var u UUIDValue
+	invalid := fmt.Errorf("%w: %q is not a uuid", ErrInvalidCast, s)
+
+	trimmed := strings.TrimSpace(s)
+	if len(trimmed) == 26 {
+		return parseULID(trimmed, invalid)
+	}
+
+	if strings.HasPrefix(trimmed, "{") && strings.HasSuffix(trimmed, "}") {
+		trimmed = trimmed[1 : len(trimmed)-1]
+	}
+	if len(trimmed) == 36 {
+		for _, i := range []int{8, 13, 18, 23} {
+			if trimmed[i] != '-' {
+				return u, invalid
+			}
+		}
+		trimmed = strings.ReplaceAll(trimmed, "-", "")
+	}
+
+	if len(trimmed) != 32 {
+		return u, invalid
+	}
+	if _, err := hex.Decode(u[:], []byte(trimmed)); err != nil {
+		return u, invalid
+	}
+
+	return u, nil
+}
+
+func parseULID(s string, invalid error) (UUIDValue, error) {
+	var u UUIDValue
+	// The first character only holds 3 bits
+	if strings.IndexByte("01234567", s[0]) < 0 {
+		return u, invalid
+	}
+
+	for i := 0; i < 26; i++ {
+		v := strings.IndexByte(crockford, upper(s[i]))
+		if v < 0 {
+			return u, invalid
+		}
+
+		bit := (25 - i) * 5
+		for j := 0; j < 5 && bit+j < 128; j++ {
+			if v>>j&1 == 1 {
+				u[15-(bit+j)/8] |= 1 << ((bit + j) % 8)
+			}
+		}
+	}
+
+	return u, nil
+}
+
+func upper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+
+	return c
+}
+
+func compareUUIDs(op string, l, r UUIDValue) (interface{}, error) {
+	c := bytes.Compare(l[:], r[:])
+
+	switch op {
+	case "=":
+		return c == 0, nil
+	case "<>", "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v %s %v", ErrInvalidOperands, l, op, r)
+}
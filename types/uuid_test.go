@@ -0,0 +1,101 @@
+package types
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseUUID(t *testing.T) {
+	want := UUIDValue{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+
+	tests := []struct {
+		s  string
+		ok bool
+	}{
+		{"123e4567-e89b-12d3-a456-426614174000", true},
+		{"123E4567-E89B-12D3-A456-426614174000", true},
+		{"{123e4567-e89b-12d3-a456-426614174000}", true},
+		{"123e4567e89b12d3a456426614174000", true},
+		{"  123e4567-e89b-12d3-a456-426614174000 ", true},
+		{want.ULID(), true},
+		{"123e4567-e89b-12d3-a456-42661417400", false},
+		{"123e4567_e89b_12d3_a456_426614174000", false},
+		{"123e4567-e89b-12d3-a456-42661417400g", false},
+		{"{123e4567e89b12d3a456426614174000", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseUUID(tt.s)
+		if !tt.ok {
+			if !errors.Is(err, ErrInvalidCast) {
+				t.Errorf("ParseUUID(%q): got %v, %v, want %v", tt.s, got, err, ErrInvalidCast)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("ParseUUID(%q) = %v, %v, want %v", tt.s, got, err, want)
+		}
+	}
+
+	if s := want.String(); s != "123e4567-e89b-12d3-a456-426614174000" {
+		t.Errorf("formatted as %q", s)
+	}
+}
+
+func TestULID(t *testing.T) {
+	tests := []struct {
+		u    UUIDValue
+		ulid string
+	}{
+		{UUIDValue{}, "00000000000000000000000000"},
+		{UUIDValue{15: 1}, "00000000000000000000000001"},
+		{UUIDValue{15: 32}, "00000000000000000000000010"},
+		{UUIDValue{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"},
+		{UUIDValue{0x01, 0x8f, 0x3a, 0x10, 0x00, 0x00}, "01HWX100000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.u.ULID(); got != tt.ulid {
+			t.Errorf("%v: got ULID %q, want %q", tt.u, got, tt.ulid)
+		}
+		if back, err := ParseUUID(tt.ulid); err != nil || back != tt.u {
+			t.Errorf("%q: parsed back as %v, %v, want %v", tt.ulid, back, err, tt.u)
+		}
+	}
+
+	// Lowercase is read the same, and the first character only has 3 bits
+	if u, err := ParseUUID("7zzzzzzzzzzzzzzzzzzzzzzzzz"); err != nil || u != tests[3].u {
+		t.Errorf("lowercase ULID parsed as %v, %v", u, err)
+	}
+	if _, err := ParseUUID("8ZZZZZZZZZZZZZZZZZZZZZZZZZ"); !errors.Is(err, ErrInvalidCast) {
+		t.Errorf("a ULID over 128 bits: got %v, want %v", err, ErrInvalidCast)
+	}
+}
+
+func TestCompareUUIDs(t *testing.T) {
+	low, high := UUIDValue{0x01}, UUIDValue{0x01, 15: 0x01}
+
+	tests := []struct {
+		op   string
+		want bool
+	}{
+		{"=", false},
+		{"<>", true},
+		{"!=", true},
+		{"<", true},
+		{"<=", true},
+		{">", false},
+		{">=", false},
+	}
+
+	for _, tt := range tests {
+		if got, err := compareUUIDs(tt.op, low, high); err != nil || got != tt.want {
+			t.Errorf("%v %s %v = %v, %v, want %v", low, tt.op, high, got, err, tt.want)
+		}
+	}
+
+	if _, err := compareUUIDs("+", low, high); !errors.Is(err, ErrInvalidOperands) {
+		t.Errorf("adding uuids: got %v, want %v", err, ErrInvalidOperands)
+	}
+}
@@ -0,0 +1,204 @@
+package sgsql
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/nireo/sgsql/backend"
+	"github.com/nireo/sgsql/logging"
+	"github.com/nireo/sgsql/storage"
+)
+
+var ErrVacuumInTx = errors.New("VACUUM can't run inside a transaction block")
+
+// minAutoVacuumSize is how large the statement log has to be before it is
+// vacuumed automatically, small logs aren't worth rewriting.
+const minAutoVacuumSize = 1 << 20
+
+// Vacuum rewrites the statement log to hold only what is needed to rebuild
+// the database as it is now, and returns how many bytes that reclaimed. The
+// new log replaces the old one only once it is completely written, so a
+// failed vacuum leaves the old log in place. A storage engine keeping the
+// rows of the tables in a file is checkpointed, and the new log only
+// recreates the tables around the rows it keeps.
+func (db *DB) Vacuum() (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.vacuum()
+}
+
+// SetAutoVacuum makes the database vacuum itself in the background once the
+// statement log has grown to more than factor times its size after it was
+// opened or last vacuumed. A factor of zero turns it off.
+func (db *DB) SetAutoVacuum(factor float64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.autoVacuum = factor
+}
+
+func (db *DB) vacuum() (int64, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	if db.log == nil {
+		return 0, nil
+	}
+
+	entries, err := db.dump()
+	if err != nil {
+		return 0, err
+	}
+
+	before := db.logSize
+	size, err := db.rewriteLog(func(w io.Writer) error {
+		for i, entry := range entries {
+			if err := writeEntry(w, entry, i+1, db.cipher); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	db.vacuumedSize, db.entries = size, len(entries)
+	db.backend.Vacuumed(time.Now().UTC())
+	db.logEvent(context.Background(), logging.LevelInfo, "Vacuumed the statement log",
+		"reclaimed_bytes", before-size, "size", size)
+	return before - size, nil
+}
+
+// dump returns the entries of a statement log rebuilding the database as it
+// is now. With an engine keeping the rows of the tables, the engine is
+// checkpointed and the log starts from the checkpoint instead of inserting
+// the rows. The old log keeps working from an earlier checkpoint until the
+// new one replaces it.
+func (db *DB) dump() ([]logEntry, error) {
+	var stmts []backend.DumpStatement
+	entries := []logEntry{}
+	if c, ok := db.engine.(storage.Checkpointer); ok {
+		var names []string
+		stmts, names = db.backend.DumpSchema()
+
+		id, err := newCheckpointID()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Checkpoint(id, names); err != nil {
+			return nil, err
+		}
+		entries = append(entries, logEntry{Checkpoint: id})
+	} else {
+		var err error
+		if stmts, err = db.backend.Dump(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, stmt := range stmts {
+		entries = append(entries, logEntry{Query: stmt.Query, Params: stmt.Params})
+	}
+	return entries, nil
+}
+
+// newCheckpointID returns a random id for a checkpoint of the storage
+// engine.
+func newCheckpointID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b[:]), nil
+}
+
+// openLog opens the statement log a second time for reading it from the
+// start.
+func (db *DB) openLog() (*os.File, error) {
+	return os.Open(db.path)
+}
+
+// rewriteLog replaces the statement log with a new one holding what write
+// writes to it and returns its size. The new log replaces the old one only
+// once it is completely written and synced, so a failure leaves the old log
+// in place.
+func (db *DB) rewriteLog(write func(w io.Writer) error) (int64, error) {
+	tmp := db.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	var size int64
+	if err == nil {
+		size, err = f.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		err = os.Rename(tmp, db.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	// The rename only survives a crash once the directory is synced
+	if dir, err := os.Open(filepath.Dir(db.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	// Everything written to the old log is in the new one, which is synced
+	db.syncMu.Lock()
+	db.syncFile, db.synced, db.syncErr = f, db.written, nil
+	db.syncMu.Unlock()
+
+	db.log.Close()
+	db.log, db.logSize = f, size
+	return size, nil
+}
+
+// maybeAutoVacuum starts vacuuming in the background if the statement log
+// has grown enough since it was last vacuumed. It must be called with db.mu
+// held, which the vacuum waits for.
+func (db *DB) maybeAutoVacuum() {
+	if db.autoVacuum <= 0 || db.logSize < minAutoVacuumSize ||
+		float64(db.logSize) <= db.autoVacuum*float64(db.vacuumedSize) {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&db.vacuuming, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&db.vacuuming, 0)
+
+		// A failed vacuum leaves the log as it was, to be tried again
+		// after a later commit
+		if _, err := db.Vacuum(); err != nil {
+			db.logEvent(context.Background(), logging.LevelError, "Automatic vacuum failed", "error", err)
+		}
+	}()
+}